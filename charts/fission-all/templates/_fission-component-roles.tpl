@@ -4,8 +4,10 @@ rules:
   - fission.io
   resources:
   - environments
+  - environments/status
   - functions
   - packages
+  - packages/status
   verbs:
   - create
   - get
@@ -14,6 +16,17 @@ rules:
   - update
   - patch
   - delete
+- apiGroups:
+  - fission.io
+  resources:
+  - httptriggers
+  - kuberneteswatchtriggers
+  - messagequeuetriggers
+  - timetriggers
+  verbs:
+  - get
+  - list
+  - watch
 {{- end }}
 {{- define "controller-rules" }}
 rules:
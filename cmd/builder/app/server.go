@@ -19,10 +19,12 @@ package app
 import (
 	"context"
 	"net/http"
+	"os"
 
 	"go.uber.org/zap"
 
 	builder "github.com/fission/fission/pkg/builder"
+	"github.com/fission/fission/pkg/utils/authtoken"
 	"github.com/fission/fission/pkg/utils/httpserver"
 )
 
@@ -32,8 +34,12 @@ func Run(ctx context.Context, logger *zap.Logger, shareVolume string) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", builder.Handler)
 	mux.HandleFunc("/version", builder.VersionHandler)
+	mux.HandleFunc("/status", builder.StatusHandler)
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	httpserver.StartServer(ctx, logger, "builder", "8001", mux)
+	go builder.RunJanitor(ctx)
+	// the builder manager sends the token of the builder deployment env
+	handler := authtoken.Handler(os.Getenv(authtoken.EnvBuilderAuthToken), mux, "/healthz", "/version")
+	httpserver.StartServer(ctx, logger, "builder", "8001", handler)
 }
@@ -29,6 +29,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/utils/authtoken"
 	"github.com/fission/fission/pkg/utils/httpserver"
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
@@ -118,7 +119,10 @@ func Run(ctx context.Context, logger *zap.Logger) {
 
 	logger.Info("fetcher ready to receive requests")
 
-	handler := otelUtils.GetHandlerWithOTEL(mux, "fission-fetcher", otelUtils.UrlsToIgnore("/healthz", "/readiness-healthz"))
+	// only the fetchers of builder pods get a token, function pods don't
+	// enforce it
+	handler := authtoken.Handler(os.Getenv(authtoken.EnvBuilderAuthToken), mux, "/healthz", "/readiness-healthz", "/version")
+	handler = otelUtils.GetHandlerWithOTEL(handler, "fission-fetcher", otelUtils.UrlsToIgnore("/healthz", "/readiness-healthz"))
 	httpserver.StartServer(ctx, logger, "fetcher", "8000", handler)
 }
 
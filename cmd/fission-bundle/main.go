@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
 	"go.uber.org/zap"
@@ -87,8 +88,16 @@ func runStorageSvc(ctx context.Context, logger *zap.Logger, port int, storage st
 	return storagesvc.Start(ctx, logger, storage, port)
 }
 
-func runBuilderMgr(ctx context.Context, logger *zap.Logger, storageSvcUrl string) error {
-	return buildermgr.Start(ctx, logger, storageSvcUrl)
+func runBuilderMgr(ctx context.Context, logger *zap.Logger, storageSvcUrl string, maxConcurrentBuilds int, maxBuildRetries int,
+	buildTimeout time.Duration, maxBuildLogSize int, apiPort int, queueLedger string, leaderElectionLease string,
+	shutdownGracePeriod time.Duration, envBuildParallelism int, maxNamespaceBuilds int, builderBackoff buildermgr.BuilderBackoff,
+	buildNotificationURL string, rebuildCooldown time.Duration, gcDeploymentArchives bool, skipArchiveVerification bool,
+	uploadRetries int, maxSourceArchiveSize int64, maxDeploymentArchiveSize int64, builderAuthSecret string,
+	buildMetadataPrefix string, forceArchiveUpload bool, deleteSourceAfterBuild bool, builderDrainGracePeriod time.Duration) error {
+	return buildermgr.Start(ctx, logger, storageSvcUrl, maxConcurrentBuilds, maxBuildRetries, buildTimeout, maxBuildLogSize, apiPort, queueLedger, leaderElectionLease,
+		shutdownGracePeriod, envBuildParallelism, maxNamespaceBuilds, builderBackoff, buildNotificationURL, rebuildCooldown, gcDeploymentArchives,
+		skipArchiveVerification, uploadRetries, maxSourceArchiveSize, maxDeploymentArchiveSize, builderAuthSecret,
+		buildMetadataPrefix, forceArchiveUpload, deleteSourceAfterBuild, builderDrainGracePeriod)
 }
 
 func runLogger(ctx context.Context, logger *zap.Logger) {
@@ -104,6 +113,30 @@ func getPort(logger *zap.Logger, portArg interface{}) int {
 	return port
 }
 
+func getIntArgWithDefault(logger *zap.Logger, arg interface{}, defaultValue int) int {
+	if arg == nil {
+		return defaultValue
+	}
+	argStr := arg.(string)
+	value, err := strconv.Atoi(argStr)
+	if err != nil {
+		logger.Fatal("invalid integer argument", zap.Error(err), zap.String("value", argStr))
+	}
+	return value
+}
+
+func getFloatArgWithDefault(logger *zap.Logger, arg interface{}, defaultValue float64) float64 {
+	if arg == nil {
+		return defaultValue
+	}
+	argStr := arg.(string)
+	value, err := strconv.ParseFloat(argStr, 64)
+	if err != nil {
+		logger.Fatal("invalid number argument", zap.Error(err), zap.String("value", argStr))
+	}
+	return value
+}
+
 func getStringArgWithDefault(arg interface{}, defaultValue string) string {
 	if arg != nil {
 		return arg.(string)
@@ -183,7 +216,7 @@ Usage:
   fission-bundle --executorPort=<port> [--namespace=<namespace>] [--fission-namespace=<namespace>]
   fission-bundle --kubewatcher [--routerUrl=<url>]
   fission-bundle --storageServicePort=<port> --storageType=<storateType>
-  fission-bundle --builderMgr [--storageSvcUrl=<url>] [--envbuilder-namespace=<namespace>]
+  fission-bundle --builderMgr [--storageSvcUrl=<url>] [--envbuilder-namespace=<namespace>] [--max-concurrent-builds=<num>] [--max-build-retries=<num>] [--build-timeout=<seconds>] [--max-build-log-size=<kb>] [--api-port=<port>] [--build-queue-ledger=<configmap>] [--leader-election-lease=<lease>] [--build-shutdown-grace-period=<seconds>] [--env-build-parallelism=<num>] [--max-namespace-builds=<num>] [--builder-wait-initial-interval=<ms>] [--builder-wait-max-interval=<seconds>] [--builder-wait-multiplier=<num>] [--builder-wait-max-time=<seconds>] [--build-notification-url=<url>] [--rebuild-cooldown=<seconds>] [--gc-deployment-archives] [--skip-archive-verification] [--upload-retries=<num>] [--max-source-archive-size=<mb>] [--max-deployment-archive-size=<mb>] [--builder-auth-secret=<secret>] [--build-metadata-prefix=<prefix>] [--force-archive-upload] [--delete-source-after-build] [--builder-drain-grace-period=<seconds>]
   fission-bundle --timer [--routerUrl=<url>]
   fission-bundle --mqt   [--routerUrl=<url>]
   fission-bundle --mqt_keda [--routerUrl=<url>]
@@ -208,6 +241,32 @@ Options:
   --mqt                           Start message queue trigger.
   --mqt_keda					  Start message queue trigger of kind KEDA
   --builderMgr                    Start builder manager.
+  --max-concurrent-builds=<num>   Maximum number of package builds the builder manager runs at once, 0 means no limit.
+  --max-build-retries=<num>       Number of times the builder manager retries a failed package build. Defaults to 3.
+  --build-timeout=<seconds>       Default deadline of package builds, 0 means no deadline. Defaults to 1800.
+  --max-build-log-size=<kb>       Maximum size in kilobytes of the build logs stored in package status, 0 means no limit. Defaults to 256.
+  --api-port=<port>               Port the builder manager API listens on. Defaults to 8000.
+  --build-queue-ledger=<configmap>  Config map persisting the build queue across builder manager restarts, empty disables it.
+  --leader-election-lease=<lease>  Lease electing the builder manager replica running the package builds, empty disables the election.
+  --build-shutdown-grace-period=<seconds>  Time running package builds get to finish on builder manager shutdown, unfinished ones are built again by the next builder manager. Defaults to 20.
+  --env-build-parallelism=<num>   Maximum number of package builds of an environment the builder manager runs at once, 0 means no limit.
+  --max-namespace-builds=<num>    Maximum number of package builds of a namespace the builder manager runs at once, 0 means no limit.
+  --builder-wait-initial-interval=<ms>    First interval between the health checks of the builder pod of a build, in milliseconds.
+  --builder-wait-max-interval=<seconds>   Interval between the builder pod health checks after which a build stops waiting for the builder pod.
+  --builder-wait-multiplier=<num>         Factor by which the interval between the builder pod health checks grows.
+  --builder-wait-max-time=<seconds>       Maximum time a build waits for its builder pod, 0 means no limit.
+  --build-notification-url=<url>          HTTPS URL receiving a JSON POST on every package build completion.
+  --rebuild-cooldown=<seconds>            Time after a finished package build during which rebuilds of the same source are delayed, 0 disables it. Defaults to 30.
+  --gc-deployment-archives                Delete the deployment archive superseded by a successful rebuild from the storage service, unless another package references it.
+  --skip-archive-verification             Only check that uploaded deployment archives are downloadable, for storage backends that can't serve their checksum.
+  --upload-retries=<num>                  Number of times the upload of a deployment archive is retried while the storage service is unavailable, 0 disables retries. Defaults to 3.
+  --max-source-archive-size=<mb>          Maximum size in megabytes of the source archive of a build, checked before the build is dispatched. Environments override it with the "fission.io/max-source-archive-size" annotation. 0 means no limit.
+  --max-deployment-archive-size=<mb>      Maximum size in megabytes of the deployment archive of a build, checked before the upload. Environments override it with the "fission.io/max-deployment-archive-size" annotation. 0 means no limit.
+  --builder-auth-secret=<secret>          Secret of the builder manager namespace holding the token of the requests to the builder pods, created with a random token unless it exists. Unset sends the requests without token.
+  --build-metadata-prefix=<prefix>        Prefix of the package labels and annotations passed to the build command as FISSION_BUILD_META_<NAME> environment variables, named by the rest of the key. Defaults to "build.fission.io/", "" passes none.
+  --force-archive-upload                  Upload the deployment archive of every build, even if it is identical to the current one of the package.
+  --delete-source-after-build             Delete the source archives of the packages from the storage service once built, unless failed builds of the package are retried or its environment rebuilds its packages on builder image changes. Packages override it with the fission.io/delete-source-after-build annotation.
+  --builder-drain-grace-period=<seconds>  Time the builds in flight on a builder replaced by an environment update get to finish before it is deleted, no new builds are sent to it meanwhile. Defaults to 600.
   --version                       Print version information
 `
 	logger := loggerfactory.GetLogger()
@@ -307,7 +366,39 @@ Options:
 	}
 
 	if arguments["--builderMgr"] == true {
-		err = runBuilderMgr(ctx, logger, storageSvcUrl)
+		maxConcurrentBuilds := getIntArgWithDefault(logger, arguments["--max-concurrent-builds"], 0)
+		maxBuildRetries := getIntArgWithDefault(logger, arguments["--max-build-retries"], 3)
+		buildTimeout := getIntArgWithDefault(logger, arguments["--build-timeout"], 1800)
+		maxBuildLogSize := getIntArgWithDefault(logger, arguments["--max-build-log-size"], 256)
+		apiPort := getIntArgWithDefault(logger, arguments["--api-port"], 8000)
+		queueLedger := getStringArgWithDefault(arguments["--build-queue-ledger"], "")
+		leaderElectionLease := getStringArgWithDefault(arguments["--leader-election-lease"], "")
+		shutdownGracePeriod := getIntArgWithDefault(logger, arguments["--build-shutdown-grace-period"], 20)
+		envBuildParallelism := getIntArgWithDefault(logger, arguments["--env-build-parallelism"], 0)
+		maxNamespaceBuilds := getIntArgWithDefault(logger, arguments["--max-namespace-builds"], 0)
+		builderBackoff := buildermgr.DefaultBuilderBackoff()
+		builderBackoff.InitialInterval = time.Duration(getIntArgWithDefault(logger, arguments["--builder-wait-initial-interval"],
+			int(builderBackoff.InitialInterval/time.Millisecond))) * time.Millisecond
+		builderBackoff.MaxInterval = time.Duration(getIntArgWithDefault(logger, arguments["--builder-wait-max-interval"],
+			int(builderBackoff.MaxInterval/time.Second))) * time.Second
+		builderBackoff.Multiplier = getFloatArgWithDefault(logger, arguments["--builder-wait-multiplier"], builderBackoff.Multiplier)
+		builderBackoff.MaxWait = time.Duration(getIntArgWithDefault(logger, arguments["--builder-wait-max-time"], 0)) * time.Second
+		buildNotificationURL := getStringArgWithDefault(arguments["--build-notification-url"], "")
+		rebuildCooldown := getIntArgWithDefault(logger, arguments["--rebuild-cooldown"], 30)
+		uploadRetries := getIntArgWithDefault(logger, arguments["--upload-retries"], 3)
+		maxSourceArchiveSize := getIntArgWithDefault(logger, arguments["--max-source-archive-size"], 0)
+		maxDeploymentArchiveSize := getIntArgWithDefault(logger, arguments["--max-deployment-archive-size"], 0)
+		builderAuthSecret := getStringArgWithDefault(arguments["--builder-auth-secret"], "")
+		buildMetadataPrefix := getStringArgWithDefault(arguments["--build-metadata-prefix"], buildermgr.DefaultBuildMetadataPrefix)
+		builderDrainGracePeriod := getIntArgWithDefault(logger, arguments["--builder-drain-grace-period"], 600)
+		err = runBuilderMgr(ctx, logger, storageSvcUrl, maxConcurrentBuilds, maxBuildRetries,
+			time.Duration(buildTimeout)*time.Second, maxBuildLogSize*1024, apiPort, queueLedger, leaderElectionLease,
+			time.Duration(shutdownGracePeriod)*time.Second, envBuildParallelism, maxNamespaceBuilds, builderBackoff,
+			buildNotificationURL, time.Duration(rebuildCooldown)*time.Second, arguments["--gc-deployment-archives"] == true,
+			arguments["--skip-archive-verification"] == true, uploadRetries,
+			int64(maxSourceArchiveSize)<<20, int64(maxDeploymentArchiveSize)<<20, builderAuthSecret, buildMetadataPrefix,
+			arguments["--force-archive-upload"] == true, arguments["--delete-source-after-build"] == true,
+			time.Duration(builderDrainGracePeriod)*time.Second)
 		if err != nil {
 			logger.Error("builder manager exited", zap.Error(err))
 			return
@@ -41,6 +41,15 @@ const (
 
 	// ArchiveTypeUrl means the package contents are at the specified URL.
 	ArchiveTypeUrl ArchiveType = "url"
+
+	// ArchiveTypeGit means the package contents are cloned from the git
+	// repository of the Git field.
+	ArchiveTypeGit ArchiveType = "git"
+)
+
+const (
+	// ArchiveEncodingGzip means the archive is stored gzip compressed.
+	ArchiveEncodingGzip ArchiveEncoding = "gzip"
 )
 
 const (
@@ -48,9 +57,138 @@ const (
 	BuildStatusRunning   = "running"
 	BuildStatusSucceeded = "succeeded"
 	BuildStatusFailed    = "failed"
+	BuildStatusCanceled  = "canceled"
 	BuildStatusNone      = "none"
 )
 
+const (
+	BuildPhaseWaitingForBuilder BuildPhase = "WaitingForBuilder"
+	BuildPhaseBuilding          BuildPhase = "Building"
+	BuildPhaseUploading         BuildPhase = "Uploading"
+	BuildPhaseUpdatingFunctions BuildPhase = "UpdatingFunctions"
+)
+
+const (
+	// BuildTriggerPackageCreated builds a package created with a source archive.
+	BuildTriggerPackageCreated BuildTrigger = "PackageCreated"
+	// BuildTriggerSpecChanged rebuilds a package whose build inputs changed.
+	BuildTriggerSpecChanged BuildTrigger = "SpecChanged"
+	// BuildTriggerRebuildAnnotation rebuilds a package annotated with
+	// ANNOTATION_REBUILD.
+	BuildTriggerRebuildAnnotation BuildTrigger = "RebuildAnnotation"
+	// BuildTriggerBuilderImageChanged rebuilds the packages of an environment
+	// whose builder image changed.
+	BuildTriggerBuilderImageChanged BuildTrigger = "BuilderImageChanged"
+	// BuildTriggerReconcile rebuilds a package whose build was abandoned.
+	BuildTriggerReconcile BuildTrigger = "Reconcile"
+	// BuildTriggerManualRebuild rebuilds a package on user request, e.g.
+	// with "fission package rebuild".
+	BuildTriggerManualRebuild BuildTrigger = "ManualRebuild"
+	// BuildTriggerBulkRebuild rebuilds the packages of a bulk rebuild, e.g.
+	// with "fission environment rebuild-packages".
+	BuildTriggerBulkRebuild BuildTrigger = "BulkRebuild"
+	// BuildTriggerUnknown is the trigger of pending packages not telling
+	// theirs, e.g. marked pending by older clients.
+	BuildTriggerUnknown BuildTrigger = "Unknown"
+)
+
+// Types of the package status conditions
+const (
+	PackageConditionBuilderReady     = "BuilderReady"
+	PackageConditionBuildSucceeded   = "BuildSucceeded"
+	PackageConditionFunctionsUpdated = "FunctionsUpdated"
+	// PackageConditionDryRunSucceeded tells whether the package would
+	// build, it is set by the dry-run builds only.
+	PackageConditionDryRunSucceeded = "DryRunSucceeded"
+)
+
+// Reasons of the package status conditions
+const (
+	PackageReasonBuildPending          = "BuildPending"
+	PackageReasonBuildRunning          = "BuildRunning"
+	PackageReasonBuildRetrying         = "BuildRetrying"
+	PackageReasonBuildSucceeded        = "BuildSucceeded"
+	PackageReasonBuildFailed           = "BuildFailed"
+	PackageReasonBuildTimeout          = "BuildTimeout"
+	PackageReasonBuildCanceled         = "BuildCanceled"
+	PackageReasonBuildSkipped          = "BuildSkipped"
+	PackageReasonArtifactUnavailable   = "ArtifactUnavailable"
+	PackageReasonSourceFetchFailed     = "SourceFetchFailed"
+	PackageReasonEnvironmentNotFound   = "EnvironmentNotFound"
+	PackageReasonWaitingForBuilder     = "WaitingForBuilder"
+	PackageReasonBuilderPodReady       = "BuilderPodReady"
+	PackageReasonBuilderNotReady       = "BuilderNotReady"
+	PackageReasonFunctionsUpdated      = "FunctionsUpdated"
+	PackageReasonFunctionUpdateFailed  = "FunctionUpdateFailed"
+	PackageReasonFunctionUpdateSkipped = "FunctionUpdateSkipped"
+
+	// PackageReasonBuilderNamespaceNotWatched is the reason of builds whose
+	// environment builder namespace buildermgr doesn't watch.
+	PackageReasonBuilderNamespaceNotWatched = "BuilderNamespaceNotWatched"
+
+	// PackageReasonSourceArchiveMissing is the reason of builds whose
+	// source archive was deleted from the storage service.
+	PackageReasonSourceArchiveMissing = "SourceArchiveMissing"
+
+	// PackageReasonSourceChecksumMismatch is the reason of builds whose
+	// source archive doesn't match its declared checksum.
+	PackageReasonSourceChecksumMismatch = "SourceChecksumMismatch"
+
+	// PackageReasonArchiveTooLarge is the reason of builds whose source
+	// or deployment archive exceeds its size limit.
+	PackageReasonArchiveTooLarge = "ArchiveTooLarge"
+
+	// PackageReasonBuilderUnreachable is the reason of builds whose
+	// builder pod didn't answer the fetch, build or upload request.
+	PackageReasonBuilderUnreachable = "BuilderUnreachable"
+
+	// PackageReasonUploadFailed is the reason of builds whose deployment
+	// archive failed to be uploaded to the storage service.
+	PackageReasonUploadFailed = "UploadFailed"
+
+	// PackageReasonBuilderProtocolMismatch is the reason of builds whose
+	// builder pod speaks a build protocol version the builder manager
+	// can't build with.
+	PackageReasonBuilderProtocolMismatch = "BuilderProtocolMismatch"
+
+	// PackageReasonBuilderOOMKilled is the reason of builds whose builder
+	// container was killed for exceeding its memory limit.
+	PackageReasonBuilderOOMKilled = "BuilderOOMKilled"
+
+	// PackageReasonBuilderEvicted is the reason of builds whose builder pod
+	// was evicted from its node.
+	PackageReasonBuilderEvicted = "BuilderEvicted"
+
+	// PackageReasonBuilderDiskPressure is the reason of builds whose builder
+	// pod was evicted for its ephemeral storage use, over its limit or on a
+	// node running out of disk.
+	PackageReasonBuilderDiskPressure = "BuilderDiskPressure"
+
+	// PackageReasonBuilderImagePullFailed is the reason of builds whose
+	// builder pod couldn't pull its image until the builder wait ran out.
+	PackageReasonBuilderImagePullFailed = "BuilderImagePullFailed"
+
+	// PackageReasonBuildInterrupted is the reason of pending packages whose
+	// build was interrupted by a builder manager shutdown.
+	PackageReasonBuildInterrupted = "BuildInterrupted"
+
+	// PackageReasonSourceUnchanged is the reason of succeeded packages whose
+	// build was skipped, their source archive and builder image are the
+	// ones of the last successful build.
+	PackageReasonSourceUnchanged = "SourceUnchanged"
+
+	// PackageReasonArtifactUnchanged is the reason of the FunctionsUpdated
+	// condition of builds whose deployment archive is byte-identical to the
+	// current one, it's kept and the functions aren't bumped.
+	PackageReasonArtifactUnchanged = "ArtifactUnchanged"
+
+	// PackageReasonDryRunSucceeded and PackageReasonDryRunFailed are the
+	// reasons of the DryRunSucceeded condition not having a more specific
+	// one.
+	PackageReasonDryRunSucceeded = "DryRunSucceeded"
+	PackageReasonDryRunFailed    = "DryRunFailed"
+)
+
 const (
 	AllowedFunctionsPerContainerSingle   = "single"
 	AllowedFunctionsPerContainerInfinite = "infinite"
@@ -71,6 +209,10 @@ const (
 	BuilderPodSpecPath = "/etc/fission/builder-podspec-patch.yaml"
 )
 
+// MinBuilderEphemeralStorageLimit is the smallest ephemeral storage limit
+// of builder pods, the builds need room for their sources and outputs.
+const MinBuilderEphemeralStorageLimit = "256Mi"
+
 const (
 	SharedVolumeUserfunc   = "userfunc"
 	SharedVolumePackages   = "packages"
@@ -137,6 +279,81 @@ const (
 
 const (
 	ANNOTATION_SVC_HOST = "svcHost"
+	// ANNOTATION_MAX_BUILD_RETRIES overrides the number of times
+	// buildermgr retries a failed build of the annotated package.
+	ANNOTATION_MAX_BUILD_RETRIES = "fission.io/max-build-retries"
+	// ANNOTATION_ARCHIVE_COMPRESSION sets the compression of the deployment
+	// archives built by the annotated environment: auto, always or never.
+	ANNOTATION_ARCHIVE_COMPRESSION = "fission.io/archive-compression"
+	// ANNOTATION_ARCHIVE_COMPRESSION_LEVEL sets the gzip compression level
+	// of the deployment archives, 1 (fastest) to 9 (best).
+	ANNOTATION_ARCHIVE_COMPRESSION_LEVEL = "fission.io/archive-compression-level"
+	// ANNOTATION_ARCHIVE_CONTENT_TYPE overrides the detected content type
+	// of the deployment archives.
+	ANNOTATION_ARCHIVE_CONTENT_TYPE = "fission.io/archive-content-type"
+	// ANNOTATION_MAX_SOURCE_ARCHIVE_SIZE and ANNOTATION_MAX_DEPLOYMENT_ARCHIVE_SIZE
+	// override the buildermgr size limits of the source and deployment
+	// archives of the packages built by the annotated environment, as
+	// quantities like "2Gi". "0" means no limit.
+	ANNOTATION_MAX_SOURCE_ARCHIVE_SIZE     = "fission.io/max-source-archive-size"
+	ANNOTATION_MAX_DEPLOYMENT_ARCHIVE_SIZE = "fission.io/max-deployment-archive-size"
+	// ANNOTATION_STORAGE_TARGET selects the storagesvc storage target
+	// receiving the deployment archive of the annotated package.
+	ANNOTATION_STORAGE_TARGET = "fission.io/storage-target"
+	// ANNOTATION_REBUILD set to "true" makes buildermgr rebuild the annotated
+	// package whatever its build status. buildermgr removes the annotation
+	// when it takes the request, setting it again requests another build.
+	ANNOTATION_REBUILD = "fission.io/rebuild"
+	// ANNOTATION_SKIP_BUILD set to "true" makes buildermgr never build the
+	// annotated package, its deployment archive is uploaded by someone else.
+	ANNOTATION_SKIP_BUILD = "fission.io/skip-build"
+	// ANNOTATION_REBUILD_ON_BUILDER_CHANGE set to "true" on an environment
+	// makes buildermgr rebuild the source packages of the environment when
+	// its builder image changes.
+	ANNOTATION_REBUILD_ON_BUILDER_CHANGE = "fission.io/rebuild-on-builder-change"
+	// ANNOTATION_BUILD_PARALLELISM overrides the number of package builds
+	// of the annotated environment buildermgr runs at the same time.
+	ANNOTATION_BUILD_PARALLELISM = "fission.io/build-parallelism"
+	// ANNOTATION_MAX_CONCURRENT_BUILDS overrides the number of package
+	// builds of the annotated namespace buildermgr runs at the same time.
+	ANNOTATION_MAX_CONCURRENT_BUILDS = "fission.io/max-concurrent-builds"
+	// ANNOTATION_BUILD_PRIORITY sets the build priority of the annotated
+	// package, queued builds of higher priority packages are built first.
+	ANNOTATION_BUILD_PRIORITY = "fission.io/build-priority"
+	// ANNOTATION_BUILD_NOTIFICATION_URL sets an https URL receiving the
+	// completion notifications of the builds of the annotated package.
+	// They are not signed.
+	ANNOTATION_BUILD_NOTIFICATION_URL = "fission.io/build-notification-url"
+	// ANNOTATION_BUILD_DRY_RUN set to "true" makes buildermgr validate that
+	// the annotated package would build, without building it. The outcome
+	// is the DryRunSucceeded condition and the package stays pending.
+	ANNOTATION_BUILD_DRY_RUN = "fission.io/build-dry-run"
+	// ANNOTATION_BUILD_MODE selects how the packages of the annotated
+	// environment are built, see BuildModeShared and BuildModeJob.
+	ANNOTATION_BUILD_MODE = "fission.io/build-mode"
+	// ANNOTATION_BUILD_CPU and ANNOTATION_BUILD_MEMORY set the resources
+	// of the builder building the annotated package in a build job, as
+	// Kubernetes quantities. They're both requested and limits.
+	ANNOTATION_BUILD_CPU    = "fission.io/build-cpu"
+	ANNOTATION_BUILD_MEMORY = "fission.io/build-memory"
+	// ANNOTATION_DELETE_SOURCE_AFTER_BUILD set to "true" or "false"
+	// overrides whether buildermgr deletes the source archive of the
+	// annotated package from the storage service once it's built.
+	ANNOTATION_DELETE_SOURCE_AFTER_BUILD = "fission.io/delete-source-after-build"
+	// ANNOTATION_BUILDER_NAMESPACE sets the namespace of the builder of the
+	// annotated environment, instead of the builder namespace. The namespace
+	// needs the fission-builder service account, and buildermgr the builder
+	// permissions in it.
+	ANNOTATION_BUILDER_NAMESPACE = "fission.io/builder-namespace"
+)
+
+const (
+	// BuildModeShared builds the packages with the builder deployment
+	// of the environment, shared by its builds. It's the default.
+	BuildModeShared = "shared"
+	// BuildModeJob builds every package in a Job of its own, created
+	// from the builder image for the build and deleted once it's done.
+	BuildModeJob = "job"
 )
 
 const (
@@ -47,6 +47,7 @@ func (r *Package) Default() {
 	packagelog.Debug("default", zap.String("name", r.Name))
 	if r.Status.BuildStatus == "" {
 		r.Status.BuildStatus = BuildStatusPending
+		r.Status.BuildTrigger = BuildTriggerPackageCreated
 	}
 }
 
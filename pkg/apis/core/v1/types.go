@@ -19,6 +19,7 @@ package v1
 import (
 	asv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -46,6 +47,7 @@ type (
 	// Package Think of these as function-level images.
 	// +genclient
 	// +kubebuilder:object:root=true
+	// +kubebuilder:subresource:status
 	// +kubebuilder:resource:singular="package",scope="Namespaced",shortName={pkg}
 	Package struct {
 		metav1.TypeMeta   `json:",inline"`
@@ -93,6 +95,10 @@ type (
 		metav1.TypeMeta   `json:",inline"`
 		metav1.ObjectMeta `json:"metadata"`
 		Spec              EnvironmentSpec `json:"spec"`
+
+		// Status summarizes the builds of packages using the environment.
+		// +optional
+		Status EnvironmentStatus `json:"status,omitempty"`
 	}
 
 	// EnvironmentList is a list of Environments.
@@ -213,7 +219,11 @@ type (
 		Sum  string       `json:"sum,omitempty"`
 	}
 
-	// ArchiveType is either literal or URL, indicating whether
+	// ArchiveEncoding is the content encoding of an archive stored
+	// compressed, such as gzip.
+	ArchiveEncoding string
+
+	// ArchiveType is literal, URL or git, indicating whether
 	// the package is specified in the Archive struct or
 	// externally.
 	ArchiveType string
@@ -221,10 +231,11 @@ type (
 	// Archive contains or references a collection of sources or
 	// binary files.
 	Archive struct {
-		// Type defines how the package is specified: literal or URL.
+		// Type defines how the package is specified: literal, URL or git.
 		// Available value:
 		//  - literal
 		//  - url
+		//  - git
 		// +optional
 		Type ArchiveType `json:"type,omitempty"`
 
@@ -241,6 +252,63 @@ type (
 		// referenced by URL. Ignored for literals.
 		// +optional
 		Checksum Checksum `json:"checksum,omitempty"`
+
+		// Git references a git repository, the builder clones it
+		// instead of downloading an archive. Only supported for
+		// source archives.
+		// +optional
+		Git *GitSource `json:"git,omitempty"`
+
+		// AuthSecret is the name of a secret in the package namespace
+		// with the credentials to download the HTTPS URL archive with:
+		// the value of the Authorization header under "authorization",
+		// or a "username" and "password" for basic authentication.
+		// +optional
+		AuthSecret string `json:"authSecret,omitempty"`
+
+		// Size of the archive in bytes. Set on deployment archives
+		// built by buildermgr.
+		// +optional
+		Size int64 `json:"size,omitempty"`
+
+		// Encoding is the content encoding the URL archive is stored
+		// with, "gzip" for compressed archives. Fetchers decompress
+		// encoded archives; Checksum and Size are those of the
+		// decompressed archive. Empty for archives stored as is.
+		// +optional
+		Encoding ArchiveEncoding `json:"encoding,omitempty"`
+
+		// StoredChecksum ensures the integrity of the encoded archive
+		// as stored. Only set along with Encoding.
+		// +optional
+		StoredChecksum Checksum `json:"storedChecksum,omitempty"`
+
+		// StoredSize is the size in bytes of the encoded archive as
+		// stored. Only set along with Encoding.
+		// +optional
+		StoredSize int64 `json:"storedSize,omitempty"`
+	}
+
+	// GitSource is a git repository at a pinned revision.
+	GitSource struct {
+		// URL of the repository, an https, ssh or git URL.
+		URL string `json:"url"`
+
+		// Ref is the branch, tag or commit SHA to check out.
+		// Defaults to the default branch of the repository.
+		// +optional
+		Ref string `json:"ref,omitempty"`
+
+		// SubPath is the directory of the repository holding the
+		// package source. Defaults to the repository root.
+		// +optional
+		SubPath string `json:"subPath,omitempty"`
+
+		// Secret is the name of a secret in the package namespace
+		// with the username and password, or token, to clone the
+		// repository with.
+		// +optional
+		Secret string `json:"secret,omitempty"`
 	}
 
 	// EnvironmentReference is a reference to an environment.
@@ -264,6 +332,12 @@ type (
 	// BuildStatus indicates the current build status of a package.
 	BuildStatus string
 
+	// BuildPhase indicates the step a running package build is at.
+	BuildPhase string
+
+	// BuildTrigger indicates why a package build was triggered.
+	BuildTrigger string
+
 	// PackageSpec includes source/deploy archives and the reference of environment to build the package.
 	PackageSpec struct {
 		// Environment is a reference to the environment for building source archive.
@@ -283,6 +357,12 @@ type (
 		// +optional
 		BuildCommand string `json:"buildcmd,omitempty"`
 
+		// BuildTimeout is the maximum time in seconds the whole build may take,
+		// including waiting for the environment builder to be ready. The package
+		// is marked as failed once it's exceeded. Zero means the builder manager default.
+		// +optional
+		BuildTimeout int `json:"buildTimeout,omitempty"`
+
 		// In the future, we can have a debug build here too
 	}
 
@@ -295,10 +375,93 @@ type (
 		// +kubebuilder:default:="pending"
 		BuildStatus BuildStatus `json:"buildstatus,omitempty"`
 
+		// BuildPhase is the step the running build is at, a failed build
+		// keeps the phase it failed in.
+		// +optional
+		BuildPhase BuildPhase `json:"buildphase,omitempty"`
+
+		// BuildTrigger is why the pending, running or last build was
+		// triggered.
+		// +optional
+		BuildTrigger BuildTrigger `json:"buildtrigger,omitempty"`
+
 		// BuildLog stores build log during the compilation.
 		// +optional
 		BuildLog string `json:"buildlog,omitempty"` // output of the build (errors etc)
 
+		// BuildLogURL is the storage service URL of the complete build log of
+		// the last finished build, BuildLog only keeps its tail then.
+		// +optional
+		BuildLogURL string `json:"buildlogurl,omitempty"`
+
+		// BuildResourceUsage is the resource usage of the last build,
+		// absent if the environment builder doesn't report it.
+		// +optional
+		BuildResourceUsage *BuildResourceUsage `json:"buildresourceusage,omitempty"`
+
+		// BuildStartTime is when the first attempt of the running or
+		// last build started.
+		// +optional
+		// +nullable
+		BuildStartTime *metav1.Time `json:"buildstarttime,omitempty"`
+
+		// BuildCompletionTime is when the last finished build succeeded or
+		// failed, it's kept while the next build runs.
+		// +optional
+		// +nullable
+		BuildCompletionTime *metav1.Time `json:"buildcompletiontime,omitempty"`
+
+		// BuildDurationSeconds is the duration of the last finished build,
+		// its retries included.
+		// +optional
+		BuildDurationSeconds int64 `json:"builddurationseconds,omitempty"`
+
+		// BuildAttempts is the number of attempts of the running or last build.
+		// +optional
+		BuildAttempts int32 `json:"buildattempts,omitempty"`
+
+		// BuilderWait is the health check backoff of a build waiting for
+		// its environment builder, it's cleared once the build starts.
+		// +optional
+		BuilderWait *BuilderWait `json:"builderwait,omitempty"`
+
+		// BuilderPod is the builder pod of the running or last build
+		// attempt, the one it waits for until a pod is ready.
+		// +optional
+		BuilderPod *BuilderPodInfo `json:"builderpod,omitempty"`
+
+		// SourceCommit is the commit SHA the git source of the last build
+		// was resolved to.
+		// +optional
+		SourceCommit string `json:"sourcecommit,omitempty"`
+
+		// SourceDeletedTime is when the source archive of the package was
+		// deleted from the storage service after its last successful
+		// build, the package can't be built from it again.
+		// +optional
+		// +nullable
+		SourceDeletedTime *metav1.Time `json:"sourcedeletedtime,omitempty"`
+
+		// BuiltSource is the source archive and builder image of the last
+		// successful build, builds of the same source with the same builder
+		// image are skipped unless forced.
+		// +optional
+		BuiltSource *BuiltSource `json:"builtsource,omitempty"`
+
+		// BuildHistory are the last finished build attempts, retried ones
+		// included, oldest first.
+		// +optional
+		BuildHistory []BuildHistoryEntry `json:"buildhistory,omitempty"`
+
+		// Conditions are the BuilderReady, BuildSucceeded and FunctionsUpdated
+		// conditions of the last build, BuildSucceeded is in sync with BuildStatus.
+		// +optional
+		// +listType=map
+		// +listMapKey=type
+		// +patchStrategy=merge
+		// +patchMergeKey=type
+		Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
 		// LastUpdateTimestamp will store the timestamp the package was last updated
 		// metav1.Time is a wrapper around time.Time which supports correct marshaling to YAML and JSON.
 		// https://github.com/kubernetes/apimachinery/blob/44bd77c24ef93cd3a5eb6fef64e514025d10d44e/pkg/apis/meta/v1/time.go#L26-L35
@@ -307,6 +470,90 @@ type (
 		LastUpdateTimestamp metav1.Time `json:"lastUpdateTimestamp,omitempty"`
 	}
 
+	// BuildResourceUsage is the resource usage of a package build command
+	// in the environment builder.
+	BuildResourceUsage struct {
+		// CPUMilliseconds is the user and system CPU time of the build.
+		CPUMilliseconds int64 `json:"cpuMilliseconds"`
+
+		// PeakMemoryBytes is the peak resident set size of the build.
+		PeakMemoryBytes int64 `json:"peakMemoryBytes"`
+	}
+
+	// BuilderWait is the state of the environment builder health checks
+	// of a build.
+	BuilderWait struct {
+		// Attempt is the number of the last health check.
+		Attempt int32 `json:"attempt"`
+
+		// MaxAttempts is the number of health checks before the build fails.
+		MaxAttempts int32 `json:"maxAttempts"`
+
+		// NextRetryTime is when the next health check is due.
+		// +optional
+		// +nullable
+		NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	}
+
+	// BuilderPodInfo identifies the environment builder pod of a build.
+	BuilderPodInfo struct {
+		// Name is the name of the pod.
+		Name string `json:"name"`
+
+		// Namespace is the namespace of the pod.
+		Namespace string `json:"namespace"`
+
+		// Node is the node the pod runs on.
+		// +optional
+		Node string `json:"node,omitempty"`
+
+		// Image is the builder image of the pod.
+		// +optional
+		Image string `json:"image,omitempty"`
+	}
+
+	// BuildHistoryEntry is a finished package build attempt.
+	BuildHistoryEntry struct {
+		// Attempt is the number of the attempt in its build.
+		Attempt int32 `json:"attempt"`
+
+		// StartTime is when the attempt started.
+		StartTime metav1.Time `json:"startTime"`
+
+		// CompletionTime is when the attempt succeeded or failed.
+		CompletionTime metav1.Time `json:"completionTime"`
+
+		// Result is succeeded or failed, failed attempts may be retried.
+		Result BuildStatus `json:"result"`
+
+		// BuilderPod is the builder pod of the attempt, as namespace/name.
+		// +optional
+		BuilderPod string `json:"builderPod,omitempty"`
+
+		// Reason is the reason of the failure of a failed attempt.
+		// +optional
+		Reason string `json:"reason,omitempty"`
+
+		// Message is the beginning of the failure message of a failed
+		// attempt.
+		// +optional
+		Message string `json:"message,omitempty"`
+	}
+
+	// BuiltSource identifies the inputs of a package build.
+	BuiltSource struct {
+		// Checksum is the checksum of the source archive.
+		Checksum Checksum `json:"checksum"`
+
+		// BuilderImage is the environment builder image.
+		BuilderImage string `json:"builderImage"`
+
+		// BuildCommand is the build command, the one of the package or
+		// the environment builder default.
+		// +optional
+		BuildCommand string `json:"buildCommand,omitempty"`
+	}
+
 	// PackageRef is a reference to the package.
 	PackageRef struct {
 		// +optional
@@ -570,11 +817,74 @@ type (
 		// - Command; set to the Builder.Command
 		// - TerminationMessagePath
 		// - ImagePullPolicy
-		// - ReadinessProbe
+		// - ReadinessProbe; set with Builder.ReadinessProbe
 		Container *apiv1.Container `json:"container,omitempty"`
 
 		// PodSpec will store the spec of the pod that will be applied to the pod created for the builder
 		PodSpec *apiv1.PodSpec `json:"podspec,omitempty"`
+
+		// (Optional) BuildTimeout is the maximum time in seconds the builder may take
+		// to build a package once the source is fetched. The package is marked as failed
+		// once it's exceeded. Zero means the deadline of the whole package build.
+		// +optional
+		BuildTimeout int `json:"buildTimeout,omitempty"`
+
+		// (Optional) Replicas is the number of builder pods of the environment,
+		// the builds are spread over them. Zero means one builder pod.
+		// +optional
+		Replicas int `json:"replicas,omitempty"`
+
+		// (Optional) PodSecurityContext is the pod-level security context of the
+		// builder pods, such as RunAsNonRoot, SeccompProfile and FSGroup. FSGroup
+		// defaults to RunAsGroup, or RunAsUser, so that the volume shared by the
+		// fetcher and builder containers stays writable.
+		// +optional
+		PodSecurityContext *apiv1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+		// (Optional) SecurityContext is the security context of the builder and
+		// fetcher containers of the builder pods. Containers with their own
+		// security context, e.g. set with Container, keep it.
+		// +optional
+		SecurityContext *apiv1.SecurityContext `json:"securityContext,omitempty"`
+
+		// (Optional) NodeSelector is the node selector of the builder pods, e.g.
+		// to run them on a dedicated node pool for builds. Its labels are added
+		// to the node selector of PodSpec.
+		// +optional
+		NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+		// (Optional) Tolerations are the tolerations of the builder pods, e.g. of
+		// the taints of a dedicated node pool for builds. They're added to the
+		// tolerations of PodSpec.
+		// +optional
+		Tolerations []apiv1.Toleration `json:"tolerations,omitempty"`
+
+		// (Optional) Affinity is the scheduling affinity of the builder pods. It
+		// replaces the affinity of PodSpec.
+		// +optional
+		Affinity *apiv1.Affinity `json:"affinity,omitempty"`
+
+		// (Optional) ReadinessProbe is the readiness probe of the builder
+		// container. Builds only go to builder pods it reports ready. It
+		// defaults to an HTTP probe of the health check of the builder
+		// on port 8001.
+		// +optional
+		ReadinessProbe *apiv1.Probe `json:"readinessProbe,omitempty"`
+
+		// (Optional) EphemeralStorageRequest is the ephemeral storage request of
+		// the builder and fetcher containers of the builder pods, for the
+		// sources, outputs and caches of the builds. It's set over the resources
+		// of Container and PodSpec.
+		// +optional
+		EphemeralStorageRequest *resource.Quantity `json:"ephemeralStorageRequest,omitempty"`
+
+		// (Optional) EphemeralStorageLimit is the ephemeral storage limit of the
+		// builder and fetcher containers of the builder pods. The builder pods
+		// using more are evicted instead of filling the disk of their node. It's
+		// set over the resources of Container and PodSpec, and must be at least
+		// 256Mi.
+		// +optional
+		EphemeralStorageLimit *resource.Quantity `json:"ephemeralStorageLimit,omitempty"`
 	}
 
 	// EnvironmentSpec contains with builder, runtime and some other related environment settings.
@@ -641,6 +951,53 @@ type (
 		// +optional
 		ImagePullSecret string `json:"imagepullsecret"`
 	}
+
+	// EnvironmentStatus is the build health of the packages using the environment,
+	// it's maintained by buildermgr.
+	EnvironmentStatus struct {
+		// PackageBuilds is the number of packages referencing the environment by build status.
+		// +optional
+		PackageBuilds PackageBuildCounts `json:"packageBuilds,omitempty"`
+
+		// LastSuccessfulBuildTimestamp is the time the last successful package build finished.
+		// +optional
+		// +nullable
+		LastSuccessfulBuildTimestamp metav1.Time `json:"lastSuccessfulBuildTimestamp,omitempty"`
+
+		// LastFailedBuildTimestamp is the time the last failed package build finished.
+		// +optional
+		// +nullable
+		LastFailedBuildTimestamp metav1.Time `json:"lastFailedBuildTimestamp,omitempty"`
+
+		// BuilderReady reports whether the environment builder pod is ready to build packages.
+		// +optional
+		BuilderReady bool `json:"builderReady,omitempty"`
+
+		// BuilderFailure is why the pods of the environment builder can't be
+		// created, such as their rejection by the pod admission.
+		// +optional
+		BuilderFailure string `json:"builderFailure,omitempty"`
+
+		// LastUpdateTimestamp is the time the status was last updated.
+		// +optional
+		// +nullable
+		LastUpdateTimestamp metav1.Time `json:"lastUpdateTimestamp,omitempty"`
+	}
+
+	// PackageBuildCounts is the number of packages in each build status.
+	PackageBuildCounts struct {
+		// +optional
+		Pending int `json:"pending,omitempty"`
+		// +optional
+		Running int `json:"running,omitempty"`
+		// +optional
+		Succeeded int `json:"succeeded,omitempty"`
+		// +optional
+		Failed int `json:"failed,omitempty"`
+		// +optional
+		None int `json:"none,omitempty"`
+	}
+
 	// AllowedFunctionsPerContainer defaults to 'single'. Related to Fission Workflows
 	AllowedFunctionsPerContainer string
 
@@ -866,7 +1223,7 @@ type (
 
 // IsEmpty checks if the archive byte and litreal are of length 0
 func (a Archive) IsEmpty() bool {
-	return len(a.Literal) == 0 && len(a.URL) == 0
+	return len(a.Literal) == 0 && len(a.URL) == 0 && a.Git == nil
 }
 
 func (fn Function) GetConcurrency() int {
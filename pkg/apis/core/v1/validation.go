@@ -20,12 +20,16 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"reflect"
 	"regexp"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/robfig/cron/v3"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
 
@@ -195,15 +199,75 @@ func (archive Archive) Validate() error {
 	if len(archive.Type) > 0 {
 		switch archive.Type {
 		case ArchiveTypeLiteral, ArchiveTypeUrl: // no op
+		case ArchiveTypeGit:
+			if archive.Git == nil {
+				result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git", nil, "git archive without git repository"))
+			}
 		default:
 			result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "Archive.Type", archive.Type, "not a valid archive type"))
 		}
 	}
 
+	if archive.Git != nil {
+		result = multierror.Append(result, archive.Git.Validate())
+	}
+
 	if archive.Checksum != (Checksum{}) {
 		result = multierror.Append(result, archive.Checksum.Validate())
 	}
 
+	if len(archive.Encoding) > 0 {
+		if archive.Encoding != ArchiveEncodingGzip {
+			result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "Archive.Encoding", archive.Encoding, "not a valid archive encoding"))
+		}
+		if archive.Type == ArchiveTypeLiteral || len(archive.Literal) > 0 || archive.Git != nil {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Encoding", archive.Encoding, "encoding requires a URL archive"))
+		}
+	}
+
+	if archive.StoredChecksum != (Checksum{}) {
+		result = multierror.Append(result, archive.StoredChecksum.Validate())
+	}
+
+	if len(archive.AuthSecret) > 0 {
+		// credentials are never sent in clear text
+		if u, err := url.Parse(archive.URL); err != nil || u.Scheme != "https" || len(archive.Literal) > 0 || archive.Git != nil {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.AuthSecret", archive.AuthSecret, "auth secret requires an https URL archive"))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (git GitSource) Validate() error {
+	result := &multierror.Error{}
+
+	if u, err := url.Parse(git.URL); err != nil || len(u.Host) == 0 {
+		// scp-like ssh URLs, e.g. git@github.com:fission/fission.git
+		if !strings.Contains(git.URL, "@") || !strings.Contains(git.URL, ":") {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "GitSource.URL", git.URL, "not a valid git repository URL"))
+		}
+	} else {
+		switch u.Scheme {
+		case "https", "http", "ssh", "git": // no op
+		default:
+			result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "GitSource.URL", git.URL, "not a supported git URL scheme"))
+		}
+	}
+
+	if len(git.SubPath) > 0 {
+		p := path.Clean(git.SubPath)
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "GitSource.SubPath", git.SubPath, "must be a relative path within the repository"))
+		}
+	}
+
+	if len(git.Secret) > 0 {
+		if e := validation.IsDNS1123Subdomain(git.Secret); len(e) > 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "GitSource.Secret", git.Secret, e...))
+		}
+	}
+
 	return result.ErrorOrNil()
 }
 
@@ -231,11 +295,19 @@ func (spec PackageSpec) Validate() error {
 	result = multierror.Append(result, spec.Environment.Validate())
 
 	for _, r := range []Archive{spec.Source, spec.Deployment} {
-		if len(r.URL) > 0 || len(r.Literal) > 0 {
+		if !r.IsEmpty() {
 			result = multierror.Append(result, r.Validate())
 		}
 	}
 
+	if spec.Deployment.Type == ArchiveTypeGit || spec.Deployment.Git != nil {
+		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "PackageSpec.Deployment.Type", spec.Deployment.Type, "git is only supported for source archives"))
+	}
+
+	if spec.BuildTimeout < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "PackageSpec.BuildTimeout", spec.BuildTimeout, "build timeout must be greater than or equal to 0"))
+	}
+
 	return result.ErrorOrNil()
 }
 
@@ -243,7 +315,7 @@ func (sts PackageStatus) Validate() error {
 	result := &multierror.Error{}
 
 	switch sts.BuildStatus {
-	case BuildStatusPending, BuildStatusRunning, BuildStatusSucceeded, BuildStatusFailed, BuildStatusNone: // no op
+	case BuildStatusPending, BuildStatusRunning, BuildStatusSucceeded, BuildStatusFailed, BuildStatusCanceled, BuildStatusNone: // no op
 	default:
 		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "PackageStatus.BuildStatus", sts.BuildStatus, "not a valid build status"))
 	}
@@ -378,7 +450,89 @@ func (runtime Runtime) Validate() error {
 }
 
 func (builder Builder) Validate() error {
-	// do nothing for now
+	result := &multierror.Error{}
+
+	if builder.BuildTimeout < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.BuildTimeout", builder.BuildTimeout, "build timeout must be greater than or equal to 0"))
+	}
+
+	if builder.Replicas < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.Replicas", builder.Replicas, "builder replicas must be greater than or equal to 0"))
+	}
+
+	// the container settings override the pod ones
+	var podNonRoot *bool
+	var podUser *int64
+	if ctx := builder.PodSecurityContext; ctx != nil {
+		podNonRoot, podUser = ctx.RunAsNonRoot, ctx.RunAsUser
+		result = multierror.Append(result, validateRunAsNonRoot("Builder.PodSecurityContext", podNonRoot, podUser))
+	}
+	fields := []string{"Builder.SecurityContext", "Builder.Container.SecurityContext"}
+	ctxs := []*apiv1.SecurityContext{builder.SecurityContext, nil}
+	if builder.Container != nil {
+		ctxs[1] = builder.Container.SecurityContext
+	}
+	for i, ctx := range ctxs {
+		if ctx == nil {
+			continue
+		}
+		field := fields[i]
+		nonRoot, user := podNonRoot, podUser
+		if ctx.RunAsNonRoot != nil {
+			nonRoot = ctx.RunAsNonRoot
+		}
+		if ctx.RunAsUser != nil {
+			user = ctx.RunAsUser
+		}
+		result = multierror.Append(result, validateRunAsNonRoot(field, nonRoot, user))
+		if ctx.Privileged != nil && *ctx.Privileged && ctx.AllowPrivilegeEscalation != nil && !*ctx.AllowPrivilegeEscalation {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, field+".AllowPrivilegeEscalation", false, "privilege escalation can't be disallowed for privileged containers"))
+		}
+	}
+
+	for k, v := range builder.NodeSelector {
+		if e := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...); len(e) > 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.NodeSelector", k+"="+v, e...))
+		}
+	}
+	for i, toleration := range builder.Tolerations {
+		if toleration.Operator == apiv1.TolerationOpExists && len(toleration.Value) > 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, fmt.Sprintf("Builder.Tolerations[%d].Value", i), toleration.Value, "value must be empty with operator Exists"))
+		}
+	}
+	if probe := builder.ReadinessProbe; probe != nil {
+		handlers := 0
+		for _, set := range []bool{probe.Exec != nil, probe.HTTPGet != nil, probe.TCPSocket != nil, probe.GRPC != nil} {
+			if set {
+				handlers++
+			}
+		}
+		if handlers != 1 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.ReadinessProbe", handlers, "readiness probe must have exactly one handler"))
+		}
+	}
+	if request := builder.EphemeralStorageRequest; request != nil && request.Sign() < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.EphemeralStorageRequest", request.String(), "ephemeral storage request must be greater than or equal to 0"))
+	}
+	if limit := builder.EphemeralStorageLimit; limit != nil {
+		floor := resource.MustParse(MinBuilderEphemeralStorageLimit)
+		if limit.Cmp(floor) < 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.EphemeralStorageLimit", limit.String(), "ephemeral storage limit must be at least "+MinBuilderEphemeralStorageLimit))
+		}
+		if request := builder.EphemeralStorageRequest; request != nil && request.Cmp(*limit) > 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.EphemeralStorageRequest", request.String(), "ephemeral storage request must be less than or equal to the limit"))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// validateRunAsNonRoot rejects security contexts running as root while
+// requiring a non-root user.
+func validateRunAsNonRoot(field string, runAsNonRoot *bool, runAsUser *int64) error {
+	if runAsNonRoot != nil && *runAsNonRoot && runAsUser != nil && *runAsUser == 0 {
+		return MakeValidationErr(ErrorInvalidValue, field+".RunAsUser", 0, "can't run as root with RunAsNonRoot set")
+	}
 	return nil
 }
 
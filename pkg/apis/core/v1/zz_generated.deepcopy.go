@@ -24,6 +24,8 @@ package v1
 import (
 	"k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -36,6 +38,12 @@ func (in *Archive) DeepCopyInto(out *Archive) {
 		copy(*out, *in)
 	}
 	out.Checksum = in.Checksum
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitSource)
+		**out = **in
+	}
+	out.StoredChecksum = in.StoredChecksum
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Archive.
@@ -63,6 +71,89 @@ func (in *AuthLogin) DeepCopy() *AuthLogin {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildHistoryEntry) DeepCopyInto(out *BuildHistoryEntry) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.CompletionTime.DeepCopyInto(&out.CompletionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildHistoryEntry.
+func (in *BuildHistoryEntry) DeepCopy() *BuildHistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildHistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildResourceUsage) DeepCopyInto(out *BuildResourceUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildResourceUsage.
+func (in *BuildResourceUsage) DeepCopy() *BuildResourceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildResourceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuilderPodInfo) DeepCopyInto(out *BuilderPodInfo) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuilderPodInfo.
+func (in *BuilderPodInfo) DeepCopy() *BuilderPodInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(BuilderPodInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuilderWait) DeepCopyInto(out *BuilderWait) {
+	*out = *in
+	if in.NextRetryTime != nil {
+		in, out := &in.NextRetryTime, &out.NextRetryTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuilderWait.
+func (in *BuilderWait) DeepCopy() *BuilderWait {
+	if in == nil {
+		return nil
+	}
+	out := new(BuilderWait)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuiltSource) DeepCopyInto(out *BuiltSource) {
+	*out = *in
+	out.Checksum = in.Checksum
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuiltSource.
+func (in *BuiltSource) DeepCopy() *BuiltSource {
+	if in == nil {
+		return nil
+	}
+	out := new(BuiltSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Builder) DeepCopyInto(out *Builder) {
 	*out = *in
@@ -76,6 +167,50 @@ func (in *Builder) DeepCopyInto(out *Builder) {
 		*out = new(corev1.PodSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodSecurityContext != nil {
+		in, out := &in.PodSecurityContext, &out.PodSecurityContext
+		*out = new(corev1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(corev1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReadinessProbe != nil {
+		in, out := &in.ReadinessProbe, &out.ReadinessProbe
+		*out = new(corev1.Probe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EphemeralStorageRequest != nil {
+		in, out := &in.EphemeralStorageRequest, &out.EphemeralStorageRequest
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.EphemeralStorageLimit != nil {
+		in, out := &in.EphemeralStorageLimit, &out.EphemeralStorageLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Builder.
@@ -213,6 +348,7 @@ func (in *Environment) DeepCopyInto(out *Environment) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Environment.
@@ -298,6 +434,25 @@ func (in *EnvironmentSpec) DeepCopy() *EnvironmentSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvironmentStatus) DeepCopyInto(out *EnvironmentStatus) {
+	*out = *in
+	out.PackageBuilds = in.PackageBuilds
+	in.LastSuccessfulBuildTimestamp.DeepCopyInto(&out.LastSuccessfulBuildTimestamp)
+	in.LastFailedBuildTimestamp.DeepCopyInto(&out.LastFailedBuildTimestamp)
+	in.LastUpdateTimestamp.DeepCopyInto(&out.LastUpdateTimestamp)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvironmentStatus.
+func (in *EnvironmentStatus) DeepCopy() *EnvironmentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvironmentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExecutionStrategy) DeepCopyInto(out *ExecutionStrategy) {
 	*out = *in
@@ -460,6 +615,21 @@ func (in *FunctionSpec) DeepCopy() *FunctionSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitSource) DeepCopyInto(out *GitSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitSource.
+func (in *GitSource) DeepCopy() *GitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPTrigger) DeepCopyInto(out *HTTPTrigger) {
 	*out = *in
@@ -797,6 +967,21 @@ func (in *Package) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageBuildCounts) DeepCopyInto(out *PackageBuildCounts) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageBuildCounts.
+func (in *PackageBuildCounts) DeepCopy() *PackageBuildCounts {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageBuildCounts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PackageList) DeepCopyInto(out *PackageList) {
 	*out = *in
@@ -865,6 +1050,52 @@ func (in *PackageSpec) DeepCopy() *PackageSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PackageStatus) DeepCopyInto(out *PackageStatus) {
 	*out = *in
+	if in.BuildResourceUsage != nil {
+		in, out := &in.BuildResourceUsage, &out.BuildResourceUsage
+		*out = new(BuildResourceUsage)
+		**out = **in
+	}
+	if in.BuildStartTime != nil {
+		in, out := &in.BuildStartTime, &out.BuildStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BuildCompletionTime != nil {
+		in, out := &in.BuildCompletionTime, &out.BuildCompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BuilderWait != nil {
+		in, out := &in.BuilderWait, &out.BuilderWait
+		*out = new(BuilderWait)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BuilderPod != nil {
+		in, out := &in.BuilderPod, &out.BuilderPod
+		*out = new(BuilderPodInfo)
+		**out = **in
+	}
+	if in.SourceDeletedTime != nil {
+		in, out := &in.SourceDeletedTime, &out.SourceDeletedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BuiltSource != nil {
+		in, out := &in.BuiltSource, &out.BuiltSource
+		*out = new(BuiltSource)
+		**out = **in
+	}
+	if in.BuildHistory != nil {
+		in, out := &in.BuildHistory, &out.BuildHistory
+		*out = make([]BuildHistoryEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	in.LastUpdateTimestamp.DeepCopyInto(&out.LastUpdateTimestamp)
 }
 
@@ -11,11 +11,17 @@ package v1
 // Those methods can be generated by using hack/update-swagger-docs.sh
 // AUTO-GENERATED FUNCTIONS START HERE
 var map_Archive = map[string]string{
-	"":         "Archive contains or references a collection of sources or binary files.",
-	"type":     "Type defines how the package is specified: literal or URL. Available value:\n - literal\n - url",
-	"literal":  "Literal contents of the package. Can be used for encoding packages below TODO (256 KB?) size.",
-	"url":      "URL references a package.",
-	"checksum": "Checksum ensures the integrity of packages referenced by URL. Ignored for literals.",
+	"":               "Archive contains or references a collection of sources or binary files.",
+	"type":           "Type defines how the package is specified: literal, URL or git. Available value:\n - literal\n - url\n - git",
+	"literal":        "Literal contents of the package. Can be used for encoding packages below TODO (256 KB?) size.",
+	"url":            "URL references a package.",
+	"checksum":       "Checksum ensures the integrity of packages referenced by URL. Ignored for literals.",
+	"git":            "Git references a git repository, the builder clones it instead of downloading an archive. Only supported for source archives.",
+	"authSecret":     "AuthSecret is the name of a secret in the package namespace with the credentials to download the HTTPS URL archive with: the value of the Authorization header under \"authorization\", or a \"username\" and \"password\" for basic authentication.",
+	"size":           "Size of the archive in bytes. Set on deployment archives built by buildermgr.",
+	"encoding":       "Encoding is the content encoding the URL archive is stored with, \"gzip\" for compressed archives. Fetchers decompress encoded archives; Checksum and Size are those of the decompressed archive. Empty for archives stored as is.",
+	"storedChecksum": "StoredChecksum ensures the integrity of the encoded archive as stored. Only set along with Encoding.",
+	"storedSize":     "StoredSize is the size in bytes of the encoded archive as stored. Only set along with Encoding.",
 }
 
 func (Archive) SwaggerDoc() map[string]string {
@@ -30,12 +36,72 @@ func (AuthLogin) SwaggerDoc() map[string]string {
 	return map_AuthLogin
 }
 
+var map_BuildHistoryEntry = map[string]string{
+	"":               "BuildHistoryEntry is a finished package build attempt.",
+	"attempt":        "Attempt is the number of the attempt in its build.",
+	"startTime":      "StartTime is when the attempt started.",
+	"completionTime": "CompletionTime is when the attempt succeeded or failed.",
+	"result":         "Result is succeeded or failed, failed attempts may be retried.",
+	"builderPod":     "BuilderPod is the builder pod of the attempt, as namespace/name.",
+	"reason":         "Reason is the reason of the failure of a failed attempt.",
+	"message":        "Message is the beginning of the failure message of a failed attempt.",
+}
+
+func (BuildHistoryEntry) SwaggerDoc() map[string]string {
+	return map_BuildHistoryEntry
+}
+
+var map_BuildResourceUsage = map[string]string{
+	"":                "BuildResourceUsage is the resource usage of a package build command in the environment builder.",
+	"cpuMilliseconds": "CPUMilliseconds is the user and system CPU time of the build.",
+	"peakMemoryBytes": "PeakMemoryBytes is the peak resident set size of the build.",
+}
+
+func (BuildResourceUsage) SwaggerDoc() map[string]string {
+	return map_BuildResourceUsage
+}
+
+var map_BuilderPodInfo = map[string]string{
+	"":          "BuilderPodInfo identifies the environment builder pod of a build.",
+	"name":      "Name is the name of the pod.",
+	"namespace": "Namespace is the namespace of the pod.",
+	"node":      "Node is the node the pod runs on.",
+	"image":     "Image is the builder image of the pod.",
+}
+
+func (BuilderPodInfo) SwaggerDoc() map[string]string {
+	return map_BuilderPodInfo
+}
+
+var map_BuilderWait = map[string]string{
+	"":              "BuilderWait is the state of the environment builder health checks of a build.",
+	"attempt":       "Attempt is the number of the last health check.",
+	"maxAttempts":   "MaxAttempts is the number of health checks before the build fails.",
+	"nextRetryTime": "NextRetryTime is when the next health check is due.",
+}
+
+func (BuilderWait) SwaggerDoc() map[string]string {
+	return map_BuilderWait
+}
+
+var map_BuiltSource = map[string]string{
+	"":             "BuiltSource identifies the inputs of a package build.",
+	"checksum":     "Checksum is the checksum of the source archive.",
+	"builderImage": "BuilderImage is the environment builder image.",
+	"buildCommand": "BuildCommand is the build command, the one of the package or the environment builder default.",
+}
+
+func (BuiltSource) SwaggerDoc() map[string]string {
+	return map_BuiltSource
+}
+
 var map_Builder = map[string]string{
-	"":          "Builder is the setting for environment builder.",
-	"image":     "Image for containing the language compilation environment.",
-	"command":   "(Optional) Default build command to run for this build environment.",
-	"container": "(Optional) Container allows the modification of the deployed builder container using the Kubernetes Container spec. Fission overrides the following fields: - Name - Image; set to the Builder.Image - Command; set to the Builder.Command - TerminationMessagePath - ImagePullPolicy - ReadinessProbe",
-	"podspec":   "PodSpec will store the spec of the pod that will be applied to the pod created for the builder",
+	"":             "Builder is the setting for environment builder.",
+	"image":        "Image for containing the language compilation environment.",
+	"command":      "(Optional) Default build command to run for this build environment.",
+	"container":    "(Optional) Container allows the modification of the deployed builder container using the Kubernetes Container spec. Fission overrides the following fields: - Name - Image; set to the Builder.Image - Command; set to the Builder.Command - TerminationMessagePath - ImagePullPolicy - ReadinessProbe",
+	"podspec":      "PodSpec will store the spec of the pod that will be applied to the pod created for the builder",
+	"buildTimeout": "(Optional) BuildTimeout is the maximum time in seconds the builder may take to build a package once the source is fetched. The package is marked as failed once it's exceeded. Zero means the deadline of the whole package build.",
 }
 
 func (Builder) SwaggerDoc() map[string]string {
@@ -210,6 +276,18 @@ func (FunctionSpec) SwaggerDoc() map[string]string {
 	return map_FunctionSpec
 }
 
+var map_GitSource = map[string]string{
+	"":        "GitSource is a git repository at a pinned revision.",
+	"url":     "URL of the repository, an https, ssh or git URL.",
+	"ref":     "Ref is the branch, tag or commit SHA to check out. Defaults to the default branch of the repository.",
+	"subPath": "SubPath is the directory of the repository holding the package source. Defaults to the repository root.",
+	"secret":  "Secret is the name of a secret in the package namespace with the username and password, or token, to clone the repository with.",
+}
+
+func (GitSource) SwaggerDoc() map[string]string {
+	return map_GitSource
+}
+
 var map_HTTPTrigger = map[string]string{
 	"": "HTTPTrigger is the trigger invokes user functions when receiving HTTP requests.",
 }
@@ -370,10 +448,24 @@ func (PackageSpec) SwaggerDoc() map[string]string {
 }
 
 var map_PackageStatus = map[string]string{
-	"":                    "PackageStatus contains the build status of a package also the build log for examination.",
-	"buildstatus":         "BuildStatus is the package build status.",
-	"buildlog":            "BuildLog stores build log during the compilation.",
-	"lastUpdateTimestamp": "LastUpdateTimestamp will store the timestamp the package was last updated metav1.Time is a wrapper around time.Time which supports correct marshaling to YAML and JSON. https://github.com/kubernetes/apimachinery/blob/44bd77c24ef93cd3a5eb6fef64e514025d10d44e/pkg/apis/meta/v1/time.go#L26-L35",
+	"":                     "PackageStatus contains the build status of a package also the build log for examination.",
+	"buildstatus":          "BuildStatus is the package build status.",
+	"buildphase":           "BuildPhase is the step the running build is at, a failed build keeps the phase it failed in.",
+	"buildtrigger":         "BuildTrigger is why the pending, running or last build was triggered.",
+	"buildlog":             "BuildLog stores build log during the compilation.",
+	"buildlogurl":          "BuildLogURL is the storage service URL of the complete build log of the last finished build, BuildLog only keeps its tail then.",
+	"buildresourceusage":   "BuildResourceUsage is the resource usage of the last build, absent if the environment builder doesn't report it.",
+	"buildstarttime":       "BuildStartTime is when the first attempt of the running or last build started.",
+	"buildcompletiontime":  "BuildCompletionTime is when the last finished build succeeded or failed, it's kept while the next build runs.",
+	"builddurationseconds": "BuildDurationSeconds is the duration of the last finished build, its retries included.",
+	"buildattempts":        "BuildAttempts is the number of attempts of the running or last build.",
+	"builderwait":          "BuilderWait is the health check backoff of a build waiting for its environment builder, it's cleared once the build starts.",
+	"builderpod":           "BuilderPod is the builder pod of the running or last build attempt, the one it waits for until a pod is ready.",
+	"sourcecommit":         "SourceCommit is the commit SHA the git source of the last build was resolved to.",
+	"builtsource":          "BuiltSource is the source archive and builder image of the last successful build, builds of the same source with the same builder image are skipped unless forced.",
+	"buildhistory":         "BuildHistory are the last finished build attempts, retried ones included, oldest first.",
+	"conditions":           "Conditions are the BuilderReady, BuildSucceeded and FunctionsUpdated conditions of the last build, BuildSucceeded is in sync with BuildStatus.",
+	"lastUpdateTimestamp":  "LastUpdateTimestamp will store the timestamp the package was last updated metav1.Time is a wrapper around time.Time which supports correct marshaling to YAML and JSON. https://github.com/kubernetes/apimachinery/blob/44bd77c24ef93cd3a5eb6fef64e514025d10d44e/pkg/apis/meta/v1/time.go#L26-L35",
 }
 
 func (PackageStatus) SwaggerDoc() map[string]string {
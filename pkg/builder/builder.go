@@ -42,34 +42,79 @@ const (
 	// supported environment variables
 	envSrcPkg    string = "SRC_PKG"
 	envDeployPkg string = "DEPLOY_PKG"
+
+	// ProtocolVersion is the version of the build requests and responses
+	// the builder manager and the builder exchange. Version 1 payloads
+	// have no version. Builders accept the requests of older versions and
+	// reject newer ones.
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest builder protocol version the builder
+	// manager builds with.
+	MinProtocolVersion = 1
 )
 
 type (
 	PackageBuildRequest struct {
-		SrcPkgFilename string `json:"srcPkgFilename"`
+		// ProtocolVersion is the protocol version of the builder manager,
+		// 0 for version 1.
+		ProtocolVersion int    `json:"protocolVersion,omitempty"`
+		SrcPkgFilename  string `json:"srcPkgFilename"`
+		// BuildID identifies the build the workspace in the shared volume belongs to.
+		BuildID string `json:"buildID,omitempty"`
 		// Command for builder to run with.
 		// A build command consists of commands, parameters and environment variables.
 		// For now, two environment variables are supported:
 		// 1. SRC_PKG: path to source package directory
 		// 2. DEPLOY_PKG: path to deployment package directory
 		BuildCommand string `json:"command"`
+		// Metadata is the build metadata of the package, e.g. its team,
+		// exposed to the build command as FISSION_BUILD_META_<NAME>
+		// environment variables.
+		Metadata map[string]string `json:"metadata,omitempty"`
 	}
 
 	PackageBuildResponse struct {
+		// ProtocolVersion is the protocol version of the builder, 0 for
+		// version 1.
+		ProtocolVersion  int    `json:"protocolVersion,omitempty"`
 		ArtifactFilename string `json:"artifactFilename"`
 		BuildLogs        string `json:"buildLogs"`
+		// ResourceUsage is absent if the builder can't measure it.
+		ResourceUsage *BuildResourceUsage `json:"resourceUsage,omitempty"`
+	}
+
+	// BuildResourceUsage is the resource usage of the build command.
+	BuildResourceUsage struct {
+		// CPUMilliseconds is the user and system CPU time of the build.
+		CPUMilliseconds int64 `json:"cpuMilliseconds"`
+		// PeakMemoryBytes is the peak resident set size of the build.
+		PeakMemoryBytes int64 `json:"peakMemoryBytes"`
 	}
 
 	Builder struct {
 		logger           *zap.Logger
 		sharedVolumePath string
+		workspaces       *workspaceRegistry
+		janitorConfig    JanitorConfig
 	}
 )
 
+// Version returns the protocol version of the builder answering the
+// request, responses without version are version 1.
+func (resp *PackageBuildResponse) Version() int {
+	if resp.ProtocolVersion == 0 {
+		return 1
+	}
+	return resp.ProtocolVersion
+}
+
 func MakeBuilder(logger *zap.Logger, sharedVolumePath string) *Builder {
+	logger = logger.Named("builder")
 	return &Builder{
-		logger:           logger.Named("builder"),
+		logger:           logger,
 		sharedVolumePath: sharedVolumePath,
+		workspaces:       makeWorkspaceRegistry(),
+		janitorConfig:    GetJanitorConfig(logger),
 	}
 }
 
@@ -92,7 +137,7 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		e := "method not allowed"
 		logger.Error(e, zap.String("http_method", r.Method))
-		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, r.Method), http.StatusMethodNotAllowed)
+		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, r.Method), nil, http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -107,7 +152,7 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		e := "error reading request body"
 		logger.Error(e, zap.Error(err))
-		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, err.Error()), http.StatusInternalServerError)
+		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, err.Error()), nil, http.StatusInternalServerError)
 		return
 	}
 	var req PackageBuildRequest
@@ -115,12 +160,21 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		e := "error parsing json body"
 		logger.Error(e, zap.Error(err))
-		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, err.Error()), http.StatusBadRequest)
+		builder.reply(r.Context(), w, "", fmt.Sprintf("%s: %s", e, err.Error()), nil, http.StatusBadRequest)
 		return
 	}
 	logger.Info("builder received request", zap.Any("request", req))
+	if req.ProtocolVersion > ProtocolVersion {
+		// the builder manager doesn't expect a version 1 answer, it
+		// reports the mismatch from the version of the reply
+		e := fmt.Sprintf("builder speaks protocol v%d, build request is v%d", ProtocolVersion, req.ProtocolVersion)
+		logger.Error(e)
+		builder.reply(r.Context(), w, "", e, nil, http.StatusBadRequest)
+		return
+	}
 
 	logger.Debug("starting build")
+	builder.workspaces.register(req.BuildID, req.SrcPkgFilename)
 	srcPkgPath := filepath.Join(builder.sharedVolumePath, req.SrcPkgFilename)
 	deployPkgFilename := fmt.Sprintf("%s-%s", req.SrcPkgFilename, strings.ToLower(uniuri.NewLen(6)))
 	deployPkgPath := filepath.Join(builder.sharedVolumePath, deployPkgFilename)
@@ -140,31 +194,36 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 			buildArgs = append(buildArgs, args[i])
 		}
 	}
-	buildLogs, err := builder.build(r.Context(), buildCmd, buildArgs, srcPkgPath, deployPkgPath)
+	buildLogs, usage, err := builder.build(r.Context(), buildCmd, buildArgs, srcPkgPath, deployPkgPath, metadataEnv(req.Metadata))
 	if err != nil {
 		e := "error building source package"
 		logger.Error(e, zap.Error(err))
 
 		// append error at the end of build logs
 		buildLogs += fmt.Sprintf("%s: %s\n", e, err.Error())
-		builder.reply(r.Context(), w, deployPkgFilename, buildLogs, http.StatusInternalServerError)
+		builder.workspaces.setStatus(req.SrcPkgFilename, WorkspaceStatusFailed)
+		builder.reply(r.Context(), w, deployPkgFilename, buildLogs, usage, http.StatusInternalServerError)
 		return
 	}
 
-	builder.reply(r.Context(), w, deployPkgFilename, buildLogs, http.StatusOK)
+	builder.workspaces.setStatus(req.SrcPkgFilename, WorkspaceStatusSucceeded)
+	builder.reply(r.Context(), w, deployPkgFilename, buildLogs, usage, http.StatusOK)
 }
 
-func (builder *Builder) reply(ctx context.Context, w http.ResponseWriter, pkgFilename string, buildLogs string, statusCode int) {
+func (builder *Builder) reply(ctx context.Context, w http.ResponseWriter, pkgFilename string, buildLogs string,
+	usage *BuildResourceUsage, statusCode int) {
 	logger := otelUtils.LoggerWithTraceID(ctx, builder.logger)
 	resp := PackageBuildResponse{
+		ProtocolVersion:  ProtocolVersion,
 		ArtifactFilename: pkgFilename,
 		BuildLogs:        buildLogs,
+		ResourceUsage:    usage,
 	}
 
 	rBody, err := json.Marshal(resp)
 	if err != nil {
 		e := errors.Wrap(err, "error encoding response body")
-		rBody = []byte(fmt.Sprintf(`{"buildLogs": "%s"}`, e.Error()))
+		rBody = []byte(fmt.Sprintf(`{"protocolVersion": %d, "buildLogs": "%s"}`, ProtocolVersion, e.Error()))
 		statusCode = http.StatusInternalServerError
 	}
 
@@ -181,14 +240,17 @@ func (builder *Builder) reply(ctx context.Context, w http.ResponseWriter, pkgFil
 	}
 }
 
-func (builder *Builder) build(ctx context.Context, command string, args []string, srcPkgPath string, deployPkgPath string) (string, error) {
+// build runs the build command with the extra environment variables, it
+// returns the build logs and the resource usage of the command if it ran.
+func (builder *Builder) build(ctx context.Context, command string, args []string, srcPkgPath string, deployPkgPath string,
+	env []string) (string, *BuildResourceUsage, error) {
 	logger := otelUtils.LoggerWithTraceID(ctx, builder.logger)
 
 	cmd := exec.Command(command, args...)
 
 	fi, err := os.Stat(srcPkgPath)
 	if err != nil {
-		return "", fmt.Errorf("could not find srcPkgPath: '%s'", srcPkgPath)
+		return "", nil, fmt.Errorf("could not find srcPkgPath: '%s'", srcPkgPath)
 	}
 	if fi.IsDir() {
 		cmd.Dir = srcPkgPath
@@ -197,19 +259,20 @@ func (builder *Builder) build(ctx context.Context, command string, args []string
 	}
 
 	// set env variables for build command
-	cmd.Env = append(os.Environ(),
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env,
 		fmt.Sprintf("%s=%s", envSrcPkg, srcPkgPath),
 		fmt.Sprintf("%s=%s", envDeployPkg, deployPkgPath),
 	)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return "", errors.Wrap(err, "error creating stdout pipe for cmd")
+		return "", nil, errors.Wrap(err, "error creating stdout pipe for cmd")
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return "", errors.Wrap(err, "error creating stderr pipe for cmd")
+		return "", nil, errors.Wrap(err, "error creating stderr pipe for cmd")
 	}
 
 	// Init logs
@@ -220,7 +283,7 @@ func (builder *Builder) build(ctx context.Context, command string, args []string
 
 	err = cmd.Start()
 	if err != nil {
-		return "", errors.Wrap(err, "error starting cmd")
+		return "", nil, errors.Wrap(err, "error starting cmd")
 	}
 	fmt.Printf("========= START =========\n")
 	defer fmt.Printf("========= END ===========\n")
@@ -235,14 +298,15 @@ func (builder *Builder) build(ctx context.Context, command string, args []string
 	if err := scanner.Err(); err != nil {
 		scanErr := errors.Wrap(err, "error reading cmd output")
 		fmt.Println(scanErr)
-		return buildLogs, scanErr
+		return buildLogs, nil, scanErr
 	}
 
 	err = cmd.Wait()
+	usage := getResourceUsage(cmd.ProcessState)
 	if err != nil {
 		cmdErr := errors.Wrapf(err, "error waiting for cmd %q", command)
 		fmt.Println(cmdErr)
-		return buildLogs, cmdErr
+		return buildLogs, usage, cmdErr
 	}
-	return buildLogs, nil
+	return buildLogs, usage, nil
 }
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -148,6 +149,9 @@ func TestBuilder(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
+				if buildResp.ProtocolVersion != ProtocolVersion {
+					t.Errorf("expected protocol version %d, got %d", ProtocolVersion, buildResp.ProtocolVersion)
+				}
 				if test.status == http.StatusOK {
 					if strings.Contains(buildResp.BuildLogs, "error") {
 						t.Errorf("expected build logs to not contain error, got %s", buildResp.BuildLogs)
@@ -159,6 +163,10 @@ func TestBuilder(t *testing.T) {
 					if artifacts[0] != test.expected.ArtifactFilename {
 						t.Errorf("expected artifact filename to be %s, got %s", test.expected.ArtifactFilename, artifacts[0])
 					}
+					// the resource usage of the build is only measured on linux
+					if usage := buildResp.ResourceUsage; runtime.GOOS == "linux" && (usage == nil || usage.PeakMemoryBytes <= 0) {
+						t.Errorf("expected build resource usage with peak memory, got %+v", usage)
+					}
 				} else {
 					if !strings.Contains(buildResp.BuildLogs, "error") {
 						t.Errorf("expected build logs to contain error, got %s", buildResp.BuildLogs)
@@ -167,5 +175,30 @@ func TestBuilder(t *testing.T) {
 
 			})
 		}
+
+		t.Run("should reject newer protocol version", func(t *testing.T) {
+			body, err := json.Marshal(&PackageBuildRequest{
+				ProtocolVersion: ProtocolVersion + 1,
+				SrcPkgFilename:  "test4",
+				BuildCommand:    "ls",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			builder.Handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+			resp := w.Result()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status code %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+			var buildResp PackageBuildResponse
+			err = json.NewDecoder(resp.Body).Decode(&buildResp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buildResp.ProtocolVersion != ProtocolVersion || !strings.Contains(buildResp.BuildLogs, "protocol") {
+				t.Errorf("expected protocol version %d with the mismatch, got %+v", ProtocolVersion, buildResp)
+			}
+		})
 	})
 }
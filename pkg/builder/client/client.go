@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -31,6 +33,7 @@ import (
 
 	"github.com/fission/fission/pkg/builder"
 	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/utils/authtoken"
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
 
@@ -40,8 +43,34 @@ type (
 		url        string
 		httpClient *retryablehttp.Client
 	}
+
+	// ProtocolMismatchError is a builder speaking a protocol version the
+	// builder manager can't build with, e.g. a builder pod left behind by
+	// an upgrade.
+	ProtocolMismatchError struct {
+		// Local is the protocol version of the builder manager.
+		Local int
+		// Remote is the protocol version of the builder.
+		Remote int
+		// StatusCode is the HTTP status code of the builder answer.
+		StatusCode int
+		// Err is the error parsing the builder answer, if it failed.
+		Err error
+	}
 )
 
+func (e *ProtocolMismatchError) Error() string {
+	msg := fmt.Sprintf("buildermgr speaks v%d, builder speaks v%d", e.Local, e.Remote)
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *ProtocolMismatchError) Unwrap() error {
+	return e.Err
+}
+
 func MakeClient(logger *zap.Logger, builderUrl string) *Client {
 	hc := retryablehttp.NewClient()
 	hc.HTTPClient.Transport = otelhttp.NewTransport(hc.HTTPClient.Transport)
@@ -52,10 +81,40 @@ func MakeClient(logger *zap.Logger, builderUrl string) *Client {
 	}
 }
 
+// WithAuthToken returns a copy of the client sending the token of the
+// builder pods with each request. An empty token is not sent.
+func (c *Client) WithAuthToken(token string) *Client {
+	if len(token) == 0 {
+		return c
+	}
+	httpClient := *c.httpClient.HTTPClient
+	httpClient.Transport = &authtoken.Transport{Token: token, Base: c.httpClient.HTTPClient.Transport}
+	// the retryable client holds sync.Onces, copy its settings only
+	hc := &retryablehttp.Client{
+		HTTPClient:      &httpClient,
+		Logger:          c.httpClient.Logger,
+		RetryWaitMin:    c.httpClient.RetryWaitMin,
+		RetryWaitMax:    c.httpClient.RetryWaitMax,
+		RetryMax:        c.httpClient.RetryMax,
+		RequestLogHook:  c.httpClient.RequestLogHook,
+		ResponseLogHook: c.httpClient.ResponseLogHook,
+		CheckRetry:      c.httpClient.CheckRetry,
+		Backoff:         c.httpClient.Backoff,
+		ErrorHandler:    c.httpClient.ErrorHandler,
+	}
+	return &Client{
+		logger:     c.logger,
+		url:        c.url,
+		httpClient: hc,
+	}
+}
+
 func (c *Client) Build(ctx context.Context, req *builder.PackageBuildRequest) (*builder.PackageBuildResponse, error) {
 	logger := otelUtils.LoggerWithTraceID(ctx, c.logger)
 
-	body, err := json.Marshal(req)
+	versioned := *req
+	versioned.ProtocolVersion = builder.ProtocolVersion
+	body, err := json.Marshal(&versioned)
 	if err != nil {
 		return nil, errors.Wrap(err, "error marshaling json")
 	}
@@ -72,12 +131,53 @@ func (c *Client) Build(ctx context.Context, req *builder.PackageBuildRequest) (*
 		return nil, err
 	}
 
+	// unknown fields of newer builders are ignored
 	pkgBuildResp := builder.PackageBuildResponse{}
 	err = json.Unmarshal(rBody, &pkgBuildResp)
 	if err != nil {
 		logger.Error("error parsing resp body", zap.Error(err))
-		return nil, err
+		if !json.Valid(rBody) {
+			return nil, err
+		}
+		// the answer of a builder speaking another protocol, the fields
+		// that parse are set, its version too if it has one
+		return nil, &ProtocolMismatchError{
+			Local:      builder.ProtocolVersion,
+			Remote:     pkgBuildResp.Version(),
+			StatusCode: resp.StatusCode,
+			Err:        errors.Wrap(err, "error parsing builder response"),
+		}
+	}
+	// builders reject the requests of newer protocol versions
+	remote := pkgBuildResp.Version()
+	if remote < builder.MinProtocolVersion || (remote < builder.ProtocolVersion && resp.StatusCode == http.StatusBadRequest) {
+		return &pkgBuildResp, &ProtocolMismatchError{
+			Local:      builder.ProtocolVersion,
+			Remote:     remote,
+			StatusCode: resp.StatusCode,
+		}
 	}
 
 	return &pkgBuildResp, ferror.MakeErrorFromHTTP(resp)
 }
+
+// Status returns the shared volume disk usage and the workspaces known to the builder.
+func (c *Client) Status(ctx context.Context) (*builder.BuilderStatus, error) {
+	resp, err := ctxhttp.Get(ctx, c.httpClient.StandardClient(), c.url+"/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	err = ferror.MakeErrorFromHTTP(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	status := builder.BuilderStatus{}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing builder status")
+	}
+	return &status, nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fission/fission/pkg/builder"
+	"github.com/fission/fission/pkg/utils/authtoken"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestBuildProtocolVersion(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		status   int
+		body     string
+		mismatch bool
+		remote   int
+	}{
+		{name: "current builder", status: http.StatusOK,
+			body: `{"protocolVersion": 2, "artifactFilename": "deploy", "buildLogs": ""}`},
+		{name: "unknown fields", status: http.StatusOK,
+			body: `{"protocolVersion": 3, "artifactFilename": "deploy", "buildLogs": "", "cacheHit": true}`},
+		{name: "version 1 builder", status: http.StatusOK,
+			body: `{"artifactFilename": "deploy", "buildLogs": ""}`},
+		{name: "unparsable answer", status: http.StatusOK,
+			body: `{"artifactFilename": ["deploy"], "buildLogs": ""}`, mismatch: true, remote: 1},
+		{name: "newer request rejected", status: http.StatusBadRequest,
+			body: `{"protocolVersion": 1, "buildLogs": "builder speaks protocol v1"}`, mismatch: true, remote: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var req builder.PackageBuildRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&req)
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			_, err := MakeClient(loggerfactory.GetLogger(), server.URL).Build(context.Background(), &builder.PackageBuildRequest{SrcPkgFilename: "src"})
+			if req.ProtocolVersion != builder.ProtocolVersion {
+				t.Errorf("Expected request of protocol version %d, got %d", builder.ProtocolVersion, req.ProtocolVersion)
+			}
+			var mismatch *ProtocolMismatchError
+			if errors.As(err, &mismatch) != test.mismatch {
+				t.Fatalf("Expected protocol mismatch %v, got %v", test.mismatch, err)
+			}
+			if !test.mismatch && err != nil {
+				t.Fatalf("Expected build to succeed, got %v", err)
+			}
+			if test.mismatch && (mismatch.Local != builder.ProtocolVersion || mismatch.Remote != test.remote || mismatch.StatusCode != test.status) {
+				t.Errorf("Expected builder of version %d answering %d, got %+v", test.remote, test.status, mismatch)
+			}
+		})
+	}
+}
+
+func TestWithAuthTokenKeepsSettings(t *testing.T) {
+	c := MakeClient(loggerfactory.GetLogger(), "http://builder")
+	c.httpClient.RetryMax = 1
+	c.httpClient.HTTPClient.Timeout = time.Minute
+
+	authed := c.WithAuthToken("t0ken")
+	if authed.httpClient.RetryMax != 1 || authed.httpClient.HTTPClient.Timeout != time.Minute {
+		t.Errorf("Expected the client settings kept, got %d retries and timeout %v",
+			authed.httpClient.RetryMax, authed.httpClient.HTTPClient.Timeout)
+	}
+	if _, ok := c.httpClient.HTTPClient.Transport.(*authtoken.Transport); ok {
+		t.Errorf("Expected the original client not to send the token")
+	}
+	if _, ok := authed.httpClient.HTTPClient.Transport.(*authtoken.Transport); !ok {
+		t.Errorf("Expected the client to send the token")
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin
+
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"syscall"
+)
+
+// getDiskUsage returns the usage of the filesystem path is on.
+func getDiskUsage(path string) (*DiskUsage, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return nil, err
+	}
+	total := stat.Blocks * uint64(stat.Bsize)
+	used := total - stat.Bfree*uint64(stat.Bsize)
+	usage := &DiskUsage{
+		TotalBytes: total,
+		UsedBytes:  used,
+	}
+	if total > 0 {
+		usage.UsedPercent = float64(used) / float64(total) * 100
+	}
+	return usage, nil
+}
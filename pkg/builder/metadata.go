@@ -0,0 +1,106 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxMetadataEntries is the maximum number of build metadata entries
+	// exposed to the build command.
+	MaxMetadataEntries = 32
+	// MaxMetadataValueSize is the maximum size in bytes of a build metadata
+	// value, longer values are cut.
+	MaxMetadataValueSize = 1024
+
+	// envMetadataPrefix prefixes the environment variables of the build
+	// metadata, e.g. FISSION_BUILD_META_TEAM for the team entry.
+	envMetadataPrefix = "FISSION_BUILD_META_"
+)
+
+// SanitizeMetadata returns the build metadata safe to expose as environment
+// variables: control characters are dropped from the values and the values
+// are cut to MaxMetadataValueSize bytes. Entries without name are dropped,
+// the first MaxMetadataEntries entries by name are kept.
+func SanitizeMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if len(metadataEnvName(k)) > len(envMetadataPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > MaxMetadataEntries {
+		keys = keys[:MaxMetadataEntries]
+	}
+	sanitized := make(map[string]string, len(keys))
+	for _, k := range keys {
+		sanitized[k] = sanitizeMetadataValue(metadata[k])
+	}
+	return sanitized
+}
+
+func sanitizeMetadataValue(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, v)
+	if len(v) <= MaxMetadataValueSize {
+		return v
+	}
+	cut := MaxMetadataValueSize
+	for cut > 0 && !utf8.RuneStart(v[cut]) {
+		cut--
+	}
+	return v[:cut]
+}
+
+// metadataEnvName returns the environment variable of the build metadata
+// entry: its name upper-cased, with the characters other than letters,
+// digits and underscores replaced with underscores.
+func metadataEnvName(name string) string {
+	return envMetadataPrefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+}
+
+// metadataEnv returns the environment variables of the build metadata,
+// sorted by name.
+func metadataEnv(metadata map[string]string) []string {
+	metadata = SanitizeMetadata(metadata)
+	env := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		env = append(env, metadataEnvName(k)+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestSanitizeMetadata(t *testing.T) {
+	metadata := map[string]string{
+		"team":  "payments\n\x00",
+		"long":  strings.Repeat("é", MaxMetadataValueSize),
+		"   ":   "no name",
+		"app-1": "checkout",
+	}
+	for i := 0; i < MaxMetadataEntries; i++ {
+		metadata[fmt.Sprintf("z%02d", i)] = "x"
+	}
+	sanitized := SanitizeMetadata(metadata)
+	if len(sanitized) != MaxMetadataEntries {
+		t.Errorf("Expected %d entries kept, got %d", MaxMetadataEntries, len(sanitized))
+	}
+	if sanitized["team"] != "payments" {
+		t.Errorf("Expected control characters dropped, got %q", sanitized["team"])
+	}
+	if v := sanitized["long"]; len(v) > MaxMetadataValueSize || !strings.HasPrefix(metadata["long"], v) || len(v) < MaxMetadataValueSize-1 {
+		t.Errorf("Expected value cut at a rune boundary within %d bytes, got %d bytes", MaxMetadataValueSize, len(v))
+	}
+	if _, ok := sanitized["   "]; ok {
+		t.Error("Expected entry without name dropped")
+	}
+
+	env := metadataEnv(map[string]string{"app-1": "checkout", "team": "payments"})
+	if strings.Join(env, " ") != "FISSION_BUILD_META_APP_1=checkout FISSION_BUILD_META_TEAM=payments" {
+		t.Errorf("Unexpected metadata environment %v", env)
+	}
+}
+
+func TestBuilderExposesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	builder := MakeBuilder(loggerfactory.GetLogger(), dir)
+	err := os.WriteFile(dir+"/src", nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(&PackageBuildRequest{
+		SrcPkgFilename: "src",
+		BuildCommand:   "env",
+		Metadata:       map[string]string{"team": "payments"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	builder.Handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	var resp PackageBuildResponse
+	err = json.NewDecoder(w.Result().Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !strings.Contains(resp.BuildLogs, "FISSION_BUILD_META_TEAM=payments\n") {
+		t.Errorf("Expected metadata in the build command environment, got %d: %s", w.Code, resp.BuildLogs)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"os"
+	"syscall"
+)
+
+// getResourceUsage returns the resource usage of the exited build command.
+// The kernel accounts the peak memory of the command and the children it
+// waited for, so no sampling is needed during the build.
+func getResourceUsage(state *os.ProcessState) *BuildResourceUsage {
+	if state == nil {
+		return nil
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+	return &BuildResourceUsage{
+		CPUMilliseconds: (state.UserTime() + state.SystemTime()).Milliseconds(),
+		// ru_maxrss is in kilobytes on Linux
+		PeakMemoryBytes: rusage.Maxrss * 1024,
+	}
+}
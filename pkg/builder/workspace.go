@@ -0,0 +1,323 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	otelUtils "github.com/fission/fission/pkg/utils/otel"
+)
+
+const (
+	// supported environment variables for workspace cleanup
+	envWorkspaceRetention = "BUILDER_WORKSPACE_RETENTION"
+	envWorkspaceMaxCount  = "BUILDER_WORKSPACE_MAX_COUNT"
+	envWorkspaceMaxSize   = "BUILDER_WORKSPACE_MAX_SIZE"
+
+	defaultWorkspaceRetention = time.Hour
+	defaultWorkspaceMaxCount  = 50
+	janitorInterval           = time.Minute
+
+	WorkspaceStatusBuilding  = "building"
+	WorkspaceStatusSucceeded = "succeeded"
+	WorkspaceStatusFailed    = "failed"
+)
+
+type (
+	// Workspace is the set of files a build leaves in the shared volume.
+	// The fetched source package, the deployment package and its archive
+	// all share the source package filename as prefix.
+	Workspace struct {
+		BuildID   string    `json:"buildID,omitempty"`
+		Name      string    `json:"name"`
+		Status    string    `json:"status"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+		SizeBytes int64     `json:"sizeBytes"`
+	}
+
+	DiskUsage struct {
+		TotalBytes  uint64  `json:"totalBytes"`
+		UsedBytes   uint64  `json:"usedBytes"`
+		UsedPercent float64 `json:"usedPercent"`
+	}
+
+	// BuilderStatus is returned by the builder status endpoint.
+	BuilderStatus struct {
+		DiskUsage  *DiskUsage  `json:"diskUsage,omitempty"`
+		Workspaces []Workspace `json:"workspaces"`
+	}
+
+	// JanitorConfig controls when workspaces are removed from the shared volume.
+	// Zero MaxCount or MaxSizeBytes means no limit.
+	JanitorConfig struct {
+		Retention    time.Duration
+		MaxCount     int
+		MaxSizeBytes int64
+	}
+
+	workspaceRegistry struct {
+		mutex      sync.Mutex
+		workspaces map[string]*Workspace
+	}
+)
+
+func makeWorkspaceRegistry() *workspaceRegistry {
+	return &workspaceRegistry{
+		workspaces: make(map[string]*Workspace),
+	}
+}
+
+func (r *workspaceRegistry) register(buildID string, name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.workspaces[name] = &Workspace{
+		BuildID:   buildID,
+		Name:      name,
+		Status:    WorkspaceStatusBuilding,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (r *workspaceRegistry) setStatus(name string, status string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ws, ok := r.workspaces[name]; ok {
+		ws.Status = status
+		ws.UpdatedAt = time.Now()
+	}
+}
+
+func (r *workspaceRegistry) list() []Workspace {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	list := make([]Workspace, 0, len(r.workspaces))
+	for _, ws := range r.workspaces {
+		list = append(list, *ws)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.After(list[j].UpdatedAt)
+	})
+	return list
+}
+
+// GetJanitorConfig reads the workspace cleanup settings from environment variables.
+func GetJanitorConfig(logger *zap.Logger) JanitorConfig {
+	config := JanitorConfig{
+		Retention: defaultWorkspaceRetention,
+		MaxCount:  defaultWorkspaceMaxCount,
+	}
+	if v := os.Getenv(envWorkspaceRetention); len(v) > 0 {
+		retention, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Error("failed to parse workspace retention, using default", zap.String("value", v), zap.Error(err))
+		} else {
+			config.Retention = retention
+		}
+	}
+	if v := os.Getenv(envWorkspaceMaxCount); len(v) > 0 {
+		count, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Error("failed to parse workspace max count, using default", zap.String("value", v), zap.Error(err))
+		} else {
+			config.MaxCount = count
+		}
+	}
+	if v := os.Getenv(envWorkspaceMaxSize); len(v) > 0 {
+		size, err := resource.ParseQuantity(v)
+		if err != nil {
+			logger.Error("failed to parse workspace max size, ignoring", zap.String("value", v), zap.Error(err))
+		} else {
+			config.MaxSizeBytes = size.Value()
+		}
+	}
+	return config
+}
+
+// StatusHandler reports the shared volume disk usage and the workspaces
+// known to the builder, including the most recent failed one.
+func (builder *Builder) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), builder.logger)
+
+	status := BuilderStatus{
+		Workspaces: builder.workspaces.list(),
+	}
+	usage, err := getDiskUsage(builder.sharedVolumePath)
+	if err != nil {
+		logger.Error("error getting disk usage of shared volume", zap.Error(err))
+	} else {
+		status.DiskUsage = usage
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		logger.Error("error encoding status", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+// RunJanitor periodically removes stale workspaces from the shared volume
+// until the context is done.
+func (builder *Builder) RunJanitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			builder.cleanupWorkspaces(time.Now())
+		}
+	}
+}
+
+// cleanupWorkspaces removes workspaces older than the retention period or
+// beyond the count/size budget, oldest first. Workspaces of running builds
+// and the most recent failed workspace are always kept. Files that don't
+// belong to any known workspace, e.g. partial downloads of failed fetches,
+// are removed once they are older than the retention period.
+func (builder *Builder) cleanupWorkspaces(now time.Time) {
+	entries, err := os.ReadDir(builder.sharedVolumePath)
+	if err != nil {
+		builder.logger.Error("error reading shared volume", zap.Error(err))
+		return
+	}
+
+	workspaces := builder.workspaces.list()
+	files := make(map[string][]string)
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		path := filepath.Join(builder.sharedVolumePath, entry.Name())
+		// pick the longest matching name, the deployment package of one
+		// workspace may look like the prefix of another one
+		owner := ""
+		for _, ws := range workspaces {
+			if len(ws.Name) > len(owner) && (entry.Name() == ws.Name ||
+				strings.HasPrefix(entry.Name(), ws.Name+"-") || strings.HasPrefix(entry.Name(), ws.Name+".")) {
+				owner = ws.Name
+			}
+		}
+		if len(owner) > 0 {
+			files[owner] = append(files[owner], path)
+			sizes[owner] += pathSize(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > builder.janitorConfig.Retention {
+			builder.removePaths(path)
+		}
+	}
+
+	keepFailed := ""
+	for _, ws := range workspaces {
+		if ws.Status == WorkspaceStatusFailed {
+			keepFailed = ws.Name
+			break
+		}
+	}
+
+	var count int
+	var totalSize int64
+	// workspaces are sorted by last update, newest first
+	for _, ws := range workspaces {
+		builder.workspaces.setSize(ws.Name, sizes[ws.Name])
+		if ws.Status == WorkspaceStatusBuilding || ws.Name == keepFailed {
+			count++
+			totalSize += sizes[ws.Name]
+			continue
+		}
+		expired := now.Sub(ws.UpdatedAt) > builder.janitorConfig.Retention
+		overCount := builder.janitorConfig.MaxCount > 0 && count >= builder.janitorConfig.MaxCount
+		overSize := builder.janitorConfig.MaxSizeBytes > 0 && totalSize+sizes[ws.Name] > builder.janitorConfig.MaxSizeBytes
+		if expired || overCount || overSize {
+			builder.logger.Info("removing build workspace",
+				zap.String("workspace", ws.Name),
+				zap.String("build_id", ws.BuildID),
+				zap.String("status", ws.Status),
+				zap.Bool("expired", expired),
+				zap.Bool("over_count", overCount),
+				zap.Bool("over_size", overSize))
+			builder.removePaths(files[ws.Name]...)
+			builder.workspaces.remove(ws.Name)
+			continue
+		}
+		count++
+		totalSize += sizes[ws.Name]
+	}
+}
+
+func (builder *Builder) removePaths(paths ...string) {
+	for _, path := range paths {
+		err := os.RemoveAll(path)
+		if err != nil {
+			builder.logger.Error("error removing file from shared volume", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+func (r *workspaceRegistry) setSize(name string, size int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ws, ok := r.workspaces[name]; ok {
+		ws.SizeBytes = size
+	}
+}
+
+func (r *workspaceRegistry) remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.workspaces, name)
+}
+
+// pathSize returns the total size of the files under path.
+func pathSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// makeTestWorkspace creates the source and deployment package directories
+// of a build in the shared volume and registers the workspace.
+func makeTestWorkspace(t *testing.T, builder *Builder, name string, status string, updatedAt time.Time) {
+	t.Helper()
+	for _, dir := range []string{name, name + "-abcdef"} {
+		err := os.MkdirAll(filepath.Join(builder.sharedVolumePath, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(builder.sharedVolumePath, dir, "file"), make([]byte, 100), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder.workspaces.register(name+"-build", name)
+	builder.workspaces.workspaces[name].Status = status
+	builder.workspaces.workspaces[name].UpdatedAt = updatedAt
+}
+
+func workspaceExists(builder *Builder, name string) bool {
+	_, err := os.Stat(filepath.Join(builder.sharedVolumePath, name))
+	return err == nil
+}
+
+func TestCleanupWorkspacesRetention(t *testing.T) {
+	builder := MakeBuilder(loggerfactory.GetLogger(), t.TempDir())
+	builder.janitorConfig = JanitorConfig{Retention: time.Hour}
+
+	now := time.Now()
+	makeTestWorkspace(t, builder, "old-failed", WorkspaceStatusFailed, now.Add(-3*time.Hour))
+	makeTestWorkspace(t, builder, "recent-failed", WorkspaceStatusFailed, now.Add(-2*time.Hour))
+	makeTestWorkspace(t, builder, "old-succeeded", WorkspaceStatusSucceeded, now.Add(-2*time.Hour))
+	makeTestWorkspace(t, builder, "running", WorkspaceStatusBuilding, now.Add(-2*time.Hour))
+	makeTestWorkspace(t, builder, "new-succeeded", WorkspaceStatusSucceeded, now)
+
+	// leftover of a failed fetch that never reached the builder
+	orphan := filepath.Join(builder.sharedVolumePath, "orphan.tmp")
+	err := os.WriteFile(orphan, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(orphan, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder.cleanupWorkspaces(now)
+
+	for name, expected := range map[string]bool{
+		"old-failed":           false,
+		"old-failed-abcdef":    false,
+		"old-succeeded":        false,
+		"old-succeeded-abcdef": false,
+		"orphan.tmp":           false,
+		"recent-failed":        true,
+		"recent-failed-abcdef": true,
+		"running":              true,
+		"new-succeeded":        true,
+	} {
+		if workspaceExists(builder, name) != expected {
+			t.Errorf("Expected %s to exist: %v", name, expected)
+		}
+	}
+	if n := len(builder.workspaces.list()); n != 3 {
+		t.Errorf("Expected 3 workspaces registered, got %d", n)
+	}
+}
+
+func TestCleanupWorkspacesBudget(t *testing.T) {
+	builder := MakeBuilder(loggerfactory.GetLogger(), t.TempDir())
+	builder.janitorConfig = JanitorConfig{Retention: time.Hour, MaxCount: 2}
+
+	now := time.Now()
+	makeTestWorkspace(t, builder, "ws-1", WorkspaceStatusSucceeded, now.Add(-3*time.Minute))
+	makeTestWorkspace(t, builder, "ws-2", WorkspaceStatusSucceeded, now.Add(-2*time.Minute))
+	makeTestWorkspace(t, builder, "ws-3", WorkspaceStatusSucceeded, now.Add(-time.Minute))
+
+	builder.cleanupWorkspaces(now)
+
+	if workspaceExists(builder, "ws-1") {
+		t.Error("Expected oldest workspace beyond count budget to be removed")
+	}
+	if !workspaceExists(builder, "ws-2") || !workspaceExists(builder, "ws-3") {
+		t.Error("Expected newest workspaces to be kept")
+	}
+
+	// each workspace holds 200 bytes, so only the newest one fits
+	builder.janitorConfig = JanitorConfig{Retention: time.Hour, MaxSizeBytes: 300}
+	builder.cleanupWorkspaces(now)
+
+	if workspaceExists(builder, "ws-2") {
+		t.Error("Expected workspace beyond size budget to be removed")
+	}
+	if !workspaceExists(builder, "ws-3") {
+		t.Error("Expected newest workspace to be kept")
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fission/fission/pkg/utils/httpserver"
+	"github.com/fission/fission/pkg/utils/metrics"
+	otelUtils "github.com/fission/fission/pkg/utils/otel"
+)
+
+const (
+	// standbyRetryAfter is the Retry-After, in seconds, of build requests
+	// a standby replica can't forward to the leader.
+	standbyRetryAfter = "2"
+	// headerForwardedByStandby marks the build requests forwarded by a
+	// standby replica, they're not forwarded again.
+	headerForwardedByStandby = "X-Fission-Buildermgr-Forwarded"
+)
+
+// leaderClient is the http client of the requests forwarded to the leader.
+var leaderClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// builderMgrAPI serves the administrative API of the builder manager.
+type builderMgrAPI struct {
+	logger   *zap.Logger
+	migrator *literalMigrator
+	impact   *impactResolver
+	builds   *packageWatcher
+
+	// k8sClient looks up the pod of the leader in namespace, whose API
+	// is served on port like ours.
+	k8sClient kubernetes.Interface
+	namespace string
+	port      int
+	// leader is the identity, the pod name, of the replica leading the
+	// package builds.
+	leader atomic.Pointer[string]
+	// readiness are the checks of the readiness endpoint.
+	readiness *readinessChecks
+}
+
+// setLeader records the identity of the replica leading the package builds.
+func (api *builderMgrAPI) setLeader(identity string) {
+	api.leader.Store(&identity)
+}
+
+// forwardToLeader forwards the build request to the leader, the only
+// replica knowing the running builds. It returns false if the request
+// can't be forwarded.
+func (api *builderMgrAPI) forwardToLeader(w http.ResponseWriter, r *http.Request) bool {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), api.logger)
+	leader := api.leader.Load()
+	if leader == nil || api.k8sClient == nil || len(r.Header.Get(headerForwardedByStandby)) > 0 {
+		return false
+	}
+	pod, err := api.k8sClient.CoreV1().Pods(api.namespace).Get(r.Context(), *leader, metav1.GetOptions{})
+	if err != nil || len(pod.Status.PodIP) == 0 {
+		logger.Warn("error looking up the builder manager leader", zap.String("leader", *leader), zap.Error(err))
+		return false
+	}
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(api.port)), r.URL.RequestURI())
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, r.Body)
+	if err != nil {
+		logger.Error("error creating request to the builder manager leader", zap.Error(err))
+		return false
+	}
+	req.Header.Set(headerForwardedByStandby, "true")
+	resp, err := leaderClient.Do(req)
+	if err != nil {
+		logger.Warn("error forwarding request to the builder manager leader", zap.String("leader", *leader), zap.Error(err))
+		return false
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); len(contentType) > 0 {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
+	return true
+}
+
+func (api *builderMgrAPI) migrateLiteralsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), api.logger)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("error reading request body", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req LiteralMigrationRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		logger.Error("error parsing request body", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Threshold <= 0 {
+		http.Error(w, fmt.Sprintf("invalid threshold %d, must be positive", req.Threshold), http.StatusBadRequest)
+		return
+	}
+	if len(req.Name) > 0 && len(req.Namespace) == 0 {
+		http.Error(w, "package name needs a namespace", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := api.migrator.migrate(r.Context(), &req)
+	if err != nil {
+		logger.Error("error migrating package literals", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rBody, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("error encoding literal migration response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(rBody)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+func (api *builderMgrAPI) packageImpactHandler(w http.ResponseWriter, r *http.Request) {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), api.logger)
+	vars := mux.Vars(r)
+	impact, err := api.impact.impact(vars["namespace"], vars["name"])
+	if err != nil {
+		code := http.StatusInternalServerError
+		if k8serrors.IsNotFound(err) {
+			code = http.StatusNotFound
+		} else if errors.Is(err, errImpactNotReady) {
+			code = http.StatusServiceUnavailable
+		}
+		logger.Error("error resolving package impact", zap.String("namespace", vars["namespace"]), zap.String("name", vars["name"]), zap.Error(err))
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	rBody, err := json.Marshal(impact)
+	if err != nil {
+		logger.Error("error encoding package impact", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(rBody)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+// servedByLeader serves the build request of a standby replica by the
+// leader, the only replica knowing the builds. It returns false if the
+// replica leads and must serve the request itself.
+func (api *builderMgrAPI) servedByLeader(w http.ResponseWriter, r *http.Request) bool {
+	if api.builds.leading.Load() {
+		return false
+	}
+	if api.forwardToLeader(w, r) {
+		return true
+	}
+	w.Header().Set("Retry-After", standbyRetryAfter)
+	http.Error(w, "builder manager replica is standing by and can't reach the leader, retry later", http.StatusServiceUnavailable)
+	return true
+}
+
+func (api *builderMgrAPI) cancelBuildHandler(w http.ResponseWriter, r *http.Request) {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), api.logger)
+	vars := mux.Vars(r)
+	// the builds run on the leader, a standby can't tell whether the
+	// package has one
+	if api.servedByLeader(w, r) {
+		return
+	}
+	if !api.builds.CancelBuild(vars["namespace"], vars["name"]) {
+		http.Error(w, fmt.Sprintf("package %s/%s has no build to cancel", vars["namespace"], vars["name"]), http.StatusNotFound)
+		return
+	}
+	logger.Info("canceled package build by request", zap.String("namespace", vars["namespace"]), zap.String("name", vars["name"]))
+	// the package goes into canceled state once the build stopped
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (api *builderMgrAPI) listBuildsHandler(w http.ResponseWriter, r *http.Request) {
+	if api.servedByLeader(w, r) {
+		return
+	}
+	api.writeJSON(w, r, api.builds.InFlightBuilds("", ""))
+}
+
+func (api *builderMgrAPI) getBuildHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if api.servedByLeader(w, r) {
+		return
+	}
+	builds := api.builds.InFlightBuilds(vars["namespace"], vars["name"])
+	if len(builds) == 0 {
+		http.Error(w, fmt.Sprintf("package %s/%s has no build in flight", vars["namespace"], vars["name"]), http.StatusNotFound)
+		return
+	}
+	// the build of the newest package version, older ones are canceled
+	api.writeJSON(w, r, builds[len(builds)-1])
+}
+
+// writeJSON writes the JSON encoding of v as response.
+func (api *builderMgrAPI) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	api.writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+// writeJSONStatus writes the JSON encoding of v as response with the
+// status code.
+func (api *builderMgrAPI) writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	logger := otelUtils.LoggerWithTraceID(r.Context(), api.logger)
+	rBody, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("error encoding response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(rBody)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+// healthHandler tells the process is alive.
+func (api *builderMgrAPI) healthHandler(w http.ResponseWriter, r *http.Request) {
+	api.writeJSON(w, r, map[string]string{"status": "ok"})
+}
+
+// readyHandler tells whether the replica is ready, with the failed
+// readiness checks otherwise.
+func (api *builderMgrAPI) readyHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := api.readiness.check(r.Context(), api.leader.Load())
+	status := http.StatusOK
+	if !readiness.Ready {
+		otelUtils.LoggerWithTraceID(r.Context(), api.logger).Info("builder manager not ready", zap.Any("failed", readiness.Failed))
+		status = http.StatusServiceUnavailable
+	}
+	api.writeJSONStatus(w, r, status, readiness)
+}
+
+func (api *builderMgrAPI) GetHandler() http.Handler {
+	r := mux.NewRouter()
+	r.Use(metrics.HTTPMetricMiddleware)
+	r.HandleFunc("/v1/packages/migrate-literals", api.migrateLiteralsHandler).Methods("POST")
+	r.HandleFunc("/v2/packages/{namespace}/{name}/impact", api.packageImpactHandler).Methods("GET")
+	r.HandleFunc("/v2/packages/{namespace}/{name}/cancel", api.cancelBuildHandler).Methods("POST")
+	r.HandleFunc("/v2/builds", api.listBuildsHandler).Methods("GET")
+	r.HandleFunc("/v2/builds/{namespace}/{name}", api.getBuildHandler).Methods("GET")
+	r.HandleFunc("/healthz", api.healthHandler).Methods("GET")
+	r.HandleFunc("/readyz", api.readyHandler).Methods("GET")
+	return r
+}
+
+// Serve starts an HTTP server.
+func (api *builderMgrAPI) Serve(ctx context.Context, port int) {
+	handler := otelUtils.GetHandlerWithOTEL(api.GetHandler(), "fission-buildermgr", otelUtils.UrlsToIgnore("/healthz", "/readyz"))
+	httpserver.StartServer(ctx, api.logger, "buildermgr", fmt.Sprintf("%d", port), handler)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// archiveGCTimeout is the deadline of the deletion of the superseded
+// deployment archives after a build.
+const archiveGCTimeout = 30 * time.Second
+
+// archiveGC deletes the deployment archives superseded by successful
+// rebuilds from the storage service, unless another package still
+// references them. Functions reference archives through their packages
+// only. The archives whose deletion failed are tried again after the next
+// successful build.
+type archiveGC struct {
+	mu sync.Mutex
+	// failed are the archive URLs whose deletion failed, with the
+	// namespace of the package they were built for
+	failed map[string]string
+}
+
+func newArchiveGC() *archiveGC {
+	return &archiveGC{failed: make(map[string]string)}
+}
+
+// take returns the archives whose deletion failed and forgets them, the
+// ones failing again are added back.
+func (gc *archiveGC) take() map[string]string {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	failed := gc.failed
+	gc.failed = make(map[string]string)
+	return failed
+}
+
+func (gc *archiveGC) deleteFailed(archiveURL string, namespace string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.failed[archiveURL] = namespace
+}
+
+// supersededArchive returns the URL of the deployment archive of the
+// package before its build if the build replaced it, empty otherwise.
+func supersededArchive(oldPkg, pkg *fv1.Package) string {
+	old := oldPkg.Spec.Deployment
+	if old.Type != fv1.ArchiveTypeUrl || len(old.URL) == 0 || old.URL == pkg.Spec.Deployment.URL {
+		return ""
+	}
+	return old.URL
+}
+
+// collectArchives deletes the deployment archive the successful build of
+// pkg superseded, along with the archives whose deletion failed before.
+// Failures are logged, they never fail the build.
+func (pkgw *packageWatcher) collectArchives(oldPkg, pkg *fv1.Package) {
+	if pkgw.archiveGC == nil {
+		return
+	}
+	archives := pkgw.archiveGC.take()
+	if archiveURL := supersededArchive(oldPkg, pkg); len(archiveURL) > 0 {
+		archives[archiveURL] = pkg.ObjectMeta.Namespace
+	}
+	if len(archives) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(pkgw.buildsCtx, archiveGCTimeout)
+	defer cancel()
+	for archiveURL, namespace := range archives {
+		logger := pkgw.logger.With(zap.String("url", archiveURL), zap.String("namespace", namespace))
+		referenced, err := pkgw.archiveReferenced(pkg, archiveURL)
+		if err != nil {
+			logger.Warn("error looking up references to superseded deployment archive, keeping it for now", zap.Error(err))
+			pkgw.archiveGC.deleteFailed(archiveURL, namespace)
+			continue
+		}
+		if referenced {
+			logger.Info("superseded deployment archive still referenced by a package, keeping it")
+			continue
+		}
+		deleted, err := pkgw.deleteStorageArchive(ctx, namespace, archiveURL)
+		if err != nil {
+			logger.Warn("error deleting superseded deployment archive, retrying after the next build", zap.Error(err))
+			pkgw.archiveGC.deleteFailed(archiveURL, namespace)
+			continue
+		}
+		if deleted {
+			logger.Info("deleted superseded deployment archive")
+		}
+	}
+}
+
+// archiveReferenced tells whether a package references the archive URL as
+// its source or deployment archive. The packages are looked up in the
+// package informers, pkg is the up to date version of the package just
+// built whose informer copy may lag behind.
+func (pkgw *packageWatcher) archiveReferenced(pkg *fv1.Package, archiveURL string) (bool, error) {
+	if pkg.Spec.Source.URL == archiveURL || pkg.Spec.Deployment.URL == archiveURL {
+		return true, nil
+	}
+	return pkgw.archiveReferencedByOthers(pkg, archiveURL)
+}
+
+// archiveReferencedByOthers tells whether another package than pkg
+// references the archive URL as its source or deployment archive.
+func (pkgw *packageWatcher) archiveReferencedByOthers(pkg *fv1.Package, archiveURL string) (bool, error) {
+	if len(pkgw.pkgInformer) == 0 {
+		return false, errors.New("no package informer to look up the packages")
+	}
+	for namespace, informer := range pkgw.pkgInformer {
+		if !informer.HasSynced() {
+			return false, errors.Errorf("package informer of namespace %q not synced", namespace)
+		}
+		for _, obj := range informer.GetStore().List() {
+			other, ok := obj.(*fv1.Package)
+			if !ok {
+				continue
+			}
+			if other.ObjectMeta.Namespace == pkg.ObjectMeta.Namespace && other.ObjectMeta.Name == pkg.ObjectMeta.Name {
+				continue
+			}
+			if other.Spec.Source.URL == archiveURL || other.Spec.Deployment.URL == archiveURL {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// deleteStorageArchive deletes the archive at the URL if it's kept by the
+// storage service of the namespace. Archives stored elsewhere, e.g. at
+// external URLs, are left alone and deleted is false.
+func (pkgw *packageWatcher) deleteStorageArchive(ctx context.Context, namespace string, archiveURL string) (deleted bool, err error) {
+	storageSvcURL := storageSvcURLFor(pkgw.logger, namespace, pkgw.deps.StorageSvcURL)
+	store, sourceStore := pkgw.deps.storageSvcStores(storageSvcURL)
+	id, target, ok := sourceStore.ArchiveID(archiveURL)
+	if !ok {
+		return false, nil
+	}
+	err = store.DeleteTarget(ctx, id, target)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package buildermgr
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+)
+
+func storedArchive(id string) fv1.Archive {
+	return fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/v1/archive?id=" + id}
+}
+
+func TestCollectSupersededArchives(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	store := &fakeArchiveStore{}
+	tpw.deps.logStore = store
+	tpw.archiveGC = newArchiveGC()
+
+	// another package shares the deployment archive of the first build
+	shared := tpw.pkg.DeepCopy()
+	shared.ObjectMeta.Name = "shared"
+	shared.Spec.Deployment = storedArchive("shared")
+	_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(ctx, shared, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating package: %v", err)
+	}
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+	go pkgInformer.Run(ctx.Done())
+	if !k8sCache.WaitForCacheSync(ctx.Done(), pkgInformer.HasSynced) {
+		t.Fatal("Package informer not synced")
+	}
+
+	rebuild := func(oldArchive, newArchive fv1.Archive) {
+		t.Helper()
+		oldPkg := tpw.pkg.DeepCopy()
+		oldPkg.Spec.Deployment = oldArchive
+		pkg := tpw.pkg.DeepCopy()
+		pkg.Spec.Deployment = newArchive
+		tpw.collectArchives(oldPkg, pkg)
+	}
+
+	rebuild(storedArchive("shared"), storedArchive("build-1"))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected archive shared with another package kept, got deleted %v", store.deleted)
+	}
+
+	// archives at external URLs aren't ours to delete
+	rebuild(fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "https://example.com/deploy.zip"}, storedArchive("build-2"))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected external archive kept, got deleted %v", store.deleted)
+	}
+
+	// failed deletions are retried after the next build
+	store.deleteErr = errors.New("storage service unavailable")
+	rebuild(storedArchive("build-2"), storedArchive("build-3"))
+	if len(store.deleted) != 0 || len(tpw.archiveGC.failed) != 1 {
+		t.Fatalf("Expected failed deletion remembered, got deleted %v and failed %v", store.deleted, tpw.archiveGC.failed)
+	}
+	store.deleteErr = nil
+	rebuild(storedArchive("build-3"), storedArchive("build-4"))
+	if deleted := map[string]bool{"build-2": true, "build-3": true}; len(store.deleted) != 2 ||
+		!deleted[store.deleted[0]] || !deleted[store.deleted[1]] || len(tpw.archiveGC.failed) != 0 {
+		t.Errorf("Expected superseded archives deleted, got deleted %v and failed %v", store.deleted, tpw.archiveGC.failed)
+	}
+
+	// an archive the build kept isn't superseded
+	rebuild(storedArchive("build-4"), storedArchive("build-4"))
+	if len(store.deleted) != 2 {
+		t.Errorf("Expected the current archive kept, got deleted %v", store.deleted)
+	}
+}
+
+func TestCollectArchivesWithoutPackageInformer(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	store := &fakeArchiveStore{}
+	tpw.deps.logStore = store
+	tpw.archiveGC = newArchiveGC()
+
+	oldPkg := tpw.pkg.DeepCopy()
+	oldPkg.Spec.Deployment = storedArchive("build-1")
+	tpw.collectArchives(oldPkg, tpw.pkg)
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected archive kept when the packages can't be looked up, got deleted %v", store.deleted)
+	}
+	if !reflect.DeepEqual(tpw.archiveGC.failed, map[string]string{oldPkg.Spec.Deployment.URL: testNamespace}) {
+		t.Errorf("Expected archive deletion retried later, got %v", tpw.archiveGC.failed)
+	}
+}
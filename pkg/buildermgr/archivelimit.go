@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+	"golang.org/x/net/context/ctxhttp"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/storagesvc"
+)
+
+type (
+	// archiveSizeLimits are the maximum sizes in bytes of the source
+	// archive and of the deployment archive of a build, 0 means no limit.
+	archiveSizeLimits struct {
+		Source     int64
+		Deployment int64
+	}
+
+	// archiveSizeLimitsKey is the context key of the archive size limits
+	// of the build.
+	archiveSizeLimitsKey struct{}
+
+	// archiveTooLargeError is a build failing because of an archive
+	// exceeding its size limit, retrying doesn't help.
+	archiveTooLargeError struct {
+		error
+	}
+)
+
+func (e archiveTooLargeError) Unwrap() error {
+	return e.error
+}
+
+// archiveSizeMessage tells the archive exceeds its size limit.
+func archiveSizeMessage(archive string, size int64, limit int64) string {
+	return fmt.Sprintf("%s archive exceeds limit (%s > %s)", archive,
+		humanize.IBytes(uint64(size)), humanize.IBytes(uint64(limit)))
+}
+
+// forEnvironment returns the limits with the overrides of the environment
+// annotations, quantities like "2Gi" where "0" means no limit. Invalid
+// overrides are ignored.
+func (l archiveSizeLimits) forEnvironment(logger *zap.Logger, env *fv1.Environment) archiveSizeLimits {
+	override := func(annotation string, limit int64) int64 {
+		v, ok := env.ObjectMeta.Annotations[annotation]
+		if !ok {
+			return limit
+		}
+		q, err := resource.ParseQuantity(v)
+		if err != nil || q.Sign() < 0 {
+			logger.Warn("invalid archive size limit annotation, using default",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("annotation", annotation),
+				zap.String("value", v))
+			return limit
+		}
+		return q.Value()
+	}
+	return archiveSizeLimits{
+		Source:     override(fv1.ANNOTATION_MAX_SOURCE_ARCHIVE_SIZE, l.Source),
+		Deployment: override(fv1.ANNOTATION_MAX_DEPLOYMENT_ARCHIVE_SIZE, l.Deployment),
+	}
+}
+
+// withArchiveSizeLimits returns the context enforcing the archive size
+// limits on the build.
+func withArchiveSizeLimits(ctx context.Context, limits archiveSizeLimits) context.Context {
+	return context.WithValue(ctx, archiveSizeLimitsKey{}, limits)
+}
+
+// contextArchiveSizeLimits returns the archive size limits of the context,
+// archives aren't limited without them.
+func contextArchiveSizeLimits(ctx context.Context) archiveSizeLimits {
+	limits, _ := ctx.Value(archiveSizeLimitsKey{}).(archiveSizeLimits)
+	return limits
+}
+
+// sourceArchiveSize returns the size in bytes of the source archive, -1 if
+// unknown. URL archives are asked for their size with a HEAD request: the
+// storage service reports the size of its stored archives, other servers
+// their Content-Length. Archives with credentials and git sources aren't
+// sized, the fetcher downloads them.
+func sourceArchiveSize(ctx context.Context, archive fv1.Archive) (int64, error) {
+	if len(archive.Literal) > 0 {
+		return int64(len(archive.Literal)), nil
+	}
+	if archive.Type != fv1.ArchiveTypeUrl || len(archive.URL) == 0 || len(archive.AuthSecret) > 0 {
+		return -1, nil
+	}
+	req, err := http.NewRequest(http.MethodHead, archive.URL, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := ctxhttp.Do(ctx, archiveCheckClient, req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1, nil
+	}
+	if size := resp.Header.Get(storagesvc.HeaderStoredSize); len(size) > 0 {
+		return strconv.ParseInt(size, 10, 64)
+	}
+	return resp.ContentLength, nil
+}
+
+// sourceTooLarge checks the size of the source archive against the limit
+// of the environment before the build is dispatched, and returns why the
+// build must fail if it exceeds it. Archives whose size is unknown are
+// left to the builder.
+func (e *buildExecution) sourceTooLarge(ctx context.Context, pkg *fv1.Package, env *fv1.Environment) string {
+	limit := e.archiveLimits.forEnvironment(e.logger, env).Source
+	if limit <= 0 {
+		return ""
+	}
+	size, err := e.sourceSize(ctx, pkg.Spec.Source)
+	if err != nil {
+		e.logger.Warn("error getting source archive size", zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace), zap.Error(err))
+		return ""
+	}
+	if size <= limit {
+		return ""
+	}
+	msg := archiveSizeMessage("source", size, limit)
+	e.logger.Error(msg, zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace), zap.Int64("size", size), zap.Int64("limit", limit))
+	return msg
+}
@@ -0,0 +1,130 @@
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/storagesvc"
+)
+
+func TestExecuteBuildSourceArchiveSizeLimit(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		override string
+		failed   bool
+	}{
+		{name: "within limit", override: "4Mi"},
+		{name: "exceeds limit", failed: true},
+		{name: "limit disabled by environment", override: "0"},
+		{name: "invalid override", override: "big", failed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			tb.deps.archiveLimits = archiveSizeLimits{Source: 1 << 20}
+			tb.deps.sourceSize = func(ctx context.Context, archive fv1.Archive) (int64, error) {
+				return 2 << 20, nil
+			}
+			if len(test.override) > 0 {
+				env := tb.env.DeepCopy()
+				env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_MAX_SOURCE_ARCHIVE_SIZE: test.override}
+				_, err := tb.fissionClient.CoreV1().Environments(env.ObjectMeta.Namespace).Update(context.Background(), env, metav1.UpdateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+			if !test.failed {
+				if err != nil || result.Status != fv1.BuildStatusSucceeded || tb.builds != 1 {
+					t.Fatalf("Expected the package to be built, got %s after %d builds: %v", result.Status, tb.builds, err)
+				}
+				return
+			}
+			if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+				t.Fatalf("Expected failed build, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			if tb.builds != 0 {
+				t.Errorf("Expected no build of a source archive exceeding the limit, got %d", tb.builds)
+			}
+			pkg := tb.getPackage(t)
+			if !strings.Contains(pkg.Status.BuildLog, "source archive exceeds limit (2.0 MiB > 1.0 MiB)") {
+				t.Errorf("Expected size limit in build logs, got %q", pkg.Status.BuildLog)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonArchiveTooLarge)
+		})
+	}
+}
+
+func TestExecuteBuildDeploymentArchiveSizeLimit(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.archiveLimits = archiveSizeLimits{Deployment: 1 << 20}
+	env := tb.env.DeepCopy()
+	env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_MAX_DEPLOYMENT_ARCHIVE_SIZE: "2Mi"}
+	_, err := tb.fissionClient.CoreV1().Environments(env.ObjectMeta.Namespace).Update(context.Background(), env, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limit int64
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		limit = contextArchiveSizeLimits(ctx).Deployment
+		e := "ArchiveTooLarge: deployment archive exceeds limit (3.0 MiB > 2.0 MiB)"
+		return nil, logLines(logPhaseUpload, e), permanentBuildError{archiveTooLargeError{ferror.MakeError(http.StatusBadRequest, e)}}
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+	if limit != 2<<20 {
+		t.Errorf("Expected the deployment archive limit of the environment, got %d", limit)
+	}
+	if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+		t.Fatalf("Expected failed build, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	checkCondition(t, tb.getPackage(t), fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonArchiveTooLarge)
+}
+
+func TestSourceArchiveSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("Expected HEAD request, got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/storagesvc":
+			w.Header().Set(storagesvc.HeaderStoredSize, "1234")
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Length", "5678")
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name    string
+		archive fv1.Archive
+		size    int64
+	}{
+		{name: "literal", archive: fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: []byte("source")}, size: 6},
+		{name: "storage service", archive: fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: server.URL + "/storagesvc"}, size: 1234},
+		{name: "content length", archive: fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: server.URL + "/archive.zip"}, size: 5678},
+		{name: "missing", archive: fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: server.URL + "/missing"}, size: -1},
+		{name: "auth secret", archive: fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: server.URL + "/archive.zip", AuthSecret: "creds"}, size: -1},
+		{name: "git", archive: fv1.Archive{Type: fv1.ArchiveTypeGit, Git: &fv1.GitSource{URL: "https://github.com/fission/examples.git"}}, size: -1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			size, err := sourceArchiveSize(context.Background(), test.archive)
+			if err != nil || size != test.size {
+				t.Errorf("Expected size %d, got %d: %v", test.size, size, err)
+			}
+		})
+	}
+}
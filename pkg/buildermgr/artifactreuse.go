@@ -0,0 +1,53 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// currentDeploymentKey is the context key of the checksum of the current
+// deployment archive of the package being built.
+type currentDeploymentKey struct{}
+
+// reusableDeployment returns the checksum of the deployment archive of the
+// package, which an identical build archive reuses instead of being
+// uploaded, nil if the package has none to reuse or uploads are forced.
+func reusableDeployment(pkg *fv1.Package, forceUpload bool) *fv1.Checksum {
+	deployment := pkg.Spec.Deployment
+	if forceUpload || deployment.Type != fv1.ArchiveTypeUrl || len(deployment.URL) == 0 ||
+		deployment.Checksum.Type != fv1.ChecksumTypeSHA256 || len(deployment.Checksum.Sum) == 0 {
+		return nil
+	}
+	return deployment.Checksum.DeepCopy()
+}
+
+// withCurrentDeployment returns a context asking the fetcher to skip the
+// upload of deployment archives with the checksum, a nil checksum uploads
+// them all.
+func withCurrentDeployment(ctx context.Context, sum *fv1.Checksum) context.Context {
+	return context.WithValue(ctx, currentDeploymentKey{}, sum)
+}
+
+// contextCurrentDeployment returns the checksum of the current deployment
+// archive of the context, nil if uploads aren't skipped.
+func contextCurrentDeployment(ctx context.Context) *fv1.Checksum {
+	sum, _ := ctx.Value(currentDeploymentKey{}).(*fv1.Checksum)
+	return sum
+}
@@ -0,0 +1,112 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// deployedTestBuild returns a test build of a package having a deployment
+// archive, whose builds report an archive identical to it.
+func deployedTestBuild(t *testing.T) (*testBuild, **fv1.Checksum) {
+	t.Helper()
+	tb := newTestBuild(t)
+	tb.pkg.Spec.Deployment = fv1.Archive{
+		Type:     fv1.ArchiveTypeUrl,
+		URL:      "http://storagesvc/current",
+		Checksum: fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc123"},
+		Size:     42,
+	}
+	pkg, err := tb.fissionClient.CoreV1().Packages(testNamespace).Update(context.Background(), tb.pkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	tb.pkg = pkg
+
+	skipIf := new(*fv1.Checksum)
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		*skipIf = contextCurrentDeployment(ctx)
+		sum := fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc123"}
+		if *skipIf != nil && **skipIf == sum {
+			return &fetcher.ArchiveUploadResponse{Checksum: sum, Unchanged: true}, "build succeeded\n", nil
+		}
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy", Checksum: sum, StoredSize: 42},
+			"build succeeded\n", nil
+	}
+	return tb, skipIf
+}
+
+func TestExecuteBuildReusesUnchangedArtifact(t *testing.T) {
+	tb, skipIf := deployedTestBuild(t)
+	fnVersion := tb.functionResourceVersion(t)
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if *skipIf == nil || (*skipIf).Sum != "abc123" {
+		t.Fatalf("Expected upload skipped for the current deployment checksum, got %v", *skipIf)
+	}
+	pkg := tb.getPackage(t)
+	if pkg.Spec.Deployment.URL != "http://storagesvc/current" || result.Deployment.URL != "http://storagesvc/current" {
+		t.Errorf("Expected current deployment archive kept, got %q", pkg.Spec.Deployment.URL)
+	}
+	if v := tb.functionResourceVersion(t); v != fnVersion {
+		t.Errorf("Expected function left alone, got package ref version %q", v)
+	}
+	if tb.checks != 0 {
+		t.Errorf("Expected kept archive not checked again, got %d checks", tb.checks)
+	}
+	if result.ArtifactSize != 42 {
+		t.Errorf("Expected artifact size of the kept archive, got %d", result.ArtifactSize)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonArtifactUnchanged)
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionTrue, fv1.PackageReasonBuildSucceeded)
+	if !strings.Contains(result.Logs, "artifact unchanged, reusing existing deployment archive") {
+		t.Errorf("Expected reused archive noted in the build logs, got %q", result.Logs)
+	}
+}
+
+func TestExecuteBuildForcedArchiveUpload(t *testing.T) {
+	tb, skipIf := deployedTestBuild(t)
+	tb.deps.forceArchiveUpload = true
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if *skipIf != nil {
+		t.Errorf("Expected forced upload, got upload skipped for checksum %v", *skipIf)
+	}
+	if pkg := tb.getPackage(t); pkg.Spec.Deployment.URL != "http://storagesvc/deploy" {
+		t.Errorf("Expected new deployment archive, got %q", pkg.Spec.Deployment.URL)
+	}
+	checkCondition(t, tb.getPackage(t), fv1.PackageConditionFunctionsUpdated, metav1.ConditionTrue, fv1.PackageReasonFunctionsUpdated)
+}
+
+func TestReusableDeployment(t *testing.T) {
+	pkg := testPackage()
+	if sum := reusableDeployment(pkg, false); sum != nil {
+		t.Errorf("Expected nothing to reuse without deployment archive, got %v", sum)
+	}
+	pkg.Spec.Deployment = fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/current"}
+	if sum := reusableDeployment(pkg, false); sum != nil {
+		t.Errorf("Expected nothing to reuse without deployment checksum, got %v", sum)
+	}
+	pkg.Spec.Deployment.Checksum = fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc123"}
+	if sum := reusableDeployment(pkg, false); sum == nil || *sum != pkg.Spec.Deployment.Checksum {
+		t.Errorf("Expected deployment checksum, got %v", sum)
+	}
+	if sum := reusableDeployment(pkg, true); sum != nil {
+		t.Errorf("Expected nothing to reuse with forced uploads, got %v", sum)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fission/fission/pkg/utils/authtoken"
+)
+
+const (
+	// builderAuthTokenKey is the key of the token in the builder auth
+	// secret.
+	builderAuthTokenKey = "token"
+	// builderAuthRefresh is how long the token read from the secret is
+	// used before the secret is read again, rotated tokens are picked up
+	// within it.
+	builderAuthRefresh = time.Minute
+)
+
+type (
+	// builderAuth authenticates the requests to the builder pods with the
+	// token of a secret of the builder manager namespace, created with a
+	// random token unless it exists. The builder pods read the token from
+	// a copy of the secret in their namespace. The token is rotated by
+	// updating the secret and rolling the builders once the builder
+	// manager synced the copies.
+	builderAuth struct {
+		logger     *zap.Logger
+		k8sClient  kubernetes.Interface
+		namespace  string
+		secretName string
+
+		mu     sync.Mutex
+		token  string
+		readAt time.Time
+		// synced holds the token of the secret copies by namespace,
+		// since the secret was last read
+		synced map[string]string
+	}
+
+	// builderAuthTokenCtxKey is the context key of the token sent to the
+	// builder receiving the build requests.
+	builderAuthTokenCtxKey struct{}
+)
+
+func newBuilderAuth(logger *zap.Logger, k8sClient kubernetes.Interface, namespace, secretName string) *builderAuth {
+	return &builderAuth{
+		logger:     logger.With(zap.String("secret", namespace+"/"+secretName)),
+		k8sClient:  k8sClient,
+		namespace:  namespace,
+		secretName: secretName,
+		synced:     make(map[string]string),
+	}
+}
+
+// withToken returns the context sending the token to the builders of the
+// builder namespace, once the secret copy of the namespace holds it.
+// Without builder auth the context is returned as is.
+func (a *builderAuth) withToken(ctx context.Context, builderNs string) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+	token, err := a.sync(ctx, builderNs)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, builderAuthTokenCtxKey{}, token), nil
+}
+
+// contextBuilderAuthToken returns the token of the context, empty without
+// builder auth.
+func contextBuilderAuthToken(ctx context.Context) string {
+	token, _ := ctx.Value(builderAuthTokenCtxKey{}).(string)
+	return token
+}
+
+// sync copies the secret into the builder namespace and returns its token.
+func (a *builderAuth) sync(ctx context.Context, builderNs string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, err := a.readToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if builderNs == a.namespace || a.synced[builderNs] == token {
+		return token, nil
+	}
+
+	secrets := a.k8sClient.CoreV1().Secrets(builderNs)
+	secret, err := secrets.Get(ctx, a.secretName, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		_, err = secrets.Create(ctx, &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: builderNs,
+				Name:      a.secretName,
+				Labels:    map[string]string{LABEL_DEPLOYMENT_OWNER: BUILDER_MGR},
+			},
+			Data: map[string][]byte{builderAuthTokenKey: []byte(token)},
+		}, metav1.CreateOptions{})
+	case err == nil && string(secret.Data[builderAuthTokenKey]) != token:
+		secret = secret.DeepCopy()
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[builderAuthTokenKey] = []byte(token)
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error syncing builder auth secret into namespace %q", builderNs)
+	}
+	a.synced[builderNs] = token
+	return token, nil
+}
+
+// readToken returns the token of the secret, which is read again once the
+// token is older than builderAuthRefresh. a.mu must be held.
+func (a *builderAuth) readToken(ctx context.Context) (string, error) {
+	if len(a.token) > 0 && time.Since(a.readAt) < builderAuthRefresh {
+		return a.token, nil
+	}
+	secrets := a.k8sClient.CoreV1().Secrets(a.namespace)
+	secret, err := secrets.Get(ctx, a.secretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		var token string
+		token, err = authtoken.Generate()
+		if err != nil {
+			return "", errors.Wrap(err, "error generating builder auth token")
+		}
+		secret, err = secrets.Create(ctx, &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: a.namespace,
+				Name:      a.secretName,
+			},
+			Data: map[string][]byte{builderAuthTokenKey: []byte(token)},
+		}, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			// another replica created it first
+			secret, err = secrets.Get(ctx, a.secretName, metav1.GetOptions{})
+		} else if err == nil {
+			a.logger.Info("created builder auth secret")
+		}
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error reading builder auth secret")
+	}
+	token := string(secret.Data[builderAuthTokenKey])
+	if len(token) == 0 {
+		return "", errors.Errorf("builder auth secret %s/%s has no %q key", a.namespace, a.secretName, builderAuthTokenKey)
+	}
+	if len(a.token) > 0 && token != a.token {
+		a.logger.Info("builder auth token rotated")
+	}
+	a.token = token
+	a.readAt = time.Now()
+	// the copies are checked again too, deleted copies are recreated
+	a.synced = make(map[string]string)
+	return token, nil
+}
+
+// addToPodSpec sets the token of the secret copy in the environment of the
+// builder and fetcher containers. Without builder auth the pod spec is left
+// alone.
+func (a *builderAuth) addToPodSpec(spec *apiv1.PodSpec) {
+	if a == nil {
+		return
+	}
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		if c.Name != "builder" && c.Name != "fetcher" {
+			continue
+		}
+		c.Env = append(c.Env, apiv1.EnvVar{
+			Name: authtoken.EnvBuilderAuthToken,
+			ValueFrom: &apiv1.EnvVarSource{
+				SecretKeyRef: &apiv1.SecretKeySelector{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: a.secretName},
+					Key:                  builderAuthTokenKey,
+				},
+			},
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils/authtoken"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func secretToken(t *testing.T, k8sClient *fake.Clientset, namespace, name string) string {
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting secret %s/%s: %v", namespace, name, err)
+	}
+	return string(secret.Data[builderAuthTokenKey])
+}
+
+func TestBuilderAuthSyncsSecret(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	auth := newBuilderAuth(loggerfactory.GetLogger(), k8sClient, "fission", "builder-auth")
+
+	ctx, err := auth.withToken(context.Background(), "fission-builder")
+	if err != nil {
+		t.Fatalf("Error getting builder auth token: %v", err)
+	}
+	token := secretToken(t, k8sClient, "fission", "builder-auth")
+	if len(token) == 0 || contextBuilderAuthToken(ctx) != token {
+		t.Fatalf("Expected generated token %q sent, got %q", token, contextBuilderAuthToken(ctx))
+	}
+	if copied := secretToken(t, k8sClient, "fission-builder", "builder-auth"); copied != token {
+		t.Errorf("Expected secret copied into the builder namespace, got token %q", copied)
+	}
+
+	// the token is rotated by updating the secret
+	secret, err := k8sClient.CoreV1().Secrets("fission").Get(context.Background(), "builder-auth", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[builderAuthTokenKey] = []byte("rotated")
+	_, err = k8sClient.CoreV1().Secrets("fission").Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ = auth.withToken(context.Background(), "fission-builder")
+	if contextBuilderAuthToken(ctx) != token {
+		t.Errorf("Expected token cached until the refresh, got %q", contextBuilderAuthToken(ctx))
+	}
+	auth.readAt = time.Now().Add(-builderAuthRefresh)
+	ctx, err = auth.withToken(context.Background(), "fission-builder")
+	if err != nil {
+		t.Fatalf("Error getting builder auth token: %v", err)
+	}
+	if contextBuilderAuthToken(ctx) != "rotated" {
+		t.Errorf("Expected rotated token sent, got %q", contextBuilderAuthToken(ctx))
+	}
+	if copied := secretToken(t, k8sClient, "fission-builder", "builder-auth"); copied != "rotated" {
+		t.Errorf("Expected rotated token synced into the builder namespace, got %q", copied)
+	}
+}
+
+func TestBuilderAuthAddToPodSpec(t *testing.T) {
+	spec := &apiv1.PodSpec{Containers: []apiv1.Container{{Name: "builder"}, {Name: "fetcher"}, {Name: "sidecar"}}}
+	var disabled *builderAuth
+	disabled.addToPodSpec(spec)
+	if len(spec.Containers[0].Env) != 0 {
+		t.Fatalf("Expected pod spec left alone without builder auth, got %+v", spec.Containers[0].Env)
+	}
+
+	newBuilderAuth(loggerfactory.GetLogger(), fake.NewSimpleClientset(), "fission", "builder-auth").addToPodSpec(spec)
+	for _, c := range spec.Containers[:2] {
+		if len(c.Env) != 1 || c.Env[0].Name != authtoken.EnvBuilderAuthToken ||
+			c.Env[0].ValueFrom.SecretKeyRef.Name != "builder-auth" || c.Env[0].ValueFrom.SecretKeyRef.Key != builderAuthTokenKey {
+			t.Errorf("Expected token of the secret in the %s container environment, got %+v", c.Name, c.Env)
+		}
+	}
+	if len(spec.Containers[2].Env) != 0 {
+		t.Errorf("Expected other containers left alone, got %+v", spec.Containers[2].Env)
+	}
+}
+
+func TestExecuteBuildSendsBuilderAuthToken(t *testing.T) {
+	tb := newTestBuild(t)
+	k8sClient := fake.NewSimpleClientset()
+	tb.deps.builderAuth = newBuilderAuth(loggerfactory.GetLogger(), k8sClient, "fission", "builder-auth")
+
+	var sent string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		sent = contextBuilderAuthToken(ctx)
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if token := secretToken(t, k8sClient, "fission", "builder-auth"); len(sent) == 0 || sent != token {
+		t.Errorf("Expected token %q sent to the builder, got %q", token, sent)
+	}
+}
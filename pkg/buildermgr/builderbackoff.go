@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fission/fission/pkg/utils"
+)
+
+// BuilderBackoff is the health check backoff of the builds waiting for a
+// ready builder pod. The checks start InitialInterval apart, the interval
+// grows by Multiplier after every check of a pod not ready yet, and the wait
+// is over once it would exceed MaxInterval or MaxWait is over.
+type BuilderBackoff struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// MaxWait bounds the whole wait, zero leaves it to the backoff.
+	MaxWait time.Duration
+}
+
+// DefaultBuilderBackoff returns the default builder health check backoff.
+func DefaultBuilderBackoff() BuilderBackoff {
+	return BuilderBackoff{
+		InitialInterval: utils.DefaultInitialInterval,
+		MaxInterval:     utils.DefaultMaxInterval,
+		Multiplier:      utils.DefaultMultiplier,
+	}
+}
+
+// Validate checks that the backoff eventually ends.
+func (b BuilderBackoff) Validate() error {
+	if b.InitialInterval <= 0 {
+		return errors.New("builder health check initial interval must be positive")
+	}
+	if b.MaxInterval < b.InitialInterval {
+		return errors.New("builder health check max interval must not be below the initial interval")
+	}
+	if b.Multiplier < 1 {
+		return errors.New("builder health check multiplier must be at least 1")
+	}
+	if b.MaxWait < 0 {
+		return errors.New("builder health check max wait must not be negative")
+	}
+	return nil
+}
+
+// isZero reports whether the backoff is unset.
+func (b BuilderBackoff) isZero() bool {
+	return b == BuilderBackoff{}
+}
+
+// remaining returns how long is left of the max wait of a wait started at
+// start, ok is false once it is over. Without a max wait, d is left as is.
+func (b BuilderBackoff) remaining(start time.Time, d time.Duration) (time.Duration, bool) {
+	if b.MaxWait <= 0 {
+		return d, true
+	}
+	left := b.MaxWait - time.Since(start)
+	if left <= 0 {
+		return 0, false
+	}
+	if d > left {
+		d = left
+	}
+	return d, true
+}
@@ -0,0 +1,420 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// defaultBuilderDrainGracePeriod is the time the builds in flight on
+	// a replaced builder get to finish before it's deleted.
+	defaultBuilderDrainGracePeriod = 10 * time.Minute
+	// builderDrainCheckInterval is how often a draining builder is checked
+	// for builds in flight.
+	builderDrainCheckInterval = 5 * time.Second
+)
+
+type (
+	// builderDrain is the drain of a builder deployment replaced by a
+	// builder change of its environment.
+	builderDrain struct {
+		namespace string
+		name      string
+		envName   string
+		// labels select the service of the deployment, selector its pods
+		labels   map[string]string
+		selector map[string]string
+		since    time.Time
+		// stop ends the drain before the builds in flight are done, keep
+		// tells whether the deployment is in use again
+		stop chan struct{}
+		keep bool
+	}
+
+	// builderDrains tracks the builder deployments being drained, by
+	// namespace/name.
+	builderDrains struct {
+		mu     sync.Mutex
+		drains map[string]*builderDrain
+	}
+)
+
+func newBuilderDrains() *builderDrains {
+	return &builderDrains{drains: make(map[string]*builderDrain)}
+}
+
+// newBuilderDrain returns the drain of the builder deployment started at
+// since.
+func newBuilderDrain(deploy *appsv1.Deployment, since time.Time) *builderDrain {
+	selector := deploy.ObjectMeta.Labels
+	if deploy.Spec.Selector != nil && len(deploy.Spec.Selector.MatchLabels) > 0 {
+		selector = deploy.Spec.Selector.MatchLabels
+	}
+	return &builderDrain{
+		namespace: deploy.ObjectMeta.Namespace,
+		name:      deploy.ObjectMeta.Name,
+		envName:   deploy.ObjectMeta.Labels[LABEL_ENV_NAME],
+		labels:    deploy.ObjectMeta.Labels,
+		selector:  selector,
+		since:     since,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (d *builderDrain) key() string {
+	return d.namespace + "/" + d.name
+}
+
+// start tracks the drain, it returns false if the deployment is already
+// being drained.
+func (d *builderDrains) start(drain *builderDrain) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.drains[drain.key()]; ok {
+		return false
+	}
+	d.drains[drain.key()] = drain
+	return true
+}
+
+// stop ends the drain of the deployment early, the deployment is deleted
+// unless keep is set. It returns false if the deployment isn't being
+// drained.
+func (d *builderDrains) stop(namespace, name string, keep bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	drain, ok := d.drains[namespace+"/"+name]
+	if !ok {
+		return false
+	}
+	d.stopLocked(drain, keep)
+	return true
+}
+
+// stopEnv ends the drains of the builders of the environment early, they're
+// deleted right away.
+func (d *builderDrains) stopEnv(namespace, envName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, drain := range d.drains {
+		if drain.namespace == namespace && drain.envName == envName {
+			d.stopLocked(drain, false)
+		}
+	}
+}
+
+func (d *builderDrains) stopLocked(drain *builderDrain, keep bool) {
+	select {
+	case <-drain.stop:
+		// stopped already, the deployment is kept if any stop keeps it
+		drain.keep = drain.keep || keep
+	default:
+		drain.keep = keep
+		close(drain.stop)
+	}
+}
+
+// finish forgets the drain, it returns whether the deployment is in use
+// again and must be kept.
+func (d *builderDrains) finish(drain *builderDrain) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.drains, drain.key())
+	return drain.keep
+}
+
+// builderDraining reports whether the builder deployment, service or pod
+// is being drained. No builds are sent to draining builder pods.
+func builderDraining(meta *metav1.ObjectMeta) bool {
+	_, ok := meta.Annotations[ANNOTATION_BUILDER_DRAINING]
+	return ok
+}
+
+// drainingSince returns when the drain of the builder deployment started.
+func drainingSince(meta *metav1.ObjectMeta) time.Time {
+	since, err := time.Parse(time.RFC3339, meta.Annotations[ANNOTATION_BUILDER_DRAINING])
+	if err != nil {
+		return time.Now()
+	}
+	return since
+}
+
+// drainingPatch returns the merge patch marking a builder object as draining
+// since the given time, or unmarking it without time.
+func drainingPatch(since *time.Time) ([]byte, error) {
+	var value interface{}
+	if since != nil {
+		value = since.UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				ANNOTATION_BUILDER_DRAINING: value,
+			},
+		},
+	})
+}
+
+// drainBuilder stops the builds from going to the builder deployment
+// replaced by a builder change, and deletes it once the builds in flight on
+// its pods are done or once the drain grace period is over. The builds
+// waiting for a builder meanwhile wait for the pods of the new one.
+func (envw *environmentWatcher) drainBuilder(ctx context.Context, deploy *appsv1.Deployment, since time.Time) {
+	if deploy == nil {
+		return
+	}
+	drain := newBuilderDrain(deploy, since)
+	if !envw.drains.start(drain) {
+		return
+	}
+	err := envw.markDraining(ctx, drain, &drain.since)
+	if err != nil {
+		envw.logger.Warn("error marking builder as draining", zap.Error(err),
+			zap.String("deployment_name", drain.name),
+			zap.String("deployment_namespace", drain.namespace))
+	}
+	go envw.runDrain(ctx, drain)
+}
+
+// undrainBuilder keeps the builder deployment being drained, it's the
+// builder of the environment again. Builds are sent to its pods again once
+// they're unmarked.
+func (envw *environmentWatcher) undrainBuilder(ctx context.Context, deploy *appsv1.Deployment) {
+	if deploy == nil {
+		return
+	}
+	if envw.drains.stop(deploy.ObjectMeta.Namespace, deploy.ObjectMeta.Name, true) {
+		// unmarked by its drain
+		return
+	}
+	if !builderDraining(&deploy.ObjectMeta) {
+		return
+	}
+	err := envw.markDraining(ctx, newBuilderDrain(deploy, time.Time{}), nil)
+	if err != nil {
+		envw.logger.Warn("error unmarking draining builder", zap.Error(err),
+			zap.String("deployment_name", deploy.ObjectMeta.Name),
+			zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
+	}
+}
+
+// resumeDrains drains the builders of the environment replaced before the
+// builder manager restarted, other than the current builder.
+func (envw *environmentWatcher) resumeDrains(ctx context.Context, env *fv1.Environment, ns string, current *builderInfo) {
+	deployList, err := envw.getBuilderDeploymentList(ctx, envw.getDeploymentLabels(env.ObjectMeta.Name), ns)
+	if err != nil {
+		envw.logger.Error("error getting the builder deployment list", zap.Error(err))
+		return
+	}
+	deployList = environmentDeployments(deployList, env)
+	for i := range deployList {
+		deploy := &deployList[i]
+		if !builderDraining(&deploy.ObjectMeta) ||
+			(current.deployment != nil && deploy.ObjectMeta.Name == current.deployment.ObjectMeta.Name) {
+			continue
+		}
+		envw.drainBuilder(ctx, deploy, drainingSince(&deploy.ObjectMeta))
+	}
+}
+
+// markDraining marks the deployment, service and pods of the drained builder
+// as draining since the given time, or unmarks them without time.
+func (envw *environmentWatcher) markDraining(ctx context.Context, drain *builderDrain, since *time.Time) error {
+	patch, err := drainingPatch(since)
+	if err != nil {
+		return err
+	}
+	_, err = envw.kubernetesClient.AppsV1().Deployments(drain.namespace).Patch(ctx, drain.name,
+		k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error patching builder deployment %s.%s", drain.name, drain.namespace)
+	}
+	svcList, err := envw.getBuilderServiceList(ctx, drain.labels, drain.namespace)
+	if err != nil {
+		return err
+	}
+	for _, svc := range svcList {
+		_, err = envw.kubernetesClient.CoreV1().Services(svc.ObjectMeta.Namespace).Patch(ctx, svc.ObjectMeta.Name,
+			k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "error patching builder service %s.%s", svc.ObjectMeta.Name, svc.ObjectMeta.Namespace)
+		}
+	}
+	if since == nil {
+		_, err = envw.drainPods(ctx, drain, patch, true)
+		return err
+	}
+	return nil
+}
+
+// drainPods returns the namespace/names of the pods of the drained builder,
+// the pods not marked yet, or all of them if all is set, are patched.
+func (envw *environmentWatcher) drainPods(ctx context.Context, drain *builderDrain, patch []byte, all bool) (map[string]bool, error) {
+	podList, err := envw.kubernetesClient.CoreV1().Pods(drain.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(drain.selector).AsSelector().String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting builder pod list")
+	}
+	pods := make(map[string]bool, len(podList.Items))
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		pods[builderPodName(pod)] = true
+		if !all && builderDraining(&pod.ObjectMeta) {
+			continue
+		}
+		_, err = envw.kubernetesClient.CoreV1().Pods(pod.ObjectMeta.Namespace).Patch(ctx, pod.ObjectMeta.Name,
+			k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "error patching builder pod %s.%s", pod.ObjectMeta.Name, pod.ObjectMeta.Namespace)
+		}
+	}
+	return pods, nil
+}
+
+// buildsInFlight returns the number of builds running on the pods of the
+// drained builder. The pods are marked as draining meanwhile.
+func (envw *environmentWatcher) buildsInFlight(ctx context.Context, drain *builderDrain) (int, error) {
+	patch, err := drainingPatch(&drain.since)
+	if err != nil {
+		return 0, err
+	}
+	pods, err := envw.drainPods(ctx, drain, patch, false)
+	if err != nil {
+		return 0, err
+	}
+	podBuilds, err := envw.podBuildsInFlight(pods)
+	if err != nil {
+		return 0, err
+	}
+	var builds int
+	for _, n := range podBuilds {
+		builds += n
+	}
+	return builds, nil
+}
+
+// podBuildsInFlight returns the number of builds running on each of the
+// builder pods, given as namespace/name: the builds this builder manager
+// sent to the pod, or the running builds of the packages whose status names
+// it, whichever is higher.
+func (envw *environmentWatcher) podBuildsInFlight(pods map[string]bool) (map[string]int, error) {
+	for _, informer := range envw.pkgInformer {
+		if !informer.HasSynced() {
+			return nil, errors.New("package informers not synced")
+		}
+	}
+	running := make(map[string]int)
+	for _, informer := range envw.pkgInformer {
+		for _, obj := range informer.GetStore().List() {
+			pkg, ok := obj.(*fv1.Package)
+			if !ok || pkg.Status.BuildStatus != fv1.BuildStatusRunning || pkg.Status.BuilderPod == nil {
+				continue
+			}
+			running[pkg.Status.BuilderPod.Namespace+"/"+pkg.Status.BuilderPod.Name]++
+		}
+	}
+	builds := make(map[string]int, len(pods))
+	for pod := range pods {
+		builds[pod] = envw.builderLoad.inFlight(pod)
+		if running[pod] > builds[pod] {
+			builds[pod] = running[pod]
+		}
+	}
+	return builds, nil
+}
+
+// runDrain waits for the builds in flight on the drained builder, and
+// deletes it once they're done, once the grace period is over or once the
+// drain is stopped. The drain is left to the next builder manager once ctx
+// is done.
+func (envw *environmentWatcher) runDrain(ctx context.Context, drain *builderDrain) {
+	logger := envw.logger.With(zap.String("deployment_name", drain.name),
+		zap.String("deployment_namespace", drain.namespace))
+	logger.Info("draining replaced builder", zap.Time("since", drain.since))
+
+	deadline := time.NewTimer(time.Until(drain.since.Add(envw.drainGracePeriod)))
+	defer deadline.Stop()
+	ticker := time.NewTicker(builderDrainCheckInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		builds, err := envw.buildsInFlight(ctx, drain)
+		if err != nil {
+			logger.Warn("error counting the builds in flight on draining builder", zap.Error(err))
+		} else if builds == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			envw.drains.finish(drain)
+			return
+		case <-drain.stop:
+			break loop
+		case <-deadline.C:
+			logger.Warn("builder drain grace period is over, deleting builder with builds in flight", zap.Int("builds", builds))
+			break loop
+		case <-ticker.C:
+		}
+	}
+	if envw.drains.finish(drain) {
+		logger.Info("draining builder is in use again")
+		err := envw.markDraining(ctx, drain, nil)
+		if err != nil {
+			logger.Warn("error unmarking draining builder", zap.Error(err))
+		}
+		return
+	}
+	envw.deleteDrainedBuilder(ctx, logger, drain)
+}
+
+// deleteDrainedBuilder deletes the service and deployment of the drained
+// builder, those deleted already are skipped.
+func (envw *environmentWatcher) deleteDrainedBuilder(ctx context.Context, logger *zap.Logger, drain *builderDrain) {
+	svcList, err := envw.getBuilderServiceList(ctx, drain.labels, drain.namespace)
+	if err != nil {
+		logger.Error("error getting the builder service list", zap.Error(err))
+	}
+	for _, svc := range svcList {
+		err = envw.deleteBuilderServiceByName(ctx, svc.ObjectMeta.Name, svc.ObjectMeta.Namespace)
+		if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+			logger.Error("error removing builder service", zap.Error(err),
+				zap.String("service_name", svc.ObjectMeta.Name),
+				zap.String("service_namespace", svc.ObjectMeta.Namespace))
+		}
+	}
+	err = envw.deleteBuilderDeploymentByName(ctx, drain.name, drain.namespace)
+	if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+		logger.Error("error removing builder deployment", zap.Error(err))
+		return
+	}
+	logger.Info("drained builder deleted")
+}
@@ -0,0 +1,192 @@
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// newTestDrainWatcher returns an environment watcher with the builder
+// deployment, service and pod of the test environment.
+func newTestDrainWatcher(t *testing.T) (*environmentWatcher, *appsv1.Deployment) {
+	t.Helper()
+	env := testEnvironment()
+	envw := &environmentWatcher{
+		logger:           loggerfactory.GetLogger(),
+		drains:           newBuilderDrains(),
+		drainGracePeriod: time.Minute,
+		builderLoad:      newBuilderLoad(),
+	}
+	sel := envw.getLabels(env.ObjectMeta.Name, testNamespace, env.ObjectMeta.ResourceVersion)
+	name := fmt.Sprintf("%v-%v", env.ObjectMeta.Name, env.ObjectMeta.ResourceVersion)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name, Labels: sel},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: sel}},
+	}
+	svc := &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name, Labels: sel},
+	}
+	envw.kubernetesClient = fake.NewSimpleClientset(deploy, svc, testBuilderPod(env))
+	return envw, deploy
+}
+
+func TestBuilderDrainDeletesBuilderOnceIdle(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	pod := builderPodName(testBuilderPod(testEnvironment()))
+	envw.builderLoad.builds[pod] = 1
+
+	drain := newBuilderDrain(deploy, time.Now())
+	if !envw.drains.start(drain) || envw.drains.start(newBuilderDrain(deploy, time.Now())) {
+		t.Fatal("Expected the deployment drained once")
+	}
+	err := envw.markDraining(ctx, drain, &drain.since)
+	if err != nil {
+		t.Fatalf("Error marking builder as draining: %v", err)
+	}
+	builds, err := envw.buildsInFlight(ctx, drain)
+	if err != nil || builds != 1 {
+		t.Fatalf("Expected 1 build in flight, got %d: %v", builds, err)
+	}
+	marked, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || !builderDraining(&marked.ObjectMeta) {
+		t.Errorf("Expected deployment marked as draining: %v", err)
+	}
+	builderPod, err := envw.kubernetesClient.CoreV1().Pods(testNamespace).Get(ctx, "builder-pod", metav1.GetOptions{})
+	if err != nil || !builderDraining(&builderPod.ObjectMeta) {
+		t.Errorf("Expected builder pod marked as draining: %v", err)
+	}
+	if since := drainingSince(&builderPod.ObjectMeta); since.Unix() != drain.since.Unix() {
+		t.Errorf("Expected drain start %v, got %v", drain.since, since)
+	}
+
+	envw.builderLoad.release(pod)
+	envw.runDrain(ctx, drain)
+	_, err = envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected drained deployment deleted, got %v", err)
+	}
+	_, err = envw.kubernetesClient.CoreV1().Services(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected drained service deleted, got %v", err)
+	}
+	if len(envw.drains.drains) != 0 {
+		t.Errorf("Expected finished drain forgotten, got %d drains", len(envw.drains.drains))
+	}
+}
+
+func TestBuilderDrainCountsRunningPackageBuilds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	envw, deploy := newTestDrainWatcher(t)
+	running := testPackage()
+	running.Status.BuildStatus = fv1.BuildStatusRunning
+	running.Status.BuilderPod = &fv1.BuilderPodInfo{Name: "builder-pod", Namespace: testNamespace}
+	succeeded := running.DeepCopy()
+	succeeded.ObjectMeta.Name = "succeeded"
+	succeeded.Status.BuildStatus = fv1.BuildStatusSucceeded
+	pkgInformer := fInformers.NewSharedInformerFactory(fClient.NewSimpleClientset(running, succeeded), 0).Core().V1().Packages().Informer()
+	envw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+
+	drain := newBuilderDrain(deploy, time.Now())
+	_, err := envw.buildsInFlight(ctx, drain)
+	if err == nil {
+		t.Error("Expected builds in flight unknown until the package informers are synced")
+	}
+	go pkgInformer.Run(ctx.Done())
+	if !k8sCache.WaitForCacheSync(ctx.Done(), pkgInformer.HasSynced) {
+		t.Fatal("Package informer not synced")
+	}
+	builds, err := envw.buildsInFlight(ctx, drain)
+	if err != nil || builds != 1 {
+		t.Errorf("Expected the running package build in flight, got %d: %v", builds, err)
+	}
+}
+
+func TestBuilderDrainStops(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	envw.builderLoad.builds[builderPodName(testBuilderPod(testEnvironment()))] = 1
+
+	// the deployment is the builder of the environment again
+	drain := newBuilderDrain(deploy, time.Now())
+	envw.drains.start(drain)
+	err := envw.markDraining(ctx, drain, &drain.since)
+	if err != nil {
+		t.Fatalf("Error marking builder as draining: %v", err)
+	}
+	envw.undrainBuilder(ctx, deploy)
+	envw.runDrain(ctx, drain)
+	kept, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || builderDraining(&kept.ObjectMeta) {
+		t.Fatalf("Expected deployment kept and unmarked, got %v", err)
+	}
+	pod, err := envw.kubernetesClient.CoreV1().Pods(testNamespace).Get(ctx, "builder-pod", metav1.GetOptions{})
+	if err != nil || builderDraining(&pod.ObjectMeta) {
+		t.Errorf("Expected builder pod unmarked: %v", err)
+	}
+
+	// the environment is deleted
+	drain = newBuilderDrain(deploy, time.Now())
+	envw.drains.start(drain)
+	envw.drains.stopEnv(testNamespace, testEnvName)
+	envw.runDrain(ctx, drain)
+	_, err = envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected deployment of deleted environment deleted, got %v", err)
+	}
+}
+
+func TestBuilderDrainGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	envw.builderLoad.builds[builderPodName(testBuilderPod(testEnvironment()))] = 1
+
+	envw.runDrain(ctx, newBuilderDrain(deploy, time.Now().Add(-envw.drainGracePeriod)))
+	_, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected deployment deleted once the grace period is over, got %v", err)
+	}
+}
+
+func TestExecuteBuildSkipsDrainingBuilderPods(t *testing.T) {
+	tb := newTestBuild(t)
+	pods := testBuilderPods(2)
+	for i, pod := range pods {
+		pod.Status.PodIP = "10.0.0." + string(rune('1'+i))
+	}
+	pods[0].ObjectMeta.Annotations = map[string]string{ANNOTATION_BUILDER_DRAINING: time.Now().UTC().Format(time.RFC3339)}
+	tb.pods.pods = pods
+
+	var address string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		address = builderAddress(ctx, tb.env, envBuilderNamespace)
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if address != "10.0.0.2" {
+		t.Errorf("Expected build sent to the pod of the new builder, got address %q", address)
+	}
+}
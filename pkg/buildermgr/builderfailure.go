@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// reasonOOMKilled is the termination reason of containers killed for
+	// exceeding their memory limit.
+	reasonOOMKilled = "OOMKilled"
+	// reasonEvicted is the reason of pods evicted from their node.
+	reasonEvicted = "Evicted"
+	// reasonImagePullBackOff and reasonErrImagePull are the waiting
+	// reasons of containers failing to pull their image.
+	reasonImagePullBackOff = "ImagePullBackOff"
+	reasonErrImagePull     = "ErrImagePull"
+)
+
+// builderImagePullError is a builder wait run out while the builder pod
+// failed to pull its image.
+type builderImagePullError struct {
+	error
+}
+
+func (e builderImagePullError) Unwrap() error {
+	return e.error
+}
+
+// builderPodFailure tells whether the builder container of the builder pod,
+// given as namespace/name, was OOMKilled or the pod evicted during the build
+// started at since. It returns the package reason and the message of the
+// failure, an empty reason if the pod didn't fail.
+func builderPodFailure(ctx context.Context, logger *zap.Logger, k8sClient kubernetes.Interface, pod string, since time.Time) (string, string) {
+	namespace, name, ok := strings.Cut(pod, "/")
+	if k8sClient == nil || !ok {
+		return "", ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, builderLogTimeout)
+	defer cancel()
+	p, err := k8sClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		logger.Warn("error getting builder pod for its failure", zap.String("pod", pod), zap.Error(err))
+		return "", ""
+	}
+	return podFailure(p, since)
+}
+
+// podFailure returns the package reason and message of a builder pod that
+// failed during the build started at since: its builder container
+// OOMKilled, or the pod evicted, for disk pressure or else. The pod was ready when the build started,
+// an evicted pod was evicted since.
+func podFailure(pod *apiv1.Pod, since time.Time) (string, string) {
+	if status := containerStatus(pod, "builder"); status != nil {
+		for _, terminated := range []*apiv1.ContainerStateTerminated{status.State.Terminated, status.LastTerminationState.Terminated} {
+			if terminated == nil || terminated.Reason != reasonOOMKilled || terminated.FinishedAt.Time.Before(since) {
+				continue
+			}
+			limit := "no limit"
+			for _, c := range pod.Spec.Containers {
+				if q, ok := c.Resources.Limits[apiv1.ResourceMemory]; c.Name == "builder" && ok {
+					limit = "limit " + q.String()
+				}
+			}
+			return fv1.PackageReasonBuilderOOMKilled, fmt.Sprintf("%s: builder container OOMKilled (%s), increase the environment builder resources",
+				fv1.PackageReasonBuilderOOMKilled, limit)
+		}
+	}
+	if pod.Status.Phase == apiv1.PodFailed && pod.Status.Reason == reasonEvicted {
+		if reason, msg := storageEvictionFailure(pod); len(reason) > 0 {
+			return reason, msg
+		}
+		return fv1.PackageReasonBuilderEvicted, fmt.Sprintf("%s: builder pod %s was evicted: %s",
+			fv1.PackageReasonBuilderEvicted, builderPodName(pod), pod.Status.Message)
+	}
+	return "", ""
+}
+
+// imagePullFailure returns the image pull error of the builder pod whose
+// containers fail to pull their image, such as private images pulled
+// without image pull secrets, nil if the pod isn't stuck pulling them.
+func imagePullFailure(pod *apiv1.Pod) error {
+	if pod == nil {
+		return nil
+	}
+	statuses := append(append([]apiv1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil || (waiting.Reason != reasonImagePullBackOff && waiting.Reason != reasonErrImagePull) {
+			continue
+		}
+		return builderImagePullError{errors.Errorf("%s: builder pod %s can't pull image %q of container %s: %s",
+			fv1.PackageReasonBuilderImagePullFailed, builderPodName(pod), status.Image, status.Name, waiting.Message)}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package buildermgr
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// oomKilledBuilderPod returns the builder pod of the environment whose
+// builder container, limited to 512Mi, was OOMKilled at finishedAt.
+func oomKilledBuilderPod(env *fv1.Environment, finishedAt time.Time) *apiv1.Pod {
+	pod := testBuilderPod(env)
+	pod.Spec.Containers = []apiv1.Container{{
+		Name: "builder",
+		Resources: apiv1.ResourceRequirements{
+			Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")},
+		},
+	}}
+	pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &apiv1.ContainerStateTerminated{
+		Reason:     "OOMKilled",
+		FinishedAt: metav1.NewTime(finishedAt),
+	}
+	return pod
+}
+
+func TestPodFailure(t *testing.T) {
+	since := time.Now()
+	env := testEnvironment()
+
+	evicted := testBuilderPod(env)
+	evicted.Status.Phase = apiv1.PodFailed
+	evicted.Status.Reason = "Evicted"
+	evicted.Status.Message = "The node was low on resource: memory."
+
+	diskPressure := evicted.DeepCopy()
+	diskPressure.Status.Message = "The node was low on resource: ephemeral-storage."
+
+	noLimit := oomKilledBuilderPod(env, since.Add(time.Second))
+	noLimit.Spec.Containers = nil
+
+	for _, test := range []struct {
+		name   string
+		pod    *apiv1.Pod
+		reason string
+		msg    string
+	}{
+		{name: "OOMKilled", pod: oomKilledBuilderPod(env, since.Add(time.Second)), reason: fv1.PackageReasonBuilderOOMKilled,
+			msg: "builder container OOMKilled (limit 512Mi), increase the environment builder resources"},
+		{name: "OOMKilled without limit", pod: noLimit, reason: fv1.PackageReasonBuilderOOMKilled,
+			msg: "builder container OOMKilled (no limit)"},
+		{name: "OOMKilled before the build", pod: oomKilledBuilderPod(env, since.Add(-time.Minute))},
+		{name: "evicted", pod: evicted, reason: fv1.PackageReasonBuilderEvicted,
+			msg: "builder pod " + testNamespace + "/builder-pod was evicted: The node was low on resource"},
+		{name: "disk pressure", pod: diskPressure, reason: fv1.PackageReasonBuilderDiskPressure,
+			msg: "evicted for its ephemeral storage use (no limit), set the environment builder ephemeral storage request and limit"},
+		{name: "running", pod: testBuilderPod(env)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reason, msg := podFailure(test.pod, since)
+			if reason != test.reason || !strings.Contains(msg, test.msg) {
+				t.Errorf("Expected reason %q with message %q, got %q: %q", test.reason, test.msg, reason, msg)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildReportsOOMKilledBuilder(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.k8sClient = fake.NewSimpleClientset(oomKilledBuilderPod(tb.env, time.Now().Add(time.Hour)))
+	tb.deps.buildPackage = nil
+	tb.deps.runBuild = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+		result := newPackageBuildResult()
+		err := &url.Error{Op: "Post", URL: "http://builder:8001", Err: errors.New("EOF")}
+		result.observe(logPhaseBuild, time.Now(), err)
+		return result.fail(logPhaseBuild, ErrBuildFailed, logf(logPhaseBuild, "Error building deployment package: %v", err), err)
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 2})
+	if err == nil || result.Retry {
+		t.Fatalf("Expected failed build without retry, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	pkg := tb.getPackage(t)
+	if !strings.Contains(pkg.Status.BuildLog, "builder container OOMKilled (limit 512Mi)") {
+		t.Errorf("Expected the OOMKilled builder in the build logs, got %q", pkg.Status.BuildLog)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonBuilderOOMKilled)
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderOOMKilled)
+}
+
+// imagePullBackOffBuilderPod returns the builder pod of the environment
+// whose builder container can't pull its image.
+func imagePullBackOffBuilderPod(env *fv1.Environment) *apiv1.Pod {
+	pod := testBuilderPod(env)
+	pod.Status.ContainerStatuses[0].Ready = false
+	pod.Status.ContainerStatuses[0].Image = "registry.example.com/builder-image"
+	pod.Status.ContainerStatuses[0].State.Waiting = &apiv1.ContainerStateWaiting{
+		Reason:  "ImagePullBackOff",
+		Message: "pull access denied",
+	}
+	return pod
+}
+
+func TestImagePullFailure(t *testing.T) {
+	env := testEnvironment()
+	if err := imagePullFailure(testBuilderPod(env)); err != nil {
+		t.Errorf("Expected no image pull failure of a ready pod, got %v", err)
+	}
+	if err := imagePullFailure(nil); err != nil {
+		t.Errorf("Expected no image pull failure without pod, got %v", err)
+	}
+	err := imagePullFailure(imagePullBackOffBuilderPod(env))
+	var pullErr builderImagePullError
+	if !errors.As(err, &pullErr) || !strings.Contains(err.Error(), `can't pull image "registry.example.com/builder-image" of container builder: pull access denied`) {
+		t.Errorf("Expected image pull failure of the builder container, got %v", err)
+	}
+}
+
+func TestExecuteBuildFailsOnBuilderImagePullBackOff(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.pods.pods = []*apiv1.Pod{imagePullBackOffBuilderPod(tb.env)}
+	tb.deps.builderBackoff = BuilderBackoff{InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, Multiplier: 1,
+		MaxWait: 100 * time.Millisecond}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if result.Status != fv1.BuildStatusFailed || tb.builds != 0 {
+		t.Fatalf("Expected build failed before building, got %s with %d builds: %v", result.Status, tb.builds, err)
+	}
+	pkg := tb.getPackage(t)
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderImagePullFailed)
+	if !strings.Contains(pkg.Status.BuildLog, "pull access denied") || strings.Contains(pkg.Status.BuildLog, "environment builder not ready") {
+		t.Errorf("Expected image pull failure in the build log, got %q", pkg.Status.BuildLog)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// defaultBuilderGCInterval is the time between two sweeps of the
+	// builders of deleted environments.
+	defaultBuilderGCInterval = 10 * time.Minute
+	// defaultBuilderGCMinAge is the age below which builders are never
+	// swept, their environment may not have reached the informer caches.
+	defaultBuilderGCMinAge = 30 * time.Minute
+)
+
+// builderOwner is an environment owning builders in a builder namespace.
+type builderOwner struct {
+	builderNs string
+	envNs     string
+	envName   string
+}
+
+// runBuilderGC sweeps the builders of deleted environments once the
+// environment informers are synced, then once per gcInterval until ctx is
+// done.
+func (envw *environmentWatcher) runBuilderGC(ctx context.Context) {
+	var synced []k8sCache.InformerSynced
+	for _, informer := range envw.envWatchInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !k8sCache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	ticker := time.NewTicker(envw.gcInterval)
+	defer ticker.Stop()
+	for {
+		envw.sweepOrphanedBuilders(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepOrphanedBuilders deletes the builder deployments and services left
+// by environments whose delete event never reached the builder manager,
+// e.g. deleted while it was down. The builders are looked up in the builder
+// namespaces of the watched namespaces and of the live environments,
+// builder namespaces set by environments deleted since aren't known.
+// Draining builders, builders younger than gcMinAge and those of
+// environments of namespaces not watched are left alone.
+func (envw *environmentWatcher) sweepOrphanedBuilders(ctx context.Context) {
+	owners, namespaces := envw.builderOwners()
+	sel := map[string]string{LABEL_DEPLOYMENT_OWNER: BUILDER_MGR}
+	for ns := range namespaces {
+		deployList, err := envw.getBuilderDeploymentList(ctx, sel, ns)
+		if err != nil {
+			envw.logger.Error("error getting the builder deployment list", zap.Error(err), zap.String("namespace", ns))
+			continue
+		}
+		for _, deploy := range deployList {
+			if !envw.orphanedBuilder(&deploy.ObjectMeta, owners) {
+				continue
+			}
+			err = envw.deleteBuilderDeploymentByName(ctx, deploy.ObjectMeta.Name, deploy.ObjectMeta.Namespace)
+			if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+				envw.logger.Error("error removing orphaned builder deployment", zap.Error(err),
+					zap.String("deployment_name", deploy.ObjectMeta.Name),
+					zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
+				continue
+			}
+			envw.logger.Info("orphaned builder deployment deleted",
+				zap.String("env_name", deploy.ObjectMeta.Labels[LABEL_ENV_NAME]),
+				zap.String("deployment_name", deploy.ObjectMeta.Name),
+				zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
+		}
+
+		svcList, err := envw.getBuilderServiceList(ctx, sel, ns)
+		if err != nil {
+			envw.logger.Error("error getting the builder service list", zap.Error(err), zap.String("namespace", ns))
+			continue
+		}
+		for _, svc := range svcList {
+			if !envw.orphanedBuilder(&svc.ObjectMeta, owners) {
+				continue
+			}
+			err = envw.deleteBuilderServiceByName(ctx, svc.ObjectMeta.Name, svc.ObjectMeta.Namespace)
+			if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+				envw.logger.Error("error removing orphaned builder service", zap.Error(err),
+					zap.String("service_name", svc.ObjectMeta.Name),
+					zap.String("service_namespace", svc.ObjectMeta.Namespace))
+				continue
+			}
+			envw.logger.Info("orphaned builder service deleted",
+				zap.String("env_name", svc.ObjectMeta.Labels[LABEL_ENV_NAME]),
+				zap.String("service_name", svc.ObjectMeta.Name),
+				zap.String("service_namespace", svc.ObjectMeta.Namespace))
+		}
+	}
+}
+
+// builderOwners returns the live environments from the informer stores,
+// and the builder namespaces to sweep.
+func (envw *environmentWatcher) builderOwners() (map[builderOwner]bool, map[string]bool) {
+	owners := make(map[builderOwner]bool)
+	namespaces := make(map[string]bool)
+	for ns, informer := range envw.envWatchInformer {
+		namespaces[envw.nsResolver.GetBuilderNS(ns)] = true
+		for _, obj := range informer.GetStore().List() {
+			env, ok := obj.(*fv1.Environment)
+			if !ok {
+				continue
+			}
+			builderNs := builderNamespace(envw.nsResolver, env)
+			owners[builderOwner{builderNs: builderNs, envNs: env.ObjectMeta.Namespace, envName: env.ObjectMeta.Name}] = true
+			namespaces[builderNs] = true
+		}
+	}
+	return owners, namespaces
+}
+
+// orphanedBuilder reports whether the builder deployment or service is
+// old enough to be swept and no live environment owns it.
+func (envw *environmentWatcher) orphanedBuilder(meta *metav1.ObjectMeta, owners map[builderOwner]bool) bool {
+	envName := meta.Labels[LABEL_ENV_NAME]
+	if len(envName) == 0 || builderDraining(meta) || time.Since(meta.CreationTimestamp.Time) < envw.gcMinAge {
+		return false
+	}
+	envNamespaces := envw.ownerNamespaces(meta)
+	for _, envNs := range envNamespaces {
+		if owners[builderOwner{builderNs: meta.Namespace, envNs: envNs, envName: envName}] {
+			return false
+		}
+	}
+	// the environments of namespaces not watched are unknown
+	return len(envNamespaces) > 0
+}
+
+// ownerNamespaces returns the watched namespaces the environment owning the
+// builder may be in: the one of its owner namespace label or, for builders
+// created before the label, those whose builder namespace it's in.
+func (envw *environmentWatcher) ownerNamespaces(meta *metav1.ObjectMeta) []string {
+	if ns, ok := meta.Labels[LABEL_ENV_OWNER_NAMESPACE]; ok {
+		if _, watched := envw.envWatchInformer[ns]; watched {
+			return []string{ns}
+		}
+		return nil
+	}
+	var namespaces []string
+	for ns := range envw.envWatchInformer {
+		if envw.nsResolver.GetBuilderNS(ns) == meta.Namespace {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
@@ -0,0 +1,105 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// testBuilderObjects returns the builder deployment and service of the
+// environment of the given name, owned by the namespace and created at
+// the given time.
+func testBuilderObjects(envName, ownerNs string, created time.Time) (*appsv1.Deployment, *apiv1.Service) {
+	meta := metav1.ObjectMeta{
+		Namespace:         testNamespace,
+		Name:              envName + "-1",
+		CreationTimestamp: metav1.NewTime(created),
+		Labels: map[string]string{
+			LABEL_ENV_NAME:            envName,
+			LABEL_ENV_NAMESPACE:       testNamespace,
+			LABEL_ENV_RESOURCEVERSION: "1",
+			LABEL_DEPLOYMENT_OWNER:    BUILDER_MGR,
+		},
+	}
+	if len(ownerNs) > 0 {
+		meta.Labels[LABEL_ENV_OWNER_NAMESPACE] = ownerNs
+	}
+	svcMeta := *meta.DeepCopy()
+	return &appsv1.Deployment{ObjectMeta: meta}, &apiv1.Service{ObjectMeta: svcMeta}
+}
+
+func TestSweepOrphanedBuilders(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour)
+	live, liveSvc := testBuilderObjects(testEnvName, "", old)
+	orphan, orphanSvc := testBuilderObjects("deleted-env", testNamespace, old)
+	young, youngSvc := testBuilderObjects("new-env", testNamespace, time.Now())
+	unwatched, unwatchedSvc := testBuilderObjects("other-env", "not-watched", old)
+	draining, drainingSvc := testBuilderObjects("drained-env", testNamespace, old)
+	draining.ObjectMeta.Annotations = map[string]string{ANNOTATION_BUILDER_DRAINING: old.Format(time.RFC3339)}
+	drainingSvc.ObjectMeta.Annotations = draining.ObjectMeta.Annotations
+
+	envInformer := fInformers.NewSharedInformerFactory(fClient.NewSimpleClientset(), 0).Core().V1().Environments().Informer()
+	err := envInformer.GetStore().Add(testEnvironment())
+	if err != nil {
+		t.Fatalf("Error adding environment to informer store: %v", err)
+	}
+	envw := &environmentWatcher{
+		logger: loggerfactory.GetLogger(),
+		kubernetesClient: fake.NewSimpleClientset(live, liveSvc, orphan, orphanSvc, young, youngSvc,
+			unwatched, unwatchedSvc, draining, drainingSvc),
+		nsResolver:       utils.DefaultNSResolver(),
+		envWatchInformer: map[string]k8sCache.SharedIndexInformer{testNamespace: envInformer},
+		gcMinAge:         defaultBuilderGCMinAge,
+	}
+	envw.sweepOrphanedBuilders(ctx)
+
+	for _, test := range []struct {
+		name    string
+		deleted bool
+	}{
+		{name: live.ObjectMeta.Name},
+		{name: orphan.ObjectMeta.Name, deleted: true},
+		{name: young.ObjectMeta.Name},
+		{name: unwatched.ObjectMeta.Name},
+		{name: draining.ObjectMeta.Name},
+	} {
+		_, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, test.name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) != test.deleted {
+			t.Errorf("Expected builder deployment %s deleted %v: %v", test.name, test.deleted, err)
+		}
+		_, err = envw.kubernetesClient.CoreV1().Services(testNamespace).Get(ctx, test.name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) != test.deleted {
+			t.Errorf("Expected builder service %s deleted %v: %v", test.name, test.deleted, err)
+		}
+	}
+}
+
+func TestDeleteBuilderWithoutCachedBuilder(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	envw.nsResolver = utils.DefaultNSResolver()
+
+	// the builder was created before the builder manager restarted
+	envw.DeleteBuilder(ctx, testEnvironment())
+	_, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected builder deployment deleted, got %v", err)
+	}
+	_, err = envw.kubernetesClient.CoreV1().Services(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected builder service deleted, got %v", err)
+	}
+}
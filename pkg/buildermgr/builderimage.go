@@ -0,0 +1,165 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// rebuildOnBuilderChange reports whether the environment opted in to the
+// rebuild of its packages when its builder image changes.
+func rebuildOnBuilderChange(env *fv1.Environment) bool {
+	return env.ObjectMeta.Annotations[fv1.ANNOTATION_REBUILD_ON_BUILDER_CHANGE] == "true"
+}
+
+// environmentInformerHandler rebuilds the packages of the environments
+// opted in to rebuilds when their builder image changes. It also keeps the
+// build worker pools of the environments in line with their build
+// parallelism, and tears them down with their environment.
+func (pkgw *packageWatcher) environmentInformerHandler(ctx context.Context) k8sCache.ResourceEventHandlerFuncs {
+	return k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			env, ok := obj.(*fv1.Environment)
+			if !ok {
+				// the environment watcher counts the dropped event
+				return
+			}
+			pkgw.pools.setParallelism(env)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldEnv, ok := oldObj.(*fv1.Environment)
+			if !ok {
+				// the environment watcher counts the dropped event
+				return
+			}
+			env, ok := newObj.(*fv1.Environment)
+			if !ok {
+				return
+			}
+			pkgw.pools.setParallelism(env)
+			// builds of the environment may fit the new parallelism
+			pkgw.dispatchBuilds()
+			if oldEnv.Spec.Builder.Image == env.Spec.Builder.Image || !rebuildOnBuilderChange(env) {
+				return
+			}
+			// one status update per package, keep them off the handler
+			if !pkgw.startBuild() {
+				return
+			}
+			go func() {
+				defer pkgw.running.Done()
+				pkgw.rebuildEnvironmentPackages(ctx, env)
+			}()
+		},
+		DeleteFunc: func(obj interface{}) {
+			env, ok := eventObject(obj).(*fv1.Environment)
+			if !ok {
+				return
+			}
+			pkgw.pools.remove(env)
+		},
+	}
+}
+
+// rebuildEnvironmentPackages marks the source packages of the environment
+// pending. Their builds go through the build queue like any other, so they
+// are staggered by the concurrent build limit. Packages waiting for or in a
+// build, packages skipping their build and packages whose source archive
+// was deleted are left alone. The builds in progress are checked against the
+// builder image once they finish, see rebuildOnBuilderImageChange.
+func (pkgw *packageWatcher) rebuildEnvironmentPackages(ctx context.Context, env *fv1.Environment) {
+	logger := pkgw.logger.With(
+		zap.String("environment", env.ObjectMeta.Name),
+		zap.String("namespace", env.ObjectMeta.Namespace),
+		zap.String("builder_image", env.Spec.Builder.Image))
+	rebuilt := 0
+	for _, informer := range pkgw.pkgInformer {
+		for _, obj := range informer.GetStore().List() {
+			pkg, ok := obj.(*fv1.Package)
+			if !ok || pkg.Spec.Environment.Name != env.ObjectMeta.Name ||
+				pkg.Spec.Environment.Namespace != env.ObjectMeta.Namespace {
+				continue
+			}
+			if pkg.Spec.Source.IsEmpty() || pkg.Status.SourceDeletedTime != nil || skipBuildRequested(pkg) ||
+				pkg.Status.BuildStatus == fv1.BuildStatusPending || pkg.Status.BuildStatus == fv1.BuildStatusRunning {
+				continue
+			}
+			_, err := setPendingBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), fv1.BuildTriggerBuilderImageChanged)
+			if err != nil {
+				logger.Error("error setting package pending state",
+					zap.String("package_name", pkg.ObjectMeta.Name),
+					zap.String("package_namespace", pkg.ObjectMeta.Namespace),
+					zap.Error(err))
+				continue
+			}
+			rebuilt++
+		}
+	}
+	logger.Info("rebuilding packages after builder image change", zap.Int("packages", rebuilt))
+}
+
+// rebuildOnBuilderImageChange marks the package of a finished build pending
+// again when the builder image of its environment changed while it was
+// building, its artifact comes from the replaced image. Packages that
+// changed or are in another build since are left alone, their next build
+// uses the new image anyway.
+func (pkgw *packageWatcher) rebuildOnBuilderImageChange(b *pkgBuild) {
+	if len(b.builderImage) == 0 {
+		return
+	}
+	logger := pkgw.logger.With(
+		zap.String("package_name", b.pkg.ObjectMeta.Name),
+		zap.String("namespace", b.pkg.ObjectMeta.Namespace))
+	envRef := b.pkg.Spec.Environment
+	env, err := pkgw.fissionClient.CoreV1().Environments(envRef.Namespace).Get(b.ctx, envRef.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			logger.Error("error getting environment of finished build", zap.Error(err))
+		}
+		return
+	}
+	if env.Spec.Builder.Image == b.builderImage || !rebuildOnBuilderChange(env) {
+		return
+	}
+	pkg, err := pkgw.fissionClient.CoreV1().Packages(b.pkg.ObjectMeta.Namespace).Get(b.ctx, b.pkg.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			logger.Error("error getting package of finished build", zap.Error(err))
+		}
+		return
+	}
+	if pkg.ObjectMeta.UID != b.pkg.ObjectMeta.UID || pkg.ObjectMeta.Generation != b.pkg.ObjectMeta.Generation ||
+		pkg.Status.BuildStatus == fv1.BuildStatusPending || pkg.Status.BuildStatus == fv1.BuildStatusRunning ||
+		skipBuildRequested(pkg) || pkg.Status.SourceDeletedTime != nil {
+		return
+	}
+	_, err = setPendingBuildStatus(b.ctx, pkgw.fissionClient, pkg, fv1.BuildTriggerBuilderImageChanged)
+	if err != nil {
+		logger.Error("error setting package pending state", zap.Error(err))
+		return
+	}
+	logger.Info("rebuilding package built with a replaced builder image",
+		zap.String("built_with", b.builderImage),
+		zap.String("builder_image", env.Spec.Builder.Image))
+}
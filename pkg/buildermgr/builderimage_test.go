@@ -0,0 +1,128 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+)
+
+func TestBuilderImageChangeRebuildsPackages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+	addPackage := func(name string, status fv1.BuildStatus, mutate func(pkg *fv1.Package)) {
+		t.Helper()
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = name
+		pkg.Status.BuildStatus = status
+		if mutate != nil {
+			mutate(pkg)
+		}
+		_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(ctx, pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+		err = pkgInformer.GetStore().Add(pkg)
+		if err != nil {
+			t.Fatalf("Error adding package to the informer store: %v", err)
+		}
+	}
+	addPackage("succeeded", fv1.BuildStatusSucceeded, nil)
+	addPackage("failed", fv1.BuildStatusFailed, nil)
+	addPackage("running", fv1.BuildStatusRunning, nil)
+	addPackage("skipped", fv1.BuildStatusNone, func(pkg *fv1.Package) {
+		pkg.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_SKIP_BUILD: "true"}
+	})
+	addPackage("deploy-only", fv1.BuildStatusNone, func(pkg *fv1.Package) {
+		pkg.Spec.Source = fv1.Archive{}
+		pkg.Spec.Deployment = fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/deploy"}
+	})
+	addPackage("other-env", fv1.BuildStatusSucceeded, func(pkg *fv1.Package) {
+		pkg.Spec.Environment.Name = "other-env"
+	})
+
+	handler := tpw.environmentInformerHandler(ctx)
+	oldEnv := tpw.env.DeepCopy()
+	oldEnv.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_REBUILD_ON_BUILDER_CHANGE: "true"}
+
+	// other environment changes don't rebuild
+	relabeled := oldEnv.DeepCopy()
+	relabeled.ObjectMeta.Labels = map[string]string{"team": "a"}
+	handler.OnUpdate(oldEnv, relabeled)
+	// environments not opted in don't rebuild
+	notOptedIn := tpw.env.DeepCopy()
+	notOptedIn.Spec.Builder.Image = "builder-image:v2"
+	handler.OnUpdate(tpw.env, notOptedIn)
+	tpw.running.Wait()
+	if n := tpw.countPackageUpdates(fv1.BuildStatusPending); n != 0 {
+		t.Fatalf("Expected no rebuilds, got %d pending status updates", n)
+	}
+
+	updated := oldEnv.DeepCopy()
+	updated.Spec.Builder.Image = "builder-image:v2"
+	handler.OnUpdate(oldEnv, updated)
+	// the packages are marked off the handler
+	tpw.running.Wait()
+	for name, expected := range map[string]fv1.BuildStatus{
+		"succeeded":   fv1.BuildStatusPending,
+		"failed":      fv1.BuildStatusPending,
+		"running":     fv1.BuildStatusRunning,
+		"skipped":     fv1.BuildStatusNone,
+		"deploy-only": fv1.BuildStatusNone,
+		"other-env":   fv1.BuildStatusSucceeded,
+	} {
+		pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting package: %v", err)
+		}
+		if pkg.Status.BuildStatus != expected {
+			t.Errorf("Expected build status %s of package %s, got %s", expected, name, pkg.Status.BuildStatus)
+		}
+	}
+}
+
+func TestBuilderImageChangeDuringBuildRebuildsPackage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	env := tpw.env.DeepCopy()
+	env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_REBUILD_ON_BUILDER_CHANGE: "true"}
+	_, err := tpw.fissionClient.CoreV1().Environments(testNamespace).Update(ctx, env, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating environment: %v", err)
+	}
+	pkg := tpw.pkg.DeepCopy()
+	pkg.Status.BuildStatus = fv1.BuildStatusSucceeded
+	_, err = tpw.fissionClient.CoreV1().Packages(testNamespace).UpdateStatus(ctx, pkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+
+	// built with the current image
+	tpw.rebuildOnBuilderImageChange(&pkgBuild{pkg: pkg, ctx: ctx, builderImage: env.Spec.Builder.Image})
+	if n := tpw.countPackageUpdates(fv1.BuildStatusPending); n != 0 {
+		t.Fatalf("Expected no rebuild, got %d pending status updates", n)
+	}
+
+	// the image changed while the package was building
+	tpw.rebuildOnBuilderImageChange(&pkgBuild{pkg: pkg, ctx: ctx, builderImage: "builder-image:v0"})
+	updated, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if updated.Status.BuildStatus != fv1.BuildStatusPending {
+		t.Fatalf("Expected build status %s, got %s", fv1.BuildStatusPending, updated.Status.BuildStatus)
+	}
+	if updated.Status.BuildTrigger != fv1.BuildTriggerBuilderImageChanged {
+		t.Errorf("Expected build trigger %s, got %s", fv1.BuildTriggerBuilderImageChanged, updated.Status.BuildTrigger)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"math/rand"
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// builderLoad counts the builds in flight on each builder pod, so that the
+// builds of an environment with several builder replicas are spread over
+// its pods.
+type builderLoad struct {
+	mu sync.Mutex
+	// builds counts the builds in flight by pod namespace/name
+	builds map[string]int
+}
+
+func newBuilderLoad() *builderLoad {
+	return &builderLoad{builds: make(map[string]int)}
+}
+
+// builderPodName returns the namespace/name of the builder pod.
+func builderPodName(pod *apiv1.Pod) string {
+	return pod.ObjectMeta.Namespace + "/" + pod.ObjectMeta.Name
+}
+
+// acquire picks the pod with the fewest builds in flight among the ready
+// builder pods, a random one of them on ties, and counts the build on it.
+// The build is released once done. Without load tracking the first pod is
+// picked.
+func (l *builderLoad) acquire(pods []*apiv1.Pod) *apiv1.Pod {
+	if len(pods) == 0 {
+		return nil
+	}
+	if l == nil {
+		return pods[0]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var least []*apiv1.Pod
+	for _, pod := range pods {
+		switch n := l.builds[builderPodName(pod)]; {
+		case len(least) == 0 || n < l.builds[builderPodName(least[0])]:
+			least = []*apiv1.Pod{pod}
+		case n == l.builds[builderPodName(least[0])]:
+			least = append(least, pod)
+		}
+	}
+	pod := least[rand.Intn(len(least))]
+	l.builds[builderPodName(pod)]++
+	return pod
+}
+
+// release ends a build counted on the pod, given as namespace/name.
+func (l *builderLoad) release(pod string) {
+	if l == nil || len(pod) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.builds[pod] <= 1 {
+		// pods are forgotten once idle, deleted pods don't pile up
+		delete(l.builds, pod)
+		return
+	}
+	l.builds[pod]--
+}
+
+// inFlight returns the number of builds in flight on the pod.
+func (l *builderLoad) inFlight(pod string) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.builds[pod]
+}
@@ -0,0 +1,88 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func testBuilderPods(n int) []*apiv1.Pod {
+	var pods []*apiv1.Pod
+	for i := 0; i < n; i++ {
+		pod := testBuilderPod(testEnvironment())
+		pod.ObjectMeta.Name = pod.ObjectMeta.Name + "-" + string(rune('a'+i))
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func TestBuilderLoadPicksLeastLoadedPod(t *testing.T) {
+	load := newBuilderLoad()
+	pods := testBuilderPods(3)
+
+	// the builds are spread over the pods
+	for i := 0; i < 6; i++ {
+		load.acquire(pods)
+	}
+	for _, pod := range pods {
+		if n := load.inFlight(builderPodName(pod)); n != 2 {
+			t.Errorf("Expected 2 builds in flight on pod %s, got %d", pod.ObjectMeta.Name, n)
+		}
+	}
+
+	load.release(builderPodName(pods[1]))
+	if pod := load.acquire(pods); pod != pods[1] {
+		t.Errorf("Expected least loaded pod %s picked, got %s", pods[1].ObjectMeta.Name, pod.ObjectMeta.Name)
+	}
+
+	for i := 0; i < 2; i++ {
+		load.release(builderPodName(pods[0]))
+	}
+	if n := load.inFlight(builderPodName(pods[0])); n != 0 || len(load.builds) != 2 {
+		t.Errorf("Expected idle pod forgotten, got %d builds in flight and %d pods tracked", n, len(load.builds))
+	}
+}
+
+func TestExecuteBuildPicksReadyBuilderPod(t *testing.T) {
+	tb := newTestBuild(t)
+	pods := testBuilderPods(3)
+	pods[0].Status.ContainerStatuses[0].Ready = false
+	for i, pod := range pods {
+		pod.Status.PodIP = "10.0.0." + string(rune('1'+i))
+	}
+	tb.pods.pods = pods
+	load := newBuilderLoad()
+	// the second pod is busy with another build
+	load.builds[builderPodName(pods[1])] = 1
+	tb.deps.builderLoad = load
+
+	var address string
+	var inFlight int
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		address = builderAddress(ctx, tb.env, envBuilderNamespace)
+		inFlight = load.inFlight(builderPodName(pods[2]))
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if address != "10.0.0.3" || inFlight != 1 {
+		t.Errorf("Expected build sent to the idle ready pod, got address %q with %d builds in flight", address, inFlight)
+	}
+	if n := load.inFlight(builderPodName(pods[2])); n != 0 {
+		t.Errorf("Expected build released from the pod once done, got %d builds in flight", n)
+	}
+	if n := load.inFlight(builderPodName(pods[1])); n != 1 {
+		t.Errorf("Expected busy pod left alone, got %d builds in flight", n)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// builderLogLines is the number of trailing lines of the builder and
+	// fetcher container logs added to the build logs of builds whose
+	// builder pod stopped answering.
+	builderLogLines = 50
+	// builderLogTimeout bounds the time spent getting them.
+	builderLogTimeout = 10 * time.Second
+)
+
+// builderLogContainers are the containers of the builder pods whose logs
+// tell why the pod stopped answering.
+var builderLogContainers = []string{"builder", "fetcher"}
+
+// builderPodLogs returns the last lines of the builder and fetcher container
+// logs of the builder pod, given as namespace/name, as build logs of the
+// failed phase of the build started at since. A pod that's gone is noted
+// instead.
+func builderPodLogs(ctx context.Context, logger *zap.Logger, k8sClient kubernetes.Interface, pod string, since time.Time, phase string) string {
+	namespace, name, ok := strings.Cut(pod, "/")
+	if k8sClient == nil || !ok {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, builderLogTimeout)
+	defer cancel()
+
+	p, err := k8sClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return logf(logPhaseBuildermgr, "Builder pod %s is gone, its container logs are lost", pod)
+	}
+	if err != nil {
+		logger.Warn("error getting builder pod for its logs", zap.String("pod", pod), zap.Error(err))
+		return logf(logPhaseBuildermgr, "Error getting builder pod %s for its container logs: %v", pod, err)
+	}
+
+	var logs string
+	for _, container := range builderLogContainers {
+		status := containerStatus(p, container)
+		if status == nil {
+			continue
+		}
+		// the logs of the previous run of a container restarted
+		// during the build are the ones of the crash
+		terminated := status.LastTerminationState.Terminated
+		previous := terminated != nil && !terminated.FinishedAt.Time.Before(since)
+		tail := int64(builderLogLines)
+		req := k8sClient.CoreV1().Pods(namespace).GetLogs(name, &apiv1.PodLogOptions{
+			Container: container,
+			TailLines: &tail,
+			Previous:  previous,
+		})
+		out, err := readPodLogs(ctx, req)
+		if err != nil {
+			logger.Warn("error getting builder container logs", zap.String("pod", pod),
+				zap.String("container", container), zap.Error(err))
+			logs += logf(logPhaseBuildermgr, "Error getting the %s container logs of builder pod %s: %v", container, pod, err)
+			continue
+		}
+		run := "current"
+		if previous {
+			run = "crashed"
+		}
+		logs += logf(logPhaseBuildermgr, "Last %d lines of the %s %s container logs of builder pod %s:", builderLogLines, run, container, pod)
+		logs += logLines(phase, out)
+	}
+	return logs
+}
+
+// containerStatus returns the status of the container of the pod, nil if
+// the container didn't start yet.
+func containerStatus(pod *apiv1.Pod, name string) *apiv1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+func readPodLogs(ctx context.Context, req *rest.Request) (string, error) {
+	r, err := req.Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading container logs")
+	}
+	return string(out), nil
+}
@@ -0,0 +1,83 @@
+package buildermgr
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestBuilderPodLogs(t *testing.T) {
+	since := time.Now()
+	pod := testBuilderPod(testEnvironment())
+	// the builder crashed during the build
+	pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &apiv1.ContainerStateTerminated{
+		Reason:     "OOMKilled",
+		FinishedAt: metav1.NewTime(since.Add(time.Second)),
+	}
+	k8sClient := fake.NewSimpleClientset(pod)
+	var previous []bool
+	k8sClient.PrependReactor("get", "pods", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "log" {
+			opts := action.(k8sTesting.GenericAction).GetValue().(*apiv1.PodLogOptions)
+			previous = append(previous, opts.Previous)
+		}
+		return false, nil, nil
+	})
+
+	logs := builderPodLogs(context.Background(), loggerfactory.GetLogger(), k8sClient, builderPodName(pod), since, logPhaseBuild)
+	for _, want := range []string{
+		"Last 50 lines of the crashed builder container logs of builder pod " + testNamespace + "/builder-pod:",
+		"Last 50 lines of the current fetcher container logs of builder pod " + testNamespace + "/builder-pod:",
+		" build] fake logs\n",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Expected %q in the builder logs, got %q", want, logs)
+		}
+	}
+	if len(previous) != 2 || !previous[0] || previous[1] {
+		t.Errorf("Expected the previous logs of the crashed builder only, got %v", previous)
+	}
+
+	logs = builderPodLogs(context.Background(), loggerfactory.GetLogger(), fake.NewSimpleClientset(), builderPodName(pod), since, logPhaseBuild)
+	if !strings.Contains(logs, "Builder pod "+testNamespace+"/builder-pod is gone, its container logs are lost") {
+		t.Errorf("Expected the gone builder pod noted, got %q", logs)
+	}
+}
+
+func TestExecuteBuildAddsLogsOfUnreachableBuilder(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.k8sClient = fake.NewSimpleClientset(testBuilderPod(tb.env))
+	tb.deps.buildPackage = nil
+	tb.deps.runBuild = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+		result := newPackageBuildResult()
+		// the builder pod died mid-build
+		err := &url.Error{Op: "Post", URL: "http://builder:8001", Err: errors.New("EOF")}
+		result.observe(logPhaseBuild, time.Now(), err)
+		return result.fail(logPhaseBuild, ErrBuildFailed, logf(logPhaseBuild, "Error building deployment package: %v", err), err)
+	}
+
+	_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if !errors.Is(err, ErrBuilderUnreachable) {
+		t.Fatalf("Expected unreachable builder, got %v", err)
+	}
+	pkg := tb.getPackage(t)
+	if !strings.Contains(pkg.Status.BuildLog, "container logs of builder pod") || !strings.Contains(pkg.Status.BuildLog, "fake logs") {
+		t.Errorf("Expected the builder container logs in the build logs, got %q", pkg.Status.BuildLog)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonBuilderUnreachable)
+}
@@ -18,6 +18,7 @@ package buildermgr
 
 import (
 	"context"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
@@ -30,9 +31,67 @@ import (
 	"github.com/fission/fission/pkg/utils"
 )
 
-// Start the buildermgr service.
-func Start(ctx context.Context, logger *zap.Logger, storageSvcUrl string) error {
+// Start the buildermgr service. maxConcurrentBuilds limits the number of
+// package builds running at the same time, a value <= 0 means no limit.
+// maxBuildRetries is the number of times a failed build is retried.
+// buildTimeout is the deadline of builds of packages that don't set
+// their own, a value <= 0 means no deadline. maxBuildLogSize is the maximum
+// size in bytes of the build logs stored in package status, a value <= 0
+// means no limit. The builder manager API is served on apiPort. The build
+// queue is persisted in the queueLedger config map of the pod namespace,
+// an empty name disables the ledger. Replicas elect the one running the
+// package builds and environment status reports with the leaderElectionLease
+// lease of the pod namespace, an empty name runs them without election.
+// Standby replicas forward build requests of the API to the leader. Once ctx
+// is done, no build starts anymore and running builds get shutdownGracePeriod
+// to finish, the packages of builds that don't are put back into pending
+// state for the next builder manager. envBuildParallelism limits the
+// number of package builds of each environment running at the same time,
+// so that environments make progress independently of each other, a value
+// <= 0 means no limit. maxNamespaceBuilds limits the number of package
+// builds of each namespace running at the same time, namespaces override
+// it with the max concurrent builds annotation, a value <= 0 means no
+// limit. Rebuilds of packages whose source is unchanged since their last
+// build, finished less than rebuildCooldown ago, wait for the end of the
+// cooldown, a value <= 0 disables it. The upload of a deployment archive
+// is retried uploadRetries times while the storage service is unavailable.
+// Builds whose source archive exceeds maxSourceArchiveSize bytes fail
+// before they're dispatched, and those whose deployment archive exceeds
+// maxDeploymentArchiveSize bytes before it's uploaded, environments
+// override the limits with annotations, a value <= 0 means no limit.
+// The requests to the builder pods carry the token of the
+// builderAuthSecret secret of the pod namespace, created unless it exists,
+// an empty name sends them without token. The package labels and
+// annotations prefixed with buildMetadataPrefix are passed to the build
+// command as build metadata, an empty prefix passes none. Deployment
+// archives identical to the current one of the package aren't uploaded
+// unless forceArchiveUpload is set. The source archives of the packages
+// are deleted from the storage service once built if deleteSourceAfterBuild
+// is set, unless the packages may be rebuilt automatically. The builder
+// replaced by a builder change of its environment gets no new builds, it's
+// deleted once its builds in flight are done, or once builderDrainGracePeriod
+// is over, a value < 0 uses the default grace period.
+// Start returns once the package builds are stopped, or once the replica
+// lost the leadership.
+func Start(ctx context.Context, logger *zap.Logger, storageSvcUrl string, maxConcurrentBuilds int, maxBuildRetries int,
+	buildTimeout time.Duration, maxBuildLogSize int, apiPort int, queueLedger string, leaderElectionLease string,
+	shutdownGracePeriod time.Duration, envBuildParallelism int, maxNamespaceBuilds int, builderBackoff BuilderBackoff,
+	buildNotificationURL string, rebuildCooldown time.Duration, gcDeploymentArchives bool,
+	skipArchiveVerification bool, uploadRetries int, maxSourceArchiveSize int64, maxDeploymentArchiveSize int64,
+	builderAuthSecret string, buildMetadataPrefix string, forceArchiveUpload bool, deleteSourceAfterBuild bool,
+	builderDrainGracePeriod time.Duration) error {
 	bmLogger := logger.Named("builder_manager")
+	err := builderBackoff.Validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid builder health check backoff")
+	}
+	if len(buildNotificationURL) > 0 {
+		err = validateNotificationURL(buildNotificationURL)
+		if err != nil {
+			return err
+		}
+	}
+	go logDroppedEvents(ctx, bmLogger)
 
 	clientGen := crd.NewClientGenerator()
 	fissionClient, err := clientGen.GetFissionClient()
@@ -49,6 +108,11 @@ func Start(ctx context.Context, logger *zap.Logger, storageSvcUrl string) error
 		return errors.Wrap(err, "error waiting for CRDs")
 	}
 
+	err = validateStorageSvcMapping(ctx, bmLogger)
+	if err != nil {
+		return errors.Wrap(err, "invalid storage service mapping")
+	}
+
 	fetcherConfig, err := fetcherConfig.MakeFetcherConfig("/packages")
 	if err != nil {
 		return errors.Wrap(err, "error making fetcher config")
@@ -59,13 +123,142 @@ func Start(ctx context.Context, logger *zap.Logger, storageSvcUrl string) error
 		logger.Warn("error reading data for pod spec patch", zap.String("path", fv1.BuilderPodSpecPath), zap.Error(err))
 	}
 
+	var auth *builderAuth
+	if len(builderAuthSecret) > 0 {
+		auth = newBuilderAuth(bmLogger, kubernetesClient, podNamespace(), builderAuthSecret)
+		// the secret is created before the first builder needs it
+		_, err = auth.withToken(ctx, podNamespace())
+		if err != nil {
+			return err
+		}
+	}
+
 	envWatcher := makeEnvironmentWatcher(ctx, bmLogger, fissionClient, kubernetesClient, fetcherConfig, podSpecPatch)
-	envWatcher.Run(ctx)
+	envWatcher.builderAuth = auth
+	if builderDrainGracePeriod >= 0 {
+		envWatcher.drainGracePeriod = builderDrainGracePeriod
+	}
+
+	podInformer := utils.GetK8sInformersForNamespaces(kubernetesClient, time.Minute*30, fv1.Pods)
+	pkgInformer := utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.PackagesResource)
+
+	recorder := newEventRecorder(kubernetesClient)
+	envStatusReporter := makeEnvStatusReporter(bmLogger, fissionClient, podInformer, pkgInformer)
+	envStatusReporter.deployInformer = utils.GetK8sInformersForNamespaces(kubernetesClient, time.Minute*30, fv1.Deployments)
+	envStatusReporter.recorder = recorder
+	for _, informer := range envStatusReporter.deployInformer {
+		go informer.Run(ctx.Done())
+	}
+	// the builder namespaces set by environments are watched once their
+	// builders are looked up
+	podInformers := newNamespaceInformers(bmLogger, kubernetesClient, time.Minute*30, newPodInformer, ctx.Done())
+	envStatusReporter.podInformers = podInformers
+	envStatusReporter.deployInformers = newNamespaceInformers(bmLogger, kubernetesClient, time.Minute*30, newDeploymentInformer, ctx.Done())
+
+	impact := makeImpactResolver(bmLogger, fissionClient, pkgInformer)
 
 	pkgWatcher := makePackageWatcher(bmLogger, fissionClient,
-		kubernetesClient, storageSvcUrl,
-		utils.GetK8sInformersForNamespaces(kubernetesClient, time.Minute*30, fv1.Pods),
-		utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.PackagesResource))
-	pkgWatcher.Run(ctx)
+		kubernetesClient, storageSvcUrl, maxConcurrentBuilds, maxBuildRetries, buildTimeout, maxBuildLogSize,
+		builderBackoff, podInformer, pkgInformer)
+	pkgWatcher.deps.recorder = recorder
+	pkgWatcher.watchBuilderNamespaces(podInformers)
+	pkgWatcher.deps.fnInformer = impact.fnInformer
+	pkgWatcher.deps.buildJobs = &buildJobs{k8sClient: kubernetesClient, podTemplate: envWatcher.builderPodTemplate}
+	pkgWatcher.deps.builderAuth = auth
+	pkgWatcher.deps.buildMetadataPrefix = buildMetadataPrefix
+	pkgWatcher.deps.forceArchiveUpload = forceArchiveUpload
+	if shutdownGracePeriod >= 0 {
+		pkgWatcher.shutdownGracePeriod = shutdownGracePeriod
+	}
+	pkgWatcher.pools = newEnvPools(pkgWatcher.logger, envBuildParallelism)
+	pkgWatcher.namespaceLimits = newNamespaceLimits(pkgWatcher.logger, kubernetesClient, maxNamespaceBuilds)
+	pkgWatcher.notifier.url = buildNotificationURL
+	pkgWatcher.rebuildCooldown = newRebuildCooldown(rebuildCooldown)
+	if gcDeploymentArchives {
+		pkgWatcher.archiveGC = newArchiveGC()
+	}
+	pkgWatcher.deleteSourceAfterBuild = deleteSourceAfterBuild
+	if skipArchiveVerification {
+		pkgWatcher.deps.checkArchive = checkArchiveFetchable
+	}
+	if uploadRetries >= 0 {
+		pkgWatcher.deps.uploadRetry = defaultUploadRetryPolicy(uploadRetries)
+	}
+	pkgWatcher.deps.archiveLimits = archiveSizeLimits{
+		Source:     maxSourceArchiveSize,
+		Deployment: maxDeploymentArchiveSize,
+	}
+	pkgWatcher.notifier.secret = []byte(os.Getenv("BUILD_NOTIFICATION_SECRET"))
+	if len(queueLedger) > 0 {
+		pkgWatcher.ledger = newQueueLedger(pkgWatcher.logger, kubernetesClient, podNamespace(), queueLedger)
+	}
+	envWatcher.builderLoad = pkgWatcher.deps.builderLoad
+	envWatcher.pkgInformer = pkgInformer
+	envWatcher.Run(ctx)
+	pkgWatcher.StartInformers(ctx)
+	// lead starts the package builds, the rebuilds on builder image
+	// changes, the environment status reports and the sweeps of the
+	// builders of deleted environments of the replica running them
+	lead := func(ctx context.Context) {
+		for _, informer := range envWatcher.envWatchInformer {
+			informer.AddEventHandler(pkgWatcher.environmentInformerHandler(ctx))
+		}
+		envStatusReporter.Run(ctx)
+		go envWatcher.runBuilderGC(ctx)
+		pkgWatcher.Run(ctx)
+	}
+
+	impact.Run(ctx)
+
+	api := &builderMgrAPI{
+		logger:   bmLogger,
+		migrator: makeLiteralMigrator(bmLogger, fissionClient, storageSvcUrl),
+		impact:   impact,
+		builds:   pkgWatcher,
+
+		k8sClient: kubernetesClient,
+		namespace: podNamespace(),
+		port:      apiPort,
+		readiness: newReadinessChecks(fissionClient),
+	}
+	api.readiness.addInformers("environments", envWatcher.envWatchInformer)
+	api.readiness.addInformers("pods", podInformer)
+	api.readiness.addInformers("packages", pkgInformer)
+	api.readiness.addInformers("functions", impact.fnInformer)
+	api.readiness.leaderElection = len(leaderElectionLease) > 0
+	go api.Serve(ctx, apiPort)
+
+	if len(leaderElectionLease) > 0 {
+		le := &leaderElection{
+			logger:    bmLogger,
+			k8sClient: kubernetesClient,
+			namespace: podNamespace(),
+			lease:     leaderElectionLease,
+			identity:  replicaIdentity(),
+			lead: func(ctx context.Context) {
+				pkgWatcher.abandonRunningBuilds = true
+				lead(ctx)
+			},
+			stop:        pkgWatcher.Shutdown,
+			gracePeriod: pkgWatcher.shutdownGracePeriod,
+			newLeader:   api.setLeader,
+		}
+		return le.run(ctx)
+	}
+
+	lead(ctx)
+	// give running builds the chance to finish, so that their packages
+	// don't stay in running state
+	<-ctx.Done()
+	pkgWatcher.Shutdown(pkgWatcher.shutdownGracePeriod)
 	return nil
 }
+
+// podNamespace returns the namespace of the builder manager pod.
+func podNamespace() string {
+	ns := os.Getenv("POD_NAMESPACE")
+	if len(ns) == 0 {
+		return "fission"
+	}
+	return ns
+}
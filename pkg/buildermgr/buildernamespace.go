@@ -0,0 +1,166 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sInformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// builderNamespace returns the namespace of the builder of the environment:
+// the one of its builder namespace annotation, or the builder namespace of
+// the environment namespace. Invalid namespace names are ignored.
+func builderNamespace(nsResolver *utils.NamespaceResolver, env *fv1.Environment) string {
+	ns := env.ObjectMeta.Annotations[fv1.ANNOTATION_BUILDER_NAMESPACE]
+	if len(ns) > 0 && len(validation.IsDNS1123Label(ns)) == 0 {
+		return ns
+	}
+	return nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)
+}
+
+// builderOfEnvironment reports whether the builder labels are those of a
+// builder of the environment namespace. Environments of the same name may
+// share a builder namespace, builders created before the label are taken
+// as the environment's.
+func builderOfEnvironment(labels map[string]string, env *fv1.Environment) bool {
+	ns, ok := labels[LABEL_ENV_OWNER_NAMESPACE]
+	return !ok || ns == env.ObjectMeta.Namespace
+}
+
+// ownerLabels returns a copy of the builder labels with the namespace of
+// the environment added. It isn't part of the selectors, the builders
+// created before it keep being found.
+func ownerLabels(labels map[string]string, env *fv1.Environment) map[string]string {
+	owned := map[string]string{LABEL_ENV_OWNER_NAMESPACE: env.ObjectMeta.Namespace}
+	for k, v := range labels {
+		owned[k] = v
+	}
+	return owned
+}
+
+// environmentServices and environmentDeployments drop the builder services
+// and deployments of environments of other namespaces.
+func environmentServices(svcList []apiv1.Service, env *fv1.Environment) []apiv1.Service {
+	var owned []apiv1.Service
+	for _, svc := range svcList {
+		if builderOfEnvironment(svc.ObjectMeta.Labels, env) {
+			owned = append(owned, svc)
+		}
+	}
+	return owned
+}
+
+func environmentDeployments(deployList []appsv1.Deployment, env *fv1.Environment) []appsv1.Deployment {
+	var owned []appsv1.Deployment
+	for _, deploy := range deployList {
+		if builderOfEnvironment(deploy.ObjectMeta.Labels, env) {
+			owned = append(owned, deploy)
+		}
+	}
+	return owned
+}
+
+// namespaceInformers are the informers of the builder namespaces outside
+// of the fission namespaces, whose informers are created at startup. They're
+// created once the builders of environments overriding their builder
+// namespace are looked up.
+type namespaceInformers struct {
+	logger      *zap.Logger
+	k8sClient   kubernetes.Interface
+	resync      time.Duration
+	newInformer func(factory k8sInformers.SharedInformerFactory) k8sCache.SharedIndexInformer
+	stop        <-chan struct{}
+
+	mu        sync.Mutex
+	informers map[string]k8sCache.SharedIndexInformer
+	// setups are run on every informer before it's started
+	setups []func(informer k8sCache.SharedIndexInformer)
+}
+
+// newNamespaceInformers returns the informers made by newInformer of the
+// namespaces outside of the fission namespaces, they run until stop is
+// closed.
+func newNamespaceInformers(logger *zap.Logger, k8sClient kubernetes.Interface, resync time.Duration,
+	newInformer func(factory k8sInformers.SharedInformerFactory) k8sCache.SharedIndexInformer, stop <-chan struct{}) *namespaceInformers {
+	return &namespaceInformers{
+		logger:      logger,
+		k8sClient:   k8sClient,
+		resync:      resync,
+		newInformer: newInformer,
+		stop:        stop,
+		informers:   make(map[string]k8sCache.SharedIndexInformer),
+	}
+}
+
+// newPodInformer and newDeploymentInformer make the informers of the
+// builder pods and deployments.
+func newPodInformer(factory k8sInformers.SharedInformerFactory) k8sCache.SharedIndexInformer {
+	return factory.Core().V1().Pods().Informer()
+}
+
+func newDeploymentInformer(factory k8sInformers.SharedInformerFactory) k8sCache.SharedIndexInformer {
+	return factory.Apps().V1().Deployments().Informer()
+}
+
+// forEach runs the setup on the informers, those created later included.
+// Indexers are added before any informer is created, they can't be added
+// to running informers.
+func (n *namespaceInformers) forEach(setup func(informer k8sCache.SharedIndexInformer)) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.setups = append(n.setups, setup)
+	for _, informer := range n.informers {
+		setup(informer)
+	}
+}
+
+// ensure returns the informer of the namespace, created and started unless
+// it exists, false without namespace informers. The informer store is
+// empty until it's synced, the builders are looked up again meanwhile.
+func (n *namespaceInformers) ensure(ns string) (k8sCache.SharedIndexInformer, bool) {
+	if n == nil {
+		return nil, false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if informer, ok := n.informers[ns]; ok {
+		return informer, true
+	}
+	factory := k8sInformers.NewSharedInformerFactoryWithOptions(n.k8sClient, n.resync, k8sInformers.WithNamespace(ns))
+	informer := n.newInformer(factory)
+	for _, setup := range n.setups {
+		setup(informer)
+	}
+	n.informers[ns] = informer
+	go informer.Run(n.stop)
+	n.logger.Info("watching builder namespace", zap.String("namespace", ns))
+	return informer, true
+}
@@ -0,0 +1,94 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+const testTenantNamespace = "tenant-builders"
+
+func TestBuilderNamespace(t *testing.T) {
+	nsResolver := utils.DefaultNSResolver()
+	env := testEnvironment()
+	if ns := builderNamespace(nsResolver, env); ns != nsResolver.GetBuilderNS(testNamespace) {
+		t.Errorf("Expected builder namespace of the environment namespace, got %q", ns)
+	}
+	env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILDER_NAMESPACE: testTenantNamespace}
+	if ns := builderNamespace(nsResolver, env); ns != testTenantNamespace {
+		t.Errorf("Expected builder namespace of the annotation, got %q", ns)
+	}
+	env.ObjectMeta.Annotations[fv1.ANNOTATION_BUILDER_NAMESPACE] = "Tenant_Builders"
+	if ns := builderNamespace(nsResolver, env); ns != nsResolver.GetBuilderNS(testNamespace) {
+		t.Errorf("Expected invalid builder namespace ignored, got %q", ns)
+	}
+}
+
+func TestBuilderPodOfOtherNamespaceEnvironment(t *testing.T) {
+	env := testEnvironment()
+	pod := testBuilderPod(env)
+	if !builderPodMatches(pod, env) {
+		t.Fatalf("Expected builder pod without owner namespace to match")
+	}
+	pod.ObjectMeta.Labels = ownerLabels(pod.ObjectMeta.Labels, env)
+	if !builderPodMatches(pod, env) {
+		t.Errorf("Expected builder pod of the environment namespace to match")
+	}
+	pod.ObjectMeta.Labels[LABEL_ENV_OWNER_NAMESPACE] = "other"
+	if builderPodMatches(pod, env) {
+		t.Errorf("Expected builder pod of an environment of another namespace not to match")
+	}
+}
+
+func TestListBuilderPodsWatchesBuilderNamespace(t *testing.T) {
+	env := testEnvironment()
+	pod := testBuilderPod(env)
+	pod.ObjectMeta.Namespace = testTenantNamespace
+	pod.ObjectMeta.Labels[LABEL_ENV_NAMESPACE] = testTenantNamespace
+	stop := make(chan struct{})
+	defer close(stop)
+	informers := newNamespaceInformers(loggerfactory.GetLogger(), fake.NewSimpleClientset(pod), time.Minute, newPodInformer, stop)
+	var setups int
+	informers.forEach(func(informer k8sCache.SharedIndexInformer) {
+		setups++
+		err := informer.AddIndexers(k8sCache.Indexers{builderPodIndex: builderPodIndexFunc})
+		if err != nil {
+			t.Errorf("Error adding builder pod index: %v", err)
+		}
+	})
+
+	lister := informerPodLister{logger: loggerfactory.GetLogger(), podInformer: map[string]k8sCache.SharedIndexInformer{}}
+	_, err := lister.ListBuilderPods(testTenantNamespace, env)
+	if !errors.Is(err, errNoBuilderPodInformer) {
+		t.Fatalf("Expected builder namespace not watched without namespace informers, got %v", err)
+	}
+
+	lister.informers = informers
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		pods, err := lister.ListBuilderPods(testTenantNamespace, env)
+		if err != nil {
+			t.Fatalf("Error listing builder pods: %v", err)
+		}
+		if len(pods) == 1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Expected builder pod of the builder namespace listed, got %d pods", len(pods))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if setups != 1 || len(informers.informers) != 1 {
+		t.Errorf("Expected one informer set up for the builder namespace, got %d setups of %d informers", setups, len(informers.informers))
+	}
+}
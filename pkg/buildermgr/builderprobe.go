@@ -0,0 +1,97 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// builderPingTimeout is how long the builder pod picked for a build
+	// has to answer the ping before the build is sent.
+	builderPingTimeout = 2 * time.Second
+	// maxBuilderPings is the number of builder pods pinged by a build
+	// before it gives up waiting for a reachable one.
+	maxBuilderPings = 3
+)
+
+// defaultBuilderReadinessProbe is the readiness probe of the builder
+// containers of environments without one, the health check of the
+// builder.
+func defaultBuilderReadinessProbe() *apiv1.Probe {
+	return &apiv1.Probe{
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       2,
+		ProbeHandler: apiv1.ProbeHandler{
+			HTTPGet: &apiv1.HTTPGetAction{
+				Path: "/healthz",
+				Port: intstr.IntOrString{
+					Type:   intstr.Int,
+					IntVal: 8001,
+				},
+			},
+		},
+	}
+}
+
+// applyBuilderReadinessProbe sets the readiness probe of the builder to
+// the builder container, over the one of its container and pod specs.
+func applyBuilderReadinessProbe(spec *apiv1.PodSpec, builder fv1.Builder) {
+	if builder.ReadinessProbe == nil {
+		return
+	}
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "builder" {
+			spec.Containers[i].ReadinessProbe = builder.ReadinessProbe.DeepCopy()
+		}
+	}
+}
+
+// pingBuilder reports whether the builder pod picked for the build
+// answers before the build is sent to it. Its readiness probe may not
+// have noticed yet that its builder went away, the pod is then passed
+// over until its status changes.
+func (e *buildExecution) pingBuilder(ctx context.Context, pod *apiv1.Pod, env *fv1.Environment, builderNs string) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, builderPingTimeout)
+	defer cancel()
+	err := e.probeBuilder(pingCtx, e.logger, env, builderNs)
+	if err == nil || ctx.Err() != nil {
+		// a canceled build is told apart by the caller
+		return true
+	}
+	e.logger.Warn("ready builder pod doesn't answer, waiting for another one", zap.Error(err),
+		zap.String("environment", env.ObjectMeta.Name), zap.String("pod", builderPodName(pod)))
+	if e.unreachablePods == nil {
+		e.unreachablePods = make(map[string]string)
+	}
+	e.unreachablePods[builderPodName(pod)] = pod.ObjectMeta.ResourceVersion
+	return false
+}
+
+// builderUnreachable reports whether the builder pod didn't answer the
+// ping of the build and its status hasn't changed since.
+func (e *buildExecution) builderUnreachable(pod *apiv1.Pod) bool {
+	rv, ok := e.unreachablePods[builderPodName(pod)]
+	return ok && rv == pod.ObjectMeta.ResourceVersion
+}
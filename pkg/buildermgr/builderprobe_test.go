@@ -0,0 +1,101 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func TestApplyBuilderReadinessProbe(t *testing.T) {
+	spec := &apiv1.PodSpec{Containers: []apiv1.Container{
+		{Name: "builder", ReadinessProbe: defaultBuilderReadinessProbe()},
+		{Name: "fetcher"},
+	}}
+	applyBuilderReadinessProbe(spec, fv1.Builder{})
+	if spec.Containers[0].ReadinessProbe.HTTPGet == nil || spec.Containers[0].ReadinessProbe.HTTPGet.Path != "/healthz" {
+		t.Errorf("Expected default readiness probe kept, got %+v", spec.Containers[0].ReadinessProbe)
+	}
+
+	builder := fv1.Builder{ReadinessProbe: &apiv1.Probe{
+		PeriodSeconds: 5,
+		ProbeHandler:  apiv1.ProbeHandler{HTTPGet: &apiv1.HTTPGetAction{Path: "/ready"}},
+	}}
+	applyBuilderReadinessProbe(spec, builder)
+	probe := spec.Containers[0].ReadinessProbe
+	if probe == builder.ReadinessProbe || probe.HTTPGet.Path != "/ready" || probe.PeriodSeconds != 5 {
+		t.Errorf("Expected copy of the builder readiness probe, got %+v", probe)
+	}
+	if spec.Containers[1].ReadinessProbe != nil {
+		t.Errorf("Expected fetcher container left alone, got %+v", spec.Containers[1].ReadinessProbe)
+	}
+}
+
+func TestExecuteBuildPassesOverUnreachableBuilderPod(t *testing.T) {
+	tb := newTestBuild(t)
+	pods := testBuilderPods(2)
+	for i, pod := range pods {
+		pod.Status.PodIP = "10.0.0." + string(rune('1'+i))
+	}
+	tb.pods.pods = pods
+	load := newBuilderLoad()
+	// the first pod is picked first, its builder went away
+	load.builds[builderPodName(pods[1])] = 1
+	tb.deps.builderLoad = load
+	var pinged []string
+	tb.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+		address := builderAddress(ctx, env, builderNs)
+		pinged = append(pinged, address)
+		if address == "10.0.0.1" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var address string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		address = builderAddress(ctx, tb.env, envBuilderNamespace)
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if address != "10.0.0.2" || len(pinged) != 2 {
+		t.Errorf("Expected build sent to the pod answering the ping, got address %q after pinging %v", address, pinged)
+	}
+	if n := load.inFlight(builderPodName(pods[0])); n != 0 {
+		t.Errorf("Expected build released from the unreachable pod, got %d builds in flight", n)
+	}
+}
+
+func TestExecuteBuildWaitsForReachableBuilder(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.builderBackoff = BuilderBackoff{InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, Multiplier: 1,
+		MaxWait: 100 * time.Millisecond}
+	pings := 0
+	tb.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+		pings++
+		return errors.New("connection refused")
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if result.Status != fv1.BuildStatusFailed || tb.builds != 0 {
+		t.Fatalf("Expected build failed before building, got %s with %d builds: %v", result.Status, tb.builds, err)
+	}
+	// the pod is passed over until its status changes
+	if pings != 1 {
+		t.Errorf("Expected the unreachable builder pod pinged once, got %d pings", pings)
+	}
+	checkCondition(t, tb.getPackage(t), fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNotReady)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// runtimeImagePullSecrets returns the image pull secrets of the runtime pod
+// spec of the environment.
+func runtimeImagePullSecrets(env *fv1.Environment) []apiv1.LocalObjectReference {
+	if env.Spec.Runtime.PodSpec == nil {
+		return nil
+	}
+	return env.Spec.Runtime.PodSpec.ImagePullSecrets
+}
+
+// applyBuilderImagePullSecrets adds the image pull secrets of the
+// environment, the ones of its runtime pod spec included, to the builder
+// pod spec, so that builder images of the same private registries as the
+// runtime images are pulled. The secrets are referenced by name, they need
+// to exist in the builder namespace. Secrets listed more than once are
+// kept once.
+func applyBuilderImagePullSecrets(spec *apiv1.PodSpec, env *fv1.Environment) {
+	secrets := spec.ImagePullSecrets
+	if len(env.Spec.ImagePullSecret) > 0 {
+		secrets = append(secrets, apiv1.LocalObjectReference{Name: env.Spec.ImagePullSecret})
+	}
+	secrets = append(secrets, runtimeImagePullSecrets(env)...)
+	seen := make(map[string]bool)
+	spec.ImagePullSecrets = nil
+	for _, secret := range secrets {
+		if len(secret.Name) == 0 || seen[secret.Name] {
+			continue
+		}
+		seen[secret.Name] = true
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, secret)
+	}
+}
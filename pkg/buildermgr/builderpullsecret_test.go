@@ -0,0 +1,35 @@
+package buildermgr
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestApplyBuilderImagePullSecrets(t *testing.T) {
+	env := testEnvironment()
+	env.Spec.ImagePullSecret = "registry"
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{
+		ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "runtime-registry"}, {Name: "registry"}},
+	}
+	spec := &apiv1.PodSpec{ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "patched"}}}
+	applyBuilderImagePullSecrets(spec, env)
+	expected := []apiv1.LocalObjectReference{{Name: "patched"}, {Name: "registry"}, {Name: "runtime-registry"}}
+	if !reflect.DeepEqual(spec.ImagePullSecrets, expected) {
+		t.Errorf("Expected image pull secrets %v, got %v", expected, spec.ImagePullSecrets)
+	}
+}
+
+func TestBuilderSpecHashRuntimeImagePullSecrets(t *testing.T) {
+	env := testEnvironment()
+	hash := builderSpecHash(env)
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{}
+	if builderSpecHash(env) != hash {
+		t.Errorf("Expected builder spec hash kept without runtime image pull secrets")
+	}
+	env.Spec.Runtime.PodSpec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: "runtime-registry"}}
+	if builderSpecHash(env) == hash {
+		t.Errorf("Expected builder spec hash changed by runtime image pull secrets")
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+type (
+	// builderKey identifies the builder pods of an environment.
+	builderKey struct {
+		name      string
+		namespace string
+	}
+
+	// builderReadiness wakes up the builds waiting for a builder pod of
+	// their environment when one becomes ready, so that they don't have
+	// to poll the pod informer stores.
+	builderReadiness struct {
+		mutex   sync.Mutex
+		waiters map[builderKey]*builderWaiters
+	}
+
+	// builderWaiters are the builds waiting for a builder pod of the
+	// same environment, ch is closed once one is ready.
+	builderWaiters struct {
+		ch chan struct{}
+		n  int
+	}
+)
+
+func newBuilderReadiness() *builderReadiness {
+	return &builderReadiness{
+		waiters: make(map[builderKey]*builderWaiters),
+	}
+}
+
+// envBuilderKey returns the key of the builder pods of the environment in
+// the builder namespace.
+func envBuilderKey(env *fv1.Environment, builderNs string) builderKey {
+	return builderKey{
+		name:      env.ObjectMeta.Name,
+		namespace: builderNs,
+	}
+}
+
+// podBuilderKey returns the key of the environment of the builder pod.
+func podBuilderKey(pod *apiv1.Pod) builderKey {
+	return builderKey{
+		name:      pod.ObjectMeta.Labels[LABEL_ENV_NAME],
+		namespace: pod.ObjectMeta.Labels[LABEL_ENV_NAMESPACE],
+	}
+}
+
+// builderPodIndex indexes the pods of the pod informers by the builderKey
+// of their environment.
+const builderPodIndex = "builderEnv"
+
+func (k builderKey) String() string {
+	return fmt.Sprintf("%s/%s", k.namespace, k.name)
+}
+
+// builderSpecHash returns the hash of the parts of the environment spec
+// the builder pods are made of. Environment updates leaving them alone,
+// such as annotations added by other controllers, keep the builder pods.
+// The builder replicas scale the builder deployment in place, they're left
+// out.
+func builderSpecHash(env *fv1.Environment) string {
+	builder := env.Spec.Builder
+	builder.Replicas = 0
+	spec, err := json.Marshal(struct {
+		Version                      int
+		Builder                      fv1.Builder
+		ImagePullSecret              string
+		AllowAccessToExternalNetwork bool
+		// left out without runtime image pull secrets, the hash of
+		// the builders created before is kept
+		RuntimeImagePullSecrets []apiv1.LocalObjectReference `json:",omitempty"`
+	}{
+		Version:                      env.Spec.Version,
+		Builder:                      builder,
+		ImagePullSecret:              env.Spec.ImagePullSecret,
+		AllowAccessToExternalNetwork: env.Spec.AllowAccessToExternalNetwork,
+		RuntimeImagePullSecrets:      runtimeImagePullSecrets(env),
+	})
+	if err != nil {
+		// only the builder pods of this environment version match
+		return env.ObjectMeta.ResourceVersion
+	}
+	h := fnv.New32a()
+	h.Write(spec)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// builderPodMatches reports whether the builder pod runs the current
+// builder spec of the environment. Pods created before the builder spec
+// hash label existed match the environment version they were created for.
+// Pods of environments of other namespaces sharing the builder namespace
+// never match.
+func builderPodMatches(pod *apiv1.Pod, env *fv1.Environment) bool {
+	if !builderOfEnvironment(pod.ObjectMeta.Labels, env) {
+		return false
+	}
+	if hash, ok := pod.ObjectMeta.Labels[LABEL_ENV_BUILDER_HASH]; ok {
+		return hash == builderSpecHash(env)
+	}
+	return pod.ObjectMeta.Labels[LABEL_ENV_RESOURCEVERSION] == env.ObjectMeta.ResourceVersion
+}
+
+// builderPodIndexFunc indexes the builder pods by the key of their
+// environment, other pods are left out of the index.
+func builderPodIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*apiv1.Pod)
+	if !ok || len(pod.ObjectMeta.Labels[LABEL_ENV_NAME]) == 0 {
+		return nil, nil
+	}
+	return []string{podBuilderKey(pod).String()}, nil
+}
+
+// builderPods returns the builder pods of the environment in the builder
+// namespace from the informer store, using the builder pod index if the
+// informer has it.
+func builderPods(logger *zap.Logger, informer k8sCache.SharedIndexInformer, env *fv1.Environment, builderNs string) []*apiv1.Pod {
+	key := envBuilderKey(env, builderNs)
+	var items []interface{}
+	if _, ok := informer.GetIndexer().GetIndexers()[builderPodIndex]; ok {
+		var err error
+		items, err = informer.GetIndexer().ByIndex(builderPodIndex, key.String())
+		if err != nil {
+			logger.Error("error listing builder pods from index", zap.Error(err))
+			return nil
+		}
+	} else {
+		items = informer.GetStore().List()
+	}
+	var pods []*apiv1.Pod
+	for _, item := range items {
+		pod, ok := item.(*apiv1.Pod)
+		if !ok {
+			eventDecodeError(logger, informerPod, eventList, item)
+			continue
+		}
+		if podBuilderKey(pod) == key && builderPodMatches(pod, env) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// builderPodReady reports whether all the containers of the pod are ready.
+// A pod may be running but still failing its health checks, so the
+// container statuses are used instead of the pod phase. Terminating pods,
+// e.g. of a builder scaled down, aren't ready for new builds anymore.
+func builderPodReady(pod *apiv1.Pod) bool {
+	if pod.ObjectMeta.DeletionTimestamp != nil {
+		return false
+	}
+	for _, cStatus := range pod.Status.ContainerStatuses {
+		if !cStatus.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// ready returns a channel closed once a builder pod of the key becomes
+// ready, and the function to call once the caller stops waiting. Waiters
+// get the channel before looking for a ready pod, so that they don't miss
+// a pod getting ready in between.
+func (r *builderReadiness) ready(key builderKey) (<-chan struct{}, func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w, ok := r.waiters[key]
+	if !ok {
+		w = &builderWaiters{ch: make(chan struct{})}
+		r.waiters[key] = w
+	}
+	w.n++
+	return w.ch, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		w.n--
+		if w.n == 0 && r.waiters[key] == w {
+			delete(r.waiters, key)
+		}
+	}
+}
+
+// notify wakes up the waiters of the builder pods of the key.
+func (r *builderReadiness) notify(key builderKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if w, ok := r.waiters[key]; ok {
+		close(w.ch)
+		delete(r.waiters, key)
+	}
+}
+
+// podInformerHandler notifies the waiters of a builder pod when it is
+// added or updated in ready state.
+func (r *builderReadiness) podInformerHandler() k8sCache.ResourceEventHandlerFuncs {
+	handle := func(obj interface{}) {
+		pod, ok := obj.(*apiv1.Pod)
+		if !ok || len(pod.ObjectMeta.Labels[LABEL_ENV_NAME]) == 0 || !builderPodReady(pod) {
+			return
+		}
+		r.notify(podBuilderKey(pod))
+	}
+	return k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			handle(newObj)
+		},
+	}
+}
+
+// waitReady waits until ready is closed, d elapsed or ctx is done. A nil
+// ready channel waits for d.
+func waitReady(ctx context.Context, ready <-chan struct{}, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-ready:
+	case <-t.C:
+	}
+}
@@ -0,0 +1,156 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils"
+)
+
+func TestBuilderReadyEndsBuilderWait(t *testing.T) {
+	tb := newTestBuild(t)
+	podInformer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Pods().Informer()
+	tb.deps.Pods = informerPodLister{logger: tb.deps.Logger, podInformer: map[string]k8sCache.SharedIndexInformer{testNamespace: podInformer}}
+	readiness := newBuilderReadiness()
+	tb.deps.builderReady = readiness
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+		done <- err
+	}()
+	// wait for the build to wait for the builder
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		readiness.mutex.Lock()
+		waiting := len(readiness.waiters) > 0
+		readiness.mutex.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Build did not wait for the builder pod")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pod := testBuilderPod(tb.env)
+	err := podInformer.GetStore().Add(pod)
+	if err != nil {
+		t.Fatalf("Error adding builder pod to informer store: %v", err)
+	}
+	readyAt := time.Now()
+	readiness.podInformerHandler().OnAdd(pod)
+
+	// the build goes on before the first health check backoff is over
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Error building package: %v", err)
+		}
+		if waited := time.Since(readyAt); waited >= utils.DefaultInitialInterval {
+			t.Errorf("Expected the build to go on once the builder pod was ready, it took %v", waited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Build did not finish")
+	}
+
+	readiness.mutex.Lock()
+	defer readiness.mutex.Unlock()
+	if len(readiness.waiters) != 0 {
+		t.Errorf("Expected no builder waiters left, got %d", len(readiness.waiters))
+	}
+}
+
+func TestBuilderPodMatches(t *testing.T) {
+	env := testEnvironment()
+	// another controller annotates the environment, its builder is the
+	// same
+	annotated := env.DeepCopy()
+	annotated.ObjectMeta.ResourceVersion = "2"
+	annotated.ObjectMeta.Annotations = map[string]string{"example.com/team": "builds"}
+	upgraded := env.DeepCopy()
+	upgraded.ObjectMeta.ResourceVersion = "3"
+	upgraded.Spec.Builder.Image = "builder-image:v2"
+	// pods created before the builder spec hash label
+	legacy := testBuilderPod(env)
+	delete(legacy.ObjectMeta.Labels, LABEL_ENV_BUILDER_HASH)
+
+	tests := []struct {
+		name    string
+		pod     *apiv1.Pod
+		env     *fv1.Environment
+		matches bool
+	}{
+		{name: "current environment", pod: testBuilderPod(env), env: env, matches: true},
+		{name: "annotation-only update", pod: testBuilderPod(env), env: annotated, matches: true},
+		{name: "builder image update", pod: testBuilderPod(env), env: upgraded},
+		{name: "legacy pod of the environment version", pod: legacy, env: env, matches: true},
+		{name: "legacy pod of an older environment version", pod: legacy, env: annotated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := builderPodMatches(test.pod, test.env); got != test.matches {
+				t.Errorf("Expected match %v, got %v", test.matches, got)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildAfterAnnotationOnlyEnvUpdate(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	podInformer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Pods().Informer()
+	err := podInformer.AddIndexers(k8sCache.Indexers{builderPodIndex: builderPodIndexFunc})
+	if err != nil {
+		t.Fatalf("Error adding builder pod index: %v", err)
+	}
+	// the ready builder pod has no IP yet, the build goes to the builder
+	// service it was created with
+	err = podInformer.GetStore().Add(testBuilderPod(tb.env))
+	if err != nil {
+		t.Fatalf("Error adding builder pod to informer store: %v", err)
+	}
+	tb.deps.Pods = informerPodLister{logger: tb.deps.Logger, podInformer: map[string]k8sCache.SharedIndexInformer{testNamespace: podInformer}}
+
+	env := tb.env.DeepCopy()
+	env.ObjectMeta.ResourceVersion = "2"
+	env.ObjectMeta.Annotations = map[string]string{"example.com/team": "builds"}
+	_, err = tb.fissionClient.CoreV1().Environments(testNamespace).Update(ctx, env, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating environment: %v", err)
+	}
+	var address string
+	succeed := tb.deps.buildPackage
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		address = builderAddress(ctx, env, envBuilderNamespace)
+		return succeed(ctx, logger, fissionClient, envBuilderNamespace, storageSvcUrl, pkg)
+	}
+
+	result, err := ExecuteBuild(ctx, tb.deps, tb.pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected the builder pod to build after the annotation-only update, got %s: %v", result.Status, err)
+	}
+	if want := testEnvName + "-1." + testNamespace; address != want {
+		t.Errorf("Expected build sent to builder service %q, got %q", want, address)
+	}
+
+	// a builder image update leaves the pod of the older builder out
+	env.ObjectMeta.ResourceVersion = "3"
+	env.Spec.Builder.Image = "builder-image:v2"
+	if pods, _ := tb.deps.Pods.ListBuilderPods(testNamespace, env); len(pods) != 0 {
+		t.Errorf("Expected no builder pod of the updated builder image, got %d", len(pods))
+	}
+}
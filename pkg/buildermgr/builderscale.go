@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+)
+
+// podDeletionCostAnnotation tells the ReplicaSet controller which pods to
+// delete first on scale downs, those of the lowest cost.
+const podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+
+// builderReplicas returns the number of builder pods of the environment.
+func builderReplicas(env *fv1.Environment) int32 {
+	if env.Spec.Builder.Replicas <= 0 {
+		return 1
+	}
+	return int32(env.Spec.Builder.Replicas)
+}
+
+// scaleBuilder sets the replicas of the builder deployment of the
+// environment to its builder replicas. Before scale downs, the builder pods
+// get the number of their builds in flight as deletion cost, so that the
+// idle pods are deleted first. Terminating pods get no new builds.
+func (envw *environmentWatcher) scaleBuilder(ctx context.Context, env *fv1.Environment) {
+	info, ok := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)]
+	if !ok || info.deployment == nil {
+		return
+	}
+	deploy := info.deployment
+	replicas := builderReplicas(env)
+	current := int32(1)
+	if deploy.Spec.Replicas != nil {
+		current = *deploy.Spec.Replicas
+	}
+	if replicas == current {
+		return
+	}
+	logger := envw.logger.With(zap.String("deployment_name", deploy.ObjectMeta.Name),
+		zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
+	if replicas < current {
+		err := envw.setPodDeletionCosts(ctx, deploy.ObjectMeta.Namespace, deploy.Spec.Selector)
+		if err != nil {
+			logger.Warn("error setting the deletion cost of busy builder pods", zap.Error(err))
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		logger.Error("error scaling builder deployment", zap.Error(err))
+		return
+	}
+	updated, err := envw.kubernetesClient.AppsV1().Deployments(deploy.ObjectMeta.Namespace).Patch(ctx, deploy.ObjectMeta.Name,
+		k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		logger.Error("error scaling builder deployment", zap.Error(err))
+		return
+	}
+	info.deployment = updated
+	logger.Info("builder deployment scaled", zap.Int32("from", current), zap.Int32("to", replicas))
+}
+
+// setPodDeletionCosts sets the deletion cost of the builder pods of the
+// selector to the number of their builds in flight.
+func (envw *environmentWatcher) setPodDeletionCosts(ctx context.Context, ns string, selector *metav1.LabelSelector) error {
+	if selector == nil {
+		return nil
+	}
+	podList, err := envw.kubernetesClient.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(selector.MatchLabels).AsSelector().String(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error getting builder pod list")
+	}
+	pods := make(map[string]bool, len(podList.Items))
+	for i := range podList.Items {
+		pods[builderPodName(&podList.Items[i])] = true
+	}
+	builds, err := envw.podBuildsInFlight(pods)
+	if err != nil {
+		return err
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		cost := strconv.Itoa(builds[builderPodName(pod)])
+		if pod.ObjectMeta.Annotations[podDeletionCostAnnotation] == cost {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{
+					podDeletionCostAnnotation: cost,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = envw.kubernetesClient.CoreV1().Pods(ns).Patch(ctx, pod.ObjectMeta.Name,
+			k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error patching builder pod %s.%s", pod.ObjectMeta.Name, ns)
+		}
+	}
+	return nil
+}
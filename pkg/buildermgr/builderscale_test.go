@@ -0,0 +1,82 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission/pkg/crd"
+)
+
+func TestBuilderReplicas(t *testing.T) {
+	env := testEnvironment()
+	if n := builderReplicas(env); n != 1 {
+		t.Errorf("Expected one builder pod by default, got %d", n)
+	}
+	hash := builderSpecHash(env)
+	env.Spec.Builder.Replicas = 3
+	if n := builderReplicas(env); n != 3 {
+		t.Errorf("Expected 3 builder pods, got %d", n)
+	}
+	if builderSpecHash(env) != hash {
+		t.Error("Expected builder replicas left out of the builder spec hash")
+	}
+	if err := env.Spec.Builder.Validate(); err != nil {
+		t.Errorf("Expected valid builder replicas: %v", err)
+	}
+	env.Spec.Builder.Replicas = -1
+	if err := env.Spec.Builder.Validate(); err == nil {
+		t.Error("Expected negative builder replicas rejected")
+	}
+}
+
+func TestScaleBuilderDownDeletesIdlePodsFirst(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	var replicas int32 = 2
+	deploy.Spec.Replicas = &replicas
+	_, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Update(ctx, deploy, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating builder deployment: %v", err)
+	}
+	env := testEnvironment()
+	envw.cache = map[string]*builderInfo{crd.CacheKeyUID(&env.ObjectMeta): {deployment: deploy}}
+	busy := testBuilderPod(env)
+	envw.builderLoad.builds[builderPodName(busy)] = 2
+
+	env.Spec.Builder.Replicas = 1
+	envw.scaleBuilder(ctx, env)
+	scaled, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || scaled.Spec.Replicas == nil || *scaled.Spec.Replicas != 1 {
+		t.Fatalf("Expected builder deployment scaled to 1 replica, got %+v: %v", scaled, err)
+	}
+	if cached := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)].deployment; cached.Spec.Replicas == nil || *cached.Spec.Replicas != 1 {
+		t.Errorf("Expected scaled deployment cached, got %+v", cached.Spec.Replicas)
+	}
+	pod, err := envw.kubernetesClient.CoreV1().Pods(testNamespace).Get(ctx, busy.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || pod.ObjectMeta.Annotations[podDeletionCostAnnotation] != "2" {
+		t.Errorf("Expected busy pod deletion cost set to its builds in flight, got %v: %v", pod.ObjectMeta.Annotations, err)
+	}
+
+	// terminating pods get no new builds
+	now := metav1.Now()
+	pod.ObjectMeta.DeletionTimestamp = &now
+	if builderPodReady(pod) {
+		t.Error("Expected terminating builder pod not ready")
+	}
+}
+
+func TestScaleBuilderUp(t *testing.T) {
+	ctx := context.Background()
+	envw, deploy := newTestDrainWatcher(t)
+	env := testEnvironment()
+	envw.cache = map[string]*builderInfo{crd.CacheKeyUID(&env.ObjectMeta): {deployment: deploy}}
+
+	env.Spec.Builder.Replicas = 3
+	envw.scaleBuilder(ctx, env)
+	scaled, err := envw.kubernetesClient.AppsV1().Deployments(testNamespace).Get(ctx, deploy.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || scaled.Spec.Replicas == nil || *scaled.Spec.Replicas != 3 {
+		t.Fatalf("Expected builder deployment scaled to 3 replicas, got %+v: %v", scaled, err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// applyBuilderScheduling sets the scheduling constraints of the environment
+// builder on the builder pod spec. The node selector labels and the
+// tolerations are added to the ones of the builder pod spec patches, the
+// affinity replaces theirs.
+func applyBuilderScheduling(spec *apiv1.PodSpec, builder fv1.Builder) {
+	if len(builder.NodeSelector) > 0 {
+		nodeSelector := make(map[string]string, len(spec.NodeSelector)+len(builder.NodeSelector))
+		for k, v := range spec.NodeSelector {
+			nodeSelector[k] = v
+		}
+		for k, v := range builder.NodeSelector {
+			nodeSelector[k] = v
+		}
+		spec.NodeSelector = nodeSelector
+	}
+	for _, toleration := range builder.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, *toleration.DeepCopy())
+	}
+	if builder.Affinity != nil {
+		spec.Affinity = builder.Affinity.DeepCopy()
+	}
+}
+
+// podUnschedulable returns the error of the builder pod the scheduler
+// can't find a node for, with the message of its scheduling condition such
+// as "0/12 nodes are available", nil if the pod isn't unschedulable.
+func podUnschedulable(pod *apiv1.Pod) error {
+	if pod == nil {
+		return nil
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == apiv1.PodScheduled && cond.Status == apiv1.ConditionFalse && cond.Reason == apiv1.PodReasonUnschedulable {
+			return builderUnschedulableError{errors.Errorf("builder pod %s is unschedulable: %s", builderPodName(pod), cond.Message)}
+		}
+	}
+	return nil
+}
+
+// builderUnschedulableError is a builder wait run out while the builder
+// pod couldn't be scheduled.
+type builderUnschedulableError struct {
+	error
+}
+
+func (e builderUnschedulableError) Unwrap() error {
+	return e.error
+}
+
+// builderWaitFailure returns why the builder pod last seen not ready by a
+// builder wait that ran out didn't get ready: it failed to pull its image,
+// or couldn't be scheduled. It returns nil otherwise.
+func builderWaitFailure(pod *apiv1.Pod) error {
+	if err := imagePullFailure(pod); err != nil {
+		return err
+	}
+	return podUnschedulable(pod)
+}
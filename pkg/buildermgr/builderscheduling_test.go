@@ -0,0 +1,75 @@
+package buildermgr
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestApplyBuilderScheduling(t *testing.T) {
+	spec := &apiv1.PodSpec{
+		NodeSelector: map[string]string{"kubernetes.io/os": "linux", "pool": "default"},
+		Tolerations:  []apiv1.Toleration{{Key: "patched", Operator: apiv1.TolerationOpExists}},
+		Affinity:     &apiv1.Affinity{PodAntiAffinity: &apiv1.PodAntiAffinity{}},
+	}
+	builder := fv1.Builder{
+		NodeSelector: map[string]string{"pool": "build"},
+		Tolerations: []apiv1.Toleration{{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "build",
+			Effect: apiv1.TaintEffectNoSchedule}},
+		Affinity: &apiv1.Affinity{NodeAffinity: &apiv1.NodeAffinity{}},
+	}
+	applyBuilderScheduling(spec, builder)
+	if !reflect.DeepEqual(spec.NodeSelector, map[string]string{"kubernetes.io/os": "linux", "pool": "build"}) {
+		t.Errorf("Expected builder node selector added to the patched one, got %v", spec.NodeSelector)
+	}
+	if len(spec.Tolerations) != 2 || spec.Tolerations[1].Key != "dedicated" {
+		t.Errorf("Expected builder tolerations appended, got %v", spec.Tolerations)
+	}
+	if spec.Affinity.NodeAffinity == nil || spec.Affinity.PodAntiAffinity != nil {
+		t.Errorf("Expected builder affinity to replace the patched one, got %+v", spec.Affinity)
+	}
+	builder.Tolerations[0].Value = "changed"
+	if spec.Tolerations[1].Value != "build" {
+		t.Errorf("Expected builder tolerations copied")
+	}
+}
+
+// unschedulableBuilderPod returns the builder pod of the environment no
+// node matches.
+func unschedulableBuilderPod(env *fv1.Environment) *apiv1.Pod {
+	pod := testBuilderPod(env)
+	pod.Status.ContainerStatuses = nil
+	pod.Status.Phase = apiv1.PodPending
+	pod.Status.Conditions = []apiv1.PodCondition{{
+		Type:    apiv1.PodScheduled,
+		Status:  apiv1.ConditionFalse,
+		Reason:  apiv1.PodReasonUnschedulable,
+		Message: "0/12 nodes are available: 12 node(s) didn't match Pod's node affinity/selector.",
+	}}
+	return pod
+}
+
+func TestExecuteBuildReportsUnschedulableBuilder(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.pods.pods = []*apiv1.Pod{unschedulableBuilderPod(tb.env)}
+	tb.deps.builderBackoff = BuilderBackoff{InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, Multiplier: 1,
+		MaxWait: 100 * time.Millisecond}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if result.Status != fv1.BuildStatusFailed || tb.builds != 0 {
+		t.Fatalf("Expected build failed before building, got %s with %d builds: %v", result.Status, tb.builds, err)
+	}
+	pkg := tb.getPackage(t)
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNotReady)
+	if !strings.Contains(pkg.Status.BuildLog, "environment builder not ready") ||
+		!strings.Contains(pkg.Status.BuildLog, "is unschedulable: 0/12 nodes are available") {
+		t.Errorf("Expected builder wait timeout with the scheduling failure in the build log, got %q", pkg.Status.BuildLog)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// applyBuilderSecurityContext sets the security contexts of the environment
+// builder on the builder pod spec. The pod security context replaces the one
+// of the builder pod spec patches, its FSGroup defaults to the group, or
+// user, the pod runs as, so that both the fetcher and the builder can write
+// to the package volume they share. The containers without security context
+// get the container one.
+func applyBuilderSecurityContext(spec *apiv1.PodSpec, builder fv1.Builder) {
+	if builder.PodSecurityContext != nil {
+		podCtx := builder.PodSecurityContext.DeepCopy()
+		if podCtx.FSGroup == nil {
+			switch {
+			case podCtx.RunAsGroup != nil:
+				fsGroup := *podCtx.RunAsGroup
+				podCtx.FSGroup = &fsGroup
+			case podCtx.RunAsUser != nil && *podCtx.RunAsUser != 0:
+				fsGroup := *podCtx.RunAsUser
+				podCtx.FSGroup = &fsGroup
+			}
+		}
+		spec.SecurityContext = podCtx
+	}
+	if builder.SecurityContext == nil {
+		return
+	}
+	for _, containers := range [][]apiv1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if containers[i].SecurityContext == nil {
+				containers[i].SecurityContext = builder.SecurityContext.DeepCopy()
+			}
+		}
+	}
+}
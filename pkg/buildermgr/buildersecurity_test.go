@@ -0,0 +1,166 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestApplyBuilderSecurityContext(t *testing.T) {
+	nonRoot := true
+	user := int64(1000)
+	noEscalation := false
+	own := &apiv1.SecurityContext{ReadOnlyRootFilesystem: &nonRoot}
+	spec := &apiv1.PodSpec{
+		SecurityContext: &apiv1.PodSecurityContext{SupplementalGroups: []int64{5}},
+		Containers: []apiv1.Container{
+			{Name: "builder", SecurityContext: own},
+			{Name: "fetcher"},
+		},
+	}
+	applyBuilderSecurityContext(spec, fv1.Builder{
+		PodSecurityContext: &apiv1.PodSecurityContext{
+			RunAsNonRoot:   &nonRoot,
+			RunAsUser:      &user,
+			SeccompProfile: &apiv1.SeccompProfile{Type: apiv1.SeccompProfileTypeRuntimeDefault},
+		},
+		SecurityContext: &apiv1.SecurityContext{AllowPrivilegeEscalation: &noEscalation},
+	})
+
+	podCtx := spec.SecurityContext
+	if podCtx.RunAsNonRoot == nil || !*podCtx.RunAsNonRoot || podCtx.SeccompProfile == nil || len(podCtx.SupplementalGroups) != 0 {
+		t.Errorf("Expected pod security context of the environment, got %+v", podCtx)
+	}
+	if podCtx.FSGroup == nil || *podCtx.FSGroup != user {
+		t.Errorf("Expected fsGroup defaulting to the user, got %v", podCtx.FSGroup)
+	}
+	if spec.Containers[0].SecurityContext != own {
+		t.Errorf("Expected builder container keeping its own security context, got %+v", spec.Containers[0].SecurityContext)
+	}
+	fetcherCtx := spec.Containers[1].SecurityContext
+	if fetcherCtx == nil || fetcherCtx.AllowPrivilegeEscalation == nil || *fetcherCtx.AllowPrivilegeEscalation {
+		t.Errorf("Expected fetcher container security context of the environment, got %+v", fetcherCtx)
+	}
+}
+
+func TestBuilderSecurityContextValidation(t *testing.T) {
+	yes, no := true, false
+	root, user := int64(0), int64(1000)
+	for _, test := range []struct {
+		name    string
+		builder fv1.Builder
+		invalid string
+	}{
+		{
+			name:    "non-root pod",
+			builder: fv1.Builder{PodSecurityContext: &apiv1.PodSecurityContext{RunAsNonRoot: &yes, RunAsUser: &user}},
+		},
+		{
+			name:    "root with non-root pod",
+			builder: fv1.Builder{PodSecurityContext: &apiv1.PodSecurityContext{RunAsNonRoot: &yes, RunAsUser: &root}},
+			invalid: "Builder.PodSecurityContext.RunAsUser",
+		},
+		{
+			name: "root container of non-root pod",
+			builder: fv1.Builder{
+				PodSecurityContext: &apiv1.PodSecurityContext{RunAsNonRoot: &yes},
+				SecurityContext:    &apiv1.SecurityContext{RunAsUser: &root},
+			},
+			invalid: "Builder.SecurityContext.RunAsUser",
+		},
+		{
+			name: "non-root builder container of root pod",
+			builder: fv1.Builder{
+				PodSecurityContext: &apiv1.PodSecurityContext{RunAsUser: &root},
+				Container:          &apiv1.Container{SecurityContext: &apiv1.SecurityContext{RunAsNonRoot: &yes}},
+			},
+			invalid: "Builder.Container.SecurityContext.RunAsUser",
+		},
+		{
+			name:    "privileged container without privilege escalation",
+			builder: fv1.Builder{SecurityContext: &apiv1.SecurityContext{Privileged: &yes, AllowPrivilegeEscalation: &no}},
+			invalid: "Builder.SecurityContext.AllowPrivilegeEscalation",
+		},
+	} {
+		err := test.builder.Validate()
+		switch {
+		case len(test.invalid) == 0 && err != nil:
+			t.Errorf("%s: expected valid builder, got %v", test.name, err)
+		case len(test.invalid) > 0 && (err == nil || !strings.Contains(err.Error(), test.invalid)):
+			t.Errorf("%s: expected invalid %s, got %v", test.name, test.invalid, err)
+		}
+	}
+}
+
+func TestEnvStatusReportsBuilderFailure(t *testing.T) {
+	ctx := context.Background()
+	env := testEnvironment()
+	fissionClient := fClient.NewSimpleClientset(env)
+	deployInformer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Apps().V1().Deployments().Informer()
+	failure := `pods "builder" is forbidden: violates PodSecurity "restricted:latest"`
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "builder",
+			Namespace: testNamespace,
+			Labels:    (&environmentWatcher{}).getLabels(testEnvName, testNamespace, "1"),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: apiv1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{LABEL_ENV_BUILDER_HASH: builderSpecHash(env)},
+			}},
+		},
+		Status: appsv1.DeploymentStatus{
+			Conditions: []appsv1.DeploymentCondition{{
+				Type:    appsv1.DeploymentReplicaFailure,
+				Status:  apiv1.ConditionTrue,
+				Reason:  "FailedCreate",
+				Message: failure,
+			}},
+		},
+	}
+	err := deployInformer.GetStore().Add(deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := makeEnvStatusReporter(loggerfactory.GetLogger(), fissionClient, nil, nil)
+	r.deployInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: deployInformer}
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+
+	r.markDirty(testNamespace, testEnvName)
+	r.flush(ctx)
+	got, err := fissionClient.CoreV1().Environments(testNamespace).Get(ctx, testEnvName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting environment: %v", err)
+	}
+	if got.Status.BuilderFailure != failure {
+		t.Errorf("Expected builder failure %q in the status, got %q", failure, got.Status.BuilderFailure)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonBuilderFailed) || !strings.Contains(event, failure) {
+			t.Errorf("Unexpected builder failure event %q", event)
+		}
+	default:
+		t.Error("Expected builder failure event")
+	}
+
+	// the failure is recorded once
+	r.markDirty(testNamespace, testEnvName)
+	r.flush(ctx)
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected no event for the same failure, got %d", len(recorder.Events))
+	}
+}
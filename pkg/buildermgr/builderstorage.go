@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// applyBuilderEphemeralStorage sets the ephemeral storage request and limit
+// of the builder to the builder and fetcher containers, over the resources
+// of their container and pod specs.
+func applyBuilderEphemeralStorage(spec *apiv1.PodSpec, builder fv1.Builder) {
+	if builder.EphemeralStorageRequest == nil && builder.EphemeralStorageLimit == nil {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if container.Name != "builder" && container.Name != "fetcher" {
+			continue
+		}
+		// the fetcher resources are shared by the pods of the fetcher
+		// config
+		container.Resources = *container.Resources.DeepCopy()
+		if builder.EphemeralStorageRequest != nil {
+			if container.Resources.Requests == nil {
+				container.Resources.Requests = apiv1.ResourceList{}
+			}
+			container.Resources.Requests[apiv1.ResourceEphemeralStorage] = builder.EphemeralStorageRequest.DeepCopy()
+		}
+		if builder.EphemeralStorageLimit != nil {
+			if container.Resources.Limits == nil {
+				container.Resources.Limits = apiv1.ResourceList{}
+			}
+			container.Resources.Limits[apiv1.ResourceEphemeralStorage] = builder.EphemeralStorageLimit.DeepCopy()
+		}
+	}
+}
+
+// storageEvictionFailure returns the package reason and message of a
+// builder pod evicted for its ephemeral storage use, an empty reason if it
+// was evicted for something else. The kubelet evicts pods over their
+// ephemeral storage or emptyDir limits, and pods using the most of it once
+// their node runs out of disk.
+func storageEvictionFailure(pod *apiv1.Pod) (string, string) {
+	msg := pod.Status.Message
+	if !strings.Contains(msg, "ephemeral") && !strings.Contains(msg, "EmptyDir") {
+		return "", ""
+	}
+	limit := "no limit"
+	for _, c := range pod.Spec.Containers {
+		if q, ok := c.Resources.Limits[apiv1.ResourceEphemeralStorage]; c.Name == "builder" && ok {
+			limit = "limit " + q.String()
+		}
+	}
+	return fv1.PackageReasonBuilderDiskPressure, fmt.Sprintf("%s: builder pod %s was evicted for its ephemeral storage use (%s), "+
+		"set the environment builder ephemeral storage request and limit: %s",
+		fv1.PackageReasonBuilderDiskPressure, builderPodName(pod), limit, msg)
+}
@@ -0,0 +1,63 @@
+package buildermgr
+
+import (
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestApplyBuilderEphemeralStorage(t *testing.T) {
+	fetcherResources := apiv1.ResourceRequirements{
+		Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("128Mi")},
+	}
+	spec := &apiv1.PodSpec{Containers: []apiv1.Container{
+		{Name: "builder"},
+		{Name: "fetcher", Resources: fetcherResources},
+		{Name: "sidecar"},
+	}}
+	applyBuilderEphemeralStorage(spec, fv1.Builder{})
+	if len(spec.Containers[0].Resources.Limits) != 0 {
+		t.Errorf("Expected resources left alone without ephemeral storage, got %v", spec.Containers[0].Resources)
+	}
+
+	request, limit := resource.MustParse("1Gi"), resource.MustParse("4Gi")
+	applyBuilderEphemeralStorage(spec, fv1.Builder{EphemeralStorageRequest: &request, EphemeralStorageLimit: &limit})
+	for _, c := range spec.Containers[:2] {
+		r, l := c.Resources.Requests[apiv1.ResourceEphemeralStorage], c.Resources.Limits[apiv1.ResourceEphemeralStorage]
+		if r.Cmp(request) != 0 || l.Cmp(limit) != 0 {
+			t.Errorf("Expected ephemeral storage request 1Gi and limit 4Gi of container %s, got %v", c.Name, c.Resources)
+		}
+	}
+	if _, ok := spec.Containers[1].Resources.Limits[apiv1.ResourceMemory]; !ok {
+		t.Errorf("Expected fetcher memory limit kept, got %v", spec.Containers[1].Resources)
+	}
+	if _, ok := fetcherResources.Limits[apiv1.ResourceEphemeralStorage]; ok {
+		t.Errorf("Expected shared fetcher resources left alone, got %v", fetcherResources)
+	}
+	if len(spec.Containers[2].Resources.Limits) != 0 {
+		t.Errorf("Expected other containers left alone, got %v", spec.Containers[2].Resources)
+	}
+}
+
+func TestStorageEvictionFailure(t *testing.T) {
+	pod := testBuilderPod(testEnvironment())
+	pod.Spec.Containers = []apiv1.Container{{
+		Name: "builder",
+		Resources: apiv1.ResourceRequirements{
+			Limits: apiv1.ResourceList{apiv1.ResourceEphemeralStorage: resource.MustParse("2Gi")},
+		},
+	}}
+	pod.Status.Message = `Container builder exceeded its local ephemeral storage limit "2Gi". `
+	reason, msg := storageEvictionFailure(pod)
+	if reason != fv1.PackageReasonBuilderDiskPressure || !strings.Contains(msg, "(limit 2Gi)") {
+		t.Errorf("Expected disk pressure over the builder limit, got %q: %q", reason, msg)
+	}
+	pod.Status.Message = "The node was low on resource: memory."
+	if reason, _ := storageEvictionFailure(pod); len(reason) > 0 {
+		t.Errorf("Expected no disk pressure of a memory eviction, got %q", reason)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// errBuilderTimeout is the cause of build requests canceled by the builder
+// timeout of their environment.
+var errBuilderTimeout = errors.New("builder timeout exceeded")
+
+// builderTimeoutError is a build exceeding the builder timeout of its
+// environment, a rebuild takes as long.
+type builderTimeoutError struct {
+	error
+}
+
+func (e builderTimeoutError) Unwrap() error {
+	return e.error
+}
+
+// builderRequestContext returns the context of the build request sent to
+// the builder of the environment, canceled with errBuilderTimeout once the
+// builder timeout of the environment is exceeded. Environments without
+// one leave the request to the deadline of the build.
+func builderRequestContext(ctx context.Context, env *fv1.Environment) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if env.Spec.Builder.BuildTimeout <= 0 {
+		return ctx, func() { cancel(nil) }
+	}
+	timer := time.AfterFunc(builderTimeout(env), func() { cancel(errBuilderTimeout) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// builderTimeout returns the builder timeout of the environment, zero means
+// none.
+func builderTimeout(env *fv1.Environment) time.Duration {
+	return time.Duration(env.Spec.Builder.BuildTimeout) * time.Second
+}
+
+// builderTimedOut reports whether the build request was canceled by the
+// builder timeout of its environment.
+func builderTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errBuilderTimeout)
+}
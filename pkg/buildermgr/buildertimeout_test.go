@@ -0,0 +1,28 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBuilderRequestContext(t *testing.T) {
+	env := testEnvironment()
+	ctx, cancel := builderRequestContext(context.Background(), env)
+	cancel()
+	if ctx.Err() == nil || builderTimedOut(ctx) {
+		t.Errorf("Expected request of an environment without builder timeout canceled by the build only, got %v", context.Cause(ctx))
+	}
+
+	env.Spec.Builder.BuildTimeout = 1
+	ctx, cancel = builderRequestContext(context.Background(), env)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected request canceled by the builder timeout")
+	}
+	if !builderTimedOut(ctx) {
+		t.Errorf("Expected builder timeout cause, got %v", context.Cause(ctx))
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"time"
+	"unicode/utf8"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// buildHistoryLimit is the number of finished build attempts kept in
+	// the build history of the package status.
+	buildHistoryLimit = 5
+	// buildHistoryMessageSize is the maximum size in bytes of the failure
+	// messages of the build history.
+	buildHistoryMessageSize = 256
+)
+
+// attemptFailed records why the build attempt failed, for its build history
+// entry.
+func (e *buildExecution) attemptFailed(reason string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failureReason = reason
+	e.failureMessage = truncateHistoryMessage(err.Error())
+}
+
+// buildHistory returns the build history of the package status with the
+// attempt appended once it's finished: the build succeeded or failed, or
+// the attempt failed and is going to be retried. e.mu must be held.
+func (e *buildExecution) buildHistory(pkg *fv1.Package, status fv1.BuildStatus) []fv1.BuildHistoryEntry {
+	history := pkg.Status.BuildHistory
+	entry := fv1.BuildHistoryEntry{
+		Attempt:        int32(e.opts.Attempt),
+		StartTime:      metav1.Time{Time: e.attemptStart.UTC()},
+		CompletionTime: metav1.Time{Time: time.Now().UTC()},
+		BuilderPod:     e.builderPod,
+	}
+	switch {
+	case status == fv1.BuildStatusSucceeded:
+		entry.Result = fv1.BuildStatusSucceeded
+	case status == fv1.BuildStatusFailed || len(e.failureReason) > 0:
+		entry.Result = fv1.BuildStatusFailed
+		entry.Reason = e.failureReason
+		entry.Message = e.failureMessage
+	default:
+		return history
+	}
+	// the history of the package being updated is left alone
+	history = append(append(make([]fv1.BuildHistoryEntry, 0, len(history)+1), history...), entry)
+	if len(history) > buildHistoryLimit {
+		history = history[len(history)-buildHistoryLimit:]
+	}
+	return history
+}
+
+// truncateHistoryMessage keeps the beginning of messages longer than
+// buildHistoryMessageSize bytes.
+func truncateHistoryMessage(msg string) string {
+	if len(msg) <= buildHistoryMessageSize {
+		return msg
+	}
+	cut := buildHistoryMessageSize - len("...")
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut] + "..."
+}
@@ -0,0 +1,76 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestExecuteBuildRecordsBuildHistory(t *testing.T) {
+	tb := newTestBuild(t)
+	succeed := tb.deps.buildPackage
+	tb.failBuilds(errors.New("builder out of memory"))
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 2})
+	if err == nil || !result.Retry {
+		t.Fatalf("Expected failed attempt to be retried, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	history := tb.getPackage(t).Status.BuildHistory
+	if len(history) != 1 {
+		t.Fatalf("Expected the failed attempt in the build history, got %+v", history)
+	}
+	failed := history[0]
+	if failed.Attempt != 1 || failed.Result != fv1.BuildStatusFailed || failed.Reason != fv1.PackageReasonBuildFailed ||
+		failed.Message != "builder out of memory" || failed.BuilderPod != testNamespace+"/builder-pod" {
+		t.Errorf("Unexpected failed attempt entry %+v", failed)
+	}
+	if failed.StartTime.IsZero() || failed.CompletionTime.Before(&failed.StartTime) {
+		t.Errorf("Expected attempt start and completion times, got %v and %v", failed.StartTime, failed.CompletionTime)
+	}
+
+	tb.deps.buildPackage = succeed
+	result, err = ExecuteBuild(context.Background(), tb.deps, tb.getPackage(t), BuildOptions{Attempt: 2, MaxAttempts: 2})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded retry, got %s: %v", result.Status, err)
+	}
+	history = tb.getPackage(t).Status.BuildHistory
+	if len(history) != 2 || history[1].Attempt != 2 || history[1].Result != fv1.BuildStatusSucceeded ||
+		history[1].Reason != "" || history[1].Message != "" {
+		t.Errorf("Expected succeeded attempt appended to the build history, got %+v", history)
+	}
+}
+
+func TestBuildHistoryLimit(t *testing.T) {
+	tb := newTestBuild(t)
+	for i := 1; i <= buildHistoryLimit; i++ {
+		tb.pkg.Status.BuildHistory = append(tb.pkg.Status.BuildHistory,
+			fv1.BuildHistoryEntry{Attempt: int32(i), Result: fv1.BuildStatusFailed})
+	}
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{SkipPackageUpdate: true})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	history := result.Package.Status.BuildHistory
+	if len(history) != buildHistoryLimit || history[0].Attempt != 2 || history[len(history)-1].Result != fv1.BuildStatusSucceeded {
+		t.Errorf("Expected oldest attempt dropped from the build history, got %+v", history)
+	}
+	if len(tb.pkg.Status.BuildHistory) != buildHistoryLimit || tb.pkg.Status.BuildHistory[0].Attempt != 1 {
+		t.Errorf("Expected build history of the source package left alone, got %+v", tb.pkg.Status.BuildHistory)
+	}
+}
+
+func TestTruncateHistoryMessage(t *testing.T) {
+	short := "build failed"
+	if got := truncateHistoryMessage(short); got != short {
+		t.Errorf("Expected short message kept, got %q", got)
+	}
+	long := strings.Repeat("é", buildHistoryMessageSize)
+	got := truncateHistoryMessage(long)
+	if len(got) > buildHistoryMessageSize || !strings.HasSuffix(got, "...") || !strings.HasPrefix(long, strings.TrimSuffix(got, "...")) {
+		t.Errorf("Expected message cut at a rune boundary within %d bytes, got %q", buildHistoryMessageSize, got)
+	}
+}
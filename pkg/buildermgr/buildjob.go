@@ -0,0 +1,378 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+const (
+	// buildJobDeadline is the lifetime of the build jobs of builds
+	// without deadline, buildJobDeadlineMargin is added to the build
+	// deadline otherwise. Jobs left behind by a builder manager that
+	// stopped during the build are stopped by then.
+	buildJobDeadline       = time.Hour
+	buildJobDeadlineMargin = 5 * time.Minute
+	// buildJobDeleteTimeout is the timeout of the deletion of the build
+	// job once the build is done, canceled builds included.
+	buildJobDeleteTimeout = 30 * time.Second
+)
+
+type (
+	// builderProvider provides the environment builder a package is built
+	// with, one of the pods of the shared builder deployment of the
+	// environment or a build job of its own.
+	builderProvider interface {
+		// acquire waits for the builder to be ready, it returns false if
+		// the builder didn't get ready in time. The returned context
+		// addresses the build requests to the builder.
+		acquire(ctx context.Context, pkg *fv1.Package, env *fv1.Environment, builderNs string) (context.Context, bool, error)
+		// release frees the builder once the build is done.
+		release(pkg *fv1.Package)
+	}
+
+	// sharedBuilder builds with the least loaded pod of the builder
+	// deployment of the environment.
+	sharedBuilder struct {
+		e *buildExecution
+		// pod is the namespace/name of the pod counting the build
+		pod string
+	}
+
+	// jobBuilder builds in a job created for the build and deleted once
+	// the build is done.
+	jobBuilder struct {
+		e    *buildExecution
+		jobs *buildJobs
+		job  *batchv1.Job
+	}
+
+	// buildJobs creates the build jobs of the environments in job build
+	// mode.
+	buildJobs struct {
+		k8sClient kubernetes.Interface
+		// podTemplate returns the builder pod template of the environment
+		// in the builder namespace.
+		podTemplate func(env *fv1.Environment, ns string) (*apiv1.PodTemplateSpec, error)
+	}
+
+	// builderAddressKey is the context key of the address of the builder
+	// receiving the build requests.
+	builderAddressKey struct{}
+)
+
+// buildMode returns the build mode of the environment, fv1.BuildModeShared
+// unless its build mode annotation selects another one.
+func buildMode(logger *zap.Logger, env *fv1.Environment) string {
+	mode, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_MODE]
+	if !ok {
+		return fv1.BuildModeShared
+	}
+	switch mode {
+	case fv1.BuildModeShared, fv1.BuildModeJob:
+		return mode
+	}
+	logger.Warn("invalid build mode annotation, using the shared builder",
+		zap.String("environment", env.ObjectMeta.Name),
+		zap.String("value", mode))
+	return fv1.BuildModeShared
+}
+
+// builderFor returns the builder provider of the build mode of the
+// environment.
+func (e *buildExecution) builderFor(env *fv1.Environment) builderProvider {
+	if buildMode(e.logger, env) == fv1.BuildModeJob {
+		return &jobBuilder{e: e, jobs: e.buildJobs}
+	}
+	return &sharedBuilder{e: e}
+}
+
+// withBuilderAddress returns the context addressing the build requests to
+// the builder at host.
+func withBuilderAddress(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, builderAddressKey{}, host)
+}
+
+// podAddress returns the host addressing the pod by its IP.
+func podAddress(pod *apiv1.Pod) string {
+	if strings.Contains(pod.Status.PodIP, ":") {
+		return "[" + pod.Status.PodIP + "]"
+	}
+	return pod.Status.PodIP
+}
+
+// builderAddress returns the host of the builder receiving the build
+// requests, the service of the shared builder of the environment unless
+// the context addresses another builder.
+func builderAddress(ctx context.Context, env *fv1.Environment, builderNs string) string {
+	if host, ok := ctx.Value(builderAddressKey{}).(string); ok {
+		return host
+	}
+	return fmt.Sprintf("%v-%v.%v", env.ObjectMeta.Name, env.ObjectMeta.ResourceVersion, builderNs)
+}
+
+// builderPodAddress returns the host addressing the shared builder pod: its
+// IP or, while it has none yet, the builder service of the environment
+// version the pod was created for. The environment may have been updated
+// since without changing its builder.
+func builderPodAddress(pod *apiv1.Pod) string {
+	if len(pod.Status.PodIP) > 0 {
+		return podAddress(pod)
+	}
+	return fmt.Sprintf("%v-%v.%v", pod.ObjectMeta.Labels[LABEL_ENV_NAME],
+		pod.ObjectMeta.Labels[LABEL_ENV_RESOURCEVERSION], pod.ObjectMeta.Namespace)
+}
+
+// acquire waits for a ready pod of the shared builder. The build requests
+// go to the pod picked by the wait, or to the builder service while the pod
+// has no IP yet. Pods that don't answer the ping before the build are
+// passed over and the wait goes on.
+func (b *sharedBuilder) acquire(ctx context.Context, pkg *fv1.Package, env *fv1.Environment, builderNs string) (context.Context, bool, error) {
+	for pings := 0; pings < maxBuilderPings; pings++ {
+		pod, err := b.e.waitForBuilder(ctx, pkg, env, builderNs)
+		if err != nil {
+			return ctx, false, errors.Wrap(err, "error retrieving pod information for environment")
+		}
+		if pod == nil {
+			return ctx, false, nil
+		}
+		podCtx := withBuilderAddress(ctx, builderPodAddress(pod))
+		if b.e.pingBuilder(podCtx, pod, env, builderNs) {
+			b.pod = builderPodName(pod)
+			return podCtx, true, nil
+		}
+		b.e.builderLoad.release(builderPodName(pod))
+	}
+	return ctx, false, nil
+}
+
+// release stops counting the build on the builder pod.
+func (b *sharedBuilder) release(pkg *fv1.Package) {
+	b.e.builderLoad.release(b.pod)
+}
+
+// acquire creates the build job and waits for its builder pod to be
+// ready, with the health check backoff of the shared builder wait.
+func (b *jobBuilder) acquire(ctx context.Context, pkg *fv1.Package, env *fv1.Environment, builderNs string) (context.Context, bool, error) {
+	e := b.e
+	if b.jobs == nil {
+		return ctx, false, permanentBuildError{errors.Errorf("environment %q builds in jobs, which this builder manager doesn't run",
+			env.ObjectMeta.Name)}
+	}
+	waitStart := time.Now()
+	e.setState(buildStateWaitingForBuilder)
+	e.setPhase(fv1.BuildPhaseWaitingForBuilder)
+
+	job, err := b.jobs.newJob(e.logger, pkg, env, builderNs, e.opts.Timeout)
+	if err != nil {
+		return ctx, false, permanentBuildError{errors.Wrap(err, "error making build job")}
+	}
+	b.job, err = b.jobs.k8sClient.BatchV1().Jobs(builderNs).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		b.job = nil
+		return ctx, false, errors.Wrap(err, "error creating build job")
+	}
+	e.logger.Info("created build job", zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("job", b.job.ObjectMeta.Name), zap.String("namespace", builderNs))
+
+	backoff, err := utils.NewBackOff(e.builderBackoff.InitialInterval, e.builderBackoff.MaxInterval,
+		e.builderBackoff.Multiplier, utils.DefaultMaxCount)
+	if err != nil {
+		return ctx, false, err
+	}
+	// the build job pod last seen
+	var last *apiv1.Pod
+	for backoff.NextExists() {
+		if ctx.Err() != nil {
+			return ctx, false, nil
+		}
+		pod, err := b.jobs.jobPod(ctx, b.job)
+		if err != nil {
+			return ctx, false, err
+		}
+		last = pod
+		if pod != nil {
+			e.setBuilderPod(pod)
+			if pod.Status.Phase == apiv1.PodFailed || pod.Status.Phase == apiv1.PodSucceeded {
+				return ctx, false, errors.Errorf("build job pod %s exited before the build: %s %s",
+					pod.ObjectMeta.Name, pod.Status.Reason, pod.Status.Message)
+			}
+			if len(pod.Status.ContainerStatuses) > 0 && builderPodReady(pod) && len(pod.Status.PodIP) > 0 {
+				observeBuilderWait(pkg, waitStart)
+				e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionTrue, fv1.PackageReasonBuilderPodReady,
+					fmt.Sprintf("build job pod %s is ready", pod.ObjectMeta.Name))
+				return withBuilderAddress(ctx, podAddress(pod)), true, nil
+			}
+		}
+		wait, ok := e.builderBackoff.remaining(waitStart, backoff.GetNext())
+		if !ok {
+			return ctx, false, builderWaitFailure(last)
+		}
+		waitReady(ctx, nil, wait)
+	}
+	if ctx.Err() != nil {
+		return ctx, false, nil
+	}
+	return ctx, false, builderWaitFailure(last)
+}
+
+// release deletes the build job along with its pod.
+func (b *jobBuilder) release(pkg *fv1.Package) {
+	if b.job == nil {
+		return
+	}
+	// the build context may be canceled already
+	ctx, cancel := context.WithTimeout(context.Background(), buildJobDeleteTimeout)
+	defer cancel()
+	err := b.jobs.k8sClient.BatchV1().Jobs(b.job.ObjectMeta.Namespace).Delete(ctx, b.job.ObjectMeta.Name, delOpt)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		b.e.logger.Error("error deleting build job", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("job", b.job.ObjectMeta.Name), zap.String("namespace", b.job.ObjectMeta.Namespace))
+		return
+	}
+	b.e.logger.Info("deleted build job", zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("job", b.job.ObjectMeta.Name), zap.String("namespace", b.job.ObjectMeta.Namespace))
+}
+
+// newJob returns the build job of the package, running the builder pod of
+// the environment with the build resources of the package. The job runs a
+// single pod, never restarted.
+func (j *buildJobs) newJob(logger *zap.Logger, pkg *fv1.Package, env *fv1.Environment, builderNs string,
+	timeout time.Duration) (*batchv1.Job, error) {
+	pod, err := j.podTemplate(env, builderNs)
+	if err != nil {
+		return nil, err
+	}
+	name := buildJobName(pkg)
+	// the job pod isn't one of the shared builder pods of the environment
+	pod.ObjectMeta.Labels = map[string]string{LABEL_BUILD_JOB: name}
+	pod.Spec.RestartPolicy = apiv1.RestartPolicyNever
+	setBuildJobResources(logger, pkg, &pod.Spec)
+
+	deadline := int64(buildJobDeadline.Seconds())
+	if timeout > 0 {
+		deadline = int64((timeout + buildJobDeadlineMargin).Seconds())
+	}
+	var backoffLimit int32
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: builderNs,
+			Name:      name,
+			Labels: map[string]string{
+				LABEL_ENV_NAME:         env.ObjectMeta.Name,
+				LABEL_ENV_NAMESPACE:    builderNs,
+				LABEL_DEPLOYMENT_OWNER: BUILDER_MGR,
+				LABEL_BUILD_JOB:        name,
+			},
+			Annotations: map[string]string{
+				"package": pkg.ObjectMeta.Namespace + "/" + pkg.ObjectMeta.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template:              *pod,
+		},
+	}, nil
+}
+
+// jobPod returns the pod of the build job, nil until the job created it.
+func (j *buildJobs) jobPod(ctx context.Context, job *batchv1.Job) (*apiv1.Pod, error) {
+	pods, err := j.k8sClient.CoreV1().Pods(job.ObjectMeta.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{LABEL_BUILD_JOB: job.ObjectMeta.Name}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting build job pod")
+	}
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+	return &pods.Items[0], nil
+}
+
+// buildJobName returns a unique job name for a build of the package.
+func buildJobName(pkg *fv1.Package) string {
+	name := pkg.ObjectMeta.Name
+	// job pods are labeled with the job name, labels are at most 63
+	// characters long
+	if len(name) > 40 {
+		name = strings.TrimRight(name[:40], "-.")
+	}
+	return fmt.Sprintf("%s-build-%s", name, strings.ToLower(uniuri.NewLen(6)))
+}
+
+// setBuildJobResources sets the resources of the builder container from
+// the build resource annotations of the package, as requests and limits.
+func setBuildJobResources(logger *zap.Logger, pkg *fv1.Package, spec *apiv1.PodSpec) {
+	resources := apiv1.ResourceList{}
+	for annotation, name := range map[string]apiv1.ResourceName{
+		fv1.ANNOTATION_BUILD_CPU:    apiv1.ResourceCPU,
+		fv1.ANNOTATION_BUILD_MEMORY: apiv1.ResourceMemory,
+	} {
+		v, ok := pkg.ObjectMeta.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(v)
+		if err != nil {
+			logger.Warn("invalid build resource annotation, ignoring",
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("annotation", annotation),
+				zap.String("value", v))
+			continue
+		}
+		resources[name] = quantity
+	}
+	if len(resources) == 0 {
+		return
+	}
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if container.Name != "builder" {
+			continue
+		}
+		// the resources may be the ones of the environment
+		container.Resources = *container.Resources.DeepCopy()
+		if container.Resources.Requests == nil {
+			container.Resources.Requests = apiv1.ResourceList{}
+		}
+		if container.Resources.Limits == nil {
+			container.Resources.Limits = apiv1.ResourceList{}
+		}
+		for name, quantity := range resources {
+			container.Resources.Requests[name] = quantity
+			container.Resources.Limits[name] = quantity
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func TestExecuteBuildInJob(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILD_MODE: fv1.BuildModeJob}
+	_, err := tb.fissionClient.CoreV1().Environments(testNamespace).Update(context.Background(), tb.env, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.pkg.ObjectMeta.Annotations = map[string]string{
+		fv1.ANNOTATION_BUILD_MEMORY: "2Gi",
+		fv1.ANNOTATION_BUILD_CPU:    "a lot",
+	}
+	// the environment has no shared builder
+	tb.pods.pods = nil
+
+	k8sClient := fake.NewSimpleClientset()
+	var created *batchv1.Job
+	k8sClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		// the job controller starts the job pod
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      created.ObjectMeta.Name + "-x7k2p",
+				Namespace: created.ObjectMeta.Namespace,
+				Labels:    created.Spec.Template.ObjectMeta.Labels,
+			},
+			Status: apiv1.PodStatus{
+				Phase: apiv1.PodRunning,
+				PodIP: "10.0.0.7",
+				ContainerStatuses: []apiv1.ContainerStatus{
+					{Name: "builder", Ready: true},
+					{Name: "fetcher", Ready: true},
+				},
+			},
+		}
+		return false, nil, k8sClient.Tracker().Add(pod)
+	})
+	tb.deps.buildJobs = &buildJobs{
+		k8sClient: k8sClient,
+		podTemplate: func(env *fv1.Environment, ns string) (*apiv1.PodTemplateSpec, error) {
+			return &apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{{Name: "builder", Image: env.Spec.Builder.Image}, {Name: "fetcher"}},
+				},
+			}, nil
+		},
+	}
+	var address string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		address = builderAddress(ctx, tb.env, envBuilderNamespace)
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	if result.Status != fv1.BuildStatusSucceeded || tb.builds != 1 {
+		t.Fatalf("Expected succeeded build, got %s after %d builds", result.Status, tb.builds)
+	}
+	if address != "10.0.0.7" {
+		t.Errorf("Expected build requests sent to the job pod, got %q", address)
+	}
+
+	if created == nil {
+		t.Fatal("Expected build job created")
+	}
+	if !strings.HasPrefix(created.ObjectMeta.Name, testPkgName+"-build-") {
+		t.Errorf("Unexpected build job name %q", created.ObjectMeta.Name)
+	}
+	if created.Spec.BackoffLimit == nil || *created.Spec.BackoffLimit != 0 ||
+		created.Spec.ActiveDeadlineSeconds == nil || *created.Spec.ActiveDeadlineSeconds != 360 {
+		t.Errorf("Expected single attempt job with deadline past the build timeout, got %+v", created.Spec)
+	}
+	podSpec := created.Spec.Template.Spec
+	if podSpec.RestartPolicy != apiv1.RestartPolicyNever {
+		t.Errorf("Expected job pod never restarted, got %q", podSpec.RestartPolicy)
+	}
+	builder := podSpec.Containers[0].Resources
+	if !builder.Limits.Memory().Equal(resource.MustParse("2Gi")) || !builder.Requests.Memory().Equal(resource.MustParse("2Gi")) {
+		t.Errorf("Expected builder memory of the package annotation, got %+v", builder)
+	}
+	if _, ok := builder.Limits[apiv1.ResourceCPU]; ok {
+		t.Errorf("Expected invalid builder CPU annotation ignored, got %+v", builder)
+	}
+	if len(podSpec.Containers[1].Resources.Limits) != 0 {
+		t.Errorf("Expected fetcher resources left alone, got %+v", podSpec.Containers[1].Resources)
+	}
+
+	jobs, err := k8sClient.BatchV1().Jobs(created.ObjectMeta.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("Expected build job deleted after the build, got %d jobs", len(jobs.Items))
+	}
+	checkCondition(t, tb.getPackage(t), fv1.PackageConditionBuilderReady, metav1.ConditionTrue, fv1.PackageReasonBuilderPodReady)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/storagesvc"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+)
+
+// inlineBuildLogLines is the number of trailing lines of a persisted
+// build log kept in the package status.
+const inlineBuildLogLines = 300
+
+// buildLogTruncatedMarker is prepended to build logs whose head was dropped
+// to fit the package status.
+const buildLogTruncatedMarker = "[log truncated, %d bytes dropped]\n"
+
+// Build log phases, the step of the build a build log line is about. The
+// lines about the build as a whole are buildermgr ones.
+const (
+	logPhaseBuildermgr = "buildermgr"
+	logPhaseFetch      = "fetch"
+	logPhaseBuild      = "build"
+	logPhaseUpload     = "upload"
+)
+
+// logLines tags every line of the text with the current time and the build
+// phase it comes from, e.g. "[2024-05-01T10:00:00Z fetch] ...". The last
+// line gets a newline if it has none.
+func logLines(phase, text string) string {
+	if len(text) == 0 {
+		return ""
+	}
+	prefix := fmt.Sprintf("[%s %s] ", time.Now().UTC().Format(time.RFC3339), phase)
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		b.WriteString(prefix)
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// logf tags the formatted line with the current time and the build phase.
+func logf(phase, format string, args ...interface{}) string {
+	return logLines(phase, fmt.Sprintf(format, args...))
+}
+
+// renderBuildLog returns the build logs as kept in the package status: their
+// last lines if the complete logs are persisted, and their tail within
+// maxSize bytes. A maxSize <= 0 means no limit.
+func renderBuildLog(logger *zap.Logger, pkg *fv1.Package, logs string, persisted bool, maxSize int) string {
+	if persisted {
+		logs = buildLogTail(logs, inlineBuildLogLines)
+	}
+	if len(logs) > maxSize && maxSize > 0 {
+		logger.Info("truncating package build logs",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Int("size", len(logs)),
+			zap.Int("max_size", maxSize))
+		logs = truncateBuildLogs(logs, maxSize)
+	}
+	return logs
+}
+
+// truncateBuildLogs keeps the tail of build logs longer than maxSize bytes
+// behind a marker with the number of dropped bytes, the result is never
+// longer than maxSize. A maxSize <= 0 means no limit.
+func truncateBuildLogs(logs string, maxSize int) string {
+	if maxSize <= 0 || len(logs) <= maxSize {
+		return logs
+	}
+	// the marker is sized for the worst case so that the cut can be
+	// computed before the number of dropped bytes is known
+	markerSize := len(fmt.Sprintf(buildLogTruncatedMarker, len(logs)))
+	if markerSize >= maxSize {
+		return logs[len(logs)-maxSize:]
+	}
+	cut := len(logs) - (maxSize - markerSize)
+	for cut < len(logs) && !utf8.RuneStart(logs[cut]) {
+		cut++
+	}
+	return fmt.Sprintf(buildLogTruncatedMarker, cut) + logs[cut:]
+}
+
+const buildLogTailMarker = "[showing the last %d lines, %d lines dropped, see the full log]\n"
+
+// buildLogTail returns the last n lines of the build logs, prefixed with a
+// note when lines were dropped.
+func buildLogTail(logs string, n int) string {
+	lines := strings.SplitAfter(logs, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return logs
+	}
+	return fmt.Sprintf(buildLogTailMarker, n, len(lines)-n) + strings.Join(lines[len(lines)-n:], "")
+}
+
+// persistBuildLogs uploads the complete build logs of the package to the
+// storage service at storageSvcURL and returns the URL of the log object.
+func (d *BuildDeps) persistBuildLogs(ctx context.Context, logger *zap.Logger, storageSvcURL string, pkg *fv1.Package, buildLogs string) (string, error) {
+	f, err := os.CreateTemp("", "buildlog-")
+	if err != nil {
+		return "", errors.Wrap(err, "error creating build log file")
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(buildLogs)
+	f.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "error writing build log file")
+	}
+
+	logStore, _ := d.storageSvcStores(storageSvcURL)
+	target := storageTargetFor(logger, pkg)
+	result, err := logStore.UploadWithOptions(ctx, f.Name(), storageSvcClient.UploadOptions{
+		ContentType: "text/plain; charset=utf-8",
+		Compression: storagesvc.CompressionAuto,
+		Target:      target,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error uploading build log")
+	}
+	return logStore.GetTargetUrl(result.ID, target), nil
+}
+
+// deleteBuildLog removes a build log object that is no longer referenced
+// by the package. The log is deleted from the storage service it was stored
+// at, the namespace may have been mapped to another one since.
+func (d *BuildDeps) deleteBuildLog(ctx context.Context, logger *zap.Logger, logURL string) {
+	u, err := url.Parse(logURL)
+	if err != nil {
+		logger.Warn("error parsing build log URL", zap.String("url", logURL), zap.Error(err))
+		return
+	}
+	query := u.Query()
+	id := query.Get("id")
+	if len(id) == 0 {
+		logger.Warn("build log URL has no archive ID", zap.String("url", logURL))
+		return
+	}
+	// archive URLs are <storage service URL>/v1/archive?id=<id>
+	storageSvcURL := u.Scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/v1/archive")
+	logStore, _ := d.storageSvcStores(storageSvcURL)
+	err = logStore.DeleteTarget(ctx, id, query.Get(storagesvc.QueryParamTarget))
+	if err != nil {
+		logger.Warn("error deleting build log", zap.String("url", logURL), zap.Error(err))
+	}
+}
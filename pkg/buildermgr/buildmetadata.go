@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"strings"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/builder"
+)
+
+// DefaultBuildMetadataPrefix is the default prefix of the package labels and
+// annotations passed to the builder as build metadata.
+const DefaultBuildMetadataPrefix = "build.fission.io/"
+
+// buildMetadataKey is the context key of the build metadata sent to the
+// builder.
+type buildMetadataKey struct{}
+
+// buildMetadata returns the package labels and annotations whose key has
+// the prefix, named by the rest of the key, as sanitized by the builder.
+// Annotations override labels of the same name. An empty prefix passes
+// none.
+func buildMetadata(pkg *fv1.Package, prefix string) map[string]string {
+	if len(prefix) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string)
+	for _, kv := range []map[string]string{pkg.ObjectMeta.Labels, pkg.ObjectMeta.Annotations} {
+		for k, v := range kv {
+			if name, ok := strings.CutPrefix(k, prefix); ok {
+				metadata[name] = v
+			}
+		}
+	}
+	return builder.SanitizeMetadata(metadata)
+}
+
+func withBuildMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, buildMetadataKey{}, metadata)
+}
+
+// contextBuildMetadata returns the build metadata of the context, nil
+// without metadata.
+func contextBuildMetadata(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(buildMetadataKey{}).(map[string]string)
+	return metadata
+}
@@ -0,0 +1,55 @@
+package buildermgr
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func TestBuildMetadata(t *testing.T) {
+	pkg := &fv1.Package{}
+	pkg.ObjectMeta.Labels = map[string]string{
+		"build.fission.io/team": "payments",
+		"build.fission.io/app":  "checkout",
+		"app":                   "other",
+	}
+	pkg.ObjectMeta.Annotations = map[string]string{
+		"build.fission.io/app":    "checkout-v2",
+		"build.fission.io/mirror": "https://mirror\n",
+	}
+	expected := map[string]string{"team": "payments", "app": "checkout-v2", "mirror": "https://mirror"}
+	if metadata := buildMetadata(pkg, DefaultBuildMetadataPrefix); !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected prefixed labels and annotations as build metadata %v, got %v", expected, metadata)
+	}
+	if metadata := buildMetadata(pkg, ""); metadata != nil {
+		t.Errorf("Expected no build metadata without prefix, got %v", metadata)
+	}
+}
+
+func TestExecuteBuildSendsBuildMetadata(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.buildMetadataPrefix = DefaultBuildMetadataPrefix
+	tb.pkg.ObjectMeta.Labels = map[string]string{"build.fission.io/team": "payments"}
+
+	var sent map[string]string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		sent = contextBuildMetadata(ctx)
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{SkipPackageUpdate: true})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if sent["team"] != "payments" {
+		t.Errorf("Expected build metadata sent to the builder, got %v", sent)
+	}
+}
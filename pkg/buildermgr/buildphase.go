@@ -0,0 +1,230 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+)
+
+const (
+	// buildPhaseUpdateInterval is the minimum time between two build phase
+	// updates of a package, a phase reached meanwhile is written after it.
+	buildPhaseUpdateInterval = 2 * time.Second
+
+	// buildPhaseUpdateQPS and buildPhaseUpdateBurst limit the build phase
+	// updates of all builds of the package watcher.
+	buildPhaseUpdateQPS   = 5
+	buildPhaseUpdateBurst = 10
+)
+
+type (
+	// buildReporter is told the progress of a build by the build steps.
+	buildReporter interface {
+		setPhase(phase fv1.BuildPhase)
+		setResourceUsage(usage *fv1.BuildResourceUsage)
+		setSourceCommit(commit string)
+		sourceFetchFailed(pod string)
+	}
+
+	// buildReporterKey is the context key of the build reporter.
+	buildReporterKey struct{}
+)
+
+// withBuildReporter returns a context carrying the build reporter.
+func withBuildReporter(ctx context.Context, reporter buildReporter) context.Context {
+	return context.WithValue(ctx, buildReporterKey{}, reporter)
+}
+
+// reportBuildPhase tells the build running with ctx that it reached the phase.
+func reportBuildPhase(ctx context.Context, phase fv1.BuildPhase) {
+	if reporter, ok := ctx.Value(buildReporterKey{}).(buildReporter); ok {
+		reporter.setPhase(phase)
+	}
+}
+
+// reportBuildResourceUsage tells the build running with ctx the resource
+// usage of its build command.
+func reportBuildResourceUsage(ctx context.Context, usage *fv1.BuildResourceUsage) {
+	if reporter, ok := ctx.Value(buildReporterKey{}).(buildReporter); ok {
+		reporter.setResourceUsage(usage)
+	}
+}
+
+// reportSourceCommit tells the build running with ctx the commit its git
+// source was resolved to.
+func reportSourceCommit(ctx context.Context, commit string) {
+	if reporter, ok := ctx.Value(buildReporterKey{}).(buildReporter); ok {
+		reporter.setSourceCommit(commit)
+	}
+}
+
+// reportSourceFetchFailure tells the build running with ctx that the
+// builder pod, given as namespace/name, failed to fetch its source.
+func reportSourceFetchFailure(ctx context.Context, pod string) {
+	if reporter, ok := ctx.Value(buildReporterKey{}).(buildReporter); ok {
+		reporter.sourceFetchFailed(pod)
+	}
+}
+
+// setPhase moves the build to the phase and writes it to the package status.
+func (e *buildExecution) setPhase(phase fv1.BuildPhase) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.phase = phase
+	if e.opts.onPhaseChange != nil {
+		e.opts.onPhaseChange(phase)
+	}
+	e.writePhase()
+}
+
+// writePhase writes the build phase and builder wait to the package status
+// if they changed. Writes are at least phaseUpdateInterval apart and subject
+// to the phase rate limiter, a write that has to wait is scheduled and
+// writes the phase the build is at by then. e.mu must be held.
+func (e *buildExecution) writePhase() {
+	if e.phasesDone || e.phaseTimer != nil || e.opts.SkipPackageUpdate || e.pkg == nil {
+		return
+	}
+	if e.phase == e.writtenPhase && e.builderWait == e.writtenBuilderWait {
+		return
+	}
+	wait := e.phaseUpdateInterval - time.Since(e.lastWrite)
+	if wait <= 0 && (e.phaseLimiter == nil || e.phaseLimiter.TryAccept()) {
+		e.writePackagePhase()
+		return
+	}
+	if wait <= 0 {
+		wait = e.phaseUpdateInterval
+	}
+	e.phaseTimer = time.AfterFunc(wait, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.phaseTimer = nil
+		e.writePhase()
+	})
+}
+
+// writePackagePhase updates the package status with the build phase.
+// e.mu must be held.
+func (e *buildExecution) writePackagePhase() {
+	if e.ctx.Err() != nil {
+		return
+	}
+	pkg := e.pkg.DeepCopy()
+	pkg.Status.BuildPhase = e.phase
+	pkg.Status.Conditions = copyConditions(e.conditions)
+	pkg.Status.BuildResourceUsage = e.resourceUsage.DeepCopy()
+	pkg.Status.BuilderWait = e.builderWait.DeepCopy()
+	pkg.Status.BuilderPod = e.builderPodInfo.DeepCopy()
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+	updated, err := crd.UpdatePackageStatus(e.ctx, e.FissionClient, pkg)
+	if err != nil {
+		// phase updates are best effort, the status update
+		// of the build result records the phase anyway
+		e.logger.Warn("error updating package build phase",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.String("phase", string(e.phase)),
+			zap.Error(err))
+		return
+	}
+	e.wrotePackage(updated, e.phase)
+}
+
+// wrotePackage records the package status write. e.mu must be held.
+func (e *buildExecution) wrotePackage(pkg *fv1.Package, phase fv1.BuildPhase) {
+	e.pkg = pkg
+	e.writtenPhase = phase
+	// the build status updates leave the builder wait out
+	e.writtenBuilderWait = nil
+	if pkg.Status.BuilderWait != nil {
+		e.writtenBuilderWait = e.builderWait
+	}
+	e.lastWrite = time.Now()
+}
+
+// setBuilderWait records the health check backoff of the builder wait, it's
+// written like a build phase. A nil wait is cleared with the next update.
+func (e *buildExecution) setBuilderWait(wait *fv1.BuilderWait) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.builderWait = wait
+	if wait != nil {
+		e.writePhase()
+	}
+}
+
+// newBuilderWait returns the builder wait of the health check attempt,
+// the next attempt is due after retryIn.
+func newBuilderWait(attempt, maxAttempts int32, retryIn time.Duration) *fv1.BuilderWait {
+	return &fv1.BuilderWait{
+		Attempt:       attempt,
+		MaxAttempts:   maxAttempts,
+		NextRetryTime: &metav1.Time{Time: time.Now().Add(retryIn).UTC()},
+	}
+}
+
+// stopPhaseUpdates stops the build phase updates once the build is over.
+func (e *buildExecution) stopPhaseUpdates() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.phasesDone = true
+	if e.phaseTimer != nil {
+		e.phaseTimer.Stop()
+		e.phaseTimer = nil
+	}
+}
+
+// setResourceUsage records the resource usage of the build command, it's
+// written with the next package status update.
+func (e *buildExecution) setResourceUsage(usage *fv1.BuildResourceUsage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resourceUsage = usage.DeepCopy()
+	observeBuildResourceUsage(e.envName, e.envNamespace, usage)
+}
+
+// setSourceCommit records the commit the git source of the build was
+// resolved to, it's written with the next package status update.
+func (e *buildExecution) setSourceCommit(commit string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sourceCommit = commit
+}
+
+// sourceFetchFailed counts the failed source fetch of the builder pod,
+// pods failing them repeatedly are recycled.
+func (e *buildExecution) sourceFetchFailed(pod string) {
+	e.mu.Lock()
+	e.sourceFetchFailures++
+	e.mu.Unlock()
+	e.fetchFailures.record(e.ctx, pod)
+}
+
+// latestPackage returns the latest version of the package written by the build.
+func (e *buildExecution) latestPackage() *fv1.Package {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.pkg
+}
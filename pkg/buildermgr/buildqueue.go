@@ -0,0 +1,270 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// buildState is the state of a package build tracked in the build cache.
+type buildState int32
+
+const (
+	// buildStatePending builds wait in the build queue or for their retry.
+	buildStatePending buildState = iota
+	// buildStateRunning builds are in flight.
+	buildStateRunning
+	// buildStateWaitingForBuilder builds are in flight but blocked
+	// waiting for a ready environment builder pod.
+	buildStateWaitingForBuilder
+)
+
+func (s buildState) String() string {
+	switch s {
+	case buildStateRunning:
+		return "running"
+	case buildStateWaitingForBuilder:
+		return "waiting_for_builder"
+	default:
+		return "pending"
+	}
+}
+
+// buildQueueReportInterval is the interval of the build queue depth reports.
+const buildQueueReportInterval = 10 * time.Second
+
+// buildQueue is a queue of package builds waiting for a free build slot,
+// ordered by priority then enqueue time. The enqueue time of a package build
+// is when the package was marked pending, or the one restored from the queue
+// ledger on restart, so that builds of the same priority go in FIFO order
+// whatever the order of the package events.
+type buildQueue struct {
+	items *list.List
+	mutex sync.Mutex
+	// onChange is called after the queue changed, outside of the lock
+	onChange func()
+}
+
+func newBuildQueue() *buildQueue {
+	return &buildQueue{
+		items: list.New(),
+	}
+}
+
+func (q *buildQueue) Push(b *pkgBuild) {
+	if b.enqueueTime.IsZero() {
+		b.enqueueTime = time.Now()
+	}
+	q.mutex.Lock()
+	item := q.items.Back()
+	for item != nil && queuedBefore(b, item.Value.(*pkgBuild)) {
+		item = item.Prev()
+	}
+	if item == nil {
+		q.items.PushFront(b)
+	} else {
+		q.items.InsertAfter(b, item)
+	}
+	q.mutex.Unlock()
+	q.changed()
+}
+
+// queuedBefore returns whether build a goes before build b in the queue.
+func queuedBefore(a, b *pkgBuild) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.enqueueTime.Before(b.enqueueTime)
+}
+
+// pendingSince returns when the package was marked pending: the transition
+// time of its pending build condition, its last status update for packages
+// without, or its creation. It's the zero time if unknown.
+func pendingSince(pkg *fv1.Package) time.Time {
+	cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuildSucceeded)
+	if cond != nil && cond.Status == metav1.ConditionUnknown && !cond.LastTransitionTime.IsZero() {
+		return cond.LastTransitionTime.Time
+	}
+	if !pkg.Status.LastUpdateTimestamp.IsZero() {
+		return pkg.Status.LastUpdateTimestamp.Time
+	}
+	return pkg.ObjectMeta.CreationTimestamp.Time
+}
+
+// buildPriority returns the build priority of the package from its
+// annotation, invalid values get the default priority 0.
+func buildPriority(logger *zap.Logger, pkg *fv1.Package) int {
+	v, ok := pkg.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_PRIORITY]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Warn("invalid build priority annotation, using default",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.String("value", v),
+			zap.Int("default", 0))
+		return 0
+	}
+	return priority
+}
+
+func (q *buildQueue) Pop() *pkgBuild {
+	q.mutex.Lock()
+	item := q.items.Front()
+	if item == nil {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.items.Remove(item)
+	q.mutex.Unlock()
+	q.changed()
+
+	b, ok := item.Value.(*pkgBuild)
+	if !ok {
+		return nil
+	}
+	return b
+}
+
+// PopFirst removes and returns the first build in queue order for which
+// take returns true, or nil if there is none. take is called with the
+// queue locked.
+func (q *buildQueue) PopFirst(take func(*pkgBuild) bool) *pkgBuild {
+	q.mutex.Lock()
+	for item := q.items.Front(); item != nil; item = item.Next() {
+		b, ok := item.Value.(*pkgBuild)
+		if !ok || !take(b) {
+			continue
+		}
+		q.items.Remove(item)
+		q.mutex.Unlock()
+		q.changed()
+		return b
+	}
+	q.mutex.Unlock()
+	return nil
+}
+
+// Any returns whether a queued build satisfies f.
+func (q *buildQueue) Any(f func(*pkgBuild) bool) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for item := q.items.Front(); item != nil; item = item.Next() {
+		if b, ok := item.Value.(*pkgBuild); ok && f(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *buildQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.items.Len()
+}
+
+// Builds returns the queued builds in queue order.
+func (q *buildQueue) Builds() []*pkgBuild {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	builds := make([]*pkgBuild, 0, q.items.Len())
+	for item := q.items.Front(); item != nil; item = item.Next() {
+		if b, ok := item.Value.(*pkgBuild); ok {
+			builds = append(builds, b)
+		}
+	}
+	return builds
+}
+
+func (q *buildQueue) changed() {
+	if q.onChange != nil {
+		q.onChange()
+	}
+}
+
+// buildQueueDepth counts the builds of the build cache by environment
+// namespace and state. Builds waiting for a builder are in flight too, so
+// they are counted as running as well.
+func (pkgw *packageWatcher) buildQueueDepth() map[string]map[buildState]int {
+	depth := make(map[string]map[buildState]int)
+	for _, v := range pkgw.buildCache.Copy() {
+		b, ok := v.(*pkgBuild)
+		if !ok {
+			continue
+		}
+		ns := b.pkg.Spec.Environment.Namespace
+		if depth[ns] == nil {
+			depth[ns] = make(map[buildState]int)
+		}
+		state := buildState(b.state.Load())
+		depth[ns][state]++
+		if state == buildStateWaitingForBuilder {
+			depth[ns][buildStateRunning]++
+		}
+	}
+	return depth
+}
+
+// reportBuildQueue periodically updates the build queue depth gauge and logs
+// the depth. Namespaces without builds left are reported with zero builds,
+// so the gauge drops to zero once everything drains.
+func (pkgw *packageWatcher) reportBuildQueue(ctx context.Context) {
+	namespaces := make(map[string]struct{})
+	ticker := time.NewTicker(buildQueueReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pkgw.updateBuildQueueDepth(namespaces)
+	}
+}
+
+func (pkgw *packageWatcher) updateBuildQueueDepth(namespaces map[string]struct{}) {
+	depth := pkgw.buildQueueDepth()
+	for ns := range depth {
+		namespaces[ns] = struct{}{}
+	}
+	total := make(map[buildState]int)
+	for ns := range namespaces {
+		for _, state := range []buildState{buildStatePending, buildStateRunning, buildStateWaitingForBuilder} {
+			n := depth[ns][state]
+			buildQueueDepth.WithLabelValues(ns, state.String()).Set(float64(n))
+			total[state] += n
+		}
+	}
+	pkgw.logger.Debug("build queue depth",
+		zap.Int("pending", total[buildStatePending]),
+		zap.Int("running", total[buildStateRunning]),
+		zap.Int("waiting_for_builder", total[buildStateWaitingForBuilder]),
+		zap.Int("queued", pkgw.buildQueue.Len()))
+	pkgw.updateEnvPoolQueued()
+}
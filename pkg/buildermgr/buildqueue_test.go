@@ -0,0 +1,209 @@
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestBuildQueuePopEmpty(t *testing.T) {
+	q := newBuildQueue()
+	if b := q.Pop(); b != nil {
+		t.Errorf("Expected Pop on empty queue to return nil, got %v", b.pkg.Name)
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected queue length to be 0, got %d", q.Len())
+	}
+}
+
+func TestBuildQueueFIFOOrder(t *testing.T) {
+	q := newBuildQueue()
+	noOfPackages := 5
+	for i := 0; i < noOfPackages; i++ {
+		q.Push(&pkgBuild{
+			pkg: &fv1.Package{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pkg-%d", i)},
+			},
+		})
+	}
+	if q.Len() != noOfPackages {
+		t.Errorf("Expected queue length to be %d, got %d", noOfPackages, q.Len())
+	}
+	for i := 0; i < noOfPackages; i++ {
+		b := q.Pop()
+		if b == nil {
+			t.Fatalf("Expected Pop to return a package build")
+		}
+		expected := fmt.Sprintf("pkg-%d", i)
+		if b.pkg.Name != expected {
+			t.Errorf("Expected package %s, got %s", expected, b.pkg.Name)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected queue length to be 0, got %d", q.Len())
+	}
+}
+
+func TestBuildQueuePriorityOrder(t *testing.T) {
+	q := newBuildQueue()
+	logger := loggerfactory.GetLogger()
+	for i, priority := range []string{"", "0", "10", "1", "10", "-1", "urgent"} {
+		pkg := &fv1.Package{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pkg-%d", i)},
+		}
+		if len(priority) > 0 {
+			pkg.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILD_PRIORITY: priority}
+		}
+		q.Push(&pkgBuild{pkg: pkg, priority: buildPriority(logger, pkg)})
+	}
+
+	// higher priorities go first, equal priorities keep FIFO order and
+	// invalid priorities get the default one
+	expected := []string{"pkg-2", "pkg-4", "pkg-3", "pkg-0", "pkg-1", "pkg-6", "pkg-5"}
+	var order []string
+	for b := q.Pop(); b != nil; b = q.Pop() {
+		order = append(order, b.pkg.ObjectMeta.Name)
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected build order %v, got %v", expected, order)
+	}
+}
+
+func TestBuildQueuePriorityKeepsRunningBuilds(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.buildSlots = make(chan struct{}, 1)
+	started := make(chan string, 4)
+	tpw.deps.buildPackage = blockOnContext(started)
+
+	build := func(name string, priority string) *fv1.Package {
+		t.Helper()
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = name
+		pkg.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILD_PRIORITY: priority}
+		pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(context.Background(), pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+		tpw.buildWithCache(pkg, fv1.BuildTriggerPackageCreated)
+		return pkg
+	}
+	running := build("batch-1", "0")
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the first build to start")
+	}
+	build("batch-2", "0")
+	hotfix := build("hotfix", "100")
+
+	// the hotfix jumps ahead of the queued build but not of the running one
+	queued := tpw.buildQueue.Builds()
+	if len(queued) != 2 || queued[0].pkg.ObjectMeta.Name != "hotfix" || queued[1].pkg.ObjectMeta.Name != "batch-2" {
+		t.Fatalf("Expected the hotfix build queued first, got %d queued builds", len(queued))
+	}
+	tpw.CancelBuild(testNamespace, running.ObjectMeta.Name)
+	select {
+	case id := <-started:
+		if id != buildID(hotfix, 1) {
+			t.Errorf("Expected build %s to start, got %q", buildID(hotfix, 1), id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the hotfix build to start")
+	}
+}
+
+func TestBuildQueueDepth(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.buildSlots = make(chan struct{}, 1)
+	queued := tpw.pkg.DeepCopy()
+	queued.ObjectMeta.Name = "queued-pkg"
+
+	// no builder pod exists, the first build waits for one
+	// while the second one stays queued
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.buildWithCache(queued, fv1.BuildTriggerPackageCreated)
+
+	gauge := func(state buildState) float64 {
+		return testutil.ToFloat64(buildQueueDepth.WithLabelValues(testNamespace, state.String()))
+	}
+	namespaces := make(map[string]struct{})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tpw.updateBuildQueueDepth(namespaces)
+		if gauge(buildStateWaitingForBuilder) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a build waiting for the builder")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := gauge(buildStatePending); n != 1 {
+		t.Errorf("Expected 1 pending build, got %v", n)
+	}
+	if n := gauge(buildStateRunning); n != 1 {
+		t.Errorf("Expected 1 running build, got %v", n)
+	}
+
+	tpw.Shutdown(0)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+	tpw.updateBuildQueueDepth(namespaces)
+	for _, state := range []buildState{buildStatePending, buildStateRunning, buildStateWaitingForBuilder} {
+		if n := gauge(state); n != 0 {
+			t.Errorf("Expected no %s builds after draining, got %v", state, n)
+		}
+	}
+}
+
+func TestPendingSince(t *testing.T) {
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	updated := created.Add(10 * time.Minute)
+	pending := created.Add(20 * time.Minute)
+	pkg := &fv1.Package{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Time{Time: created}}}
+	if got := pendingSince(pkg); !got.Equal(created) {
+		t.Errorf("Expected creation time of package without status, got %v", got)
+	}
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: updated}
+	if got := pendingSince(pkg); !got.Equal(updated) {
+		t.Errorf("Expected last status update of package without condition, got %v", got)
+	}
+	pkg.Status.Conditions = []metav1.Condition{{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionUnknown,
+		Reason:             fv1.PackageReasonBuildPending,
+		LastTransitionTime: metav1.Time{Time: pending},
+	}}
+	if got := pendingSince(pkg); !got.Equal(pending) {
+		t.Errorf("Expected transition time of the pending condition, got %v", got)
+	}
+}
+
+func TestBuildsQueuedInPendingOrder(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	// hold back the dispatch like at startup
+	tpw.reconciling.Store(true)
+	now := time.Now()
+	// the events arrive in another order than the packages were marked pending
+	for i, age := range []time.Duration{time.Minute, 3 * time.Minute, 2 * time.Minute} {
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = fmt.Sprintf("pkg-%d", i)
+		pkg.Status.LastUpdateTimestamp = metav1.Time{Time: now.Add(-age)}
+		tpw.buildWithCache(pkg, fv1.BuildTriggerPackageCreated)
+	}
+	var names []string
+	for _, b := range tpw.buildQueue.Builds() {
+		names = append(names, b.pkg.ObjectMeta.Name)
+	}
+	if expected := []string{"pkg-1", "pkg-2", "pkg-0"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected builds queued in pending order %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	builderClient "github.com/fission/fission/pkg/builder/client"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// The classes of build failures. The errors of failed builds wrap the class
+// of the failure, tell them apart with errors.Is.
+var (
+	// ErrBuilderUnreachable is a fetcher or builder of the builder pod not
+	// answering, another attempt may reach it.
+	ErrBuilderUnreachable = errors.New("builder unreachable")
+	// ErrFetchFailed is the fetcher failing to fetch the source archive.
+	ErrFetchFailed = errors.New("source fetch failed")
+	// ErrBuildFailed is the builder running the build command and the
+	// command failing, building the same source again won't help.
+	ErrBuildFailed = errors.New("build failed")
+	// ErrUploadFailed is the fetcher failing to upload the deployment
+	// archive to the storage service.
+	ErrUploadFailed = errors.New("deployment archive upload failed")
+	// ErrProtocolMismatch is the builder answering with a build protocol
+	// version the builder manager can't build with, the builder has to be
+	// rolled.
+	ErrProtocolMismatch = errors.New("builder protocol mismatch")
+)
+
+type (
+	// packageBuildResult is the outcome of a package build against the
+	// environment builder. It's named apart from BuildResult, the outcome
+	// of a whole build execution.
+	packageBuildResult struct {
+		uploadResp *fetcher.ArchiveUploadResponse
+		logs       string
+		// failedPhase is the phase of a failed build, logPhaseFetch,
+		// logPhaseBuild, logPhaseUpload or logPhaseBuildermgr for the
+		// failures before the fetch.
+		failedPhase string
+		// statusCodes are the HTTP status codes of the fetcher and builder
+		// answers by phase, phases without answer have none.
+		statusCodes map[string]int
+		// durations are the durations of the phases the build went through.
+		durations map[string]time.Duration
+		// transfers are the archive transfers between the builder pod
+		// and the storage service, by storage operation.
+		transfers map[string]storageTransfer
+	}
+
+	// buildPhaseError is the error of a failed build phase, it wraps the
+	// class of the failure along with the error of the phase.
+	buildPhaseError struct {
+		phase string
+		class error
+		error
+	}
+
+	// packageBuilder runs package builds against the environment builder.
+	packageBuilder func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error)
+)
+
+func (e buildPhaseError) Unwrap() error {
+	return e.error
+}
+
+func (e buildPhaseError) Is(target error) bool {
+	return target == e.class
+}
+
+func newPackageBuildResult() *packageBuildResult {
+	return &packageBuildResult{
+		statusCodes: make(map[string]int),
+		durations:   make(map[string]time.Duration),
+		transfers:   make(map[string]storageTransfer),
+	}
+}
+
+// observe records the duration of the phase started at start and the HTTP
+// status code of the answer of the fetcher or builder to its last request,
+// if it answered.
+func (r *packageBuildResult) observe(phase string, start time.Time, err error) {
+	r.durations[phase] += time.Since(start)
+	if code, ok := answerStatus(err); ok {
+		r.statusCodes[phase] = code
+	} else {
+		delete(r.statusCodes, phase)
+	}
+}
+
+// fail records the failed phase and the build logs, and wraps err with
+// the class of the failure. The phases the fetcher or builder didn't answer
+// failed because of an unreachable builder, whatever the phase, unless the
+// builder timeout canceled them.
+func (r *packageBuildResult) fail(phase string, class error, logs string, err error) (*packageBuildResult, error) {
+	r.failedPhase = phase
+	r.logs = logs
+	var timeout builderTimeoutError
+	if _, ok := r.statusCodes[phase]; !ok && phase != logPhaseBuildermgr && !errors.As(err, &timeout) {
+		class = ErrBuilderUnreachable
+	}
+	if class == nil {
+		return r, err
+	}
+	return r, buildPhaseError{phase: phase, class: class, error: err}
+}
+
+// answerStatus returns the HTTP status code of the answer of the fetcher
+// or builder, and false if the request got none.
+func answerStatus(err error) (int, bool) {
+	if err == nil {
+		return http.StatusOK, true
+	}
+	var fe ferror.Error
+	if errors.As(err, &fe) {
+		return fe.HTTPStatus(), true
+	}
+	var mismatch *builderClient.ProtocolMismatchError
+	if errors.As(err, &mismatch) {
+		return mismatch.StatusCode, true
+	}
+	return 0, false
+}
+
+// legacyPackageBuilder adapts a build function returning the upload response
+// and build logs only, the failures of its builds have no phase or class.
+func legacyPackageBuilder(build func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+	storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error)) packageBuilder {
+	return func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+		uploadResp, buildLogs, err := build(ctx, logger, fissionClient, envBuilderNamespace, storageSvcUrl, pkg)
+		result := newPackageBuildResult()
+		result.uploadResp = uploadResp
+		result.logs = buildLogs
+		return result, err
+	}
+}
+
+// buildFailureClass returns the metrics label of the class of a build
+// failure.
+func buildFailureClass(err error) string {
+	switch {
+	case errors.Is(err, ErrBuilderUnreachable):
+		return "builder_unreachable"
+	case errors.Is(err, ErrFetchFailed):
+		return "fetch_failed"
+	case errors.Is(err, ErrBuildFailed):
+		return "build_failed"
+	case errors.Is(err, ErrUploadFailed):
+		return "upload_failed"
+	case errors.Is(err, ErrProtocolMismatch):
+		return "protocol_mismatch"
+	}
+	return "other"
+}
@@ -0,0 +1,172 @@
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	builderClient "github.com/fission/fission/pkg/builder/client"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func TestPackageBuildResultFail(t *testing.T) {
+	unreachable := &url.Error{Op: "Post", URL: "http://builder:8001", Err: errors.New("connection refused")}
+	for _, test := range []struct {
+		name      string
+		phase     string
+		class     error
+		answer    error
+		err       error
+		want      error
+		status    int
+		permanent bool
+	}{
+		{
+			name:   "fetch failed",
+			phase:  logPhaseFetch,
+			class:  ErrFetchFailed,
+			answer: ferror.MakeError(ferror.ErrorNotFound, "archive not found"),
+			err:    errors.New("error fetching source package"),
+			want:   ErrFetchFailed,
+			status: http.StatusNotFound,
+		},
+		{
+			name:      "build failed",
+			phase:     logPhaseBuild,
+			class:     ErrBuildFailed,
+			answer:    ferror.MakeError(ferror.ErrorInternal, "build command failed"),
+			err:       errors.New("error building deployment package"),
+			want:      ErrBuildFailed,
+			status:    http.StatusInternalServerError,
+			permanent: true,
+		},
+		{
+			name:   "builder unreachable",
+			phase:  logPhaseBuild,
+			class:  ErrBuildFailed,
+			answer: unreachable,
+			err:    errors.New("error building deployment package"),
+			want:   ErrBuilderUnreachable,
+		},
+		{
+			name:      "builder timeout",
+			phase:     logPhaseBuild,
+			class:     ErrBuildFailed,
+			answer:    context.Canceled,
+			err:       permanentBuildError{builderTimeoutError{errors.New("build exceeded the builder timeout")}},
+			want:      ErrBuildFailed,
+			permanent: true,
+		},
+		{
+			name:      "upload rejected",
+			phase:     logPhaseUpload,
+			class:     ErrUploadFailed,
+			answer:    ferror.MakeError(ferror.ErrorInvalidArgument, "storage target unknown"),
+			err:       permanentBuildError{errors.New("error uploading deployment package")},
+			want:      ErrUploadFailed,
+			status:    http.StatusBadRequest,
+			permanent: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := newPackageBuildResult()
+			result.observe(test.phase, time.Now(), test.answer)
+			result, err := result.fail(test.phase, test.class, "logs", test.err)
+			if !errors.Is(err, test.want) || isPermanentBuildError(err) != test.permanent {
+				t.Errorf("Expected %v failure, permanent %v, got %v", test.want, test.permanent, err)
+			}
+			if err.Error() != test.err.Error() {
+				t.Errorf("Expected the error of the phase kept, got %q", err.Error())
+			}
+			if result.failedPhase != test.phase || result.statusCodes[test.phase] != test.status || result.logs != "logs" {
+				t.Errorf("Expected failed phase %s with status %d, got %+v", test.phase, test.status, result)
+			}
+			if _, ok := result.durations[test.phase]; !ok {
+				t.Errorf("Expected duration of phase %s, got %v", test.phase, result.durations)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildFailureClasses(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		phase   string
+		class   error
+		reason  string
+		retry   bool
+		builder metav1.ConditionStatus
+	}{
+		{name: "builder unreachable", phase: logPhaseFetch, reason: fv1.PackageReasonBuilderUnreachable, retry: true,
+			builder: metav1.ConditionFalse},
+		{name: "build failed", phase: logPhaseBuild, class: ErrBuildFailed, reason: fv1.PackageReasonBuildFailed,
+			builder: metav1.ConditionTrue},
+		{name: "upload failed", phase: logPhaseUpload, class: ErrUploadFailed, reason: fv1.PackageReasonUploadFailed, retry: true,
+			builder: metav1.ConditionTrue},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			tb.deps.buildPackage = nil
+			tb.deps.runBuild = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+				storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+				result := newPackageBuildResult()
+				var answer error
+				if test.class != nil {
+					answer = ferror.MakeError(ferror.ErrorInternal, "failed")
+				} else {
+					answer = &url.Error{Op: "Post", URL: "http://builder:8000", Err: errors.New("connection refused")}
+				}
+				result.observe(test.phase, time.Now(), answer)
+				return result.fail(test.phase, test.class, logLines(test.phase, "failed"), errors.New(test.phase+" failed"))
+			}
+
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 2})
+			if err == nil || result.Retry != test.retry {
+				t.Fatalf("Expected failed build, retry %v, got %s retry %v: %v", test.retry, result.Status, result.Retry, err)
+			}
+			pkg := tb.getPackage(t)
+			if history := pkg.Status.BuildHistory; len(history) != 1 || history[0].Reason != test.reason {
+				t.Errorf("Expected failed attempt with reason %s, got %+v", test.reason, history)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuilderReady, test.builder, map[metav1.ConditionStatus]string{
+				metav1.ConditionTrue:  fv1.PackageReasonBuilderPodReady,
+				metav1.ConditionFalse: fv1.PackageReasonBuilderUnreachable,
+			}[test.builder])
+		})
+	}
+}
+
+func TestExecuteBuildProtocolMismatch(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.buildPackage = nil
+	tb.deps.runBuild = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+		result := newPackageBuildResult()
+		mismatch := &builderClient.ProtocolMismatchError{Local: 2, Remote: 1, StatusCode: http.StatusOK}
+		result.observe(logPhaseBuild, time.Now(), mismatch)
+		return result.fail(logPhaseBuild, ErrProtocolMismatch, "", mismatch)
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 2})
+	if err == nil || result.Retry {
+		t.Fatalf("Expected failed build without retry, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	pkg := tb.getPackage(t)
+	msg := "buildermgr speaks v2, builder pod " + testNamespace + "/builder-pod speaks v1, roll the environment builder"
+	if !strings.Contains(pkg.Status.BuildLog, msg) {
+		t.Errorf("Expected build logs naming the builder pod and versions, got %q", pkg.Status.BuildLog)
+	}
+	if history := pkg.Status.BuildHistory; len(history) != 1 || history[0].Reason != fv1.PackageReasonBuilderProtocolMismatch {
+		t.Errorf("Expected failed attempt with reason %s, got %+v", fv1.PackageReasonBuilderProtocolMismatch, history)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderProtocolMismatch)
+}
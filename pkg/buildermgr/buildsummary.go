@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// The build summary is a log entry with the message "build_summary" logged
+// once per completed build, whatever its result, for pipelines parsing the
+// builder manager logs instead of scraping its metrics. Its fields are:
+//
+//	schema_version       int     version of the fields, buildSummarySchemaVersion
+//	build_id             string  ID of the last build attempt
+//	package              string  package name
+//	namespace            string  package namespace
+//	environment          string  environment name
+//	environment_namespace string environment namespace
+//	trigger              string  why the build was triggered, see fv1.BuildTrigger
+//	result               string  succeeded, failed, canceled, panicked or dry_run
+//	reason               string  reason of the BuildSucceeded package condition
+//	duration_seconds     float   time since the first attempt started
+//	attempts             int     number of attempts
+//	artifact_size_bytes  int     stored size of the deployment archive, 0 if none
+//
+// Fields may be added within a schema version, renaming, removing or changing
+// the type of one bumps it.
+const (
+	buildSummaryMessage       = "build_summary"
+	buildSummarySchemaVersion = 1
+
+	buildSummaryCanceled = "canceled"
+	buildSummaryPanicked = "panicked"
+	buildSummaryDryRun   = "dry_run"
+)
+
+// buildSummary is the end-of-build summary of a package build.
+type buildSummary struct {
+	result       string
+	reason       string
+	artifactSize int64
+	// logs are the build logs, sent with the build notification
+	logs string
+}
+
+// logBuildSummary logs the summary of the completed build and sends its
+// completion notification.
+func (pkgw *packageWatcher) logBuildSummary(b *pkgBuild, summary buildSummary) {
+	var duration time.Duration
+	if !b.startTime.IsZero() {
+		duration = time.Since(b.startTime)
+	}
+	pkgw.logger.Info(buildSummaryMessage,
+		zap.Int("schema_version", buildSummarySchemaVersion),
+		zap.String("build_id", buildID(b.pkg, b.attempt)),
+		zap.String("package", b.pkg.ObjectMeta.Name),
+		zap.String("namespace", b.pkg.ObjectMeta.Namespace),
+		zap.String("environment", b.pkg.Spec.Environment.Name),
+		zap.String("environment_namespace", b.pkg.Spec.Environment.Namespace),
+		zap.String("trigger", string(b.trigger)),
+		zap.String("result", summary.result),
+		zap.String("reason", summary.reason),
+		zap.Float64("duration_seconds", duration.Seconds()),
+		zap.Int("attempts", b.attempt),
+		zap.Int64("artifact_size_bytes", summary.artifactSize))
+	pkgw.notifier.notify(b, summary, duration)
+}
+
+// resultSummary summarizes a build that ran to its end.
+func resultSummary(result BuildResult) buildSummary {
+	summary := buildSummary{result: string(result.Status), artifactSize: result.ArtifactSize, logs: result.Logs}
+	condType := fv1.PackageConditionBuildSucceeded
+	if result.DryRun {
+		// the reason tells the outcome of the dry run
+		summary.result = buildSummaryDryRun
+		condType = fv1.PackageConditionDryRunSucceeded
+	}
+	if result.Package != nil {
+		if cond := meta.FindStatusCondition(result.Package.Status.Conditions, condType); cond != nil {
+			summary.reason = cond.Reason
+		}
+	}
+	return summary
+}
+
+// canceledSummary summarizes a canceled build.
+func canceledSummary(ctx context.Context) buildSummary {
+	reason := fv1.PackageReasonBuildCanceled
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errShuttingDown):
+		reason = fv1.PackageReasonBuildInterrupted
+	case errors.Is(cause, errBuildSkipped):
+		reason = fv1.PackageReasonBuildSkipped
+	}
+	return buildSummary{result: buildSummaryCanceled, reason: reason}
+}
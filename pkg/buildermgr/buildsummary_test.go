@@ -0,0 +1,163 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// observeBuildSummaries makes the package watcher log to an observer, which
+// is returned.
+func (tpw *testPackageWatcher) observeBuildSummaries() *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.InfoLevel)
+	tpw.logger = zap.New(core)
+	return logs
+}
+
+// buildSummaries returns the fields of the logged build summaries.
+func buildSummaries(logs *observer.ObservedLogs) []map[string]interface{} {
+	var summaries []map[string]interface{}
+	for _, entry := range logs.FilterMessage(buildSummaryMessage).All() {
+		summaries = append(summaries, entry.ContextMap())
+	}
+	return summaries
+}
+
+// TestBuildSummarySchema guards the fields parsed by log pipelines, changing
+// them needs a new buildSummarySchemaVersion.
+func TestBuildSummarySchema(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	logs := tpw.observeBuildSummaries()
+	tpw.addReadyBuilderPod(t)
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy", StoredSize: 42}, "build succeeded\n", nil
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	summaries := buildSummaries(logs)
+	if len(summaries) != 1 {
+		t.Fatalf("Expected one build summary, got %d", len(summaries))
+	}
+	summary := summaries[0]
+	for field, value := range map[string]interface{}{
+		"schema_version":        int64(1),
+		"build_id":              buildID(tpw.pkg, 1),
+		"package":               testPkgName,
+		"namespace":             testNamespace,
+		"environment":           testEnvName,
+		"environment_namespace": testNamespace,
+		"trigger":               string(fv1.BuildTriggerPackageCreated),
+		"result":                string(fv1.BuildStatusSucceeded),
+		"reason":                fv1.PackageReasonBuildSucceeded,
+		"attempts":              int64(1),
+		"artifact_size_bytes":   int64(42),
+	} {
+		got, ok := summary[field]
+		if !ok {
+			t.Errorf("Expected build summary field %s", field)
+			continue
+		}
+		if got != value {
+			t.Errorf("Expected build summary field %s %#v, got %#v", field, value, got)
+		}
+	}
+	if _, ok := summary["duration_seconds"].(float64); !ok {
+		t.Errorf("Expected float build summary field duration_seconds, got %#v", summary["duration_seconds"])
+	}
+	if len(summary) != 12 {
+		t.Errorf("Expected 12 build summary fields, got %v", summary)
+	}
+}
+
+func TestBuildSummaryOnEveryResult(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// run runs a build to its end
+		run    func(t *testing.T, tpw *testPackageWatcher)
+		result string
+		reason string
+		status fv1.BuildStatus
+	}{
+		{
+			name: "failed after retry",
+			run: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.addReadyBuilderPod(t)
+				tpw.maxBuildRetries = 1
+				tpw.buildRetryDelay = 10 * time.Millisecond
+				tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					return nil, "error uploading deployment package\n", errors.New("storage service unavailable")
+				}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+			},
+			result: string(fv1.BuildStatusFailed),
+			reason: fv1.PackageReasonBuildFailed,
+			status: fv1.BuildStatusFailed,
+		},
+		{
+			name: "canceled",
+			run: func(t *testing.T, tpw *testPackageWatcher) {
+				started := make(chan string, 1)
+				tpw.addReadyBuilderPod(t)
+				tpw.deps.buildPackage = blockOnContext(started)
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				<-started
+				if !tpw.CancelBuild(testNamespace, testPkgName) {
+					t.Fatal("Expected the package build to be canceled")
+				}
+			},
+			result: buildSummaryCanceled,
+			reason: fv1.PackageReasonBuildCanceled,
+			status: fv1.BuildStatusCanceled,
+		},
+		{
+			name: "panicked",
+			run: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.addReadyBuilderPod(t)
+				tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					panic("builder response out of range")
+				}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+			},
+			result: buildSummaryPanicked,
+			reason: fv1.PackageReasonBuildFailed,
+			status: fv1.BuildStatusFailed,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tpw := newTestPackageWatcher(t)
+			logs := tpw.observeBuildSummaries()
+			test.run(t, tpw)
+			tpw.waitForBuildsDone(t, 5*time.Second)
+
+			summaries := buildSummaries(logs)
+			if len(summaries) != 1 {
+				t.Fatalf("Expected one build summary, got %d", len(summaries))
+			}
+			if summaries[0]["result"] != test.result || summaries[0]["reason"] != test.reason {
+				t.Errorf("Expected build summary %s/%s, got %s/%s", test.result, test.reason, summaries[0]["result"], summaries[0]["reason"])
+			}
+			pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Error getting package: %v", err)
+			}
+			if pkg.Status.BuildStatus != test.status {
+				t.Errorf("Expected build status %s, got %s", test.status, pkg.Status.BuildStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// eventReasonBuildStarted is the reason of the events of started builds.
+const eventReasonBuildStarted = "BuildStarted"
+
+// pendingBuildTrigger returns why the pending package is built, as recorded
+// when it was marked pending.
+func pendingBuildTrigger(pkg *fv1.Package) fv1.BuildTrigger {
+	if len(pkg.Status.BuildTrigger) == 0 {
+		return fv1.BuildTriggerUnknown
+	}
+	return pkg.Status.BuildTrigger
+}
+
+// newEventRecorder returns the recorder of the package build events.
+func newEventRecorder(k8sClient kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(0)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "buildermgr"})
+}
+
+// recordBuildStarted records the event of the started build attempt on
+// the package.
+func (e *buildExecution) recordBuildStarted(pkg *fv1.Package) {
+	if e.recorder == nil {
+		return
+	}
+	ref := &apiv1.ObjectReference{
+		APIVersion:      fv1.SchemeGroupVersion.String(),
+		Kind:            "Package",
+		Namespace:       pkg.ObjectMeta.Namespace,
+		Name:            pkg.ObjectMeta.Name,
+		UID:             pkg.ObjectMeta.UID,
+		ResourceVersion: pkg.ObjectMeta.ResourceVersion,
+	}
+	e.recorder.Event(ref, apiv1.EventTypeNormal, eventReasonBuildStarted,
+		fmt.Sprintf("Build attempt %d/%d started, triggered by %s", e.opts.Attempt, e.opts.MaxAttempts, e.opts.Trigger))
+}
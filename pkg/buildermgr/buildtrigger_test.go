@@ -0,0 +1,88 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func TestBuildTriggerRecorded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	recorder := record.NewFakeRecorder(10)
+	tpw.deps.recorder = recorder
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	started := func() float64 {
+		return testutil.ToFloat64(buildsStarted.WithLabelValues(testEnvName, testNamespace, string(fv1.BuildTriggerSpecChanged)))
+	}
+	startedBefore := started()
+
+	handler := tpw.packageInformerHandler(ctx)
+	oldPkg := tpw.pkg.DeepCopy()
+	oldPkg.ObjectMeta.Generation = 1
+	oldPkg.Status.BuildStatus = fv1.BuildStatusSucceeded
+	changed := oldPkg.DeepCopy()
+	changed.ObjectMeta.Generation = 2
+	changed.Spec.Source.URL = "http://storagesvc/archive-new"
+	handler.OnUpdate(oldPkg, changed)
+
+	pending, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pending.Status.BuildTrigger != fv1.BuildTriggerSpecChanged {
+		t.Fatalf("Expected pending package triggered by %s, got %q", fv1.BuildTriggerSpecChanged, pending.Status.BuildTrigger)
+	}
+	handler.OnUpdate(changed, pending)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded || pkg.Status.BuildTrigger != fv1.BuildTriggerSpecChanged {
+		t.Errorf("Expected succeeded build triggered by %s, got %s build triggered by %q",
+			fv1.BuildTriggerSpecChanged, pkg.Status.BuildStatus, pkg.Status.BuildTrigger)
+	}
+	if !strings.Contains(pkg.Status.BuildLog, " buildermgr] Build attempt 1/1, triggered by SpecChanged\n") {
+		t.Errorf("Expected the trigger in the build log header, got %q", pkg.Status.BuildLog)
+	}
+	if n := started() - startedBefore; n != 1 {
+		t.Errorf("Expected one build started by %s to be counted, got %v", fv1.BuildTriggerSpecChanged, n)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonBuildStarted) || !strings.Contains(event, "triggered by SpecChanged") {
+			t.Errorf("Expected build started event with the trigger, got %q", event)
+		}
+	default:
+		t.Error("Expected build started event")
+	}
+}
+
+func TestPendingBuildTrigger(t *testing.T) {
+	pkg := testPackage()
+	if trigger := pendingBuildTrigger(pkg); trigger != fv1.BuildTriggerUnknown {
+		t.Errorf("Expected trigger %s of package without trigger, got %s", fv1.BuildTriggerUnknown, trigger)
+	}
+	pkg.Status.BuildTrigger = fv1.BuildTriggerRebuildAnnotation
+	if trigger := pendingBuildTrigger(pkg); trigger != fv1.BuildTriggerRebuildAnnotation {
+		t.Errorf("Expected trigger %s, got %s", fv1.BuildTriggerRebuildAnnotation, trigger)
+	}
+}
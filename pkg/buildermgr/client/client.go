@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/fission/fission/pkg/buildermgr"
+)
+
+type (
+	// Client is a client of the builder manager API.
+	Client struct {
+		url        string
+		httpClient *http.Client
+	}
+)
+
+// MakeClient creates a builder manager API client.
+func MakeClient(url string) *Client {
+	hc := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		httpClient: hc,
+	}
+}
+
+// MigrateLiterals moves the literal archives of packages to the storage
+// service, it returns once all packages have been processed.
+func (c *Client) MigrateLiterals(ctx context.Context, req *buildermgr.LiteralMigrationRequest) (*buildermgr.LiteralMigrationResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ctxhttp.Post(ctx, c.httpClient, c.url+"/v1/packages/migrate-literals", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Literal migration error %v: %v", resp.Status, strings.TrimSpace(string(rBody))))
+	}
+
+	var migrationResp buildermgr.LiteralMigrationResponse
+	err = json.Unmarshal(rBody, &migrationResp)
+	if err != nil {
+		return nil, err
+	}
+	return &migrationResp, nil
+}
+
+// PackageImpact returns the functions a rebuild of the package affects and
+// the triggers of those functions.
+func (c *Client) PackageImpact(ctx context.Context, namespace, name string) (*buildermgr.PackageImpact, error) {
+	resp, err := ctxhttp.Get(ctx, c.httpClient, fmt.Sprintf("%s/v2/packages/%s/%s/impact", c.url, namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Package impact error %v: %v", resp.Status, strings.TrimSpace(string(rBody))))
+	}
+
+	var impact buildermgr.PackageImpact
+	err = json.Unmarshal(rBody, &impact)
+	if err != nil {
+		return nil, err
+	}
+	return &impact, nil
+}
+
+// CancelBuild cancels the in-flight or queued builds of the package, the
+// package goes into canceled state once they stopped.
+func (c *Client) CancelBuild(ctx context.Context, namespace, name string) error {
+	resp, err := ctxhttp.Post(ctx, c.httpClient, fmt.Sprintf("%s/v2/packages/%s/%s/cancel", c.url, namespace, name), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		rBody, _ := io.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Build cancel error %v: %v", resp.Status, strings.TrimSpace(string(rBody))))
+	}
+	return nil
+}
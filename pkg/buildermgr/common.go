@@ -17,63 +17,162 @@ limitations under the License.
 package buildermgr
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dchest/uniuri"
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
+	"golang.org/x/net/context/ctxhttp"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/builder"
 	builderClient "github.com/fission/fission/pkg/builder/client"
+	"github.com/fission/fission/pkg/crd"
 	ferror "github.com/fission/fission/pkg/error"
 	"github.com/fission/fission/pkg/fetcher"
 	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
 	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/storagesvc"
+	"github.com/fission/fission/pkg/utils"
 )
 
+// permanentBuildError marks a build failure that retrying can't fix,
+// e.g. a missing environment or a failing build command.
+type permanentBuildError struct {
+	error
+}
+
+func (e permanentBuildError) Unwrap() error {
+	return e.error
+}
+
+func isPermanentBuildError(err error) bool {
+	var e permanentBuildError
+	return errors.As(err, &e) || isSourceFetchError(err) || errors.Is(err, ErrBuildFailed) || errors.Is(err, ErrProtocolMismatch)
+}
+
+// storageTargetMappingPath is the directory of the mounted ConfigMap mapping
+// namespaces to the storage target of their packages, a file per namespace.
+var storageTargetMappingPath = "/etc/fission/storage-target-mapping"
+
+// reasonArtifactUnavailable is reported for builds whose deployment archive
+// can't be downloaded after the upload.
+const reasonArtifactUnavailable = "ArtifactUnavailable"
+
+// archiveCheckClient is the http client of the deployment archive checks.
+var archiveCheckClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// builderDiskWarningPercent is the builder shared volume usage above which
+// a warning is added to the build logs.
+const builderDiskWarningPercent = 90
+
 // buildPackage helps to build source package into deployment package.
 // Following is the steps buildPackage function takes to complete the whole process.
 // 1. Send fetch request to fetcher to fetch source package.
 // 2. Send build request to builder to start a build.
 // 3. Send upload request to fetcher to upload deployment package.
-// 4. Return upload response and build logs.
-// *. Return build logs and error if any one of steps above failed.
+// 4. Return the build result with the upload response and build logs.
+// *. Return the build result and the error of the failed phase if any one of steps above failed.
 func buildPackage(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
-	storageSvcUrl string, pkg *fv1.Package) (uploadResp *fetcher.ArchiveUploadResponse, buildLogs string, err error) {
+	storageSvcUrl string, pkg *fv1.Package) (*packageBuildResult, error) {
+	result := newPackageBuildResult()
 
 	env, err := fissionClient.CoreV1().Environments(pkg.Spec.Environment.Namespace).Get(ctx, pkg.Spec.Environment.Name, metav1.GetOptions{})
 	if err != nil {
 		e := "error getting environment CRD info"
 		logger.Error(e, zap.Error(err))
 		e = fmt.Sprintf("%s: %v", e, err)
-		return nil, e, ferror.MakeError(http.StatusInternalServerError, e)
+		if k8serrors.IsNotFound(err) {
+			return result.fail(logPhaseBuildermgr, nil, logLines(logPhaseBuildermgr, e), permanentBuildError{ferror.MakeError(http.StatusNotFound, e)})
+		}
+		return result.fail(logPhaseBuildermgr, nil, logLines(logPhaseBuildermgr, e), ferror.MakeError(http.StatusInternalServerError, e))
+	}
+
+	if size := len(pkg.Spec.Source.Literal); int64(size) > fv1.ArchiveLiteralSizeLimit {
+		e := fmt.Sprintf("literal source archive of %s exceeds the %s limit of literal archives, "+
+			"upload the source archive to the storage service instead", humanize.Bytes(uint64(size)),
+			humanize.Bytes(uint64(fv1.ArchiveLiteralSizeLimit)))
+		logger.Error(e, zap.String("package_name", pkg.ObjectMeta.Name))
+		return result.fail(logPhaseBuildermgr, nil, logLines(logPhaseBuildermgr, e), permanentBuildError{ferror.MakeError(http.StatusBadRequest, e)})
 	}
 
-	svcName := fmt.Sprintf("%v-%v.%v", env.ObjectMeta.Name, env.ObjectMeta.ResourceVersion, envBuilderNamespace)
+	svcName := builderAddress(ctx, env, envBuilderNamespace)
 	srcPkgFilename := fmt.Sprintf("%v-%v", pkg.ObjectMeta.Name, strings.ToLower(uniuri.NewLen(6)))
-	fetcherC := fetcherClient.MakeClient(logger, fmt.Sprintf("http://%v:8000", svcName))
-	builderC := builderClient.MakeClient(logger, fmt.Sprintf("http://%v:8001", svcName))
+	token := contextBuilderAuthToken(ctx)
+	fetcherC := fetcherClient.MakeClient(logger, fmt.Sprintf("http://%v:8000", svcName)).WithAuthToken(token)
+	builderC := builderClient.MakeClient(logger, fmt.Sprintf("http://%v:8001", svcName)).WithAuthToken(token)
 
 	fetchReq := &fetcher.FunctionFetchRequest{
 		FetchType:   fv1.FETCH_SOURCE,
 		Package:     pkg.ObjectMeta,
 		Filename:    srcPkgFilename,
 		KeepArchive: false,
+		// the builder builds the literal of this package version
+		SourceLiteral: pkg.Spec.Source.Literal,
 	}
 
 	// send fetch request to fetcher
-	err = fetcherC.Fetch(ctx, fetchReq)
+	start := time.Now()
+	fetchResp, err := fetcherC.Fetch(ctx, fetchReq)
+	result.observe(logPhaseFetch, start, err)
+	result.observeSourceFetch(pkg, start, fetchResp, err)
+	var fetchLogs string
+	var failure *fetcherClient.FetchFailureError
+	if errors.As(err, &failure) {
+		// the source may be fine and only the pod fetched it wrong,
+		// retry once with a clean workspace, likely on another pod
+		reportSourceFetchFailure(ctx, failure.Pod)
+		logger.Warn("builder pod failed to fetch source package, retrying with a clean workspace",
+			zap.String("pod", failure.Pod), zap.Error(err))
+		fetchLogs = logf(logPhaseFetch, "Source fetch failed on builder pod %s, retrying with a clean workspace: %v", failure.Pod, err)
+		srcPkgFilename = fmt.Sprintf("%v-%v", pkg.ObjectMeta.Name, strings.ToLower(uniuri.NewLen(6)))
+		fetchReq.Filename = srcPkgFilename
+		fetchReq.CleanWorkspace = true
+		start = time.Now()
+		fetchResp, err = fetcherC.Fetch(ctx, fetchReq)
+		result.observe(logPhaseFetch, start, err)
+		result.observeSourceFetch(pkg, start, fetchResp, err)
+		if errors.As(err, &failure) {
+			reportSourceFetchFailure(ctx, failure.Pod)
+			e := fmt.Sprintf("%s: error fetching source package on builder pod %s: %v",
+				fv1.PackageReasonSourceFetchFailed, failure.Pod, err)
+			logger.Error(e)
+			return result.fail(logPhaseFetch, ErrFetchFailed, fetchLogs+logLines(logPhaseFetch, e),
+				sourceFetchError{ferror.MakeError(http.StatusInternalServerError, e)})
+		}
+	}
+	var sourceFailure *fetcherClient.SourceFailureError
+	if errors.As(err, &sourceFailure) {
+		// e.g. an unreachable git repository or a missing ref, retrying
+		// the build won't help
+		e := fmt.Sprintf("%s: error fetching source package: %v", fv1.PackageReasonSourceFetchFailed, err)
+		logger.Error(e)
+		return result.fail(logPhaseFetch, ErrFetchFailed, fetchLogs+logLines(logPhaseFetch, e),
+			sourceFetchError{ferror.MakeError(http.StatusBadRequest, e)})
+	}
 	if err != nil {
 		e := "error fetching source package"
 		logger.Error(e, zap.Error(err))
 		e = fmt.Sprintf("%s: %v", e, err)
-		return nil, e, ferror.MakeError(http.StatusInternalServerError, e)
+		return result.fail(logPhaseFetch, ErrFetchFailed, fetchLogs+logLines(logPhaseFetch, e),
+			ferror.MakeError(http.StatusInternalServerError, e))
+	}
+	if len(fetchResp.SourceCommit) > 0 {
+		reportSourceCommit(ctx, fetchResp.SourceCommit)
+		fetchLogs += logf(logPhaseFetch, "Fetched source from git commit %s", fetchResp.SourceCommit)
 	}
 
 	buildCmd := pkg.Spec.BuildCommand
@@ -83,20 +182,61 @@ func buildPackage(ctx context.Context, logger *zap.Logger, fissionClient version
 
 	pkgBuildReq := &builder.PackageBuildRequest{
 		SrcPkgFilename: srcPkgFilename,
+		BuildID:        fmt.Sprintf("%v-%v-%v", pkg.ObjectMeta.Namespace, pkg.ObjectMeta.Name, pkg.ObjectMeta.ResourceVersion),
 		BuildCommand:   buildCmd,
+		Metadata:       contextBuildMetadata(ctx),
 	}
 
 	logger.Info("started building with source package", zap.String("source_package", srcPkgFilename))
 	// send build request to builder
-	buildResp, err := builderC.Build(ctx, pkgBuildReq)
+	start = time.Now()
+	buildCtx, cancelBuild := builderRequestContext(ctx, env)
+	buildResp, err := builderC.Build(buildCtx, pkgBuildReq)
+	timedOut := builderTimedOut(buildCtx)
+	cancelBuild()
+	result.observe(logPhaseBuild, start, err)
+	if buildResp != nil {
+		buildResp.BuildLogs = fetchLogs + logLines(logPhaseBuild, buildResp.BuildLogs)
+	}
+	if buildResp != nil && buildResp.ResourceUsage != nil {
+		reportBuildResourceUsage(ctx, &fv1.BuildResourceUsage{
+			CPUMilliseconds: buildResp.ResourceUsage.CPUMilliseconds,
+			PeakMemoryBytes: buildResp.ResourceUsage.PeakMemoryBytes,
+		})
+	}
 	if err != nil {
 		e := fmt.Sprintf("Error building deployment package: %v", err)
-		var buildLogs string
+		buildLogs := fetchLogs
 		if buildResp != nil {
 			buildLogs = buildResp.BuildLogs
 		}
-		buildLogs += fmt.Sprintf("%v\n", e)
-		return nil, buildLogs, ferror.MakeError(http.StatusInternalServerError, e)
+		var mismatch *builderClient.ProtocolMismatchError
+		if errors.As(err, &mismatch) {
+			// the builder manager names the builder pod to roll
+			logger.Error("builder protocol mismatch", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name))
+			return result.fail(logPhaseBuild, ErrProtocolMismatch, buildLogs, err)
+		}
+		if timedOut {
+			// the build command would run as long again, the limit
+			// is named to tell whether to raise it or speed up the build
+			e = fmt.Sprintf("%s: build exceeded the builder timeout of %v set by spec.builder.buildTimeout of environment %s/%s",
+				fv1.PackageReasonBuildTimeout, builderTimeout(env), env.ObjectMeta.Namespace, env.ObjectMeta.Name)
+			logger.Error(e, zap.String("package_name", pkg.ObjectMeta.Name))
+			buildLogs += logLines(logPhaseBuild, e)
+			return result.fail(logPhaseBuild, ErrBuildFailed, buildLogs,
+				permanentBuildError{builderTimeoutError{ferror.MakeError(http.StatusGatewayTimeout, e)}})
+		}
+		buildLogs += logLines(logPhaseBuild, e)
+		buildLogs += builderDiskWarning(ctx, logger, builderC, env)
+		// the builder ran the build command and it failed, building the
+		// same source again won't help. Builders that didn't answer are
+		// unreachable, it's worth another attempt.
+		return result.fail(logPhaseBuild, ErrBuildFailed, buildLogs, ferror.MakeError(http.StatusInternalServerError, e))
+	}
+	buildResp.BuildLogs += builderDiskWarning(ctx, logger, builderC, env)
+	if v := buildResp.Version(); v < builder.ProtocolVersion {
+		buildResp.BuildLogs += logf(logPhaseBuild, "Warning: builder speaks protocol v%d, buildermgr speaks v%d, roll the environment builder to update it",
+			v, builder.ProtocolVersion)
 	}
 
 	logger.Info("build succeed", zap.String("source_package", srcPkgFilename), zap.String("deployment_package", buildResp.ArtifactFilename))
@@ -108,41 +248,356 @@ func buildPackage(ctx context.Context, logger *zap.Logger, fissionClient version
 		StorageSvcUrl:  storageSvcUrl,
 		ArchivePackage: archivePackage,
 	}
+	setArchiveUploadOptions(logger, env, uploadReq)
+	uploadReq.StorageTarget = storageTargetFor(logger, pkg)
+	uploadReq.MaxSize = contextArchiveSizeLimits(ctx).Deployment
+	if sum := contextCurrentDeployment(ctx); sum != nil {
+		// identical builds must produce identical archives to be
+		// recognized
+		uploadReq.Reproducible = true
+		uploadReq.SkipIfChecksum = sum
+	}
 
 	logger.Info("started uploading deployment package", zap.String("deployment_package", buildResp.ArtifactFilename))
+	reportBuildPhase(ctx, fv1.BuildPhaseUploading)
 	// ask fetcher to upload the deployment package
-	uploadResp, err = fetcherC.Upload(ctx, uploadReq)
+	start = time.Now()
+	uploadResp, attempts, err := uploadDeploymentArchive(ctx, logger, fetcherC, uploadReq)
+	result.observe(logPhaseUpload, start, err)
+	result.observeDeploymentUpload(pkg, start, uploadResp, err)
 	if err != nil {
 		e := fmt.Sprintf("Error uploading deployment package: %v", err)
-		buildResp.BuildLogs += fmt.Sprintf("%v\n", e)
-		return nil, buildResp.BuildLogs, ferror.MakeError(http.StatusInternalServerError, e)
+		if attempts > 1 {
+			e = fmt.Sprintf("Error uploading deployment package after %d attempts: %v", attempts, err)
+		}
+		if strings.Contains(err.Error(), storagesvc.ReasonStorageTargetUnknown) {
+			// retrying won't help until the target is configured
+			e = fmt.Sprintf("%s: storage target %q selected for the package is not configured in storagesvc",
+				storagesvc.ReasonStorageTargetUnknown, uploadReq.StorageTarget)
+			return result.fail(logPhaseUpload, ErrUploadFailed, buildResp.BuildLogs+logLines(logPhaseUpload, e),
+				permanentBuildError{ferror.MakeError(http.StatusBadRequest, e)})
+		}
+		var failure *fetcherClient.UploadFailureError
+		if errors.As(err, &failure) && failure.Failure == fetcher.UploadFailureTooLarge {
+			// the fetcher checks the archive before uploading it
+			e = fmt.Sprintf("%s: %v", fv1.PackageReasonArchiveTooLarge, failure.Err)
+			return result.fail(logPhaseUpload, ErrUploadFailed, buildResp.BuildLogs+logLines(logPhaseUpload, e),
+				permanentBuildError{archiveTooLargeError{ferror.MakeError(http.StatusBadRequest, e)}})
+		}
+		buildLogs := buildResp.BuildLogs + logLines(logPhaseUpload, e)
+		if errors.As(err, &failure) && !failure.Retryable() {
+			// the storage service rejected the upload, it rejects
+			// the archive of a rebuild too
+			return result.fail(logPhaseUpload, ErrUploadFailed, buildLogs, permanentBuildError{ferror.MakeError(http.StatusBadRequest, e)})
+		}
+		return result.fail(logPhaseUpload, ErrUploadFailed, buildLogs, ferror.MakeError(http.StatusInternalServerError, e))
+	}
+	if attempts > 1 {
+		buildResp.BuildLogs += logf(logPhaseUpload, "Uploaded deployment archive after %d attempts, the storage service was unavailable", attempts)
+	}
+
+	if uploadResp.Unchanged {
+		logger.Info("deployment package unchanged, skipped upload",
+			zap.String("deployment_package", buildResp.ArtifactFilename),
+			zap.String("checksum", uploadResp.Checksum.Sum))
+		buildResp.BuildLogs += logf(logPhaseUpload, "artifact unchanged, reusing existing deployment archive")
+	}
+
+	if uploadResp.Compressed && uploadResp.OriginalSize > 0 {
+		saved := uploadResp.OriginalSize - uploadResp.StoredSize
+		logger.Info("compressed deployment package",
+			zap.String("deployment_package", buildResp.ArtifactFilename),
+			zap.Int64("original_size", uploadResp.OriginalSize),
+			zap.Int64("stored_size", uploadResp.StoredSize),
+			zap.Int64("saved_bytes", saved))
+		buildResp.BuildLogs += logf(logPhaseUpload, "Compressed deployment archive from %d to %d bytes, saved %.1f%%",
+			uploadResp.OriginalSize, uploadResp.StoredSize, float64(saved)*100/float64(uploadResp.OriginalSize))
+	}
+
+	result.uploadResp = uploadResp
+	result.logs = buildResp.BuildLogs
+	return result, nil
+}
+
+// setArchiveUploadOptions sets the compression and content type of the
+// deployment archive from the environment annotations. Archives are
+// compressed when it saves space unless the environment says otherwise.
+func setArchiveUploadOptions(logger *zap.Logger, env *fv1.Environment, uploadReq *fetcher.ArchiveUploadRequest) {
+	uploadReq.Compression = storagesvc.CompressionAuto
+	if v, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_ARCHIVE_COMPRESSION]; ok {
+		switch v {
+		case storagesvc.CompressionAuto, storagesvc.CompressionAlways, storagesvc.CompressionNever:
+			uploadReq.Compression = v
+		default:
+			logger.Warn("invalid archive compression annotation, using default",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("value", v))
+		}
 	}
+	if v, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_ARCHIVE_COMPRESSION_LEVEL]; ok {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < gzip.BestSpeed || level > gzip.BestCompression {
+			logger.Warn("invalid archive compression level annotation, using default",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("value", v))
+		} else {
+			uploadReq.CompressionLevel = level
+		}
+	}
+	if v, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_ARCHIVE_CONTENT_TYPE]; ok {
+		if _, ok := storagesvc.ContentTypeSuffix(v); ok {
+			uploadReq.ContentType = v
+		} else {
+			logger.Warn("unsupported archive content type annotation, ignoring",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("value", v))
+		}
+	}
+}
+
+// archiveVerificationError is a stored deployment archive that isn't the
+// uploaded one, e.g. truncated by the storage backend.
+type archiveVerificationError struct {
+	msg string
+}
+
+func (e *archiveVerificationError) Error() string {
+	return "deployment archive verification failed: " + e.msg
+}
 
-	return uploadResp, buildResp.BuildLogs, nil
+// checkArchiveFetchable makes sure the uploaded deployment archive can be
+// downloaded from its URL, the one fetchers use. The storage service reads
+// the archive to report its checksum and size, which must match the
+// uploaded ones. Storage services not reporting them only prove the archive
+// exists.
+func checkArchiveFetchable(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+	return checkStoredArchive(ctx, uploadResp, false)
 }
 
+// verifyStoredArchive is checkArchiveFetchable failing the archives whose
+// checksum the storage service doesn't report.
+func verifyStoredArchive(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+	return checkStoredArchive(ctx, uploadResp, true)
+}
+
+// checkStoredArchive checks the deployment archive stored by the storage
+// service against the uploaded one, a missing checksum fails it when
+// required. A verification failure is permanent, the archive may just not
+// be replicated yet otherwise.
+func checkStoredArchive(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse, requireChecksum bool) error {
+	req, err := http.NewRequest(http.MethodHead, uploadResp.ArchiveDownloadUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(storagesvc.HeaderChecksumRequest, storagesvc.ChecksumSHA256)
+	resp, err := ctxhttp.Do(ctx, archiveCheckClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("HTTP error %v", resp.Status)
+	}
+	sum := resp.Header.Get(storagesvc.HeaderChecksumSHA256)
+	switch {
+	case len(sum) == 0 && requireChecksum:
+		return permanentBuildError{&archiveVerificationError{"storage service reported no checksum"}}
+	case len(sum) > 0 && len(uploadResp.Checksum.Sum) > 0 && sum != uploadResp.Checksum.Sum:
+		return permanentBuildError{&archiveVerificationError{
+			fmt.Sprintf("checksum mismatch, got %s, want %s", sum, uploadResp.Checksum.Sum)}}
+	}
+	if size := resp.Header.Get(storagesvc.HeaderSize); len(size) > 0 && uploadResp.OriginalSize > 0 {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return permanentBuildError{&archiveVerificationError{fmt.Sprintf("invalid size %q", size)}}
+		}
+		if n != uploadResp.OriginalSize {
+			return permanentBuildError{&archiveVerificationError{
+				fmt.Sprintf("size mismatch, got %d bytes, want %d", n, uploadResp.OriginalSize)}}
+		}
+	}
+	return nil
+}
+
+// sourceChecksumError is a source archive not matching its declared checksum.
+type sourceChecksumError struct {
+	got, want string
+}
+
+func (e *sourceChecksumError) Error() string {
+	return fmt.Sprintf("source archive checksum mismatch, got %s, want %s", e.got, e.want)
+}
+
+// verifySourceChecksum streams the source archive from its URL to compute
+// its checksum and compares it to the declared one.
+func verifySourceChecksum(ctx context.Context, archive fv1.Archive) error {
+	resp, err := ctxhttp.Get(ctx, archiveCheckClient, archive.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("HTTP error %v", resp.Status)
+	}
+	sum, err := utils.GetChecksum(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "error reading source archive")
+	}
+	if sum.Sum != archive.Checksum.Sum {
+		return &sourceChecksumError{got: sum.Sum, want: archive.Checksum.Sum}
+	}
+	return nil
+}
+
+// storageTargetFor returns the storage target of the package deployment
+// archive, set by the package annotation or else by the mapping of its
+// namespace. Empty means the storagesvc default storage.
+func storageTargetFor(logger *zap.Logger, pkg *fv1.Package) string {
+	if target, ok := pkg.ObjectMeta.Annotations[fv1.ANNOTATION_STORAGE_TARGET]; ok {
+		return strings.TrimSpace(target)
+	}
+	content, err := os.ReadFile(filepath.Join(storageTargetMappingPath, pkg.ObjectMeta.Namespace))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("error reading storage target mapping, using default storage",
+				zap.String("namespace", pkg.ObjectMeta.Namespace),
+				zap.Error(err))
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// protocolMismatchMessage tells which builder pod, given as namespace/name,
+// speaks another build protocol version. Rolling the environment builder
+// replaces it.
+func protocolMismatchMessage(mismatch *builderClient.ProtocolMismatchError, pod string) string {
+	if len(pod) == 0 {
+		pod = "of the environment"
+	}
+	msg := fmt.Sprintf("%s: buildermgr speaks v%d, builder pod %s speaks v%d, roll the environment builder",
+		fv1.PackageReasonBuilderProtocolMismatch, mismatch.Local, pod, mismatch.Remote)
+	if mismatch.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, mismatch.Err)
+	}
+	return msg
+}
+
+// builderDiskWarning records the disk usage of the builder shared volume
+// and returns a warning line for the build logs if it is nearly full.
+func builderDiskWarning(ctx context.Context, logger *zap.Logger, builderC *builderClient.Client, env *fv1.Environment) string {
+	status, err := builderC.Status(ctx)
+	if err != nil {
+		// older builder images don't serve the status endpoint
+		logger.Debug("error getting builder status", zap.Error(err))
+		return ""
+	}
+	if status.DiskUsage == nil {
+		return ""
+	}
+	builderDiskUsage.WithLabelValues(env.ObjectMeta.Name, env.ObjectMeta.Namespace).Set(status.DiskUsage.UsedPercent)
+	if status.DiskUsage.UsedPercent < builderDiskWarningPercent {
+		return ""
+	}
+	return logf(logPhaseBuildermgr, "Warning: builder disk %.0f%% full", status.DiskUsage.UsedPercent)
+}
+
+// deploymentArchive returns the deployment archive of the upload. Compressed
+// archives are marked with their encoding, along with the checksum and size
+// of the stored archive, for fetchers to decompress them whatever the
+// storage names them. Uploads of fetchers predating compression checksums
+// are left unmarked, the storage service decompresses them on download.
+func deploymentArchive(uploadResp *fetcher.ArchiveUploadResponse) fv1.Archive {
+	archive := fv1.Archive{
+		Type:     fv1.ArchiveTypeUrl,
+		URL:      uploadResp.ArchiveDownloadUrl,
+		Checksum: uploadResp.Checksum,
+		Size:     uploadResp.OriginalSize,
+	}
+	if uploadResp.Compressed && uploadResp.StoredChecksum != nil {
+		archive.Encoding = fv1.ArchiveEncodingGzip
+		archive.StoredChecksum = *uploadResp.StoredChecksum
+		archive.StoredSize = uploadResp.StoredSize
+	}
+	return archive
+}
+
+// updatePackage sets the package status and, given an upload response,
+// the deployment archive of the package. The build start and completion
+// times the status doesn't set are kept, along with their attempts and
+// duration, so that the numbers of the last build stay inspectable. On
+// conflicts the latest package is read again and updated again, unless
+// its build inputs changed meanwhile: the archive isn't the build of the
+// latest package then.
 func updatePackage(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface,
-	pkg *fv1.Package, status fv1.BuildStatus, buildLogs string,
-	uploadResp *fetcher.ArchiveUploadResponse) (*fv1.Package, error) {
+	pkg *fv1.Package, status fv1.PackageStatus, uploadResp *fetcher.ArchiveUploadResponse) (*fv1.Package, error) {
+	if status.BuildStartTime == nil {
+		status.BuildStartTime = pkg.Status.BuildStartTime
+		status.BuildAttempts = pkg.Status.BuildAttempts
+	}
+	if status.BuildCompletionTime == nil {
+		status.BuildCompletionTime = pkg.Status.BuildCompletionTime
+		status.BuildDurationSeconds = pkg.Status.BuildDurationSeconds
+	}
 
-	pkg.Status = fv1.PackageStatus{
-		BuildStatus:         status,
-		BuildLog:            buildLogs,
-		LastUpdateTimestamp: metav1.Time{Time: time.Now().UTC()},
+	packages := fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace)
+	built := pkg
+	// refresh reads the latest package after a conflict
+	refresh := func() error {
+		latest, err := packages.Get(ctx, pkg.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.ObjectMeta.UID != built.ObjectMeta.UID || buildInputsChanged(built, latest) {
+			return errors.New("package was changed during the build, its build result is out of date")
+		}
+		pkg = latest
+		return nil
 	}
 
 	if uploadResp != nil {
-		pkg.Spec.Deployment = fv1.Archive{
-			Type:     fv1.ArchiveTypeUrl,
-			URL:      uploadResp.ArchiveDownloadUrl,
-			Checksum: uploadResp.Checksum,
+		deployment := deploymentArchive(uploadResp)
+		// update package spec, the status is written separately
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pkg = pkg.DeepCopy()
+			pkg.Spec.Deployment = deployment
+			updated, err := packages.Update(ctx, pkg, metav1.UpdateOptions{})
+			if err == nil {
+				pkg = updated
+				return nil
+			}
+			if k8serrors.IsConflict(err) {
+				if refreshErr := refresh(); refreshErr != nil {
+					return refreshErr
+				}
+			}
+			return err
+		})
+		if err != nil {
+			e := "error updating package"
+			logger.Error(e, zap.Error(err))
+			return nil, errors.Wrap(err, e)
 		}
 	}
 
-	// update package spec
-	pkg, err := fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pkg = pkg.DeepCopy()
+		status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+		pkg.Status = status
+		updated, err := crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+		if err == nil {
+			pkg = updated
+			return nil
+		}
+		if k8serrors.IsConflict(err) {
+			if refreshErr := refresh(); refreshErr != nil {
+				return refreshErr
+			}
+		}
+		return err
+	})
 	if err != nil {
-		e := "error updating package"
+		e := "error updating package status"
 		logger.Error(e, zap.Error(err))
 		return nil, errors.Wrap(err, e)
 	}
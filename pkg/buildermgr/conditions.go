@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// startConditions resets the package conditions for a new build attempt.
+func (e *buildExecution) startConditions(pkg *fv1.Package) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.generation = pkg.ObjectMeta.Generation
+	e.conditions = copyConditions(pkg.Status.Conditions)
+	e.setConditionLocked(fv1.PackageConditionBuilderReady, metav1.ConditionUnknown,
+		fv1.PackageReasonWaitingForBuilder, "waiting for the environment builder")
+	e.setConditionLocked(fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown,
+		fv1.PackageReasonBuildRunning, "package build is running")
+	e.setConditionLocked(fv1.PackageConditionFunctionsUpdated, metav1.ConditionUnknown,
+		fv1.PackageReasonBuildRunning, "functions are updated once the build succeeded")
+}
+
+// setCondition records the package condition, it's written with the next
+// package status update.
+func (e *buildExecution) setCondition(condType string, status metav1.ConditionStatus, reason, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setConditionLocked(condType, status, reason, message)
+}
+
+// setConditionLocked is setCondition with e.mu held.
+func (e *buildExecution) setConditionLocked(condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&e.conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: e.generation,
+	})
+}
+
+// syncBuildCondition keeps the BuildSucceeded condition in sync with the
+// legacy build status, in case the build didn't tell a more specific reason.
+// e.mu must be held.
+func (e *buildExecution) syncBuildCondition(status fv1.BuildStatus) {
+	condStatus, reason, message := metav1.ConditionUnknown, fv1.PackageReasonBuildRunning, "package build is running"
+	switch status {
+	case fv1.BuildStatusSucceeded:
+		condStatus, reason, message = metav1.ConditionTrue, fv1.PackageReasonBuildSucceeded, "package build succeeded"
+	case fv1.BuildStatusFailed:
+		condStatus, reason, message = metav1.ConditionFalse, fv1.PackageReasonBuildFailed, "package build failed"
+	}
+	if !meta.IsStatusConditionPresentAndEqual(e.conditions, fv1.PackageConditionBuildSucceeded, condStatus) {
+		e.setConditionLocked(fv1.PackageConditionBuildSucceeded, condStatus, reason, message)
+	}
+	if status != fv1.BuildStatusFailed {
+		return
+	}
+	// the steps the failed build didn't get to are not going to happen
+	cond := meta.FindStatusCondition(e.conditions, fv1.PackageConditionBuildSucceeded)
+	if meta.IsStatusConditionPresentAndEqual(e.conditions, fv1.PackageConditionBuilderReady, metav1.ConditionUnknown) {
+		e.setConditionLocked(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, cond.Reason,
+			"environment builder was not ready before the package build failed")
+	}
+	if meta.IsStatusConditionPresentAndEqual(e.conditions, fv1.PackageConditionFunctionsUpdated, metav1.ConditionUnknown) {
+		e.setConditionLocked(fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, cond.Reason,
+			"functions keep the previous build, the package build failed")
+	}
+}
+
+// copyConditions returns a deep copy of the conditions.
+func copyConditions(conditions []metav1.Condition) []metav1.Condition {
+	if conditions == nil {
+		return nil
+	}
+	copied := make([]metav1.Condition, len(conditions))
+	for i := range conditions {
+		conditions[i].DeepCopyInto(&copied[i])
+	}
+	return copied
+}
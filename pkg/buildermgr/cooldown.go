@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	listersv1 "github.com/fission/fission/pkg/generated/listers/core/v1"
+)
+
+// defaultRebuildCooldown is the default time after a finished build during
+// which rebuilds of the same source are delayed.
+const defaultRebuildCooldown = 30 * time.Second
+
+// rebuildCooldown delays the rebuilds of packages whose last build finished
+// less than period ago with the same source archive, so that controllers
+// fighting over a package don't have it rebuilt over and over. Forced
+// rebuilds, e.g. requested with the rebuild annotation, aren't delayed.
+type rebuildCooldown struct {
+	period time.Duration
+
+	mu sync.Mutex
+	// delayed are the packages, by namespace/name, whose build waits for
+	// the end of their cooldown.
+	delayed map[string]bool
+}
+
+func newRebuildCooldown(period time.Duration) *rebuildCooldown {
+	return &rebuildCooldown{period: period, delayed: make(map[string]bool)}
+}
+
+// remaining returns how long the build of the package waits for the end of
+// its cooldown, zero if it's built right away. Packages whose source
+// checksum is unknown aren't delayed, their source may have changed.
+func (c *rebuildCooldown) remaining(pkg *fv1.Package, trigger fv1.BuildTrigger, now time.Time) time.Duration {
+	if c == nil || c.period <= 0 || forcedBuildTrigger(trigger) ||
+		pkg.Status.BuildCompletionTime == nil || pkg.Status.BuiltSource == nil {
+		return 0
+	}
+	sum, ok := sourceChecksum(pkg.Spec.Source)
+	if !ok || sum != pkg.Status.BuiltSource.Checksum {
+		return 0
+	}
+	remaining := pkg.Status.BuildCompletionTime.Add(c.period).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// delay records that the build of the package waits for the end of its
+// cooldown. It returns false if it already does.
+func (c *rebuildCooldown) delay(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.delayed[key] {
+		return false
+	}
+	c.delayed[key] = true
+	return true
+}
+
+// done records the end of the cooldown of the package.
+func (c *rebuildCooldown) done(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.delayed, key)
+}
+
+// delayRebuild delays the build of the package within its rebuild
+// cooldown, the package is processed again from the informer cache once
+// the cooldown is over. It returns false if the package is built right
+// away.
+func (pkgw *packageWatcher) delayRebuild(ctx context.Context, pkg *fv1.Package, trigger fv1.BuildTrigger) bool {
+	delay := pkgw.rebuildCooldown.remaining(pkg, trigger, time.Now())
+	if delay <= 0 {
+		return false
+	}
+	observeBuildSuppressed(pkg)
+	namespace, name := pkg.ObjectMeta.Namespace, pkg.ObjectMeta.Name
+	key := namespace + "/" + name
+	if !pkgw.rebuildCooldown.delay(key) {
+		return true
+	}
+	pkgw.logger.Info("delaying rebuild of unchanged package source within its rebuild cooldown",
+		zap.String("package_name", name),
+		zap.String("namespace", namespace),
+		zap.String("trigger", string(trigger)),
+		zap.Duration("delay", delay))
+	time.AfterFunc(delay, func() {
+		pkgw.rebuildCooldown.done(key)
+		informer, ok := pkgw.pkgInformer[namespace]
+		if ctx.Err() != nil || !ok {
+			return
+		}
+		latest, err := listersv1.NewPackageLister(informer.GetIndexer()).Packages(namespace).Get(name)
+		if err != nil {
+			// deleted packages aren't built
+			return
+		}
+		pkgw.processPkg(ctx, latest.DeepCopy())
+	})
+	return true
+}
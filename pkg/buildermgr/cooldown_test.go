@@ -0,0 +1,122 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// builtPackage returns a pending package whose last build of the same
+// source finished at completion.
+func builtPackage(completion time.Time, trigger fv1.BuildTrigger) *fv1.Package {
+	pkg := testPackage()
+	pkg.Spec.Source.Checksum = fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc"}
+	pkg.Status.BuildTrigger = trigger
+	pkg.Status.BuildCompletionTime = &metav1.Time{Time: completion}
+	pkg.Status.BuiltSource = &fv1.BuiltSource{Checksum: pkg.Spec.Source.Checksum, BuilderImage: "builder-image"}
+	return pkg
+}
+
+func TestRebuildCooldownRemaining(t *testing.T) {
+	now := time.Now()
+	cooldown := newRebuildCooldown(30 * time.Second)
+	for _, test := range []struct {
+		name     string
+		pkg      *fv1.Package
+		cooldown *rebuildCooldown
+		delayed  bool
+	}{
+		{
+			name:     "recent build of the same source",
+			pkg:      builtPackage(now.Add(-10*time.Second), fv1.BuildTriggerSpecChanged),
+			cooldown: cooldown,
+			delayed:  true,
+		},
+		{
+			name:     "cooldown over",
+			pkg:      builtPackage(now.Add(-time.Minute), fv1.BuildTriggerSpecChanged),
+			cooldown: cooldown,
+		},
+		{
+			name: "changed source",
+			pkg: func() *fv1.Package {
+				pkg := builtPackage(now, fv1.BuildTriggerSpecChanged)
+				pkg.Spec.Source.Checksum.Sum = "def"
+				return pkg
+			}(),
+			cooldown: cooldown,
+		},
+		{
+			name: "unknown source checksum",
+			pkg: func() *fv1.Package {
+				pkg := builtPackage(now, fv1.BuildTriggerSpecChanged)
+				pkg.Spec.Source.Checksum = fv1.Checksum{}
+				return pkg
+			}(),
+			cooldown: cooldown,
+		},
+		{
+			name:     "rebuild annotation",
+			pkg:      builtPackage(now, fv1.BuildTriggerRebuildAnnotation),
+			cooldown: cooldown,
+		},
+		{
+			name:     "cooldown disabled",
+			pkg:      builtPackage(now, fv1.BuildTriggerSpecChanged),
+			cooldown: newRebuildCooldown(0),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			remaining := test.cooldown.remaining(test.pkg, test.pkg.Status.BuildTrigger, now)
+			if delayed := remaining > 0; delayed != test.delayed {
+				t.Errorf("Expected delayed build %v, got %v remaining", test.delayed, remaining)
+			}
+		})
+	}
+}
+
+func TestRebuildDelayedWithinCooldown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.rebuildCooldown = newRebuildCooldown(200 * time.Millisecond)
+	pkg := builtPackage(time.Now(), fv1.BuildTriggerSpecChanged)
+	_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, pkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	pkgInformer := k8sCache.NewSharedIndexInformer(&k8sCache.ListWatch{}, &fv1.Package{}, 0, k8sCache.Indexers{})
+	err = pkgInformer.GetStore().Add(pkg)
+	if err != nil {
+		t.Fatalf("Error adding package to informer store: %v", err)
+	}
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+
+	suppressed := testutil.ToFloat64(buildsSuppressed.WithLabelValues(testEnvName, testNamespace))
+	tpw.processPkg(ctx, pkg)
+	tpw.processPkg(ctx, pkg)
+	if builds := tpw.buildCache.Copy(); len(builds) != 0 {
+		t.Fatalf("Expected rebuild of unchanged source delayed, got builds %v", builds)
+	}
+	if n := testutil.ToFloat64(buildsSuppressed.WithLabelValues(testEnvName, testNamespace)) - suppressed; n != 2 {
+		t.Errorf("Expected 2 suppressed builds counted, got %v", n)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tpw.buildCache.Copy()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected package built once its cooldown is over")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if builds := tpw.buildCache.Copy(); len(builds) != 1 {
+		t.Errorf("Expected one build after the cooldown, got builds %v", builds)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// sourceChecksum returns the checksum of the source archive: the one given
+// in the archive, or the one of the literal contents. ok is false for URL
+// archives without checksum, their contents are only known to the builder.
+func sourceChecksum(archive fv1.Archive) (sum fv1.Checksum, ok bool) {
+	if archive.Checksum.Type == fv1.ChecksumTypeSHA256 && len(archive.Checksum.Sum) > 0 {
+		return archive.Checksum, true
+	}
+	if archive.Type != fv1.ArchiveTypeLiteral || len(archive.Literal) == 0 {
+		return fv1.Checksum{}, false
+	}
+	checksum, err := utils.GetChecksum(bytes.NewReader(archive.Literal))
+	if err != nil {
+		return fv1.Checksum{}, false
+	}
+	return *checksum, true
+}
+
+// builtSource returns the inputs of a build of the package source with the
+// environment builder, nil if the source checksum is unknown.
+func builtSource(pkg *fv1.Package, env *fv1.Environment) *fv1.BuiltSource {
+	sum, ok := sourceChecksum(pkg.Spec.Source)
+	if !ok {
+		return nil
+	}
+	buildCmd := pkg.Spec.BuildCommand
+	if len(buildCmd) == 0 {
+		buildCmd = env.Spec.Builder.Command
+	}
+	return &fv1.BuiltSource{Checksum: sum, BuilderImage: env.Spec.Builder.Image, BuildCommand: buildCmd}
+}
+
+// forcedBuildTrigger reports whether builds with the trigger run even if
+// the source is unchanged: rebuild requests, e.g. with ANNOTATION_REBUILD,
+// and builder image changes.
+func forcedBuildTrigger(trigger fv1.BuildTrigger) bool {
+	switch trigger {
+	case fv1.BuildTriggerRebuildAnnotation, fv1.BuildTriggerManualRebuild, fv1.BuildTriggerBulkRebuild,
+		fv1.BuildTriggerBuilderImageChanged:
+		return true
+	}
+	return false
+}
+
+// unchangedSource returns the inputs of the last successful build of the
+// package if the build would have the same ones, i.e. source archive, builder
+// image and build command, nil if the package needs to be built. Only the
+// first attempt of builds updating the package can be skipped.
+func (e *buildExecution) unchangedSource(ctx context.Context, pkg *fv1.Package) *fv1.BuiltSource {
+	last := pkg.Status.BuiltSource
+	if last == nil || e.opts.Attempt > 1 || e.opts.SkipPackageUpdate || forcedBuildTrigger(e.opts.Trigger) ||
+		pkg.Spec.Deployment.IsEmpty() {
+		return nil
+	}
+	if sum, ok := sourceChecksum(pkg.Spec.Source); !ok || sum != last.Checksum {
+		return nil
+	}
+	// the build reports environment lookup errors
+	env, err := e.FissionClient.CoreV1().Environments(pkg.Spec.Environment.Namespace).Get(ctx, pkg.Spec.Environment.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	if current := builtSource(pkg, env); current == nil || *current != *last {
+		return nil
+	}
+	return last
+}
+
+// skipUnchangedSource marks the package as succeeded with the deployment
+// archive of its last successful build, whose inputs are unchanged.
+func (e *buildExecution) skipUnchangedSource(ctx context.Context, srcpkg *fv1.Package, last *fv1.BuiltSource) (BuildResult, error) {
+	e.logger.Info("skipping build of unchanged package source",
+		zap.String("package_name", srcpkg.ObjectMeta.Name),
+		zap.String("namespace", srcpkg.ObjectMeta.Namespace),
+		zap.String("checksum", last.Checksum.Sum),
+		zap.String("builder_image", last.BuilderImage),
+		zap.String("trigger", string(e.opts.Trigger)))
+	buildLogs := e.opts.Logs + logf(logPhaseBuildermgr, "Build skipped, source archive %s:%s unchanged since the last successful build with builder image %s",
+		last.Checksum.Type, last.Checksum.Sum, last.BuilderImage)
+
+	pkg := srcpkg.DeepCopy()
+	pkg.Status.BuildStatus = fv1.BuildStatusSucceeded
+	pkg.Status.BuildPhase = ""
+	pkg.Status.BuildTrigger = e.opts.Trigger
+	pkg.Status.BuildLog = buildLogs
+	pkg.Status.BuilderWait = nil
+	pkg.Status.BuilderPod = nil
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             fv1.PackageReasonSourceUnchanged,
+		Message:            "source archive unchanged since the last successful build, its deployment archive is kept",
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	updated, err := updatePackage(ctx, e.logger, e.FissionClient, pkg, pkg.Status, nil)
+	if err != nil {
+		return BuildResult{Package: srcpkg, Status: srcpkg.Status.BuildStatus, Logs: buildLogs}, err
+	}
+	e.wrotePackage(updated, "")
+	observeBuildResult(srcpkg, buildResultUnchanged)
+	return BuildResult{
+		Package:    updated,
+		Status:     fv1.BuildStatusSucceeded,
+		Logs:       buildLogs,
+		Deployment: updated.Spec.Deployment.DeepCopy(),
+	}, nil
+}
@@ -0,0 +1,124 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestSourceChecksum(t *testing.T) {
+	given := fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc"}
+	if sum, ok := sourceChecksum(fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/archive", Checksum: given}); !ok || sum != given {
+		t.Errorf("Expected the given checksum %v, got %v", given, sum)
+	}
+	literal := fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: []byte("source")}
+	sum, ok := sourceChecksum(literal)
+	if !ok || sum.Type != fv1.ChecksumTypeSHA256 || len(sum.Sum) == 0 {
+		t.Errorf("Expected the checksum of the literal, got %v", sum)
+	}
+	if _, ok := sourceChecksum(fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/archive"}); ok {
+		t.Error("Expected no checksum of an URL archive without checksum")
+	}
+}
+
+func TestExecuteBuildSkipsUnchangedSource(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		trigger fv1.BuildTrigger
+		// change changes the inputs of the second build
+		change func(t *testing.T, tb *testBuild, pkg *fv1.Package)
+		// noChecksum builds a source without checksum
+		noChecksum bool
+		rebuilt    bool
+	}{
+		{
+			name:    "unchanged source",
+			trigger: fv1.BuildTriggerSpecChanged,
+		},
+		{
+			name:    "changed source",
+			trigger: fv1.BuildTriggerSpecChanged,
+			change: func(t *testing.T, tb *testBuild, pkg *fv1.Package) {
+				pkg.Spec.Source.Checksum.Sum = "def"
+			},
+			rebuilt: true,
+		},
+		{
+			name:    "changed build command",
+			trigger: fv1.BuildTriggerSpecChanged,
+			change: func(t *testing.T, tb *testBuild, pkg *fv1.Package) {
+				pkg.Spec.BuildCommand = "./build.sh --release"
+			},
+			rebuilt: true,
+		},
+		{
+			name:    "changed builder image",
+			trigger: fv1.BuildTriggerSpecChanged,
+			change: func(t *testing.T, tb *testBuild, pkg *fv1.Package) {
+				env := tb.env.DeepCopy()
+				env.Spec.Builder.Image = "builder-image:v2"
+				_, err := tb.fissionClient.CoreV1().Environments(env.ObjectMeta.Namespace).Update(context.Background(), env, metav1.UpdateOptions{})
+				if err != nil {
+					t.Fatalf("Error updating environment: %v", err)
+				}
+			},
+			rebuilt: true,
+		},
+		{
+			name:    "rebuild annotation",
+			trigger: fv1.BuildTriggerRebuildAnnotation,
+			rebuilt: true,
+		},
+		{
+			name:       "no source checksum",
+			trigger:    fv1.BuildTriggerSpecChanged,
+			noChecksum: true,
+			rebuilt:    true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			if !test.noChecksum {
+				tb.pkg.Spec.Source.Checksum = fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "abc"}
+			}
+			_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{Trigger: fv1.BuildTriggerPackageCreated})
+			if err != nil {
+				t.Fatalf("Error building package: %v", err)
+			}
+			pkg := tb.getPackage(t)
+			if test.noChecksum != (pkg.Status.BuiltSource == nil) {
+				t.Fatalf("Expected built source recorded for source checksum %q, got %+v", pkg.Spec.Source.Checksum.Sum, pkg.Status.BuiltSource)
+			}
+			deployment := pkg.Spec.Deployment
+			if test.change != nil {
+				test.change(t, tb, pkg)
+			}
+
+			result, err := ExecuteBuild(context.Background(), tb.deps, pkg, BuildOptions{Trigger: test.trigger})
+			if err != nil || result.Status != fv1.BuildStatusSucceeded {
+				t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+			}
+			if rebuilt := tb.builds == 2; rebuilt != test.rebuilt {
+				t.Fatalf("Expected rebuild %v, got %d builds", test.rebuilt, tb.builds)
+			}
+			pkg = tb.getPackage(t)
+			if test.rebuilt {
+				checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionTrue, fv1.PackageReasonBuildSucceeded)
+				return
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionTrue, fv1.PackageReasonSourceUnchanged)
+			if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded || pkg.Status.BuildTrigger != test.trigger ||
+				!strings.Contains(pkg.Status.BuildLog, "Build skipped, source archive sha256:abc unchanged") {
+				t.Errorf("Expected skipped build to leave the package succeeded, got %s triggered by %s: %q",
+					pkg.Status.BuildStatus, pkg.Status.BuildTrigger, pkg.Status.BuildLog)
+			}
+			if pkg.Spec.Deployment.URL != deployment.URL || pkg.Spec.Deployment.Checksum != deployment.Checksum {
+				t.Errorf("Expected deployment archive %+v to be kept, got %+v", deployment, pkg.Spec.Deployment)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	builderClient "github.com/fission/fission/pkg/builder/client"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// dryRunRequested reports whether the package is annotated for dry-run
+// builds.
+func dryRunRequested(pkg *fv1.Package) bool {
+	return pkg.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_DRY_RUN] == "true"
+}
+
+// dryRunDone reports whether the current generation of the package went
+// through a dry-run build already. The package stays pending after its dry
+// run, it's built for real once the annotation is removed.
+func dryRunDone(pkg *fv1.Package) bool {
+	cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionDryRunSucceeded)
+	return cond != nil && cond.Status != metav1.ConditionUnknown && cond.ObservedGeneration == pkg.ObjectMeta.Generation
+}
+
+// probeBuilderPod checks that the builder addressed by the context, the
+// builder service of the environment by default, answers. Older builder
+// images don't serve the status endpoint, a not found answer proves the
+// builder is reachable too. The probe sends the builder auth token of the
+// context.
+func probeBuilderPod(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+	svcName := builderAddress(ctx, env, builderNs)
+	builderC := builderClient.MakeClient(logger, fmt.Sprintf("http://%v:8001", svcName)).WithAuthToken(contextBuilderAuthToken(ctx))
+	_, err := builderC.Status(ctx)
+	if err != nil && !ferror.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// dryRun validates that the package would build without building it: its
+// environment exists, its source archive is fetchable with the expected
+// checksum and a builder pod of the environment is ready and reachable.
+// The outcome is the DryRunSucceeded condition, the package is left pending.
+func (e *buildExecution) dryRun(ctx, attemptCtx context.Context, pkg *fv1.Package, logs string) (BuildResult, error) {
+	env, err := e.FissionClient.CoreV1().Environments(pkg.Spec.Environment.Namespace).Get(ctx, pkg.Spec.Environment.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonEnvironmentNotFound,
+			fmt.Errorf("environment does not exist: %q", pkg.Spec.Environment.Name))
+	} else if err != nil {
+		return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonDryRunFailed,
+			errors.Wrap(err, "error getting environment"))
+	}
+	logs += logf(logPhaseBuildermgr, "Dry run: environment %s exists", env.ObjectMeta.Name)
+
+	if msg := e.missingSourceArchive(ctx, pkg); len(msg) > 0 {
+		return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonSourceArchiveMissing, errors.New(msg))
+	}
+	if pkg.Spec.Source.Type == fv1.ArchiveTypeGit {
+		// only the builder pod has the credentials of the repository
+		logs += logLines(logPhaseBuildermgr, "Dry run: git source is cloned by the builder, not checked")
+	} else {
+		err = e.checkSourceArchive(ctx, pkg)
+		if err != nil {
+			return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonSourceFetchFailed,
+				errors.Wrap(err, "source archive check failed"))
+		}
+		logs += logLines(logPhaseBuildermgr, "Dry run: source archive is fetchable")
+	}
+
+	if buildMode(e.logger, env) == fv1.BuildModeJob {
+		// build jobs are only created to build
+		logs += logLines(logPhaseBuildermgr, "Dry run: environment builds in jobs, builder not checked")
+		return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonDryRunSucceeded, nil)
+	}
+	builderNs := builderNamespace(e.NSResolver, env)
+	pod, err := e.waitForBuilder(ctx, pkg, env, builderNs)
+	if pod != nil {
+		defer e.builderLoad.release(builderPodName(pod))
+	}
+	if buildCanceled(ctx, e.Logger, pkg) {
+		return e.canceled(ctx, pkg, logs)
+	}
+	if err == nil && pod == nil {
+		err = errors.New("environment builder not ready")
+	}
+	if err == nil {
+		var probeCtx context.Context
+		probeCtx, err = e.builderAuth.withToken(withBuilderAddress(ctx, builderPodAddress(pod)), builderNs)
+		if err == nil {
+			err = e.probeBuilder(probeCtx, e.logger, env, builderNs)
+		}
+	}
+	if err != nil {
+		reason := fv1.PackageReasonBuilderNotReady
+		var pullErr builderImagePullError
+		if errors.As(err, &pullErr) {
+			reason = fv1.PackageReasonBuilderImagePullFailed
+		}
+		return e.dryRunDone(ctx, attemptCtx, pkg, logs, reason,
+			errors.Wrap(err, "environment builder check failed"))
+	}
+	logs += logLines(logPhaseBuildermgr, "Dry run: environment builder is reachable")
+	return e.dryRunDone(ctx, attemptCtx, pkg, logs, fv1.PackageReasonDryRunSucceeded, nil)
+}
+
+// checkSourceArchive checks that the source archive is fetchable and, if
+// the package has a checksum, that it matches. URL archives are checked
+// like the deployment archives, storage services not reporting the
+// checksum only prove the archive exists. URL archives with credentials
+// are left to the builder.
+func (e *buildExecution) checkSourceArchive(ctx context.Context, pkg *fv1.Package) error {
+	src := pkg.Spec.Source
+	switch src.Type {
+	case fv1.ArchiveTypeLiteral:
+		if len(src.Checksum.Sum) == 0 {
+			return nil
+		}
+		sum, err := utils.GetChecksum(bytes.NewReader(src.Literal))
+		if err != nil {
+			return err
+		}
+		if sum.Sum != src.Checksum.Sum {
+			return errors.Errorf("checksum mismatch, got %s, want %s", sum.Sum, src.Checksum.Sum)
+		}
+		return nil
+	case fv1.ArchiveTypeUrl:
+		if len(src.AuthSecret) > 0 {
+			// only the fetcher reads the credentials of the archive
+			return nil
+		}
+		return e.checkArchive(ctx, &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: src.URL, Checksum: src.Checksum})
+	}
+	return errors.Errorf("unknown source archive type %q", src.Type)
+}
+
+// dryRunDone records the outcome of the dry run, a nil err means success.
+// The package goes back to pending and its other conditions tell it's not
+// built.
+func (e *buildExecution) dryRunDone(ctx, attemptCtx context.Context, pkg *fv1.Package, logs string, reason string, err error) (BuildResult, error) {
+	if buildCanceled(ctx, e.Logger, pkg) {
+		return e.canceled(ctx, pkg, logs)
+	}
+	status, msg := metav1.ConditionTrue, "package would build"
+	if buildTimedOut(ctx) {
+		// the build context is done, update the package with the
+		// context of the attempt
+		ctx = attemptCtx
+		reason = fv1.PackageReasonBuildTimeout
+		err = errors.Errorf("dry run exceeded timeout of %v", e.opts.Timeout)
+	}
+	if err != nil {
+		status, msg = metav1.ConditionFalse, err.Error()
+		e.logger.Info("package dry run failed", zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace), zap.String("reason", reason), zap.Error(err))
+	}
+	if err == nil {
+		logs += logLines(logPhaseBuildermgr, "Dry run succeeded, the package would build")
+	} else {
+		logs += logf(logPhaseBuildermgr, "Dry run failed: %s", msg)
+	}
+
+	notBuilt := fmt.Sprintf("package not built, remove the %s annotation to build it", fv1.ANNOTATION_BUILD_DRY_RUN)
+	e.mu.Lock()
+	// the package isn't in any build phase while pending
+	e.phase = ""
+	e.setConditionLocked(fv1.PackageConditionDryRunSucceeded, status, reason, msg)
+	if meta.IsStatusConditionPresentAndEqual(e.conditions, fv1.PackageConditionBuilderReady, metav1.ConditionUnknown) {
+		e.setConditionLocked(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, reason, msg)
+	}
+	e.setConditionLocked(fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildPending, notBuilt)
+	e.setConditionLocked(fv1.PackageConditionFunctionsUpdated, metav1.ConditionUnknown, fv1.PackageReasonBuildPending, notBuilt)
+	e.mu.Unlock()
+	updated, er := e.updatePackage(ctx, pkg, fv1.BuildStatusPending, logs, nil)
+	if er != nil {
+		e.logger.Error("error updating package", zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("resource_version", pkg.ObjectMeta.ResourceVersion), zap.Error(er))
+		updated = pkg
+	}
+	return BuildResult{Package: updated, Status: fv1.BuildStatusPending, Logs: logs, DryRun: true}, err
+}
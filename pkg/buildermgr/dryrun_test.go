@@ -0,0 +1,104 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestExecuteBuildDryRun(t *testing.T) {
+	tb := newTestBuild(t)
+	probes := 0
+	tb.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+		probes++
+		return nil
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Error running dry-run build: %v", err)
+	}
+	if !result.DryRun || result.Status != fv1.BuildStatusPending || result.Retry {
+		t.Errorf("Expected pending dry-run result, got %s dry run %v retry %v", result.Status, result.DryRun, result.Retry)
+	}
+	if tb.builds != 0 || tb.store.uploads != 0 {
+		t.Errorf("Expected nothing built or uploaded, got %d builds and %d uploads", tb.builds, tb.store.uploads)
+	}
+	if tb.checks != 1 || probes != 1 {
+		t.Errorf("Expected the source archive and builder checked once, got %d and %d", tb.checks, probes)
+	}
+
+	pkg := tb.getPackage(t)
+	if pkg.Status.BuildStatus != fv1.BuildStatusPending || !pkg.Spec.Deployment.IsEmpty() {
+		t.Errorf("Expected package left pending without deployment archive, got %s %+v", pkg.Status.BuildStatus, pkg.Spec.Deployment)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionDryRunSucceeded, metav1.ConditionTrue, fv1.PackageReasonDryRunSucceeded)
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildPending)
+	if !dryRunDone(pkg) {
+		t.Error("Expected the dry run of the package generation to be done")
+	}
+	if rv := tb.functionResourceVersion(t); rv != "" {
+		t.Errorf("Expected function not to be bumped, got package resource version %q", rv)
+	}
+}
+
+func TestExecuteBuildDryRunFailures(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		setup  func(tb *testBuild)
+		reason string
+	}{
+		{
+			name: "missing environment",
+			setup: func(tb *testBuild) {
+				tb.pkg.Spec.Environment.Name = "missing"
+			},
+			reason: fv1.PackageReasonEnvironmentNotFound,
+		},
+		{
+			name: "source checksum mismatch",
+			setup: func(tb *testBuild) {
+				tb.pkg.Spec.Source = fv1.Archive{
+					Type:     fv1.ArchiveTypeLiteral,
+					Literal:  []byte("package main"),
+					Checksum: fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: "0123"},
+				}
+			},
+			reason: fv1.PackageReasonSourceFetchFailed,
+		},
+		{
+			name: "builder unreachable",
+			setup: func(tb *testBuild) {
+				tb.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+					return errors.New("connection refused")
+				}
+			},
+			reason: fv1.PackageReasonBuilderNotReady,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			tb.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+				return nil
+			}
+			test.setup(tb)
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{DryRun: true, MaxAttempts: 3})
+			if err == nil || result.Retry || result.Status != fv1.BuildStatusPending {
+				t.Fatalf("Expected failed dry run leaving the package pending, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			if tb.builds != 0 {
+				t.Errorf("Expected nothing built, got %d builds", tb.builds)
+			}
+			pkg := tb.getPackage(t)
+			if pkg.Status.BuildStatus != fv1.BuildStatusPending {
+				t.Errorf("Expected package left pending, got %s", pkg.Status.BuildStatus)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionDryRunSucceeded, metav1.ConditionFalse, test.reason)
+		})
+	}
+}
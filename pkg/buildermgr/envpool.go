@@ -0,0 +1,180 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+type (
+	// envPool is the build worker pool of an environment. Its builds wait
+	// in the build queue with the builds of the other environments, but
+	// only parallelism of them run at the same time, so that a flood of
+	// packages of one environment doesn't take all the build slots.
+	envPool struct {
+		env fv1.EnvironmentReference
+		// parallelism is the number of builds of the environment running
+		// at the same time, zero means no limit.
+		parallelism int
+		running     int
+	}
+
+	// envPools holds the worker pools of the environments with builds.
+	// Pools are created with the first build of their environment and
+	// torn down when the environment is deleted.
+	envPools struct {
+		logger *zap.Logger
+		mutex  sync.Mutex
+		pools  map[string]*envPool
+		// parallelism is the default parallelism of the pools, and
+		// overrides holds the environments overriding it with the build
+		// parallelism annotation.
+		parallelism int
+		overrides   map[string]int
+	}
+)
+
+func newEnvPools(logger *zap.Logger, parallelism int) *envPools {
+	return &envPools{
+		logger:      logger,
+		pools:       make(map[string]*envPool),
+		parallelism: parallelism,
+		overrides:   make(map[string]int),
+	}
+}
+
+func envPoolKey(env fv1.EnvironmentReference) string {
+	return env.Namespace + "/" + env.Name
+}
+
+// pool returns the pool of the environment, it must be called with the
+// mutex held.
+func (p *envPools) pool(env fv1.EnvironmentReference) *envPool {
+	key := envPoolKey(env)
+	pool, ok := p.pools[key]
+	if !ok {
+		parallelism, ok := p.overrides[key]
+		if !ok {
+			parallelism = p.parallelism
+		}
+		pool = &envPool{env: env, parallelism: parallelism}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// acquire takes a slot of the pool of the build environment, it returns
+// false if all of them are in use.
+func (p *envPools) acquire(b *pkgBuild) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pool := p.pool(b.pkg.Spec.Environment)
+	if pool.parallelism > 0 && pool.running >= pool.parallelism {
+		return false
+	}
+	pool.running++
+	b.pool = pool
+	envPoolRunning.WithLabelValues(pool.env.Name, pool.env.Namespace).Set(float64(pool.running))
+	return true
+}
+
+// available returns whether the pool of the build environment has a free slot.
+func (p *envPools) available(b *pkgBuild) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pool := p.pool(b.pkg.Spec.Environment)
+	return pool.parallelism <= 0 || pool.running < pool.parallelism
+}
+
+// release frees the pool slot taken by the build.
+func (p *envPools) release(b *pkgBuild) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pool := b.pool
+	if pool == nil {
+		return
+	}
+	b.pool = nil
+	pool.running--
+	// the metrics of torn down pools are gone
+	if p.pools[envPoolKey(pool.env)] == pool {
+		envPoolRunning.WithLabelValues(pool.env.Name, pool.env.Namespace).Set(float64(pool.running))
+	}
+}
+
+// setParallelism applies the build parallelism annotation of the
+// environment to its pool.
+func (p *envPools) setParallelism(env *fv1.Environment) {
+	ref := fv1.EnvironmentReference{Name: env.ObjectMeta.Name, Namespace: env.ObjectMeta.Namespace}
+	key := envPoolKey(ref)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	parallelism := p.parallelism
+	if v, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_PARALLELISM]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			p.logger.Warn("invalid build parallelism annotation, using default",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("namespace", env.ObjectMeta.Namespace),
+				zap.String("value", v),
+				zap.Int("default", parallelism))
+			delete(p.overrides, key)
+		} else {
+			parallelism = n
+			p.overrides[key] = n
+		}
+	} else {
+		delete(p.overrides, key)
+	}
+	if pool, ok := p.pools[key]; ok {
+		pool.parallelism = parallelism
+	}
+}
+
+// remove tears down the pool of the deleted environment. Its running
+// builds keep their slots until they end.
+func (p *envPools) remove(env *fv1.Environment) {
+	ref := fv1.EnvironmentReference{Name: env.ObjectMeta.Name, Namespace: env.ObjectMeta.Namespace}
+	key := envPoolKey(ref)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.overrides, key)
+	if _, ok := p.pools[key]; !ok {
+		return
+	}
+	delete(p.pools, key)
+	envPoolRunning.DeleteLabelValues(ref.Name, ref.Namespace)
+	envPoolQueued.DeleteLabelValues(ref.Name, ref.Namespace)
+}
+
+// updateEnvPoolQueued reports the queued builds of each environment pool.
+func (pkgw *packageWatcher) updateEnvPoolQueued() {
+	queued := make(map[string]int)
+	for _, b := range pkgw.buildQueue.Builds() {
+		queued[envPoolKey(b.pkg.Spec.Environment)]++
+	}
+	pkgw.pools.mutex.Lock()
+	defer pkgw.pools.mutex.Unlock()
+	for key, pool := range pkgw.pools.pools {
+		envPoolQueued.WithLabelValues(pool.env.Name, pool.env.Namespace).Set(float64(queued[key]))
+	}
+}
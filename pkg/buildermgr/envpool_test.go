@@ -0,0 +1,92 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestEnvPoolKeepsEnvironmentsIndependent(t *testing.T) {
+	ctx := context.Background()
+	tpw := newTestPackageWatcher(t)
+	tpw.pools = newEnvPools(tpw.logger, 1)
+	tpw.addReadyBuilderPod(t)
+	started := make(chan string, 2)
+	tpw.deps.buildPackage = blockOnContext(started)
+
+	otherEnv := tpw.env.DeepCopy()
+	otherEnv.ObjectMeta.Name = "other-env"
+	_, err := tpw.fissionClient.CoreV1().Environments(testNamespace).Create(ctx, otherEnv, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating environment: %v", err)
+	}
+	newPackage := func(name string, env string) *fv1.Package {
+		t.Helper()
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = name
+		pkg.Spec.Environment.Name = env
+		pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(ctx, pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+		return pkg
+	}
+
+	// the environment pool runs one build, the second one stays queued
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	<-started
+	tpw.buildWithCache(newPackage("flooding-pkg", testEnvName), fv1.BuildTriggerPackageCreated)
+	// builds of the other environment aren't held back, its build waits
+	// for its builder pod
+	tpw.buildWithCache(newPackage("other-pkg", otherEnv.ObjectMeta.Name), fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildState(t, buildStateWaitingForBuilder, 1)
+
+	queued := tpw.buildQueue.Builds()
+	if len(queued) != 1 || queued[0].pkg.ObjectMeta.Name != "flooding-pkg" {
+		t.Fatalf("Expected the second build of the environment to stay queued, got %d queued builds", len(queued))
+	}
+	if n := testutil.ToFloat64(envPoolRunning.WithLabelValues(testEnvName, testNamespace)); n != 1 {
+		t.Errorf("Expected 1 running build in the environment pool, got %v", n)
+	}
+
+	// the queued build runs once the pool slot is freed
+	tpw.CancelBuild(testNamespace, testPkgName)
+	if id := <-started; id != buildID(queued[0].pkg, 1) {
+		t.Errorf("Expected the queued build %s to start, got %q", buildID(queued[0].pkg, 1), id)
+	}
+}
+
+func TestEnvPoolParallelismAnnotation(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.pools = newEnvPools(tpw.logger, 1)
+	b := &pkgBuild{pkg: tpw.pkg}
+
+	env := tpw.env.DeepCopy()
+	env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILD_PARALLELISM: "2"}
+	tpw.pools.setParallelism(env)
+	for i := 0; i < 2; i++ {
+		if !tpw.pools.acquire(&pkgBuild{pkg: tpw.pkg}) {
+			t.Fatalf("Expected build %d to get a slot of the environment pool", i+1)
+		}
+	}
+	if tpw.pools.acquire(b) {
+		t.Fatalf("Expected the environment pool to be full")
+	}
+
+	// invalid values fall back to the default
+	env.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_PARALLELISM] = "many"
+	tpw.pools.setParallelism(env)
+	if tpw.pools.pool(tpw.pkg.Spec.Environment).parallelism != 1 {
+		t.Errorf("Expected an invalid annotation to restore the default parallelism")
+	}
+
+	// the pool is torn down with its environment
+	tpw.pools.remove(env)
+	if !tpw.pools.acquire(b) {
+		t.Errorf("Expected a new pool for builds after the environment deletion")
+	}
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// envStatusUpdateInterval is the minimum time between two status
+// updates of the same environment.
+const envStatusUpdateInterval = 10 * time.Second
+
+// eventReasonBuilderFailed is the reason of the events of builders failing
+// to create their pods.
+const eventReasonBuilderFailed = "BuilderFailed"
+
+// envStatusReporter keeps the package build health in the environment status
+// up to date. Package and builder pod events mark the environment dirty, and
+// dirty environments are updated in batches once per interval. The status is
+// always computed from the informer caches, so it's rebuilt after a restart.
+type envStatusReporter struct {
+	logger        *zap.Logger
+	fissionClient versioned.Interface
+	nsResolver    *utils.NamespaceResolver
+	podInformer   map[string]k8sCache.SharedIndexInformer
+	pkgInformer   map[string]k8sCache.SharedIndexInformer
+	interval      time.Duration
+	// deployInformer finds the builder deployments failing to create their
+	// pods. Optional; nil reports no builder failure.
+	deployInformer map[string]k8sCache.SharedIndexInformer
+	// podInformers and deployInformers watch the builder namespaces set
+	// by environments, created once their builders are looked up.
+	// Optional; nil only watches the namespaces of podInformer and
+	// deployInformer.
+	podInformers    *namespaceInformers
+	deployInformers *namespaceInformers
+	// recorder records the builder failures on the environments. Optional;
+	// nil records none.
+	recorder record.EventRecorder
+
+	mutex sync.Mutex
+	dirty map[k8stypes.NamespacedName]struct{}
+}
+
+func makeEnvStatusReporter(logger *zap.Logger, fissionClient versioned.Interface,
+	podInformer, pkgInformer map[string]k8sCache.SharedIndexInformer) *envStatusReporter {
+	return &envStatusReporter{
+		logger:        logger.Named("env_status_reporter"),
+		fissionClient: fissionClient,
+		nsResolver:    utils.DefaultNSResolver(),
+		podInformer:   podInformer,
+		pkgInformer:   pkgInformer,
+		interval:      envStatusUpdateInterval,
+		dirty:         make(map[k8stypes.NamespacedName]struct{}),
+	}
+}
+
+// Run registers the event handlers on the informers and starts updating
+// environment status. The informers are run by the package watcher, the
+// deployment informers by Start.
+func (r *envStatusReporter) Run(ctx context.Context) {
+	for _, informer := range r.pkgInformer {
+		informer.AddEventHandler(r.packageInformerHandler())
+	}
+	for _, informer := range r.podInformer {
+		informer.AddEventHandler(r.podInformerHandler())
+	}
+	for _, informer := range r.deployInformer {
+		informer.AddEventHandler(r.deploymentInformerHandler())
+	}
+	r.podInformers.forEach(func(informer k8sCache.SharedIndexInformer) {
+		informer.AddEventHandler(r.podInformerHandler())
+	})
+	r.deployInformers.forEach(func(informer k8sCache.SharedIndexInformer) {
+		informer.AddEventHandler(r.deploymentInformerHandler())
+	})
+	go r.run(ctx)
+}
+
+func (r *envStatusReporter) run(ctx context.Context) {
+	var synced []k8sCache.InformerSynced
+	for _, informer := range r.pkgInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	for _, informer := range r.podInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	for _, informer := range r.deployInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !k8sCache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	// environments without packages or builder pods get no events,
+	// mark them all once so that stale counts get reset.
+	r.markAllEnvironments(ctx)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.flush(ctx)
+		}
+	}
+}
+
+func (r *envStatusReporter) markDirty(namespace, name string) {
+	if len(name) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dirty[k8stypes.NamespacedName{Namespace: namespace, Name: name}] = struct{}{}
+}
+
+func (r *envStatusReporter) markAllEnvironments(ctx context.Context) {
+	for ns := range r.pkgInformer {
+		envs, err := r.fissionClient.CoreV1().Environments(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			r.logger.Error("error listing environments", zap.String("namespace", ns), zap.Error(err))
+			continue
+		}
+		for _, env := range envs.Items {
+			r.markDirty(env.ObjectMeta.Namespace, env.ObjectMeta.Name)
+		}
+	}
+}
+
+// flush updates the status of all dirty environments. Environments that
+// failed to update are retried with the next batch.
+func (r *envStatusReporter) flush(ctx context.Context) {
+	r.mutex.Lock()
+	dirty := r.dirty
+	r.dirty = make(map[k8stypes.NamespacedName]struct{})
+	r.mutex.Unlock()
+
+	for key := range dirty {
+		err := r.updateStatus(ctx, key)
+		if err != nil {
+			r.logger.Error("error updating environment status",
+				zap.String("environment", key.Name),
+				zap.String("namespace", key.Namespace),
+				zap.Error(err))
+			r.markDirty(key.Namespace, key.Name)
+		}
+	}
+}
+
+func (r *envStatusReporter) updateStatus(ctx context.Context, key k8stypes.NamespacedName) error {
+	env, err := r.fissionClient.CoreV1().Environments(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	status := r.computeStatus(env)
+	status.LastUpdateTimestamp = env.Status.LastUpdateTimestamp
+	if equality.Semantic.DeepEqual(status, env.Status) {
+		return nil
+	}
+	status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+	failed := len(status.BuilderFailure) > 0 && status.BuilderFailure != env.Status.BuilderFailure
+	env.Status = status
+	updated, err := r.fissionClient.CoreV1().Environments(env.ObjectMeta.Namespace).UpdateStatus(ctx, env, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	if failed {
+		r.recordBuilderFailure(updated)
+	}
+	return nil
+}
+
+// computeStatus summarizes the packages referencing the environment and its
+// builder readiness from the informer caches. Build timestamps only move
+// forward, so they survive the package that set them being rebuilt or deleted.
+func (r *envStatusReporter) computeStatus(env *fv1.Environment) fv1.EnvironmentStatus {
+	status := fv1.EnvironmentStatus{
+		LastSuccessfulBuildTimestamp: env.Status.LastSuccessfulBuildTimestamp,
+		LastFailedBuildTimestamp:     env.Status.LastFailedBuildTimestamp,
+		BuilderReady:                 r.builderReady(env),
+		BuilderFailure:               r.builderFailure(env),
+	}
+	for _, informer := range r.pkgInformer {
+		for _, obj := range informer.GetStore().List() {
+			pkg, ok := obj.(*fv1.Package)
+			if !ok || pkg.Spec.Environment.Name != env.ObjectMeta.Name ||
+				pkg.Spec.Environment.Namespace != env.ObjectMeta.Namespace {
+				continue
+			}
+			switch pkg.Status.BuildStatus {
+			case fv1.BuildStatusPending:
+				status.PackageBuilds.Pending++
+			case fv1.BuildStatusRunning:
+				status.PackageBuilds.Running++
+			case fv1.BuildStatusSucceeded:
+				status.PackageBuilds.Succeeded++
+				if pkg.Status.LastUpdateTimestamp.After(status.LastSuccessfulBuildTimestamp.Time) {
+					status.LastSuccessfulBuildTimestamp = pkg.Status.LastUpdateTimestamp
+				}
+			case fv1.BuildStatusFailed:
+				status.PackageBuilds.Failed++
+				if pkg.Status.LastUpdateTimestamp.After(status.LastFailedBuildTimestamp.Time) {
+					status.LastFailedBuildTimestamp = pkg.Status.LastUpdateTimestamp
+				}
+			case fv1.BuildStatusNone:
+				status.PackageBuilds.None++
+			}
+		}
+	}
+	return status
+}
+
+// builderReady reports whether a builder pod of the current builder spec
+// of the environment has all its containers ready.
+func (r *envStatusReporter) builderReady(env *fv1.Environment) bool {
+	builderNs := builderNamespace(r.nsResolver, env)
+	informer, ok := r.podInformer[builderNs]
+	if !ok {
+		informer, ok = r.podInformers.ensure(builderNs)
+	}
+	if !ok {
+		return false
+	}
+	for _, pod := range builderPods(r.logger, informer, env, builderNs) {
+		if len(pod.Status.ContainerStatuses) > 0 && builderPodReady(pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// builderFailure returns why the builder deployment of the current builder
+// spec of the environment fails to create its pods, such as the rejection
+// of the pods by the pod admission, empty if it doesn't.
+func (r *envStatusReporter) builderFailure(env *fv1.Environment) string {
+	builderNs := builderNamespace(r.nsResolver, env)
+	informer, ok := r.deployInformer[builderNs]
+	if !ok {
+		informer, ok = r.deployInformers.ensure(builderNs)
+	}
+	if !ok {
+		return ""
+	}
+	hash := builderSpecHash(env)
+	for _, obj := range informer.GetStore().List() {
+		deploy, ok := obj.(*appsv1.Deployment)
+		if !ok || deploy.ObjectMeta.Labels[LABEL_DEPLOYMENT_OWNER] != BUILDER_MGR ||
+			deploy.ObjectMeta.Labels[LABEL_ENV_NAME] != env.ObjectMeta.Name ||
+			deploy.ObjectMeta.Labels[LABEL_ENV_NAMESPACE] != builderNs ||
+			!builderOfEnvironment(deploy.ObjectMeta.Labels, env) ||
+			deploy.Spec.Template.ObjectMeta.Labels[LABEL_ENV_BUILDER_HASH] != hash {
+			continue
+		}
+		for _, cond := range deploy.Status.Conditions {
+			if cond.Type == appsv1.DeploymentReplicaFailure && cond.Status == apiv1.ConditionTrue {
+				return cond.Message
+			}
+		}
+	}
+	return ""
+}
+
+// recordBuilderFailure records the event of the builder failure on the
+// environment.
+func (r *envStatusReporter) recordBuilderFailure(env *fv1.Environment) {
+	if r.recorder == nil {
+		return
+	}
+	ref := &apiv1.ObjectReference{
+		APIVersion:      fv1.SchemeGroupVersion.String(),
+		Kind:            "Environment",
+		Namespace:       env.ObjectMeta.Namespace,
+		Name:            env.ObjectMeta.Name,
+		UID:             env.ObjectMeta.UID,
+		ResourceVersion: env.ObjectMeta.ResourceVersion,
+	}
+	r.recorder.Event(ref, apiv1.EventTypeWarning, eventReasonBuilderFailed,
+		fmt.Sprintf("builder pods can't be created: %s", env.Status.BuilderFailure))
+}
+
+func (r *envStatusReporter) packageInformerHandler() k8sCache.ResourceEventHandlerFuncs {
+	markPkgEnv := func(obj interface{}) {
+		pkg, ok := eventObject(obj).(*fv1.Package)
+		if !ok {
+			// the package watcher counts the dropped event
+			return
+		}
+		r.markDirty(pkg.Spec.Environment.Namespace, pkg.Spec.Environment.Name)
+	}
+	return k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: markPkgEnv,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// the package may have moved to another environment
+			markPkgEnv(oldObj)
+			markPkgEnv(newObj)
+		},
+		DeleteFunc: markPkgEnv,
+	}
+}
+
+// markBuilderEnv marks the environment of the builder pod or deployment
+// with the labels dirty.
+func (r *envStatusReporter) markBuilderEnv(labels map[string]string) {
+	if labels[LABEL_DEPLOYMENT_OWNER] != BUILDER_MGR {
+		return
+	}
+	envName := labels[LABEL_ENV_NAME]
+	if ns, ok := labels[LABEL_ENV_OWNER_NAMESPACE]; ok {
+		r.markDirty(ns, envName)
+		return
+	}
+	// older builders only carry the builder namespace, environments in
+	// the default namespace have their builders in the builder namespace.
+	builderNs := labels[LABEL_ENV_NAMESPACE]
+	r.markDirty(builderNs, envName)
+	if builderNs == r.nsResolver.BuilderNamespace {
+		r.markDirty(metav1.NamespaceDefault, envName)
+	}
+}
+
+func (r *envStatusReporter) podInformerHandler() k8sCache.ResourceEventHandlerFuncs {
+	markPodEnv := func(event string, obj interface{}) {
+		pod, ok := eventObject(obj).(*apiv1.Pod)
+		if !ok {
+			eventDecodeError(r.logger, informerPod, event, eventObject(obj))
+			return
+		}
+		r.markBuilderEnv(pod.ObjectMeta.Labels)
+	}
+	return k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			markPodEnv(eventAdd, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			markPodEnv(eventUpdate, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			markPodEnv(eventDelete, obj)
+		},
+	}
+}
+
+func (r *envStatusReporter) deploymentInformerHandler() k8sCache.ResourceEventHandlerFuncs {
+	markDeploymentEnv := func(event string, obj interface{}) {
+		deploy, ok := eventObject(obj).(*appsv1.Deployment)
+		if !ok {
+			eventDecodeError(r.logger, informerDeployment, event, eventObject(obj))
+			return
+		}
+		r.markBuilderEnv(deploy.ObjectMeta.Labels)
+	}
+	return k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			markDeploymentEnv(eventAdd, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			markDeploymentEnv(eventUpdate, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			markDeploymentEnv(eventDelete, obj)
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	genInformer "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestEnvStatusReporter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env := &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testEnvName,
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+		},
+	}
+	fissionClient := fClient.NewSimpleClientset(env)
+	pkgInformer := genInformer.NewSharedInformerFactory(fissionClient, 0).Core().V1().Packages().Informer()
+	podInformer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Pods().Informer()
+
+	lastSuccess := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	lastFailure := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []struct {
+		status    fv1.BuildStatus
+		timestamp time.Time
+	}{
+		{fv1.BuildStatusSucceeded, lastSuccess.Add(-time.Hour)},
+		{fv1.BuildStatusSucceeded, lastSuccess},
+		{fv1.BuildStatusFailed, lastFailure},
+		{fv1.BuildStatusFailed, lastFailure.Add(-time.Hour)},
+		{fv1.BuildStatusFailed, lastFailure.Add(-2 * time.Hour)},
+		{fv1.BuildStatusPending, time.Time{}},
+	}
+	for i, s := range statuses {
+		err := pkgInformer.GetStore().Add(&fv1.Package{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pkg-%d", i), Namespace: testNamespace},
+			Spec: fv1.PackageSpec{
+				Environment: fv1.EnvironmentReference{Name: testEnvName, Namespace: testNamespace},
+			},
+			Status: fv1.PackageStatus{
+				BuildStatus:         s.status,
+				LastUpdateTimestamp: metav1.Time{Time: s.timestamp},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// packages of other environments are not counted
+	err := pkgInformer.GetStore().Add(&fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-pkg", Namespace: testNamespace},
+		Spec: fv1.PackageSpec{
+			Environment: fv1.EnvironmentReference{Name: "other-env", Namespace: testNamespace},
+		},
+		Status: fv1.PackageStatus{BuildStatus: fv1.BuildStatusFailed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = podInformer.GetStore().Add(&apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "builder-pod",
+			Namespace: testNamespace,
+			Labels: map[string]string{
+				LABEL_ENV_NAME:            testEnvName,
+				LABEL_ENV_NAMESPACE:       testNamespace,
+				LABEL_ENV_RESOURCEVERSION: "1",
+			},
+		},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{{Name: "builder", Ready: true}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := makeEnvStatusReporter(loggerfactory.GetLogger(), fissionClient,
+		map[string]k8sCache.SharedIndexInformer{testNamespace: podInformer},
+		map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer})
+	r.markAllEnvironments(ctx)
+	r.flush(ctx)
+
+	got, err := fissionClient.CoreV1().Environments(testNamespace).Get(ctx, testEnvName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting environment: %v", err)
+	}
+	expected := fv1.PackageBuildCounts{Pending: 1, Succeeded: 2, Failed: 3}
+	if got.Status.PackageBuilds != expected {
+		t.Errorf("Expected package builds %+v, got %+v", expected, got.Status.PackageBuilds)
+	}
+	if !got.Status.LastSuccessfulBuildTimestamp.Time.Equal(lastSuccess) {
+		t.Errorf("Expected last successful build at %v, got %v", lastSuccess, got.Status.LastSuccessfulBuildTimestamp)
+	}
+	if !got.Status.LastFailedBuildTimestamp.Time.Equal(lastFailure) {
+		t.Errorf("Expected last failed build at %v, got %v", lastFailure, got.Status.LastFailedBuildTimestamp)
+	}
+	if !got.Status.BuilderReady {
+		t.Error("Expected builder to be ready")
+	}
+
+	// nothing changed, so another batch must not touch the environment
+	r.markAllEnvironments(ctx)
+	r.flush(ctx)
+	updates := 0
+	for _, action := range fissionClient.Actions() {
+		if action.Matches("update", "environments") {
+			if action.(k8sTesting.UpdateAction).GetSubresource() != "status" {
+				t.Errorf("Expected environment to be updated through the status subresource")
+			}
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("Expected 1 environment status update, got %d", updates)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -45,8 +46,16 @@ const (
 	LABEL_ENV_NAME            = "envName"
 	LABEL_ENV_NAMESPACE       = "envNamespace"
 	LABEL_ENV_RESOURCEVERSION = "envResourceVersion"
+	LABEL_ENV_BUILDER_HASH    = "envBuilderHash"
+	LABEL_ENV_OWNER_NAMESPACE = "envOwnerNamespace"
 	LABEL_DEPLOYMENT_OWNER    = "owner"
+	LABEL_BUILD_JOB           = "buildJob"
 	BUILDER_MGR               = "buildermgr"
+
+	// ANNOTATION_BUILDER_DRAINING marks the deployment, service and pods
+	// of a builder replaced by a builder change, with the time its drain
+	// started.
+	ANNOTATION_BUILDER_DRAINING = "builderDraining"
 )
 
 var (
@@ -72,6 +81,22 @@ type (
 		useIstio               bool
 		podSpecPatch           *apiv1.PodSpec
 		envWatchInformer       map[string]k8sCache.SharedIndexInformer
+		// builderAuth gives the builder pods the token of the build
+		// requests, nil without builder auth.
+		builderAuth *builderAuth
+		// drains are the replaced builders waiting for their builds in
+		// flight before they're deleted, for drainGracePeriod at most.
+		drains           *builderDrains
+		drainGracePeriod time.Duration
+		// builderLoad and pkgInformer find the builds in flight on the
+		// draining builders. Optional; nil counts none of them.
+		builderLoad *builderLoad
+		pkgInformer map[string]k8sCache.SharedIndexInformer
+		// gcInterval is the time between two sweeps of the builders of
+		// deleted environments, gcMinAge the age below which builders
+		// aren't swept.
+		gcInterval time.Duration
+		gcMinAge   time.Duration
 	}
 )
 
@@ -106,6 +131,10 @@ func makeEnvironmentWatcher(
 		fetcherConfig:          fetcherConfig,
 		podSpecPatch:           podSpecPatch,
 		envWatchInformer:       utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.EnvironmentResource),
+		drains:                 newBuilderDrains(),
+		drainGracePeriod:       defaultBuilderDrainGracePeriod,
+		gcInterval:             defaultBuilderGCInterval,
+		gcMinAge:               defaultBuilderGCMinAge,
 	}
 
 	envWatcher.EnvWatchEventHandlers(ctx)
@@ -138,100 +167,153 @@ func (envw *environmentWatcher) EnvWatchEventHandlers(ctx context.Context) {
 	for _, informer := range envw.envWatchInformer {
 		informer.AddEventHandler(k8sCache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				envObj := obj.(*fv1.Environment)
+				envObj, ok := obj.(*fv1.Environment)
+				if !ok {
+					eventDecodeError(envw.logger, informerEnvironment, eventAdd, obj)
+					return
+				}
 				envw.AddUpdateBuilder(ctx, envObj)
 			},
 			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
-				oldEnvObj := oldObj.(*fv1.Environment)
-				newEnvObj := newObj.(*fv1.Environment)
-				if oldEnvObj.ObjectMeta.ResourceVersion != newEnvObj.ObjectMeta.ResourceVersion {
+				oldEnvObj, ok := oldObj.(*fv1.Environment)
+				if !ok {
+					eventDecodeError(envw.logger, informerEnvironment, eventUpdate, oldObj)
+					return
+				}
+				newEnvObj, ok := newObj.(*fv1.Environment)
+				if !ok {
+					eventDecodeError(envw.logger, informerEnvironment, eventUpdate, newObj)
+					return
+				}
+				if envw.builderChanged(oldEnvObj, newEnvObj) {
 					envw.AddUpdateBuilder(ctx, newEnvObj)
+				} else if builderReplicas(oldEnvObj) != builderReplicas(newEnvObj) {
+					envw.scaleBuilder(ctx, newEnvObj)
 				}
 			},
 			DeleteFunc: func(obj interface{}) {
-				envObj := obj.(*fv1.Environment)
+				envObj, ok := eventObject(obj).(*fv1.Environment)
+				if !ok {
+					eventDecodeError(envw.logger, informerEnvironment, eventDelete, eventObject(obj))
+					return
+				}
 				envw.DeleteBuilder(ctx, envObj)
 			},
 		})
 	}
 }
 
+// builderChanged reports whether the environment update changes its
+// builder: its builder spec, build mode or builder namespace. Other updates,
+// such as annotations added by other controllers, keep the running builder.
+func (envw *environmentWatcher) builderChanged(oldEnv, newEnv *fv1.Environment) bool {
+	if info, ok := envw.cache[crd.CacheKeyUID(&newEnv.ObjectMeta)]; ok && info.deployment != nil {
+		if _, ok := info.deployment.Spec.Template.ObjectMeta.Labels[LABEL_ENV_BUILDER_HASH]; !ok {
+			// the pods of builders created before the builder spec
+			// hash only match the environment version they were
+			// created for
+			return true
+		}
+	}
+	return builderSpecHash(oldEnv) != builderSpecHash(newEnv) ||
+		buildMode(envw.logger, oldEnv) != buildMode(envw.logger, newEnv) ||
+		builderNamespace(envw.nsResolver, oldEnv) != builderNamespace(envw.nsResolver, newEnv)
+}
+
 func (envw *environmentWatcher) AddUpdateBuilder(ctx context.Context, env *fv1.Environment) {
 	//builder is not supported with v1 interface and ignore env without builder image
 	if env.Spec.Version != 1 && len(env.Spec.Builder.Image) != 0 {
-		if _, ok := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)]; !ok {
-			builderInfo, err := envw.createBuilder(ctx, env, envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace))
+		key := crd.CacheKeyUID(&env.ObjectMeta)
+		if buildMode(envw.logger, env) == fv1.BuildModeJob {
+			// every build runs its own builder job, the shared builder
+			// of an environment switched to build jobs goes away once
+			// its builds in flight are done
+			if info, ok := envw.cache[key]; ok {
+				delete(envw.cache, key)
+				envw.drainBuilder(ctx, info.deployment, time.Now())
+			}
+			return
+		}
+		ns := builderNamespace(envw.nsResolver, env)
+		if _, ok := envw.cache[key]; !ok {
+			builderInfo, err := envw.createBuilder(ctx, env, ns)
 			if err != nil {
 				envw.logger.Error("error creating builder service", zap.Error(err))
 				return
 			}
-			envw.cache[crd.CacheKeyUID(&env.ObjectMeta)] = builderInfo
+			envw.cache[key] = builderInfo
+			envw.undrainBuilder(ctx, builderInfo.deployment)
+			envw.resumeDrains(ctx, env, ns, builderInfo)
+			// the replicas may have changed while buildermgr was down
+			envw.scaleBuilder(ctx, env)
 		} else {
-			envw.DeleteBuilder(ctx, env)
-			// once older builder deleted then add new builder service
-			builderInfo, err := envw.createBuilder(ctx, env, envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace))
+			// the older builder keeps its builds in flight until the
+			// new builder is created, it's deleted once they're done,
+			// in its own namespace if the builder namespace changed
+			old := envw.cache[key]
+			builderInfo, err := envw.createBuilder(ctx, env, ns)
 			if err != nil {
 				envw.logger.Error("error updating builder service", zap.Error(err))
 				return
 			}
-			envw.cache[crd.CacheKeyUID(&env.ObjectMeta)] = builderInfo
+			envw.cache[key] = builderInfo
+			envw.undrainBuilder(ctx, builderInfo.deployment)
+			envw.scaleBuilder(ctx, env)
+			if old.deployment != nil && builderInfo.deployment != nil &&
+				old.deployment.ObjectMeta.Name != builderInfo.deployment.ObjectMeta.Name {
+				envw.drainBuilder(ctx, old.deployment, time.Now())
+			}
 		}
 	}
 }
 
+// DeleteBuilder deletes the builder services and deployments of the deleted
+// environment, those it got before the builder manager restarted included.
 func (envw *environmentWatcher) DeleteBuilder(ctx context.Context, env *fv1.Environment) {
-	if _, ok := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)]; ok {
-		envw.DeleteBuilderService(ctx, env)
-		envw.DeleteBuilderDeployment(ctx, env)
-		delete(envw.cache, crd.CacheKeyUID(&env.ObjectMeta))
-		envw.logger.Info("builder service deleted", zap.String("env_name", env.ObjectMeta.Name), zap.String("namespace", envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)))
-	} else {
-		envw.logger.Debug("builder service not found", zap.String("env_name", env.ObjectMeta.Name), zap.String("namespace", envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)))
-	}
+	ns := builderNamespace(envw.nsResolver, env)
+	// the builders drained for the environment go away with it
+	envw.drains.stopEnv(ns, env.ObjectMeta.Name)
+	envw.DeleteBuilderService(ctx, env)
+	envw.DeleteBuilderDeployment(ctx, env)
+	delete(envw.cache, crd.CacheKeyUID(&env.ObjectMeta))
+	envw.logger.Info("builder deleted", zap.String("env_name", env.ObjectMeta.Name), zap.String("namespace", ns))
 }
 
 func (envw *environmentWatcher) DeleteBuilderService(ctx context.Context, env *fv1.Environment) {
-	ns := envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)
+	ns := builderNamespace(envw.nsResolver, env)
 	svcList, err := envw.getBuilderServiceList(ctx, envw.getDeploymentLabels(env.ObjectMeta.Name), ns)
 	if err != nil {
 		envw.logger.Error("error getting the builder service list", zap.Error(err))
 	}
 	for _, svc := range svcList {
-		envName := svc.ObjectMeta.Labels[LABEL_ENV_NAME]
-		if _, ok := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)]; ok {
-			err := envw.deleteBuilderServiceByName(ctx, svc.ObjectMeta.Name, svc.ObjectMeta.Namespace)
-			if err != nil {
-				envw.logger.Error("error removing builder service", zap.Error(err),
-					zap.String("service_name", svc.ObjectMeta.Name),
-					zap.String("service_namespace", svc.ObjectMeta.Namespace),
-					zap.String("env_name", envName))
-			}
-			break
-		} else {
-			envw.logger.Error("builder service not found",
+		if builderDraining(&svc.ObjectMeta) || !builderOfEnvironment(svc.ObjectMeta.Labels, env) {
+			// deleted by its drain, or of another environment
+			continue
+		}
+		err := envw.deleteBuilderServiceByName(ctx, svc.ObjectMeta.Name, svc.ObjectMeta.Namespace)
+		if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+			envw.logger.Error("error removing builder service", zap.Error(err),
 				zap.String("service_name", svc.ObjectMeta.Name),
-				zap.String("service_namespace", svc.ObjectMeta.Namespace))
+				zap.String("service_namespace", svc.ObjectMeta.Namespace),
+				zap.String("env_name", env.ObjectMeta.Name))
 		}
 	}
 }
 
 func (envw *environmentWatcher) DeleteBuilderDeployment(ctx context.Context, env *fv1.Environment) {
-	ns := envw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)
+	ns := builderNamespace(envw.nsResolver, env)
 	deployList, err := envw.getBuilderDeploymentList(ctx, envw.getDeploymentLabels(env.ObjectMeta.Name), ns)
 	if err != nil {
 		envw.logger.Error("error getting the builder deployment list", zap.Error(err))
 	}
 	for _, deploy := range deployList {
-		if _, ok := envw.cache[crd.CacheKeyUID(&env.ObjectMeta)]; ok {
-			err := envw.deleteBuilderDeploymentByName(ctx, deploy.ObjectMeta.Name, deploy.ObjectMeta.Namespace)
-			if err != nil {
-				envw.logger.Error("error removing builder deployment", zap.Error(err),
-					zap.String("deployment_name", deploy.ObjectMeta.Name),
-					zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
-			}
-			break
-		} else {
-			envw.logger.Error("builder deployment not found", zap.Error(err),
+		if builderDraining(&deploy.ObjectMeta) || !builderOfEnvironment(deploy.ObjectMeta.Labels, env) {
+			// deleted by its drain, or of another environment
+			continue
+		}
+		err := envw.deleteBuilderDeploymentByName(ctx, deploy.ObjectMeta.Name, deploy.ObjectMeta.Namespace)
+		if err != nil && !k8serrors.IsNotFound(errors.Cause(err)) {
+			envw.logger.Error("error removing builder deployment", zap.Error(err),
 				zap.String("deployment_name", deploy.ObjectMeta.Name),
 				zap.String("deployment_namespace", deploy.ObjectMeta.Namespace))
 		}
@@ -242,12 +324,16 @@ func (envw *environmentWatcher) createBuilder(ctx context.Context, env *fv1.Envi
 	var svc *apiv1.Service
 	var deploy *appsv1.Deployment
 
-	sel := envw.getLabels(env.ObjectMeta.Name, ns, env.ObjectMeta.ResourceVersion)
+	sel, err := envw.builderLabels(ctx, env, ns)
+	if err != nil {
+		return nil, err
+	}
 
 	svcList, err := envw.getBuilderServiceList(ctx, sel, ns)
 	if err != nil {
 		return nil, err
 	}
+	svcList = environmentServices(svcList, env)
 	// there should be only one service in svcList
 	if len(svcList) == 0 {
 		svc, err = envw.createBuilderService(ctx, env, ns)
@@ -266,6 +352,7 @@ func (envw *environmentWatcher) createBuilder(ctx context.Context, env *fv1.Envi
 	if err != nil {
 		return nil, err
 	}
+	deployList = environmentDeployments(deployList, env)
 	// there should be only one deploy in deployList
 	if len(deployList) == 0 {
 		deploy, err = envw.createBuilderDeployment(ctx, env, ns)
@@ -286,6 +373,26 @@ func (envw *environmentWatcher) createBuilder(ctx context.Context, env *fv1.Envi
 	}, nil
 }
 
+// builderLabels returns the labels of the builder service and deployment
+// of the environment. The builder created for an older version of the
+// environment is kept if its builder spec is still the current one, the
+// environment may have been updated otherwise while buildermgr was down.
+func (envw *environmentWatcher) builderLabels(ctx context.Context, env *fv1.Environment, ns string) (map[string]string, error) {
+	sel := envw.getDeploymentLabels(env.ObjectMeta.Name)
+	sel[LABEL_ENV_NAMESPACE] = ns
+	deployList, err := envw.getBuilderDeploymentList(ctx, sel, ns)
+	if err != nil {
+		return nil, err
+	}
+	hash := builderSpecHash(env)
+	for _, deploy := range environmentDeployments(deployList, env) {
+		if deploy.Spec.Template.ObjectMeta.Labels[LABEL_ENV_BUILDER_HASH] == hash {
+			return envw.getLabels(env.ObjectMeta.Name, ns, deploy.ObjectMeta.Labels[LABEL_ENV_RESOURCEVERSION]), nil
+		}
+	}
+	return envw.getLabels(env.ObjectMeta.Name, ns, env.ObjectMeta.ResourceVersion), nil
+}
+
 func (envw *environmentWatcher) deleteBuilderServiceByName(ctx context.Context, name, namespace string) error {
 	err := envw.kubernetesClient.CoreV1().
 		Services(namespace).
@@ -325,7 +432,7 @@ func (envw *environmentWatcher) createBuilderService(ctx context.Context, env *f
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: ns,
 			Name:      name,
-			Labels:    sel,
+			Labels:    ownerLabels(sel, env),
 		},
 		Spec: apiv1.ServiceSpec{
 			Selector: sel,
@@ -375,7 +482,49 @@ func (envw *environmentWatcher) getBuilderDeploymentList(ctx context.Context, se
 func (envw *environmentWatcher) createBuilderDeployment(ctx context.Context, env *fv1.Environment, ns string) (*appsv1.Deployment, error) {
 	name := fmt.Sprintf("%v-%v", env.ObjectMeta.Name, env.ObjectMeta.ResourceVersion)
 	sel := envw.getLabels(env.ObjectMeta.Name, ns, env.ObjectMeta.ResourceVersion)
-	var replicas int32 = 1
+	replicas := builderReplicas(env)
+
+	pod, err := envw.builderPodTemplate(env, ns)
+	if err != nil {
+		return nil, err
+	}
+	// the builder pods don't start without the secret copy of their
+	// namespace
+	_, err = envw.builderAuth.withToken(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+			Labels:    ownerLabels(sel, env),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: sel,
+			},
+			Template: *pod,
+		},
+	}
+
+	_, err = envw.kubernetesClient.AppsV1().Deployments(ns).Create(ctx, deployment, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	envw.logger.Info("creating builder deployment", zap.String("deployment", name))
+
+	return deployment, nil
+}
+
+// builderPodTemplate returns the pod template of the environment builder,
+// with the builder and fetcher containers, in the builder namespace. The
+// builder deployment and the build jobs run it.
+func (envw *environmentWatcher) builderPodTemplate(env *fv1.Environment, ns string) (*apiv1.PodTemplateSpec, error) {
+	sel := envw.getLabels(env.ObjectMeta.Name, ns, env.ObjectMeta.ResourceVersion)
 
 	podAnnotations := env.ObjectMeta.Annotations
 	if podAnnotations == nil {
@@ -391,27 +540,19 @@ func (envw *environmentWatcher) createBuilderDeployment(ctx context.Context, env
 		ImagePullPolicy:        envw.builderImagePullPolicy,
 		TerminationMessagePath: "/dev/termination-log",
 		Command:                []string{"/builder", envw.fetcherConfig.SharedMountPath()},
-		ReadinessProbe: &apiv1.Probe{
-			InitialDelaySeconds: 5,
-			PeriodSeconds:       2,
-			ProbeHandler: apiv1.ProbeHandler{
-				HTTPGet: &apiv1.HTTPGetAction{
-					Path: "/healthz",
-					Port: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: 8001,
-					},
-				},
-			},
-		},
+		ReadinessProbe:         defaultBuilderReadinessProbe(),
 	}, env.Spec.Builder.Container)
 	if err != nil {
 		return nil, err
 	}
 
-	pod := apiv1.PodTemplateSpec{
+	// the builder spec hash isn't part of the selectors, the builders
+	// created before it keep being found
+	podLabels := ownerLabels(sel, env)
+	podLabels[LABEL_ENV_BUILDER_HASH] = builderSpecHash(env)
+	pod := &apiv1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels:      sel,
+			Labels:      podLabels,
 			Annotations: podAnnotations,
 		},
 		Spec: apiv1.PodSpec{
@@ -430,42 +571,24 @@ func (envw *environmentWatcher) createBuilderDeployment(ctx context.Context, env
 		}
 	}
 
-	pod.Spec = *(util.ApplyImagePullSecret(env.Spec.ImagePullSecret, pod.Spec))
-
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: ns,
-			Name:      name,
-			Labels:    sel,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: sel,
-			},
-			Template: pod,
-		},
-	}
-
-	err = envw.fetcherConfig.AddFetcherToPodSpec(&deployment.Spec.Template.Spec, "builder")
+	err = envw.fetcherConfig.AddFetcherToPodSpec(&pod.Spec, "builder")
 	if err != nil {
 		return nil, err
 	}
+	envw.builderAuth.addToPodSpec(&pod.Spec)
 
 	if env.Spec.Builder.PodSpec != nil {
-		newPodSpec, err := util.MergePodSpec(&deployment.Spec.Template.Spec, env.Spec.Builder.PodSpec)
+		newPodSpec, err := util.MergePodSpec(&pod.Spec, env.Spec.Builder.PodSpec)
 		if err != nil {
 			return nil, err
 		}
-		deployment.Spec.Template.Spec = *newPodSpec
+		pod.Spec = *newPodSpec
 	}
+	applyBuilderImagePullSecrets(&pod.Spec, env)
+	applyBuilderScheduling(&pod.Spec, env.Spec.Builder)
+	applyBuilderReadinessProbe(&pod.Spec, env.Spec.Builder)
+	applyBuilderEphemeralStorage(&pod.Spec, env.Spec.Builder)
+	applyBuilderSecurityContext(&pod.Spec, env.Spec.Builder)
 
-	_, err = envw.kubernetesClient.AppsV1().Deployments(ns).Create(ctx, deployment, metav1.CreateOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	envw.logger.Info("creating builder deployment", zap.String("deployment", name))
-
-	return deployment, nil
+	return pod, nil
 }
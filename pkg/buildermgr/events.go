@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	k8sCache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	informerPackage     = "package"
+	informerPod         = "pod"
+	informerEnvironment = "environment"
+	informerDeployment  = "deployment"
+
+	eventAdd    = "add"
+	eventUpdate = "update"
+	eventDelete = "delete"
+	// eventList is an object listed from an informer store
+	eventList = "list"
+)
+
+// droppedEvents is the number of informer events dropped since start.
+var droppedEvents atomic.Int64
+
+// eventObject returns the object of an informer event, unwrapping the
+// final state of objects whose deletion was missed by the watch.
+func eventObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(k8sCache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+// eventDecodeError records an informer event dropped because its object
+// isn't of the type the handler expects.
+func eventDecodeError(logger *zap.Logger, informer string, event string, obj interface{}) {
+	eventDecodeErrors.WithLabelValues(informer, event).Inc()
+	droppedEvents.Add(1)
+	logger.Error("dropping informer event with unexpected object type",
+		zap.String("informer", informer),
+		zap.String("event", event),
+		zap.String("type", fmt.Sprintf("%T", obj)))
+}
+
+// logDroppedEvents logs the number of dropped informer events once the
+// context is done, so that silently lost events show up on shutdown.
+func logDroppedEvents(ctx context.Context, logger *zap.Logger) {
+	<-ctx.Done()
+	if n := droppedEvents.Load(); n > 0 {
+		logger.Warn("informer events were dropped due to unexpected object types",
+			zap.Int64("dropped_events", n))
+	}
+}
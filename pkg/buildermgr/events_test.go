@@ -0,0 +1,112 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+func decodeErrors(informer, event string) float64 {
+	return testutil.ToFloat64(eventDecodeErrors.WithLabelValues(informer, event))
+}
+
+func TestPackageHandlerDropsUnexpectedObjects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	handler := tpw.packageInformerHandler(ctx)
+
+	addErrors := decodeErrors(informerPackage, eventAdd)
+	updateErrors := decodeErrors(informerPackage, eventUpdate)
+	deleteErrors := decodeErrors(informerPackage, eventDelete)
+
+	// none of these may panic the handler
+	handler.OnAdd(&apiv1.Pod{})
+	handler.OnAdd(nil)
+	handler.OnUpdate(tpw.pkg, "not a package")
+	handler.OnDelete(k8sCache.DeletedFinalStateUnknown{Key: "default/pod", Obj: &apiv1.Pod{}})
+
+	if got := decodeErrors(informerPackage, eventAdd) - addErrors; got != 2 {
+		t.Errorf("Expected 2 add decode errors, got %v", got)
+	}
+	if got := decodeErrors(informerPackage, eventUpdate) - updateErrors; got != 1 {
+		t.Errorf("Expected 1 update decode error, got %v", got)
+	}
+	if got := decodeErrors(informerPackage, eventDelete) - deleteErrors; got != 1 {
+		t.Errorf("Expected 1 delete decode error, got %v", got)
+	}
+
+	// the handler keeps processing events, a missed deletion
+	// still cancels the build waiting for the builder
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	time.Sleep(100 * time.Millisecond)
+	handler.OnDelete(k8sCache.DeletedFinalStateUnknown{Key: "default/" + testPkgName, Obj: tpw.pkg})
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 0 {
+		t.Errorf("Expected no failed status update for deleted package, got %d", n)
+	}
+}
+
+func TestBuildSkipsUnexpectedPodObjects(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	err := tpw.podInformer.GetStore().Add(&fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-a-pod", Namespace: testNamespace},
+	})
+	if err != nil {
+		t.Fatalf("Error adding object to informer store: %v", err)
+	}
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	listErrors := decodeErrors(informerPod, eventList)
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+
+	// the store lists in random order, add the builder pod once the
+	// unexpected object has been skipped so the build doesn't finish first
+	deadline := time.Now().Add(5 * time.Second)
+	for decodeErrors(informerPod, eventList) == listErrors {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected pod list decode errors, got none")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tpw.addReadyBuilderPod(t)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+	if n := tpw.countPackageUpdates(fv1.BuildStatusSucceeded); n != 1 {
+		t.Errorf("Expected one succeeded status update, got %d", n)
+	}
+}
+
+func TestEnvStatusPodHandlerDropsUnexpectedObjects(t *testing.T) {
+	r := makeEnvStatusReporter(zap.NewNop(), nil, nil, nil)
+	handler := r.podInformerHandler()
+
+	deleteErrors := decodeErrors(informerPod, eventDelete)
+	handler.OnDelete(k8sCache.DeletedFinalStateUnknown{Key: "default/pkg", Obj: &fv1.Package{}})
+	handler.OnAdd(&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		LABEL_DEPLOYMENT_OWNER: BUILDER_MGR,
+		LABEL_ENV_NAME:         testEnvName,
+		LABEL_ENV_NAMESPACE:    testNamespace,
+	}}})
+
+	if got := decodeErrors(informerPod, eventDelete) - deleteErrors; got != 1 {
+		t.Errorf("Expected 1 delete decode error, got %v", got)
+	}
+	if len(r.dirty) != 1 {
+		t.Errorf("Expected the builder pod environment to be marked dirty, got %v", r.dirty)
+	}
+}
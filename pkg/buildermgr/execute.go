@@ -0,0 +1,1390 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/retry"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	builderClient "github.com/fission/fission/pkg/builder/client"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+	"github.com/fission/fission/pkg/utils"
+	otelUtils "github.com/fission/fission/pkg/utils/otel"
+)
+
+type (
+	// BuilderPodLister finds the environment builder pods.
+	BuilderPodLister interface {
+		// ListBuilderPods returns the builder pods of the current
+		// version of the environment in the builder namespace.
+		ListBuilderPods(namespace string, env *fv1.Environment) ([]*apiv1.Pod, error)
+	}
+
+	// sourceArchiveStore is the storage service holding package source
+	// archives.
+	sourceArchiveStore interface {
+		ArchiveID(archiveURL string) (id string, target string, ok bool)
+		Stat(ctx context.Context, id string, target string) error
+	}
+
+	// BuildDeps are the dependencies of package builds.
+	BuildDeps struct {
+		Logger        *zap.Logger
+		FissionClient versioned.Interface
+		// StorageSvcURL is the storage service the deployment archives
+		// and build logs are uploaded to.
+		StorageSvcURL string
+		// Pods finds the builder pods of the package environment.
+		Pods BuilderPodLister
+		// NSResolver maps environment namespaces to builder namespaces.
+		// Optional; defaults to utils.DefaultNSResolver().
+		NSResolver *utils.NamespaceResolver
+
+		// runBuild runs the build against the environment builder.
+		runBuild packageBuilder
+		// buildPackage runs the build against the environment builder,
+		// reporting the upload response and build logs only. Optional;
+		// overrides runBuild, its failed builds have no phase or class.
+		buildPackage func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+			storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error)
+		// checkArchive verifies that the deployment archive is downloadable
+		// and is the uploaded one. It's tried archiveCheckAttempts times with a delay starting at
+		// archiveCheckDelay that doubles every time.
+		checkArchive         func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error
+		archiveCheckAttempts int
+		archiveCheckDelay    time.Duration
+		// sourceStore looks up the source archives kept by the storage
+		// service before they're fetched by the builder.
+		sourceStore sourceArchiveStore
+		// verifySource checks the URL source archive against its declared
+		// checksum before the build. Optional; nil downloads the archive
+		// to compute its checksum.
+		verifySource func(ctx context.Context, archive fv1.Archive) error
+		// logStore keeps the complete build logs of finished builds,
+		// the package status only holds their tail.
+		logStore archiveStore
+		// storesFor returns the log and source stores of a storage
+		// service other than StorageSvcURL, the one of a mapped namespace.
+		// Optional; nil makes storage service clients.
+		storesFor func(storageSvcURL string) (archiveStore, sourceArchiveStore)
+		// phaseUpdateInterval is the minimum time between two build phase
+		// updates of a package, phaseLimiter limits them across builds.
+		// No limiter means no limit.
+		phaseUpdateInterval time.Duration
+		phaseLimiter        flowcontrol.RateLimiter
+		// fetchFailures counts the failed source fetches of the builder
+		// pods and recycles failing pods. Optional; nil only counts them
+		// in the metrics.
+		fetchFailures *fetchFailureTracker
+		// recorder records the build events of the packages. Optional;
+		// nil records none.
+		recorder record.EventRecorder
+		// builderReady notifies the builds waiting for a builder pod
+		// when one becomes ready. Optional; nil waits for the health
+		// check backoff only.
+		builderReady *builderReadiness
+		// fnInformer finds the functions using the built package with
+		// the package reference index. Optional; functions of namespaces
+		// without a synced informer are listed.
+		fnInformer map[string]k8sCache.SharedIndexInformer
+		// builderBackoff is the health check backoff of the builder
+		// wait. Optional; the zero value uses the default backoff.
+		builderBackoff BuilderBackoff
+		// probeBuilder checks that the builder of the environment is
+		// reachable in dry-run builds, and pings the builder pod before
+		// sending it a build. Optional; nil asks the builder for its
+		// status.
+		probeBuilder func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error
+		// builderLoad counts the builds in flight on the builder pods,
+		// builds go to the least loaded pod. Optional; nil builds with
+		// the first ready pod.
+		builderLoad *builderLoad
+		// buildJobs creates the build jobs of the environments in job
+		// build mode. Optional; nil fails their builds.
+		buildJobs *buildJobs
+		// uploadRetry is how the deployment archive uploads are retried
+		// while the storage service is unavailable. Optional; the zero
+		// value doesn't retry them.
+		uploadRetry uploadRetryPolicy
+		// archiveLimits are the default size limits of the source and
+		// deployment archives, environments override them. Optional;
+		// the zero value doesn't limit them.
+		archiveLimits archiveSizeLimits
+		// sourceSize returns the size of the source archive, -1 if
+		// unknown. Optional; nil asks the archive URL for it.
+		sourceSize func(ctx context.Context, archive fv1.Archive) (int64, error)
+		// k8sClient gets the container logs of the builder pods that
+		// stopped answering a build. Optional; nil leaves them out of
+		// the build logs.
+		k8sClient kubernetes.Interface
+		// builderAuth authenticates the requests to the builder pods.
+		// Optional; nil sends them without token.
+		builderAuth *builderAuth
+		// buildMetadataPrefix is the prefix of the package labels and
+		// annotations passed to the builder as build metadata. Optional;
+		// empty passes none.
+		buildMetadataPrefix string
+		// forceArchiveUpload uploads the deployment archive of every
+		// build, even if it's identical to the current one of the
+		// package, so that each build gets a fresh archive.
+		forceArchiveUpload bool
+	}
+
+	// BuildOptions are the options of a package build attempt. The zero
+	// value runs a single attempt with all its side effects: the package
+	// status and deployment archive are updated, and the functions using
+	// the package are bumped to the new build.
+	BuildOptions struct {
+		// Attempt is the 1-based number of the build attempt, MaxAttempts
+		// the number of attempts before the package goes into failed state.
+		// Optional; both default to 1.
+		Attempt     int
+		MaxAttempts int
+		// Logs are the build logs of the previous attempts.
+		Logs string
+		// Trigger is why the build was triggered, it's recorded in the
+		// package status. Optional; defaults to fv1.BuildTriggerUnknown.
+		Trigger fv1.BuildTrigger
+		// StartTime is when the first attempt of the build started.
+		// Optional; defaults to the start of this attempt.
+		StartTime time.Time
+		// RetryDelay is the delay before the next attempt, reported in the
+		// build logs when the attempt fails and is going to be retried.
+		RetryDelay time.Duration
+		// Timeout is the deadline of the build, zero means none.
+		Timeout time.Duration
+		// TimeoutLimit names the limit Timeout comes from in the build
+		// logs of builds exceeding it, e.g. the package build timeout.
+		// Optional.
+		TimeoutLimit string
+		// MaxBuildLogSize is the maximum size in bytes of the build logs
+		// stored in package status, zero means no limit.
+		MaxBuildLogSize int
+
+		// SkipPackageUpdate leaves the package resource alone, the status
+		// and deployment archive are only returned in the build result.
+		SkipPackageUpdate bool
+		// SkipFunctionUpdate doesn't bump the functions using the package
+		// to the new build.
+		SkipFunctionUpdate bool
+		// SkipArchiveCheck doesn't wait for the deployment archive to be
+		// downloadable before the build succeeds.
+		SkipArchiveCheck bool
+		// DryRun only checks that the package would build, see
+		// fv1.ANNOTATION_BUILD_DRY_RUN. Nothing is built or uploaded.
+		DryRun bool
+
+		// onStateChange is told the buildState changes of the build.
+		onStateChange func(buildState)
+		// onPhaseChange is told the build phase changes of the build.
+		onPhaseChange func(fv1.BuildPhase)
+		// onBuilderPod is told the builder pod, as namespace/name, the
+		// build waits for or found ready.
+		onBuilderPod func(string)
+		// onBuilderImage is told the builder image of the environment
+		// the build runs with.
+		onBuilderImage func(string)
+	}
+
+	// BuildResult is the outcome of a package build attempt.
+	BuildResult struct {
+		// Package is the package with the status the build left it in.
+		Package *fv1.Package
+		// Status is the build status of the package. It's running when the
+		// attempt failed and Retry is set.
+		Status fv1.BuildStatus
+		// Logs are the build logs of all attempts so far.
+		Logs string
+		// Deployment is the deployment archive of a successful build.
+		Deployment *fv1.Archive
+		// UpdatedFunctions are the functions bumped to the new build,
+		// the functions of other namespaces than the package one are
+		// prefixed with their namespace.
+		UpdatedFunctions []string
+		// ResourceUsage is the resource usage of the build command, nil if
+		// the environment builder doesn't report it.
+		ResourceUsage *fv1.BuildResourceUsage
+		// ArtifactSize is the stored size in bytes of the deployment
+		// archive of a successful build, zero if unknown.
+		ArtifactSize int64
+		// SourceFetchFailures is the number of source fetches the builder
+		// pods failed to verify, the fetch is retried once after a failure.
+		SourceFetchFailures int
+		// Retry tells the failed attempt is to be retried after RetryDelay.
+		Retry bool
+		// Canceled tells the build was canceled, Package is the latest
+		// version written by the build then.
+		Canceled bool
+		// DryRun tells the build was a dry run, the package is left
+		// pending with its DryRunSucceeded condition.
+		DryRun bool
+	}
+
+	// buildExecution is a run of ExecuteBuild.
+	buildExecution struct {
+		BuildDeps
+		opts   BuildOptions
+		logger *zap.Logger
+		// storageSvcURL is the storage service of the package namespace,
+		// StorageSvcURL unless the namespace is mapped to another one
+		storageSvcURL string
+
+		// ctx is the build context, for the deferred build phase updates
+		ctx context.Context
+
+		// mu guards the package writes of the build
+		mu sync.Mutex
+		// pkg is the latest version of the package written by the build
+		pkg          *fv1.Package
+		phase        fv1.BuildPhase
+		writtenPhase fv1.BuildPhase
+		lastWrite    time.Time
+		phaseTimer   *time.Timer
+		phasesDone   bool
+		// conditions are the package conditions of the build, observing
+		// the package generation
+		conditions []metav1.Condition
+		generation int64
+		// resourceUsage is the resource usage reported by the builder
+		resourceUsage *fv1.BuildResourceUsage
+		// sourceCommit is the commit the git source was resolved to
+		sourceCommit string
+		// builtSource are the inputs of the build, recorded in the
+		// status once it succeeded
+		builtSource *fv1.BuiltSource
+		// builderWait is the health check backoff while waiting for the
+		// builder, written with the build phase updates
+		builderWait        *fv1.BuilderWait
+		writtenBuilderWait *fv1.BuilderWait
+		// sourceFetchFailures counts the failed source fetches of the build
+		sourceFetchFailures int
+		// attemptStart is when the build attempt started, builderPod the
+		// builder pod it ran on, as namespace/name, and failureReason and
+		// failureMessage why it failed. They make its build history entry.
+		attemptStart   time.Time
+		builderPod     string
+		failureReason  string
+		failureMessage string
+		// builderPodInfo identifies the builder pod in the package
+		// status, written with the build phase updates
+		builderPodInfo *fv1.BuilderPodInfo
+		// unreachablePods are the ready builder pods that didn't answer
+		// the ping before the build, as namespace/name, with their
+		// resource version then. They're passed over until it changes.
+		unreachablePods map[string]string
+		// envName and envNamespace label the build metrics
+		envName      string
+		envNamespace string
+	}
+
+	// informerPodLister lists the builder pods from the pod informers
+	// of the builder namespaces. The informers of the builder namespaces
+	// set by environments are created on first use. Optional; nil only
+	// watches the namespaces of podInformer.
+	informerPodLister struct {
+		logger      *zap.Logger
+		podInformer map[string]k8sCache.SharedIndexInformer
+		informers   *namespaceInformers
+	}
+)
+
+var (
+	errNoBuilderPodInformer = errors.New("no builder pod informer for namespace")
+	errEnvironmentDeleted   = errors.New("environment was deleted during build")
+)
+
+// ListBuilderPods looks up the builder pods of the environment in the
+// informer store of the builder namespace.
+func (l informerPodLister) ListBuilderPods(namespace string, env *fv1.Environment) ([]*apiv1.Pod, error) {
+	informer, ok := l.podInformer[namespace]
+	if !ok {
+		informer, ok = l.informers.ensure(namespace)
+	}
+	if !ok {
+		return nil, errors.Wrap(errNoBuilderPodInformer, namespace)
+	}
+	return builderPods(l.logger, informer, env, namespace), nil
+}
+
+// ExecuteBuild builds the package with its environment builder and records
+// the result: the package status and deployment archive are updated, and the
+// functions using the package are bumped to the new build, each of which can
+// be skipped with the build options. Steps of the build:
+// 1. Update package status to running state and start the build deadline
+// 2. Wait for the environment builder pod, or build job pod in job build mode, to be ready
+// 3. Call buildPackage to build package
+// 4. Wait for the deployment archive to be downloadable
+// 5. Update package resource in package ref of functions that share the same package
+// 6. Update package status to succeed state
+// *. Mark the attempt to be retried or update package status to failed state, if any one of steps above failed/time out
+//
+// The error tells why the build failed, the result is still meaningful then.
+// A canceled build returns the cancellation cause and leaves the package alone.
+func ExecuteBuild(ctx context.Context, deps BuildDeps, pkg *fv1.Package, opts BuildOptions) (BuildResult, error) {
+	if deps.FissionClient == nil || deps.Pods == nil {
+		return BuildResult{Package: pkg, Status: pkg.Status.BuildStatus, Logs: opts.Logs},
+			errors.New("build dependencies need a fission client and a builder pod lister")
+	}
+	e := newBuildExecution(deps, opts)
+	return e.run(ctx, pkg)
+}
+
+// newBuildExecution fills in the defaults of the build dependencies and options.
+func newBuildExecution(deps BuildDeps, opts BuildOptions) *buildExecution {
+	if deps.Logger == nil {
+		deps.Logger = zap.NewNop()
+	}
+	if deps.NSResolver == nil {
+		deps.NSResolver = utils.DefaultNSResolver()
+	}
+	if deps.buildPackage != nil {
+		deps.runBuild = legacyPackageBuilder(deps.buildPackage)
+	}
+	if deps.runBuild == nil {
+		deps.runBuild = buildPackage
+	}
+	if deps.checkArchive == nil {
+		deps.checkArchive = verifyStoredArchive
+	}
+	if deps.archiveCheckAttempts <= 0 {
+		deps.archiveCheckAttempts = defaultArchiveCheckAttempts
+	}
+	if deps.archiveCheckDelay <= 0 {
+		deps.archiveCheckDelay = defaultArchiveCheckDelay
+	}
+	if deps.logStore == nil {
+		deps.logStore = storageSvcClient.MakeClient(deps.StorageSvcURL)
+	}
+	if deps.verifySource == nil {
+		deps.verifySource = verifySourceChecksum
+	}
+	if deps.sourceSize == nil {
+		deps.sourceSize = sourceArchiveSize
+	}
+	if deps.sourceStore == nil {
+		deps.sourceStore = storageSvcClient.MakeClient(deps.StorageSvcURL)
+	}
+	if deps.phaseUpdateInterval <= 0 {
+		deps.phaseUpdateInterval = buildPhaseUpdateInterval
+	}
+	if deps.probeBuilder == nil {
+		deps.probeBuilder = probeBuilderPod
+	}
+	if deps.builderBackoff.isZero() {
+		deps.builderBackoff = DefaultBuilderBackoff()
+	}
+	if opts.Attempt < 1 {
+		opts.Attempt = 1
+	}
+	if opts.MaxAttempts < opts.Attempt {
+		opts.MaxAttempts = opts.Attempt
+	}
+	if opts.StartTime.IsZero() {
+		opts.StartTime = time.Now()
+	}
+	if len(opts.Trigger) == 0 {
+		opts.Trigger = fv1.BuildTriggerUnknown
+	}
+	return &buildExecution{BuildDeps: deps, opts: opts, logger: deps.Logger, ctx: context.Background(),
+		storageSvcURL: deps.StorageSvcURL, attemptStart: time.Now()}
+}
+
+// missingSourceArchive looks up the source archive of packages kept by our
+// own storage service and returns why the build must fail if the archive is
+// gone. Archives at external URLs are left to the builder to fetch.
+func (e *buildExecution) missingSourceArchive(ctx context.Context, pkg *fv1.Package) string {
+	if pkg.Spec.Source.Type != fv1.ArchiveTypeUrl || len(pkg.Spec.Source.URL) == 0 {
+		return ""
+	}
+	_, sourceStore := e.storageSvcStores(e.storageSvcURL)
+	id, target, ok := sourceStore.ArchiveID(pkg.Spec.Source.URL)
+	if !ok {
+		return ""
+	}
+	err := sourceStore.Stat(ctx, id, target)
+	if errors.Is(err, storageSvcClient.ErrArchiveNotFound) {
+		e.logger.Error("source archive missing from storage service", zap.String("archive_id", id),
+			zap.String("package_name", pkg.ObjectMeta.Name))
+		return fmt.Sprintf("source archive %q was deleted from the storage service, re-upload it with "+
+			"'fission package update --name %s --namespace %s --src <archive>'", id, pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace)
+	}
+	if err != nil {
+		// the builder reports the fetch failure if it's really gone
+		e.logger.Warn("error looking up source archive", zap.String("archive_id", id),
+			zap.String("package_name", pkg.ObjectMeta.Name), zap.Error(err))
+	}
+	return ""
+}
+
+// sourceChecksumMismatch verifies the URL source archive against its
+// declared checksum and returns why the build must fail if they differ.
+// Sources without checksum or with credentials aren't verified, failing
+// downloads are left to the builder to report.
+func (e *buildExecution) sourceChecksumMismatch(ctx context.Context, pkg *fv1.Package) string {
+	src := pkg.Spec.Source
+	if src.Type != fv1.ArchiveTypeUrl || len(src.URL) == 0 {
+		return ""
+	}
+	if len(src.Checksum.Sum) == 0 {
+		e.logger.Info("source archive has no checksum, skipping verification",
+			zap.String("package_name", pkg.ObjectMeta.Name), zap.String("namespace", pkg.ObjectMeta.Namespace))
+		return ""
+	}
+	if len(src.AuthSecret) > 0 {
+		// the fetcher downloads it with the credentials of the auth
+		// secret and enforces its checksum
+		return ""
+	}
+	err := e.verifySource(ctx, src)
+	var mismatch *sourceChecksumError
+	if errors.As(err, &mismatch) {
+		e.logger.Error("source archive checksum mismatch", zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace), zap.String("checksum", mismatch.got),
+			zap.String("expected", mismatch.want))
+		return mismatch.Error()
+	}
+	if err != nil {
+		e.logger.Warn("error verifying source archive checksum", zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace), zap.Error(err))
+	}
+	return ""
+}
+
+func (e *buildExecution) setState(state buildState) {
+	if e.opts.onStateChange != nil {
+		e.opts.onStateChange(state)
+	}
+}
+
+func (e *buildExecution) setBuilderPod(pod *apiv1.Pod) {
+	e.mu.Lock()
+	e.builderPod = builderPodName(pod)
+	e.builderPodInfo = newBuilderPodInfo(pod)
+	e.mu.Unlock()
+	if e.opts.onBuilderPod != nil {
+		e.opts.onBuilderPod(builderPodName(pod))
+	}
+}
+
+// newBuilderPodInfo returns the name, node and builder image of the pod.
+func newBuilderPodInfo(pod *apiv1.Pod) *fv1.BuilderPodInfo {
+	info := &fv1.BuilderPodInfo{
+		Name:      pod.ObjectMeta.Name,
+		Namespace: pod.ObjectMeta.Namespace,
+		Node:      pod.Spec.NodeName,
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "builder" {
+			info.Image = container.Image
+		}
+	}
+	return info
+}
+
+func (e *buildExecution) run(ctx context.Context, srcpkg *fv1.Package) (BuildResult, error) {
+	attrs := []attribute.KeyValue{attribute.Int("attempt", e.opts.Attempt)}
+	if id, ok := ctx.Value(buildIDKey{}).(string); ok {
+		attrs = append(attrs, attribute.String("build-id", id))
+	}
+	ctx, span := otel.Tracer("fission-buildermgr").Start(ctx, "buildermgr/build",
+		trace.WithAttributes(otelUtils.GetAttributesForPackage(srcpkg)...),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+	e.logger = buildLogger(ctx, e.Logger)
+
+	// the package is marked as failed with the attempt context
+	// once the build deadline canceled the build context
+	attemptCtx := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if e.opts.Timeout > 0 {
+		timer := time.AfterFunc(e.opts.Timeout, func() { cancel(errBuildTimeout) })
+		defer timer.Stop()
+	}
+	e.ctx = ctx
+	ctx = withBuildReporter(ctx, e)
+	defer e.stopPhaseUpdates()
+
+	// the last successful build of an identical source with the same
+	// builder image left the deployment archive this one would build,
+	// dry runs check the build inputs anyway
+	if !e.opts.DryRun {
+		if last := e.unchangedSource(ctx, srcpkg); last != nil {
+			return e.skipUnchangedSource(ctx, srcpkg, last)
+		}
+	}
+
+	start := time.Now()
+	defer observeBuildDuration(srcpkg, start)
+
+	e.setState(buildStateRunning)
+	e.envName, e.envNamespace = srcpkg.Spec.Environment.Name, srcpkg.Spec.Environment.Namespace
+	e.startConditions(srcpkg)
+	e.logger.Info("starting build for package", zap.String("package_name", srcpkg.ObjectMeta.Name),
+		zap.String("resource_version", srcpkg.ObjectMeta.ResourceVersion), zap.Int("attempt", e.opts.Attempt),
+		zap.String("trigger", string(e.opts.Trigger)))
+	observeBuildStarted(srcpkg, e.opts.Trigger)
+	e.recordBuildStarted(srcpkg)
+
+	attemptLogs := e.opts.Logs + logf(logPhaseBuildermgr, "Build attempt %d/%d, triggered by %s", e.opts.Attempt, e.opts.MaxAttempts, e.opts.Trigger)
+	if storageSvcURL := e.resolveStorageSvc(srcpkg.ObjectMeta.Namespace); len(storageSvcURL) > 0 {
+		e.logger.Info("using storage service of package namespace", zap.String("package_name", srcpkg.ObjectMeta.Name),
+			zap.String("namespace", srcpkg.ObjectMeta.Namespace), zap.String("storage_svc_url", storageSvcURL))
+		attemptLogs += logf(logPhaseBuildermgr, "Using storage service %s of namespace %s", storageSvcURL, srcpkg.ObjectMeta.Namespace)
+	}
+	pkg, err := e.updatePackage(ctx, srcpkg, fv1.BuildStatusRunning, attemptLogs, nil)
+	if buildCanceled(ctx, e.Logger, srcpkg) {
+		return e.canceled(ctx, srcpkg, attemptLogs)
+	}
+	if err != nil {
+		e.logger.Error("error setting package running state", zap.Error(err))
+		return BuildResult{Package: srcpkg, Status: srcpkg.Status.BuildStatus, Logs: attemptLogs}, err
+	}
+	if e.opts.DryRun {
+		return e.dryRun(ctx, attemptCtx, pkg, attemptLogs)
+	}
+
+	env, err := e.FissionClient.CoreV1().Environments(pkg.Spec.Environment.Namespace).Get(ctx, pkg.Spec.Environment.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		msg := "environment does not exist"
+		e.logger.Error(msg, zap.String("environment", pkg.Spec.Environment.Name))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonEnvironmentNotFound,
+			fmt.Sprintf("%s: %q", msg, pkg.Spec.Environment.Name))
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logf(logPhaseBuildermgr, "%s: %q", msg, pkg.Spec.Environment.Name),
+			fv1.PackageReasonEnvironmentNotFound, permanentBuildError{errors.New(msg)})
+	} else if err != nil {
+		msg := "error getting environment"
+		e.logger.Error(msg, zap.String("environment", pkg.Spec.Environment.Name), zap.Error(err))
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logf(logPhaseBuildermgr, "%s: %v", msg, err), fv1.PackageReasonBuildFailed, err)
+	}
+
+	if msg := e.missingSourceArchive(ctx, pkg); len(msg) > 0 {
+		// the builder can't fetch a deleted archive, retrying doesn't help
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonSourceArchiveMissing,
+			permanentBuildError{errors.New(msg)})
+	}
+	if msg := e.sourceTooLarge(ctx, pkg, env); len(msg) > 0 {
+		// the builder would fetch it anyway, fail before it runs out
+		// of disk
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonArchiveTooLarge,
+			permanentBuildError{archiveTooLargeError{errors.New(msg)}})
+	}
+	if msg := e.sourceChecksumMismatch(ctx, pkg); len(msg) > 0 {
+		// building the wrong source is worse than not building
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonSourceChecksumMismatch,
+			permanentBuildError{errors.New(msg)})
+	}
+
+	e.builtSource = builtSource(pkg, env)
+	if e.opts.onBuilderImage != nil {
+		e.opts.onBuilderImage(env.Spec.Builder.Image)
+	}
+	builderNs := builderNamespace(e.NSResolver, env)
+	// the builder pods read the token from the secret copy of their
+	// namespace, it exists before build jobs are created
+	ctx, err = e.builderAuth.withToken(ctx, builderNs)
+	if err != nil {
+		e.logger.Error("error getting builder auth token", zap.Error(err), zap.String("environment", env.ObjectMeta.Name))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNotReady, err.Error())
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, err.Error()), fv1.PackageReasonBuilderNotReady, err)
+	}
+	waitStart := time.Now()
+	builder := e.builderFor(env)
+	defer builder.release(pkg)
+	var ready bool
+	ctx, ready, err = builder.acquire(ctx, pkg, env, builderNs)
+	if buildCanceled(ctx, e.Logger, pkg) {
+		return e.canceled(ctx, pkg, attemptLogs)
+	}
+	if errors.Is(err, errEnvironmentDeleted) {
+		msg := fmt.Sprintf("%v: %q", errEnvironmentDeleted, env.ObjectMeta.Name)
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonEnvironmentNotFound, msg)
+		return e.environmentDeletedFailure(ctx, attemptCtx, pkg, attemptLogs)
+	}
+	if errors.Is(err, errNoBuilderPodInformer) {
+		// the builder pods are never seen, retrying doesn't help
+		msg := fmt.Sprintf("builder namespace %q of environment %q is not watched by the builder manager", builderNs, env.ObjectMeta.Name)
+		e.logger.Error("builder namespace not watched", zap.String("environment", env.ObjectMeta.Name),
+			zap.String("builder_namespace", builderNs))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNamespaceNotWatched, msg)
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonBuilderNamespaceNotWatched,
+			permanentBuildError{errors.New(msg)})
+	}
+	var pullErr builderImagePullError
+	if errors.As(err, &pullErr) {
+		// the builder pod doesn't get ready until the image pull
+		// secrets of the environment let it pull its image
+		msg := pullErr.Error()
+		e.logger.Error("environment builder can't pull its image", zap.String("environment", env.ObjectMeta.Name),
+			zap.String("message", msg))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderImagePullFailed, msg)
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonBuilderImagePullFailed, pullErr)
+	}
+	var unschedulable builderUnschedulableError
+	if errors.As(err, &unschedulable) {
+		// reported with the builder wait timeout below
+		err = nil
+	}
+	if err != nil {
+		e.logger.Error("error getting environment builder", zap.Error(err), zap.String("environment", env.ObjectMeta.Name))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNotReady, err.Error())
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, err.Error()), fv1.PackageReasonBuilderNotReady, err)
+	}
+	if !ready {
+		waited := time.Since(waitStart).Round(time.Millisecond)
+		msg := fmt.Sprintf("Build timeout due to environment builder not ready after waiting %v", waited)
+		if unschedulable.error != nil {
+			// tells which scheduling constraints no node meets
+			msg += ": " + unschedulable.Error()
+		}
+		e.logger.Error("max retries exceeded in building source package, timeout due to environment builder not ready",
+			zap.String("package", fmt.Sprintf("%s.%s", pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace)),
+			zap.Duration("waited", waited))
+		e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, fv1.PackageReasonBuilderNotReady, msg)
+		return e.failed(ctx, attemptCtx, pkg, attemptLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonBuilderNotReady, errors.New(msg))
+	}
+
+	e.setState(buildStateRunning)
+	e.setPhase(fv1.BuildPhaseBuilding)
+	buildCtx := withUploadRetryPolicy(ctx, e.uploadRetry)
+	buildCtx = withArchiveSizeLimits(buildCtx, e.archiveLimits.forEnvironment(e.logger, env))
+	buildCtx = withBuildMetadata(buildCtx, buildMetadata(pkg, e.buildMetadataPrefix))
+	buildCtx = withCurrentDeployment(buildCtx, reusableDeployment(pkg, e.forceArchiveUpload))
+	built, err := e.runBuild(buildCtx, e.logger, e.FissionClient, builderNs, e.storageSvcURL, pkg)
+	observeBuildPhases(pkg, built, err)
+	logStorageSummary(e.logger, pkg, built)
+	if buildCanceled(ctx, e.Logger, pkg) {
+		return e.canceled(ctx, pkg, attemptLogs)
+	}
+	buildLogs := attemptLogs + built.logs
+	uploadResp := built.uploadResp
+	if err != nil {
+		e.logger.Error("error building package", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("phase", built.failedPhase), zap.Any("status_codes", built.statusCodes))
+		e.mu.Lock()
+		pod := e.builderPod
+		e.mu.Unlock()
+		if errors.Is(err, ErrBuilderUnreachable) {
+			// the builder pod may have died mid-build, e.g. out of
+			// memory, its container logs tell more than the dropped
+			// connection. The build context may be past its deadline.
+			buildLogs += builderPodLogs(attemptCtx, e.logger, e.k8sClient, pod, e.attemptStart, built.failedPhase)
+		}
+		var podReason, podMsg string
+		if errors.Is(err, ErrBuilderUnreachable) || errors.Is(err, ErrBuildFailed) {
+			podReason, podMsg = builderPodFailure(attemptCtx, e.logger, e.k8sClient, pod, e.attemptStart)
+		}
+		reason := fv1.PackageReasonBuildFailed
+		var tooLarge archiveTooLargeError
+		var timeout builderTimeoutError
+		var mismatch *builderClient.ProtocolMismatchError
+		switch {
+		case len(podReason) > 0:
+			// the pod tells why the build failed better than the
+			// dropped connection
+			reason = podReason
+			buildLogs += logLines(logPhaseBuildermgr, podMsg)
+			e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, reason, podMsg)
+			err = buildPhaseError{phase: built.failedPhase, class: ErrBuilderUnreachable, error: errors.New(podMsg)}
+			if reason == fv1.PackageReasonBuilderOOMKilled {
+				// the build needs as much memory again
+				err = permanentBuildError{err}
+			}
+		case errors.As(err, &mismatch):
+			// the builder answered, it won't understand the next attempt
+			// either
+			reason = fv1.PackageReasonBuilderProtocolMismatch
+			msg := protocolMismatchMessage(mismatch, pod)
+			buildLogs += logLines(logPhaseBuild, msg)
+			e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, reason, msg)
+			err = buildPhaseError{phase: built.failedPhase, class: ErrProtocolMismatch, error: errors.New(msg)}
+		case isSourceFetchError(err):
+			reason = fv1.PackageReasonSourceFetchFailed
+		case errors.As(err, &tooLarge):
+			reason = fv1.PackageReasonArchiveTooLarge
+		case errors.As(err, &timeout):
+			reason = fv1.PackageReasonBuildTimeout
+		case errors.Is(err, ErrBuilderUnreachable):
+			// the pod was ready when the build started
+			reason = fv1.PackageReasonBuilderUnreachable
+			e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionFalse, reason,
+				fmt.Sprintf("builder pod didn't answer the %s request", built.failedPhase))
+		case errors.Is(err, ErrUploadFailed):
+			reason = fv1.PackageReasonUploadFailed
+		}
+		return e.failed(ctx, attemptCtx, pkg, buildLogs, reason, err)
+	}
+
+	// an unchanged archive isn't uploaded, the current deployment archive
+	// is kept and the functions are already using it
+	unchanged := uploadResp.Unchanged
+	artifactSize := uploadResp.StoredSize
+	if unchanged {
+		uploadResp = nil
+		artifactSize = pkg.Spec.Deployment.StoredSize
+		if artifactSize == 0 {
+			artifactSize = pkg.Spec.Deployment.Size
+		}
+	}
+
+	// functions must not be bumped to an archive fetchers can't download
+	if !e.opts.SkipArchiveCheck && !unchanged {
+		err = e.ensureArchiveFetchable(ctx, pkg, uploadResp)
+		if buildCanceled(ctx, e.Logger, pkg) {
+			return e.canceled(ctx, pkg, buildLogs)
+		}
+		if err != nil {
+			artifactUnavailable.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace).Inc()
+			msg := fmt.Sprintf("%s: deployment archive %s is not downloadable: %v",
+				reasonArtifactUnavailable, uploadResp.ArchiveDownloadUrl, err)
+			var verifyErr *archiveVerificationError
+			if errors.As(err, &verifyErr) {
+				msg = fmt.Sprintf("%s: %v, archive %s", reasonArtifactUnavailable, verifyErr, uploadResp.ArchiveDownloadUrl)
+			}
+			e.logger.Error("deployment archive not downloadable", zap.Error(err),
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("url", uploadResp.ArchiveDownloadUrl))
+			buildLogs += logLines(logPhaseUpload, msg)
+			// storage service replication may still catch up, only a
+			// checksum mismatch isn't worth another attempt
+			buildErr := errors.New(msg)
+			if isPermanentBuildError(err) {
+				buildErr = permanentBuildError{buildErr}
+			}
+			return e.failed(ctx, attemptCtx, pkg, buildLogs, fv1.PackageReasonArtifactUnavailable, buildErr)
+		}
+	}
+
+	// functions must not be bumped to a build of a deleted environment
+	if e.environmentDeleted(ctx, env) {
+		return e.environmentDeletedFailure(ctx, attemptCtx, pkg, buildLogs)
+	}
+
+	e.logger.Info("starting package info update", zap.String("package_name", pkg.ObjectMeta.Name))
+
+	var updatedFunctions []string
+	switch {
+	case unchanged:
+		e.setCondition(fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonArtifactUnchanged,
+			"deployment archive unchanged, functions keep using it")
+	case !e.opts.SkipFunctionUpdate:
+		e.setPhase(fv1.BuildPhaseUpdatingFunctions)
+		updatedFunctions, err = e.updateFunctions(ctx, e.latestPackage())
+		if err != nil {
+			buildLogs += logLines(logPhaseBuildermgr, err.Error())
+			e.setCondition(fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonFunctionUpdateFailed, err.Error())
+			return e.failed(ctx, attemptCtx, pkg, buildLogs, fv1.PackageReasonFunctionUpdateFailed, err)
+		}
+		e.setCondition(fv1.PackageConditionFunctionsUpdated, metav1.ConditionTrue, fv1.PackageReasonFunctionsUpdated,
+			fmt.Sprintf("%d functions updated to the new build", len(updatedFunctions)))
+	default:
+		e.setCondition(fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonFunctionUpdateSkipped,
+			"function update skipped for this build")
+	}
+
+	updated, err := e.updatePackage(ctx, pkg, fv1.BuildStatusSucceeded, buildLogs, uploadResp)
+	if err != nil {
+		e.logger.Error("error updating package info", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name))
+		return e.failed(ctx, attemptCtx, pkg, buildLogs, fv1.PackageReasonBuildFailed, err)
+	}
+
+	observeBuildResult(pkg, buildResultSucceeded)
+	e.logger.Info("completed package build request", zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.Bool("artifact_unchanged", unchanged))
+	return BuildResult{
+		Package:          updated,
+		Status:           fv1.BuildStatusSucceeded,
+		Logs:             buildLogs,
+		Deployment:       updated.Spec.Deployment.DeepCopy(),
+		UpdatedFunctions: updatedFunctions,
+		ResourceUsage:    updated.Status.BuildResourceUsage.DeepCopy(),
+		ArtifactSize:     artifactSize,
+
+		SourceFetchFailures: e.fetchFailureCount(),
+	}, nil
+}
+
+// environmentDeleted tells whether the environment of the build was deleted
+// since the build started, a recreated environment is another one. Lookup
+// errors are taken as the environment still existing.
+func (e *buildExecution) environmentDeleted(ctx context.Context, env *fv1.Environment) bool {
+	current, err := e.FissionClient.CoreV1().Environments(env.ObjectMeta.Namespace).Get(ctx, env.ObjectMeta.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		e.logger.Debug("error looking up environment of running build", zap.String("environment", env.ObjectMeta.Name), zap.Error(err))
+		return false
+	}
+	return current.ObjectMeta.UID != env.ObjectMeta.UID
+}
+
+// environmentDeletedFailure fails the build whose environment was deleted
+// while it waited for its builder or ran, retrying doesn't help.
+func (e *buildExecution) environmentDeletedFailure(ctx, attemptCtx context.Context, pkg *fv1.Package, buildLogs string) (BuildResult, error) {
+	e.logger.Error("environment deleted during build", zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace), zap.String("environment", pkg.Spec.Environment.Name))
+	msg := fmt.Sprintf("%v: %q", errEnvironmentDeleted, pkg.Spec.Environment.Name)
+	return e.failed(ctx, attemptCtx, pkg, buildLogs+logLines(logPhaseBuildermgr, msg), fv1.PackageReasonEnvironmentNotFound,
+		permanentBuildError{errors.Wrap(errEnvironmentDeleted, pkg.Spec.Environment.Name)})
+}
+
+// waitForBuilder waits for a ready builder pod of the environment, it
+// returns nil if none got ready before the health check backoff or its
+// max wait ran out, with the error of the builder pod if it was stuck
+// pulling its image or unschedulable. The waits between the health checks end early once the
+// pod informers report a ready builder pod of the environment. The pod with
+// the fewest builds in flight is picked among the ready ones, the build is
+// counted on it until the caller releases it from the builder load.
+func (e *buildExecution) waitForBuilder(ctx context.Context, pkg *fv1.Package, env *fv1.Environment, builderNs string) (*apiv1.Pod, error) {
+	waitStart := time.Now()
+	e.setState(buildStateWaitingForBuilder)
+	e.setPhase(fv1.BuildPhaseWaitingForBuilder)
+
+	// Create a new BackOff for health check on environment builder pod
+	healthCheckBackOff, err := utils.NewBackOff(e.builderBackoff.InitialInterval, e.builderBackoff.MaxInterval,
+		e.builderBackoff.Multiplier, utils.DefaultMaxCount)
+	if err != nil {
+		return nil, err
+	}
+	maxAttempts := int32(healthCheckBackOff.RemainingCount()) + 1
+	// the next build phase update clears the wait
+	defer e.setBuilderWait(nil)
+	key := envBuilderKey(env, builderNs)
+	// the builder pod last seen not ready
+	var stuck *apiv1.Pod
+	for healthCheckBackOff.NextExists() {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		if _, ok := e.builderBackoff.remaining(waitStart, 0); !ok {
+			return nil, builderWaitFailure(stuck)
+		}
+
+		// wait for readiness before looking for a ready pod, so that
+		// a pod getting ready in between ends the wait
+		ready, stopWaiting := e.builderReadyNotification(key)
+		attempt := int32(healthCheckBackOff.GetCurrentCount()) + 1
+		// the build started with the environment, it may be deleted
+		// while its builder is awaited
+		if attempt > 1 && e.environmentDeleted(ctx, env) {
+			stopWaiting()
+			return nil, errEnvironmentDeleted
+		}
+		pods, err := e.Pods.ListBuilderPods(builderNs, env)
+		if err != nil {
+			stopWaiting()
+			return nil, err
+		}
+		if len(pods) == 0 {
+			stuck = nil
+			e.logger.Info("builder pod does not exist for environment, will retry again later", zap.String("environment", pkg.Spec.Environment.Name))
+			wait, _ := e.builderBackoff.remaining(waitStart, healthCheckBackOff.GetCurrentBackoffDuration())
+			e.setBuilderWait(newBuilderWait(attempt, maxAttempts, wait))
+			waitReady(ctx, ready, wait)
+			stopWaiting()
+			continue
+		}
+
+		var readyPods []*apiv1.Pod
+		var notReady *apiv1.Pod
+		for _, pod := range pods {
+			// Filter non-matching pods, and those of builders being
+			// drained after a builder change
+			if podBuilderKey(pod) != key || !builderPodMatches(pod, env) || builderDraining(&pod.ObjectMeta) {
+				continue
+			}
+			if builderPodReady(pod) && !e.builderUnreachable(pod) {
+				readyPods = append(readyPods, pod)
+			} else if notReady == nil {
+				notReady = pod
+			}
+		}
+		stuck = notReady
+
+		if pod := e.builderLoad.acquire(readyPods); pod != nil {
+			stopWaiting()
+			e.setBuilderPod(pod)
+			observeBuilderWait(pkg, waitStart)
+			e.setCondition(fv1.PackageConditionBuilderReady, metav1.ConditionTrue, fv1.PackageReasonBuilderPodReady,
+				fmt.Sprintf("builder pod %s is ready", pod.ObjectMeta.Name))
+			return pod, nil
+		}
+		if notReady != nil {
+			e.setBuilderPod(notReady)
+			e.logger.Info("builder pod is not ready for environment, will retry again later", zap.String("environment", pkg.Spec.Environment.Name))
+			// the backoff below follows this wait
+			current, _ := e.builderBackoff.remaining(waitStart, healthCheckBackOff.GetCurrentBackoffDuration())
+			next := time.Duration(float64(healthCheckBackOff.GetCurrentBackoffDuration()) * healthCheckBackOff.GetMultiplier())
+			total, _ := e.builderBackoff.remaining(waitStart, current+next)
+			e.setBuilderWait(newBuilderWait(attempt, maxAttempts, total))
+			waitReady(ctx, ready, current)
+		}
+		next := healthCheckBackOff.GetNext()
+		if wait, ok := e.builderBackoff.remaining(waitStart, next); ok {
+			waitReady(ctx, ready, wait)
+		}
+		stopWaiting()
+	}
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	return nil, builderWaitFailure(stuck)
+}
+
+// builderReadyNotification returns a channel closed once a builder pod of
+// the key becomes ready, and the function to call once done waiting. The
+// channel is nil without readiness notifications, the waits then run to
+// their end.
+func (e *buildExecution) builderReadyNotification(key builderKey) (<-chan struct{}, func()) {
+	if e.builderReady == nil {
+		return nil, func() {}
+	}
+	return e.builderReady.ready(key)
+}
+
+// updateFunctions bumps the functions using the package to its resource
+// version, it returns the names of the updated functions, functions of other
+// namespaces included. Functions changed
+// meanwhile by someone else are read again and patched on conflicts, and
+// the failed updates of some functions don't hold back the others.
+func (e *buildExecution) updateFunctions(ctx context.Context, pkg *fv1.Package) ([]string, error) {
+	fns, err := e.packageFunctions(ctx, pkg)
+	if err != nil {
+		msg := "error getting function list"
+		e.logger.Error(msg, zap.Error(err))
+		return nil, errors.Wrap(err, msg)
+	}
+
+	// A package may be used by multiple functions. Update
+	// functions with old package resource version
+	var updated []string
+	errs := utils.MultiErrorWithFormat()
+	for _, fn := range fns {
+		if fn.Spec.Package.PackageRef.ResourceVersion == pkg.ObjectMeta.ResourceVersion {
+			continue
+		}
+		ok, err := e.updateFunction(ctx, fn, pkg)
+		if err != nil {
+			msg := "error updating function package resource version"
+			e.logger.Error(msg, zap.String("function", fn.ObjectMeta.Name), zap.Error(err))
+			errs = multierror.Append(errs, errors.Wrapf(err, "%s: function %s", msg, fn.ObjectMeta.Name))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		packageRefUpdates.WithLabelValues(fn.ObjectMeta.Namespace).Inc()
+		name := fn.ObjectMeta.Name
+		if fn.ObjectMeta.Namespace != pkg.ObjectMeta.Namespace {
+			name = fn.ObjectMeta.Namespace + "/" + name
+		}
+		updated = append(updated, name)
+	}
+	return updated, errs.ErrorOrNil()
+}
+
+// packageFunctions returns the functions bumped after builds of the
+// package, whatever their namespace among the fission resource namespaces.
+// They're looked up in the package reference index of the function
+// informers, and listed from the API server in the namespaces whose informer
+// cache isn't synced.
+func (e *buildExecution) packageFunctions(ctx context.Context, pkg *fv1.Package) ([]*fv1.Function, error) {
+	namespaces := map[string]struct{}{pkg.Namespace: {}}
+	for _, ns := range e.NSResolver.FissionResourceNS {
+		namespaces[ns] = struct{}{}
+	}
+	var fns []*fv1.Function
+	for ns := range namespaces {
+		nsFns, err := e.namespacePackageFunctions(ctx, ns, pkg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "namespace %s", ns)
+		}
+		fns = append(fns, nsFns...)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if fns[i].ObjectMeta.Namespace != fns[j].ObjectMeta.Namespace {
+			return fns[i].ObjectMeta.Namespace < fns[j].ObjectMeta.Namespace
+		}
+		return fns[i].ObjectMeta.Name < fns[j].ObjectMeta.Name
+	})
+	return fns, nil
+}
+
+// namespacePackageFunctions returns the functions of the namespace bumped
+// after builds of the package.
+func (e *buildExecution) namespacePackageFunctions(ctx context.Context, namespace string, pkg *fv1.Package) ([]*fv1.Function, error) {
+	if informer, ok := e.fnInformer[namespace]; ok && informer.HasSynced() {
+		items, err := informer.GetIndexer().ByIndex(functionPackageIndex, functionPackageIndexKey(pkg.Namespace, pkg.Name))
+		if err == nil {
+			var fns []*fv1.Function
+			for _, item := range items {
+				if fn, ok := item.(*fv1.Function); ok && updatedOnRebuild(fn, pkg) {
+					fns = append(fns, fn.DeepCopy())
+				}
+			}
+			return fns, nil
+		}
+		e.logger.Warn("error looking up functions in the function informer, listing them",
+			zap.String("namespace", namespace), zap.Error(err))
+	}
+
+	fnList, err := e.FissionClient.CoreV1().Functions(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var fns []*fv1.Function
+	for i := range fnList.Items {
+		if updatedOnRebuild(&fnList.Items[i], pkg) {
+			fns = append(fns, &fnList.Items[i])
+		}
+	}
+	return fns, nil
+}
+
+// functionPackageRefPatch is the merge patch of the package reference
+// resource version of a function. The function resource version makes the
+// patch fail with a conflict if the function changed meanwhile.
+type functionPackageRefPatch struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		Package struct {
+			PackageRef struct {
+				ResourceVersion string `json:"resourceversion"`
+			} `json:"packageref"`
+		} `json:"package"`
+	} `json:"spec"`
+}
+
+// packageRefPatch returns the patch bumping the function to the package
+// resource version.
+func packageRefPatch(fn *fv1.Function, pkg *fv1.Package) ([]byte, error) {
+	var patch functionPackageRefPatch
+	patch.Metadata.ResourceVersion = fn.ObjectMeta.ResourceVersion
+	patch.Spec.Package.PackageRef.ResourceVersion = pkg.ObjectMeta.ResourceVersion
+	return json.Marshal(patch)
+}
+
+// updateFunction bumps the function to the package resource version with a
+// patch of its package reference alone, it returns whether the function was
+// updated. On conflicts the function is read again and patched again, unless
+// the function moved to another package or version meanwhile.
+func (e *buildExecution) updateFunction(ctx context.Context, fn *fv1.Function, pkg *fv1.Package) (bool, error) {
+	functions := e.FissionClient.CoreV1().Functions(fn.ObjectMeta.Namespace)
+	updated := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch, err := packageRefPatch(fn, pkg)
+		if err != nil {
+			return err
+		}
+		_, err = functions.Patch(ctx, fn.ObjectMeta.Name, k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+		if err == nil {
+			updated = true
+			return nil
+		}
+		if !k8serrors.IsConflict(err) {
+			return err
+		}
+		latest, getErr := functions.Get(ctx, fn.ObjectMeta.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		if !updatedOnRebuild(latest, pkg) || latest.Spec.Package.PackageRef.ResourceVersion == pkg.ObjectMeta.ResourceVersion {
+			return nil
+		}
+		fn = latest
+		return err
+	})
+	return updated, err
+}
+
+// canceled returns the result of a canceled build, the package is left as is.
+func (e *buildExecution) canceled(ctx context.Context, pkg *fv1.Package, buildLogs string) (BuildResult, error) {
+	// build phase updates may have written a newer version
+	if latest := e.latestPackage(); latest != nil {
+		pkg = latest
+	}
+	return BuildResult{Package: pkg, Status: pkg.Status.BuildStatus, Logs: buildLogs, Canceled: true},
+		errors.Wrap(context.Cause(ctx), "build canceled")
+}
+
+// failed handles a failed build attempt. Transient failures are marked to be
+// retried until the package runs out of attempts, the package is marked as
+// failed then. The reason is the reason of the BuildSucceeded condition.
+func (e *buildExecution) failed(ctx, attemptCtx context.Context, pkg *fv1.Package, buildLogs string, reason string, err error) (BuildResult, error) {
+	// errors of canceled builds are caused by the cancellation
+	if buildCanceled(ctx, e.Logger, pkg) {
+		return e.canceled(ctx, pkg, buildLogs)
+	}
+	result := buildResultFailed
+	if buildTimedOut(ctx) {
+		result = buildResultTimeout
+		reason = fv1.PackageReasonBuildTimeout
+		// whatever failed, the deadline is the cause. The build context
+		// is done, update the package with the context of the attempt.
+		ctx = attemptCtx
+		if len(e.opts.TimeoutLimit) > 0 {
+			buildLogs += logf(logPhaseBuildermgr, "Build exceeded timeout of %v set by the %s", e.opts.Timeout, e.opts.TimeoutLimit)
+		} else {
+			buildLogs += logf(logPhaseBuildermgr, "Build exceeded timeout of %v", e.opts.Timeout)
+		}
+		e.logger.Error("build exceeded timeout",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Duration("timeout", e.opts.Timeout))
+		err = permanentBuildError{errBuildTimeout}
+	}
+	e.attemptFailed(reason, err)
+	if isPermanentBuildError(err) || e.opts.Attempt >= e.opts.MaxAttempts {
+		observeBuildResult(pkg, result)
+		e.setCondition(fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, reason, err.Error())
+		updated, er := e.updatePackage(ctx, pkg, fv1.BuildStatusFailed, buildLogs, nil)
+		if er != nil {
+			e.logger.Error(
+				"error updating package",
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
+				zap.Error(er),
+			)
+			updated = pkg
+		}
+		return BuildResult{Package: updated, Status: fv1.BuildStatusFailed, Logs: buildLogs,
+			SourceFetchFailures: e.fetchFailureCount()}, err
+	}
+
+	buildLogs += logf(logPhaseBuildermgr, "Build attempt %d/%d failed, retrying in %v", e.opts.Attempt, e.opts.MaxAttempts, e.opts.RetryDelay)
+	e.logger.Info("retrying failed package build",
+		zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace),
+		zap.Int("attempt", e.opts.Attempt),
+		zap.Int("max_attempts", e.opts.MaxAttempts),
+		zap.Duration("delay", e.opts.RetryDelay),
+		zap.Error(err))
+
+	// keep the package running, it's only failed after the last attempt
+	e.setCondition(fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildRetrying,
+		fmt.Sprintf("build attempt %d/%d failed, retrying in %v: %v", e.opts.Attempt, e.opts.MaxAttempts, e.opts.RetryDelay, err))
+	updated, er := e.updatePackage(ctx, pkg, fv1.BuildStatusRunning, buildLogs, nil)
+	if er != nil {
+		e.logger.Error(
+			"error updating package",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
+			zap.Error(er),
+		)
+		return BuildResult{Package: pkg, Status: pkg.Status.BuildStatus, Logs: buildLogs}, err
+	}
+	return BuildResult{Package: updated, Status: fv1.BuildStatusRunning, Logs: buildLogs, Retry: true,
+		SourceFetchFailures: e.fetchFailureCount()}, err
+}
+
+// fetchFailureCount returns the number of failed source fetches of the build.
+func (e *buildExecution) fetchFailureCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sourceFetchFailures
+}
+
+// updatePackage updates the package with the build status and the phase the
+// build is at, a succeeded build has none. The complete build logs of a
+// finished build are persisted to the storage service and only their tail is
+// kept in the status, the log object of the previous build is deleted once
+// replaced. Build logs beyond the size limit are
+// truncated so that the update doesn't fail because of them.
+func (e *buildExecution) updatePackage(ctx context.Context, pkg *fv1.Package, status fv1.BuildStatus, buildLogs string,
+	uploadResp *fetcher.ArchiveUploadResponse) (*fv1.Package, error) {
+	// a canceled build must leave the package alone, e.g. the package was
+	// deleted or a newer build owns its status now
+	if ctx.Err() != nil {
+		return nil, errors.Wrap(context.Cause(ctx), "build canceled, package not updated")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if status == fv1.BuildStatusSucceeded {
+		e.phase = ""
+	}
+	e.syncBuildCondition(status)
+	if e.opts.SkipPackageUpdate {
+		pkg = recordBuildStatus(pkg, status, buildLogs, uploadResp)
+		if status == fv1.BuildStatusSucceeded {
+			pkg.Status.BuiltSource = e.builtSource.DeepCopy()
+		}
+		pkg.Status.BuildTrigger = e.opts.Trigger
+		e.setBuildTiming(&pkg.Status)
+		pkg.Status.BuildPhase = e.phase
+		pkg.Status.Conditions = copyConditions(e.conditions)
+		pkg.Status.BuildResourceUsage = e.resourceUsage.DeepCopy()
+		pkg.Status.BuilderPod = e.builderPodInfo.DeepCopy()
+		pkg.Status.SourceCommit = e.statusSourceCommit(pkg)
+		pkg.Status.BuildHistory = e.buildHistory(pkg, status)
+		return pkg, nil
+	}
+	// build phase updates may have written a newer version
+	if e.pkg != nil {
+		pkg = e.pkg
+	}
+	pkg = pkg.DeepCopy()
+
+	oldLogURL := pkg.Status.BuildLogURL
+	logURL := oldLogURL
+	var persisted bool
+	if status == fv1.BuildStatusSucceeded || status == fv1.BuildStatusFailed {
+		var err error
+		logURL, err = e.persistBuildLogs(ctx, e.logger, e.storageSvcURL, pkg, buildLogs)
+		if err != nil {
+			// the inline logs are all there is then
+			e.logger.Warn("error persisting build logs, keeping them in package status only",
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("namespace", pkg.ObjectMeta.Namespace),
+				zap.Error(err))
+		} else {
+			persisted = true
+		}
+	}
+	buildLogs = renderBuildLog(e.logger, pkg, buildLogs, persisted, e.opts.MaxBuildLogSize)
+	pkgStatus := fv1.PackageStatus{
+		BuildStatus:        status,
+		BuildPhase:         e.phase,
+		BuildTrigger:       e.opts.Trigger,
+		BuildLog:           buildLogs,
+		BuildLogURL:        logURL,
+		Conditions:         copyConditions(e.conditions),
+		BuildResourceUsage: e.resourceUsage.DeepCopy(),
+		BuilderPod:         e.builderPodInfo.DeepCopy(),
+		BuiltSource:        pkg.Status.BuiltSource,
+		SourceCommit:       e.statusSourceCommit(pkg),
+		BuildHistory:       e.buildHistory(pkg, status),
+	}
+	if status == fv1.BuildStatusSucceeded {
+		pkgStatus.BuiltSource = e.builtSource.DeepCopy()
+	}
+	e.setBuildTiming(&pkgStatus)
+	updated, err := updatePackage(ctx, e.logger, e.FissionClient, pkg, pkgStatus, uploadResp)
+	if err == nil {
+		e.wrotePackage(updated, e.phase)
+	}
+	if logURL == oldLogURL {
+		return updated, err
+	}
+	if err != nil {
+		// the package doesn't reference the new log object
+		e.deleteBuildLog(ctx, e.logger, logURL)
+		return nil, err
+	}
+	if len(oldLogURL) > 0 {
+		e.deleteBuildLog(ctx, e.logger, oldLogURL)
+	}
+	return updated, nil
+}
+
+// statusSourceCommit returns the source commit of the package status: the
+// one of this build once the git source is fetched, the one of the last
+// build until then. e.mu must be held.
+func (e *buildExecution) statusSourceCommit(pkg *fv1.Package) string {
+	if pkg.Spec.Source.Type != fv1.ArchiveTypeGit {
+		return ""
+	}
+	if len(e.sourceCommit) > 0 {
+		return e.sourceCommit
+	}
+	return pkg.Status.SourceCommit
+}
+
+// setBuildTiming sets the start time and attempts of the build in the
+// status, and its completion time and duration once it's finished.
+func (e *buildExecution) setBuildTiming(status *fv1.PackageStatus) {
+	status.BuildStartTime = &metav1.Time{Time: e.opts.StartTime.UTC()}
+	status.BuildAttempts = int32(e.opts.Attempt)
+	if status.BuildStatus == fv1.BuildStatusSucceeded || status.BuildStatus == fv1.BuildStatusFailed {
+		now := time.Now()
+		status.BuildCompletionTime = &metav1.Time{Time: now.UTC()}
+		status.BuildDurationSeconds = int64(now.Sub(e.opts.StartTime).Round(time.Second) / time.Second)
+	}
+}
+
+// recordBuildStatus returns a copy of the package with the build status
+// and deployment archive, without updating the package resource. The log
+// and timing of the last finished build are kept.
+func recordBuildStatus(pkg *fv1.Package, status fv1.BuildStatus, buildLogs string,
+	uploadResp *fetcher.ArchiveUploadResponse) *fv1.Package {
+	pkg = pkg.DeepCopy()
+	if uploadResp != nil {
+		pkg.Spec.Deployment = deploymentArchive(uploadResp)
+	}
+	pkg.Status = fv1.PackageStatus{
+		BuildStatus:          status,
+		BuildLog:             buildLogs,
+		BuildLogURL:          pkg.Status.BuildLogURL,
+		BuildCompletionTime:  pkg.Status.BuildCompletionTime,
+		BuildDurationSeconds: pkg.Status.BuildDurationSeconds,
+		BuiltSource:          pkg.Status.BuiltSource,
+		LastUpdateTimestamp:  metav1.Time{Time: time.Now().UTC()},
+	}
+	return pkg
+}
+
+// ensureArchiveFetchable waits briefly for the deployment archive to be
+// downloadable, e.g. for the storage replication to catch up.
+func (e *buildExecution) ensureArchiveFetchable(ctx context.Context, pkg *fv1.Package, uploadResp *fetcher.ArchiveUploadResponse) error {
+	delay := e.archiveCheckDelay
+	var err error
+	for attempt := 1; attempt <= e.archiveCheckAttempts; attempt++ {
+		err = e.checkArchive(ctx, uploadResp)
+		if err == nil || ctx.Err() != nil || isPermanentBuildError(err) {
+			return err
+		}
+		e.logger.Info("deployment archive not downloadable yet",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.String("url", uploadResp.ArchiveDownloadUrl),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		if attempt < e.archiveCheckAttempts {
+			sleepWithContext(ctx, delay)
+			if ctx.Err() != nil {
+				return err
+			}
+			delay *= 2
+		}
+	}
+	return err
+}
@@ -0,0 +1,1440 @@
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	k8sTesting "k8s.io/client-go/testing"
+	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+type testPodLister struct {
+	pods []*apiv1.Pod
+	err  error
+	// listed is called on every lookup, optional
+	listed func()
+}
+
+func (l *testPodLister) ListBuilderPods(namespace string, env *fv1.Environment) ([]*apiv1.Pod, error) {
+	if l.listed != nil {
+		l.listed()
+	}
+	return l.pods, l.err
+}
+
+type testBuild struct {
+	deps          BuildDeps
+	fissionClient *fClient.Clientset
+	pods          *testPodLister
+	store         *fakeArchiveStore
+	env           *fv1.Environment
+	pkg           *fv1.Package
+	fn            *fv1.Function
+	// builds counts the buildPackage calls, checks the archive checks
+	builds int
+	checks int
+}
+
+// newTestBuild returns the dependencies of a build of the test package with
+// a ready builder pod and a function using the package. The builds succeed.
+func newTestBuild(t *testing.T) *testBuild {
+	t.Helper()
+	env := testEnvironment()
+	pkg := testPackage()
+	fn := &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fn", Namespace: testNamespace},
+		Spec: fv1.FunctionSpec{
+			Package: fv1.FunctionPackageRef{
+				PackageRef: fv1.PackageRef{Name: testPkgName, Namespace: testNamespace},
+			},
+		},
+	}
+	tb := &testBuild{
+		fissionClient: fClient.NewSimpleClientset(env, pkg, fn),
+		pods:          &testPodLister{pods: []*apiv1.Pod{testBuilderPod(env)}},
+		store:         &fakeArchiveStore{},
+		env:           env,
+		pkg:           pkg,
+		fn:            fn,
+	}
+	tb.deps = BuildDeps{
+		Logger:        loggerfactory.GetLogger(),
+		FissionClient: tb.fissionClient,
+		StorageSvcURL: "http://storagesvc",
+		Pods:          tb.pods,
+		buildPackage: func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+			storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+			tb.builds++
+			reportBuildPhase(ctx, fv1.BuildPhaseUploading)
+			return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+		},
+		checkArchive: func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+			tb.checks++
+			return nil
+		},
+		verifySource: func(ctx context.Context, archive fv1.Archive) error {
+			return nil
+		},
+		probeBuilder: func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+			return nil
+		},
+		archiveCheckDelay: time.Millisecond,
+		logStore:          tb.store,
+	}
+	return tb
+}
+
+func (tb *testBuild) getPackage(t *testing.T) *fv1.Package {
+	t.Helper()
+	pkg, err := tb.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	return pkg
+}
+
+func (tb *testBuild) functionResourceVersion(t *testing.T) string {
+	t.Helper()
+	fn, err := tb.fissionClient.CoreV1().Functions(testNamespace).Get(context.Background(), tb.fn.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting function: %v", err)
+	}
+	return fn.Spec.Package.PackageRef.ResourceVersion
+}
+
+func (tb *testBuild) countWrites(resource string) int {
+	count := 0
+	for _, action := range tb.fissionClient.Actions() {
+		if (action.GetVerb() == "update" || action.GetVerb() == "patch") && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+// statusPhases returns the build phases of the package status writes.
+func (tb *testBuild) statusPhases() []fv1.BuildPhase {
+	var phases []fv1.BuildPhase
+	for _, action := range tb.fissionClient.Actions() {
+		update, ok := action.(k8sTesting.UpdateAction)
+		if !ok || action.GetResource().Resource != "packages" || action.GetSubresource() != "status" {
+			continue
+		}
+		phases = append(phases, update.GetObject().(*fv1.Package).Status.BuildPhase)
+	}
+	return phases
+}
+
+// checkCondition checks the status and reason of the package condition.
+func checkCondition(t *testing.T, pkg *fv1.Package, condType string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	cond := meta.FindStatusCondition(pkg.Status.Conditions, condType)
+	if cond == nil {
+		t.Errorf("Expected %s condition, got %+v", condType, pkg.Status.Conditions)
+		return
+	}
+	if cond.Status != status || cond.Reason != reason {
+		t.Errorf("Expected %s condition %s with reason %s, got %s %s: %s", condType, status, reason, cond.Status, cond.Reason, cond.Message)
+	}
+	if cond.LastTransitionTime.IsZero() || cond.ObservedGeneration != pkg.ObjectMeta.Generation {
+		t.Errorf("Expected %s condition with transition time and generation %d, got %+v", condType, pkg.ObjectMeta.Generation, cond)
+	}
+}
+
+func (tb *testBuild) failBuilds(err error) {
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		return nil, "build failed\n", err
+	}
+}
+
+func TestExecuteBuildSucceeded(t *testing.T) {
+	tb := newTestBuild(t)
+	var states []buildState
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{
+		onStateChange: func(state buildState) { states = append(states, state) },
+	})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+
+	if result.Status != fv1.BuildStatusSucceeded || result.Retry {
+		t.Errorf("Expected succeeded build, got %s retry %v", result.Status, result.Retry)
+	}
+	if result.Deployment == nil || result.Deployment.URL != "http://storagesvc/deploy" {
+		t.Errorf("Expected deployment archive in result, got %+v", result.Deployment)
+	}
+	if !reflect.DeepEqual(result.UpdatedFunctions, []string{"test-fn"}) {
+		t.Errorf("Expected updated functions [test-fn], got %v", result.UpdatedFunctions)
+	}
+	if !strings.HasPrefix(result.Logs, "[") || !strings.Contains(result.Logs, " buildermgr] Build attempt 1/1, triggered by Unknown\n") ||
+		!strings.HasSuffix(result.Logs, "build succeeded\n") {
+		t.Errorf("Unexpected build logs %q", result.Logs)
+	}
+	expectedStates := []buildState{buildStateRunning, buildStateWaitingForBuilder, buildStateRunning}
+	if !reflect.DeepEqual(states, expectedStates) {
+		t.Errorf("Expected build states %v, got %v", expectedStates, states)
+	}
+	if tb.builds != 1 || tb.checks != 1 {
+		t.Errorf("Expected 1 build and 1 archive check, got %d and %d", tb.builds, tb.checks)
+	}
+
+	pkg := tb.getPackage(t)
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded || pkg.Spec.Deployment.URL != "http://storagesvc/deploy" {
+		t.Errorf("Expected succeeded package with deployment archive, got %s %q", pkg.Status.BuildStatus, pkg.Spec.Deployment.URL)
+	}
+	if pkg.Status.BuildLogURL == "" || tb.store.uploads != 1 {
+		t.Errorf("Expected persisted build log, got URL %q after %d uploads", pkg.Status.BuildLogURL, tb.store.uploads)
+	}
+	if rv := tb.functionResourceVersion(t); rv == "" {
+		t.Error("Expected function to be bumped to the new build")
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionTrue, fv1.PackageReasonBuilderPodReady)
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionTrue, fv1.PackageReasonBuildSucceeded)
+	checkCondition(t, pkg, fv1.PackageConditionFunctionsUpdated, metav1.ConditionTrue, fv1.PackageReasonFunctionsUpdated)
+}
+
+func TestExecuteBuildRecordsResourceUsage(t *testing.T) {
+	buildCPUSeconds.Reset()
+	buildPeakMemory.Reset()
+
+	tb := newTestBuild(t)
+	// a builder reporting the resource usage of the build command
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		reportBuildResourceUsage(ctx, &fv1.BuildResourceUsage{CPUMilliseconds: 1500, PeakMemoryBytes: 100 << 20})
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+
+	expected := &fv1.BuildResourceUsage{CPUMilliseconds: 1500, PeakMemoryBytes: 100 << 20}
+	if !reflect.DeepEqual(result.ResourceUsage, expected) {
+		t.Errorf("Expected resource usage %+v in result, got %+v", expected, result.ResourceUsage)
+	}
+	if usage := tb.getPackage(t).Status.BuildResourceUsage; !reflect.DeepEqual(usage, expected) {
+		t.Errorf("Expected resource usage %+v in package status, got %+v", expected, usage)
+	}
+	err = testutil.CollectAndCompare(buildCPUSeconds, strings.NewReader(`
+# HELP fission_package_build_cpu_seconds CPU time consumed by package build commands, as reported by the environment builder
+# TYPE fission_package_build_cpu_seconds histogram
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="0.5"} 0
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="1"} 0
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="5"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="10"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="30"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="60"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="120"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="300"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="600"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="1800"} 1
+fission_package_build_cpu_seconds_bucket{environment="test-env",environment_namespace="default",le="+Inf"} 1
+fission_package_build_cpu_seconds_sum{environment="test-env",environment_namespace="default"} 1.5
+fission_package_build_cpu_seconds_count{environment="test-env",environment_namespace="default"} 1
+`))
+	if err != nil {
+		t.Errorf("Unexpected build CPU metric: %v", err)
+	}
+	if n := testutil.CollectAndCount(buildPeakMemory); n != 1 {
+		t.Errorf("Expected peak memory of 1 environment, got %d", n)
+	}
+
+	// builders that don't report the resource usage leave it out
+	buildCPUSeconds.Reset()
+	tb = newTestBuild(t)
+	result, err = ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	if result.ResourceUsage != nil || tb.getPackage(t).Status.BuildResourceUsage != nil {
+		t.Errorf("Expected no resource usage, got %+v", result.ResourceUsage)
+	}
+	if n := testutil.CollectAndCount(buildCPUSeconds); n != 0 {
+		t.Errorf("Expected no build CPU observations, got %d", n)
+	}
+}
+
+func TestExecuteBuildRecordsSourceCommit(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.pkg.Spec.Source = fv1.Archive{
+		Type: fv1.ArchiveTypeGit,
+		Git:  &fv1.GitSource{URL: "https://github.com/fission/examples.git", Ref: "main"},
+	}
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		reportSourceCommit(ctx, "4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	if commit := tb.getPackage(t).Status.SourceCommit; commit != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("Expected source commit in package status, got %q", commit)
+	}
+}
+
+func TestExecuteBuildRecordsArchiveEncoding(t *testing.T) {
+	sum := fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: strings.Repeat("a", 64)}
+	storedSum := fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: strings.Repeat("b", 64)}
+	tests := []struct {
+		name     string
+		resp     fetcher.ArchiveUploadResponse
+		expected fv1.Archive
+	}{
+		{
+			name: "compressed",
+			resp: fetcher.ArchiveUploadResponse{Checksum: sum, OriginalSize: 1000, StoredSize: 200, Compressed: true, StoredChecksum: &storedSum},
+			expected: fv1.Archive{Checksum: sum, Size: 1000, Encoding: fv1.ArchiveEncodingGzip,
+				StoredChecksum: storedSum, StoredSize: 200},
+		},
+		{
+			name:     "not compressed",
+			resp:     fetcher.ArchiveUploadResponse{Checksum: sum, OriginalSize: 1000, StoredSize: 1000},
+			expected: fv1.Archive{Checksum: sum, Size: 1000},
+		},
+		{
+			// fetchers predating stored checksums
+			name:     "compressed without stored checksum",
+			resp:     fetcher.ArchiveUploadResponse{Checksum: sum, OriginalSize: 1000, StoredSize: 200, Compressed: true},
+			expected: fv1.Archive{Checksum: sum, Size: 1000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+				storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+				resp := test.resp
+				resp.ArchiveDownloadUrl = "http://storagesvc/deploy"
+				return &resp, "build succeeded\n", nil
+			}
+			_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+			if err != nil {
+				t.Fatalf("Error building package: %v", err)
+			}
+			test.expected.Type = fv1.ArchiveTypeUrl
+			test.expected.URL = "http://storagesvc/deploy"
+			if deployment := tb.getPackage(t).Spec.Deployment; !reflect.DeepEqual(deployment, test.expected) {
+				t.Errorf("Expected deployment archive %+v, got %+v", test.expected, deployment)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildSkipsSideEffects(t *testing.T) {
+	tb := newTestBuild(t)
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{
+		SkipPackageUpdate:  true,
+		SkipFunctionUpdate: true,
+		SkipArchiveCheck:   true,
+	})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+
+	if result.Status != fv1.BuildStatusSucceeded || result.Package.Status.BuildStatus != fv1.BuildStatusSucceeded {
+		t.Errorf("Expected succeeded build, got %s with package %s", result.Status, result.Package.Status.BuildStatus)
+	}
+	if result.Package.Spec.Deployment.URL != "http://storagesvc/deploy" {
+		t.Errorf("Expected deployment archive in result package, got %q", result.Package.Spec.Deployment.URL)
+	}
+	if len(result.UpdatedFunctions) != 0 {
+		t.Errorf("Expected no updated functions, got %v", result.UpdatedFunctions)
+	}
+	if n := tb.countWrites("packages") + tb.countWrites("functions"); n != 0 {
+		t.Errorf("Expected no resource updates, got %d", n)
+	}
+	if tb.checks != 0 || tb.store.uploads != 0 {
+		t.Errorf("Expected no archive check nor build log upload, got %d and %d", tb.checks, tb.store.uploads)
+	}
+	if tb.pkg.Status.BuildStatus != fv1.BuildStatusPending {
+		t.Errorf("Expected input package untouched, got %s", tb.pkg.Status.BuildStatus)
+	}
+	checkCondition(t, result.Package, fv1.PackageConditionBuildSucceeded, metav1.ConditionTrue, fv1.PackageReasonBuildSucceeded)
+	checkCondition(t, result.Package, fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonFunctionUpdateSkipped)
+}
+
+func TestExecuteBuildRetriesTransientFailure(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.failBuilds(errors.New("builder unavailable"))
+	opts := BuildOptions{Attempt: 1, MaxAttempts: 2, RetryDelay: time.Second}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, opts)
+	if err == nil || !result.Retry || result.Status != fv1.BuildStatusRunning {
+		t.Fatalf("Expected the failed attempt to be retried, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	if !strings.Contains(result.Logs, "Build attempt 1/2 failed, retrying in 1s") {
+		t.Errorf("Expected build logs to report the retry, got %q", result.Logs)
+	}
+	pkg := tb.getPackage(t)
+	if pkg.Status.BuildStatus != fv1.BuildStatusRunning {
+		t.Errorf("Expected package to stay running, got %s", pkg.Status.BuildStatus)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildRetrying)
+
+	opts.Attempt, opts.Logs = 2, result.Logs
+	result, err = ExecuteBuild(context.Background(), tb.deps, result.Package, opts)
+	if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+		t.Fatalf("Expected the last attempt to fail the package, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	pkg = tb.getPackage(t)
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed || !strings.Contains(pkg.Status.BuildLog, "Build attempt 1/2 failed") {
+		t.Errorf("Expected failed package with the logs of both attempts, got %s: %q", pkg.Status.BuildStatus, pkg.Status.BuildLog)
+	}
+	if tb.builds != 2 {
+		t.Errorf("Expected 2 builds, got %d", tb.builds)
+	}
+	if rv := tb.functionResourceVersion(t); rv != "" {
+		t.Errorf("Expected function untouched, got resource version %q", rv)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuilderReady, metav1.ConditionTrue, fv1.PackageReasonBuilderPodReady)
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonBuildFailed)
+	checkCondition(t, pkg, fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, fv1.PackageReasonBuildFailed)
+}
+
+func TestExecuteBuildRecordsTiming(t *testing.T) {
+	tb := newTestBuild(t)
+	succeed := tb.deps.buildPackage
+	tb.failBuilds(errors.New("builder unavailable"))
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	opts := BuildOptions{Attempt: 1, MaxAttempts: 2, StartTime: start}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, opts)
+	if !result.Retry {
+		t.Fatalf("Expected the failed attempt to be retried, got %s: %v", result.Status, err)
+	}
+	pkg := tb.getPackage(t)
+	if pkg.Status.BuildStartTime == nil || !pkg.Status.BuildStartTime.Time.Equal(start) || pkg.Status.BuildAttempts != 1 {
+		t.Errorf("Expected build started at %v with 1 attempt, got %v with %d", start, pkg.Status.BuildStartTime, pkg.Status.BuildAttempts)
+	}
+	if pkg.Status.BuildCompletionTime != nil {
+		t.Errorf("Expected no completion time of a running build, got %v", pkg.Status.BuildCompletionTime)
+	}
+
+	tb.deps.buildPackage = succeed
+	opts.Attempt = 2
+	if _, err = ExecuteBuild(context.Background(), tb.deps, result.Package, opts); err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	pkg = tb.getPackage(t)
+	if pkg.Status.BuildAttempts != 2 || pkg.Status.BuildCompletionTime == nil || pkg.Status.BuildDurationSeconds < 60 {
+		t.Errorf("Expected finished build of 2 attempts taking at least a minute, got %d attempts, completion %v, %ds",
+			pkg.Status.BuildAttempts, pkg.Status.BuildCompletionTime, pkg.Status.BuildDurationSeconds)
+	}
+
+	// marking the package for a rebuild keeps the timing of the last build
+	completion, duration := pkg.Status.BuildCompletionTime, pkg.Status.BuildDurationSeconds
+	pkg, err = setPendingBuildStatus(context.Background(), tb.fissionClient, pkg, fv1.BuildTriggerManualRebuild)
+	if err != nil {
+		t.Fatalf("Error marking package pending: %v", err)
+	}
+	if !pkg.Status.BuildCompletionTime.Equal(completion) || pkg.Status.BuildDurationSeconds != duration || pkg.Status.BuildAttempts != 2 {
+		t.Errorf("Expected pending package to keep the build timing, got %+v", pkg.Status)
+	}
+
+	// so does the next build while it runs
+	tb.failBuilds(errors.New("builder unavailable"))
+	if _, err = ExecuteBuild(context.Background(), tb.deps, pkg, BuildOptions{MaxAttempts: 2}); err == nil {
+		t.Fatal("Expected the build to fail")
+	}
+	pkg = tb.getPackage(t)
+	if !pkg.Status.BuildCompletionTime.Equal(completion) || pkg.Status.BuildDurationSeconds != duration ||
+		pkg.Status.BuildAttempts != 1 || !pkg.Status.BuildStartTime.After(start) {
+		t.Errorf("Expected running build to keep the last completion, got %+v", pkg.Status)
+	}
+}
+
+func TestExecuteBuildFailures(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(tb *testBuild)
+		opts  BuildOptions
+		log   string
+		phase fv1.BuildPhase
+		// reason is the reason of the failed BuildSucceeded condition,
+		// builder the reason of the BuilderReady condition
+		reason  string
+		builder string
+	}{
+		{
+			name: "missing environment",
+			setup: func(tb *testBuild) {
+				tb.pkg.Spec.Environment.Name = "missing"
+			},
+			// permanent failures aren't retried
+			opts:    BuildOptions{MaxAttempts: 3},
+			log:     `environment does not exist: "missing"`,
+			reason:  fv1.PackageReasonEnvironmentNotFound,
+			builder: fv1.PackageReasonEnvironmentNotFound,
+		},
+		{
+			name: "pod lookup error",
+			setup: func(tb *testBuild) {
+				tb.pods.err = errors.New("pod lister unavailable")
+			},
+			log:     "error retrieving pod information for environment",
+			phase:   fv1.BuildPhaseWaitingForBuilder,
+			reason:  fv1.PackageReasonBuilderNotReady,
+			builder: fv1.PackageReasonBuilderNotReady,
+		},
+		{
+			name: "builder namespace not watched",
+			setup: func(tb *testBuild) {
+				// default namespace environments are built in the
+				// builder namespace, only the default one is watched
+				tb.deps.NSResolver = &utils.NamespaceResolver{BuilderNamespace: "fission-builder"}
+				tb.deps.Pods = informerPodLister{
+					logger:      loggerfactory.GetLogger(),
+					podInformer: map[string]k8sCache.SharedIndexInformer{testNamespace: nil},
+				}
+			},
+			opts:    BuildOptions{MaxAttempts: 3},
+			log:     `builder namespace "fission-builder" of environment "test-env" is not watched`,
+			phase:   fv1.BuildPhaseWaitingForBuilder,
+			reason:  fv1.PackageReasonBuilderNamespaceNotWatched,
+			builder: fv1.PackageReasonBuilderNamespaceNotWatched,
+		},
+		{
+			name: "archive checksum mismatch",
+			setup: func(tb *testBuild) {
+				tb.deps.archiveCheckAttempts = 2
+				tb.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+					return permanentBuildError{errors.New("checksum mismatch, got abc, want def")}
+				}
+			},
+			opts:    BuildOptions{MaxAttempts: 3},
+			log:     reasonArtifactUnavailable,
+			phase:   fv1.BuildPhaseUploading,
+			reason:  fv1.PackageReasonArtifactUnavailable,
+			builder: fv1.PackageReasonBuilderPodReady,
+		},
+		{
+			name: "archive verification failed",
+			setup: func(tb *testBuild) {
+				tb.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+					return permanentBuildError{&archiveVerificationError{"size mismatch, got 10 bytes, want 20"}}
+				}
+			},
+			opts:    BuildOptions{MaxAttempts: 3},
+			log:     "deployment archive verification failed: size mismatch, got 10 bytes, want 20, archive http://storagesvc/deploy",
+			phase:   fv1.BuildPhaseUploading,
+			reason:  fv1.PackageReasonArtifactUnavailable,
+			builder: fv1.PackageReasonBuilderPodReady,
+		},
+		{
+			name: "timeout",
+			setup: func(tb *testBuild) {
+				tb.pods.pods = nil
+			},
+			opts:    BuildOptions{Timeout: 100 * time.Millisecond, MaxAttempts: 3},
+			log:     "Build exceeded timeout of 100ms",
+			phase:   fv1.BuildPhaseWaitingForBuilder,
+			reason:  fv1.PackageReasonBuildTimeout,
+			builder: fv1.PackageReasonBuilderNotReady,
+		},
+		{
+			name: "builder wait max time",
+			setup: func(tb *testBuild) {
+				tb.pods.pods = nil
+				tb.deps.builderBackoff = DefaultBuilderBackoff()
+				tb.deps.builderBackoff.MaxWait = 100 * time.Millisecond
+			},
+			log:     "Build timeout due to environment builder not ready after waiting",
+			phase:   fv1.BuildPhaseWaitingForBuilder,
+			reason:  fv1.PackageReasonBuilderNotReady,
+			builder: fv1.PackageReasonBuilderNotReady,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			test.setup(tb)
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, test.opts)
+			if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+				t.Fatalf("Expected failed build, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			pkg := tb.getPackage(t)
+			if pkg.Status.BuildStatus != fv1.BuildStatusFailed || !strings.Contains(pkg.Status.BuildLog, test.log) {
+				t.Errorf("Expected package to fail with %q, got %s: %q", test.log, pkg.Status.BuildStatus, pkg.Status.BuildLog)
+			}
+			if pkg.Status.BuildPhase != test.phase {
+				t.Errorf("Expected package to fail in phase %q, got %q", test.phase, pkg.Status.BuildPhase)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, test.reason)
+			builderStatus := metav1.ConditionFalse
+			if test.builder == fv1.PackageReasonBuilderPodReady {
+				builderStatus = metav1.ConditionTrue
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuilderReady, builderStatus, test.builder)
+			checkCondition(t, pkg, fv1.PackageConditionFunctionsUpdated, metav1.ConditionFalse, test.reason)
+			if !pkg.Spec.Deployment.IsEmpty() {
+				t.Errorf("Expected deployment archive not to be recorded, got %+v", pkg.Spec.Deployment)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildPhases(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.phaseUpdateInterval = time.Nanosecond
+	_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	expected := []fv1.BuildPhase{"", fv1.BuildPhaseWaitingForBuilder, fv1.BuildPhaseBuilding,
+		fv1.BuildPhaseUploading, fv1.BuildPhaseUpdatingFunctions, ""}
+	if phases := tb.statusPhases(); !reflect.DeepEqual(phases, expected) {
+		t.Errorf("Expected build phases %q, got %q", expected, phases)
+	}
+}
+
+func TestExecuteBuildPhaseUpdatesLimited(t *testing.T) {
+	t.Run("interval", func(t *testing.T) {
+		tb := newTestBuild(t)
+		tb.deps.phaseUpdateInterval = 200 * time.Millisecond
+		tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+			storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+			reportBuildPhase(ctx, fv1.BuildPhaseUploading)
+			time.Sleep(400 * time.Millisecond)
+			return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+		}
+		_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+		if err != nil {
+			t.Fatalf("Error building package: %v", err)
+		}
+		// the phases reached within the interval are written as the latest one
+		phases := tb.statusPhases()
+		for _, phase := range phases {
+			if phase == fv1.BuildPhaseWaitingForBuilder || phase == fv1.BuildPhaseBuilding {
+				t.Errorf("Expected phases before the upload to be coalesced, got %q", phases)
+			}
+		}
+		if len(phases) < 3 || phases[1] != fv1.BuildPhaseUploading || phases[len(phases)-1] != "" {
+			t.Errorf("Expected deferred write of the upload phase, got %q", phases)
+		}
+	})
+
+	t.Run("rate limiter", func(t *testing.T) {
+		tb := newTestBuild(t)
+		tb.deps.phaseUpdateInterval = time.Nanosecond
+		tb.deps.phaseLimiter = flowcontrol.NewFakeNeverRateLimiter()
+		tb.failBuilds(errors.New("compile error"))
+		_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+		if err == nil {
+			t.Fatal("Expected build to fail")
+		}
+		// the status update of the build result records the phase anyway
+		expected := []fv1.BuildPhase{"", fv1.BuildPhaseBuilding}
+		if phases := tb.statusPhases(); !reflect.DeepEqual(phases, expected) {
+			t.Errorf("Expected build phases %q, got %q", expected, phases)
+		}
+	})
+}
+
+// startingPodLister lists no builder pods until the given number of calls.
+type startingPodLister struct {
+	mu    sync.Mutex
+	calls int
+	after int
+	pods  []*apiv1.Pod
+}
+
+func (l *startingPodLister) ListBuilderPods(namespace string, env *fv1.Environment) ([]*apiv1.Pod, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.calls <= l.after {
+		return nil, nil
+	}
+	return l.pods, nil
+}
+
+func TestExecuteBuildReportsBuilderWait(t *testing.T) {
+	tb := newTestBuild(t)
+	tb.deps.phaseUpdateInterval = time.Nanosecond
+	tb.deps.Pods = &startingPodLister{after: 1, pods: tb.pods.pods}
+	start := time.Now()
+	_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+
+	var waits, cleared int
+	for _, action := range tb.fissionClient.Actions() {
+		update, ok := action.(k8sTesting.UpdateAction)
+		if !ok || action.GetResource().Resource != "packages" || action.GetSubresource() != "status" {
+			continue
+		}
+		status := update.GetObject().(*fv1.Package).Status
+		wait := status.BuilderWait
+		switch {
+		case wait != nil:
+			waits++
+			maxAttempts := int32(utils.NewDefaultBackOff().RemainingCount()) + 1
+			if wait.Attempt != 1 || wait.MaxAttempts != maxAttempts {
+				t.Errorf("Expected builder wait attempt 1/%d, got %d/%d", maxAttempts, wait.Attempt, wait.MaxAttempts)
+			}
+			if wait.NextRetryTime == nil || wait.NextRetryTime.Time.Before(start.Add(utils.DefaultInitialInterval)) {
+				t.Errorf("Expected next retry after the initial backoff, got %v", wait.NextRetryTime)
+			}
+		case status.BuildPhase == fv1.BuildPhaseBuilding:
+			cleared++
+		}
+	}
+	if waits != 1 || cleared != 1 {
+		t.Errorf("Expected one builder wait write cleared by the building phase, got %d waits and %d clears", waits, cleared)
+	}
+	if wait := tb.getPackage(t).Status.BuilderWait; wait != nil {
+		t.Errorf("Expected the builder wait to be cleared, got %+v", wait)
+	}
+}
+
+func TestExecuteBuildCanceled(t *testing.T) {
+	tb := newTestBuild(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		cancel(errPackageDeleted)
+		return nil, "", ctx.Err()
+	}
+
+	result, err := ExecuteBuild(ctx, tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+	if !errors.Is(err, errPackageDeleted) {
+		t.Errorf("Expected the cancellation cause, got %v", err)
+	}
+	if result.Retry {
+		t.Error("Expected canceled build not to be retried")
+	}
+	// the package stays in the running state of the attempt
+	if pkg := tb.getPackage(t); pkg.Status.BuildStatus != fv1.BuildStatusRunning {
+		t.Errorf("Expected canceled build not to update the package, got %s", pkg.Status.BuildStatus)
+	}
+}
+
+func TestExecuteBuildChecksSourceArchive(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// url is the package source URL, relative ones point at the
+		// storage service
+		url     string
+		status  int
+		lookups int
+		failed  bool
+	}{
+		{name: "internal archive missing", url: "/v1/archive?id=src-archive", status: http.StatusNotFound, lookups: 1, failed: true},
+		{name: "internal archive present", url: "/v1/archive?id=src-archive", status: http.StatusOK, lookups: 1},
+		{name: "external url", url: "http://example.com/v1/archive?id=src-archive", status: http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var lookups int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead || r.URL.Query().Get("id") != "src-archive" {
+					t.Errorf("Unexpected storage service request %s %s", r.Method, r.URL)
+				}
+				lookups++
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			tb := newTestBuild(t)
+			tb.deps.StorageSvcURL = server.URL
+			tb.pkg.Spec.Source.URL = test.url
+			if strings.HasPrefix(test.url, "/") {
+				tb.pkg.Spec.Source.URL = server.URL + test.url
+			}
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+			if lookups != test.lookups {
+				t.Errorf("Expected %d source archive lookups, got %d", test.lookups, lookups)
+			}
+			if !test.failed {
+				if err != nil || result.Status != fv1.BuildStatusSucceeded || tb.builds != 1 {
+					t.Fatalf("Expected the package to be built, got %s after %d builds: %v", result.Status, tb.builds, err)
+				}
+				return
+			}
+			// the archive doesn't come back by retrying
+			if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+				t.Fatalf("Expected failed build, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			if tb.builds != 0 {
+				t.Errorf("Expected no build of a missing source archive, got %d", tb.builds)
+			}
+			pkg := tb.getPackage(t)
+			for _, log := range []string{`source archive "src-archive"`, "fission package update --name " + testPkgName} {
+				if !strings.Contains(pkg.Status.BuildLog, log) {
+					t.Errorf("Expected build logs to contain %q, got %q", log, pkg.Status.BuildLog)
+				}
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonSourceArchiveMissing)
+		})
+	}
+}
+
+func TestExecuteBuildVerifiesSourceChecksum(t *testing.T) {
+	archive := []byte("source archive")
+	sum, err := utils.GetChecksum(strings.NewReader(string(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name     string
+		path     string
+		checksum string
+		failed   bool
+	}{
+		{name: "matching checksum", path: "/archive", checksum: sum.Sum},
+		{name: "no checksum", path: "/archive"},
+		{name: "checksum mismatch", path: "/archive", checksum: "0123", failed: true},
+		// the builder reports the fetch failure
+		{name: "download failure", path: "/missing", checksum: sum.Sum},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			tb.deps.verifySource = verifySourceChecksum
+			tb.pkg.Spec.Source.URL = server.URL + test.path
+			if len(test.checksum) > 0 {
+				tb.pkg.Spec.Source.Checksum = fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: test.checksum}
+			}
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+			if !test.failed {
+				if err != nil || result.Status != fv1.BuildStatusSucceeded || tb.builds != 1 {
+					t.Fatalf("Expected the package to be built, got %s after %d builds: %v", result.Status, tb.builds, err)
+				}
+				return
+			}
+			if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+				t.Fatalf("Expected failed build, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			if tb.builds != 0 {
+				t.Errorf("Expected no build of a mismatching source archive, got %d", tb.builds)
+			}
+			pkg := tb.getPackage(t)
+			if !strings.Contains(pkg.Status.BuildLog, "source archive checksum mismatch") {
+				t.Errorf("Expected checksum mismatch in build logs, got %q", pkg.Status.BuildLog)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonSourceChecksumMismatch)
+		})
+	}
+}
+
+func TestExecuteBuildNeedsDeps(t *testing.T) {
+	_, err := ExecuteBuild(context.Background(), BuildDeps{}, testPackage(), BuildOptions{})
+	if err == nil {
+		t.Error("Expected an error without fission client and pod lister")
+	}
+}
+
+func TestInformerPodLister(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	err := tpw.podInformer.GetStore().Add(&fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-a-pod", Namespace: testNamespace},
+	})
+	if err != nil {
+		t.Fatalf("Error adding object to informer store: %v", err)
+	}
+
+	// builder pods of other environments and older builder specs are
+	// left out
+	otherEnv := testBuilderPod(tpw.env)
+	otherEnv.ObjectMeta.Name = "other-env-builder-pod"
+	otherEnv.ObjectMeta.Labels[LABEL_ENV_NAME] = "other-env"
+	oldVersion := testBuilderPod(tpw.env)
+	oldVersion.ObjectMeta.Name = "old-builder-pod"
+	oldVersion.ObjectMeta.Labels[LABEL_ENV_RESOURCEVERSION] = "old"
+	oldVersion.ObjectMeta.Labels[LABEL_ENV_BUILDER_HASH] = "old"
+	for _, pod := range []*apiv1.Pod{otherEnv, oldVersion} {
+		err = tpw.podInformer.GetStore().Add(pod)
+		if err != nil {
+			t.Fatalf("Error adding builder pod to informer store: %v", err)
+		}
+	}
+
+	pods, err := tpw.deps.Pods.ListBuilderPods(testNamespace, tpw.env)
+	if err != nil || len(pods) != 1 || pods[0].ObjectMeta.Name != "builder-pod" {
+		t.Errorf("Expected the builder pod, got %v: %v", pods, err)
+	}
+	indexed, err := tpw.podInformer.GetIndexer().ByIndex(builderPodIndex, envBuilderKey(tpw.env, testNamespace).String())
+	if err != nil || len(indexed) != 1 {
+		t.Errorf("Expected the builder pod to be indexed, got %v: %v", indexed, err)
+	}
+	if _, err = tpw.deps.Pods.ListBuilderPods("other", tpw.env); !errors.Is(err, errNoBuilderPodInformer) {
+		t.Errorf("Expected %v for unwatched namespace, got %v", errNoBuilderPodInformer, err)
+	}
+}
+
+func TestUpdatePackageTruncatesBuildLogs(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	// the inline logs are all there is when they can't be persisted
+	tb.deps.logStore = &fakeArchiveStore{failAt: 1}
+	e := newBuildExecution(tb.deps, BuildOptions{MaxBuildLogSize: 256 * 1024})
+
+	tail := "build failed: missing dependency\n"
+	logs := strings.Repeat("compiling 0123456789abcdef\n", 5*1024*1024/27) + tail
+	pkg, err := e.updatePackage(ctx, tb.pkg.DeepCopy(), fv1.BuildStatusFailed, logs, nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if len(pkg.Status.BuildLog) > e.opts.MaxBuildLogSize {
+		t.Errorf("Expected build log of at most %d bytes, got %d", e.opts.MaxBuildLogSize, len(pkg.Status.BuildLog))
+	}
+	if !strings.HasPrefix(pkg.Status.BuildLog, "[log truncated, ") {
+		t.Errorf("Expected truncation marker, got %q", pkg.Status.BuildLog[:64])
+	}
+	if !strings.HasSuffix(pkg.Status.BuildLog, tail) {
+		t.Errorf("Expected build log tail to be preserved")
+	}
+
+	// short logs are stored as they are
+	pkg, err = e.updatePackage(ctx, pkg, fv1.BuildStatusFailed, tail, nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildLog != tail {
+		t.Errorf("Expected build log %q, got %q", tail, pkg.Status.BuildLog)
+	}
+}
+
+func TestUpdatePackageRetriesConflicts(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// subresource is the one of the update getting a conflict,
+		// the deployment archive is only written with an upload
+		subresource   string
+		upload        bool
+		sourceChanged bool
+	}{
+		{name: "spec conflict", upload: true},
+		{name: "status conflict", subresource: "status"},
+		{name: "source changed", upload: true, sourceChanged: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			tb := newTestBuild(t)
+			// someone else updates the package during the build
+			latest := tb.pkg.DeepCopy()
+			latest.ObjectMeta.Annotations = map[string]string{"team": "payments"}
+			if test.sourceChanged {
+				latest.Spec.Source.URL = "http://storagesvc/v1/archive?id=new-source"
+			}
+			_, err := tb.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, latest, metav1.UpdateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conflicts := 0
+			tb.fissionClient.PrependReactor("update", "packages", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != test.subresource || conflicts > 0 {
+					return false, nil, nil
+				}
+				conflicts++
+				return true, nil, k8serrors.NewConflict(fv1.Resource("packages"), testPkgName, errors.New("package changed"))
+			})
+
+			status := fv1.PackageStatus{BuildStatus: fv1.BuildStatusSucceeded}
+			var uploadResp *fetcher.ArchiveUploadResponse
+			if test.upload {
+				uploadResp = &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}
+			}
+			_, err = updatePackage(ctx, tb.deps.Logger, tb.fissionClient, tb.pkg.DeepCopy(), status, uploadResp)
+			if conflicts != 1 {
+				t.Errorf("Expected 1 conflict, got %d", conflicts)
+			}
+			pkg := tb.getPackage(t)
+			if test.sourceChanged {
+				// the archive isn't the build of the new source
+				if err == nil || len(pkg.Spec.Deployment.URL) > 0 {
+					t.Errorf("Expected the build of the old source left out, got deployment %q: %v", pkg.Spec.Deployment.URL, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error updating package: %v", err)
+			}
+			if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded || (test.upload && len(pkg.Spec.Deployment.URL) == 0) {
+				t.Errorf("Expected the build result written after the conflict, got %s with deployment %q",
+					pkg.Status.BuildStatus, pkg.Spec.Deployment.URL)
+			}
+			if pkg.ObjectMeta.Annotations["team"] != "payments" {
+				t.Errorf("Expected the concurrent update kept, got annotations %v", pkg.ObjectMeta.Annotations)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildEnvironmentDeleted(t *testing.T) {
+	deleteEnv := func(t *testing.T, tb *testBuild) {
+		t.Helper()
+		err := tb.fissionClient.CoreV1().Environments(testNamespace).Delete(context.Background(), testEnvName, metav1.DeleteOptions{})
+		if err != nil {
+			t.Fatalf("Error deleting environment: %v", err)
+		}
+	}
+	for _, test := range []struct {
+		name    string
+		setup   func(t *testing.T, tb *testBuild)
+		builds  int
+		builder string
+	}{
+		{
+			name: "while waiting for the builder",
+			setup: func(t *testing.T, tb *testBuild) {
+				tb.pods.pods = nil
+				tb.deps.builderBackoff = BuilderBackoff{InitialInterval: 10 * time.Millisecond, MaxInterval: 100 * time.Millisecond, Multiplier: 1.5}
+				tb.pods.listed = func() {
+					tb.pods.listed = nil
+					deleteEnv(t, tb)
+				}
+			},
+			builder: fv1.PackageReasonEnvironmentNotFound,
+		},
+		{
+			name: "before the function updates",
+			setup: func(t *testing.T, tb *testBuild) {
+				buildPackage := tb.deps.buildPackage
+				tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					deleteEnv(t, tb)
+					return buildPackage(ctx, logger, fissionClient, envBuilderNamespace, storageSvcUrl, pkg)
+				}
+			},
+			builds:  1,
+			builder: fv1.PackageReasonBuilderPodReady,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTestBuild(t)
+			test.setup(t, tb)
+			fnVersion := tb.functionResourceVersion(t)
+			result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+			if !errors.Is(err, errEnvironmentDeleted) || result.Retry || result.Status != fv1.BuildStatusFailed {
+				t.Fatalf("Expected build failed for the deleted environment, got %s retry %v: %v", result.Status, result.Retry, err)
+			}
+			pkg := tb.getPackage(t)
+			if !strings.Contains(pkg.Status.BuildLog, `environment was deleted during build: "test-env"`) {
+				t.Errorf("Expected deleted environment in the build logs, got %q", pkg.Status.BuildLog)
+			}
+			checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonEnvironmentNotFound)
+			if cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuilderReady); cond == nil || cond.Reason != test.builder {
+				t.Errorf("Expected builder ready condition with reason %s, got %+v", test.builder, cond)
+			}
+			if tb.builds != test.builds || tb.functionResourceVersion(t) != fnVersion {
+				t.Errorf("Expected %d builds and the function left alone, got %d builds", test.builds, tb.builds)
+			}
+		})
+	}
+}
+
+func TestExecuteBuildRecordsBuilderPod(t *testing.T) {
+	tb := newTestBuild(t)
+	pod := tb.pods.pods[0]
+	pod.Spec.NodeName = "node-a"
+	pod.Spec.Containers = []apiv1.Container{
+		{Name: "builder", Image: "builder:v1"},
+		{Name: "fetcher", Image: "fetcher:v1"},
+	}
+
+	_, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	expected := &fv1.BuilderPodInfo{Name: "builder-pod", Namespace: testNamespace, Node: "node-a", Image: "builder:v1"}
+	if got := tb.getPackage(t).Status.BuilderPod; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected builder pod %+v in the package status, got %+v", expected, got)
+	}
+
+	// the next attempt overwrites it
+	pod.ObjectMeta.Name = "builder-pod-b"
+	pod.Spec.NodeName = "node-b"
+	_, err = ExecuteBuild(context.Background(), tb.deps, tb.getPackage(t), BuildOptions{})
+	if err != nil {
+		t.Fatalf("Error building package: %v", err)
+	}
+	if got := tb.getPackage(t).Status.BuilderPod; got == nil || got.Name != "builder-pod-b" || got.Node != "node-b" {
+		t.Errorf("Expected builder pod of the last attempt in the package status, got %+v", got)
+	}
+
+	// and an attempt failing before it gets a builder pod clears it
+	pkg := tb.getPackage(t)
+	pkg.Spec.Environment.Name = "missing"
+	_, err = ExecuteBuild(context.Background(), tb.deps, pkg, BuildOptions{})
+	if err == nil {
+		t.Fatal("Expected build of a package with a missing environment to fail")
+	}
+	if got := tb.getPackage(t).Status.BuilderPod; got != nil {
+		t.Errorf("Expected no builder pod in the package status, got %+v", got)
+	}
+}
+
+func TestLogLines(t *testing.T) {
+	logs := logLines(logPhaseFetch, "fetching source\nfetched source")
+	lines := strings.SplitAfter(logs, "\n")
+	if len(lines) != 3 || lines[2] != "" {
+		t.Fatalf("Expected two newline terminated lines, got %q", logs)
+	}
+	for i, msg := range []string{"fetching source\n", "fetched source\n"} {
+		tag, line, ok := strings.Cut(lines[i], "] ")
+		if !ok || line != msg || !strings.HasPrefix(tag, "[") || !strings.HasSuffix(tag, " fetch") {
+			t.Errorf("Expected line %q tagged with the fetch phase, got %q", msg, lines[i])
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, strings.TrimSuffix(strings.TrimPrefix(tag, "["), " fetch")); err != nil {
+			t.Errorf("Expected RFC3339 timestamp in %q: %v", lines[i], err)
+		}
+	}
+	if logs := logLines(logPhaseBuild, ""); logs != "" {
+		t.Errorf("Expected no lines for empty text, got %q", logs)
+	}
+	if logs := logf(logPhaseUpload, "uploaded %d bytes", 42); !strings.HasSuffix(logs, " upload] uploaded 42 bytes\n") {
+		t.Errorf("Unexpected formatted line %q", logs)
+	}
+}
+
+func TestUpdatePackagePersistsBuildLogs(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	store := &fakeArchiveStore{failAt: 3}
+	tb.deps.logStore = store
+	e := newBuildExecution(tb.deps, BuildOptions{})
+
+	var logs strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&logs, "build step %d\n", i)
+	}
+	pkg, err := e.updatePackage(ctx, tb.pkg.DeepCopy(), fv1.BuildStatusFailed, logs.String(), nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildLogURL != "http://storagesvc/v1/archive?id=archive-1" {
+		t.Errorf("Expected build log URL of the uploaded log, got %q", pkg.Status.BuildLogURL)
+	}
+	if !strings.HasPrefix(pkg.Status.BuildLog, "[showing the last 300 lines, 700 lines dropped") ||
+		!strings.HasSuffix(pkg.Status.BuildLog, "build step 999\n") ||
+		strings.Count(pkg.Status.BuildLog, "\n") != inlineBuildLogLines+1 {
+		t.Errorf("Expected the last %d lines inline, got %q", inlineBuildLogLines, pkg.Status.BuildLog)
+	}
+
+	// running builds keep the link to the last finished build
+	pkg, err = e.updatePackage(ctx, pkg, fv1.BuildStatusRunning, "Build attempt 1/1\n", nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildLogURL != "http://storagesvc/v1/archive?id=archive-1" || len(store.deleted) != 0 {
+		t.Errorf("Expected build log URL to be kept, got %q, deleted %v", pkg.Status.BuildLogURL, store.deleted)
+	}
+
+	// a rebuild replaces the log object
+	pkg, err = e.updatePackage(ctx, pkg, fv1.BuildStatusSucceeded, "build succeeded\n", nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildLogURL != "http://storagesvc/v1/archive?id=archive-2" || pkg.Status.BuildLog != "build succeeded\n" {
+		t.Errorf("Expected the new build log, got %q %q", pkg.Status.BuildLogURL, pkg.Status.BuildLog)
+	}
+	if !reflect.DeepEqual(store.deleted, []string{"archive-1"}) {
+		t.Errorf("Expected the old build log to be deleted, got %v", store.deleted)
+	}
+
+	// the logs stay inline when the upload fails
+	pkg, err = e.updatePackage(ctx, pkg, fv1.BuildStatusFailed, logs.String(), nil)
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildLogURL != "" || pkg.Status.BuildLog != logs.String() {
+		t.Errorf("Expected inline build logs without URL, got %q", pkg.Status.BuildLogURL)
+	}
+	if !reflect.DeepEqual(store.deleted, []string{"archive-1", "archive-2"}) {
+		t.Errorf("Expected the replaced build log to be deleted, got %v", store.deleted)
+	}
+}
+
+func TestUpdateFunctionsRetriesConflicts(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	for _, name := range []string{"broken-fn", "other-fn"} {
+		fn := tb.fn.DeepCopy()
+		fn.ObjectMeta.Name = name
+		_, err := tb.fissionClient.CoreV1().Functions(testNamespace).Create(ctx, fn, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating function: %v", err)
+		}
+	}
+	// the test function is changed by someone else before its first
+	// update, the broken function can't be updated at all
+	conflicts := 0
+	tb.fissionClient.PrependReactor("patch", "functions", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8sTesting.PatchAction).GetName()
+		switch {
+		case name == tb.fn.ObjectMeta.Name && conflicts == 0:
+			conflicts++
+			return true, nil, k8serrors.NewConflict(fv1.Resource("functions"), name, errors.New("function changed"))
+		case name == "broken-fn":
+			return true, nil, errors.New("admission webhook denied the request")
+		}
+		return false, nil, nil
+	})
+
+	e := newBuildExecution(tb.deps, BuildOptions{})
+	updated, err := e.updateFunctions(ctx, tb.pkg)
+	if err == nil || !strings.Contains(err.Error(), "broken-fn") {
+		t.Errorf("Expected the update error of broken-fn, got %v", err)
+	}
+	sort.Strings(updated)
+	if !reflect.DeepEqual(updated, []string{"other-fn", tb.fn.ObjectMeta.Name}) {
+		t.Errorf("Expected the other functions to be updated, got %v", updated)
+	}
+	if conflicts != 1 {
+		t.Errorf("Expected 1 conflict, got %d", conflicts)
+	}
+	if rv := tb.functionResourceVersion(t); rv != tb.pkg.ObjectMeta.ResourceVersion {
+		t.Errorf("Expected function bumped to %q after the conflict, got %q", tb.pkg.ObjectMeta.ResourceVersion, rv)
+	}
+}
+
+func TestUpdateFunctionsPatchesPackageRef(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	// fields set by others survive the update
+	fn, err := tb.fissionClient.CoreV1().Functions(testNamespace).Get(ctx, tb.fn.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting function: %v", err)
+	}
+	fn.ObjectMeta.ResourceVersion = "7"
+	fn.ObjectMeta.Annotations = map[string]string{"autoscaler": "on"}
+	fn.Spec.Package.FunctionName = "handler"
+	fn.Spec.Concurrency = 3
+	_, err = tb.fissionClient.CoreV1().Functions(testNamespace).Update(ctx, fn, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating function: %v", err)
+	}
+
+	e := newBuildExecution(tb.deps, BuildOptions{})
+	_, err = e.updateFunctions(ctx, tb.pkg)
+	if err != nil {
+		t.Fatalf("Error updating functions: %v", err)
+	}
+
+	var patches []k8sTesting.PatchAction
+	for _, action := range tb.fissionClient.Actions() {
+		if patch, ok := action.(k8sTesting.PatchAction); ok && action.GetResource().Resource == "functions" {
+			patches = append(patches, patch)
+		}
+	}
+	if len(patches) != 1 || patches[0].GetPatchType() != k8sTypes.MergePatchType {
+		t.Fatalf("Expected 1 merge patch of the function, got %v", patches)
+	}
+	expected := fmt.Sprintf(`{"metadata":{"resourceVersion":"7"},"spec":{"package":{"packageref":{"resourceversion":%q}}}}`,
+		tb.pkg.ObjectMeta.ResourceVersion)
+	if string(patches[0].GetPatch()) != expected {
+		t.Errorf("Expected patch %s, got %s", expected, patches[0].GetPatch())
+	}
+
+	patched, err := tb.fissionClient.CoreV1().Functions(testNamespace).Get(ctx, tb.fn.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting function: %v", err)
+	}
+	if patched.Spec.Package.PackageRef.ResourceVersion != tb.pkg.ObjectMeta.ResourceVersion {
+		t.Errorf("Expected function bumped to %q, got %q", tb.pkg.ObjectMeta.ResourceVersion, patched.Spec.Package.PackageRef.ResourceVersion)
+	}
+	if patched.Spec.Package.FunctionName != "handler" || patched.Spec.Concurrency != 3 ||
+		patched.Spec.Package.PackageRef.Name != testPkgName || patched.ObjectMeta.Annotations["autoscaler"] != "on" {
+		t.Errorf("Expected the other function fields to be left alone, got %+v", patched)
+	}
+}
+
+func TestUpdateFunctionsUsesFunctionIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tb := newTestBuild(t)
+	other := tb.fn.DeepCopy()
+	other.ObjectMeta.Name = "other-pkg-fn"
+	other.Spec.Package.PackageRef.Name = "other-pkg"
+	_, err := tb.fissionClient.CoreV1().Functions(testNamespace).Create(ctx, other, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating function: %v", err)
+	}
+	fnInformer := fInformers.NewSharedInformerFactory(tb.fissionClient, 0).Core().V1().Functions().Informer()
+	err = fnInformer.AddIndexers(k8sCache.Indexers{functionPackageIndex: functionPackageIndexFunc})
+	if err != nil {
+		t.Fatalf("Error adding function index: %v", err)
+	}
+	tb.deps.fnInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: fnInformer}
+	e := newBuildExecution(tb.deps, BuildOptions{})
+
+	// functions are listed until the informer cache is synced
+	updated, err := e.updateFunctions(ctx, tb.pkg)
+	if err != nil || !reflect.DeepEqual(updated, []string{tb.fn.ObjectMeta.Name}) {
+		t.Fatalf("Expected the listed function to be updated, got %v: %v", updated, err)
+	}
+
+	go fnInformer.Run(ctx.Done())
+	if !k8sCache.WaitForCacheSync(ctx.Done(), fnInformer.HasSynced) {
+		t.Fatalf("Function informer cache did not sync")
+	}
+	// the test function moves to the other package
+	fn, err := tb.fissionClient.CoreV1().Functions(testNamespace).Get(ctx, tb.fn.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting function: %v", err)
+	}
+	fn.Spec.Package.PackageRef.Name = "other-pkg"
+	_, err = tb.fissionClient.CoreV1().Functions(testNamespace).Update(ctx, fn, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating function: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fns, _ := fnInformer.GetIndexer().ByIndex(functionPackageIndex, functionPackageIndexKey(testNamespace, "other-pkg"))
+		if len(fns) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Function index did not follow the package change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	otherPkg := tb.pkg.DeepCopy()
+	otherPkg.ObjectMeta.Name = "other-pkg"
+	otherPkg.ObjectMeta.ResourceVersion = "2"
+	actions := len(tb.fissionClient.Actions())
+	updated, err = e.updateFunctions(ctx, otherPkg)
+	if err != nil {
+		t.Fatalf("Error updating functions: %v", err)
+	}
+	sort.Strings(updated)
+	if !reflect.DeepEqual(updated, []string{"other-pkg-fn", tb.fn.ObjectMeta.Name}) {
+		t.Errorf("Expected the functions of the other package to be updated, got %v", updated)
+	}
+	for _, action := range tb.fissionClient.Actions()[actions:] {
+		if action.GetVerb() == "list" {
+			t.Errorf("Expected the functions to be looked up in the informer index, got a %s", action.GetVerb())
+		}
+	}
+}
+
+func TestUpdateFunctionsInOtherNamespaces(t *testing.T) {
+	ctx := context.Background()
+	tb := newTestBuild(t)
+	tb.deps.NSResolver = &utils.NamespaceResolver{
+		FissionResourceNS: map[string]string{testNamespace: testNamespace, "team-b": "team-b"},
+		Logger:            tb.deps.Logger,
+	}
+	// a function of another namespace using the package, and one using
+	// a package of the same name in its own namespace
+	shared := tb.fn.DeepCopy()
+	shared.ObjectMeta.Namespace = "team-b"
+	own := tb.fn.DeepCopy()
+	own.ObjectMeta.Namespace = "team-b"
+	own.ObjectMeta.Name = "own-fn"
+	own.Spec.Package.PackageRef.Namespace = "team-b"
+	for _, fn := range []*fv1.Function{shared, own} {
+		_, err := tb.fissionClient.CoreV1().Functions("team-b").Create(ctx, fn, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating function: %v", err)
+		}
+	}
+
+	e := newBuildExecution(tb.deps, BuildOptions{})
+	updated, err := e.updateFunctions(ctx, tb.pkg)
+	if err != nil {
+		t.Fatalf("Error updating functions: %v", err)
+	}
+	if !reflect.DeepEqual(updated, []string{tb.fn.ObjectMeta.Name, "team-b/" + shared.ObjectMeta.Name}) {
+		t.Errorf("Expected the functions of both namespaces to be updated, got %v", updated)
+	}
+	for _, fn := range []*fv1.Function{shared, own} {
+		got, err := tb.fissionClient.CoreV1().Functions("team-b").Get(ctx, fn.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting function: %v", err)
+		}
+		bumped := got.Spec.Package.PackageRef.ResourceVersion == tb.pkg.ObjectMeta.ResourceVersion
+		if bumped != (fn == shared) {
+			t.Errorf("Function %s bumped: %v, expected %v", fn.ObjectMeta.Name, bumped, fn == shared)
+		}
+	}
+}
+
+func TestArchiveCheckStopsOnCancel(t *testing.T) {
+	tb := newTestBuild(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checks := 0
+	tb.deps.archiveCheckAttempts = 5
+	tb.deps.archiveCheckDelay = time.Hour
+	tb.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+		checks++
+		// the build is canceled while the archive isn't downloadable
+		cancel()
+		return errors.New("archive not found")
+	}
+
+	e := newBuildExecution(tb.deps, BuildOptions{})
+	done := make(chan error, 1)
+	go func() {
+		done <- e.ensureArchiveFetchable(ctx, tb.pkg, &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"})
+	}()
+	select {
+	case err := <-done:
+		if err == nil || checks != 1 {
+			t.Errorf("Expected the archive check to stop after the first failure, got %d checks: %v", checks, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Archive check did not stop on cancel")
+	}
+}
+
+func TestBuildPackageLiteralSizeLimit(t *testing.T) {
+	env := testEnvironment()
+	pkg := testPackage()
+	pkg.Spec.Source = fv1.Archive{
+		Type:    fv1.ArchiveTypeLiteral,
+		Literal: make([]byte, fv1.ArchiveLiteralSizeLimit+1),
+	}
+	result, err := buildPackage(context.Background(), loggerfactory.GetLogger(), fClient.NewSimpleClientset(env, pkg),
+		"fission-builder", "http://storagesvc", pkg)
+	if err == nil || !isPermanentBuildError(err) || result.failedPhase != logPhaseBuildermgr {
+		t.Fatalf("Expected permanent build error before the fetch, got %v in phase %q", err, result.failedPhase)
+	}
+	if !strings.Contains(result.logs, "exceeds the 262 kB limit of literal archives") {
+		t.Errorf("Expected size limit in build logs, got %q", result.logs)
+	}
+}
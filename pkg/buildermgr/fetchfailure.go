@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+)
+
+// builderPodRecycleThreshold is the number of corrupt source fetches
+// after which a builder pod is deleted to be replaced by a fresh one.
+const builderPodRecycleThreshold = 3
+
+type (
+	// sourceFetchError is a source fetch the builder pods kept failing even
+	// though the source archive may be fine, e.g. a checksum mismatch of the
+	// download caused by a bad local disk, or a source no pod can fetch,
+	// e.g. a missing git ref. The build isn't retried.
+	sourceFetchError struct {
+		error
+	}
+
+	// fetchFailureTracker counts the failed source fetches of the builder
+	// pods and recycles the pods that keep failing them.
+	fetchFailureTracker struct {
+		logger    *zap.Logger
+		k8sClient kubernetes.Interface
+		threshold int
+
+		mu sync.Mutex
+		// failures counts the failed fetches by pod namespace/name
+		failures map[string]int
+	}
+)
+
+func isSourceFetchError(err error) bool {
+	var e sourceFetchError
+	return errors.As(err, &e)
+}
+
+func newFetchFailureTracker(logger *zap.Logger, k8sClient kubernetes.Interface) *fetchFailureTracker {
+	return &fetchFailureTracker{
+		logger:    logger,
+		k8sClient: k8sClient,
+		threshold: builderPodRecycleThreshold,
+		failures:  make(map[string]int),
+	}
+}
+
+// record counts a failed source fetch of the builder pod, given as
+// namespace/name. The pod is deleted once it reached the threshold,
+// its deployment replaces it with a pod on a fresh disk.
+func (t *fetchFailureTracker) record(ctx context.Context, pod string) {
+	builderFetchFailures.WithLabelValues(pod).Inc()
+	if t == nil || len(pod) == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.failures[pod]++
+	n := t.failures[pod]
+	if n >= t.threshold {
+		delete(t.failures, pod)
+	}
+	t.mu.Unlock()
+
+	t.logger.Warn("builder pod failed to verify fetched source",
+		zap.String("pod", pod),
+		zap.Int("failures", n))
+	if n < t.threshold {
+		return
+	}
+
+	namespace, name, err := k8sCache.SplitMetaNamespaceKey(pod)
+	if err != nil {
+		t.logger.Error("invalid builder pod name", zap.String("pod", pod), zap.Error(err))
+		return
+	}
+	err = t.k8sClient.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		t.logger.Error("error recycling builder pod", zap.String("pod", pod), zap.Error(err))
+		return
+	}
+	builderPodsRecycled.Inc()
+	t.logger.Warn("recycled builder pod with repeated source fetch failures",
+		zap.String("pod", pod),
+		zap.Int("failures", n))
+}
@@ -0,0 +1,79 @@
+package buildermgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestFetchFailureTrackerRecyclesPod(t *testing.T) {
+	pod := testBuilderPod(testEnvironment())
+	k8sClient := fake.NewSimpleClientset(pod)
+	tracker := newFetchFailureTracker(loggerfactory.GetLogger(), k8sClient)
+	podName := testNamespace + "/" + pod.ObjectMeta.Name
+
+	for i := 1; i < tracker.threshold; i++ {
+		tracker.record(context.Background(), podName)
+	}
+	if _, err := k8sClient.CoreV1().Pods(testNamespace).Get(context.Background(), pod.ObjectMeta.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected builder pod below the threshold to be kept, got %v", err)
+	}
+
+	tracker.record(context.Background(), podName)
+	_, err := k8sClient.CoreV1().Pods(testNamespace).Get(context.Background(), pod.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected builder pod at the threshold to be recycled, got %v", err)
+	}
+	if n := tracker.failures[podName]; n != 0 {
+		t.Errorf("Expected failures of the recycled pod to be reset, got %d", n)
+	}
+
+	// nil trackers only count the failures in the metrics
+	var none *fetchFailureTracker
+	none.record(context.Background(), podName)
+}
+
+func TestExecuteBuildSourceFetchFailure(t *testing.T) {
+	tb := newTestBuild(t)
+	pod := testBuilderPod(tb.env)
+	k8sClient := fake.NewSimpleClientset(pod)
+	tb.deps.fetchFailures = newFetchFailureTracker(loggerfactory.GetLogger(), k8sClient)
+	tb.deps.fetchFailures.threshold = 2
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		tb.builds++
+		// the fetch fails on the builder pod and again after the retry
+		reportSourceFetchFailure(ctx, testNamespace+"/"+pod.ObjectMeta.Name)
+		reportSourceFetchFailure(ctx, testNamespace+"/"+pod.ObjectMeta.Name)
+		return nil, fv1.PackageReasonSourceFetchFailed + ": checksum mismatch\n", sourceFetchError{errors.New("checksum mismatch")}
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, tb.pkg, BuildOptions{MaxAttempts: 3})
+	if err == nil || result.Retry || result.Status != fv1.BuildStatusFailed {
+		t.Fatalf("Expected failed build without retry, got %s retry %v: %v", result.Status, result.Retry, err)
+	}
+	if result.SourceFetchFailures != 2 {
+		t.Errorf("Expected 2 source fetch failures, got %d", result.SourceFetchFailures)
+	}
+	pkg := tb.getPackage(t)
+	if !strings.Contains(pkg.Status.BuildLog, fv1.PackageReasonSourceFetchFailed) {
+		t.Errorf("Expected build logs to report the source fetch failure, got %q", pkg.Status.BuildLog)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonSourceFetchFailed)
+
+	_, err = k8sClient.CoreV1().Pods(testNamespace).Get(context.Background(), pod.ObjectMeta.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected builder pod with repeated fetch failures to be recycled, got %v", err)
+	}
+}
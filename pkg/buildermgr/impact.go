@@ -0,0 +1,254 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	listersv1 "github.com/fission/fission/pkg/generated/listers/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// errImpactNotReady is returned while the informer caches sync.
+var errImpactNotReady = errors.New("informer caches are not synced yet")
+
+type (
+	// ImpactSources are the resources package impacts are resolved from,
+	// all in the namespace of the package.
+	ImpactSources struct {
+		Functions               []*fv1.Function
+		HTTPTriggers            []*fv1.HTTPTrigger
+		TimeTriggers            []*fv1.TimeTrigger
+		MessageQueueTriggers    []*fv1.MessageQueueTrigger
+		KubernetesWatchTriggers []*fv1.KubernetesWatchTrigger
+	}
+
+	// impactResolver resolves package impacts from the informer caches.
+	impactResolver struct {
+		pkgInformer       map[string]k8sCache.SharedIndexInformer
+		fnInformer        map[string]k8sCache.SharedIndexInformer
+		httpInformer      map[string]k8sCache.SharedIndexInformer
+		timeInformer      map[string]k8sCache.SharedIndexInformer
+		mqInformer        map[string]k8sCache.SharedIndexInformer
+		kubeWatchInformer map[string]k8sCache.SharedIndexInformer
+	}
+)
+
+// ReferencesPackage tells whether the function uses the package. Package
+// references without namespace are resolved in the function namespace.
+func ReferencesPackage(fn *fv1.Function, pkgName, pkgNamespace string) bool {
+	ref := fn.Spec.Package.PackageRef
+	if ref.Name != pkgName {
+		return false
+	}
+	if len(ref.Namespace) == 0 {
+		return fn.ObjectMeta.Namespace == pkgNamespace
+	}
+	return ref.Namespace == pkgNamespace
+}
+
+// FunctionsReferencingPackage returns the functions using the package.
+func FunctionsReferencingPackage(fns []fv1.Function, pkgName, pkgNamespace string) []fv1.Function {
+	refs := []fv1.Function{}
+	for i := range fns {
+		if ReferencesPackage(&fns[i], pkgName, pkgNamespace) {
+			refs = append(refs, fns[i])
+		}
+	}
+	return refs
+}
+
+// updatedOnRebuild tells whether the builder manager bumps the package
+// reference of the function after a successful build of the package.
+func updatedOnRebuild(fn *fv1.Function, pkg *fv1.Package) bool {
+	ref := fn.Spec.Package.PackageRef
+	return ref.Name == pkg.ObjectMeta.Name && ref.Namespace == pkg.ObjectMeta.Namespace
+}
+
+// functionPackageIndex indexes the functions of the function informers by
+// the namespace and name of their package reference.
+const functionPackageIndex = "packageRef"
+
+func functionPackageIndexKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// functionPackageIndexFunc indexes the functions by their package reference.
+func functionPackageIndexFunc(obj interface{}) ([]string, error) {
+	fn, ok := obj.(*fv1.Function)
+	if !ok {
+		return nil, nil
+	}
+	ref := fn.Spec.Package.PackageRef
+	return []string{functionPackageIndexKey(ref.Namespace, ref.Name)}, nil
+}
+
+// referencesFunction tells whether a trigger function reference targets the function.
+func referencesFunction(ref fv1.FunctionReference, fnName string) bool {
+	if ref.Type == fv1.FunctionReferenceTypeFunctionWeights {
+		_, ok := ref.FunctionWeights[fnName]
+		return ok
+	}
+	return ref.Name == fnName
+}
+
+// ResolvePackageImpact returns the functions a rebuild of the package affects
+// and the triggers of those functions, sorted by name.
+func ResolvePackageImpact(pkg *fv1.Package, src *ImpactSources) *PackageImpact {
+	impact := &PackageImpact{
+		Name:            pkg.ObjectMeta.Name,
+		Namespace:       pkg.ObjectMeta.Namespace,
+		ResourceVersion: pkg.ObjectMeta.ResourceVersion,
+		Functions:       []FunctionImpact{},
+	}
+	for _, fn := range src.Functions {
+		if !ReferencesPackage(fn, pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace) {
+			continue
+		}
+		fi := FunctionImpact{
+			Name:                   fn.ObjectMeta.Name,
+			Namespace:              fn.ObjectMeta.Namespace,
+			ExecutorType:           fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType,
+			UpdatePolicy:           FunctionUpdateManual,
+			PackageResourceVersion: fn.Spec.Package.PackageRef.ResourceVersion,
+			Triggers:               []TriggerImpact{},
+		}
+		if len(fi.ExecutorType) == 0 {
+			fi.ExecutorType = fv1.ExecutorTypePoolmgr
+		}
+		if updatedOnRebuild(fn, pkg) {
+			fi.UpdatePolicy = FunctionUpdateAutomatic
+		}
+		addTrigger := func(kind, name string, ref fv1.FunctionReference) {
+			if referencesFunction(ref, fn.ObjectMeta.Name) {
+				fi.Triggers = append(fi.Triggers, TriggerImpact{Kind: kind, Name: name})
+			}
+		}
+		for _, t := range src.HTTPTriggers {
+			addTrigger(TriggerKindHTTP, t.ObjectMeta.Name, t.Spec.FunctionReference)
+		}
+		for _, t := range src.TimeTriggers {
+			addTrigger(TriggerKindTime, t.ObjectMeta.Name, t.Spec.FunctionReference)
+		}
+		for _, t := range src.MessageQueueTriggers {
+			addTrigger(TriggerKindMessageQueue, t.ObjectMeta.Name, t.Spec.FunctionReference)
+		}
+		for _, t := range src.KubernetesWatchTriggers {
+			addTrigger(TriggerKindKubernetesWatch, t.ObjectMeta.Name, t.Spec.FunctionReference)
+		}
+		sort.Slice(fi.Triggers, func(i, j int) bool {
+			if fi.Triggers[i].Kind != fi.Triggers[j].Kind {
+				return fi.Triggers[i].Kind < fi.Triggers[j].Kind
+			}
+			return fi.Triggers[i].Name < fi.Triggers[j].Name
+		})
+		impact.Functions = append(impact.Functions, fi)
+	}
+	sort.Slice(impact.Functions, func(i, j int) bool {
+		return impact.Functions[i].Name < impact.Functions[j].Name
+	})
+	return impact
+}
+
+func makeImpactResolver(logger *zap.Logger, fissionClient versioned.Interface, pkgInformer map[string]k8sCache.SharedIndexInformer) *impactResolver {
+	r := &impactResolver{
+		pkgInformer:       pkgInformer,
+		fnInformer:        utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.FunctionResource),
+		httpInformer:      utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.HttpTriggerResource),
+		timeInformer:      utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.TimeTriggerResource),
+		mqInformer:        utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.MessageQueueResource),
+		kubeWatchInformer: utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.KubernetesWatchResource),
+	}
+	// the package watcher finds the functions to bump after builds with
+	// the package reference index
+	for _, informer := range r.fnInformer {
+		err := informer.AddIndexers(k8sCache.Indexers{functionPackageIndex: functionPackageIndexFunc})
+		if err != nil {
+			logger.Error("error adding function package index, functions are listed after builds", zap.Error(err))
+		}
+	}
+	return r
+}
+
+// informers returns the informers the resolver runs itself, the package
+// informers are run by the package watcher.
+func (r *impactResolver) informers() []map[string]k8sCache.SharedIndexInformer {
+	return []map[string]k8sCache.SharedIndexInformer{r.fnInformer, r.httpInformer, r.timeInformer, r.mqInformer, r.kubeWatchInformer}
+}
+
+func (r *impactResolver) Run(ctx context.Context) {
+	for _, informers := range r.informers() {
+		for _, informer := range informers {
+			go informer.Run(ctx.Done())
+		}
+	}
+}
+
+// impact resolves the impact of the package from the informer caches.
+func (r *impactResolver) impact(namespace, name string) (*PackageImpact, error) {
+	pkgInformer, ok := r.pkgInformer[namespace]
+	if !ok {
+		return nil, k8serrors.NewNotFound(fv1.Resource("packages"), name)
+	}
+	synced := []k8sCache.SharedIndexInformer{pkgInformer}
+	for _, informers := range r.informers() {
+		synced = append(synced, informers[namespace])
+	}
+	for _, informer := range synced {
+		if !informer.HasSynced() {
+			return nil, errImpactNotReady
+		}
+	}
+
+	pkg, err := listersv1.NewPackageLister(pkgInformer.GetIndexer()).Packages(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var src ImpactSources
+	src.Functions, err = listersv1.NewFunctionLister(r.fnInformer[namespace].GetIndexer()).Functions(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing functions")
+	}
+	src.HTTPTriggers, err = listersv1.NewHTTPTriggerLister(r.httpInformer[namespace].GetIndexer()).HTTPTriggers(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing http triggers")
+	}
+	src.TimeTriggers, err = listersv1.NewTimeTriggerLister(r.timeInformer[namespace].GetIndexer()).TimeTriggers(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing time triggers")
+	}
+	src.MessageQueueTriggers, err = listersv1.NewMessageQueueTriggerLister(r.mqInformer[namespace].GetIndexer()).MessageQueueTriggers(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing message queue triggers")
+	}
+	src.KubernetesWatchTriggers, err = listersv1.NewKubernetesWatchTriggerLister(r.kubeWatchInformer[namespace].GetIndexer()).KubernetesWatchTriggers(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing kubernetes watch triggers")
+	}
+	return ResolvePackageImpact(pkg, &src), nil
+}
@@ -0,0 +1,197 @@
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	genInformer "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func impactTestFunction(name, pkgName, pkgNamespace string, executor fv1.ExecutorType) *fv1.Function {
+	return &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+		Spec: fv1.FunctionSpec{
+			Package: fv1.FunctionPackageRef{
+				PackageRef: fv1.PackageRef{Name: pkgName, Namespace: pkgNamespace, ResourceVersion: "41"},
+			},
+			InvokeStrategy: fv1.InvokeStrategy{
+				ExecutionStrategy: fv1.ExecutionStrategy{ExecutorType: executor},
+			},
+		},
+	}
+}
+
+func impactTestSources() *ImpactSources {
+	return &ImpactSources{
+		Functions: []*fv1.Function{
+			impactTestFunction("fn-b", testPkgName, metav1.NamespaceDefault, fv1.ExecutorTypeNewdeploy),
+			impactTestFunction("fn-a", testPkgName, "", ""),
+			impactTestFunction("fn-other", "other-pkg", metav1.NamespaceDefault, ""),
+		},
+		HTTPTriggers: []*fv1.HTTPTrigger{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: metav1.NamespaceDefault},
+				Spec: fv1.HTTPTriggerSpec{FunctionReference: fv1.FunctionReference{
+					Type:            fv1.FunctionReferenceTypeFunctionWeights,
+					FunctionWeights: map[string]int{"fn-a": 90, "fn-b": 10},
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: metav1.NamespaceDefault},
+				Spec: fv1.HTTPTriggerSpec{FunctionReference: fv1.FunctionReference{
+					Type: fv1.FunctionReferenceTypeFunctionName,
+					Name: "fn-other",
+				}},
+			},
+		},
+		TimeTriggers: []*fv1.TimeTrigger{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: metav1.NamespaceDefault},
+				Spec: fv1.TimeTriggerSpec{FunctionReference: fv1.FunctionReference{
+					Type: fv1.FunctionReferenceTypeFunctionName,
+					Name: "fn-b",
+				}},
+			},
+		},
+	}
+}
+
+func impactTestPackage() *fv1.Package {
+	return &fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: testPkgName, Namespace: metav1.NamespaceDefault, ResourceVersion: "42"},
+	}
+}
+
+func TestResolvePackageImpact(t *testing.T) {
+	impact := ResolvePackageImpact(impactTestPackage(), impactTestSources())
+
+	expected := &PackageImpact{
+		Name:            testPkgName,
+		Namespace:       metav1.NamespaceDefault,
+		ResourceVersion: "42",
+		Functions: []FunctionImpact{
+			{
+				Name:                   "fn-a",
+				Namespace:              metav1.NamespaceDefault,
+				ExecutorType:           fv1.ExecutorTypePoolmgr,
+				UpdatePolicy:           FunctionUpdateManual,
+				PackageResourceVersion: "41",
+				Triggers:               []TriggerImpact{{Kind: TriggerKindHTTP, Name: "canary"}},
+			},
+			{
+				Name:                   "fn-b",
+				Namespace:              metav1.NamespaceDefault,
+				ExecutorType:           fv1.ExecutorTypeNewdeploy,
+				UpdatePolicy:           FunctionUpdateAutomatic,
+				PackageResourceVersion: "41",
+				Triggers: []TriggerImpact{
+					{Kind: TriggerKindHTTP, Name: "canary"},
+					{Kind: TriggerKindTime, Name: "nightly"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(impact, expected) {
+		t.Errorf("Expected impact %+v, got %+v", expected, impact)
+	}
+}
+
+func TestFunctionsReferencingPackage(t *testing.T) {
+	var fns []fv1.Function
+	for _, fn := range impactTestSources().Functions {
+		fns = append(fns, *fn)
+	}
+	// a reference to a package of the same name in another namespace
+	fns = append(fns, *impactTestFunction("fn-c", testPkgName, "other", ""))
+
+	refs := FunctionsReferencingPackage(fns, testPkgName, metav1.NamespaceDefault)
+	var names []string
+	for _, fn := range refs {
+		names = append(names, fn.ObjectMeta.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"fn-b", "fn-a"}) {
+		t.Errorf("Expected functions [fn-b fn-a], got %v", names)
+	}
+	if refs := FunctionsReferencingPackage(fns, "unused", metav1.NamespaceDefault); len(refs) != 0 {
+		t.Errorf("Expected no functions, got %d", len(refs))
+	}
+}
+
+func TestPackageImpactHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := impactTestSources()
+	fissionClient := fClient.NewSimpleClientset(impactTestPackage(), src.Functions[0], src.Functions[1], src.Functions[2],
+		src.HTTPTriggers[0], src.HTTPTriggers[1], src.TimeTriggers[0])
+	factory := genInformer.NewSharedInformerFactory(fissionClient, 0).Core().V1()
+	ns := metav1.NamespaceDefault
+	resolver := &impactResolver{
+		pkgInformer:       map[string]k8sCache.SharedIndexInformer{ns: factory.Packages().Informer()},
+		fnInformer:        map[string]k8sCache.SharedIndexInformer{ns: factory.Functions().Informer()},
+		httpInformer:      map[string]k8sCache.SharedIndexInformer{ns: factory.HTTPTriggers().Informer()},
+		timeInformer:      map[string]k8sCache.SharedIndexInformer{ns: factory.TimeTriggers().Informer()},
+		mqInformer:        map[string]k8sCache.SharedIndexInformer{ns: factory.MessageQueueTriggers().Informer()},
+		kubeWatchInformer: map[string]k8sCache.SharedIndexInformer{ns: factory.KubernetesWatchTriggers().Informer()},
+	}
+	api := &builderMgrAPI{logger: loggerfactory.GetLogger(), impact: resolver}
+	server := httptest.NewServer(api.GetHandler())
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("/v2/packages/default/" + testPkgName + "/impact")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d before the caches sync, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	go resolver.pkgInformer[ns].Run(ctx.Done())
+	resolver.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp = get("/v2/packages/default/" + testPkgName + "/impact")
+		if resp.StatusCode != http.StatusServiceUnavailable || time.Now().After(deadline) {
+			break
+		}
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var impact PackageImpact
+	err := json.NewDecoder(resp.Body).Decode(&impact)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&impact, ResolvePackageImpact(impactTestPackage(), src)) {
+		t.Errorf("Expected impact resolved from the informer caches, got %+v", impact)
+	}
+
+	for _, path := range []string{"/v2/packages/default/missing/impact", "/v2/packages/unwatched/" + testPkgName + "/impact"} {
+		resp = get(path)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusNotFound, path, resp.StatusCode)
+		}
+	}
+}
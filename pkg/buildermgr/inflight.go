@@ -0,0 +1,79 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"sort"
+	"time"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// inFlightBuild returns the report of the build.
+func (b *pkgBuild) inFlightBuild() InFlightBuild {
+	build := InFlightBuild{
+		Name:            b.pkg.ObjectMeta.Name,
+		Namespace:       b.pkg.ObjectMeta.Namespace,
+		ResourceVersion: b.pkg.ObjectMeta.ResourceVersion,
+		BuildID:         b.id,
+		State:           buildState(b.state.Load()).String(),
+		Trigger:         b.trigger,
+		Attempt:         b.attempt,
+	}
+	if started, ok := b.started.Load().(time.Time); ok {
+		build.StartTime = &started
+	}
+	if phase, ok := b.phase.Load().(fv1.BuildPhase); ok {
+		build.Phase = phase
+	}
+	if pod, ok := b.builderPod.Load().(string); ok {
+		build.BuilderPod = pod
+	}
+	return build
+}
+
+// InFlightBuilds returns the builds in the build cache, sorted by package
+// namespace and name, then start time. Builds of the package namespace and
+// name only are returned if they're given.
+func (pkgw *packageWatcher) InFlightBuilds(namespace, name string) []InFlightBuild {
+	builds := []InFlightBuild{}
+	for _, v := range pkgw.buildCache.Copy() {
+		b, ok := v.(*pkgBuild)
+		if !ok {
+			continue
+		}
+		if (len(namespace) > 0 && b.pkg.ObjectMeta.Namespace != namespace) ||
+			(len(name) > 0 && b.pkg.ObjectMeta.Name != name) {
+			continue
+		}
+		builds = append(builds, b.inFlightBuild())
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		if builds[i].Namespace != builds[j].Namespace {
+			return builds[i].Namespace < builds[j].Namespace
+		}
+		if builds[i].Name != builds[j].Name {
+			return builds[i].Name < builds[j].Name
+		}
+		// queued builds last
+		if builds[i].StartTime == nil || builds[j].StartTime == nil {
+			return builds[j].StartTime == nil && builds[i].StartTime != nil
+		}
+		return builds[i].StartTime.Before(*builds[j].StartTime)
+	})
+	return builds
+}
@@ -0,0 +1,94 @@
+package buildermgr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/cache"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestInFlightBuildsHandler(t *testing.T) {
+	pkgw := &packageWatcher{buildCache: cache.MakeCache(0, 0)}
+	pkgw.leading.Store(true)
+	started := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	for _, b := range []*pkgBuild{
+		{
+			key:     "default-queued-1",
+			pkg:     &fv1.Package{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "queued", ResourceVersion: "1"}},
+			trigger: fv1.BuildTriggerPackageCreated,
+			attempt: 1,
+		},
+		{
+			key:     "default-running-2",
+			pkg:     &fv1.Package{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "running", ResourceVersion: "2"}},
+			id:      "default-running-2-2",
+			trigger: fv1.BuildTriggerSpecChanged,
+			attempt: 2,
+		},
+	} {
+		if b.id != "" {
+			b.state.Store(int32(buildStateRunning))
+			b.started.Store(started)
+			b.phase.Store(fv1.BuildPhaseBuilding)
+			b.builderPod.Store(testNamespace + "/builder-pod")
+		}
+		_, err := pkgw.buildCache.Set(b.key, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	api := &builderMgrAPI{logger: loggerfactory.GetLogger(), builds: pkgw}
+	server := httptest.NewServer(api.GetHandler())
+	defer server.Close()
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(v)
+			if err != nil {
+				t.Fatalf("Error decoding %s response: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var builds []InFlightBuild
+	if code := get("/v2/builds", &builds); code != http.StatusOK {
+		t.Fatalf("Expected builds listed, got status %d", code)
+	}
+	if len(builds) != 2 {
+		t.Fatalf("Expected 2 builds in flight, got %+v", builds)
+	}
+	queued, running := builds[0], builds[1]
+	if queued.Name != "queued" || queued.State != "pending" || queued.StartTime != nil ||
+		queued.Trigger != fv1.BuildTriggerPackageCreated || queued.Attempt != 1 {
+		t.Errorf("Unexpected queued build %+v", queued)
+	}
+	if running.Name != "running" || running.State != "running" || running.Phase != fv1.BuildPhaseBuilding ||
+		running.BuildID != "default-running-2-2" || running.Attempt != 2 || running.ResourceVersion != "2" ||
+		running.BuilderPod != testNamespace+"/builder-pod" || running.StartTime == nil || !running.StartTime.Equal(started) {
+		t.Errorf("Unexpected running build %+v", running)
+	}
+
+	var build InFlightBuild
+	if code := get("/v2/builds/"+testNamespace+"/running", &build); code != http.StatusOK {
+		t.Fatalf("Expected build of running package, got status %d", code)
+	}
+	if build.Name != "running" || build.BuildID != running.BuildID {
+		t.Errorf("Expected build of running package, got %+v", build)
+	}
+	if code := get("/v2/builds/"+testNamespace+"/missing", &build); code != http.StatusNotFound {
+		t.Errorf("Expected not found for package without build, got status %d", code)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// leaseDuration is the time a standby builder manager waits before
+	// taking over the lease of a leader that stopped renewing it.
+	leaseDuration = 15 * time.Second
+	// leaseRenewDeadline is the time the leader tries to renew the lease
+	// before it gives up the leadership.
+	leaseRenewDeadline = 10 * time.Second
+	// leaseRetryPeriod is the interval between two lease acquisition or
+	// renewal attempts.
+	leaseRetryPeriod = 2 * time.Second
+)
+
+var errLostLeadership = errors.New("lost builder manager leadership")
+
+// leaderElection runs the package watcher of the builder manager replica
+// leading the package builds. The other replicas stand by with warm
+// informer caches, so that the builds are run once.
+type leaderElection struct {
+	logger    *zap.Logger
+	k8sClient kubernetes.Interface
+	namespace string
+	lease     string
+	identity  string
+	// lead starts the package builds once the replica leads, with a
+	// context done when the leadership ends.
+	lead func(ctx context.Context)
+	// stop stops the package builds of the leader, giving running builds
+	// the grace period to finish. The lease is released afterwards, so
+	// that the next leader doesn't build them again.
+	stop        func(grace time.Duration)
+	gracePeriod time.Duration
+	// newLeader is told the identity of every new leader, standby
+	// replicas forward build requests to it. Optional.
+	newLeader func(identity string)
+}
+
+// replicaIdentity returns the identity of the builder manager replica in
+// the leader election, the pod name.
+func replicaIdentity() string {
+	if name := os.Getenv("POD_NAME"); len(name) > 0 {
+		return name
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "buildermgr"
+	}
+	return name
+}
+
+// run campaigns for the lease until ctx is done and runs the package builds
+// while leading. It returns errLostLeadership if the replica stops leading
+// before ctx is done: the package event handlers can't be removed, the
+// replica is restarted to stand by again.
+func (le *leaderElection) run(ctx context.Context) error {
+	logger := le.logger.With(zap.String("lease", le.namespace+"/"+le.lease), zap.String("replica", le.identity))
+	builderMgrLeader.WithLabelValues(le.identity).Set(0)
+
+	// the lease is released once the builds stopped, not when ctx is done
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	defer cancelElection()
+	leading := make(chan struct{})
+	lost := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: le.namespace,
+				Name:      le.lease,
+			},
+			Client:     le.k8sClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: le.identity},
+		},
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            le.lease,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				logger.Info("leading the builder managers, starting package builds")
+				builderMgrLeader.WithLabelValues(le.identity).Set(1)
+				close(leading)
+				le.lead(leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				builderMgrLeader.WithLabelValues(le.identity).Set(0)
+				close(lost)
+			},
+			OnNewLeader: func(identity string) {
+				if le.newLeader != nil {
+					le.newLeader(identity)
+				}
+				if identity != le.identity {
+					logger.Info("standing by, another builder manager leads the package builds", zap.String("leader", identity))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error creating leader elector")
+	}
+	done := make(chan struct{})
+	go func() {
+		elector.Run(electionCtx)
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		select {
+		case <-leading:
+			le.stop(le.gracePeriod)
+		default:
+		}
+		cancelElection()
+		<-done
+		return nil
+	case <-lost:
+		// the next leader may take over any moment, running
+		// builds are canceled right away
+		logger.Error("lost builder manager leadership, stopping package builds")
+		le.stop(0)
+		<-done
+		return errLostLeadership
+	}
+}
@@ -0,0 +1,149 @@
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestLeaderElectionFailover(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	var leaders atomic.Int32
+	newReplica := func(identity string) (*leaderElection, chan struct{}, chan time.Duration) {
+		leading := make(chan struct{}, 1)
+		stopped := make(chan time.Duration, 1)
+		return &leaderElection{
+			logger:    loggerfactory.GetLogger(),
+			k8sClient: k8sClient,
+			namespace: testNamespace,
+			lease:     "buildermgr",
+			identity:  identity,
+			lead: func(ctx context.Context) {
+				if leaders.Add(1) > 1 {
+					t.Errorf("Expected a single leader, %s leads too", identity)
+				}
+				leading <- struct{}{}
+			},
+			stop: func(grace time.Duration) {
+				leaders.Add(-1)
+				stopped <- grace
+			},
+			gracePeriod: defaultBuildShutdownGracePeriod,
+		}, leading, stopped
+	}
+
+	first, firstLeading, firstStopped := newReplica("first")
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- first.run(firstCtx) }()
+	select {
+	case <-firstLeading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the first replica to lead")
+	}
+
+	second, secondLeading, secondStopped := newReplica("second")
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	secondDone := make(chan error, 1)
+	go func() { secondDone <- second.run(secondCtx) }()
+	select {
+	case <-secondLeading:
+		t.Fatal("Expected the second replica to stand by")
+	case <-time.After(2 * leaseRetryPeriod):
+	}
+
+	// the leader stops its builds before it releases the lease
+	stopFirst()
+	if grace := <-firstStopped; grace != defaultBuildShutdownGracePeriod {
+		t.Errorf("Expected the builds to get the grace period %v, got %v", defaultBuildShutdownGracePeriod, grace)
+	}
+	if err := <-firstDone; err != nil {
+		t.Errorf("Expected the first replica to stop without error, got %v", err)
+	}
+	select {
+	case <-secondLeading:
+	case <-time.After(leaseDuration):
+		t.Fatal("Expected the second replica to take over within the lease duration")
+	}
+
+	stopSecond()
+	<-secondStopped
+	if err := <-secondDone; err != nil {
+		t.Errorf("Expected the second replica to stop without error, got %v", err)
+	}
+}
+
+func TestStandbyForwardsCancelToLeader(t *testing.T) {
+	var forwarded atomic.Int32
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/packages/"+testNamespace+"/"+testPkgName+"/cancel" || len(r.Header.Get(headerForwardedByStandby)) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		forwarded.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer leader.Close()
+	leaderURL, err := url.Parse(leader.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(leaderURL.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	standby := &builderMgrAPI{
+		logger: loggerfactory.GetLogger(),
+		builds: &packageWatcher{},
+		k8sClient: fake.NewSimpleClientset(&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "leader"},
+			Status:     apiv1.PodStatus{PodIP: leaderURL.Hostname()},
+		}),
+		namespace: testNamespace,
+		port:      port,
+	}
+	server := httptest.NewServer(standby.GetHandler())
+	defer server.Close()
+	cancel := func(header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/v2/packages/"+testNamespace+"/"+testPkgName+"/cancel", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != nil {
+			req.Header = header
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// the leader isn't known yet
+	if resp := cancel(nil); resp.StatusCode != http.StatusServiceUnavailable || len(resp.Header.Get("Retry-After")) == 0 {
+		t.Errorf("Expected status %d with Retry-After, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	standby.setLeader("leader")
+	if resp := cancel(nil); resp.StatusCode != http.StatusAccepted || forwarded.Load() != 1 {
+		t.Errorf("Expected the cancel forwarded to the leader, got status %d and %d forwarded requests", resp.StatusCode, forwarded.Load())
+	}
+	// forwarded requests aren't forwarded again
+	if resp := cancel(http.Header{headerForwardedByStandby: []string{"true"}}); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d for a forwarded request, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
@@ -0,0 +1,248 @@
+package buildermgr
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils/metrics"
+)
+
+// build results
+const (
+	buildResultSucceeded = "succeeded"
+	buildResultFailed    = "failed"
+	buildResultTimeout   = "timeout"
+	// buildResultUnchanged counts the builds skipped because the package
+	// source is unchanged
+	buildResultUnchanged = "unchanged"
+)
+
+var (
+	builderLabels    = []string{"environment", "environment_namespace"}
+	builderDiskUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_builder_disk_usage_percent",
+			Help: "Percentage of the builder shared volume in use",
+		},
+		builderLabels,
+	)
+	eventDecodeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_buildermgr_event_decode_errors_total",
+			Help: "Count of informer events dropped because of an unexpected object type",
+		},
+		[]string{"informer", "event"},
+	)
+	buildsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_builds_total",
+			Help: "Count of finished package builds by result",
+		},
+		append(builderLabels, "result"),
+	)
+	buildsStarted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_builds_started_total",
+			Help: "Count of started package build attempts by build trigger",
+		},
+		append(builderLabels, "trigger"),
+	)
+	buildDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_duration_seconds",
+			Help:    "Duration of package build attempts",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+		},
+		builderLabels,
+	)
+	buildPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_phase_duration_seconds",
+			Help:    "Duration of the fetch, build and upload phases of package builds",
+			Buckets: []float64{0.1, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		},
+		append(builderLabels, "phase"),
+	)
+	buildPhaseFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_build_phase_failures_total",
+			Help: "Count of failed package builds by failed phase and failure class",
+		},
+		append(builderLabels, "phase", "class"),
+	)
+	builderWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_builder_wait_seconds",
+			Help:    "Time package builds spent waiting for the environment builder pod to be ready",
+			Buckets: []float64{0.1, 1, 5, 10, 30, 60, 120, 300, 600},
+		},
+		builderLabels,
+	)
+	artifactUnavailable = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_build_artifact_unavailable_total",
+			Help: "Count of package builds failed because the deployment archive wasn't downloadable after the upload",
+		},
+		builderLabels,
+	)
+	buildQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_package_build_queue_depth",
+			Help: "Number of package builds by state: pending, running or waiting for a ready builder pod",
+		},
+		[]string{"environment_namespace", "state"},
+	)
+	envPoolRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_package_build_pool_running",
+			Help: "Number of package builds running in the build worker pool of the environment",
+		},
+		builderLabels,
+	)
+	envPoolQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_package_build_pool_queued",
+			Help: "Number of package builds of the environment waiting in the build queue",
+		},
+		builderLabels,
+	)
+	buildCPUSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_cpu_seconds",
+			Help:    "CPU time consumed by package build commands, as reported by the environment builder",
+			Buckets: []float64{0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		},
+		builderLabels,
+	)
+	buildPeakMemory = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_peak_memory_bytes",
+			Help:    "Peak memory used by package build commands, as reported by the environment builder",
+			Buckets: prometheus.ExponentialBuckets(16<<20, 2, 10),
+		},
+		builderLabels,
+	)
+	builderFetchFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_builder_source_fetch_failures_total",
+			Help: "Count of source fetches a builder pod failed to verify, e.g. because of a checksum mismatch",
+		},
+		[]string{"pod"},
+	)
+	builderPodsRecycled = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fission_builder_pods_recycled_total",
+			Help: "Count of builder pods deleted because of repeated source fetch failures",
+		},
+	)
+	packageRefUpdates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_build_function_updates_total",
+			Help: "Count of function package references updated after package builds",
+		},
+		[]string{"namespace"},
+	)
+	buildsSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_builds_suppressed_total",
+			Help: "Count of package rebuilds delayed by the rebuild cooldown, the source being unchanged since the last build",
+		},
+		builderLabels,
+	)
+	buildStorageDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_package_build_storage_duration_seconds",
+			Help:    "Duration of the source archive downloads and deployment archive uploads of package builds",
+			Buckets: []float64{0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+		},
+		append(builderLabels, "operation"),
+	)
+	buildStorageBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_build_storage_bytes_total",
+			Help: "Bytes of the source archives downloaded and deployment archives uploaded by package builds",
+		},
+		append(builderLabels, "operation"),
+	)
+	buildStorageErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_package_build_storage_errors_total",
+			Help: "Count of failed source archive downloads and deployment archive uploads of package builds",
+		},
+		append(builderLabels, "operation"),
+	)
+	builderMgrLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_buildermgr_leader",
+			Help: "Whether the builder manager replica leads the package builds (1) or stands by (0)",
+		},
+		[]string{"replica"},
+	)
+)
+
+func init() {
+	registry := metrics.Registry
+	registry.MustRegister(builderDiskUsage)
+	registry.MustRegister(eventDecodeErrors)
+	registry.MustRegister(buildsTotal)
+	registry.MustRegister(buildsStarted)
+	registry.MustRegister(buildDuration)
+	registry.MustRegister(buildPhaseDuration)
+	registry.MustRegister(buildPhaseFailures)
+	registry.MustRegister(builderWaitDuration)
+	registry.MustRegister(packageRefUpdates)
+	registry.MustRegister(buildQueueDepth)
+	registry.MustRegister(artifactUnavailable)
+	registry.MustRegister(envPoolRunning)
+	registry.MustRegister(envPoolQueued)
+	registry.MustRegister(buildCPUSeconds)
+	registry.MustRegister(buildPeakMemory)
+	registry.MustRegister(builderFetchFailures)
+	registry.MustRegister(builderPodsRecycled)
+	registry.MustRegister(builderMgrLeader)
+	registry.MustRegister(buildsSuppressed)
+	registry.MustRegister(buildStorageDuration)
+	registry.MustRegister(buildStorageBytes)
+	registry.MustRegister(buildStorageErrors)
+}
+
+func observeBuildResult(pkg *fv1.Package, result string) {
+	buildsTotal.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace, result).Inc()
+}
+
+func observeBuildSuppressed(pkg *fv1.Package) {
+	buildsSuppressed.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace).Inc()
+}
+
+func observeBuildStarted(pkg *fv1.Package, trigger fv1.BuildTrigger) {
+	buildsStarted.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace, string(trigger)).Inc()
+}
+
+func observeBuildDuration(pkg *fv1.Package, start time.Time) {
+	buildDuration.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace).
+		Observe(time.Since(start).Seconds())
+}
+
+// observeBuildPhases observes the durations of the build phases and the
+// failed phase of a failed build.
+func observeBuildPhases(pkg *fv1.Package, result *packageBuildResult, err error) {
+	envName, envNamespace := pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace
+	for phase, d := range result.durations {
+		buildPhaseDuration.WithLabelValues(envName, envNamespace, phase).Observe(d.Seconds())
+	}
+	if err != nil && len(result.failedPhase) > 0 {
+		buildPhaseFailures.WithLabelValues(envName, envNamespace, result.failedPhase, buildFailureClass(err)).Inc()
+	}
+}
+
+func observeBuilderWait(pkg *fv1.Package, start time.Time) {
+	builderWaitDuration.WithLabelValues(pkg.Spec.Environment.Name, pkg.Spec.Environment.Namespace).
+		Observe(time.Since(start).Seconds())
+}
+
+func observeBuildResourceUsage(envName, envNamespace string, usage *fv1.BuildResourceUsage) {
+	buildCPUSeconds.WithLabelValues(envName, envNamespace).Observe(float64(usage.CPUMilliseconds) / 1000)
+	buildPeakMemory.WithLabelValues(envName, envNamespace).Observe(float64(usage.PeakMemoryBytes))
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/flowcontrol"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+	"github.com/fission/fission/pkg/utils"
+)
+
+const (
+	// defaultMigrationQPS limits the API server requests of a literal migration.
+	defaultMigrationQPS = 5
+	// migrationPageSize is the number of packages listed at once, so that
+	// the literals of every package are never in memory together.
+	migrationPageSize = 50
+)
+
+type (
+	// archiveStore stores the migrated literals, it's implemented by the
+	// storage service client.
+	archiveStore interface {
+		UploadWithOptions(ctx context.Context, filePath string, opts storageSvcClient.UploadOptions) (*storageSvcClient.UploadResult, error)
+		GetTargetUrl(id string, target string) string
+		DeleteTarget(ctx context.Context, id string, target string) error
+	}
+
+	// literalMigrator moves large literal archives out of package specs,
+	// and so out of etcd, into the storage service.
+	literalMigrator struct {
+		logger        *zap.Logger
+		fissionClient versioned.Interface
+		store         archiveStore
+		// storageSvcUrl is the storage service of store, namespaces
+		// mapped to another storage service get a store of theirs.
+		storageSvcUrl string
+	}
+
+	// migratedArchive is a literal uploaded to the storage service.
+	migratedArchive struct {
+		id     string
+		target string
+	}
+)
+
+func makeLiteralMigrator(logger *zap.Logger, fissionClient versioned.Interface, storageSvcUrl string) *literalMigrator {
+	return &literalMigrator{
+		logger:        logger.Named("literal_migrator"),
+		fissionClient: fissionClient,
+		store:         storageSvcClient.MakeClient(storageSvcUrl),
+		storageSvcUrl: storageSvcUrl,
+	}
+}
+
+// storeFor returns the store of the archives of the namespace packages.
+func (m *literalMigrator) storeFor(logger *zap.Logger, namespace string) archiveStore {
+	if storageSvcURL := storageSvcURLFor(logger, namespace, m.storageSvcUrl); storageSvcURL != m.storageSvcUrl {
+		return storageSvcClient.MakeClient(storageSvcURL)
+	}
+	return m.store
+}
+
+// migrate moves the literal archives of at least the threshold size of the
+// requested packages, all of the namespace or the named one, to the storage
+// service. Packages are migrated one by one, a package that fails to migrate
+// is left untouched. Migrated packages have no literal left, so running the
+// migration again resumes it.
+func (m *literalMigrator) migrate(ctx context.Context, req *LiteralMigrationRequest) (*LiteralMigrationResponse, error) {
+	if req.Threshold <= 0 {
+		return nil, errors.Errorf("invalid threshold %d, must be positive", req.Threshold)
+	}
+	if len(req.Name) > 0 && len(req.Namespace) == 0 {
+		return nil, errors.Errorf("package %q has no namespace", req.Name)
+	}
+	qps := req.QPS
+	if qps <= 0 {
+		qps = defaultMigrationQPS
+	}
+	limiter := flowcontrol.NewTokenBucketRateLimiter(qps, 1)
+	defer limiter.Stop()
+
+	resp := &LiteralMigrationResponse{
+		DryRun:  req.DryRun,
+		Results: []LiteralMigrationResult{},
+	}
+	add := func(result LiteralMigrationResult) {
+		switch result.Status {
+		case LiteralMigrationMigrated:
+			resp.Migrated++
+		case LiteralMigrationSkipped:
+			resp.Skipped++
+		case LiteralMigrationFailed:
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	if len(req.Name) > 0 {
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pkg, err := m.fissionClient.CoreV1().Packages(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			add(LiteralMigrationResult{Name: req.Name, Namespace: req.Namespace,
+				Status: LiteralMigrationFailed, Message: "package not found"})
+		} else if err != nil {
+			return nil, errors.Wrap(err, "error getting package")
+		} else {
+			add(m.migratePackage(ctx, limiter, pkg, req))
+		}
+	} else {
+		err := m.migratePackages(ctx, limiter, req, add)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.logger.Info("literal migration finished",
+		zap.String("namespace", req.Namespace),
+		zap.Bool("dry_run", req.DryRun),
+		zap.Int("migrated", resp.Migrated),
+		zap.Int("skipped", resp.Skipped),
+		zap.Int("failed", resp.Failed))
+	return resp, nil
+}
+
+// migratePackages migrates the packages of the requested namespace page by
+// page, the API server lists them ordered by namespace and name.
+func (m *literalMigrator) migratePackages(ctx context.Context, limiter flowcontrol.RateLimiter,
+	req *LiteralMigrationRequest, add func(LiteralMigrationResult)) error {
+
+	opts := metav1.ListOptions{Limit: migrationPageSize}
+	for {
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		pkgList, err := m.fissionClient.CoreV1().Packages(req.Namespace).List(ctx, opts)
+		if err != nil {
+			return errors.Wrap(err, "error listing packages")
+		}
+		pkgs := pkgList.Items
+		sort.Slice(pkgs, func(i, j int) bool {
+			if pkgs[i].ObjectMeta.Namespace != pkgs[j].ObjectMeta.Namespace {
+				return pkgs[i].ObjectMeta.Namespace < pkgs[j].ObjectMeta.Namespace
+			}
+			return pkgs[i].ObjectMeta.Name < pkgs[j].ObjectMeta.Name
+		})
+		for i := range pkgs {
+			add(m.migratePackage(ctx, limiter, &pkgs[i], req))
+		}
+		if pkgList.ListMeta.Continue == "" {
+			break
+		}
+		opts.Continue = pkgList.ListMeta.Continue
+	}
+	return nil
+}
+
+func (m *literalMigrator) migratePackage(ctx context.Context, limiter flowcontrol.RateLimiter,
+	pkg *fv1.Package, req *LiteralMigrationRequest) LiteralMigrationResult {
+
+	result := LiteralMigrationResult{
+		Name:      pkg.ObjectMeta.Name,
+		Namespace: pkg.ObjectMeta.Namespace,
+	}
+
+	archives := map[string]*fv1.Archive{
+		"source":     &pkg.Spec.Source,
+		"deployment": &pkg.Spec.Deployment,
+	}
+	for _, name := range []string{"source", "deployment"} {
+		archive := archives[name]
+		if archive.Type == fv1.ArchiveTypeLiteral && int64(len(archive.Literal)) >= req.Threshold {
+			result.Archives = append(result.Archives, name)
+			result.Size += int64(len(archive.Literal))
+		}
+	}
+	if len(result.Archives) == 0 {
+		result.Status = LiteralMigrationSkipped
+		result.Message = "no literal archive above threshold"
+		return result
+	}
+	if pkg.Status.BuildStatus == fv1.BuildStatusRunning {
+		// the build updates the package when it's done, leave it alone
+		result.Status = LiteralMigrationSkipped
+		result.Message = "build in progress"
+		return result
+	}
+	if req.DryRun {
+		result.Status = LiteralMigrationMigrated
+		return result
+	}
+
+	logger := m.logger.With(zap.String("package_name", pkg.ObjectMeta.Name), zap.String("namespace", pkg.ObjectMeta.Namespace))
+	store := m.storeFor(logger, pkg.ObjectMeta.Namespace)
+	fail := func(err error, uploaded []migratedArchive) LiteralMigrationResult {
+		logger.Error("error migrating package literals", zap.Error(err))
+		// the package is untouched, the uploaded archives are of no use
+		for _, a := range uploaded {
+			er := store.DeleteTarget(ctx, a.id, a.target)
+			if er != nil {
+				logger.Warn("error deleting uploaded archive", zap.String("archive_id", a.id), zap.Error(er))
+			}
+		}
+		result.Status = LiteralMigrationFailed
+		result.Message = err.Error()
+		return result
+	}
+
+	target := storageTargetFor(logger, pkg)
+	var uploaded []migratedArchive
+	for _, name := range result.Archives {
+		archive := archives[name]
+		id, migrated, err := uploadLiteral(ctx, store, archive.Literal, target)
+		if err != nil {
+			return fail(errors.Wrapf(err, "error uploading %s literal", name), uploaded)
+		}
+		uploaded = append(uploaded, migratedArchive{id: id, target: target})
+		*archive = *migrated
+	}
+
+	err := limiter.Wait(ctx)
+	if err != nil {
+		return fail(err, uploaded)
+	}
+	_, err = m.fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
+	if k8serrors.IsConflict(err) {
+		return fail(errors.New("package changed during migration, run the migration again"), uploaded)
+	} else if err != nil {
+		return fail(errors.Wrap(err, "error updating package"), uploaded)
+	}
+
+	logger.Info("migrated package literals", zap.Strings("archives", result.Archives), zap.Int64("size", result.Size))
+	result.Status = LiteralMigrationMigrated
+	return result
+}
+
+// uploadLiteral uploads the literal to the store and returns the archive
+// referencing it.
+func uploadLiteral(ctx context.Context, store archiveStore, literal []byte, target string) (string, *fv1.Archive, error) {
+	f, err := os.CreateTemp("", "literal-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(literal)
+	f.Close()
+	if err != nil {
+		return "", nil, err
+	}
+
+	contentType := "application/octet-stream"
+	if isZip, _ := utils.IsZip(f.Name()); isZip {
+		contentType = "application/zip"
+	}
+	result, err := store.UploadWithOptions(ctx, f.Name(), storageSvcClient.UploadOptions{
+		ContentType: contentType,
+		Target:      target,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum, err := utils.GetChecksum(bytes.NewReader(literal))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error calculating checksum")
+	}
+	return result.ID, &fv1.Archive{
+		Type:     fv1.ArchiveTypeUrl,
+		URL:      store.GetTargetUrl(result.ID, target),
+		Checksum: *sum,
+	}, nil
+}
@@ -0,0 +1,229 @@
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sTesting "k8s.io/client-go/testing"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+	"github.com/fission/fission/pkg/utils"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+type fakeArchiveStore struct {
+	uploads   int
+	deleted   []string
+	failAt    int
+	deleteErr error
+}
+
+func (s *fakeArchiveStore) UploadWithOptions(ctx context.Context, filePath string, opts storageSvcClient.UploadOptions) (*storageSvcClient.UploadResult, error) {
+	s.uploads++
+	if s.uploads == s.failAt {
+		return nil, errors.New("storage service unavailable")
+	}
+	return &storageSvcClient.UploadResult{ID: fmt.Sprintf("archive-%d", s.uploads)}, nil
+}
+
+func (s *fakeArchiveStore) GetTargetUrl(id string, target string) string {
+	return "http://storagesvc/v1/archive?id=" + id
+}
+
+func (s *fakeArchiveStore) DeleteTarget(ctx context.Context, id string, target string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+func literalPackage(name string, size int, status fv1.BuildStatus) *fv1.Package {
+	return &fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: fv1.PackageSpec{
+			Environment: fv1.EnvironmentReference{Name: testEnvName, Namespace: testNamespace},
+			Deployment: fv1.Archive{
+				Type:    fv1.ArchiveTypeLiteral,
+				Literal: bytes.Repeat([]byte("a"), size),
+			},
+		},
+		Status: fv1.PackageStatus{BuildStatus: status},
+	}
+}
+
+func TestMigrateLiterals(t *testing.T) {
+	ctx := context.Background()
+	fissionClient := fClient.NewSimpleClientset(
+		literalPackage("large", 2048, fv1.BuildStatusNone),
+		literalPackage("small", 10, fv1.BuildStatusNone),
+		literalPackage("building", 2048, fv1.BuildStatusRunning),
+		literalPackage("conflict", 2048, fv1.BuildStatusNone),
+	)
+	fissionClient.PrependReactor("update", "packages", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		pkg := action.(k8sTesting.UpdateAction).GetObject().(*fv1.Package)
+		if pkg.ObjectMeta.Name == "conflict" {
+			return true, nil, errors.New("conflict")
+		}
+		return false, nil, nil
+	})
+	store := &fakeArchiveStore{}
+	m := &literalMigrator{
+		logger:        loggerfactory.GetLogger(),
+		fissionClient: fissionClient,
+		store:         store,
+	}
+
+	dryRun, err := m.migrate(ctx, &LiteralMigrationRequest{Threshold: 1024, DryRun: true, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running dry run migration: %v", err)
+	}
+	if dryRun.Migrated != 2 || dryRun.Skipped != 2 || dryRun.Failed != 0 || store.uploads != 0 {
+		t.Errorf("Unexpected dry run result %+v with %d uploads", dryRun, store.uploads)
+	}
+
+	resp, err := m.migrate(ctx, &LiteralMigrationRequest{Threshold: 1024, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running migration: %v", err)
+	}
+	if resp.Migrated != 1 || resp.Skipped != 2 || resp.Failed != 1 {
+		t.Errorf("Unexpected migration result %+v", resp)
+	}
+	if len(store.deleted) != 1 {
+		t.Errorf("Expected the archive of the failed package to be deleted, got %v", store.deleted)
+	}
+
+	pkg, err := fissionClient.CoreV1().Packages(testNamespace).Get(ctx, "large", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	sum, err := utils.GetChecksum(bytes.NewReader(bytes.Repeat([]byte("a"), 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Spec.Deployment.Type != fv1.ArchiveTypeUrl || pkg.Spec.Deployment.Checksum.Sum != sum.Sum ||
+		len(pkg.Spec.Deployment.Literal) != 0 {
+		t.Errorf("Expected deployment literal to be replaced by a URL, got %+v", pkg.Spec.Deployment)
+	}
+	for _, name := range []string{"building", "conflict"} {
+		pkg, err := fissionClient.CoreV1().Packages(testNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting package: %v", err)
+		}
+		if pkg.Spec.Deployment.Type != fv1.ArchiveTypeLiteral {
+			t.Errorf("Expected package %s to be untouched, got %+v", name, pkg.Spec.Deployment)
+		}
+	}
+
+	// the migrated package is skipped when running again
+	uploads := store.uploads
+	resp, err = m.migrate(ctx, &LiteralMigrationRequest{Threshold: 1024, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running migration again: %v", err)
+	}
+	if resp.Migrated != 0 || store.uploads != uploads+1 {
+		t.Errorf("Expected only the failed package to be retried, got %+v with %d uploads", resp, store.uploads-uploads)
+	}
+}
+
+func TestMigratedSourceIsNoBuildInputChange(t *testing.T) {
+	literal := []byte("source code")
+	sum, err := utils.GetChecksum(bytes.NewReader(literal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPkg := literalPackage("pkg", 0, fv1.BuildStatusSucceeded)
+	oldPkg.Spec.Source = fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: literal}
+
+	pkg := oldPkg.DeepCopy()
+	pkg.Spec.Source = fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/v1/archive?id=1", Checksum: *sum}
+	if buildInputsChanged(oldPkg, pkg) {
+		t.Error("Expected migrated source literal not to change the build inputs")
+	}
+
+	pkg.Spec.Source.Checksum.Sum = "other"
+	if !buildInputsChanged(oldPkg, pkg) {
+		t.Error("Expected source with a different checksum to change the build inputs")
+	}
+}
+
+func TestMigrateLiteralsPaged(t *testing.T) {
+	ctx := context.Background()
+	pages := [][]fv1.Package{
+		{*literalPackage("a", 2048, fv1.BuildStatusNone), *literalPackage("b", 10, fv1.BuildStatusNone)},
+		{*literalPackage("c", 2048, fv1.BuildStatusNone)},
+	}
+	fissionClient := fClient.NewSimpleClientset()
+	lists := 0
+	fissionClient.PrependReactor("list", "packages", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		list := &fv1.PackageList{Items: pages[lists]}
+		lists++
+		if lists < len(pages) {
+			list.ListMeta.Continue = fmt.Sprintf("page-%d", lists)
+		}
+		return true, list, nil
+	})
+	m := &literalMigrator{
+		logger:        loggerfactory.GetLogger(),
+		fissionClient: fissionClient,
+		store:         &fakeArchiveStore{},
+	}
+
+	resp, err := m.migrate(ctx, &LiteralMigrationRequest{Threshold: 1024, DryRun: true, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running dry run migration: %v", err)
+	}
+	if lists != len(pages) {
+		t.Errorf("Expected %d package pages listed, got %d", len(pages), lists)
+	}
+	if resp.Migrated != 2 || resp.Skipped != 1 || len(resp.Results) != 3 {
+		t.Errorf("Expected the packages of every page migrated, got %+v", resp)
+	}
+}
+
+func TestMigrateNamedPackage(t *testing.T) {
+	ctx := context.Background()
+	fissionClient := fClient.NewSimpleClientset(
+		literalPackage("confirmed", 2048, fv1.BuildStatusNone),
+		literalPackage("created-after-dry-run", 2048, fv1.BuildStatusNone),
+	)
+	store := &fakeArchiveStore{}
+	m := &literalMigrator{
+		logger:        loggerfactory.GetLogger(),
+		fissionClient: fissionClient,
+		store:         store,
+	}
+
+	resp, err := m.migrate(ctx, &LiteralMigrationRequest{Namespace: testNamespace, Name: "confirmed", Threshold: 1024, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running migration: %v", err)
+	}
+	if resp.Migrated != 1 || len(resp.Results) != 1 || resp.Results[0].Name != "confirmed" || store.uploads != 1 {
+		t.Errorf("Expected only the named package migrated, got %+v with %d uploads", resp, store.uploads)
+	}
+	pkg, err := fissionClient.CoreV1().Packages(testNamespace).Get(ctx, "created-after-dry-run", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Spec.Deployment.Type != fv1.ArchiveTypeLiteral {
+		t.Errorf("Expected other packages untouched, got %+v", pkg.Spec.Deployment)
+	}
+
+	resp, err = m.migrate(ctx, &LiteralMigrationRequest{Namespace: testNamespace, Name: "deleted", Threshold: 1024, QPS: 100})
+	if err != nil {
+		t.Fatalf("Error running migration: %v", err)
+	}
+	if resp.Failed != 1 {
+		t.Errorf("Expected the migration of a missing package to fail, got %+v", resp)
+	}
+	if _, err = m.migrate(ctx, &LiteralMigrationRequest{Name: "confirmed", Threshold: 1024}); err == nil {
+		t.Error("Expected error for a package name without namespace")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// namespaceLimitRefreshInterval is how long the build limit read from
+	// a namespace annotation is used before the namespace is read again.
+	namespaceLimitRefreshInterval = time.Minute
+	namespaceLimitReadTimeout     = 5 * time.Second
+)
+
+type (
+	// namespaceLimits bounds the number of builds of the packages of each
+	// namespace running at the same time, so that a mass apply in one
+	// namespace doesn't take all the build slots.
+	namespaceLimits struct {
+		logger    *zap.Logger
+		k8sClient kubernetes.Interface
+		// limit is the default limit of the namespaces, zero means no
+		// limit. Namespaces override it with the max concurrent builds
+		// annotation.
+		limit   int
+		mutex   sync.Mutex
+		limits  map[string]namespaceLimit
+		running map[string]int
+	}
+
+	namespaceLimit struct {
+		limit int
+		read  time.Time
+	}
+)
+
+func newNamespaceLimits(logger *zap.Logger, k8sClient kubernetes.Interface, limit int) *namespaceLimits {
+	return &namespaceLimits{
+		logger:    logger,
+		k8sClient: k8sClient,
+		limit:     limit,
+		limits:    make(map[string]namespaceLimit),
+		running:   make(map[string]int),
+	}
+}
+
+// refresh reads the build limit of the namespace from its annotation
+// unless it was read recently. Namespaces that can't be read get the
+// default limit.
+func (n *namespaceLimits) refresh(ctx context.Context, namespace string) {
+	n.mutex.Lock()
+	cached, ok := n.limits[namespace]
+	n.mutex.Unlock()
+	if ok && time.Since(cached.read) < namespaceLimitRefreshInterval {
+		return
+	}
+
+	limit := n.limit
+	ctx, cancel := context.WithTimeout(ctx, namespaceLimitReadTimeout)
+	defer cancel()
+	ns, err := n.k8sClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		n.logger.Debug("error reading namespace build limit, using default",
+			zap.String("namespace", namespace), zap.Int("default", limit), zap.Error(err))
+	} else if v, ok := ns.ObjectMeta.Annotations[fv1.ANNOTATION_MAX_CONCURRENT_BUILDS]; ok {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 0 {
+			n.logger.Warn("invalid max concurrent builds annotation, using default",
+				zap.String("namespace", namespace),
+				zap.String("value", v),
+				zap.Int("default", limit))
+		} else {
+			limit = l
+		}
+	}
+
+	n.mutex.Lock()
+	n.limits[namespace] = namespaceLimit{limit: limit, read: time.Now()}
+	n.mutex.Unlock()
+}
+
+// available returns whether the namespace of the build package has a
+// free build slot.
+func (n *namespaceLimits) available(b *pkgBuild) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	ns := b.pkg.ObjectMeta.Namespace
+	limit := n.limit
+	if cached, ok := n.limits[ns]; ok {
+		limit = cached.limit
+	}
+	return limit <= 0 || n.running[ns] < limit
+}
+
+// acquire takes a build slot of the namespace of the build package, the
+// caller checks that one is available.
+func (n *namespaceLimits) acquire(b *pkgBuild) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.running[b.pkg.ObjectMeta.Namespace]++
+	b.namespaceSlot = true
+}
+
+// release frees the namespace build slot taken by the build.
+func (n *namespaceLimits) release(b *pkgBuild) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !b.namespaceSlot {
+		return
+	}
+	b.namespaceSlot = false
+	ns := b.pkg.ObjectMeta.Namespace
+	n.running[ns]--
+	if n.running[ns] <= 0 {
+		delete(n.running, ns)
+	}
+}
+
+// takeSlots takes the namespace and environment pool slots of the build,
+// it returns false if either of them is full. It is called with the build
+// queue locked, which serializes the slot acquisitions.
+func (pkgw *packageWatcher) takeSlots(b *pkgBuild) bool {
+	if !pkgw.namespaceLimits.available(b) || !pkgw.pools.acquire(b) {
+		return false
+	}
+	pkgw.namespaceLimits.acquire(b)
+	return true
+}
+
+// slotsAvailable returns whether the build could take its slots.
+func (pkgw *packageWatcher) slotsAvailable(b *pkgBuild) bool {
+	return pkgw.namespaceLimits.available(b) && pkgw.pools.available(b)
+}
+
+// releaseSlots frees the namespace and environment pool slots of the build.
+func (pkgw *packageWatcher) releaseSlots(b *pkgBuild) {
+	pkgw.pools.release(b)
+	pkgw.namespaceLimits.release(b)
+}
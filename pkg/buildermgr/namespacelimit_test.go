@@ -0,0 +1,84 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestNamespaceLimitsCompetingNamespaces(t *testing.T) {
+	ctx := context.Background()
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	started := make(chan string, 4)
+	tpw.deps.buildPackage = blockOnContext(started)
+
+	// team-a gets the default limit, team-b raises its own
+	for _, ns := range []*apiv1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{
+			fv1.ANNOTATION_MAX_CONCURRENT_BUILDS: "2",
+		}}},
+	} {
+		_, err := tpw.k8sClient.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating namespace: %v", err)
+		}
+	}
+	tpw.namespaceLimits = newNamespaceLimits(tpw.logger, tpw.k8sClient, 1)
+
+	build := func(namespace, name string) *fv1.Package {
+		t.Helper()
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Namespace = namespace
+		pkg.ObjectMeta.Name = name
+		pkg, err := tpw.fissionClient.CoreV1().Packages(namespace).Create(ctx, pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+		tpw.buildWithCache(pkg, fv1.BuildTriggerPackageCreated)
+		return pkg
+	}
+	first := build("team-a", "a-1")
+	second := build("team-a", "a-2")
+	third := build("team-a", "a-3")
+	build("team-b", "b-1")
+	build("team-b", "b-2")
+
+	// one build of team-a and both builds of team-b run
+	running := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case id := <-started:
+			running[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 running builds, got %d", len(running))
+		}
+	}
+	if !running[buildID(first, 1)] {
+		t.Errorf("Expected the first build of team-a to run, got %v", running)
+	}
+	queued := tpw.buildQueue.Builds()
+	if len(queued) != 2 || queued[0].pkg.ObjectMeta.Name != "a-2" || queued[1].pkg.ObjectMeta.Name != "a-3" {
+		t.Fatalf("Expected the builds over the team-a limit to stay queued in order, got %d queued builds", len(queued))
+	}
+
+	// the queued builds of team-a run in order as slots free up
+	for _, next := range []*fv1.Package{second, third} {
+		tpw.CancelBuild("team-a", first.ObjectMeta.Name)
+		select {
+		case id := <-started:
+			if id != buildID(next, 1) {
+				t.Errorf("Expected build %s to start, got %q", buildID(next, 1), id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected build %s to start", buildID(next, 1))
+		}
+		first = next
+	}
+}
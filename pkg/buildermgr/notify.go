@@ -0,0 +1,238 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// buildNotificationSignatureHeader carries the hex HMAC-SHA256 of the
+	// notification timestamp and body keyed with the shared secret,
+	// prefixed by "sha256=".
+	buildNotificationSignatureHeader = "X-Fission-Signature"
+	// buildNotificationTimestampHeader carries the Unix time at which the
+	// notification was signed, receivers reject old ones to stop replays.
+	buildNotificationTimestampHeader = "X-Fission-Timestamp"
+	// maxBuildNotificationLogSize is the size of the tail of the build
+	// logs sent in the notifications.
+	maxBuildNotificationLogSize = 4096
+
+	defaultBuildNotificationAttempts = 3
+	defaultBuildNotificationDelay    = time.Second
+	buildNotificationTimeout         = 10 * time.Second
+)
+
+type (
+	// buildNotifier posts the completion of the package builds to the
+	// notification URL of the builder manager and to the one set by the
+	// package with the build notification annotation. Notifications are
+	// sent in the background and never affect the builds, failed ones are
+	// retried a few times then dropped.
+	buildNotifier struct {
+		logger *zap.Logger
+		client *http.Client
+		// ctx bounds the deliveries, sending tracks them.
+		ctx     context.Context
+		sending sync.WaitGroup
+		// url is the notification URL of all builds, empty for none.
+		url string
+		// secret signs the notifications sent to url, empty for unsigned
+		// ones. Those sent to the URLs of the packages are never signed,
+		// package authors pick them.
+		secret   []byte
+		attempts int
+		delay    time.Duration
+	}
+
+	// notificationDelivery is a notification body to post to a URL.
+	notificationDelivery struct {
+		url    string
+		signed bool
+	}
+
+	// buildNotification is the JSON body of a build notification.
+	buildNotification struct {
+		Package         string  `json:"package"`
+		Namespace       string  `json:"namespace"`
+		BuildID         string  `json:"buildID"`
+		Status          string  `json:"status"`
+		Reason          string  `json:"reason,omitempty"`
+		DurationSeconds float64 `json:"durationSeconds"`
+		Attempts        int     `json:"attempts"`
+		Log             string  `json:"log,omitempty"`
+	}
+)
+
+func newBuildNotifier(ctx context.Context, logger *zap.Logger) *buildNotifier {
+	return &buildNotifier{
+		logger:   logger,
+		client:   &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: buildNotificationTimeout},
+		ctx:      ctx,
+		attempts: defaultBuildNotificationAttempts,
+		delay:    defaultBuildNotificationDelay,
+	}
+}
+
+// validateNotificationURL checks that the notification URL is an absolute
+// HTTPS URL.
+func validateNotificationURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return errors.Wrap(err, "invalid build notification URL")
+	}
+	if parsed.Scheme != "https" || len(parsed.Host) == 0 {
+		return errors.Errorf("build notification URL %q is not an absolute https URL", u)
+	}
+	return nil
+}
+
+// notify sends the notification of the completed build in the background.
+func (n *buildNotifier) notify(b *pkgBuild, summary buildSummary, duration time.Duration) {
+	if n == nil {
+		return
+	}
+	var deliveries []notificationDelivery
+	if len(n.url) > 0 {
+		deliveries = append(deliveries, notificationDelivery{url: n.url, signed: true})
+	}
+	if u, ok := b.pkg.ObjectMeta.Annotations[fv1.ANNOTATION_BUILD_NOTIFICATION_URL]; ok && u != n.url {
+		if err := validateNotificationURL(u); err != nil {
+			n.logger.Warn("ignoring build notification annotation", zap.Error(err),
+				zap.String("package_name", b.pkg.ObjectMeta.Name),
+				zap.String("namespace", b.pkg.ObjectMeta.Namespace))
+		} else {
+			deliveries = append(deliveries, notificationDelivery{url: u})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	logs := summary.logs
+	if len(logs) > maxBuildNotificationLogSize {
+		logs = logs[len(logs)-maxBuildNotificationLogSize:]
+	}
+	body, err := json.Marshal(buildNotification{
+		Package:         b.pkg.ObjectMeta.Name,
+		Namespace:       b.pkg.ObjectMeta.Namespace,
+		BuildID:         buildID(b.pkg, b.attempt),
+		Status:          summary.result,
+		Reason:          summary.reason,
+		DurationSeconds: duration.Seconds(),
+		Attempts:        b.attempt,
+		Log:             logs,
+	})
+	if err != nil {
+		n.logger.Error("error encoding build notification", zap.Error(err))
+		return
+	}
+	for _, d := range deliveries {
+		n.sending.Add(1)
+		go func(d notificationDelivery) {
+			defer n.sending.Done()
+			n.send(n.ctx, d, body)
+		}(d)
+	}
+}
+
+// wait returns once the notifications being sent are delivered or dropped.
+func (n *buildNotifier) wait() {
+	if n == nil {
+		return
+	}
+	n.sending.Wait()
+}
+
+// send posts the notification body to the delivery URL, retrying failed
+// deliveries with exponential backoff. It returns whether the notification
+// got delivered.
+func (n *buildNotifier) send(ctx context.Context, d notificationDelivery, body []byte) bool {
+	delay := n.delay
+	var err error
+	for attempt := 1; attempt <= n.attempts; attempt++ {
+		var retry bool
+		retry, err = n.post(ctx, d, body)
+		if err == nil {
+			return true
+		}
+		if !retry || attempt == n.attempts {
+			break
+		}
+		sleepWithContext(ctx, delay)
+		if ctx.Err() != nil {
+			break
+		}
+		delay *= 2
+	}
+	n.logger.Warn("dropping build notification", zap.String("url", d.url), zap.Error(err))
+	return false
+}
+
+// post posts the notification body once, it returns whether a failed
+// delivery is worth retrying.
+func (n *buildNotifier) post(ctx context.Context, d notificationDelivery, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.signed && len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(buildNotificationTimestampHeader, timestamp)
+		req.Header.Set(buildNotificationSignatureHeader, "sha256="+notificationSignature(n.secret, timestamp, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("build notification rejected with status %d", resp.StatusCode)
+	// other client errors won't go away by retrying
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
+
+// notificationSignature returns the hex HMAC-SHA256 of the timestamp and
+// the body, joined by a dot.
+func notificationSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,127 @@
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestBuildNotifierRetriesAndSigns(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	received := make(chan []byte, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests++
+		n := requests
+		mutex.Unlock()
+		// the first delivery fails
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		timestamp := r.Header.Get(buildNotificationTimestampHeader)
+		if sig := r.Header.Get(buildNotificationSignatureHeader); sig != "sha256="+notificationSignature([]byte("s3cr3t"), timestamp, body) {
+			t.Errorf("Unexpected notification signature %q", sig)
+		}
+		received <- body
+	}))
+	defer server.Close()
+
+	n := newBuildNotifier(context.Background(), zap.NewNop())
+	n.client = server.Client()
+	n.delay = time.Millisecond
+	n.url = server.URL
+	n.secret = []byte("s3cr3t")
+	pkg := testPackage()
+	logs := strings.Repeat("x", maxBuildNotificationLogSize) + "build failed\n"
+	n.notify(&pkgBuild{pkg: pkg, attempt: 2}, buildSummary{result: string(fv1.BuildStatusFailed),
+		reason: fv1.PackageReasonBuildFailed, logs: logs}, 3*time.Second)
+
+	select {
+	case body := <-received:
+		var notification buildNotification
+		err := json.Unmarshal(body, &notification)
+		if err != nil {
+			t.Fatalf("Error decoding notification: %v", err)
+		}
+		if notification.Package != testPkgName || notification.Namespace != testNamespace ||
+			notification.Status != string(fv1.BuildStatusFailed) || notification.Attempts != 2 ||
+			notification.DurationSeconds != 3 {
+			t.Errorf("Unexpected notification %+v", notification)
+		}
+		if len(notification.Log) != maxBuildNotificationLogSize || !strings.HasSuffix(notification.Log, "build failed\n") {
+			t.Errorf("Expected the tail of the build logs, got %d bytes", len(notification.Log))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Notification not delivered")
+	}
+}
+
+func TestBuildNotifierDropsRejectedNotifications(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n := newBuildNotifier(context.Background(), zap.NewNop())
+	n.client = server.Client()
+	n.delay = time.Millisecond
+	if n.send(context.Background(), notificationDelivery{url: server.URL}, []byte("{}")) {
+		t.Fatalf("Expected rejected notification not to be delivered")
+	}
+	if requests != 1 {
+		t.Errorf("Expected rejected notification not to be retried, got %d requests", requests)
+	}
+}
+
+func TestBuildNotifierDoesNotSignPackageNotifications(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer server.Close()
+
+	n := newBuildNotifier(context.Background(), zap.NewNop())
+	n.client = server.Client()
+	n.secret = []byte("s3cr3t")
+	pkg := testPackage()
+	pkg.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_BUILD_NOTIFICATION_URL: server.URL}
+	n.notify(&pkgBuild{pkg: pkg, attempt: 1}, buildSummary{result: string(fv1.BuildStatusSucceeded)}, time.Second)
+	n.wait()
+
+	select {
+	case header := <-received:
+		if sig := header.Get(buildNotificationSignatureHeader); len(sig) > 0 {
+			t.Errorf("Expected unsigned package notification, got signature %q", sig)
+		}
+	default:
+		t.Fatalf("Notification not delivered")
+	}
+}
+
+func TestValidateNotificationURL(t *testing.T) {
+	for u, valid := range map[string]bool{
+		"https://ci.example.com/hooks/fission": true,
+		"http://ci.example.com/hooks/fission":  false,
+		"https:///hooks":                       false,
+		"ci.example.com":                       false,
+	} {
+		if err := validateNotificationURL(u); (err == nil) != valid {
+			t.Errorf("Expected URL %q valid %v, got %v", u, valid, err)
+		}
+	}
+}
@@ -17,308 +17,1020 @@ limitations under the License.
 package buildermgr
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
-	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/cache"
+	"github.com/fission/fission/pkg/crd"
 	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
 	"github.com/fission/fission/pkg/utils"
 	"github.com/fission/fission/pkg/utils/metrics"
+	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
 
 type (
 	packageWatcher struct {
 		logger        *zap.Logger
 		fissionClient versioned.Interface
-		nsResolver    *utils.NamespaceResolver
 		k8sClient     kubernetes.Interface
 		podInformer   map[string]k8sCache.SharedIndexInformer
 		pkgInformer   map[string]k8sCache.SharedIndexInformer
-		storageSvcUrl string
-		buildCache    *cache.Cache
+		// deps are the dependencies of the builds run with ExecuteBuild
+		deps       BuildDeps
+		buildCache *cache.Cache
+		buildQueue *buildQueue
+		// ledger persists the build queue across restarts, it is nil
+		// when the queue is rebuilt from the package states alone.
+		ledger *queueLedger
+		// restoring holds back the dispatch of queued builds until the
+		// builds of the restored ledger are queued again.
+		restoring atomic.Bool
+		// reconciling holds back the dispatch of queued builds until the
+		// packages pending at startup are all queued, so that they're
+		// built in the order they were marked pending.
+		reconciling atomic.Bool
+		// buildSlots bounds the number of concurrently running builds,
+		// it is nil when the number of builds is unlimited.
+		buildSlots chan struct{}
+		// pools are the build worker pools of the environments, they
+		// bound the builds of each environment running at the same time.
+		pools *envPools
+		// namespaceLimits bound the builds of each package namespace
+		// running at the same time.
+		namespaceLimits *namespaceLimits
+		// notifier sends the build completion notifications.
+		notifier *buildNotifier
+		// maxBuildRetries is the number of times a failed build is retried,
+		// packages can override it with the max build retries annotation.
+		maxBuildRetries int
+		// buildRetryDelay is the delay before the first retry of a failed
+		// build, it doubles with every attempt up to maxBuildRetryDelay.
+		buildRetryDelay time.Duration
+		// buildTimeout is the deadline of builds of packages that
+		// don't set their own, zero means no deadline.
+		buildTimeout time.Duration
+		// maxBuildLogSize is the maximum size in bytes of the build
+		// logs stored in package status, zero means no limit.
+		maxBuildLogSize int
+		// rebuildCooldown delays the rebuilds of unchanged package
+		// sources shortly after their last build.
+		rebuildCooldown *rebuildCooldown
+		// archiveGC deletes the deployment archives superseded by
+		// successful rebuilds. Optional; nil keeps them.
+		archiveGC *archiveGC
+		// deleteSourceAfterBuild deletes the source archives of the
+		// packages once built, packages can override it with the delete
+		// source after build annotation.
+		deleteSourceAfterBuild bool
+		// staleRunningBuildAge is the minimum time after its last status
+		// update from which a running build is considered abandoned.
+		staleRunningBuildAge time.Duration
+		// shutdownGracePeriod is the time running builds get to finish
+		// on shutdown before they're interrupted.
+		shutdownGracePeriod time.Duration
+		// abandonRunningBuilds is set once the watcher leads the builder
+		// managers, the builds running by then were abandoned by the
+		// previous leader whatever their age.
+		abandonRunningBuilds bool
+		// leading is set once the watcher runs the package builds of the
+		// replica, standby replicas know nothing of the running builds.
+		leading atomic.Bool
+
+		// buildsCtx is the parent of all build contexts. It outlives the
+		// Run context so that running builds can finish during shutdown,
+		// stopBuilds cancels it once the shutdown grace period is over.
+		buildsCtx  context.Context
+		stopBuilds context.CancelCauseFunc
+		// shutdownMutex guards shuttingDown and additions to running,
+		// running tracks the build goroutines and the rebuilds of the
+		// packages of environments whose builder image changed.
+		shutdownMutex sync.Mutex
+		shuttingDown  bool
+		running       sync.WaitGroup
+	}
+
+	// pkgBuild is a build request tracked in the build cache. The build
+	// context is canceled when the build must be abandoned, e.g. when the
+	// package is deleted.
+	pkgBuild struct {
+		key string
+		// id identifies the build attempt in logs and traces
+		id     string
+		pkg    *fv1.Package
+		ctx    context.Context
+		cancel context.CancelCauseFunc
+		// attempt is the 1-based build attempt number, and logs holds
+		// the build logs of the previous attempts.
+		attempt int
+		logs    string
+		// startTime is when the first attempt started
+		startTime time.Time
+		// priority and enqueueTime order the builds in the build queue
+		priority    int
+		enqueueTime time.Time
+		// trigger is why the build was triggered
+		trigger fv1.BuildTrigger
+		// state is the buildState of the build, for reporting
+		state atomic.Int32
+		// started is the startTime of a build that ran, phase the
+		// fv1.BuildPhase of the running build and builderPod the
+		// namespace/name of the builder pod it waits for or found
+		// ready, for reporting
+		started    atomic.Value
+		phase      atomic.Value
+		builderPod atomic.Value
+		// builderImage is the builder image of the environment the
+		// attempt ran with, empty if it didn't get to the environment
+		builderImage string
+		// pool is the environment pool whose slot the running build holds
+		pool *envPool
+		// namespaceSlot is set while the build holds a slot of its
+		// package namespace
+		namespaceSlot bool
 	}
 )
 
+const (
+	defaultBuildRetryDelay = 10 * time.Second
+	maxBuildRetryDelay     = 5 * time.Minute
+
+	defaultArchiveCheckAttempts = 5
+	defaultArchiveCheckDelay    = time.Second
+
+	// defaultBuildShutdownGracePeriod is the default time running builds
+	// get to finish on shutdown, it stays below the default pod
+	// termination grace period.
+	defaultBuildShutdownGracePeriod = 20 * time.Second
+
+	// canceledStatusTimeout is the deadline of the package status update
+	// of a canceled build, whose own context is done.
+	canceledStatusTimeout = 10 * time.Second
+)
+
+var (
+	errPackageDeleted    = errors.New("package deleted")
+	errPackageSuperseded = errors.New("superseded by a newer package version")
+	errBuildTimeout      = errors.New("build exceeded timeout")
+	errShuttingDown      = errors.New("builder manager shutting down")
+	errCanceledByRequest = errors.New("build canceled by request")
+	errBuildSkipped      = errors.New("package build skipped by annotation")
+)
+
+// buildIDKey is the context key of the build ID.
+type buildIDKey struct{}
+
+// withBuildID returns a context carrying the build ID.
+func withBuildID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, buildIDKey{}, id)
+}
+
+// buildLogger returns the logger of the build running with ctx, it logs
+// the build ID and trace ID carried by the context.
+func buildLogger(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if id, ok := ctx.Value(buildIDKey{}).(string); ok {
+		logger = logger.With(zap.String("build_id", id))
+	}
+	return otelUtils.LoggerWithTraceID(ctx, logger)
+}
+
 func makePackageWatcher(logger *zap.Logger, fissionClient versioned.Interface, k8sClientSet kubernetes.Interface,
-	storageSvcUrl string, podInformer,
-	pkgInformer map[string]k8sCache.SharedIndexInformer) *packageWatcher {
+	storageSvcUrl string, maxConcurrentBuilds int, maxBuildRetries int, buildTimeout time.Duration, maxBuildLogSize int,
+	builderBackoff BuilderBackoff, podInformer, pkgInformer map[string]k8sCache.SharedIndexInformer) *packageWatcher {
+	var buildSlots chan struct{}
+	if maxConcurrentBuilds > 0 {
+		buildSlots = make(chan struct{}, maxConcurrentBuilds)
+	}
+	buildsCtx, stopBuilds := context.WithCancelCause(context.Background())
+	logger = logger.Named("package_watcher")
 	pkgw := &packageWatcher{
-		logger:        logger.Named("package_watcher"),
+		logger:        logger,
 		fissionClient: fissionClient,
 		k8sClient:     k8sClientSet,
-		nsResolver:    utils.DefaultNSResolver(),
 		podInformer:   podInformer,
 		pkgInformer:   pkgInformer,
-		storageSvcUrl: storageSvcUrl,
-		buildCache:    cache.MakeCache(0, 0),
+		deps: BuildDeps{
+			Logger:        logger,
+			FissionClient: fissionClient,
+			StorageSvcURL: storageSvcUrl,
+			Pods:          informerPodLister{logger: logger, podInformer: podInformer},
+			NSResolver:    utils.DefaultNSResolver(),
+
+			runBuild:             buildPackage,
+			checkArchive:         verifyStoredArchive,
+			archiveCheckAttempts: defaultArchiveCheckAttempts,
+			archiveCheckDelay:    defaultArchiveCheckDelay,
+			logStore:             storageSvcClient.MakeClient(storageSvcUrl),
+			sourceStore:          storageSvcClient.MakeClient(storageSvcUrl),
+			phaseUpdateInterval:  buildPhaseUpdateInterval,
+			phaseLimiter:         flowcontrol.NewTokenBucketRateLimiter(buildPhaseUpdateQPS, buildPhaseUpdateBurst),
+			fetchFailures:        newFetchFailureTracker(logger, k8sClientSet),
+			k8sClient:            k8sClientSet,
+			builderReady:         newBuilderReadiness(),
+			builderLoad:          newBuilderLoad(),
+			builderBackoff:       builderBackoff,
+			uploadRetry:          defaultUploadRetryPolicy(defaultUploadRetries),
+		},
+		buildCache:      cache.MakeCache(0, 0),
+		buildQueue:      newBuildQueue(),
+		buildSlots:      buildSlots,
+		pools:           newEnvPools(logger, 0),
+		namespaceLimits: newNamespaceLimits(logger, k8sClientSet, 0),
+		notifier:        newBuildNotifier(buildsCtx, logger),
+		maxBuildRetries: maxBuildRetries,
+		buildRetryDelay: defaultBuildRetryDelay,
+		buildTimeout:    buildTimeout,
+		maxBuildLogSize: maxBuildLogSize,
+
+		rebuildCooldown:      newRebuildCooldown(defaultRebuildCooldown),
+		staleRunningBuildAge: defaultStaleBuildAge,
+		shutdownGracePeriod:  defaultBuildShutdownGracePeriod,
+
+		buildsCtx:  buildsCtx,
+		stopBuilds: stopBuilds,
+	}
+	for _, informer := range podInformer {
+		pkgw.setupPodInformer(informer)
 	}
 	return pkgw
 }
 
+// setupPodInformer indexes the builder pods of the pod informer and wakes
+// up the builds waiting for them to be ready.
+func (pkgw *packageWatcher) setupPodInformer(informer k8sCache.SharedIndexInformer) {
+	err := informer.AddIndexers(k8sCache.Indexers{builderPodIndex: builderPodIndexFunc})
+	if err != nil {
+		pkgw.logger.Error("error adding builder pod index, builder pods are looked up in the whole informer store", zap.Error(err))
+	}
+	informer.AddEventHandler(pkgw.deps.builderReady.podInformerHandler())
+}
+
+// watchBuilderNamespaces looks up the builder pods of the builder
+// namespaces set by environments in the pod informers, created once
+// they're needed.
+func (pkgw *packageWatcher) watchBuilderNamespaces(informers *namespaceInformers) {
+	informers.forEach(pkgw.setupPodInformer)
+	pkgw.deps.Pods = informerPodLister{logger: pkgw.logger, podInformer: pkgw.podInformer, informers: informers}
+}
+
 func (pkgw *packageWatcher) buildCacheKey(obj metav1.ObjectMeta) string {
 	return fmt.Sprintf("%s-%s-%s", obj.Namespace, obj.Name, obj.ResourceVersion)
 }
 
-func (pkgw *packageWatcher) buildWithCache(ctx context.Context, srcpkg *fv1.Package) {
+// buildID returns the ID of a build attempt of the package.
+func buildID(pkg *fv1.Package, attempt int) string {
+	return fmt.Sprintf("%s-%s-%s-%d", pkg.ObjectMeta.Namespace, pkg.ObjectMeta.Name, pkg.ObjectMeta.ResourceVersion, attempt)
+}
+
+// newBuildContext sets up the context of a build attempt, derived from the
+// builds context and carrying the build ID.
+func (pkgw *packageWatcher) newBuildContext(b *pkgBuild) {
+	b.id = buildID(b.pkg, b.attempt)
+	b.ctx, b.cancel = context.WithCancelCause(withBuildID(pkgw.buildsCtx, b.id))
+}
+
+// buildWithCache enqueues the build of the pending package, the trigger
+// tells why it's built.
+func (pkgw *packageWatcher) buildWithCache(srcpkg *fv1.Package, trigger fv1.BuildTrigger) {
+	b := &pkgBuild{
+		key:         pkgw.buildCacheKey(srcpkg.ObjectMeta),
+		pkg:         srcpkg,
+		attempt:     1,
+		priority:    buildPriority(pkgw.logger, srcpkg),
+		enqueueTime: pkgw.ledger.enqueueTime(srcpkg.ObjectMeta.Namespace, srcpkg.ObjectMeta.Name),
+		trigger:     trigger,
+	}
+	if b.enqueueTime.IsZero() {
+		b.enqueueTime = pendingSince(srcpkg)
+	}
+	pkgw.newBuildContext(b)
 	// Ignore duplicate build requests
-	_, err := pkgw.buildCache.Set(pkgw.buildCacheKey(srcpkg.ObjectMeta), srcpkg)
+	_, err := pkgw.buildCache.Set(b.key, b)
 	if err != nil {
+		b.cancel(nil)
 		pkgw.logger.Info("package build cache set error", zap.Error(err))
 		return
 	}
-	go pkgw.build(ctx, srcpkg)
-}
-
-// build helps to update package status, checks environment builder pod status and
-// dispatches buildPackage to build source package into deployment package.
-// Following is the steps build function takes to complete the whole process.
-// 1. Check package status
-// 2. Update package status to running state
-// 3. Check environment builder pod status
-// 4. Call buildPackage to build package
-// 5. Update package resource in package ref of functions that share the same package
-// 6. Update package status to succeed state
-// *. Update package status to failed state,if any one of steps above failed/time out
-func (pkgw *packageWatcher) build(ctx context.Context, srcpkg *fv1.Package) {
-	defer func() {
-		key := pkgw.buildCacheKey(srcpkg.ObjectMeta)
-		err := pkgw.buildCache.Delete(key)
-		if err != nil {
-			pkgw.logger.Error("error deleting key from cache", zap.String("key", key), zap.Error(err))
-		}
-	}()
-
-	pkgw.logger.Info("starting build for package", zap.String("package_name", srcpkg.ObjectMeta.Name), zap.String("resource_version", srcpkg.ObjectMeta.ResourceVersion))
+	if pkgw.isShuttingDown() {
+		pkgw.forgetBuild(b, errShuttingDown)
+		return
+	}
+	// Builds of older resource versions would race with this one and the last
+	// one to finish wins, so cancel them to make sure the newest spec gets built.
+	pkgw.cancelBuilds(srcpkg, b.key, errPackageSuperseded)
+	pkgw.namespaceLimits.refresh(b.ctx, srcpkg.ObjectMeta.Namespace)
+	pkgw.buildQueue.Push(b)
+	pkgw.dispatchBuilds()
+}
 
-	pkg, err := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, srcpkg, fv1.BuildStatusRunning, "", nil)
+// forgetBuild cancels a build that never ran and removes it from the build cache.
+func (pkgw *packageWatcher) forgetBuild(b *pkgBuild, cause error) {
+	b.cancel(cause)
+	err := pkgw.buildCache.Delete(b.key)
 	if err != nil {
-		pkgw.logger.Error("error setting package pending state", zap.Error(err))
-		return
+		pkgw.logger.Error("error deleting key from cache", zap.String("key", b.key), zap.Error(err))
 	}
+}
 
-	env, err := pkgw.fissionClient.CoreV1().Environments(pkg.Spec.Environment.Namespace).Get(ctx, pkg.Spec.Environment.Name, metav1.GetOptions{})
-	if k8serrors.IsNotFound(err) {
-		e := "environment does not exist"
-		pkgw.logger.Error(e, zap.String("environment", pkg.Spec.Environment.Name))
-		_, er := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg,
-			fv1.BuildStatusFailed, fmt.Sprintf("%s: %q", e, pkg.Spec.Environment.Name), nil)
-		if er != nil {
-			pkgw.logger.Error(
-				"error updating package",
-				zap.String("package_name", pkg.ObjectMeta.Name),
-				zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-				zap.Error(er),
-			)
-		}
-		return
+func (pkgw *packageWatcher) isShuttingDown() bool {
+	pkgw.shutdownMutex.Lock()
+	defer pkgw.shutdownMutex.Unlock()
+	return pkgw.shuttingDown
+}
+
+// startBuild registers a build goroutine, it returns false once the
+// shutdown started and no build may start anymore.
+func (pkgw *packageWatcher) startBuild() bool {
+	pkgw.shutdownMutex.Lock()
+	defer pkgw.shutdownMutex.Unlock()
+	if pkgw.shuttingDown {
+		return false
 	}
+	pkgw.running.Add(1)
+	return true
+}
 
-	// Create a new BackOff for health check on environment builder pod
-	healthCheckBackOff := utils.NewDefaultBackOff()
-	builderNs := pkgw.nsResolver.GetBuilderNS(env.ObjectMeta.Namespace)
+// Shutdown stops the package builds. Builds that haven't started are canceled
+// right away, running builds get the grace period to finish before they're
+// canceled too. It returns once all build goroutines have returned and the
+// build notifications are sent or dropped, canceled builds don't update their
+// package.
+func (pkgw *packageWatcher) Shutdown(grace time.Duration) {
+	pkgw.shutdownMutex.Lock()
+	pkgw.shuttingDown = true
+	pkgw.shutdownMutex.Unlock()
 
-	//if err != nil {
-	//	pkgw.logger.Error("Unable to create BackOff for Health Check", zap.Error(err))
-	//}
-	// Do health check for environment builder pod
-	for healthCheckBackOff.NextExists() {
-		// Informer store is not able to use label to find the pod,
-		// iterate all available environment builders.
-		items := pkgw.podInformer[builderNs].GetStore().List()
-		if err != nil {
-			pkgw.logger.Error("error retrieving pod information for environment", zap.Error(err), zap.String("environment", env.ObjectMeta.Name))
-			return
+	// nothing dispatches builds anymore, forget the queued ones
+	// once they're in the ledger for the next builder manager
+	if pkgw.ledger != nil {
+		pkgw.ledger.close(pkgw.buildQueue)
+	}
+	for b := pkgw.buildQueue.Pop(); b != nil; b = pkgw.buildQueue.Pop() {
+		pkgw.forgetBuild(b, errShuttingDown)
+	}
+	// builds waiting to be retried
+	for _, v := range pkgw.buildCache.Copy() {
+		if b, ok := v.(*pkgBuild); ok && buildState(b.state.Load()) == buildStatePending {
+			b.cancel(errShuttingDown)
 		}
+	}
 
-		if len(items) == 0 {
-			pkgw.logger.Info("builder pod does not exist for environment, will retry again later", zap.String("environment", pkg.Spec.Environment.Name))
-			time.Sleep(healthCheckBackOff.GetCurrentBackoffDuration())
+	done := make(chan struct{})
+	go func() {
+		pkgw.running.Wait()
+		close(done)
+	}()
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		pkgw.logger.Warn("shutdown grace period over, canceling running builds", zap.Duration("grace_period", grace))
+		pkgw.stopBuilds(errShuttingDown)
+		<-done
+	}
+	// the notifications of the last builds get what's left of the grace
+	// period, canceling the builds drops them
+	notified := make(chan struct{})
+	go func() {
+		pkgw.notifier.wait()
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-t.C:
+		pkgw.stopBuilds(errShuttingDown)
+		<-notified
+	}
+	pkgw.stopBuilds(nil)
+}
+
+// cancelBuilds cancels in-flight or queued builds of the package with the given cause,
+// except the build with the key exceptKey. It returns the number of canceled builds.
+func (pkgw *packageWatcher) cancelBuilds(pkg *fv1.Package, exceptKey string, cause error) int {
+	canceled := 0
+	for _, v := range pkgw.buildCache.Copy() {
+		b, ok := v.(*pkgBuild)
+		if !ok || b.key == exceptKey {
 			continue
 		}
+		if b.pkg.ObjectMeta.Namespace == pkg.ObjectMeta.Namespace &&
+			b.pkg.ObjectMeta.Name == pkg.ObjectMeta.Name {
+			pkgw.logger.Info("canceling package build",
+				zap.String("package_name", b.pkg.ObjectMeta.Name),
+				zap.String("namespace", b.pkg.ObjectMeta.Namespace),
+				zap.String("resource_version", b.pkg.ObjectMeta.ResourceVersion),
+				zap.Error(cause))
+			b.cancel(cause)
+			canceled++
+		}
+	}
+	return canceled
+}
 
-		for _, item := range items {
-			pod := item.(*apiv1.Pod)
+// CancelBuild cancels the in-flight or queued builds of the package, their
+// package goes into canceled state. It returns false if the package has no
+// build to cancel.
+func (pkgw *packageWatcher) CancelBuild(namespace, name string) bool {
+	pkg := &fv1.Package{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return pkgw.cancelBuilds(pkg, "", errCanceledByRequest) > 0
+}
 
-			// Filter non-matching pods
-			if pod.ObjectMeta.Labels[LABEL_ENV_NAME] != env.ObjectMeta.Name ||
-				pod.ObjectMeta.Labels[LABEL_ENV_NAMESPACE] != builderNs ||
-				pod.ObjectMeta.Labels[LABEL_ENV_RESOURCEVERSION] != env.ObjectMeta.ResourceVersion {
-				continue
-			}
+// markCanceled puts the package of a canceled build into canceled state. The
+// package is left alone if it's gone or its status belongs to another build:
+// the status written by the build, or the package given for a build that
+// never ran, must still be the latest. A newer build of a superseded package
+// owns the status then and skipped packages get their skipped status. Running
+// builds interrupted by a shutdown put their package back into pending state
+// instead, for the next builder manager to build it. Queued ones never left
+// it.
+func (pkgw *packageWatcher) markCanceled(b *pkgBuild, pkg *fv1.Package) {
+	cause := context.Cause(b.ctx)
+	if cause == nil || errors.Is(cause, errPackageDeleted) || errors.Is(cause, errBuildSkipped) {
+		return
+	}
+	interrupted := errors.Is(cause, errShuttingDown)
+	if interrupted && pkg.Status.BuildStatus != fv1.BuildStatusRunning {
+		return
+	}
+	// the build context is done, and so is the Run context on shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), canceledStatusTimeout)
+	defer cancel()
+	var err error
+	if interrupted {
+		_, err = setInterruptedBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), b.trigger)
+	} else {
+		_, err = setCanceledBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), cause)
+	}
+	if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+		pkgw.logger.Debug("package changed since the build was canceled, leaving its status alone",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Error(err))
+		return
+	}
+	if err != nil {
+		pkgw.logger.Error("error setting package canceled state",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Error(err))
+	}
+}
 
-			// Pod may become "Running" state but still failed at health check, so use
-			// pod.Status.ContainerStatuses instead of pod.Status.Phase to check pod readiness states.
-			podIsReady := true
+// buildCanceled reports whether the build context is canceled, logging the
+// cancellation cause if it is. A build that timed out is not canceled, it
+// has to be marked as failed.
+func buildCanceled(ctx context.Context, logger *zap.Logger, pkg *fv1.Package) bool {
+	if ctx.Err() == nil || buildTimedOut(ctx) {
+		return false
+	}
+	buildLogger(ctx, logger).Info(fmt.Sprintf("build canceled: %v", context.Cause(ctx)),
+		zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace))
+	return true
+}
 
-			for _, cStatus := range pod.Status.ContainerStatuses {
-				podIsReady = podIsReady && cStatus.Ready
-			}
+func buildTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errBuildTimeout)
+}
 
-			if !podIsReady {
-				pkgw.logger.Info("builder pod is not ready for environment, will retry again later", zap.String("environment", pkg.Spec.Environment.Name))
-				time.Sleep(healthCheckBackOff.GetCurrentBackoffDuration())
-				break
-			}
+// buildTimeoutFor returns the deadline of the package build, zero means none.
+func (pkgw *packageWatcher) buildTimeoutFor(pkg *fv1.Package) time.Duration {
+	if pkg.Spec.BuildTimeout > 0 {
+		return time.Duration(pkg.Spec.BuildTimeout) * time.Second
+	}
+	return pkgw.buildTimeout
+}
 
-			uploadResp, buildLogs, err := buildPackage(ctx, pkgw.logger, pkgw.fissionClient, builderNs, pkgw.storageSvcUrl, pkg)
-			if err != nil {
-				pkgw.logger.Error("error building package", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name))
-				_, er := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg, fv1.BuildStatusFailed, buildLogs, nil)
-				if er != nil {
-					pkgw.logger.Error(
-						"error updating package",
-						zap.String("package_name", pkg.ObjectMeta.Name),
-						zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-						zap.Error(er),
-					)
-				}
-				return
-			}
+// buildTimeoutLimit names the limit the deadline of the package build
+// comes from.
+func buildTimeoutLimit(pkg *fv1.Package) string {
+	if pkg.Spec.BuildTimeout > 0 {
+		return "spec.buildTimeout of the package"
+	}
+	return "builder manager default build timeout"
+}
 
-			pkgw.logger.Info("starting package info update", zap.String("package_name", pkg.ObjectMeta.Name))
+// sleepWithContext waits for the given duration or until the context is done.
+func sleepWithContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
 
-			fnList, err := pkgw.fissionClient.CoreV1().
-				Functions(pkg.Namespace).List(ctx, metav1.ListOptions{})
-			if err != nil {
-				e := "error getting function list"
-				pkgw.logger.Error(e, zap.Error(err))
-				buildLogs += fmt.Sprintf("%s: %v\n", e, err)
-				_, er := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg, fv1.BuildStatusFailed, buildLogs, nil)
-				if er != nil {
-					pkgw.logger.Error(
-						"error updating package",
-						zap.String("package_name", pkg.ObjectMeta.Name),
-						zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-						zap.Error(er),
-					)
-				}
+// dispatchBuilds starts queued builds in queue order until the queue
+// is drained or all build slots are in use. Builds of environments whose
+// worker pool is busy, or of namespaces at their build limit, are passed
+// over and keep their place in the queue. Packages left in the queue stay in
+// pending state and are dispatched once a running build finishes.
+// Nothing is dispatched while the queue ledger is being restored, or while
+// the packages pending at startup are being queued.
+func (pkgw *packageWatcher) dispatchBuilds() {
+	for {
+		if pkgw.isShuttingDown() || pkgw.restoring.Load() || pkgw.reconciling.Load() {
+			return
+		}
+		if pkgw.buildSlots != nil {
+			select {
+			case pkgw.buildSlots <- struct{}{}:
+			default:
+				pkgw.logger.Info("concurrent build limit reached, deferring queued builds",
+					zap.Int("max_concurrent_builds", cap(pkgw.buildSlots)),
+					zap.Int("queued_builds", pkgw.buildQueue.Len()))
+				return
 			}
+		}
 
-			// A package may be used by multiple functions. Update
-			// functions with old package resource version
-			for _, fn := range fnList.Items {
-				if fn.Spec.Package.PackageRef.Name == pkg.ObjectMeta.Name &&
-					fn.Spec.Package.PackageRef.Namespace == pkg.ObjectMeta.Namespace &&
-					fn.Spec.Package.PackageRef.ResourceVersion != pkg.ObjectMeta.ResourceVersion {
-					fn.Spec.Package.PackageRef.ResourceVersion = pkg.ObjectMeta.ResourceVersion
-					// update CRD
-					_, err = pkgw.fissionClient.CoreV1().Functions(fn.ObjectMeta.Namespace).Update(ctx, &fn, metav1.UpdateOptions{})
-					if err != nil {
-						e := "error updating function package resource version"
-						pkgw.logger.Error(e, zap.Error(err))
-						buildLogs += fmt.Sprintf("%s: %v\n", e, err)
-						_, er := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg, fv1.BuildStatusFailed, buildLogs, nil)
-						if er != nil {
-							pkgw.logger.Error(
-								"error updating package",
-								zap.String("package_name", pkg.ObjectMeta.Name),
-								zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-								zap.Error(er),
-							)
-						}
-						return
-					}
-				}
+		// builds of busy environment pools or namespaces stay queued
+		b := pkgw.buildQueue.PopFirst(pkgw.takeSlots)
+		if b == nil {
+			pkgw.releaseBuildSlot()
+			// a package may have been queued or a pool slot freed
+			// while we were holding the slot, check again so that
+			// builds don't get stuck.
+			if pkgw.buildQueue.Any(pkgw.slotsAvailable) {
+				continue
 			}
+			return
+		}
+		if !pkgw.startBuild() {
+			pkgw.releaseSlots(b)
+			pkgw.forgetBuild(b, errShuttingDown)
+			pkgw.releaseBuildSlot()
+			return
+		}
 
-			_, err = updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg,
-				fv1.BuildStatusSucceeded, buildLogs, uploadResp)
+		go func() {
+			defer pkgw.running.Done()
+			defer func() {
+				pkgw.releaseSlots(b)
+				pkgw.releaseBuildSlot()
+				pkgw.dispatchBuilds()
+			}()
+			defer pkgw.recoverBuild(b)
+			next := pkgw.build(b)
+			b.cancel(nil)
+			// track the retry before forgetting this build, so that
+			// the package always has an entry in the build cache
+			if next != nil {
+				pkgw.retryBuild(next)
+			}
+			err := pkgw.buildCache.Delete(b.key)
 			if err != nil {
-				pkgw.logger.Error("error updating package info", zap.Error(err), zap.String("package_name", pkg.ObjectMeta.Name))
-				_, er := updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg, fv1.BuildStatusFailed, buildLogs, nil)
-				if er != nil {
-					pkgw.logger.Error(
-						"error updating package",
-						zap.String("package_name", pkg.ObjectMeta.Name),
-						zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-						zap.Error(er),
-					)
-				}
-				return
+				pkgw.logger.Error("error deleting key from cache", zap.String("key", b.key), zap.Error(err))
 			}
+		}()
+	}
+}
 
-			pkgw.logger.Info("completed package build request", zap.String("package_name", pkg.ObjectMeta.Name))
+func (pkgw *packageWatcher) releaseBuildSlot() {
+	if pkgw.buildSlots != nil {
+		<-pkgw.buildSlots
+	}
+}
+
+// retryBuild queues the next attempt of a failed build once its backoff
+// delay has passed. The attempt is in the build cache while waiting, so
+// it is canceled if the package is deleted or updated meanwhile.
+func (pkgw *packageWatcher) retryBuild(next *pkgBuild) {
+	pkgw.newBuildContext(next)
+	// the resource version may not change between attempts,
+	// so keep the attempts apart in the build cache
+	next.key = fmt.Sprintf("%s-attempt-%d", pkgw.buildCacheKey(next.pkg.ObjectMeta), next.attempt)
+	_, err := pkgw.buildCache.Set(next.key, next)
+	if err != nil {
+		next.cancel(nil)
+		pkgw.logger.Info("package build cache set error", zap.Error(err))
+		return
+	}
+	// the retry is tracked with the running builds, so that shutdown
+	// waits for it to mark its package interrupted
+	if !pkgw.startBuild() {
+		pkgw.forgetBuild(next, errShuttingDown)
+		return
+	}
+	delay := pkgw.retryDelay(next.attempt - 1)
+	go func() {
+		defer pkgw.running.Done()
+		sleepWithContext(next.ctx, delay)
+		if buildCanceled(next.ctx, pkgw.logger, next.pkg) {
+			pkgw.markCanceled(next, next.pkg)
+			// the attempt never ran, the build ended with the last one
+			pkgw.logBuildSummary(&pkgBuild{pkg: next.pkg, attempt: next.attempt - 1, startTime: next.startTime,
+				trigger: next.trigger}, canceledSummary(next.ctx))
+			pkgw.forgetBuild(next, nil)
 			return
 		}
-		time.Sleep(healthCheckBackOff.GetNext())
+		pkgw.buildQueue.Push(next)
+		pkgw.dispatchBuilds()
+	}()
+}
+
+// retryDelay returns the backoff delay after the given failed attempt.
+func (pkgw *packageWatcher) retryDelay(attempt int) time.Duration {
+	delay := pkgw.buildRetryDelay
+	for i := 1; i < attempt && delay < maxBuildRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxBuildRetryDelay {
+		delay = maxBuildRetryDelay
+	}
+	return delay
+}
+
+// maxBuildAttempts returns the number of times the package is built
+// before it goes into failed state.
+func (pkgw *packageWatcher) maxBuildAttempts(pkg *fv1.Package) int {
+	retries := pkgw.maxBuildRetries
+	if v, ok := pkg.ObjectMeta.Annotations[fv1.ANNOTATION_MAX_BUILD_RETRIES]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			pkgw.logger.Warn("invalid max build retries annotation, using default",
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("namespace", pkg.ObjectMeta.Namespace),
+				zap.String("value", v),
+				zap.Int("default", retries))
+		} else {
+			retries = n
+		}
 	}
-	// build timeout
-	_, err = updatePackage(ctx, pkgw.logger, pkgw.fissionClient, pkg,
-		fv1.BuildStatusFailed, "Build timeout due to environment builder not ready", nil)
-	if err != nil {
-		pkgw.logger.Error(
-			"error updating package",
-			zap.String("package_name", pkg.ObjectMeta.Name),
-			zap.String("resource_version", pkg.ObjectMeta.ResourceVersion),
-			zap.Error(err),
-		)
+	if retries < 0 {
+		retries = 0
+	}
+	return retries + 1
+}
+
+// build runs a build attempt of the package with ExecuteBuild. It returns
+// the next attempt to schedule if the build failed and is going to be retried,
+// builds that are done get their summary logged.
+func (pkgw *packageWatcher) build(b *pkgBuild) *pkgBuild {
+	// the package may be deleted while the build was waiting in the queue
+	if buildCanceled(b.ctx, pkgw.logger, b.pkg) {
+		pkgw.markCanceled(b, b.pkg)
+		pkgw.logBuildSummary(b, canceledSummary(b.ctx))
+		return nil
+	}
+	if b.startTime.IsZero() {
+		b.startTime = time.Now()
+	}
+	b.started.Store(b.startTime)
+	// the build logs its failures, the result tells whether to retry
+	result, _ := ExecuteBuild(b.ctx, pkgw.deps, b.pkg, BuildOptions{
+		Attempt:         b.attempt,
+		StartTime:       b.startTime,
+		MaxAttempts:     pkgw.maxBuildAttempts(b.pkg),
+		Logs:            b.logs,
+		Trigger:         b.trigger,
+		RetryDelay:      pkgw.retryDelay(b.attempt),
+		Timeout:         pkgw.buildTimeoutFor(b.pkg),
+		TimeoutLimit:    buildTimeoutLimit(b.pkg),
+		MaxBuildLogSize: pkgw.maxBuildLogSize,
+		DryRun:          dryRunRequested(b.pkg),
+		onStateChange:   func(state buildState) { b.state.Store(int32(state)) },
+		onPhaseChange:   func(phase fv1.BuildPhase) { b.phase.Store(phase) },
+		onBuilderPod:    func(pod string) { b.builderPod.Store(pod) },
+		onBuilderImage:  func(image string) { b.builderImage = image },
+	})
+	if result.Canceled {
+		pkgw.markCanceled(b, result.Package)
+		pkgw.logBuildSummary(b, canceledSummary(b.ctx))
+		return nil
+	}
+	if !result.Retry {
+		pkgw.logBuildSummary(b, resultSummary(result))
+		if result.Status == fv1.BuildStatusSucceeded && !result.DryRun {
+			pkgw.collectArchives(b.pkg, result.Package)
+			pkgw.deleteSource(result.Package)
+		}
+		if !result.DryRun {
+			pkgw.rebuildOnBuilderImageChange(b)
+		}
+		return nil
+	}
+	return &pkgBuild{
+		pkg:       result.Package,
+		attempt:   b.attempt + 1,
+		logs:      result.Logs,
+		startTime: b.startTime,
+		trigger:   b.trigger,
+		priority:  b.priority,
 	}
+}
 
-	pkgw.logger.Error("max retries exceeded in building source package, timeout due to environment builder not ready",
-		zap.String("package", fmt.Sprintf("%s.%s", pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace)))
+// recoverBuild recovers from a panic of the build goroutine. The package is
+// failed and its build forgotten, so that the next update of the package
+// builds it again.
+func (pkgw *packageWatcher) recoverBuild(b *pkgBuild) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	pkgw.logger.Error("package build panicked", zap.Any("panic", r), zap.Stack("stack"),
+		zap.String("package_name", b.pkg.ObjectMeta.Name),
+		zap.String("namespace", b.pkg.ObjectMeta.Namespace))
+	pkgw.forgetBuild(b, nil)
+	pkgw.markPanicked(b, r)
+	pkgw.logBuildSummary(b, buildSummary{result: buildSummaryPanicked, reason: fv1.PackageReasonBuildFailed})
 }
 
-func (pkgw *packageWatcher) packageInformerHandler(ctx context.Context) k8sCache.ResourceEventHandlerFuncs {
-	processPkg := func(ctx context.Context, pkg *fv1.Package) {
-		var err error
-		if len(pkg.Status.BuildStatus) == 0 {
-			_, err = setInitialBuildStatus(ctx, pkgw.fissionClient, pkg)
-			if err != nil {
-				pkgw.logger.Error("error filling package status", zap.Error(err))
-			}
-			// once we update the package status, an update event
-			// will arrive and handle by UpdateFunc later. So we
-			// don't need to build the package at this moment.
-			return
+// markPanicked fails the package of a build that panicked, unless the
+// package changed or its build finished since. The panic leaves the package
+// in whatever state the build got to, it would stay running otherwise.
+func (pkgw *packageWatcher) markPanicked(b *pkgBuild, cause interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), canceledStatusTimeout)
+	defer cancel()
+	logger := pkgw.logger.With(
+		zap.String("package_name", b.pkg.ObjectMeta.Name),
+		zap.String("namespace", b.pkg.ObjectMeta.Namespace))
+	pkg, err := pkgw.fissionClient.CoreV1().Packages(b.pkg.ObjectMeta.Namespace).Get(ctx, b.pkg.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("error getting package of panicked build", zap.Error(err))
+		return
+	}
+	if pkg.ObjectMeta.UID != b.pkg.ObjectMeta.UID || pkg.ObjectMeta.Generation != b.pkg.ObjectMeta.Generation ||
+		(pkg.Status.BuildStatus != fv1.BuildStatusRunning && pkg.Status.BuildStatus != fv1.BuildStatusPending) {
+		return
+	}
+	pkg.Status.BuildStatus = fv1.BuildStatusFailed
+	pkg.Status.BuildLog += logf(logPhaseBuildermgr, "Build failed, internal error during build: %v", cause)
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionFalse,
+		Reason:             fv1.PackageReasonBuildFailed,
+		Message:            fmt.Sprintf("internal error during build: %v", cause),
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	_, err = crd.UpdatePackageStatus(ctx, pkgw.fissionClient, pkg)
+	if err != nil && !k8serrors.IsConflict(err) && !k8serrors.IsNotFound(err) {
+		logger.Error("error setting package failed state", zap.Error(err))
+	}
+}
+
+// processPkg handles a package event: it fills the status of new packages,
+// takes the rebuild and skip build requests and enqueues the builds of the
+// pending packages. Packages already enqueued are dropped by the build cache,
+// events of the same resource version are processed once.
+func (pkgw *packageWatcher) processPkg(ctx context.Context, pkg *fv1.Package) {
+	if skipBuildRequested(pkg) {
+		pkgw.skipBuild(ctx, pkg)
+		return
+	}
+	var err error
+	if len(pkg.Status.BuildStatus) == 0 {
+		_, err = setInitialBuildStatus(ctx, pkgw.fissionClient, pkg)
+		if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+			pkgw.logger.Debug("package changed since the event, leaving its status to the next event",
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("namespace", pkg.ObjectMeta.Namespace),
+				zap.Error(err))
+		} else if err != nil {
+			pkgw.logger.Error("error filling package status", zap.Error(err))
 		}
-		// Only build pending state packages.
-		if pkg.Status.BuildStatus == fv1.BuildStatusPending {
-			pkgw.buildWithCache(ctx, pkg)
+		// once we update the package status, an update event
+		// will arrive and handle by UpdateFunc later. So we
+		// don't need to build the package at this moment.
+		return
+	}
+	if rebuildRequested(pkg) {
+		pkgw.takeRebuildRequest(ctx, pkg)
+		return
+	}
+	// Only build pending state packages, failed and canceled
+	// ones wait for a spec change or a rebuild request. Dry-run
+	// packages stay pending after their dry run.
+	if pkg.Status.BuildStatus == fv1.BuildStatusPending && !(dryRunRequested(pkg) && dryRunDone(pkg)) {
+		trigger := pendingBuildTrigger(pkg)
+		if pkgw.delayRebuild(ctx, pkg, trigger) {
+			return
 		}
+		pkgw.buildWithCache(pkg, trigger)
 	}
+}
+
+func (pkgw *packageWatcher) packageInformerHandler(ctx context.Context) k8sCache.ResourceEventHandlerFuncs {
 	return k8sCache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			pkg := obj.(*fv1.Package)
-			processPkg(ctx, pkg)
+			pkg, ok := obj.(*fv1.Package)
+			if !ok {
+				eventDecodeError(pkgw.logger, informerPackage, eventAdd, obj)
+				return
+			}
+			pkgw.processPkg(ctx, pkg)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			oldPkg := oldObj.(*fv1.Package)
-			pkg := newObj.(*fv1.Package)
-
-			// TODO: Once enable "/status", check generation for spec changed instead.
-			//   Before "/status" is enabled, the generation and resource version will be changed
-			//   if we update the status of a package, hence we are not able to differentiate
-			//   the spec change or status change. So we only build package which has status
-			//   us "pending" and user have to use "kubectl replace" to update a package.
-			if oldPkg.ResourceVersion == pkg.ResourceVersion &&
-				pkg.Status.BuildStatus != fv1.BuildStatusPending {
+			oldPkg, ok := oldObj.(*fv1.Package)
+			if !ok {
+				eventDecodeError(pkgw.logger, informerPackage, eventUpdate, oldObj)
+				return
+			}
+			pkg, ok := newObj.(*fv1.Package)
+			if !ok {
+				eventDecodeError(pkgw.logger, informerPackage, eventUpdate, newObj)
 				return
 			}
-			processPkg(ctx, pkg)
+
+			// Status updates go through the "/status" subresource and leave the
+			// generation alone, so a new generation means the spec changed. The
+			// deployment archive written by a finished build changes the spec too,
+			// only changes of the build inputs need a rebuild. Marking the package
+			// pending triggers the build with the next update event. Packages
+			// skipping their build are never marked pending.
+			if !skipBuildRequested(pkg) &&
+				oldPkg.ObjectMeta.Generation != pkg.ObjectMeta.Generation &&
+				buildInputsChanged(oldPkg, pkg) &&
+				pkg.Status.BuildStatus != fv1.BuildStatusPending &&
+				!pkg.Spec.Source.IsEmpty() {
+				_, err := setPendingBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), fv1.BuildTriggerSpecChanged)
+				if err != nil {
+					pkgw.logger.Error("error setting package pending state",
+						zap.String("package_name", pkg.ObjectMeta.Name),
+						zap.String("namespace", pkg.ObjectMeta.Namespace),
+						zap.Error(err))
+				}
+				return
+			}
+			pkgw.processPkg(ctx, pkg)
+		},
+		DeleteFunc: func(obj interface{}) {
+			pkg, ok := eventObject(obj).(*fv1.Package)
+			if !ok {
+				eventDecodeError(pkgw.logger, informerPackage, eventDelete, eventObject(obj))
+				return
+			}
+			pkgw.cancelBuilds(pkg, "", errPackageDeleted)
 		},
 	}
 }
 
-func (pkgw *packageWatcher) Run(ctx context.Context) {
+// rebuildRequested reports whether the package is annotated for a rebuild.
+func rebuildRequested(pkg *fv1.Package) bool {
+	return pkg.ObjectMeta.Annotations[fv1.ANNOTATION_REBUILD] == "true"
+}
+
+// takeRebuildRequest removes the rebuild annotation of the package and marks
+// it pending. The annotation is removed from the resource version carrying it,
+// so of the events delivering the same request, e.g. informer resyncs, only
+// the first one takes it and the others conflict. The pending status gives
+// the package a new resource version, the rebuild isn't dropped as duplicate
+// of an earlier build by the build cache. Packages already pending or being
+// built get no further build.
+func (pkgw *packageWatcher) takeRebuildRequest(ctx context.Context, pkg *fv1.Package) {
+	logger := pkgw.logger.With(
+		zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace))
+	updated := pkg.DeepCopy()
+	delete(updated.ObjectMeta.Annotations, fv1.ANNOTATION_REBUILD)
+	updated, err := pkgw.fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+	if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+		logger.Debug("package changed since the rebuild request, leaving it to the next event", zap.Error(err))
+		return
+	}
+	if err != nil {
+		logger.Error("error removing package rebuild annotation", zap.Error(err))
+		return
+	}
+
+	switch {
+	case pkg.Spec.Source.IsEmpty():
+		logger.Info("ignoring rebuild request of package without source archive")
+	case pkg.Status.BuildStatus == fv1.BuildStatusPending || pkg.Status.BuildStatus == fv1.BuildStatusRunning:
+		logger.Info("ignoring rebuild request of package already waiting for or in a build",
+			zap.String("build_status", string(pkg.Status.BuildStatus)))
+	default:
+		logger.Info("rebuilding package on request", zap.String("build_status", string(pkg.Status.BuildStatus)))
+		_, err = setPendingBuildStatus(ctx, pkgw.fissionClient, updated, fv1.BuildTriggerRebuildAnnotation)
+		if err != nil {
+			logger.Error("error setting package pending state", zap.Error(err))
+		}
+	}
+}
+
+// skipBuildRequested reports whether the package is annotated to skip its
+// build.
+func skipBuildRequested(pkg *fv1.Package) bool {
+	return pkg.ObjectMeta.Annotations[fv1.ANNOTATION_SKIP_BUILD] == "true"
+}
+
+// skipBuild cancels the builds of a package skipping its build and gives it
+// the skipped status: none if it has a deployment archive, failed otherwise.
+// The status is written once per package generation, so that a deployment
+// archive uploaded later turns a failed package into a deployable one.
+func (pkgw *packageWatcher) skipBuild(ctx context.Context, pkg *fv1.Package) {
+	pkgw.cancelBuilds(pkg, "", errBuildSkipped)
+	var status fv1.BuildStatus = fv1.BuildStatusNone
+	if pkg.Spec.Deployment.IsEmpty() {
+		status = fv1.BuildStatusFailed
+	}
+	cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuildSucceeded)
+	if pkg.Status.BuildStatus == status && cond != nil && cond.Reason == fv1.PackageReasonBuildSkipped &&
+		cond.ObservedGeneration == pkg.ObjectMeta.Generation {
+		return
+	}
+	_, err := setSkippedBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), status)
+	if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+		pkgw.logger.Debug("package changed since the event, leaving the skipped status to the next event",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Error(err))
+		return
+	}
+	if err != nil {
+		pkgw.logger.Error("error setting package skipped state",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.Error(err))
+	}
+}
+
+// StartInformers serves the metrics and runs the pod and package informers.
+// A standby builder manager keeps its informer caches warm with them, so
+// that it takes over the builds quickly once it leads.
+func (pkgw *packageWatcher) StartInformers(ctx context.Context) {
 	go metrics.ServeMetrics(ctx, pkgw.logger)
 	for _, podInformer := range pkgw.podInformer {
 		go podInformer.Run(ctx.Done())
 	}
 	for _, pkgInformer := range pkgw.pkgInformer {
-		pkgInformer.AddEventHandler(pkgw.packageInformerHandler(ctx))
 		go pkgInformer.Run(ctx.Done())
 	}
 }
 
+// Run handles the package events and builds the pending packages until ctx
+// is done. The informers must be started with StartInformers.
+func (pkgw *packageWatcher) Run(ctx context.Context) {
+	pkgw.leading.Store(true)
+	pkgw.restoreBuildQueue(ctx)
+	for _, pkgInformer := range pkgw.pkgInformer {
+		pkgInformer.AddEventHandler(pkgw.packageInformerHandler(ctx))
+	}
+	pkgw.reconciling.Store(true)
+	go func() {
+		pkgw.reconcilePendingPackages(ctx)
+		// the watcher stopped, e.g. the leadership was lost, before the
+		// informers synced
+		if ctx.Err() != nil {
+			return
+		}
+		pkgw.reconciling.Store(false)
+		pkgw.dispatchBuilds()
+	}()
+	go pkgw.reconcileAbandonedBuilds(ctx)
+	if pkgw.ledger != nil {
+		go pkgw.ledger.run(ctx, pkgw.buildQueue)
+	}
+	go pkgw.reportBuildQueue(ctx)
+}
+
+// reconcilePendingPackages processes the pending packages and the ones
+// without status once the package informers are synced. The add events of
+// the initial list may be missed by the event handlers added after the
+// informers started, or be cut short by a restart during their replay.
+// The packages whose events did arrive are dropped by the build cache.
+func (pkgw *packageWatcher) reconcilePendingPackages(ctx context.Context) {
+	synced := make([]k8sCache.InformerSynced, 0, len(pkgw.pkgInformer))
+	for _, informer := range pkgw.pkgInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !k8sCache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	var pending int
+	for _, informer := range pkgw.pkgInformer {
+		for _, obj := range informer.GetStore().List() {
+			pkg, ok := obj.(*fv1.Package)
+			if !ok || (len(pkg.Status.BuildStatus) > 0 && pkg.Status.BuildStatus != fv1.BuildStatusPending) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			pending++
+			pkgw.processPkg(ctx, pkg.DeepCopy())
+		}
+	}
+	pkgw.logger.Info("reconciled pending packages", zap.Int("packages", pending))
+}
+
 // setInitialBuildStatus sets initial build status to a package if it is empty.
 // This normally occurs when the user applies package YAML files that have no status field
 // through kubectl.
@@ -333,12 +1045,125 @@ func setInitialBuildStatus(ctx context.Context, fissionClient versioned.Interfac
 		pkg.Status.BuildStatus = fv1.BuildStatusNone
 	} else if !pkg.Spec.Source.IsEmpty() {
 		pkg.Status.BuildStatus = fv1.BuildStatusPending
+		pkg.Status.BuildTrigger = fv1.BuildTriggerPackageCreated
 	} else {
 		// mark package failed since we cannot do anything with it.
 		pkg.Status.BuildStatus = fv1.BuildStatusFailed
 		pkg.Status.BuildLog = "Both deploy and source archive are empty"
 	}
 
-	// TODO: use UpdateStatus to update status
-	return fissionClient.CoreV1().Packages(pkg.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
+	return crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+}
+
+// setSkippedBuildStatus gives a package skipping its build the status, none
+// or failed. The timing of the last finished build is kept.
+func setSkippedBuildStatus(ctx context.Context, fissionClient versioned.Interface, pkg *fv1.Package, status fv1.BuildStatus) (*fv1.Package, error) {
+	pkg.Status = fv1.PackageStatus{
+		BuildStatus:          status,
+		BuildStartTime:       pkg.Status.BuildStartTime,
+		BuildCompletionTime:  pkg.Status.BuildCompletionTime,
+		BuildDurationSeconds: pkg.Status.BuildDurationSeconds,
+		BuildAttempts:        pkg.Status.BuildAttempts,
+		Conditions:           pkg.Status.Conditions,
+		LastUpdateTimestamp:  metav1.Time{Time: time.Now().UTC()},
+	}
+	cond := metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             fv1.PackageReasonBuildSkipped,
+		Message:            "package build skipped, the deployment archive is provided by " + fv1.ANNOTATION_SKIP_BUILD,
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	}
+	if status == fv1.BuildStatusFailed {
+		msg := fmt.Sprintf("package build skipped by %s annotation but the package has no deployment archive", fv1.ANNOTATION_SKIP_BUILD)
+		pkg.Status.BuildLog = logLines(logPhaseBuildermgr, msg)
+		cond.Status = metav1.ConditionFalse
+		cond.Message = msg
+	}
+	meta.SetStatusCondition(&pkg.Status.Conditions, cond)
+	return crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+}
+
+// setCanceledBuildStatus puts the package into canceled state. Like failed
+// packages, canceled ones aren't rebuilt until their spec changes or a
+// rebuild is requested.
+func setCanceledBuildStatus(ctx context.Context, fissionClient versioned.Interface, pkg *fv1.Package, cause error) (*fv1.Package, error) {
+	pkg.Status.BuildStatus = fv1.BuildStatusCanceled
+	pkg.Status.BuildLog += logf(logPhaseBuildermgr, "Build canceled: %v", cause)
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionFalse,
+		Reason:             fv1.PackageReasonBuildCanceled,
+		Message:            fmt.Sprintf("package build canceled: %v", cause),
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	return crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+}
+
+// setInterruptedBuildStatus puts the package of a build interrupted by the
+// builder manager shutdown back into pending state, the next builder manager
+// builds it again for the same trigger.
+func setInterruptedBuildStatus(ctx context.Context, fissionClient versioned.Interface, pkg *fv1.Package, trigger fv1.BuildTrigger) (*fv1.Package, error) {
+	pkg.Status.BuildStatus = fv1.BuildStatusPending
+	pkg.Status.BuildPhase = ""
+	pkg.Status.BuildTrigger = trigger
+	pkg.Status.BuildLog += logLines(logPhaseBuildermgr, "Build interrupted by builder manager shutdown, it's retried by the next builder manager")
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now().UTC()}
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionUnknown,
+		Reason:             fv1.PackageReasonBuildInterrupted,
+		Message:            "package build interrupted by builder manager shutdown, waiting to be built again",
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	return crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+}
+
+// setPendingBuildStatus marks the package for a rebuild, the trigger tells
+// why. The log and timing of the last finished build stay until the rebuild
+// replaces them.
+func setPendingBuildStatus(ctx context.Context, fissionClient versioned.Interface, pkg *fv1.Package, trigger fv1.BuildTrigger) (*fv1.Package, error) {
+	pkg.Status = fv1.PackageStatus{
+		BuildStatus:          fv1.BuildStatusPending,
+		BuildTrigger:         trigger,
+		BuildLogURL:          pkg.Status.BuildLogURL,
+		BuildStartTime:       pkg.Status.BuildStartTime,
+		BuildCompletionTime:  pkg.Status.BuildCompletionTime,
+		BuildDurationSeconds: pkg.Status.BuildDurationSeconds,
+		BuildAttempts:        pkg.Status.BuildAttempts,
+		BuiltSource:          pkg.Status.BuiltSource,
+		Conditions:           pkg.Status.Conditions,
+		LastUpdateTimestamp:  metav1.Time{Time: time.Now().UTC()},
+	}
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionUnknown,
+		Reason:             fv1.PackageReasonBuildPending,
+		Message:            "package is waiting to be built",
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	return crd.UpdatePackageStatus(ctx, fissionClient, pkg)
+}
+
+// buildInputsChanged reports whether the package spec changed in a way
+// that makes the deployment archive out of date.
+func buildInputsChanged(oldPkg, pkg *fv1.Package) bool {
+	return !equality.Semantic.DeepEqual(oldPkg.Spec.Environment, pkg.Spec.Environment) ||
+		!sameArchive(oldPkg.Spec.Source, pkg.Spec.Source) ||
+		oldPkg.Spec.BuildCommand != pkg.Spec.BuildCommand
+}
+
+// sameArchive reports whether both archives have the same content. A literal
+// moved to the storage service by the literal migration is the same archive.
+func sameArchive(oldArchive, archive fv1.Archive) bool {
+	if equality.Semantic.DeepEqual(oldArchive, archive) {
+		return true
+	}
+	if oldArchive.Type != fv1.ArchiveTypeLiteral || archive.Type != fv1.ArchiveTypeUrl ||
+		archive.Checksum.Type != fv1.ChecksumTypeSHA256 {
+		return false
+	}
+	sum, err := utils.GetChecksum(bytes.NewReader(oldArchive.Literal))
+	return err == nil && sum.Sum == archive.Checksum.Sum
 }
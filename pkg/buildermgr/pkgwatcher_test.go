@@ -0,0 +1,1224 @@
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	"github.com/fission/fission/pkg/storagesvc"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+const (
+	testNamespace = metav1.NamespaceDefault
+	testEnvName   = "test-env"
+	testPkgName   = "test-pkg"
+)
+
+type testPackageWatcher struct {
+	*packageWatcher
+	fissionClient *fClient.Clientset
+	podInformer   k8sCache.SharedIndexInformer
+	env           *fv1.Environment
+	pkg           *fv1.Package
+}
+
+func testEnvironment() *fv1.Environment {
+	return &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testEnvName,
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+		},
+		Spec: fv1.EnvironmentSpec{
+			Version: 2,
+			Builder: fv1.Builder{
+				Image: "builder-image",
+			},
+		},
+	}
+}
+
+func testPackage() *fv1.Package {
+	return &fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testPkgName,
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+		},
+		Spec: fv1.PackageSpec{
+			Environment: fv1.EnvironmentReference{
+				Name:      testEnvName,
+				Namespace: testNamespace,
+			},
+			Source: fv1.Archive{
+				Type: fv1.ArchiveTypeUrl,
+				URL:  "http://storagesvc/archive",
+			},
+		},
+		Status: fv1.PackageStatus{
+			BuildStatus: fv1.BuildStatusPending,
+		},
+	}
+}
+
+func newTestPackageWatcher(t *testing.T) *testPackageWatcher {
+	t.Helper()
+	logger := loggerfactory.GetLogger()
+
+	env := testEnvironment()
+	pkg := testPackage()
+	fissionClient := fClient.NewSimpleClientset(env, pkg)
+	kubernetesClient := fake.NewSimpleClientset()
+	podInformer := informers.NewSharedInformerFactory(kubernetesClient, 0).Core().V1().Pods().Informer()
+
+	pkgw := makePackageWatcher(logger, fissionClient, kubernetesClient, "http://storagesvc", 0, 0, 0, 0,
+		DefaultBuilderBackoff(), map[string]k8sCache.SharedIndexInformer{testNamespace: podInformer},
+		map[string]k8sCache.SharedIndexInformer{})
+	pkgw.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+		return nil
+	}
+	pkgw.deps.probeBuilder = func(ctx context.Context, logger *zap.Logger, env *fv1.Environment, builderNs string) error {
+		return nil
+	}
+	pkgw.deps.logStore = &fakeArchiveStore{}
+	// stop the builds left over by the test
+	t.Cleanup(func() { pkgw.Shutdown(0) })
+
+	return &testPackageWatcher{
+		packageWatcher: pkgw,
+		fissionClient:  fissionClient,
+		podInformer:    podInformer,
+		env:            env,
+		pkg:            pkg,
+	}
+}
+
+// testBuilderPod returns a ready builder pod of the environment.
+func testBuilderPod(env *fv1.Environment) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "builder-pod",
+			Namespace: testNamespace,
+			Labels: map[string]string{
+				LABEL_ENV_NAME:            env.ObjectMeta.Name,
+				LABEL_ENV_NAMESPACE:       testNamespace,
+				LABEL_ENV_RESOURCEVERSION: env.ObjectMeta.ResourceVersion,
+				LABEL_ENV_BUILDER_HASH:    builderSpecHash(env),
+			},
+		},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "builder", Ready: true},
+				{Name: "fetcher", Ready: true},
+			},
+		},
+	}
+}
+
+func (tpw *testPackageWatcher) addReadyBuilderPod(t *testing.T) {
+	t.Helper()
+	err := tpw.podInformer.GetStore().Add(testBuilderPod(tpw.env))
+	if err != nil {
+		t.Fatalf("Error adding builder pod to informer store: %v", err)
+	}
+}
+
+// deletePackage removes the package from the API server and notifies the
+// watcher the same way the package informer does.
+func (tpw *testPackageWatcher) deletePackage(ctx context.Context, t *testing.T) {
+	t.Helper()
+	err := tpw.fissionClient.CoreV1().Packages(testNamespace).Delete(ctx, testPkgName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("Error deleting package: %v", err)
+	}
+	tpw.packageInformerHandler(ctx).OnDelete(tpw.pkg)
+}
+
+// waitForBuildsDone waits until there is no package build left in the build cache.
+func (tpw *testPackageWatcher) waitForBuildsDone(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if len(tpw.buildCache.Copy()) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Build did not finish within %v", timeout)
+}
+
+func buildsCount(result string) float64 {
+	return testutil.ToFloat64(buildsTotal.WithLabelValues(testEnvName, testNamespace, result))
+}
+
+func (tpw *testPackageWatcher) countPackageUpdates(status fv1.BuildStatus) int {
+	count := 0
+	for _, action := range tpw.fissionClient.Actions() {
+		update, ok := action.(k8sTesting.UpdateAction)
+		if !ok || action.GetResource().Resource != "packages" {
+			continue
+		}
+		if pkg, ok := update.GetObject().(*fv1.Package); ok && pkg.Status.BuildStatus == status {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBuildCanceledOnPackageDeleteBeforeBuilderReady(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	buildPackageCalled := make(chan struct{}, 1)
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		buildPackageCalled <- struct{}{}
+		return nil, "", nil
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+
+	// no builder pod exists, so the build keeps waiting for the builder
+	time.Sleep(100 * time.Millisecond)
+	tpw.deletePackage(ctx, t)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	select {
+	case <-buildPackageCalled:
+		t.Error("Expected package not to be built after deletion")
+	default:
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 0 {
+		t.Errorf("Expected no failed status update for deleted package, got %d", n)
+	}
+}
+
+func TestBuildCanceledOnPackageDeleteDuringUpload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+
+	uploadStarted := make(chan struct{})
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		close(uploadStarted)
+		// block like an in-flight upload until the request context is canceled
+		<-ctx.Done()
+		return nil, "upload interrupted", ctx.Err()
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+
+	select {
+	case <-uploadStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Build did not start uploading")
+	}
+	tpw.deletePackage(ctx, t)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 0 {
+		t.Errorf("Expected no failed status update for deleted package, got %d", n)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusSucceeded); n != 0 {
+		t.Errorf("Expected no succeeded status update for deleted package, got %d", n)
+	}
+}
+
+func TestBuildRestartedOnPackageSourceChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+
+	const (
+		oldSourceURL = "http://storagesvc/archive-old"
+		newSourceURL = "http://storagesvc/archive-new"
+	)
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		// the old source takes longer to build, so without canceling
+		// it would finish last and overwrite the newer deployment archive
+		buildTime := 100 * time.Millisecond
+		if pkg.Spec.Source.URL == oldSourceURL {
+			buildTime = 2 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(buildTime):
+		}
+		return &fetcher.ArchiveUploadResponse{
+			ArchiveDownloadUrl: pkg.Spec.Source.URL + "-deploy",
+		}, "build succeeded", nil
+	}
+
+	oldPkg := tpw.pkg.DeepCopy()
+	oldPkg.Spec.Source.URL = oldSourceURL
+	_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, oldPkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	tpw.buildWithCache(oldPkg, fv1.BuildTriggerPackageCreated)
+
+	time.Sleep(time.Second)
+
+	newPkg := tpw.pkg.DeepCopy()
+	newPkg.ObjectMeta.ResourceVersion = "2"
+	newPkg.Spec.Source.URL = newSourceURL
+	newPkg.Status.BuildStatus = fv1.BuildStatusPending
+	_, err = tpw.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, newPkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	tpw.buildWithCache(newPkg, fv1.BuildTriggerPackageCreated)
+
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Spec.Deployment.URL != newSourceURL+"-deploy" {
+		t.Errorf("Expected deployment archive %s, got %s", newSourceURL+"-deploy", pkg.Spec.Deployment.URL)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusSucceeded, pkg.Status.BuildStatus)
+	}
+}
+
+func TestBuildRetriedAfterTransientFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.maxBuildRetries = 2
+	tpw.buildRetryDelay = 10 * time.Millisecond
+
+	calls := 0
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		calls++
+		if calls == 1 {
+			return nil, "error uploading deployment package\n", errors.New("storage service unavailable")
+		}
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if calls != 2 {
+		t.Errorf("Expected 2 build attempts, got %d", calls)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 0 {
+		t.Errorf("Expected no failed status update before the last attempt, got %d", n)
+	}
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusSucceeded, pkg.Status.BuildStatus)
+	}
+	for _, line := range []string{"Build attempt 1/3 failed", "Build attempt 2/3, triggered by PackageCreated\n"} {
+		if !strings.Contains(pkg.Status.BuildLog, line) {
+			t.Errorf("Expected build log to contain %q, got %q", line, pkg.Status.BuildLog)
+		}
+	}
+}
+
+func TestBuildFailedAfterLastRetry(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.maxBuildRetries = 5
+	tpw.buildRetryDelay = 10 * time.Millisecond
+	tpw.pkg.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_MAX_BUILD_RETRIES: "1"}
+
+	calls := 0
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		calls++
+		return nil, "error fetching source package\n", errors.New("storage service unavailable")
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if calls != 2 {
+		t.Errorf("Expected 2 build attempts, got %d", calls)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 1 {
+		t.Errorf("Expected one failed status update, got %d", n)
+	}
+}
+
+func TestBuildNotRetriedOnPermanentFailure(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.maxBuildRetries = 2
+	tpw.buildRetryDelay = 10 * time.Millisecond
+
+	calls := 0
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		calls++
+		return nil, "build command failed\n", permanentBuildError{errors.New("build command failed")}
+	}
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if calls != 1 {
+		t.Errorf("Expected 1 build attempt, got %d", calls)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 1 {
+		t.Errorf("Expected one failed status update, got %d", n)
+	}
+}
+
+func TestBuildFailedOnTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.maxBuildRetries = 2
+	tpw.buildRetryDelay = 10 * time.Millisecond
+	tpw.buildTimeout = time.Hour
+	tpw.pkg.Spec.BuildTimeout = 1
+
+	calls := 0
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		calls++
+		// hang like a stuck builder until the build deadline
+		<-ctx.Done()
+		return nil, "", ctx.Err()
+	}
+
+	timeouts := buildsCount(buildResultTimeout)
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if calls != 1 {
+		t.Errorf("Expected timed out build not to be retried, got %d attempts", calls)
+	}
+	if n := buildsCount(buildResultTimeout) - timeouts; n != 1 {
+		t.Errorf("Expected one timed out build to be counted, got %v", n)
+	}
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusFailed, pkg.Status.BuildStatus)
+	}
+	if !strings.Contains(pkg.Status.BuildLog, "Build exceeded timeout of 1s") {
+		t.Errorf("Expected build log to report the timeout, got %q", pkg.Status.BuildLog)
+	}
+}
+
+func TestBuildTimeoutAbortsBuilderWait(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.buildTimeout = 200 * time.Millisecond
+
+	// no builder pod exists, the deadline ends the wait long
+	// before the health check backoff runs out
+	start := time.Now()
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected build to time out quickly, took %v", elapsed)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusFailed); n != 1 {
+		t.Errorf("Expected one failed status update, got %d", n)
+	}
+}
+
+func TestPackageSpecChangeMarksPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	handler := tpw.packageInformerHandler(ctx)
+
+	oldPkg := tpw.pkg.DeepCopy()
+	oldPkg.ObjectMeta.Generation = 1
+	oldPkg.Status.BuildStatus = fv1.BuildStatusSucceeded
+	oldPkg.Status.Conditions = []metav1.Condition{
+		{Type: fv1.PackageConditionBuildSucceeded, Status: metav1.ConditionTrue, Reason: fv1.PackageReasonBuildSucceeded, ObservedGeneration: 1},
+		{Type: fv1.PackageConditionFunctionsUpdated, Status: metav1.ConditionTrue, Reason: fv1.PackageReasonFunctionsUpdated, ObservedGeneration: 1},
+	}
+
+	// the deployment archive of a finished build doesn't need a rebuild
+	deployed := oldPkg.DeepCopy()
+	deployed.ObjectMeta.Generation = 2
+	deployed.Spec.Deployment = fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/deploy"}
+	handler.OnUpdate(oldPkg, deployed)
+	if n := tpw.countPackageUpdates(fv1.BuildStatusPending); n != 0 {
+		t.Errorf("Expected no pending status update after deployment change, got %d", n)
+	}
+
+	changed := deployed.DeepCopy()
+	changed.ObjectMeta.Generation = 3
+	changed.Spec.Source.URL = "http://storagesvc/archive-new"
+	handler.OnUpdate(deployed, changed)
+
+	statusUpdates := 0
+	for _, action := range tpw.fissionClient.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+			statusUpdates++
+		}
+	}
+	if statusUpdates != 1 {
+		t.Errorf("Expected the pending status to be written through the status subresource, got %d status updates", statusUpdates)
+	}
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusPending {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusPending, pkg.Status.BuildStatus)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildPending)
+	// the other conditions still tell about the last build
+	if !meta.IsStatusConditionTrue(pkg.Status.Conditions, fv1.PackageConditionFunctionsUpdated) {
+		t.Errorf("Expected FunctionsUpdated condition of the last build to be kept, got %+v", pkg.Status.Conditions)
+	}
+}
+
+func TestRebuildAnnotationTriggersOneBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	// the fake clientset doesn't check resource versions, writes
+	// of stale packages must conflict for the test
+	tracker := tpw.fissionClient.Tracker()
+	tpw.fissionClient.PrependReactor("update", "packages", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		pkg := action.(k8sTesting.UpdateAction).GetObject().(*fv1.Package)
+		cur, err := tracker.Get(fv1.SchemeGroupVersion.WithResource("packages"), pkg.ObjectMeta.Namespace, pkg.ObjectMeta.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		rv := cur.(*fv1.Package).ObjectMeta.ResourceVersion
+		if pkg.ObjectMeta.ResourceVersion != rv {
+			return true, nil, k8serrors.NewConflict(fv1.Resource("packages"), pkg.ObjectMeta.Name, errors.New("stale resource version"))
+		}
+		n, _ := strconv.Atoi(rv)
+		pkg.ObjectMeta.ResourceVersion = strconv.Itoa(n + 1)
+		return false, nil, nil
+	})
+	handler := tpw.packageInformerHandler(ctx)
+	pendingStatusUpdates := func() int {
+		count := 0
+		for _, action := range tpw.fissionClient.Actions() {
+			if update, ok := action.(k8sTesting.UpdateAction); ok && action.GetSubresource() == "status" &&
+				update.GetObject().(*fv1.Package).Status.BuildStatus == fv1.BuildStatusPending {
+				count++
+			}
+		}
+		return count
+	}
+
+	succeeded := tpw.pkg.DeepCopy()
+	succeeded.Status.BuildStatus = fv1.BuildStatusSucceeded
+	_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).UpdateStatus(ctx, succeeded, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	annotated, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	annotated.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_REBUILD: "true"}
+	annotated, err = tpw.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, annotated, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error annotating package: %v", err)
+	}
+
+	// the same request delivered twice, e.g. by a resync
+	handler.OnUpdate(succeeded, annotated)
+	handler.OnUpdate(annotated, annotated)
+
+	if n := pendingStatusUpdates(); n != 1 {
+		t.Errorf("Expected the rebuild request to mark the package pending once, got %d", n)
+	}
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if _, ok := pkg.ObjectMeta.Annotations[fv1.ANNOTATION_REBUILD]; ok {
+		t.Error("Expected the rebuild annotation to be removed")
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusPending {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusPending, pkg.Status.BuildStatus)
+	}
+
+	// requests of packages waiting for a build are taken without another one
+	pending := pkg.DeepCopy()
+	pending.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_REBUILD: "true"}
+	pending, err = tpw.fissionClient.CoreV1().Packages(testNamespace).Update(ctx, pending, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error annotating package: %v", err)
+	}
+	handler.OnUpdate(pkg, pending)
+	if n := pendingStatusUpdates(); n != 1 {
+		t.Errorf("Expected no pending status update of a pending package, got %d", n-1)
+	}
+	if n := len(tpw.buildCache.Copy()); n != 0 {
+		t.Errorf("Expected the build to wait for the next event, got %d builds", n)
+	}
+}
+
+func TestSkipBuildAnnotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	handler := tpw.packageInformerHandler(ctx)
+	getPackage := func() *fv1.Package {
+		t.Helper()
+		pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting package: %v", err)
+		}
+		return pkg
+	}
+	statusUpdates := func() int {
+		count := 0
+		for _, action := range tpw.fissionClient.Actions() {
+			if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+				count++
+			}
+		}
+		return count
+	}
+
+	// a pending package without deployment archive fails
+	skipped := tpw.pkg.DeepCopy()
+	skipped.ObjectMeta.Generation = 1
+	skipped.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_SKIP_BUILD: "true"}
+	handler.OnAdd(skipped)
+	pkg := getPackage()
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusFailed, pkg.Status.BuildStatus)
+	}
+	if !strings.Contains(pkg.Status.BuildLog, fv1.ANNOTATION_SKIP_BUILD) {
+		t.Errorf("Expected the build log to tell about the skipped build, got %q", pkg.Status.BuildLog)
+	}
+	checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionFalse, fv1.PackageReasonBuildSkipped)
+
+	// the skipped status is written once
+	handler.OnUpdate(skipped, pkg)
+	if n := statusUpdates(); n != 1 {
+		t.Errorf("Expected one status update, got %d", n)
+	}
+
+	// an uploaded deployment archive makes it deployable without a build,
+	// the source change doesn't mark it pending
+	deployed := pkg.DeepCopy()
+	deployed.ObjectMeta.Generation = 2
+	deployed.Spec.Source.URL = "http://storagesvc/archive-new"
+	deployed.Spec.Deployment = fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "http://storagesvc/deploy"}
+	handler.OnUpdate(pkg, deployed)
+	pkg = getPackage()
+	if pkg.Status.BuildStatus != fv1.BuildStatusNone {
+		t.Errorf("Expected build status %s, got %s", fv1.BuildStatusNone, pkg.Status.BuildStatus)
+	}
+	if n := tpw.countPackageUpdates(fv1.BuildStatusPending); n != 0 {
+		t.Errorf("Expected no pending status update, got %d", n)
+	}
+	if n := len(tpw.buildCache.Copy()); n != 0 {
+		t.Errorf("Expected no builds of the skipped package, got %d", n)
+	}
+}
+
+func TestUpdatePackageStatusWithoutSubresource(t *testing.T) {
+	ctx := context.Background()
+	tpw := newTestPackageWatcher(t)
+
+	// package CRDs that predate the status subresource don't serve it
+	tpw.fissionClient.PrependReactor("update", "packages", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "status" {
+			return true, nil, k8serrors.NewNotFound(fv1.Resource("packages"), testPkgName)
+		}
+		return false, nil, nil
+	})
+
+	pkg, err := updatePackage(ctx, tpw.logger, tpw.fissionClient, tpw.pkg.DeepCopy(),
+		fv1.PackageStatus{BuildStatus: fv1.BuildStatusSucceeded, BuildLog: "build succeeded\n"},
+		&fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded || pkg.Spec.Deployment.URL != "http://storagesvc/deploy" {
+		t.Errorf("Expected succeeded package with deployment archive, got %s %q", pkg.Status.BuildStatus, pkg.Spec.Deployment.URL)
+	}
+}
+
+func TestTruncateBuildLogs(t *testing.T) {
+	for _, test := range []struct {
+		logs    string
+		maxSize int
+		want    string
+	}{
+		{"short", 0, "short"},
+		{"short", 10, "short"},
+		{strings.Repeat("a", 100) + "tail", 40, "[log truncated, 99 bytes dropped]\natail"},
+		// the cut doesn't split multi-byte characters
+		{strings.Repeat("é", 50), 40, "[log truncated, 96 bytes dropped]\néé"},
+	} {
+		got := truncateBuildLogs(test.logs, test.maxSize)
+		if got != test.want {
+			t.Errorf("truncateBuildLogs(%d bytes, %d) = %q, want %q", len(test.logs), test.maxSize, got, test.want)
+		}
+		if test.maxSize > 0 && len(got) > test.maxSize {
+			t.Errorf("truncateBuildLogs(%d bytes, %d) returned %d bytes", len(test.logs), test.maxSize, len(got))
+		}
+	}
+}
+
+func TestStorageTargetForPackage(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { storageTargetMappingPath = path }(storageTargetMappingPath)
+	storageTargetMappingPath = dir
+	err := os.WriteFile(filepath.Join(dir, "team-a"), []byte("team-a-target\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := loggerfactory.GetLogger()
+	for _, test := range []struct {
+		namespace   string
+		annotations map[string]string
+		target      string
+	}{
+		{"default", nil, ""},
+		{"team-a", nil, "team-a-target"},
+		{"team-a", map[string]string{fv1.ANNOTATION_STORAGE_TARGET: "other"}, "other"},
+		{"default", map[string]string{fv1.ANNOTATION_STORAGE_TARGET: "other"}, "other"},
+	} {
+		pkg := &fv1.Package{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pkg",
+			Namespace:   test.namespace,
+			Annotations: test.annotations,
+		}}
+		target := storageTargetFor(logger, pkg)
+		if target != test.target {
+			t.Errorf("wrong storage target for %s %v. Got %q, want %q",
+				test.namespace, test.annotations, target, test.target)
+		}
+	}
+}
+
+func TestBuildMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	fn := &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fn", Namespace: testNamespace},
+		Spec: fv1.FunctionSpec{
+			Package: fv1.FunctionPackageRef{
+				PackageRef: fv1.PackageRef{Name: testPkgName, Namespace: testNamespace},
+			},
+		},
+	}
+	_, err := tpw.fissionClient.CoreV1().Functions(testNamespace).Create(ctx, fn, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating function: %v", err)
+	}
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+
+	succeeded := buildsCount(buildResultSucceeded)
+	failed := buildsCount(buildResultFailed)
+	refUpdates := testutil.ToFloat64(packageRefUpdates.WithLabelValues(testNamespace))
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	if n := buildsCount(buildResultSucceeded) - succeeded; n != 1 {
+		t.Errorf("Expected one succeeded build to be counted, got %v", n)
+	}
+	if n := buildsCount(buildResultFailed) - failed; n != 0 {
+		t.Errorf("Expected no failed build to be counted, got %v", n)
+	}
+	if n := testutil.ToFloat64(packageRefUpdates.WithLabelValues(testNamespace)) - refUpdates; n != 1 {
+		t.Errorf("Expected one function package ref update to be counted, got %v", n)
+	}
+	if testutil.CollectAndCount(buildDuration) == 0 {
+		t.Error("Expected build duration to be observed")
+	}
+	if testutil.CollectAndCount(builderWaitDuration) == 0 {
+		t.Error("Expected builder wait duration to be observed")
+	}
+}
+
+func TestBuildFailsWhenArchiveUnavailable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.maxBuildRetries = 2
+	tpw.buildRetryDelay = 10 * time.Millisecond
+	tpw.deps.archiveCheckAttempts = 3
+	tpw.deps.archiveCheckDelay = time.Millisecond
+	fn := &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fn", Namespace: testNamespace},
+		Spec: fv1.FunctionSpec{
+			Package: fv1.FunctionPackageRef{
+				PackageRef: fv1.PackageRef{Name: testPkgName, Namespace: testNamespace},
+			},
+		},
+	}
+	_, err := tpw.fissionClient.CoreV1().Functions(testNamespace).Create(ctx, fn, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating function: %v", err)
+	}
+
+	calls, checks := 0, 0
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		calls++
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	tpw.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+		checks++
+		return errors.New("HTTP error 404 Not Found")
+	}
+
+	tripped := testutil.ToFloat64(artifactUnavailable.WithLabelValues(testEnvName, testNamespace))
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	// the storage service may catch up, so the build is retried
+	if calls != 3 || checks != 9 {
+		t.Errorf("Expected 3 build attempts with 3 archive checks each, got %d builds and %d checks", calls, checks)
+	}
+	if n := testutil.ToFloat64(artifactUnavailable.WithLabelValues(testEnvName, testNamespace)) - tripped; n != 3 {
+		t.Errorf("Expected the archive gate to trip on every attempt, got %v", n)
+	}
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed || !strings.Contains(pkg.Status.BuildLog, reasonArtifactUnavailable) {
+		t.Errorf("Expected build to fail with %s, got %s: %q", reasonArtifactUnavailable, pkg.Status.BuildStatus, pkg.Status.BuildLog)
+	}
+	if !pkg.Spec.Deployment.IsEmpty() {
+		t.Errorf("Expected deployment archive not to be recorded, got %+v", pkg.Spec.Deployment)
+	}
+	fn, err = tpw.fissionClient.CoreV1().Functions(testNamespace).Get(ctx, "test-fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting function: %v", err)
+	}
+	if fn.Spec.Package.PackageRef.ResourceVersion != "" {
+		t.Errorf("Expected function package ref untouched, got resource version %q", fn.Spec.Package.PackageRef.ResourceVersion)
+	}
+}
+
+func TestCheckArchiveFetchable(t *testing.T) {
+	sum := "abc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.Header.Get(storagesvc.HeaderChecksumRequest) != storagesvc.ChecksumSHA256 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("id") {
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "unverifiable":
+			// storage backends that can't serve checksums
+			return
+		}
+		w.Header().Set(storagesvc.HeaderChecksumSHA256, sum)
+		w.Header().Set(storagesvc.HeaderSize, "42")
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		id       string
+		checksum string
+		size     int64
+		verify   bool
+		ok       bool
+		mismatch bool
+	}{
+		{id: "archive", checksum: sum, size: 42, ok: true},
+		{id: "archive", checksum: sum, size: 42, verify: true, ok: true},
+		{id: "archive", checksum: "other", size: 42, mismatch: true},
+		{id: "archive", checksum: sum, size: 41, verify: true, mismatch: true},
+		{id: "missing", checksum: sum},
+		{id: "unverifiable", checksum: sum, ok: true},
+		{id: "unverifiable", checksum: sum, verify: true, mismatch: true},
+	} {
+		check := checkArchiveFetchable
+		if test.verify {
+			check = verifyStoredArchive
+		}
+		err := check(context.Background(), &fetcher.ArchiveUploadResponse{
+			ArchiveDownloadUrl: server.URL + "/v1/archive?id=" + test.id,
+			Checksum:           fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: test.checksum},
+			OriginalSize:       test.size,
+		})
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected check result of archive %s with checksum %s and size %d: %v", test.id, test.checksum, test.size, err)
+		}
+		var verifyErr *archiveVerificationError
+		if isPermanentBuildError(err) != test.mismatch || errors.As(err, &verifyErr) != test.mismatch {
+			t.Errorf("Expected only verification failures to be permanent, archive %s with checksum %s and size %d: %v",
+				test.id, test.checksum, test.size, err)
+		}
+	}
+}
+
+// waitForBuildState waits until a build of the build cache is in the given state.
+func (tpw *testPackageWatcher) waitForBuildState(t *testing.T, state buildState, attempt int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, v := range tpw.buildCache.Copy() {
+			if b, ok := v.(*pkgBuild); ok && b.attempt == attempt && buildState(b.state.Load()) == state {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("No build attempt %d in state %s within 5s", attempt, state)
+}
+
+// countWritesSince counts the package and function updates after the
+// first n actions of the fake client.
+func (tpw *testPackageWatcher) countWritesSince(n int) int {
+	count := 0
+	for _, action := range tpw.fissionClient.Actions()[n:] {
+		if action.GetVerb() == "update" || action.GetVerb() == "patch" {
+			count++
+		}
+	}
+	return count
+}
+
+// blockOnContext returns a build function that reports the build id on
+// started and blocks until the build is canceled.
+func blockOnContext(started chan<- string) func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface,
+	envBuilderNamespace string, storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+	return func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		id, _ := ctx.Value(buildIDKey{}).(string)
+		started <- id
+		<-ctx.Done()
+		return nil, "", ctx.Err()
+	}
+}
+
+func TestShutdownCancelsBuildAtEachPhase(t *testing.T) {
+	for _, test := range []struct {
+		phase string
+		// setup arranges the build to stop in the phase and
+		// returns once it got there
+		setup func(t *testing.T, tpw *testPackageWatcher)
+		// queued builds leave their package pending, the package
+		// of the others is put back into pending state
+		queued bool
+	}{
+		{
+			phase:  "queued",
+			queued: true,
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				// all build slots are taken
+				tpw.buildSlots = make(chan struct{}, 1)
+				tpw.buildSlots <- struct{}{}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				if tpw.buildQueue.Len() != 1 {
+					t.Fatalf("Expected a queued build, got %d", tpw.buildQueue.Len())
+				}
+			},
+		},
+		{
+			phase: "waiting for builder",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				tpw.waitForBuildState(t, buildStateWaitingForBuilder, 1)
+			},
+		},
+		{
+			phase: "building",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				started := make(chan string, 1)
+				tpw.addReadyBuilderPod(t)
+				tpw.deps.buildPackage = blockOnContext(started)
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				if id := <-started; id != buildID(tpw.pkg, 1) {
+					t.Errorf("Expected build ID %s in the build context, got %q", buildID(tpw.pkg, 1), id)
+				}
+			},
+		},
+		{
+			phase: "checking archive",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				started := make(chan struct{})
+				tpw.addReadyBuilderPod(t)
+				tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+				}
+				tpw.deps.checkArchive = func(ctx context.Context, uploadResp *fetcher.ArchiveUploadResponse) error {
+					close(started)
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				<-started
+			},
+		},
+		{
+			phase: "waiting for retry",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.addReadyBuilderPod(t)
+				tpw.maxBuildRetries = 1
+				tpw.buildRetryDelay = time.Hour
+				tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					return nil, "error uploading deployment package\n", errors.New("storage service unavailable")
+				}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				tpw.waitForBuildState(t, buildStatePending, 2)
+			},
+		},
+	} {
+		t.Run(test.phase, func(t *testing.T) {
+			tpw := newTestPackageWatcher(t)
+			test.setup(t, tpw)
+
+			actions := len(tpw.fissionClient.Actions())
+			start := time.Now()
+			tpw.Shutdown(0)
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("Expected shutdown to stop the build promptly, took %v", d)
+			}
+			tpw.waitForBuildsDone(t, time.Second)
+			if test.queued {
+				if n := tpw.countWritesSince(actions); n != 0 {
+					t.Errorf("Expected no writes after the shutdown, got %d", n)
+				}
+			} else {
+				if n := tpw.countWritesSince(actions); n != 1 {
+					t.Errorf("Expected the interrupted build to update its package once, got %d writes", n)
+				}
+				pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Error getting package: %v", err)
+				}
+				if pkg.Status.BuildStatus != fv1.BuildStatusPending || pkg.Status.BuildTrigger != fv1.BuildTriggerPackageCreated {
+					t.Errorf("Expected interrupted build to leave its package pending for the next builder manager, got %s triggered by %s",
+						pkg.Status.BuildStatus, pkg.Status.BuildTrigger)
+				}
+				checkCondition(t, pkg, fv1.PackageConditionBuildSucceeded, metav1.ConditionUnknown, fv1.PackageReasonBuildInterrupted)
+			}
+
+			// nothing starts after the shutdown
+			tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+			if n := len(tpw.buildCache.Copy()); n != 0 {
+				t.Errorf("Expected no build after the shutdown, got %d", n)
+			}
+		})
+	}
+}
+
+func TestCancelBuildMarksPackageCanceled(t *testing.T) {
+	for _, test := range []struct {
+		phase string
+		setup func(t *testing.T, tpw *testPackageWatcher)
+		// start lets a queued build run after it was canceled
+		start func(tpw *testPackageWatcher)
+	}{
+		{
+			phase: "queued",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.buildSlots = make(chan struct{}, 1)
+				tpw.buildSlots <- struct{}{}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+			},
+			start: func(tpw *testPackageWatcher) {
+				tpw.releaseBuildSlot()
+				tpw.dispatchBuilds()
+			},
+		},
+		{
+			phase: "building",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				started := make(chan string, 1)
+				tpw.addReadyBuilderPod(t)
+				tpw.deps.buildPackage = blockOnContext(started)
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				<-started
+			},
+		},
+		{
+			phase: "waiting for retry",
+			setup: func(t *testing.T, tpw *testPackageWatcher) {
+				tpw.addReadyBuilderPod(t)
+				tpw.maxBuildRetries = 1
+				tpw.buildRetryDelay = time.Hour
+				tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+					storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+					return nil, "error uploading deployment package\n", errors.New("storage service unavailable")
+				}
+				tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+				tpw.waitForBuildState(t, buildStatePending, 2)
+			},
+		},
+	} {
+		t.Run(test.phase, func(t *testing.T) {
+			tpw := newTestPackageWatcher(t)
+			test.setup(t, tpw)
+
+			if !tpw.CancelBuild(testNamespace, testPkgName) {
+				t.Fatal("Expected the package build to be canceled")
+			}
+			if test.start != nil {
+				test.start(tpw)
+			}
+			tpw.waitForBuildsDone(t, time.Second)
+
+			pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Error getting package: %v", err)
+			}
+			if pkg.Status.BuildStatus != fv1.BuildStatusCanceled || !strings.Contains(pkg.Status.BuildLog, errCanceledByRequest.Error()) {
+				t.Errorf("Expected canceled package, got %s: %q", pkg.Status.BuildStatus, pkg.Status.BuildLog)
+			}
+			cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuildSucceeded)
+			if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != fv1.PackageReasonBuildCanceled {
+				t.Errorf("Expected BuildSucceeded condition canceled, got %+v", cond)
+			}
+
+			// canceled packages aren't rebuilt on their own
+			tpw.packageInformerHandler(context.Background()).OnUpdate(pkg, pkg)
+			if n := len(tpw.buildCache.Copy()); n != 0 {
+				t.Errorf("Expected no build of the canceled package, got %d", n)
+			}
+			if tpw.CancelBuild(testNamespace, testPkgName) {
+				t.Error("Expected no build left to cancel")
+			}
+		})
+	}
+}
+
+func TestShutdownWaitsForRunningBuild(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		close(started)
+		<-release
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		tpw.Shutdown(5 * time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected shutdown to wait for the running build")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the build finished")
+	}
+
+	if n := tpw.countPackageUpdates(fv1.BuildStatusSucceeded); n != 1 {
+		t.Errorf("Expected the build to finish during the grace period, got %d succeeded updates", n)
+	}
+}
+
+func TestBuildRecoversFromPanic(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.addReadyBuilderPod(t)
+	tpw.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"}, "build succeeded\n", nil
+	}
+	panicked := false
+	tpw.fissionClient.PrependReactor("get", "environments", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if !panicked {
+			panicked = true
+			panic("malformed environment")
+		}
+		return false, nil, nil
+	})
+
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed || !strings.Contains(pkg.Status.BuildLog, "internal error during build: malformed environment") {
+		t.Errorf("Expected package failed with internal error, got %s: %q", pkg.Status.BuildStatus, pkg.Status.BuildLog)
+	}
+	cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuildSucceeded)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != fv1.PackageReasonBuildFailed {
+		t.Errorf("Expected BuildSucceeded condition failed, got %+v", cond)
+	}
+
+	// the panicked build doesn't block the next one
+	tpw.buildWithCache(pkg, fv1.BuildTriggerManualRebuild)
+	tpw.waitForBuildsDone(t, 5*time.Second)
+	pkg, err = tpw.fissionClient.CoreV1().Packages(testNamespace).Get(context.Background(), testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusSucceeded {
+		t.Errorf("Expected the package rebuilt, got %s: %q", pkg.Status.BuildStatus, pkg.Status.BuildLog)
+	}
+}
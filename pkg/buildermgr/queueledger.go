@@ -0,0 +1,319 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	// queueLedgerVersion is the version of the ledger format, ledgers
+	// of other versions are discarded.
+	queueLedgerVersion = 1
+	// queueLedgerKey is the config map key holding the ledger.
+	queueLedgerKey = "queue.json"
+	// queueLedgerSyncInterval is the minimum interval between two ledger
+	// writes, the queue changes in between are written at once.
+	queueLedgerSyncInterval = 5 * time.Second
+	// maxQueueLedgerAge is the age after which a ledger is considered
+	// stale, the queue is rebuilt from the package states then.
+	maxQueueLedgerAge = time.Hour
+	// queueLedgerTimeout is the deadline of a ledger read or write.
+	queueLedgerTimeout = 10 * time.Second
+)
+
+type (
+	// queueLedgerEntry is a queued package build in the ledger.
+	queueLedgerEntry struct {
+		Namespace   string    `json:"namespace"`
+		Name        string    `json:"name"`
+		EnqueueTime time.Time `json:"enqueueTime"`
+	}
+
+	// queueLedgerState is the ledger content, the queued builds in
+	// queue order.
+	queueLedgerState struct {
+		Version    int                `json:"version"`
+		UpdateTime time.Time          `json:"updateTime"`
+		Builds     []queueLedgerEntry `json:"builds"`
+	}
+
+	// queueLedger persists the build queue in a config map, so that the
+	// queued builds keep their order across builder manager restarts. The
+	// queue itself is rebuilt from the pending packages on startup, the
+	// ledger only gives them back their enqueue time.
+	queueLedger struct {
+		logger       *zap.Logger
+		k8sClient    kubernetes.Interface
+		namespace    string
+		name         string
+		syncInterval time.Duration
+
+		// changes signals queue changes to write
+		changes chan struct{}
+
+		mu sync.Mutex
+		// restored holds the enqueue times of the restored ledger by
+		// package namespace/name, until the builds are queued again.
+		restored map[string]time.Time
+
+		// saveMutex serializes the ledger writes and guards closed,
+		// closed is set once the last ledger is written on shutdown.
+		saveMutex sync.Mutex
+		closed    bool
+	}
+)
+
+func newQueueLedger(logger *zap.Logger, k8sClient kubernetes.Interface, namespace, name string) *queueLedger {
+	return &queueLedger{
+		logger:       logger.With(zap.String("ledger", namespace+"/"+name)),
+		k8sClient:    k8sClient,
+		namespace:    namespace,
+		name:         name,
+		syncInterval: queueLedgerSyncInterval,
+		changes:      make(chan struct{}, 1),
+		restored:     make(map[string]time.Time),
+	}
+}
+
+// restore reads the ledger written by the previous builder manager. It
+// returns the number of restored builds, corrupt and stale ledgers are
+// discarded.
+func (l *queueLedger) restore(ctx context.Context) int {
+	ctx, cancel := context.WithTimeout(ctx, queueLedgerTimeout)
+	defer cancel()
+	cm, err := l.k8sClient.CoreV1().ConfigMaps(l.namespace).Get(ctx, l.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return 0
+	}
+	if err != nil {
+		l.logger.Error("error reading build queue ledger, rebuilding the queue from the packages", zap.Error(err))
+		return 0
+	}
+	state, err := decodeQueueLedger(cm.Data[queueLedgerKey])
+	if err != nil {
+		l.logger.Warn("discarding corrupt build queue ledger", zap.Error(err))
+		return 0
+	}
+	if time.Since(state.UpdateTime) > maxQueueLedgerAge {
+		l.logger.Info("discarding stale build queue ledger", zap.Time("update_time", state.UpdateTime))
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range state.Builds {
+		l.restored[e.Namespace+"/"+e.Name] = e.EnqueueTime
+	}
+	l.logger.Info("restored build queue ledger", zap.Int("builds", len(l.restored)))
+	return len(l.restored)
+}
+
+// decodeQueueLedger parses the ledger, entries without a package or
+// enqueue time are dropped.
+func decodeQueueLedger(data string) (*queueLedgerState, error) {
+	state := &queueLedgerState{}
+	err := json.Unmarshal([]byte(data), state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Version != queueLedgerVersion {
+		return nil, errors.Errorf("unsupported ledger version %d", state.Version)
+	}
+	builds := state.Builds[:0]
+	for _, e := range state.Builds {
+		if len(e.Namespace) == 0 || len(e.Name) == 0 || e.EnqueueTime.IsZero() {
+			continue
+		}
+		builds = append(builds, e)
+	}
+	state.Builds = builds
+	return state, nil
+}
+
+// enqueueTime returns the restored enqueue time of the package build, or
+// the zero time if the package wasn't queued before the restart.
+func (l *queueLedger) enqueueTime(namespace, name string) time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	key := namespace + "/" + name
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := l.restored[key]
+	delete(l.restored, key)
+	return t
+}
+
+// forgetRestored drops the restored enqueue times left, their packages
+// aren't pending anymore.
+func (l *queueLedger) forgetRestored() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.restored) > 0 {
+		l.logger.Debug("dropping build queue ledger entries of packages not pending anymore", zap.Int("builds", len(l.restored)))
+	}
+	l.restored = make(map[string]time.Time)
+}
+
+// changed signals a queue change to write to the ledger.
+func (l *queueLedger) changed() {
+	select {
+	case l.changes <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queue changes to the ledger until ctx is done. Changes
+// are batched, the ledger is written at most once per sync interval.
+func (l *queueLedger) run(ctx context.Context, queue *buildQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.changes:
+		}
+		l.save(ctx, queue, false)
+		sleepWithContext(ctx, l.syncInterval)
+	}
+}
+
+// close writes the queue to the ledger a last time, nothing is written
+// afterwards. It is called on shutdown before the queued builds are
+// forgotten, they're restored by the next builder manager.
+func (l *queueLedger) close(queue *buildQueue) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueLedgerTimeout)
+	defer cancel()
+	l.save(ctx, queue, true)
+}
+
+// save writes the builds of the queue to the ledger, last closes the ledger
+// after the write. Builds canceled while queued are left out, restored
+// builds not queued again yet are kept.
+func (l *queueLedger) save(ctx context.Context, queue *buildQueue, last bool) {
+	state := queueLedgerState{
+		Version:    queueLedgerVersion,
+		UpdateTime: time.Now().UTC(),
+		Builds:     []queueLedgerEntry{},
+	}
+	for _, b := range queue.Builds() {
+		if b.ctx.Err() != nil {
+			continue
+		}
+		state.Builds = append(state.Builds, queueLedgerEntry{
+			Namespace:   b.pkg.ObjectMeta.Namespace,
+			Name:        b.pkg.ObjectMeta.Name,
+			EnqueueTime: b.enqueueTime.UTC(),
+		})
+	}
+	l.mu.Lock()
+	for key, t := range l.restored {
+		namespace, name, _ := k8sCache.SplitMetaNamespaceKey(key)
+		state.Builds = append(state.Builds, queueLedgerEntry{
+			Namespace:   namespace,
+			Name:        name,
+			EnqueueTime: t.UTC(),
+		})
+	}
+	l.mu.Unlock()
+	sort.SliceStable(state.Builds, func(i, j int) bool {
+		return state.Builds[i].EnqueueTime.Before(state.Builds[j].EnqueueTime)
+	})
+	data, err := json.Marshal(state)
+	if err != nil {
+		l.logger.Error("error encoding build queue ledger", zap.Error(err))
+		return
+	}
+
+	l.saveMutex.Lock()
+	defer l.saveMutex.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = last
+	ctx, cancel := context.WithTimeout(ctx, queueLedgerTimeout)
+	defer cancel()
+	err = l.write(ctx, string(data))
+	if err != nil {
+		l.logger.Error("error writing build queue ledger", zap.Error(err))
+	}
+}
+
+func (l *queueLedger) write(ctx context.Context, data string) error {
+	configMaps := l.k8sClient.CoreV1().ConfigMaps(l.namespace)
+	cm, err := configMaps.Get(ctx, l.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: l.namespace,
+				Name:      l.name,
+			},
+			Data: map[string]string{queueLedgerKey: data},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[queueLedgerKey] = data
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// restoreBuildQueue restores the queue ledger before the package informers
+// deliver the pending packages. Dispatching the builds is held back until
+// the informers synced, so that the restored builds are queued in their
+// previous order before any of them starts.
+func (pkgw *packageWatcher) restoreBuildQueue(ctx context.Context) {
+	if pkgw.ledger == nil {
+		return
+	}
+	pkgw.buildQueue.onChange = pkgw.ledger.changed
+	if pkgw.ledger.restore(ctx) == 0 {
+		return
+	}
+	pkgw.restoring.Store(true)
+	synced := make([]k8sCache.InformerSynced, 0, len(pkgw.pkgInformer))
+	for _, informer := range pkgw.pkgInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	go func() {
+		// the watcher stopped, e.g. the leadership was lost, before the
+		// informers synced
+		if !k8sCache.WaitForCacheSync(ctx.Done(), synced...) {
+			return
+		}
+		pkgw.ledger.forgetRestored()
+		pkgw.restoring.Store(false)
+		pkgw.dispatchBuilds()
+	}()
+}
@@ -0,0 +1,208 @@
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+const testLedgerName = "build-queue"
+
+func testQueuedBuild(name string, enqueueTime time.Time) *pkgBuild {
+	b := &pkgBuild{
+		pkg: &fv1.Package{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+		},
+		enqueueTime: enqueueTime,
+	}
+	b.ctx, b.cancel = context.WithCancelCause(context.Background())
+	return b
+}
+
+func testLedgerConfigMap(t *testing.T, state queueLedgerState) *apiv1.ConfigMap {
+	t.Helper()
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Error encoding ledger: %v", err)
+	}
+	return &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testLedgerName},
+		Data:       map[string]string{queueLedgerKey: string(data)},
+	}
+}
+
+func TestBuildQueueOrdersByEnqueueTime(t *testing.T) {
+	now := time.Now()
+	q := newBuildQueue()
+	q.Push(testQueuedBuild("new", time.Time{}))
+	q.Push(testQueuedBuild("restored-2", now.Add(-time.Minute)))
+	q.Push(testQueuedBuild("restored-1", now.Add(-time.Hour)))
+	q.Push(testQueuedBuild("newer", time.Time{}))
+
+	for _, expected := range []string{"restored-1", "restored-2", "new", "newer"} {
+		b := q.Pop()
+		if b == nil || b.pkg.Name != expected {
+			t.Fatalf("Expected package %s, got %v", expected, b)
+		}
+	}
+}
+
+func TestQueueLedgerSaveAndRestore(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	ledger := newQueueLedger(loggerfactory.GetLogger(), k8sClient, testNamespace, testLedgerName)
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	q := newBuildQueue()
+	for i := 0; i < 3; i++ {
+		q.Push(testQueuedBuild(fmt.Sprintf("pkg-%d", i), start.Add(time.Duration(i)*time.Second)))
+	}
+	canceled := testQueuedBuild("canceled", start)
+	canceled.cancel(errCanceledByRequest)
+	q.Push(canceled)
+	ledger.save(context.Background(), q, false)
+
+	restored := newQueueLedger(loggerfactory.GetLogger(), k8sClient, testNamespace, testLedgerName)
+	if n := restored.restore(context.Background()); n != 3 {
+		t.Fatalf("Expected 3 restored builds, got %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		expected := start.Add(time.Duration(i) * time.Second)
+		if got := restored.enqueueTime(testNamespace, fmt.Sprintf("pkg-%d", i)); !got.Equal(expected) {
+			t.Errorf("Expected enqueue time %v of pkg-%d, got %v", expected, i, got)
+		}
+	}
+	if got := restored.enqueueTime(testNamespace, "canceled"); !got.IsZero() {
+		t.Errorf("Expected no enqueue time of the canceled build, got %v", got)
+	}
+	// enqueue times are handed out once
+	if got := restored.enqueueTime(testNamespace, "pkg-0"); !got.IsZero() {
+		t.Errorf("Expected the enqueue time to be handed out once, got %v", got)
+	}
+
+	// nothing is written after the last write on close
+	ledger.close(newBuildQueue())
+	q.Push(testQueuedBuild("after-close", time.Time{}))
+	ledger.save(context.Background(), q, false)
+	restored = newQueueLedger(loggerfactory.GetLogger(), k8sClient, testNamespace, testLedgerName)
+	if n := restored.restore(context.Background()); n != 0 {
+		t.Errorf("Expected the empty queue written on close, got %d builds", n)
+	}
+}
+
+func TestQueueLedgerDiscarded(t *testing.T) {
+	entries := []queueLedgerEntry{{Namespace: testNamespace, Name: testPkgName, EnqueueTime: time.Now()}}
+	for _, test := range []struct {
+		name   string
+		ledger *apiv1.ConfigMap
+	}{
+		{
+			name: "corrupt",
+			ledger: &apiv1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testLedgerName},
+				Data:       map[string]string{queueLedgerKey: `{"version": 1, "builds": [{"name": `},
+			},
+		},
+		{
+			name:   "unknown version",
+			ledger: testLedgerConfigMap(t, queueLedgerState{Version: queueLedgerVersion + 1, UpdateTime: time.Now(), Builds: entries}),
+		},
+		{
+			name:   "stale",
+			ledger: testLedgerConfigMap(t, queueLedgerState{Version: queueLedgerVersion, UpdateTime: time.Now().Add(-2 * maxQueueLedgerAge), Builds: entries}),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newQueueLedger(loggerfactory.GetLogger(), fake.NewSimpleClientset(test.ledger), testNamespace, testLedgerName)
+			if n := ledger.restore(context.Background()); n != 0 {
+				t.Errorf("Expected the ledger to be discarded, got %d builds", n)
+			}
+		})
+	}
+}
+
+func TestRestoredBuildsDispatchedInLedgerOrder(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	tpw.buildSlots = make(chan struct{}, 1)
+	restoredPkg := tpw.pkg.DeepCopy()
+	restoredPkg.ObjectMeta.Name = "restored-pkg"
+	_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(context.Background(), restoredPkg, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating package: %v", err)
+	}
+	ledgerCM := testLedgerConfigMap(t, queueLedgerState{
+		Version:    queueLedgerVersion,
+		UpdateTime: time.Now(),
+		Builds: []queueLedgerEntry{
+			{Namespace: testNamespace, Name: restoredPkg.ObjectMeta.Name, EnqueueTime: time.Now().Add(-time.Minute)},
+			{Namespace: testNamespace, Name: "deleted-pkg", EnqueueTime: time.Now().Add(-time.Minute)},
+		},
+	})
+	tpw.ledger = newQueueLedger(loggerfactory.GetLogger(), fake.NewSimpleClientset(ledgerCM), testNamespace, testLedgerName)
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tpw.restoreBuildQueue(ctx)
+
+	// the informers haven't synced, nothing is dispatched
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	tpw.buildWithCache(restoredPkg, fv1.BuildTriggerPackageCreated)
+	if n := tpw.buildQueue.Len(); n != 2 {
+		t.Fatalf("Expected 2 queued builds while restoring, got %d", n)
+	}
+
+	go pkgInformer.Run(ctx.Done())
+	deadline := time.Now().Add(5 * time.Second)
+	for tpw.buildQueue.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a build to be dispatched once the informers synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b := tpw.buildQueue.Builds()[0]; b.pkg.ObjectMeta.Name != testPkgName {
+		t.Errorf("Expected the restored build to be dispatched first, %s is still queued", b.pkg.ObjectMeta.Name)
+	}
+	if got := tpw.ledger.enqueueTime(testNamespace, "deleted-pkg"); !got.IsZero() {
+		t.Errorf("Expected entries of packages not pending anymore to be dropped, got %v", got)
+	}
+}
+
+func TestRestoredBuildsNotDispatchedWhenStoppedBeforeSync(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	ledgerCM := testLedgerConfigMap(t, queueLedgerState{
+		Version:    queueLedgerVersion,
+		UpdateTime: time.Now(),
+		Builds: []queueLedgerEntry{
+			{Namespace: testNamespace, Name: testPkgName, EnqueueTime: time.Now().Add(-time.Minute)},
+		},
+	})
+	tpw.ledger = newQueueLedger(loggerfactory.GetLogger(), fake.NewSimpleClientset(ledgerCM), testNamespace, testLedgerName)
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tpw.restoreBuildQueue(ctx)
+	tpw.buildWithCache(tpw.pkg, fv1.BuildTriggerPackageCreated)
+	// the informer never runs, so it never syncs
+	cancel()
+
+	time.Sleep(200 * time.Millisecond)
+	if !tpw.restoring.Load() {
+		t.Error("Expected the watcher to stay restoring")
+	}
+	if n := tpw.buildQueue.Len(); n != 1 {
+		t.Errorf("Expected the build to stay queued, got %d queued builds", n)
+	}
+}
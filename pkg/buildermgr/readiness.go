@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// readinessCheckTimeout bounds the request made to the API server by the
+// readiness check.
+const readinessCheckTimeout = 3 * time.Second
+
+// readinessChecks are the checks a builder manager replica passes before
+// it's ready: its informer caches are synced, the fission API is reachable
+// and, with leader election, the leader is known.
+type readinessChecks struct {
+	fissionClient versioned.Interface
+	// informers are the informers of the replica by resource, each by
+	// namespace.
+	informers map[string]map[string]k8sCache.SharedIndexInformer
+	// leaderElection tells the replicas elect the one running the builds.
+	leaderElection bool
+}
+
+func newReadinessChecks(fissionClient versioned.Interface) *readinessChecks {
+	return &readinessChecks{
+		fissionClient: fissionClient,
+		informers:     make(map[string]map[string]k8sCache.SharedIndexInformer),
+	}
+}
+
+// addInformers adds the informers of the resource, by namespace, to the
+// informers whose caches must be synced.
+func (c *readinessChecks) addInformers(resource string, informers map[string]k8sCache.SharedIndexInformer) {
+	c.informers[resource] = informers
+}
+
+// check runs the readiness checks, leader is the leader identity known
+// to the replica, nil if none.
+func (c *readinessChecks) check(ctx context.Context, leader *string) *Readiness {
+	readiness := &Readiness{Ready: true}
+	fail := func(check, msg string) {
+		readiness.Ready = false
+		if readiness.Failed == nil {
+			readiness.Failed = make(map[string]string)
+		}
+		readiness.Failed[check] = msg
+	}
+	if leader != nil {
+		readiness.Leader = *leader
+	}
+	if c == nil {
+		return readiness
+	}
+
+	if unsynced := c.unsyncedInformers(); len(unsynced) > 0 {
+		fail("informers", "caches not synced: "+strings.Join(unsynced, ", "))
+	}
+	if err := c.checkFissionClient(ctx); err != nil {
+		fail("fissionClient", err.Error())
+	}
+	if c.leaderElection && leader == nil {
+		fail("leaderElection", "no leader elected yet")
+	}
+	return readiness
+}
+
+// unsyncedInformers returns the resource/namespace of the informers whose
+// caches aren't synced yet.
+func (c *readinessChecks) unsyncedInformers() []string {
+	var unsynced []string
+	for resource, informers := range c.informers {
+		for ns, informer := range informers {
+			if !informer.HasSynced() {
+				unsynced = append(unsynced, fmt.Sprintf("%s/%s", resource, ns))
+			}
+		}
+	}
+	sort.Strings(unsynced)
+	return unsynced
+}
+
+// checkFissionClient makes sure the fission API is reachable with a cheap
+// list of environments.
+func (c *readinessChecks) checkFissionClient(ctx context.Context) error {
+	if c.fissionClient == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+	_, err := c.fissionClient.CoreV1().Environments(c.probeNamespace()).List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// probeNamespace returns the namespace the fission API is checked in, one
+// of the watched namespaces, the replica may not be allowed to list the
+// others.
+func (c *readinessChecks) probeNamespace() string {
+	var namespaces []string
+	for _, informers := range c.informers {
+		for ns := range informers {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return metav1.NamespaceDefault
+	}
+	sort.Strings(namespaces)
+	return namespaces[0]
+}
@@ -0,0 +1,75 @@
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sTesting "k8s.io/client-go/testing"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	genInformer "github.com/fission/fission/pkg/generated/informers/externalversions"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestReadyHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fissionClient := fClient.NewSimpleClientset(testEnvironment())
+	envInformer := genInformer.NewSharedInformerFactory(fissionClient, 0).Core().V1().Environments().Informer()
+
+	api := &builderMgrAPI{logger: loggerfactory.GetLogger(), readiness: newReadinessChecks(fissionClient)}
+	api.readiness.addInformers("environments", map[string]k8sCache.SharedIndexInformer{testNamespace: envInformer})
+	api.readiness.leaderElection = true
+	server := httptest.NewServer(api.GetHandler())
+	defer server.Close()
+	get := func(path string) (int, Readiness) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var readiness Readiness
+		err = json.NewDecoder(resp.Body).Decode(&readiness)
+		if err != nil {
+			t.Fatalf("Error decoding %s response: %v", path, err)
+		}
+		return resp.StatusCode, readiness
+	}
+
+	if status, _ := get("/healthz"); status != http.StatusOK {
+		t.Errorf("Expected alive builder manager, got status %d", status)
+	}
+
+	status, readiness := get("/readyz")
+	if status != http.StatusServiceUnavailable || readiness.Ready ||
+		len(readiness.Failed["informers"]) == 0 || len(readiness.Failed["leaderElection"]) == 0 {
+		t.Errorf("Expected unsynced informers and unknown leader to fail readiness, got status %d: %+v", status, readiness)
+	}
+	if _, ok := readiness.Failed["fissionClient"]; ok {
+		t.Errorf("Expected reachable fission API, got %+v", readiness.Failed)
+	}
+
+	go envInformer.Run(ctx.Done())
+	if !k8sCache.WaitForCacheSync(ctx.Done(), envInformer.HasSynced) {
+		t.Fatal("Error syncing environment informer")
+	}
+	api.setLeader("buildermgr-0")
+	status, readiness = get("/readyz")
+	if status != http.StatusOK || !readiness.Ready || len(readiness.Failed) != 0 || readiness.Leader != "buildermgr-0" {
+		t.Errorf("Expected ready builder manager, got status %d: %+v", status, readiness)
+	}
+
+	fissionClient.PrependReactor("list", "environments", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	status, readiness = get("/readyz")
+	if status != http.StatusServiceUnavailable || readiness.Failed["fissionClient"] != "connection refused" {
+		t.Errorf("Expected unreachable fission API to fail readiness, got status %d: %+v", status, readiness)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+)
+
+// sourceDeletionTimeout is the deadline of the deletion of the source
+// archive of a package after its build.
+const sourceDeletionTimeout = 30 * time.Second
+
+// deleteSourceRequested reports whether the source archive of the package
+// is to be deleted once it's built: as the package annotation says if it
+// has one, as the builder manager default says otherwise.
+func (pkgw *packageWatcher) deleteSourceRequested(pkg *fv1.Package) bool {
+	v, ok := pkg.ObjectMeta.Annotations[fv1.ANNOTATION_DELETE_SOURCE_AFTER_BUILD]
+	if !ok {
+		return pkgw.deleteSourceAfterBuild
+	}
+	deleteSource, err := strconv.ParseBool(v)
+	if err != nil {
+		pkgw.logger.Warn("invalid delete source after build annotation, using default",
+			zap.String("package_name", pkg.ObjectMeta.Name),
+			zap.String("namespace", pkg.ObjectMeta.Namespace),
+			zap.String("value", v),
+			zap.Bool("default", pkgw.deleteSourceAfterBuild))
+		return pkgw.deleteSourceAfterBuild
+	}
+	return deleteSource
+}
+
+// automaticRebuild returns why the package may be built again from its
+// source without anyone asking for it, empty if it won't be: failed builds
+// are retried, and environments may rebuild their packages when their
+// builder image changes. Lookup errors are taken as a rebuild being
+// possible.
+func (pkgw *packageWatcher) automaticRebuild(ctx context.Context, pkg *fv1.Package) string {
+	if pkgw.maxBuildAttempts(pkg) > 1 {
+		return "failed builds of the package are retried"
+	}
+	envRef := pkg.Spec.Environment
+	env, err := pkgw.fissionClient.CoreV1().Environments(envRef.Namespace).Get(ctx, envRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Sprintf("error looking up the package environment: %v", err)
+	}
+	if rebuildOnBuilderChange(env) {
+		return "the environment rebuilds its packages when its builder image changes"
+	}
+	return ""
+}
+
+// deleteSource deletes the source archive of the package from the storage
+// service once the package is built, if requested, and records it in the
+// package status. The sources of packages that may be rebuilt
+// automatically and those shared with other packages are kept, along with
+// archives at external URLs. Failures are logged, they never fail the
+// build.
+func (pkgw *packageWatcher) deleteSource(pkg *fv1.Package) {
+	src := pkg.Spec.Source
+	if src.Type != fv1.ArchiveTypeUrl || len(src.URL) == 0 || pkg.Status.SourceDeletedTime != nil ||
+		!pkgw.deleteSourceRequested(pkg) {
+		return
+	}
+	logger := pkgw.logger.With(zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace),
+		zap.String("url", src.URL))
+	ctx, cancel := context.WithTimeout(pkgw.buildsCtx, sourceDeletionTimeout)
+	defer cancel()
+	if reason := pkgw.automaticRebuild(ctx, pkg); len(reason) > 0 {
+		logger.Info("keeping source archive, the package may be rebuilt from it", zap.String("reason", reason))
+		return
+	}
+	referenced, err := pkgw.archiveReferencedByOthers(pkg, src.URL)
+	if err != nil {
+		logger.Warn("error looking up references to source archive, keeping it", zap.Error(err))
+		return
+	}
+	if referenced {
+		logger.Info("source archive referenced by another package, keeping it")
+		return
+	}
+	deleted, err := pkgw.deleteStorageArchive(ctx, pkg.ObjectMeta.Namespace, src.URL)
+	if err != nil {
+		logger.Warn("error deleting source archive of built package", zap.Error(err))
+		return
+	}
+	if !deleted {
+		return
+	}
+	logger.Info("deleted source archive of built package")
+	err = pkgw.markSourceDeleted(ctx, pkg)
+	if err != nil {
+		logger.Warn("error recording source archive deletion in package status", zap.Error(err))
+	}
+}
+
+// markSourceDeleted sets the source deletion time of the package status,
+// unless the package source changed since.
+func (pkgw *packageWatcher) markSourceDeleted(ctx context.Context, pkg *fv1.Package) error {
+	packages := pkgw.fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := packages.Get(ctx, pkg.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.ObjectMeta.UID != pkg.ObjectMeta.UID || latest.Spec.Source.URL != pkg.Spec.Source.URL {
+			return nil
+		}
+		latest.Status.SourceDeletedTime = &metav1.Time{Time: time.Now().UTC()}
+		_, err = crd.UpdatePackageStatus(ctx, pkgw.fissionClient, latest)
+		return err
+	})
+}
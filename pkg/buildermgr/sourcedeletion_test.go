@@ -0,0 +1,125 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+)
+
+func TestDeleteSourceAfterBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	store := &fakeArchiveStore{}
+	tpw.deps.logStore = store
+	packages := tpw.fissionClient.CoreV1().Packages(testNamespace)
+
+	// another package shares the source archive of the first one
+	shared := tpw.pkg.DeepCopy()
+	shared.ObjectMeta.Name = "shared"
+	shared.Spec.Source = storedArchive("shared-src")
+	_, err := packages.Create(ctx, shared, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating package: %v", err)
+	}
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+	go pkgInformer.Run(ctx.Done())
+	if !k8sCache.WaitForCacheSync(ctx.Done(), pkgInformer.HasSynced) {
+		t.Fatal("Package informer not synced")
+	}
+
+	// built sets the source archive and annotations of the built package
+	built := func(src fv1.Archive, annotations map[string]string) *fv1.Package {
+		t.Helper()
+		pkg, err := packages.Get(ctx, testPkgName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting package: %v", err)
+		}
+		pkg.Spec.Source = src
+		pkg.ObjectMeta.Annotations = annotations
+		pkg.Status.BuildStatus = fv1.BuildStatusSucceeded
+		pkg, err = packages.Update(ctx, pkg, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatalf("Error updating package: %v", err)
+		}
+		return pkg
+	}
+
+	tpw.deleteSource(built(storedArchive("src-1"), nil))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected source kept by default, got deleted %v", store.deleted)
+	}
+
+	tpw.deleteSourceAfterBuild = true
+	tpw.deleteSource(built(storedArchive("src-1"), map[string]string{fv1.ANNOTATION_DELETE_SOURCE_AFTER_BUILD: "false"}))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected source kept for package opted out, got deleted %v", store.deleted)
+	}
+
+	// retried builds need the source
+	tpw.deleteSource(built(storedArchive("src-1"), map[string]string{fv1.ANNOTATION_MAX_BUILD_RETRIES: "2"}))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected source kept for package whose builds are retried, got deleted %v", store.deleted)
+	}
+
+	tpw.deleteSource(built(storedArchive("shared-src"), nil))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected source shared with another package kept, got deleted %v", store.deleted)
+	}
+
+	// archives at external URLs aren't ours to delete
+	tpw.deleteSource(built(fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: "https://example.com/src.zip"}, nil))
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected external source kept, got deleted %v", store.deleted)
+	}
+
+	tpw.deleteSource(built(storedArchive("src-1"), nil))
+	if len(store.deleted) != 1 || store.deleted[0] != "src-1" {
+		t.Fatalf("Expected source of built package deleted, got deleted %v", store.deleted)
+	}
+	pkg, err := packages.Get(ctx, testPkgName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.SourceDeletedTime == nil || pkg.Spec.Source.URL != storedArchive("src-1").URL {
+		t.Errorf("Expected source deletion recorded in package status, got %v", pkg.Status.SourceDeletedTime)
+	}
+
+	// a deleted source isn't deleted again
+	tpw.deleteSource(pkg)
+	if len(store.deleted) != 1 {
+		t.Errorf("Expected deleted source left alone, got deleted %v", store.deleted)
+	}
+}
+
+func TestDeleteSourceKeptForEnvironmentRebuilds(t *testing.T) {
+	ctx := context.Background()
+	tpw := newTestPackageWatcher(t)
+	store := &fakeArchiveStore{}
+	tpw.deps.logStore = store
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{}
+	tpw.deleteSourceAfterBuild = true
+
+	env := tpw.env.DeepCopy()
+	env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_REBUILD_ON_BUILDER_CHANGE: "true"}
+	_, err := tpw.fissionClient.CoreV1().Environments(testNamespace).Update(ctx, env, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating environment: %v", err)
+	}
+	pkg := tpw.pkg.DeepCopy()
+	pkg.Spec.Source = storedArchive("src-1")
+	if reason := tpw.automaticRebuild(ctx, pkg); len(reason) == 0 {
+		t.Error("Expected package of environment rebuilding on builder changes to be rebuilt automatically")
+	}
+	tpw.deleteSource(pkg)
+	if len(store.deleted) != 0 {
+		t.Errorf("Expected source kept for environment rebuilds, got deleted %v", store.deleted)
+	}
+}
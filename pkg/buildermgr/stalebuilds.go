@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// defaultStaleBuildAge is the time after its last status update from
+// which a running package build is considered abandoned.
+const defaultStaleBuildAge = 10 * time.Minute
+
+// staleBuildAge returns the age of the last status update of a running
+// package build from which the build is abandoned. A build with a deadline
+// is abandoned once it's past its deadline, the build timeout of a healthy
+// builder manager would have failed it by then.
+func (pkgw *packageWatcher) staleBuildAge(pkg *fv1.Package) time.Duration {
+	age := pkgw.staleRunningBuildAge
+	if timeout := pkgw.buildTimeoutFor(pkg); timeout > age {
+		age = timeout
+	}
+	return age
+}
+
+// buildAbandoned reports whether the package is stuck in running state,
+// i.e. its build status wasn't updated for longer than the stale build age
+// or the build was running when the watcher became leader.
+func (pkgw *packageWatcher) buildAbandoned(pkg *fv1.Package, now time.Time) bool {
+	return pkg.Status.BuildStatus == fv1.BuildStatusRunning &&
+		(pkgw.abandonRunningBuilds || now.Sub(pkg.Status.LastUpdateTimestamp.Time) > pkgw.staleBuildAge(pkg))
+}
+
+// reconcileAbandonedBuilds marks the packages whose builds were abandoned by
+// a previous builder manager pending again, so that they're rebuilt. It runs
+// once on startup, or once leading, after the package informers synced, the
+// builds running by then are those of other builder managers. The status updates carry the
+// resource version seen by the informer, a package updated meanwhile by a
+// build still running elsewhere conflicts and is left alone.
+func (pkgw *packageWatcher) reconcileAbandonedBuilds(ctx context.Context) {
+	synced := make([]k8sCache.InformerSynced, 0, len(pkgw.pkgInformer))
+	for _, informer := range pkgw.pkgInformer {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !k8sCache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	now := time.Now()
+	for _, informer := range pkgw.pkgInformer {
+		for _, obj := range informer.GetStore().List() {
+			pkg, ok := obj.(*fv1.Package)
+			if !ok || !pkgw.buildAbandoned(pkg, now) {
+				continue
+			}
+			logger := pkgw.logger.With(
+				zap.String("package_name", pkg.ObjectMeta.Name),
+				zap.String("namespace", pkg.ObjectMeta.Namespace),
+				zap.Time("last_update", pkg.Status.LastUpdateTimestamp.Time))
+			_, err := setPendingBuildStatus(ctx, pkgw.fissionClient, pkg.DeepCopy(), fv1.BuildTriggerReconcile)
+			if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+				logger.Debug("package changed since the informer sync, leaving its build alone", zap.Error(err))
+				continue
+			}
+			if err != nil {
+				logger.Error("error setting pending state of package with abandoned build", zap.Error(err))
+				continue
+			}
+			logger.Info("rebuilding package whose build was abandoned in running state")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fInformers "github.com/fission/fission/pkg/generated/informers/externalversions"
+)
+
+func TestReconcileAbandonedBuilds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	tpw.staleRunningBuildAge = time.Minute
+	addPackage := func(name string, status fv1.BuildStatus, age time.Duration, buildTimeout int) {
+		t.Helper()
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = name
+		pkg.Spec.BuildTimeout = buildTimeout
+		pkg.Status.BuildStatus = status
+		pkg.Status.BuildPhase = fv1.BuildPhaseBuilding
+		pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now().Add(-age)}
+		_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(ctx, pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+	}
+	addPackage("abandoned", fv1.BuildStatusRunning, time.Hour, 0)
+	addPackage("running", fv1.BuildStatusRunning, time.Second, 0)
+	addPackage("within-timeout", fv1.BuildStatusRunning, time.Hour, int((2 * time.Hour).Seconds()))
+	addPackage("succeeded", fv1.BuildStatusSucceeded, time.Hour, 0)
+
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+	go pkgInformer.Run(ctx.Done())
+	tpw.reconcileAbandonedBuilds(ctx)
+
+	for name, expected := range map[string]fv1.BuildStatus{
+		"abandoned":      fv1.BuildStatusPending,
+		"running":        fv1.BuildStatusRunning,
+		"within-timeout": fv1.BuildStatusRunning,
+		"succeeded":      fv1.BuildStatusSucceeded,
+	} {
+		pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting package %s: %v", name, err)
+		}
+		if pkg.Status.BuildStatus != expected {
+			t.Errorf("Expected package %s in %s state, got %s", name, expected, pkg.Status.BuildStatus)
+		}
+		if expected == fv1.BuildStatusPending && len(pkg.Status.BuildPhase) != 0 {
+			t.Errorf("Expected the build phase of package %s to be reset, got %s", name, pkg.Status.BuildPhase)
+		}
+	}
+}
+
+func TestLeaderAbandonsRunningBuilds(t *testing.T) {
+	tpw := newTestPackageWatcher(t)
+	pkg := tpw.pkg.DeepCopy()
+	pkg.Status.BuildStatus = fv1.BuildStatusRunning
+	pkg.Status.LastUpdateTimestamp = metav1.Time{Time: time.Now()}
+	if tpw.buildAbandoned(pkg, time.Now()) {
+		t.Fatal("Expected a recently updated build not to be abandoned")
+	}
+	tpw.abandonRunningBuilds = true
+	if !tpw.buildAbandoned(pkg, time.Now()) {
+		t.Error("Expected the builds running when leading to be abandoned")
+	}
+}
+
+func TestReconcilePendingPackages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tpw := newTestPackageWatcher(t)
+	for name, status := range map[string]fv1.BuildStatus{
+		"no-status": "",
+		"succeeded": fv1.BuildStatusSucceeded,
+	} {
+		pkg := tpw.pkg.DeepCopy()
+		pkg.ObjectMeta.Name = name
+		pkg.Status = fv1.PackageStatus{BuildStatus: status}
+		_, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Create(ctx, pkg, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating package: %v", err)
+		}
+	}
+
+	pkgInformer := fInformers.NewSharedInformerFactory(tpw.fissionClient, 0).Core().V1().Packages().Informer()
+	tpw.pkgInformer = map[string]k8sCache.SharedIndexInformer{testNamespace: pkgInformer}
+	go pkgInformer.Run(ctx.Done())
+	tpw.reconcilePendingPackages(ctx)
+
+	pkg, err := tpw.fissionClient.CoreV1().Packages(testNamespace).Get(ctx, "no-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting package: %v", err)
+	}
+	if pkg.Status.BuildStatus != fv1.BuildStatusPending {
+		t.Errorf("Expected package without status marked pending, got %q", pkg.Status.BuildStatus)
+	}
+	builds := tpw.buildCache.Copy()
+	if _, ok := builds[tpw.buildCacheKey(tpw.pkg.ObjectMeta)]; !ok || len(builds) != 1 {
+		t.Fatalf("Expected the pending package enqueued, got builds %v", builds)
+	}
+
+	// the add event replayed by the informer doesn't build the package again
+	tpw.packageInformerHandler(ctx).OnAdd(tpw.pkg)
+	if builds := tpw.buildCache.Copy(); len(builds) != 1 {
+		t.Errorf("Expected one build of the pending package, got builds %v", builds)
+	}
+}
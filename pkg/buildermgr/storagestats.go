@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+)
+
+// The storage operations of the builds, the operation label of the storage
+// metrics.
+const (
+	storageOpFetchSource  = "fetch-source"
+	storageOpUploadDeploy = "upload-deploy"
+)
+
+// storageTransfer is an archive transfer between the builder pod and the
+// storage service.
+type storageTransfer struct {
+	bytes    int64
+	duration time.Duration
+	failed   bool
+}
+
+// observeSourceFetch records the source archive download of the fetch
+// request started at start. Literal and git sources aren't downloaded from
+// a storage service. The download duration reported by the fetcher is
+// preferred, older fetchers don't report it.
+func (r *packageBuildResult) observeSourceFetch(pkg *fv1.Package, start time.Time, resp *fetcher.FunctionFetchResponse, err error) {
+	if pkg.Spec.Source.Type != fv1.ArchiveTypeUrl || len(pkg.Spec.Source.Literal) > 0 {
+		return
+	}
+	transfer := storageTransfer{duration: time.Since(start), failed: err != nil}
+	if err == nil && resp != nil {
+		transfer.bytes = resp.DownloadedSize
+		if resp.DownloadDuration > 0 {
+			transfer.duration = resp.DownloadDuration
+		}
+	}
+	r.observeTransfer(pkg, storageOpFetchSource, transfer)
+}
+
+// observeDeploymentUpload records the deployment archive upload of the
+// upload request started at start. Unchanged archives aren't uploaded.
+func (r *packageBuildResult) observeDeploymentUpload(pkg *fv1.Package, start time.Time, resp *fetcher.ArchiveUploadResponse, err error) {
+	if err == nil && resp != nil && resp.Unchanged {
+		return
+	}
+	transfer := storageTransfer{duration: time.Since(start), failed: err != nil}
+	if err == nil && resp != nil {
+		transfer.bytes = resp.StoredSize
+		if transfer.bytes == 0 {
+			transfer.bytes = resp.OriginalSize
+		}
+		if resp.UploadDuration > 0 {
+			transfer.duration = resp.UploadDuration
+		}
+	}
+	r.observeTransfer(pkg, storageOpUploadDeploy, transfer)
+}
+
+// observeTransfer records the transfer of the storage operation in the
+// build result and its metrics.
+func (r *packageBuildResult) observeTransfer(pkg *fv1.Package, op string, transfer storageTransfer) {
+	r.transfers[op] = transfer
+	env := pkg.Spec.Environment
+	if transfer.failed {
+		buildStorageErrors.WithLabelValues(env.Name, env.Namespace, op).Inc()
+		return
+	}
+	buildStorageDuration.WithLabelValues(env.Name, env.Namespace, op).Observe(transfer.duration.Seconds())
+	buildStorageBytes.WithLabelValues(env.Name, env.Namespace, op).Add(float64(transfer.bytes))
+}
+
+// logStorageSummary logs the sizes and timings of the archive transfers and
+// of the build command of a build attempt, to tell slow builds from a slow
+// storage service without the metrics.
+func logStorageSummary(logger *zap.Logger, pkg *fv1.Package, built *packageBuildResult) {
+	if built == nil || len(built.transfers) == 0 {
+		return
+	}
+	fetch := built.transfers[storageOpFetchSource]
+	upload := built.transfers[storageOpUploadDeploy]
+	logger.Info("build storage summary",
+		zap.String("package_name", pkg.ObjectMeta.Name),
+		zap.String("namespace", pkg.ObjectMeta.Namespace),
+		zap.String("environment", pkg.Spec.Environment.Name),
+		zap.Int64("source_bytes", fetch.bytes),
+		zap.Float64("source_download_seconds", fetch.duration.Seconds()),
+		zap.Bool("source_download_failed", fetch.failed),
+		zap.Float64("build_seconds", built.durations[logPhaseBuild].Seconds()),
+		zap.Int64("deployment_bytes", upload.bytes),
+		zap.Float64("deployment_upload_seconds", upload.duration.Seconds()),
+		zap.Bool("deployment_upload_failed", upload.failed))
+}
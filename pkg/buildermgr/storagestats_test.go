@@ -0,0 +1,59 @@
+package buildermgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+)
+
+func TestObserveStorageTransfers(t *testing.T) {
+	pkg := testPackage()
+	storageBytes := func(op string) float64 {
+		return testutil.ToFloat64(buildStorageBytes.WithLabelValues(testEnvName, testNamespace, op))
+	}
+	storageErrors := func(op string) float64 {
+		return testutil.ToFloat64(buildStorageErrors.WithLabelValues(testEnvName, testNamespace, op))
+	}
+	fetched, uploaded := storageBytes(storageOpFetchSource), storageBytes(storageOpUploadDeploy)
+	uploadErrors := storageErrors(storageOpUploadDeploy)
+
+	result := newPackageBuildResult()
+	result.observeSourceFetch(pkg, time.Now(), &fetcher.FunctionFetchResponse{
+		DownloadedSize:   1024,
+		DownloadDuration: 2 * time.Second,
+	}, nil)
+	if transfer := result.transfers[storageOpFetchSource]; transfer.bytes != 1024 || transfer.duration != 2*time.Second {
+		t.Errorf("Expected download reported by the fetcher, got %+v", transfer)
+	}
+	if n := storageBytes(storageOpFetchSource) - fetched; n != 1024 {
+		t.Errorf("Expected 1024 downloaded bytes counted, got %v", n)
+	}
+
+	result.observeDeploymentUpload(pkg, time.Now(), nil, errors.New("storage service unavailable"))
+	if !result.transfers[storageOpUploadDeploy].failed || storageErrors(storageOpUploadDeploy)-uploadErrors != 1 {
+		t.Errorf("Expected failed upload counted, got %+v", result.transfers[storageOpUploadDeploy])
+	}
+	result.observeDeploymentUpload(pkg, time.Now(), &fetcher.ArchiveUploadResponse{OriginalSize: 4096, StoredSize: 512}, nil)
+	if n := storageBytes(storageOpUploadDeploy) - uploaded; n != 512 {
+		t.Errorf("Expected stored size of the upload counted, got %v", n)
+	}
+
+	// unchanged archives aren't uploaded
+	result = newPackageBuildResult()
+	result.observeDeploymentUpload(pkg, time.Now(), &fetcher.ArchiveUploadResponse{Unchanged: true}, nil)
+	if _, ok := result.transfers[storageOpUploadDeploy]; ok {
+		t.Errorf("Expected unchanged archive not counted as an upload, got %+v", result.transfers)
+	}
+
+	// literal sources aren't downloaded
+	pkg.Spec.Source = fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: []byte("zip")}
+	result.observeSourceFetch(pkg, time.Now(), &fetcher.FunctionFetchResponse{}, nil)
+	if _, ok := result.transfers[storageOpFetchSource]; ok {
+		t.Errorf("Expected literal source not counted as a download, got %+v", result.transfers)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/net/context/ctxhttp"
+
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+)
+
+// storageSvcMappingPath is the directory of the mounted ConfigMap mapping
+// namespaces to the storage service keeping the archives of their packages,
+// a file per namespace holding the storage service URL. Kubernetes updates
+// the mounted files, so the mapping is read on every build.
+var storageSvcMappingPath = "/etc/fission/storage-svc-mapping"
+
+// storageSvcProbeTimeout is the deadline of the startup probe of each
+// mapped storage service.
+const storageSvcProbeTimeout = 10 * time.Second
+
+// storageSvcMapping reads the namespace to storage service URL mapping.
+func storageSvcMapping() (map[string]string, error) {
+	entries, err := os.ReadDir(storageSvcMappingPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	mapping := make(map[string]string)
+	for _, entry := range entries {
+		// skip the data directory and links of the ConfigMap volume
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(storageSvcMappingPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mapping[entry.Name()] = strings.TrimSuffix(strings.TrimSpace(string(content)), "/")
+	}
+	return mapping, nil
+}
+
+// storageSvcURLFor returns the URL of the storage service keeping the
+// archives of the namespace packages, defaultURL unless the namespace is
+// mapped to another one.
+func storageSvcURLFor(logger *zap.Logger, namespace string, defaultURL string) string {
+	content, err := os.ReadFile(filepath.Join(storageSvcMappingPath, namespace))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("error reading storage service mapping, using default storage service",
+				zap.String("namespace", namespace),
+				zap.Error(err))
+		}
+		return defaultURL
+	}
+	storageSvcURL := strings.TrimSuffix(strings.TrimSpace(string(content)), "/")
+	if len(storageSvcURL) == 0 {
+		return defaultURL
+	}
+	return storageSvcURL
+}
+
+// resolveStorageSvc points the build at the storage service of the package
+// namespace. It returns the storage service URL if it's not the default one.
+func (e *buildExecution) resolveStorageSvc(namespace string) string {
+	e.storageSvcURL = storageSvcURLFor(e.logger, namespace, e.StorageSvcURL)
+	if e.storageSvcURL == e.StorageSvcURL {
+		return ""
+	}
+	return e.storageSvcURL
+}
+
+// storageSvcStores returns the log and source stores of the storage service
+// at the URL, the injected ones for the default storage service.
+func (d *BuildDeps) storageSvcStores(storageSvcURL string) (archiveStore, sourceArchiveStore) {
+	if strings.TrimSuffix(storageSvcURL, "/") == strings.TrimSuffix(d.StorageSvcURL, "/") {
+		return d.logStore, d.sourceStore
+	}
+	if d.storesFor != nil {
+		return d.storesFor(storageSvcURL)
+	}
+	client := storageSvcClient.MakeClient(storageSvcURL)
+	return client, client
+}
+
+// validateStorageSvcMapping checks that every storage service of the
+// namespace mapping is reachable.
+func validateStorageSvcMapping(ctx context.Context, logger *zap.Logger) error {
+	mapping, err := storageSvcMapping()
+	if err != nil {
+		return errors.Wrap(err, "error reading storage service mapping")
+	}
+	for namespace, storageSvcURL := range mapping {
+		err := probeStorageSvc(ctx, storageSvcURL)
+		if err != nil {
+			return errors.Wrapf(err, "storage service %q of namespace %q", storageSvcURL, namespace)
+		}
+		logger.Info("using storage service for namespace",
+			zap.String("namespace", namespace),
+			zap.String("storage_svc_url", storageSvcURL))
+	}
+	return nil
+}
+
+// probeStorageSvc checks the health of the storage service at the URL.
+func probeStorageSvc(ctx context.Context, storageSvcURL string) error {
+	u, err := url.Parse(storageSvcURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.New("not an http(s) URL")
+	}
+	ctx, cancel := context.WithTimeout(ctx, storageSvcProbeTimeout)
+	defer cancel()
+	resp, err := ctxhttp.Get(ctx, archiveCheckClient, storageSvcURL+"/healthz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("health check failed: HTTP error %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package buildermgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// withStorageSvcMapping mounts the namespace to storage service URL mapping
+// for the test.
+func withStorageSvcMapping(t *testing.T, mapping map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := storageSvcMappingPath
+	t.Cleanup(func() { storageSvcMappingPath = path })
+	storageSvcMappingPath = dir
+	// ConfigMap volumes keep their data in a hidden directory
+	err := os.Mkdir(filepath.Join(dir, "..data"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for namespace, storageSvcURL := range mapping {
+		err := os.WriteFile(filepath.Join(dir, namespace), []byte(storageSvcURL+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStorageSvcURLFor(t *testing.T) {
+	withStorageSvcMapping(t, map[string]string{"team-eu": "http://storagesvc.fission-eu/"})
+	logger := loggerfactory.GetLogger()
+	if url := storageSvcURLFor(logger, "team-eu", "http://storagesvc"); url != "http://storagesvc.fission-eu" {
+		t.Errorf("Expected the mapped storage service, got %q", url)
+	}
+	if url := storageSvcURLFor(logger, "default", "http://storagesvc"); url != "http://storagesvc" {
+		t.Errorf("Expected the default storage service, got %q", url)
+	}
+	mapping, err := storageSvcMapping()
+	if err != nil || len(mapping) != 1 || mapping["team-eu"] != "http://storagesvc.fission-eu" {
+		t.Errorf("Expected the team-eu mapping only, got %v: %v", mapping, err)
+	}
+}
+
+func TestValidateStorageSvcMapping(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	logger := loggerfactory.GetLogger()
+	for _, test := range []struct {
+		name    string
+		mapping map[string]string
+		valid   bool
+	}{
+		{name: "no mapping", valid: true},
+		{name: "healthy", mapping: map[string]string{"team-eu": healthy.URL}, valid: true},
+		{name: "unhealthy", mapping: map[string]string{"team-eu": healthy.URL, "team-us": unhealthy.URL}},
+		{name: "not a URL", mapping: map[string]string{"team-eu": "storagesvc.fission-eu"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			withStorageSvcMapping(t, test.mapping)
+			err := validateStorageSvcMapping(context.Background(), logger)
+			if valid := err == nil; valid != test.valid {
+				t.Errorf("Expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+// mappedArchiveStore is the archive store of a mapped storage service.
+type mappedArchiveStore struct {
+	fakeArchiveStore
+	url string
+}
+
+func (s *mappedArchiveStore) GetTargetUrl(id string, target string) string {
+	return s.url + "/v1/archive?id=" + id
+}
+
+func TestExecuteBuildUsesNamespaceStorageSvc(t *testing.T) {
+	const mappedURL = "http://storagesvc.fission-eu"
+	withStorageSvcMapping(t, map[string]string{testNamespace: mappedURL})
+
+	tb := newTestBuild(t)
+	mapped := &mappedArchiveStore{url: mappedURL}
+	tb.deps.storesFor = func(storageSvcURL string) (archiveStore, sourceArchiveStore) {
+		if storageSvcURL != mappedURL {
+			t.Errorf("Expected stores of the mapped storage service, got %s", storageSvcURL)
+		}
+		return mapped, storageSvcClient.MakeClient(storageSvcURL)
+	}
+	var uploadedTo string
+	tb.deps.buildPackage = func(ctx context.Context, logger *zap.Logger, fissionClient versioned.Interface, envBuilderNamespace string,
+		storageSvcUrl string, pkg *fv1.Package) (*fetcher.ArchiveUploadResponse, string, error) {
+		uploadedTo = storageSvcUrl
+		return &fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: storageSvcUrl + "/v1/archive?id=deploy"}, "build succeeded\n", nil
+	}
+	// the log of the last build is on the default storage service
+	pkg := tb.getPackage(t)
+	pkg.Status.BuildLogURL = "http://storagesvc/v1/archive?id=old-log"
+	pkg, err := tb.fissionClient.CoreV1().Packages(testNamespace).UpdateStatus(context.Background(), pkg, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error updating package: %v", err)
+	}
+
+	result, err := ExecuteBuild(context.Background(), tb.deps, pkg, BuildOptions{})
+	if err != nil || result.Status != fv1.BuildStatusSucceeded {
+		t.Fatalf("Expected succeeded build, got %s: %v", result.Status, err)
+	}
+	if uploadedTo != mappedURL {
+		t.Errorf("Expected the deployment archive uploaded to the namespace storage service %s, got %s", mappedURL, uploadedTo)
+	}
+	pkg = tb.getPackage(t)
+	if !strings.Contains(pkg.Status.BuildLog, "Using storage service "+mappedURL) {
+		t.Errorf("Expected the storage service recorded in the build logs, got %q", pkg.Status.BuildLog)
+	}
+	if mapped.uploads != 1 || tb.store.uploads != 0 || pkg.Status.BuildLogURL != mappedURL+"/v1/archive?id=archive-1" {
+		t.Errorf("Expected the build log uploaded to the namespace storage service only, got %d uploads there, %d to the default one: %q",
+			mapped.uploads, tb.store.uploads, pkg.Status.BuildLogURL)
+	}
+	if len(tb.store.deleted) != 1 || tb.store.deleted[0] != "old-log" || len(mapped.deleted) != 0 {
+		t.Errorf("Expected the old build log deleted from the default storage service, got %v and %v", tb.store.deleted, mapped.deleted)
+	}
+}
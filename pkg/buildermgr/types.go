@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"time"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// LiteralMigrationStatus is the outcome of the literal migration of a package.
+type LiteralMigrationStatus string
+
+const (
+	LiteralMigrationMigrated LiteralMigrationStatus = "migrated"
+	LiteralMigrationSkipped  LiteralMigrationStatus = "skipped"
+	LiteralMigrationFailed   LiteralMigrationStatus = "failed"
+)
+
+// FunctionUpdatePolicy tells how a function picks up a rebuild of its package.
+type FunctionUpdatePolicy string
+
+const (
+	// FunctionUpdateAutomatic functions get their package reference bumped
+	// by the builder manager, which redeploys them with the new build.
+	FunctionUpdateAutomatic FunctionUpdatePolicy = "automatic"
+	// FunctionUpdateManual functions reference the package without its
+	// namespace, they keep the old build until the function is updated.
+	FunctionUpdateManual FunctionUpdatePolicy = "manual"
+)
+
+// Trigger kinds reported in package impacts.
+const (
+	TriggerKindHTTP            = "HTTPTrigger"
+	TriggerKindTime            = "TimeTrigger"
+	TriggerKindMessageQueue    = "MessageQueueTrigger"
+	TriggerKindKubernetesWatch = "KubernetesWatchTrigger"
+)
+
+type (
+	// LiteralMigrationRequest asks the builder manager to move the literal
+	// archives of packages to the storage service.
+	LiteralMigrationRequest struct {
+		// Namespace of the packages to migrate, empty means all namespaces.
+		Namespace string `json:"namespace,omitempty"`
+		// Name restricts the migration to the package of that name in
+		// Namespace, empty means all packages.
+		Name string `json:"name,omitempty"`
+		// Threshold is the size in bytes from which literal archives are migrated.
+		Threshold int64 `json:"threshold"`
+		// DryRun reports the packages that would be migrated without changing them.
+		DryRun bool `json:"dryRun,omitempty"`
+		// QPS limits the requests to the API server. Optional; defaults to 5.
+		QPS float32 `json:"qps,omitempty"`
+	}
+
+	// LiteralMigrationResult is the literal migration result of a package.
+	LiteralMigrationResult struct {
+		Name      string                 `json:"name"`
+		Namespace string                 `json:"namespace"`
+		Status    LiteralMigrationStatus `json:"status"`
+		// Archives are the migrated archives, source and/or deployment.
+		Archives []string `json:"archives,omitempty"`
+		// Size is the total size in bytes of the migrated literals.
+		Size    int64  `json:"size,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	// LiteralMigrationResponse reports the literal migration of all packages.
+	LiteralMigrationResponse struct {
+		DryRun   bool                     `json:"dryRun,omitempty"`
+		Results  []LiteralMigrationResult `json:"results"`
+		Migrated int                      `json:"migrated"`
+		Skipped  int                      `json:"skipped"`
+		Failed   int                      `json:"failed"`
+	}
+
+	// PackageImpact lists the functions a rebuild of the package affects.
+	PackageImpact struct {
+		Name            string           `json:"name"`
+		Namespace       string           `json:"namespace"`
+		ResourceVersion string           `json:"resourceVersion"`
+		Functions       []FunctionImpact `json:"functions"`
+	}
+
+	// FunctionImpact is a function affected by a package rebuild.
+	FunctionImpact struct {
+		Name         string               `json:"name"`
+		Namespace    string               `json:"namespace"`
+		ExecutorType fv1.ExecutorType     `json:"executorType"`
+		UpdatePolicy FunctionUpdatePolicy `json:"updatePolicy"`
+		// PackageResourceVersion is the package resource version the
+		// function currently references.
+		PackageResourceVersion string          `json:"packageResourceVersion"`
+		Triggers               []TriggerImpact `json:"triggers"`
+	}
+
+	// TriggerImpact is a trigger of a function affected by a package rebuild.
+	TriggerImpact struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	}
+
+	// InFlightBuild is a package build tracked by the builder manager,
+	// queued, waiting for its retry or running.
+	InFlightBuild struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+		BuildID         string `json:"buildID,omitempty"`
+		// State is one of pending, running or waiting_for_builder.
+		State string `json:"state"`
+		// Phase is the build phase of a running build.
+		Phase   fv1.BuildPhase   `json:"phase,omitempty"`
+		Trigger fv1.BuildTrigger `json:"trigger,omitempty"`
+		Attempt int              `json:"attempt"`
+		// StartTime is when the first attempt started, nil for
+		// builds still queued.
+		StartTime *time.Time `json:"startTime,omitempty"`
+		// BuilderPod is the namespace/name of the builder pod the
+		// build waits for or found ready.
+		BuilderPod string `json:"builderPod,omitempty"`
+	}
+
+	// Readiness is the body of the readiness endpoint of a builder
+	// manager replica.
+	Readiness struct {
+		Ready bool `json:"ready"`
+		// Failed are the messages of the failed checks by check name.
+		Failed map[string]string `json:"failed,omitempty"`
+		// Leader is the identity of the replica leading the builds,
+		// with leader election.
+		Leader string `json:"leader,omitempty"`
+	}
+)
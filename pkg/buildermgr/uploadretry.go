@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fission/fission/pkg/fetcher"
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+	"github.com/fission/fission/pkg/utils"
+)
+
+const (
+	// defaultUploadRetries is the number of times the upload of a
+	// deployment archive is retried while the storage service is
+	// unavailable.
+	defaultUploadRetries = 3
+	// uploadRetryInitialInterval is the wait before the first upload
+	// retry, the waits double up to uploadRetryMaxInterval.
+	uploadRetryInitialInterval = time.Second
+	uploadRetryMaxInterval     = 15 * time.Second
+)
+
+type (
+	// uploadRetryPolicy tells how the uploads of the deployment archives
+	// are retried when the storage service is unavailable: it couldn't be
+	// reached or failed with a server error. Uploads it rejects aren't
+	// retried.
+	uploadRetryPolicy struct {
+		// Retries is the maximum number of retries, 0 doesn't retry.
+		Retries         int
+		InitialInterval time.Duration
+		MaxInterval     time.Duration
+	}
+
+	// uploadRetryPolicyKey is the context key of the upload retry policy
+	// of the build.
+	uploadRetryPolicyKey struct{}
+)
+
+// defaultUploadRetryPolicy returns the upload retry policy retrying the
+// given number of times.
+func defaultUploadRetryPolicy(retries int) uploadRetryPolicy {
+	return uploadRetryPolicy{
+		Retries:         retries,
+		InitialInterval: uploadRetryInitialInterval,
+		MaxInterval:     uploadRetryMaxInterval,
+	}
+}
+
+// withUploadRetryPolicy returns the context retrying the deployment archive
+// uploads per the policy.
+func withUploadRetryPolicy(ctx context.Context, policy uploadRetryPolicy) context.Context {
+	return context.WithValue(ctx, uploadRetryPolicyKey{}, policy)
+}
+
+// contextUploadRetryPolicy returns the upload retry policy of the context,
+// uploads aren't retried without one.
+func contextUploadRetryPolicy(ctx context.Context) uploadRetryPolicy {
+	policy, _ := ctx.Value(uploadRetryPolicyKey{}).(uploadRetryPolicy)
+	return policy
+}
+
+// uploadDeploymentArchive asks the fetcher to upload the deployment archive
+// to the storage service, retrying with backoff per the upload retry policy
+// of the context while the storage service is unavailable. It returns the
+// number of upload attempts along with the outcome of the last one.
+func uploadDeploymentArchive(ctx context.Context, logger *zap.Logger, fetcherC *fetcherClient.Client,
+	uploadReq *fetcher.ArchiveUploadRequest) (*fetcher.ArchiveUploadResponse, int, error) {
+	policy := contextUploadRetryPolicy(ctx)
+	backoff, err := utils.NewBackOff(policy.InitialInterval, policy.MaxInterval, 2, float64(policy.Retries))
+	if err != nil {
+		return nil, 0, err
+	}
+	for attempts := 1; ; attempts++ {
+		uploadResp, err := fetcherC.Upload(ctx, uploadReq)
+		var failure *fetcherClient.UploadFailureError
+		if err == nil || !errors.As(err, &failure) || !failure.Retryable() || attempts > policy.Retries {
+			return uploadResp, attempts, err
+		}
+		wait := backoff.GetCurrentBackoffDuration()
+		if wait > policy.MaxInterval {
+			wait = policy.MaxInterval
+		}
+		backoff.GetNext()
+		logger.Warn("storage service unavailable, retrying deployment archive upload",
+			zap.Int("attempt", attempts), zap.Duration("retry_in", wait), zap.Error(err))
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, attempts, err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package buildermgr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fission/fission/pkg/fetcher"
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// testUploadFetcher serves the upload endpoint of a fetcher whose storage
+// service fails the first uploads with the given failure.
+func testUploadFetcher(t *testing.T, failures int, failure string) (*httptest.Server, *int) {
+	t.Helper()
+	uploads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads++
+		if uploads <= failures {
+			w.Header().Set(fetcher.HeaderUploadFailure, failure)
+			http.Error(w, "error uploading zip file: storage service failed", http.StatusInternalServerError)
+			return
+		}
+		err := json.NewEncoder(w).Encode(fetcher.ArchiveUploadResponse{ArchiveDownloadUrl: "http://storagesvc/deploy"})
+		if err != nil {
+			t.Errorf("Error encoding upload response: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &uploads
+}
+
+func TestUploadDeploymentArchiveRetries(t *testing.T) {
+	policy := uploadRetryPolicy{Retries: 3, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}
+	ctx := withUploadRetryPolicy(context.Background(), policy)
+	logger := loggerfactory.GetLogger()
+
+	t.Run("storage service unavailable", func(t *testing.T) {
+		srv, uploads := testUploadFetcher(t, 2, fetcher.UploadFailureUnavailable)
+		resp, attempts, err := uploadDeploymentArchive(ctx, logger, fetcherClient.MakeClient(logger, srv.URL), &fetcher.ArchiveUploadRequest{})
+		if err != nil || resp.ArchiveDownloadUrl != "http://storagesvc/deploy" {
+			t.Fatalf("Expected upload to succeed once the storage service is back, got %v: %v", resp, err)
+		}
+		if attempts != 3 || *uploads != 3 {
+			t.Errorf("Expected 3 upload attempts, got %d with %d uploads", attempts, *uploads)
+		}
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		srv, uploads := testUploadFetcher(t, 10, fetcher.UploadFailureUnavailable)
+		_, attempts, err := uploadDeploymentArchive(ctx, logger, fetcherClient.MakeClient(logger, srv.URL), &fetcher.ArchiveUploadRequest{})
+		var failure *fetcherClient.UploadFailureError
+		if !errors.As(err, &failure) || !failure.Retryable() {
+			t.Fatalf("Expected storage service failure, got %v", err)
+		}
+		if attempts != policy.Retries+1 || *uploads != policy.Retries+1 {
+			t.Errorf("Expected %d upload attempts, got %d with %d uploads", policy.Retries+1, attempts, *uploads)
+		}
+	})
+
+	t.Run("upload rejected", func(t *testing.T) {
+		srv, uploads := testUploadFetcher(t, 10, fetcher.UploadFailureRejected)
+		_, attempts, err := uploadDeploymentArchive(ctx, logger, fetcherClient.MakeClient(logger, srv.URL), &fetcher.ArchiveUploadRequest{})
+		var failure *fetcherClient.UploadFailureError
+		if !errors.As(err, &failure) || failure.Retryable() {
+			t.Fatalf("Expected rejected upload, got %v", err)
+		}
+		if attempts != 1 || *uploads != 1 {
+			t.Errorf("Expected rejected upload not retried, got %d attempts with %d uploads", attempts, *uploads)
+		}
+	})
+
+	t.Run("no retry policy", func(t *testing.T) {
+		srv, uploads := testUploadFetcher(t, 1, fetcher.UploadFailureUnavailable)
+		_, attempts, err := uploadDeploymentArchive(context.Background(), logger, fetcherClient.MakeClient(logger, srv.URL), &fetcher.ArchiveUploadRequest{})
+		if err == nil || attempts != 1 || *uploads != 1 {
+			t.Errorf("Expected a single failed upload attempt, got %d attempts with %d uploads: %v", attempts, *uploads, err)
+		}
+	})
+}
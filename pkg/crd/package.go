@@ -0,0 +1,40 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"context"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+)
+
+// UpdatePackageStatus writes the package status through the status subresource.
+// Clusters where the package CRD predates the subresource don't serve it, the
+// status is then written with a regular update, which also writes the spec.
+func UpdatePackageStatus(ctx context.Context, fissionClient versioned.Interface, pkg *fv1.Package) (*fv1.Package, error) {
+	updated, err := fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace).UpdateStatus(ctx, pkg, metav1.UpdateOptions{})
+	if k8serrors.IsNotFound(err) {
+		// either the subresource or the package doesn't exist,
+		// the update tells them apart.
+		return fissionClient.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
+	}
+	return updated, err
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -16,6 +17,7 @@ import (
 
 	ferror "github.com/fission/fission/pkg/error"
 	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/utils/authtoken"
 )
 
 type (
@@ -24,8 +26,64 @@ type (
 		url        string
 		httpClient *http.Client
 	}
+
+	// FetchFailureError is a fetch failure of the fetcher pod itself,
+	// fetching the same archive from another pod may succeed.
+	FetchFailureError struct {
+		// Failure is the kind of the failure, e.g. fetcher.FetchFailureChecksumMismatch.
+		Failure string
+		// Pod is the namespace/name of the fetcher pod.
+		Pod string
+		Err error
+	}
+
+	// SourceFailureError is a failure of the package source itself, e.g.
+	// a missing git ref, fetching it from another pod fails too.
+	SourceFailureError struct {
+		// Failure is the kind of the failure, e.g. fetcher.SourceFailureGitRefNotFound.
+		Failure string
+		Err     error
+	}
+
+	// UploadFailureError is a failure of the storage service receiving
+	// the archive upload of the fetcher, the caller decides whether to
+	// retry it.
+	UploadFailureError struct {
+		// Failure is the kind of the failure, e.g. fetcher.UploadFailureUnavailable.
+		Failure string
+		Err     error
+	}
 )
 
+func (e *FetchFailureError) Error() string {
+	return fmt.Sprintf("fetcher pod %s: %v", e.Pod, e.Err)
+}
+
+func (e *FetchFailureError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SourceFailureError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Failure, e.Err)
+}
+
+func (e *SourceFailureError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UploadFailureError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Failure, e.Err)
+}
+
+func (e *UploadFailureError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the upload may succeed once retried.
+func (e *UploadFailureError) Retryable() bool {
+	return e.Failure == fetcher.UploadFailureUnavailable
+}
+
 func MakeClient(logger *zap.Logger, fetcherUrl string) *Client {
 	hc := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	return &Client{
@@ -35,6 +93,19 @@ func MakeClient(logger *zap.Logger, fetcherUrl string) *Client {
 	}
 }
 
+// WithAuthToken returns a copy of the client sending the token of the
+// builder pods with each request. An empty token is not sent.
+func (c *Client) WithAuthToken(token string) *Client {
+	if len(token) == 0 {
+		return c
+	}
+	return &Client{
+		logger:     c.logger,
+		url:        c.url,
+		httpClient: &http.Client{Transport: &authtoken.Transport{Token: token, Base: c.httpClient.Transport}},
+	}
+}
+
 func (c *Client) getSpecializeUrl() string {
 	return c.url + "/specialize"
 }
@@ -52,9 +123,22 @@ func (c *Client) Specialize(ctx context.Context, req *fetcher.FunctionSpecialize
 	return err
 }
 
-func (c *Client) Fetch(ctx context.Context, fr *fetcher.FunctionFetchRequest) error {
-	_, err := sendRequest(c.logger, ctx, c.httpClient, fr, c.getFetchUrl())
-	return err
+func (c *Client) Fetch(ctx context.Context, fr *fetcher.FunctionFetchRequest) (*fetcher.FunctionFetchResponse, error) {
+	body, err := sendRequest(c.logger, ctx, c.httpClient, fr, c.getFetchUrl())
+	if err != nil {
+		return nil, err
+	}
+
+	fetchResp := fetcher.FunctionFetchResponse{}
+	// older fetchers answer without body
+	if len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, &fetchResp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &fetchResp, nil
 }
 
 func (c *Client) Upload(ctx context.Context, fr *fetcher.ArchiveUploadRequest) (*fetcher.ArchiveUploadResponse, error) {
@@ -93,7 +177,24 @@ func sendRequest(logger *zap.Logger, ctx context.Context, httpClient *http.Clien
 				defer resp.Body.Close()
 				return body, err
 			}
+			failure := resp.Header.Get(fetcher.HeaderFetchFailure)
+			sourceFailure := resp.Header.Get(fetcher.HeaderSourceFailure)
+			uploadFailure := resp.Header.Get(fetcher.HeaderUploadFailure)
 			err = ferror.MakeErrorFromHTTP(resp)
+			if len(failure) > 0 {
+				// the pod would likely fail the same way again,
+				// the caller decides where to retry
+				return nil, &FetchFailureError{Failure: failure, Pod: resp.Header.Get(fetcher.HeaderFetcherPod), Err: err}
+			}
+			if len(sourceFailure) > 0 {
+				// any pod fails the same way
+				return nil, &SourceFailureError{Failure: sourceFailure, Err: err}
+			}
+			if len(uploadFailure) > 0 {
+				// the storage service failed, the caller retries
+				// per its own policy
+				return nil, &UploadFailureError{Failure: uploadFailure, Err: err}
+			}
 		}
 
 		// skip retry and return directly due to context deadline exceeded
@@ -104,9 +205,20 @@ func sendRequest(logger *zap.Logger, ctx context.Context, httpClient *http.Clien
 			return nil, err
 		}
 
+		// the caller gave up, retrying is pointless
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
 		if i < maxRetries-1 {
-			time.Sleep(50 * time.Duration(2*i) * time.Millisecond)
 			logger.Error("error specializing/fetching/uploading package, retrying", zap.Error(err), zap.String("url", url))
+			t := time.NewTimer(50 * time.Duration(2*i) * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-t.C:
+			}
 			continue
 		}
 	}
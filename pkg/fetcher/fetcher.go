@@ -17,17 +17,21 @@ limitations under the License.
 package fetcher
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/mholt/archiver/v3"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
@@ -54,6 +58,15 @@ import (
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
 
+const (
+	// maxUnarchivedSize is the maximum size of the files unzipped
+	// from an archive.
+	maxUnarchivedSize = 8 << 30
+	// maxSymlinkTargetSize is the maximum length of the target of
+	// a symlink in an archive.
+	maxSymlinkTargetSize = 4096
+)
+
 type (
 	Fetcher struct {
 		logger           *zap.Logger
@@ -127,6 +140,9 @@ func MakeFetcher(logger *zap.Logger, sharedVolumePath string, sharedSecretPath s
 }
 
 func verifyChecksum(fileChecksum, checksum *fv1.Checksum) error {
+	if fileChecksum == nil || checksum == nil {
+		return ferror.MakeError(ferror.ErrorInvalidArgument, "Missing checksum")
+	}
 	if checksum.Type != fv1.ChecksumTypeSHA256 {
 		return ferror.MakeError(ferror.ErrorInvalidArgument, "Unsupported checksum type")
 	}
@@ -193,9 +209,19 @@ func (fetcher *Fetcher) FetchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code, err := fetcher.Fetch(ctx, pkg, req)
+	resp, code, err := fetcher.Fetch(ctx, pkg, req)
 	if err != nil {
 		logger.Error("error fetching", zap.Error(err))
+		var fe ferror.Error
+		var sf *sourceFailureError
+		if errors.As(err, &fe) && fe.Code == ferror.ErrorChecksumFail {
+			// the archive may be fine, tell the caller this pod fetched it wrong
+			w.Header().Set(HeaderFetchFailure, FetchFailureChecksumMismatch)
+			w.Header().Set(HeaderFetcherPod, fetcher.Info.Namespace+"/"+fetcher.Info.Name)
+		} else if errors.As(err, &sf) {
+			// fetching the source again is pointless
+			w.Header().Set(HeaderSourceFailure, sf.failure)
+		}
 		http.Error(w, err.Error(), code)
 		return
 	}
@@ -208,9 +234,21 @@ func (fetcher *Fetcher) FetchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body, err = json.Marshal(resp)
+	if err != nil {
+		logger.Error("error encoding fetch response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	logger.Info("completed fetch request")
 	// all done
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	if err != nil {
+		logger.Error("error writing response", zap.Error(err))
+	}
 }
 
 func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request) {
@@ -249,15 +287,30 @@ func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request
 }
 
 // Fetch takes FetchRequest and makes the fetch call
-// It returns the HTTP code and error if any
-func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req FunctionFetchRequest) (int, error) {
+// It returns the fetch response, the HTTP code and error if any
+func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req FunctionFetchRequest) (*FunctionFetchResponse, int, error) {
 	logger := otelUtils.LoggerWithTraceID(ctx, fetcher.logger)
 
 	// check that the requested filename is not an empty string and error out if so
 	if len(req.Filename) == 0 {
 		e := "fetch request received for an empty file name"
 		logger.Error(e, zap.Any("request", req))
-		return http.StatusBadRequest, errors.New(fmt.Sprintf("%s, request: %v", e, req))
+		return nil, http.StatusBadRequest, errors.New(fmt.Sprintf("%s, request: %v", e, req))
+	}
+
+	resp := &FunctionFetchResponse{}
+	tmpFile := req.Filename + ".tmp"
+	tmpPath := filepath.Join(fetcher.sharedVolumePath, tmpFile)
+
+	if req.CleanWorkspace {
+		// don't trust what a previous fetch left behind
+		for _, p := range []string{filepath.Join(fetcher.sharedVolumePath, req.Filename), tmpPath} {
+			if err := os.RemoveAll(p); err != nil {
+				e := "failed to clean workspace"
+				logger.Error(e, zap.Error(err), zap.String("location", p))
+				return nil, http.StatusInternalServerError, errors.Wrapf(err, "%s %s", e, p)
+			}
+		}
 	}
 
 	// verify first if the file already exists.
@@ -266,12 +319,9 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 			zap.String("requested_file", req.Filename),
 			zap.String("shared_volume_path", fetcher.sharedVolumePath))
 		otelUtils.SpanTrackEvent(ctx, "packageAlreadyExists", otelUtils.GetAttributesForPackage(pkg)...)
-		return http.StatusOK, nil
+		return resp, http.StatusOK, nil
 	}
 
-	tmpFile := req.Filename + ".tmp"
-	tmpPath := filepath.Join(fetcher.sharedVolumePath, tmpFile)
-
 	if req.FetchType == fv1.FETCH_URL {
 		otelUtils.SpanTrackEvent(ctx, "fetch_url", otelUtils.MapToAttributes(map[string]string{
 			"package-name":      pkg.Name,
@@ -283,11 +333,13 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 		if err != nil {
 			e := "failed to download url"
 			logger.Error(e, zap.Error(err), zap.String("url", req.Url))
-			return http.StatusBadRequest, errors.Wrapf(err, "%s: %s", e, req.Url)
+			return nil, http.StatusBadRequest, errors.Wrapf(err, "%s: %s", e, req.Url)
 		}
 	} else {
 		var archive *fv1.Archive
-		if req.FetchType == fv1.FETCH_SOURCE {
+		if req.FetchType == fv1.FETCH_SOURCE && len(req.SourceLiteral) > 0 {
+			archive = &fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: req.SourceLiteral}
+		} else if req.FetchType == fv1.FETCH_SOURCE {
 			archive = &pkg.Spec.Source
 		} else if req.FetchType == fv1.FETCH_DEPLOYMENT {
 			// sometimes, the user may invoke the function even before the source code is built into a deploy pkg.
@@ -300,21 +352,28 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 					zap.String("package_name", pkg.ObjectMeta.Name),
 					zap.String("package_namespace", pkg.ObjectMeta.Namespace),
 					zap.Any("package_build_status", pkg.Status.BuildStatus))
-				return http.StatusInternalServerError, errors.New(fmt.Sprintf("%s: pkg %s.%s has a status of %s", e, pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace, pkg.Status.BuildStatus))
+				return nil, http.StatusInternalServerError, errors.New(fmt.Sprintf("%s: pkg %s.%s has a status of %s", e, pkg.ObjectMeta.Name, pkg.ObjectMeta.Namespace, pkg.Status.BuildStatus))
 			}
 			archive = &pkg.Spec.Deployment
 		} else {
-			return http.StatusBadRequest, fmt.Errorf("unknown fetch type: %v", req.FetchType)
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown fetch type: %v", req.FetchType)
 		}
 
-		// get package data as literal or by url
-		if len(archive.Literal) > 0 {
+		// get package data as literal, by url or from git
+		if archive.Type == fv1.ArchiveTypeGit {
+			otelUtils.SpanTrackEvent(ctx, "cloneGitSource", otelUtils.GetAttributesForPackage(pkg)...)
+			commit, code, err := fetcher.cloneGitSource(ctx, logger, pkg, archive.Git, tmpPath)
+			if err != nil {
+				return nil, code, err
+			}
+			resp.SourceCommit = commit
+		} else if len(archive.Literal) > 0 {
 			// write pkg.Literal into tmpPath
 			err := os.WriteFile(tmpPath, archive.Literal, 0600)
 			if err != nil {
 				e := "failed to write file"
 				logger.Error(e, zap.Error(err), zap.String("location", tmpPath))
-				return http.StatusInternalServerError, errors.Wrapf(err, "%s %s", e, tmpPath)
+				return nil, http.StatusInternalServerError, errors.Wrapf(err, "%s %s", e, tmpPath)
 			}
 			otelUtils.SpanTrackEvent(ctx, "archiveLiteral", otelUtils.GetAttributesForPackage(pkg)...)
 		} else {
@@ -324,11 +383,21 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 				"package-namespace": pkg.Namespace,
 				"archive-url":       archive.URL,
 			})...)
-			err := utils.DownloadUrl(ctx, fetcher.httpClient, archive.URL, tmpPath)
+			creds, code, err := fetcher.archiveAuth(ctx, pkg.ObjectMeta.Namespace, archive)
+			if err != nil {
+				logger.Error("error getting archive credentials", zap.Error(err))
+				return nil, code, err
+			}
+			start := time.Now()
+			code, err = fetcher.downloadArchive(ctx, archive, creds, tmpPath)
 			if err != nil {
 				e := "failed to download url"
-				logger.Error(e, zap.Error(err), zap.String("url", req.Url))
-				return http.StatusBadRequest, errors.Wrapf(err, "%s %s", e, req.Url)
+				logger.Error(e, zap.Error(err), zap.String("url", archive.URL))
+				return nil, code, errors.Wrapf(err, "%s %s", e, archive.URL)
+			}
+			resp.DownloadDuration = time.Since(start)
+			if info, err := os.Stat(tmpPath); err == nil {
+				resp.DownloadedSize = info.Size()
 			}
 
 			// check file integrity only if checksum is not empty.
@@ -337,13 +406,17 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 				if err != nil {
 					e := "failed to get checksum"
 					logger.Error(e, zap.Error(err))
-					return http.StatusBadRequest, errors.Wrap(err, e)
+					return nil, http.StatusBadRequest, errors.Wrap(err, e)
 				}
 				err = verifyChecksum(checksum, &archive.Checksum)
 				if err != nil {
 					e := "failed to verify checksum"
 					logger.Error(e, zap.Error(err))
-					return http.StatusBadRequest, errors.Wrap(err, e)
+					// a corrupt download must not be reused by a later fetch
+					if rmErr := os.Remove(tmpPath); rmErr != nil {
+						logger.Warn("error removing corrupt download", zap.Error(rmErr), zap.String("location", tmpPath))
+					}
+					return nil, http.StatusBadRequest, errors.Wrap(err, e)
 				}
 			}
 		}
@@ -357,7 +430,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 			logger.Error("error generating uuid",
 				zap.Error(err),
 				zap.String("archive_location", tmpPath))
-			return http.StatusInternalServerError, err
+			return nil, http.StatusInternalServerError, err
 		}
 
 		tmpUnarchivePath := filepath.Join(fetcher.sharedVolumePath, id.String())
@@ -367,7 +440,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 				zap.Error(err),
 				zap.String("archive_location", tmpPath),
 				zap.String("target_location", tmpUnarchivePath))
-			return http.StatusInternalServerError, err
+			return nil, http.StatusInternalServerError, err
 		}
 
 		tmpPath = tmpUnarchivePath
@@ -381,12 +454,12 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 			zap.Error(err),
 			zap.String("original_path", tmpPath),
 			zap.String("rename_path", renamePath))
-		return http.StatusInternalServerError, err
+		return nil, http.StatusInternalServerError, err
 	}
 
 	otelUtils.SpanTrackEvent(ctx, "packageFetched", otelUtils.GetAttributesForPackage(pkg)...)
 	logger.Info("successfully placed", zap.String("location", renamePath))
-	return http.StatusOK, nil
+	return resp, http.StatusOK, nil
 }
 
 // FetchSecretsAndCfgMaps fetches secrets and configmaps specified by user
@@ -534,6 +607,15 @@ func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	dstFilepath := filepath.Join(fetcher.sharedVolumePath, zipFilename)
 
 	if req.ArchivePackage {
+		if req.Reproducible {
+			err = resetModTimes(srcFilepath)
+			if err != nil {
+				e := "error resetting modification times of the package"
+				logger.Error(e, zap.Error(err), zap.String("source", srcFilepath))
+				http.Error(w, fmt.Sprintf("%s: %v", e, err), http.StatusInternalServerError)
+				return
+			}
+		}
 		err = fetcher.archive(srcFilepath, dstFilepath)
 		if err != nil {
 			e := "error archiving zip file"
@@ -551,17 +633,80 @@ func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if req.MaxSize > 0 {
+		fi, err := os.Stat(dstFilepath)
+		if err != nil {
+			e := "error getting archive size"
+			logger.Error(e, zap.Error(err), zap.String("file", dstFilepath))
+			http.Error(w, fmt.Sprintf("%s: %v", e, err), http.StatusInternalServerError)
+			return
+		}
+		if fi.Size() > req.MaxSize {
+			e := fmt.Sprintf("deployment archive exceeds limit (%s > %s)",
+				humanize.IBytes(uint64(fi.Size())), humanize.IBytes(uint64(req.MaxSize)))
+			logger.Error(e, zap.String("file", dstFilepath), zap.Int64("size", fi.Size()), zap.Int64("limit", req.MaxSize))
+			w.Header().Set(HeaderUploadFailure, UploadFailureTooLarge)
+			http.Error(w, e, http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	if req.SkipIfChecksum != nil {
+		sum, err := utils.GetFileChecksum(dstFilepath)
+		if err != nil {
+			e := "error calculating checksum of zip file"
+			logger.Error(e, zap.Error(err), zap.String("file", dstFilepath))
+			http.Error(w, fmt.Sprintf("%s: %v", e, err), http.StatusInternalServerError)
+			return
+		}
+		if *sum == *req.SkipIfChecksum {
+			logger.Info("archive unchanged, skipping upload", zap.String("checksum", sum.Sum))
+			writeUploadResponse(w, logger, &ArchiveUploadResponse{Checksum: *sum, Unchanged: true})
+			return
+		}
+	}
+
+	contentType := req.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+		if isZip, _ := utils.IsZip(dstFilepath); req.ArchivePackage || isZip {
+			contentType = "application/zip"
+		}
+	}
+
 	logger.Info("starting upload...")
 	ssClient := storageSvcClient.MakeClient(req.StorageSvcUrl)
 
-	fileID, err := ssClient.Upload(ctx, dstFilepath, nil)
+	start := time.Now()
+	result, err := ssClient.UploadWithOptions(ctx, dstFilepath, storageSvcClient.UploadOptions{
+		ContentType:      contentType,
+		Compression:      req.Compression,
+		CompressionLevel: req.CompressionLevel,
+		Target:           req.StorageTarget,
+	})
 	if err != nil {
 		e := "error uploading zip file"
 		logger.Error(e, zap.Error(err), zap.String("file", dstFilepath))
+		var uploadErr *storageSvcClient.UploadError
+		if storageSvcClient.IsUploadUnavailable(err) {
+			w.Header().Set(HeaderUploadFailure, UploadFailureUnavailable)
+		} else if errors.As(err, &uploadErr) {
+			w.Header().Set(HeaderUploadFailure, UploadFailureRejected)
+		}
 		http.Error(w, fmt.Sprintf("%s: %v", e, err), http.StatusInternalServerError)
 		return
 	}
 
+	uploadDuration := time.Since(start)
+
+	if result.Compressed {
+		logger.Info("compressed archive",
+			zap.Int64("original_size", result.OriginalSize),
+			zap.Int64("stored_size", result.StoredSize),
+			zap.Int64("saved_bytes", result.OriginalSize-result.StoredSize))
+	}
+
+	// checksum of the uncompressed archive, downloads are decompressed
 	sum, err := utils.GetFileChecksum(dstFilepath)
 	if err != nil {
 		e := "error calculating checksum of zip file"
@@ -571,10 +716,25 @@ func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := ArchiveUploadResponse{
-		ArchiveDownloadUrl: ssClient.GetUrl(fileID),
+		ArchiveDownloadUrl: ssClient.GetTargetUrl(result.ID, req.StorageTarget),
 		Checksum:           *sum,
+		OriginalSize:       result.OriginalSize,
+		StoredSize:         result.StoredSize,
+		Compressed:         result.Compressed,
+		UploadDuration:     uploadDuration,
+	}
+	if result.Compressed {
+		resp.StoredChecksum = &fv1.Checksum{
+			Type: fv1.ChecksumTypeSHA256,
+			Sum:  result.StoredSHA256,
+		}
 	}
 
+	logger.Info("completed upload request")
+	writeUploadResponse(w, logger, &resp)
+}
+
+func writeUploadResponse(w http.ResponseWriter, logger *zap.Logger, resp *ArchiveUploadResponse) {
 	rBody, err := json.Marshal(resp)
 	if err != nil {
 		e := "error encoding upload response"
@@ -583,17 +743,33 @@ func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Info("completed upload request")
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(rBody)
 	if err != nil {
-		e := "error writing response"
-		logger.Error(e, zap.Error(err))
-		http.Error(w, fmt.Sprintf("%s: %v", e, err), http.StatusInternalServerError)
+		logger.Error("error writing response", zap.Error(err))
 	}
 }
 
+// reproducibleModTime is the modification time of the files of reproducible
+// archives, the earliest time zip archives can hold.
+var reproducibleModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// resetModTimes sets the modification time of the files and directories at
+// src to reproducibleModTime. Symlinks are left alone, their targets may be
+// out of src.
+func resetModTimes(src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chtimes(path, reproducibleModTime, reproducibleModTime)
+	})
+}
+
 func (fetcher *Fetcher) rename(src string, dst string) error {
 	err := os.Rename(src, dst)
 	if err != nil {
@@ -605,6 +781,19 @@ func (fetcher *Fetcher) rename(src string, dst string) error {
 // archive zips the contents of directory at src into a new zip file
 // at dst (note that the contents are zipped, not the directory itself).
 func (fetcher *Fetcher) archive(src string, dst string) error {
+	return Archive(src, dst)
+}
+
+// unarchive is a function that unzips a zip file to destination
+func (fetcher *Fetcher) unarchive(src string, dst string) error {
+	return Unarchive(src, dst)
+}
+
+// Archive zips the contents of directory at src into a new zip file
+// at dst (note that the contents are zipped, not the directory itself).
+// It's shared by the fetcher and the CLI local build so that both produce
+// the same deployment archive layout.
+func Archive(src string, dst string) error {
 	var files []string
 	target, err := os.Stat(src)
 	if err != nil {
@@ -622,15 +811,73 @@ func (fetcher *Fetcher) archive(src string, dst string) error {
 	return archiver.DefaultZip.Archive(files, dst)
 }
 
-// unarchive is a function that unzips a zip file to destination
-func (fetcher *Fetcher) unarchive(src string, dst string) error {
-	err := archiver.DefaultZip.Unarchive(src, dst)
+// Unarchive unzips the zip file at src to destination directory dst.
+// Archives expanding beyond maxUnarchivedSize, e.g. zip bombs, and archives
+// with symlinks pointing out of dst are rejected before anything is written.
+func Unarchive(src string, dst string) error {
+	return unarchiveWithLimit(src, dst, maxUnarchivedSize)
+}
+
+func unarchiveWithLimit(src string, dst string, maxSize int64) error {
+	err := checkArchive(src, maxSize)
+	if err != nil {
+		return fmt.Errorf("failed to unzip file: %w", err)
+	}
+	err = archiver.DefaultZip.Unarchive(src, dst)
 	if err != nil {
 		return fmt.Errorf("failed to unzip file: %w", err)
 	}
 	return nil
 }
 
+// checkArchive checks the uncompressed size and the symlinks of the zip
+// file at src. The sizes recorded in the archive can be trusted, reading
+// more data than recorded for a file fails.
+func checkArchive(src string, maxSize int64) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var size uint64
+	for _, f := range r.File {
+		size += f.UncompressedSize64
+		if size > uint64(maxSize) || size < f.UncompressedSize64 {
+			return ferror.MakeError(ferror.ErrorSizeLimitExceeded,
+				fmt.Sprintf("archive expands to more than %d bytes", maxSize))
+		}
+		if f.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := readSymlinkTarget(f)
+		if err != nil {
+			return err
+		}
+		// the target is relative to the directory of the link in dst
+		link := filepath.Join(filepath.Dir(strings.TrimLeft(f.Name, "/")), target)
+		if filepath.IsAbs(target) || link == ".." || strings.HasPrefix(link, "../") {
+			return ferror.MakeError(ferror.ErrorInvalidArgument,
+				fmt.Sprintf("archive symlink %q points out of the archive", f.Name))
+		}
+	}
+	return nil
+}
+
+// readSymlinkTarget returns the target of a symlink in a zip archive.
+func readSymlinkTarget(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetSize))
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
 // getPkgInformation gets package information from k8s api server.
 func (fetcher *Fetcher) getPkgInformation(ctx context.Context, req FunctionFetchRequest) (pkg *fv1.Package, err error) {
 	logger := otelUtils.LoggerWithTraceID(ctx, fetcher.logger)
@@ -685,7 +932,7 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq FunctionFetc
 		return errors.Wrap(err, "error getting package information")
 	}
 
-	_, err = fetcher.Fetch(ctx, pkg, fetchReq)
+	_, _, err = fetcher.Fetch(ctx, pkg, fetchReq)
 	if err != nil {
 		return errors.Wrap(err, "error fetching deploy package")
 	}
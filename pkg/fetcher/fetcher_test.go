@@ -0,0 +1,252 @@
+package fetcher
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// testZip returns a zip archive of the files, given by name and content.
+// Names ending with "@" are stored as symlinks to their content.
+func testZip(t testing.TB, files ...string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		header := &zip.FileHeader{Name: files[i], Method: zip.Deflate}
+		if strings.HasSuffix(files[i], "@") {
+			header.Name = strings.TrimSuffix(files[i], "@")
+			header.SetMode(os.ModeSymlink | 0777)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(files[i+1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testZipBomb returns a zip archive of a small file claiming a huge size.
+func testZipBomb(t testing.TB) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "bomb",
+		Method:             zip.Store,
+		CompressedSize64:   4,
+		UncompressedSize64: 1 << 40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte("bomb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnarchiveRejectsUnsafeArchives(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		archive []byte
+	}{
+		{"zip bomb", testZipBomb(t)},
+		{"too large", testZip(t, "large", strings.Repeat("x", 2048))},
+		{"absolute symlink", testZip(t, "link@", "/etc/passwd")},
+		{"escaping symlink", testZip(t, "dir/link@", "../../etc")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "archive.zip")
+			err := os.WriteFile(src, test.archive, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(t.TempDir(), "dst")
+			err = unarchiveWithLimit(src, dst, 1024)
+			if err == nil {
+				t.Fatal("Expected the archive to be rejected")
+			}
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				t.Errorf("Expected nothing to be unzipped, got %v", err)
+			}
+		})
+	}
+}
+
+func FuzzUnarchive(f *testing.F) {
+	valid := testZip(f, "main.py", "print('hello')\n", "lib/util.py", "x = 1\n", "lib/link@", "util.py")
+	f.Add(valid)
+	f.Add(valid[:len(valid)/2])
+	corrupted := bytes.Clone(valid)
+	corrupted[len(corrupted)/3] ^= 0xff
+	f.Add(corrupted)
+	f.Add(testZipBomb(f))
+	f.Add(testZip(f, "../escape", "x"))
+	f.Add(testZip(f, "link@", "../../etc"))
+	f.Add([]byte{})
+
+	const maxSize = 1 << 20
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		src := filepath.Join(t.TempDir(), "archive.zip")
+		err := os.WriteFile(src, archive, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(t.TempDir(), "dst")
+		err = unarchiveWithLimit(src, dst, maxSize)
+		if err != nil {
+			return
+		}
+
+		var size int64
+		err = filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			if info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dst, filepath.Join(filepath.Dir(path), target))
+			if err != nil || filepath.IsAbs(target) || strings.HasPrefix(rel, "..") {
+				t.Errorf("Symlink %s points out of the archive: %s", path, target)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error walking unzipped files: %v", err)
+		}
+		if size > maxSize {
+			t.Errorf("Expected at most %d unzipped bytes, got %d", maxSize, size)
+		}
+	})
+}
+
+func FuzzVerifyChecksum(f *testing.F) {
+	sum, err := utils.GetChecksum(bytes.NewReader([]byte("package")))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add([]byte("package"), string(fv1.ChecksumTypeSHA256), sum.Sum)
+	f.Add([]byte("package"), string(fv1.ChecksumTypeSHA256), sum.Sum[:10])
+	f.Add([]byte("package"), string(fv1.ChecksumTypeSHA256), strings.ToUpper(sum.Sum))
+	f.Add([]byte("package"), "md5", sum.Sum)
+	f.Add([]byte{}, "", "")
+
+	f.Fuzz(func(t *testing.T, data []byte, checksumType string, checksum string) {
+		fileChecksum, err := utils.GetChecksum(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = verifyChecksum(fileChecksum, &fv1.Checksum{Type: fv1.ChecksumType(checksumType), Sum: checksum})
+		valid := checksumType == string(fv1.ChecksumTypeSHA256) && checksum == fileChecksum.Sum
+		if valid != (err == nil) {
+			t.Errorf("Expected checksum %s %q of %q to be valid: %v, got %v", checksumType, checksum, data, valid, err)
+		}
+		if verifyChecksum(nil, &fv1.Checksum{}) == nil || verifyChecksum(fileChecksum, nil) == nil {
+			t.Error("Expected missing checksums to fail verification")
+		}
+	})
+}
+
+func TestFetchSourceLiteral(t *testing.T) {
+	fetcher := &Fetcher{logger: zap.NewNop(), sharedVolumePath: t.TempDir()}
+	source := "def main():\n    return 'Hello, world!'\n"
+	pkg := &fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+		Spec: fv1.PackageSpec{
+			// the package changed since the build started
+			Source: fv1.Archive{Type: fv1.ArchiveTypeLiteral, Literal: testZip(t, "hello.py", "def main(): pass\n")},
+		},
+	}
+	req := FunctionFetchRequest{
+		FetchType:     fv1.FETCH_SOURCE,
+		Package:       pkg.ObjectMeta,
+		Filename:      "hello-src",
+		SourceLiteral: testZip(t, "hello.py", source, "requirements.txt", ""),
+	}
+	_, code, err := fetcher.Fetch(context.Background(), pkg, req)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("Error fetching literal source: %d %v", code, err)
+	}
+	content, err := os.ReadFile(filepath.Join(fetcher.sharedVolumePath, "hello-src", "hello.py"))
+	if err != nil {
+		t.Fatalf("Error reading fetched source: %v", err)
+	}
+	if string(content) != source {
+		t.Errorf("Expected the literal of the build request, got %q", content)
+	}
+}
+
+func TestResetModTimesMakesArchivesReproducible(t *testing.T) {
+	var sums []string
+	for i, mtime := range []time.Time{time.Now(), time.Now().Add(-time.Hour)} {
+		src := filepath.Join(t.TempDir(), "build")
+		err := os.MkdirAll(filepath.Join(src, "lib"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"main.py", filepath.Join("lib", "util.py")} {
+			path := filepath.Join(src, name)
+			err = os.WriteFile(path, []byte("print('hello')\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Chtimes(path, mtime, mtime)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = resetModTimes(src)
+		if err != nil {
+			t.Fatalf("Error resetting modification times: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(src, "lib", "util.py"))
+		if err != nil || !info.ModTime().Equal(reproducibleModTime) {
+			t.Fatalf("Expected reset modification time, got %v: %v", info.ModTime(), err)
+		}
+		dst := filepath.Join(t.TempDir(), "build.zip")
+		err = Archive(src, dst)
+		if err != nil {
+			t.Fatalf("Error archiving build %d: %v", i, err)
+		}
+		sum, err := utils.GetFileChecksum(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums = append(sums, sum.Sum)
+	}
+	if sums[0] != sums[1] {
+		t.Errorf("Expected identical archives of identical builds, got checksums %v", sums)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// sourceFailureError is a failure of the package source itself, e.g. a
+// missing git ref, fetching the source again fails the same way.
+type sourceFailureError struct {
+	failure string
+	err     error
+}
+
+func (e *sourceFailureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sourceFailureError) Unwrap() error {
+	return e.err
+}
+
+// cloneGitSource clones the git repository of the source at its ref into
+// dst, which is the subpath of the source in the checked out repository.
+// It returns the commit SHA the ref was resolved to.
+func (fetcher *Fetcher) cloneGitSource(ctx context.Context, logger *zap.Logger, pkg *fv1.Package,
+	src *fv1.GitSource, dst string) (string, int, error) {
+	if src == nil || len(src.URL) == 0 {
+		return "", http.StatusBadRequest, errors.New("git source archive without repository URL")
+	}
+
+	auth, code, err := fetcher.gitAuth(ctx, pkg.ObjectMeta.Namespace, src)
+	if err != nil {
+		return "", code, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	cloneDir := filepath.Join(fetcher.sharedVolumePath, id.String())
+	defer func() {
+		if err := os.RemoveAll(cloneDir); err != nil {
+			logger.Warn("error removing git clone", zap.Error(err), zap.String("location", cloneDir))
+		}
+	}()
+
+	repo, err := git.PlainCloneContext(ctx, cloneDir, false, &git.CloneOptions{
+		URL:        src.URL,
+		Auth:       auth,
+		NoCheckout: true,
+	})
+	if err != nil {
+		logger.Error("error cloning git repository", zap.Error(err), zap.String("url", src.URL))
+		return "", http.StatusBadGateway, &sourceFailureError{
+			failure: SourceFailureGitUnreachable,
+			err:     errors.Wrapf(err, "error cloning git repository %s", src.URL),
+		}
+	}
+
+	hash, err := resolveGitRef(repo, src.Ref)
+	if err != nil {
+		logger.Error("error resolving git ref", zap.Error(err), zap.String("url", src.URL), zap.String("ref", src.Ref))
+		return "", http.StatusNotFound, &sourceFailureError{
+			failure: SourceFailureGitRefNotFound,
+			err:     errors.Wrapf(err, "git ref %q not found in repository %s", src.Ref, src.URL),
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.Wrap(err, "error getting git worktree")
+	}
+	err = wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.Wrapf(err, "error checking out commit %s", hash)
+	}
+	// the builder gets the source only
+	err = os.RemoveAll(filepath.Join(cloneDir, git.GitDirName))
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.Wrap(err, "error removing git directory")
+	}
+
+	srcDir := cloneDir
+	if len(src.SubPath) > 0 {
+		srcDir = filepath.Join(cloneDir, filepath.Clean("/"+src.SubPath))
+		info, err := os.Stat(srcDir)
+		if err != nil || !info.IsDir() {
+			return "", http.StatusNotFound, &sourceFailureError{
+				failure: SourceFailureGitRefNotFound,
+				err:     fmt.Errorf("subpath %q is not a directory at commit %s of repository %s", src.SubPath, hash, src.URL),
+			}
+		}
+	}
+	err = fetcher.rename(srcDir, dst)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	logger.Info("cloned git source", zap.String("url", src.URL), zap.String("ref", src.Ref),
+		zap.String("commit", hash.String()))
+	return hash.String(), http.StatusOK, nil
+}
+
+// resolveGitRef resolves the branch, tag or commit SHA to a commit of the
+// cloned repository, an empty ref is the remote HEAD.
+func resolveGitRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil && !strings.HasPrefix(ref, "refs/") && !plumbing.IsHash(ref) {
+		// branches other than the default one are only remote branches
+		hash, err = repo.ResolveRevision(plumbing.Revision(git.DefaultRemoteName + "/" + ref))
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return *hash, nil
+}
+
+// gitAuth returns the credentials of the git source from its secret, nil
+// without secret. The secret has the password, or token, and optionally
+// the username.
+func (fetcher *Fetcher) gitAuth(ctx context.Context, namespace string, src *fv1.GitSource) (transport.AuthMethod, int, error) {
+	if len(src.Secret) == 0 {
+		return nil, http.StatusOK, nil
+	}
+	secret, err := fetcher.kubeClient.CoreV1().Secrets(namespace).Get(ctx, src.Secret, metav1.GetOptions{})
+	if err != nil {
+		code := http.StatusInternalServerError
+		if k8serr.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		return nil, code, errors.Wrapf(err, "error getting git secret %s/%s", namespace, src.Secret)
+	}
+	password := string(secret.Data["password"])
+	if len(password) == 0 {
+		return nil, http.StatusBadRequest, errors.Errorf("git secret %s/%s has no password", namespace, src.Secret)
+	}
+	username := string(secret.Data["username"])
+	if len(username) == 0 {
+		// token authentication takes any username
+		username = "git"
+	}
+	return &githttp.BasicAuth{Username: username, Password: password}, http.StatusOK, nil
+}
@@ -0,0 +1,78 @@
+package fetcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testGitCommit commits the file to the repository and returns the commit.
+func testGitCommit(t *testing.T, repo *git.Repository, dir, name, content string) plumbing.Hash {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wt.Add(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit("add "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "fission", Email: "fission@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestResolveGitRef(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := testGitCommit(t, repo, dir, "main.go", "package main")
+	_, err = repo.CreateTag("v1.0.0", first, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := testGitCommit(t, repo, dir, "go.mod", "module example")
+	// branches other than the default one are only remote ones in a clone
+	err = repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/feature", first))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, want := range map[string]plumbing.Hash{
+		"":               head,
+		"HEAD":           head,
+		"v1.0.0":         first,
+		"feature":        first,
+		first.String():   first,
+		"refs/tags/v1.0": plumbing.ZeroHash,
+		"missing":        plumbing.ZeroHash,
+	} {
+		got, err := resolveGitRef(repo, ref)
+		if want.IsZero() {
+			if err == nil {
+				t.Errorf("Expected ref %q not to resolve, got %s", ref, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error resolving ref %q: %v", ref, err)
+		} else if got != want {
+			t.Errorf("Expected ref %q to resolve to %s, got %s", ref, want, got)
+		}
+	}
+}
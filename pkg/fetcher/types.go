@@ -17,11 +17,57 @@ limitations under the License.
 package fetcher
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 )
 
+const (
+	// HeaderFetchFailure tells the kind of a fetch failure of the fetcher
+	// pod itself, as opposed to a failure of the archive or request.
+	HeaderFetchFailure = "X-Fission-Fetch-Failure"
+	// HeaderFetcherPod is the namespace/name of the fetcher pod reporting
+	// a fetch failure.
+	HeaderFetcherPod = "X-Fission-Fetcher-Pod"
+
+	// FetchFailureChecksumMismatch is the fetch failure of an archive
+	// whose downloaded content didn't match its checksum.
+	FetchFailureChecksumMismatch = "ChecksumMismatch"
+
+	// HeaderSourceFailure tells the kind of a failure of the package
+	// source itself, fetching it again the same way fails too.
+	HeaderSourceFailure = "X-Fission-Source-Failure"
+
+	// SourceFailureGitUnreachable is the failure of a git repository that
+	// couldn't be cloned, e.g. it doesn't exist or the credentials were
+	// rejected.
+	SourceFailureGitUnreachable = "GitRepositoryUnreachable"
+	// SourceFailureGitRefNotFound is the failure of a git ref missing
+	// from the repository.
+	SourceFailureGitRefNotFound = "GitRefNotFound"
+	// SourceFailureURLUnauthorized is the failure of a URL archive whose
+	// server denied the download, e.g. its auth secret credentials were
+	// rejected.
+	SourceFailureURLUnauthorized = "URLUnauthorized"
+
+	// HeaderUploadFailure tells the kind of a failure of the storage
+	// service receiving an archive upload.
+	HeaderUploadFailure = "X-Fission-Upload-Failure"
+
+	// UploadFailureUnavailable is the failure of a storage service that
+	// couldn't be reached or failed with a server error, the upload may
+	// succeed once retried.
+	UploadFailureUnavailable = "StorageUnavailable"
+	// UploadFailureRejected is the failure of an upload the storage
+	// service rejected, uploading it again fails too.
+	UploadFailureRejected = "UploadRejected"
+	// UploadFailureTooLarge is the failure of an archive exceeding the
+	// size limit of the upload request, it isn't uploaded.
+	UploadFailureTooLarge = "ArchiveTooLarge"
+)
+
 // Fission-Environment interface. The following types are not
 // exposed in the Fission API, but rather used by Fission to
 // talk to environments.
@@ -42,6 +88,27 @@ type (
 		Secrets       []fv1.SecretReference    `json:"secretList"`
 		ConfigMaps    []fv1.ConfigMapReference `json:"configMapList"`
 		KeepArchive   bool                     `json:"keeparchive"`
+		// CleanWorkspace removes what a previous fetch of the same file
+		// left in the shared volume instead of reusing it.
+		CleanWorkspace bool `json:"cleanWorkspace,omitempty"`
+		// SourceLiteral is the literal source archive of the build,
+		// it's used instead of the one of the package, which may
+		// have changed since the build started. Optional.
+		SourceLiteral []byte `json:"sourceLiteral,omitempty"`
+	}
+
+	// FunctionFetchResponse describes the fetched package. Fetchers
+	// predating it answer with an empty body.
+	FunctionFetchResponse struct {
+		// SourceCommit is the commit SHA a git source was
+		// resolved to.
+		SourceCommit string `json:"sourceCommit,omitempty"`
+		// DownloadedSize is the size in bytes of the archive downloaded
+		// from its URL, and DownloadDuration the time the download took.
+		// Both are zero for archives that weren't downloaded, e.g.
+		// literals and git sources.
+		DownloadedSize   int64         `json:"downloadedSize,omitempty"`
+		DownloadDuration time.Duration `json:"downloadDuration,omitempty"`
 	}
 
 	FunctionLoadRequest struct {
@@ -72,12 +139,53 @@ type (
 		Filename       string `json:"filename"`
 		StorageSvcUrl  string `json:"storagesvcurl"`
 		ArchivePackage bool   `json:"archivepackage"`
+
+		// Compression of the stored archive, one of auto, always
+		// or never. Optional; defaults to never.
+		Compression string `json:"compression,omitempty"`
+		// CompressionLevel is the gzip compression level. Optional.
+		CompressionLevel int `json:"compressionLevel,omitempty"`
+		// ContentType of the archive. Optional; detected from
+		// the archive if empty.
+		ContentType string `json:"contentType,omitempty"`
+		// StorageTarget is the name of the storage target receiving
+		// the archive. Optional; defaults to the default storage.
+		StorageTarget string `json:"storageTarget,omitempty"`
+		// MaxSize is the maximum size in bytes of the archive, larger
+		// archives aren't uploaded. Optional; 0 means no limit.
+		MaxSize int64 `json:"maxSize,omitempty"`
+		// Reproducible archives the package with fixed modification
+		// times, so that identical builds produce byte-identical
+		// archives. Optional.
+		Reproducible bool `json:"reproducible,omitempty"`
+		// SkipIfChecksum is the checksum of the current deployment
+		// archive, an archive with the same checksum isn't uploaded.
+		// Optional.
+		SkipIfChecksum *fv1.Checksum `json:"skipIfChecksum,omitempty"`
 	}
 
 	// ArchiveUploadResponse defines the download url of an archive and
-	// its checksum.
+	// its checksum. The checksum is always computed on the uncompressed
+	// archive, which is what downloads of the url return.
 	ArchiveUploadResponse struct {
 		ArchiveDownloadUrl string       `json:"archiveDownloadUrl"`
 		Checksum           fv1.Checksum `json:"checksum"`
+
+		// sizes of the archive before and after compression
+		OriginalSize int64 `json:"originalSize,omitempty"`
+		StoredSize   int64 `json:"storedSize,omitempty"`
+		Compressed   bool  `json:"compressed,omitempty"`
+
+		// StoredChecksum is the checksum of the compressed archive
+		// as stored, set for compressed archives only.
+		StoredChecksum *fv1.Checksum `json:"storedChecksum,omitempty"`
+
+		// Unchanged is set when the archive matches the SkipIfChecksum
+		// of the request and wasn't uploaded, the response has no url.
+		Unchanged bool `json:"unchanged,omitempty"`
+
+		// UploadDuration is the time the upload to the storage service
+		// took, the archiving of the package excluded.
+		UploadDuration time.Duration `json:"uploadDuration,omitempty"`
 	}
 )
@@ -0,0 +1,187 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// archiveRedirectLimit is the maximum number of redirects followed by the
+// downloads of URL archives.
+const archiveRedirectLimit = 5
+
+// archiveCredentials are the credentials of a URL archive download, read
+// from its auth secret. They must never be logged.
+type archiveCredentials struct {
+	secret        string
+	authorization string
+	username      string
+	password      string
+}
+
+// archiveAuth returns the credentials of the URL archive from its auth
+// secret in the package namespace, nil for archives without one.
+func (fetcher *Fetcher) archiveAuth(ctx context.Context, namespace string, archive *fv1.Archive) (*archiveCredentials, int, error) {
+	if len(archive.AuthSecret) == 0 {
+		return nil, http.StatusOK, nil
+	}
+	name := namespace + "/" + archive.AuthSecret
+	secret, err := fetcher.kubeClient.CoreV1().Secrets(namespace).Get(ctx, archive.AuthSecret, metav1.GetOptions{})
+	if err != nil {
+		code := http.StatusInternalServerError
+		if k8serr.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		return nil, code, errors.Wrapf(err, "error getting archive auth secret %s", name)
+	}
+	creds := &archiveCredentials{
+		secret:        name,
+		authorization: string(secret.Data["authorization"]),
+		username:      string(secret.Data["username"]),
+		password:      string(secret.Data["password"]),
+	}
+	if len(creds.authorization) == 0 && (len(creds.username) == 0 || len(creds.password) == 0) {
+		return nil, http.StatusBadRequest, errors.Errorf("archive auth secret %s has neither an authorization nor a username and password", name)
+	}
+	return creds, http.StatusOK, nil
+}
+
+// setAuth adds the credentials to the download request.
+func (c *archiveCredentials) setAuth(req *http.Request) {
+	if len(c.authorization) > 0 {
+		req.Header.Set("Authorization", c.authorization)
+		return
+	}
+	req.SetBasicAuth(c.username, c.password)
+}
+
+// downloadArchive downloads the URL archive to localPath, with its
+// credentials if it has any. At most archiveRedirectLimit redirects are
+// followed; credentials aren't sent in clear text nor, like any
+// Authorization header, to redirect targets on other hosts. Denied
+// downloads are source failures. Archives marked with an encoding are
+// downloaded as stored when the server allows it, and decompressed once
+// verified.
+func (fetcher *Fetcher) downloadArchive(ctx context.Context, archive *fv1.Archive, creds *archiveCredentials, localPath string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archive.URL, nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	encoded := archive.Encoding == fv1.ArchiveEncodingGzip
+	if encoded {
+		// asking for gzip explicitly keeps the transport from
+		// decompressing the body
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if creds != nil {
+		if req.URL.Scheme != "https" {
+			return http.StatusBadRequest, errors.Errorf("archive auth secret %s requires an https URL", creds.secret)
+		}
+		creds.setAuth(req)
+	}
+
+	client := *fetcher.httpClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= archiveRedirectLimit {
+			return errors.Errorf("stopped after %d redirects", archiveRedirectLimit)
+		}
+		if creds != nil && req.URL.Scheme != "https" {
+			return errors.New("refusing to follow redirect of authenticated download to a non-https URL")
+		}
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		msg := "the archive requires credentials, set an auth secret"
+		if creds != nil {
+			msg = fmt.Sprintf("check the credentials of archive auth secret %s", creds.secret)
+		}
+		return resp.StatusCode, &sourceFailureError{
+			failure: SourceFailureURLUnauthorized,
+			err:     errors.Errorf("archive download denied with %s, %s", resp.Status, msg),
+		}
+	case resp.StatusCode != http.StatusOK:
+		return http.StatusBadRequest, errors.Errorf("archive download failed with %s", resp.Status)
+	}
+	if encoded && resp.Header.Get("Content-Encoding") == "gzip" {
+		return writeEncodedArchive(resp.Body, archive, localPath)
+	}
+	// servers may decompress the archive themselves, e.g. storage
+	// services predating encoded archives
+	err = utils.WriteResponseBody(resp, localPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// writeEncodedArchive verifies the gzip compressed archive read from r
+// against its stored checksum, and writes it decompressed to localPath.
+// The checksum of the decompressed archive is verified by the caller.
+func writeEncodedArchive(r io.Reader, archive *fv1.Archive, localPath string) (int, error) {
+	encodedPath := localPath + ".gz"
+	defer os.Remove(encodedPath)
+	err := utils.WriteFile(r, encodedPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if len(archive.StoredChecksum.Sum) > 0 {
+		checksum, err := utils.GetFileChecksum(encodedPath)
+		if err != nil {
+			return http.StatusInternalServerError, errors.Wrap(err, "failed to get checksum of stored archive")
+		}
+		err = verifyChecksum(checksum, &archive.StoredChecksum)
+		if err != nil {
+			return http.StatusBadRequest, errors.Wrap(err, "failed to verify checksum of stored archive")
+		}
+	}
+
+	f, err := os.Open(encodedPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return http.StatusBadRequest, errors.Wrap(err, "error decompressing archive")
+	}
+	defer zr.Close()
+	err = utils.WriteFile(zr, localPath)
+	if err != nil {
+		return http.StatusBadRequest, errors.Wrap(err, "error decompressing archive")
+	}
+	return http.StatusOK, nil
+}
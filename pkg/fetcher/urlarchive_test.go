@@ -0,0 +1,194 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/utils"
+)
+
+func TestFetchAuthenticatedURLSource(t *testing.T) {
+	source := "def main(): pass\n"
+	archive := testZip(t, "hello.py", source)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/archive.zip", http.StatusFound)
+			return
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		user, password, basic := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer artifact-token" && (!basic || user != "builder" || password != "secret") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, err := w.Write(archive)
+		if err != nil {
+			t.Errorf("Error writing archive: %v", err)
+		}
+	}))
+	defer srv.Close()
+	secret := func(name string, data map[string]string) *apiv1.Secret {
+		s := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Data: map[string][]byte{}}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+	kubeClient := fake.NewSimpleClientset(
+		secret("token", map[string]string{"authorization": "Bearer artifact-token"}),
+		secret("basic", map[string]string{"username": "builder", "password": "secret"}),
+		secret("wrong", map[string]string{"username": "builder", "password": "wrong"}),
+	)
+	sum, err := utils.GetChecksum(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		secret   string
+		checksum fv1.Checksum
+		code     int
+		failure  string
+	}{
+		{name: "authorization header", url: srv.URL + "/archive.zip", secret: "token", checksum: *sum, code: http.StatusOK},
+		{name: "basic auth", url: srv.URL + "/archive.zip", secret: "basic", code: http.StatusOK},
+		{name: "redirect", url: srv.URL + "/redirect", secret: "token", checksum: *sum, code: http.StatusOK},
+		{name: "checksum mismatch", url: srv.URL + "/archive.zip", secret: "token",
+			checksum: fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: strings.Repeat("0", 64)}, code: http.StatusBadRequest},
+		{name: "wrong credentials", url: srv.URL + "/archive.zip", secret: "wrong", code: http.StatusUnauthorized,
+			failure: SourceFailureURLUnauthorized},
+		{name: "no credentials", url: srv.URL + "/archive.zip", code: http.StatusUnauthorized, failure: SourceFailureURLUnauthorized},
+		{name: "missing secret", url: srv.URL + "/archive.zip", secret: "missing", code: http.StatusNotFound},
+		{name: "redirect loop", url: srv.URL + "/loop", secret: "token", code: http.StatusBadRequest},
+		{name: "credentials over http", url: strings.Replace(srv.URL, "https://", "http://", 1) + "/archive.zip",
+			secret: "token", code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := &Fetcher{logger: zap.NewNop(), sharedVolumePath: t.TempDir(), httpClient: srv.Client(), kubeClient: kubeClient}
+			pkg := &fv1.Package{
+				ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+				Spec: fv1.PackageSpec{
+					Source: fv1.Archive{Type: fv1.ArchiveTypeUrl, URL: test.url, AuthSecret: test.secret, Checksum: test.checksum},
+				},
+			}
+			req := FunctionFetchRequest{FetchType: fv1.FETCH_SOURCE, Package: pkg.ObjectMeta, Filename: "hello-src"}
+			_, code, err := fetcher.Fetch(context.Background(), pkg, req)
+			if code != test.code {
+				t.Fatalf("Expected code %d, got %d: %v", test.code, code, err)
+			}
+			if test.code != http.StatusOK {
+				var sf *sourceFailureError
+				if errors.As(err, &sf) != (len(test.failure) > 0) || (sf != nil && sf.failure != test.failure) {
+					t.Errorf("Expected source failure %q, got %v", test.failure, err)
+				}
+				if err != nil && strings.Contains(err.Error(), "artifact-token") {
+					t.Errorf("Expected credentials left out of the error, got %v", err)
+				}
+				return
+			}
+			content, err := os.ReadFile(filepath.Join(fetcher.sharedVolumePath, "hello-src", "hello.py"))
+			if err != nil || string(content) != source {
+				t.Errorf("Expected the downloaded source, got %q: %v", content, err)
+			}
+		})
+	}
+}
+
+func TestFetchEncodedDeploymentArchive(t *testing.T) {
+	source := "def main(): pass\n"
+	archive := testZip(t, "hello.py", source)
+	var stored bytes.Buffer
+	zw := gzip.NewWriter(&stored)
+	_, err := zw.Write(archive)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// like the storage service, the stored archive is sent as is to
+		// clients accepting gzip and decompressed for the others
+		body := archive
+		if r.URL.Path == "/stored" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			body = stored.Bytes()
+		}
+		_, err := w.Write(body)
+		if err != nil {
+			t.Errorf("Error writing archive: %v", err)
+		}
+	}))
+	defer srv.Close()
+	sum, err := utils.GetChecksum(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedSum, err := utils.GetChecksum(bytes.NewReader(stored.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		archive  fv1.Archive
+		code     int
+		checksum bool
+	}{
+		{name: "encoded", archive: fv1.Archive{URL: srv.URL + "/stored", Checksum: *sum,
+			Encoding: fv1.ArchiveEncodingGzip, StoredChecksum: *storedSum}, code: http.StatusOK},
+		{name: "decompressed by server", archive: fv1.Archive{URL: srv.URL + "/plain", Checksum: *sum,
+			Encoding: fv1.ArchiveEncodingGzip, StoredChecksum: *storedSum}, code: http.StatusOK},
+		{name: "not encoded", archive: fv1.Archive{URL: srv.URL + "/stored", Checksum: *sum}, code: http.StatusOK},
+		{name: "stored checksum mismatch", archive: fv1.Archive{URL: srv.URL + "/stored", Checksum: *sum, Encoding: fv1.ArchiveEncodingGzip,
+			StoredChecksum: fv1.Checksum{Type: fv1.ChecksumTypeSHA256, Sum: strings.Repeat("0", 64)}}, code: http.StatusBadRequest, checksum: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := &Fetcher{logger: zap.NewNop(), sharedVolumePath: t.TempDir(), httpClient: srv.Client()}
+			test.archive.Type = fv1.ArchiveTypeUrl
+			pkg := &fv1.Package{
+				ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+				Spec:       fv1.PackageSpec{Deployment: test.archive},
+				Status:     fv1.PackageStatus{BuildStatus: fv1.BuildStatusSucceeded},
+			}
+			req := FunctionFetchRequest{FetchType: fv1.FETCH_DEPLOYMENT, Package: pkg.ObjectMeta, Filename: "hello-deploy"}
+			_, code, err := fetcher.Fetch(context.Background(), pkg, req)
+			if code != test.code {
+				t.Fatalf("Expected code %d, got %d: %v", test.code, code, err)
+			}
+			if test.code != http.StatusOK {
+				var fe ferror.Error
+				if errors.As(err, &fe) != test.checksum || (test.checksum && fe.Code != ferror.ErrorChecksumFail) {
+					t.Errorf("Expected checksum failure, got %v", err)
+				}
+				return
+			}
+			content, err := os.ReadFile(filepath.Join(fetcher.sharedVolumePath, "hello-deploy", "hello.py"))
+			if err != nil || string(content) != source {
+				t.Errorf("Expected the decompressed deployment, got %q: %v", content, err)
+			}
+		})
+	}
+}
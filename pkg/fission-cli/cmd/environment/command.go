@@ -96,13 +96,24 @@ func Commands() *cobra.Command {
 		Optional: []flag.Flag{flag.NamespaceEnvironment, flag.EnvExecutorType},
 	})
 
+	rebuildPackagesCmd := &cobra.Command{
+		Use:   "rebuild-packages",
+		Short: "Rebuild the source packages of an environment",
+		Long:  "Mark the source packages of an environment pending, e.g. after an update of its builder image. Packages waiting for or in a build are left alone.",
+		RunE:  wrapper.Wrapper(RebuildPackages),
+	}
+	wrapper.SetFlags(rebuildPackagesCmd, flag.FlagSet{
+		Required: []flag.Flag{flag.EnvName},
+		Optional: []flag.Flag{flag.NamespaceEnvironment, flag.BulkDryRun, flag.BulkYes},
+	})
+
 	command := &cobra.Command{
 		Use:     "environment",
 		Aliases: []string{"env"},
 		Short:   "Create, update and manage environments",
 	}
 
-	command.AddCommand(createCmd, getCmd, updateCmd, deleteCmd, listCmd, listPodsCmd)
+	command.AddCommand(createCmd, getCmd, updateCmd, deleteCmd, listCmd, listPodsCmd, rebuildPackagesCmd)
 
 	return command
 }
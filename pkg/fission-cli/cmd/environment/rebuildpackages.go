@@ -0,0 +1,99 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package environment
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
+	"github.com/fission/fission/pkg/fission-cli/cmd"
+	pkgutil "github.com/fission/fission/pkg/fission-cli/cmd/package/util"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/fission-cli/util"
+)
+
+type RebuildPackagesSubCommand struct {
+	cmd.CommandActioner
+	name      string
+	namespace string
+}
+
+func RebuildPackages(input cli.Input) error {
+	return (&RebuildPackagesSubCommand{}).do(input)
+}
+
+func (opts *RebuildPackagesSubCommand) do(input cli.Input) error {
+	err := opts.complete(input)
+	if err != nil {
+		return err
+	}
+	return opts.run(input)
+}
+
+func (opts *RebuildPackagesSubCommand) complete(input cli.Input) (err error) {
+	opts.name = input.String(flagkey.EnvName)
+	_, opts.namespace, err = opts.GetResourceNamespace(input, flagkey.NamespaceEnvironment)
+	if err != nil {
+		return fv1.AggregateValidationErrors("Environment", err)
+	}
+	return nil
+}
+
+func (opts *RebuildPackagesSubCommand) run(input cli.Input) error {
+	_, err := opts.Client().FissionClientSet.CoreV1().Environments(opts.namespace).Get(input.Context(), opts.name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "find environment")
+	}
+	op := util.NewBulkOperation(input, fmt.Sprintf("rebuild the packages of environment %s", opts.name))
+	return rebuildEnvironmentPackages(input.Context(), opts.Client(), opts.name, opts.namespace, op)
+}
+
+// rebuildEnvironmentPackages marks the source packages of the environment
+// pending. Packages waiting for or in a build and packages skipping their
+// build are left alone.
+func rebuildEnvironmentPackages(ctx context.Context, client cmd.Client, envName, envNamespace string, op *util.BulkOperation) error {
+	pkgList, err := client.FissionClientSet.CoreV1().Packages(envNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "list packages")
+	}
+	pkgs := make(map[string]*fv1.Package)
+	var items []util.BulkItem
+	for i := range pkgList.Items {
+		pkg := &pkgList.Items[i]
+		if pkg.Spec.Environment.Name != envName || pkg.Spec.Environment.Namespace != envNamespace ||
+			pkg.Spec.Source.IsEmpty() || pkg.ObjectMeta.Annotations[fv1.ANNOTATION_SKIP_BUILD] == "true" ||
+			pkg.Status.BuildStatus == fv1.BuildStatusPending || pkg.Status.BuildStatus == fv1.BuildStatusRunning {
+			continue
+		}
+		pkgs[pkg.ObjectMeta.Name] = pkg
+		items = append(items, util.BulkItem{
+			Kind:      "package",
+			Name:      pkg.ObjectMeta.Name,
+			Namespace: pkg.ObjectMeta.Namespace,
+			Action:    fmt.Sprintf("rebuild %s package", pkg.Status.BuildStatus),
+		})
+	}
+	return op.Run(ctx, items, func(ctx context.Context, item util.BulkItem) error {
+		_, err := pkgutil.SetPackagePending(ctx, client, pkgs[item.Name].DeepCopy(), fv1.BuildTriggerBulkRebuild)
+		return err
+	})
+}
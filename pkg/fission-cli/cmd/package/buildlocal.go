@@ -0,0 +1,212 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package _package
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/fission/fission/pkg/builder"
+	builderClient "github.com/fission/fission/pkg/builder/client"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
+	"github.com/fission/fission/pkg/fission-cli/cmd"
+	"github.com/fission/fission/pkg/fission-cli/console"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/utils"
+)
+
+const (
+	// localSharedMountPath is where the shared volume is mounted in the builder
+	// container, it matches the path buildermgr uses for builder pods.
+	localSharedMountPath = "/packages"
+	localBuilderPort     = "8001"
+	localBuilderTimeout  = 60 * time.Second
+)
+
+type BuildLocalSubCommand struct {
+	cmd.CommandActioner
+	srcArchiveFiles []string
+	builderImage    string
+	buildCmd        string
+	output          string
+	runtime         string
+}
+
+// BuildLocal builds a source package with the environment builder image
+// on the local container runtime, without a cluster.
+func BuildLocal(input cli.Input) error {
+	return (&BuildLocalSubCommand{}).do(input)
+}
+
+func (opts *BuildLocalSubCommand) do(input cli.Input) error {
+	err := opts.complete(input)
+	if err != nil {
+		return err
+	}
+	return opts.run(input)
+}
+
+func (opts *BuildLocalSubCommand) complete(input cli.Input) error {
+	opts.srcArchiveFiles = input.StringSlice(flagkey.PkgSrcArchive)
+	if len(opts.srcArchiveFiles) == 0 {
+		return errors.Errorf("need --%v argument", flagkey.PkgSrcArchive)
+	}
+	opts.builderImage = input.String(flagkey.PkgEnvBuilderImage)
+	opts.buildCmd = input.String(flagkey.PkgBuildCmd)
+	opts.output = input.String(flagkey.PkgOutput)
+	if len(opts.output) == 0 {
+		opts.output = archiveName("", opts.srcArchiveFiles) + ".zip"
+	}
+	opts.runtime = input.String(flagkey.PkgContainerRuntime)
+	return nil
+}
+
+func (opts *BuildLocalSubCommand) run(input cli.Input) error {
+	ctx := input.Context()
+
+	console.Warn("Local builds differ from in-cluster builds: no secrets or configmaps are mounted, " +
+		"the builder doesn't run with the fission-builder service account, " +
+		"and environment pod spec and resource settings are not applied.")
+
+	sharedDir, err := utils.GetTempDir()
+	if err != nil {
+		return errors.Wrap(err, "error creating shared volume directory")
+	}
+	defer os.RemoveAll(sharedDir)
+
+	// place the source package in the shared volume the same way fetcher does
+	srcPkgFilename := fmt.Sprintf("%v-%v", "local", strings.ToLower(uniuri.NewLen(6)))
+	srcArchive, err := makeArchiveFile("", opts.srcArchiveFiles, false)
+	if err != nil {
+		return errors.Wrap(err, "error creating source archive")
+	}
+	err = fetcher.Unarchive(srcArchive, filepath.Join(sharedDir, srcPkgFilename))
+	if err != nil {
+		return errors.Wrap(err, "error placing source package in shared volume")
+	}
+
+	containerID, err := opts.runContainer(ctx, "run", "--detach", "--rm",
+		"--publish", fmt.Sprintf("127.0.0.1::%v", localBuilderPort),
+		"--volume", fmt.Sprintf("%v:%v", sharedDir, localSharedMountPath),
+		opts.builderImage, "/builder", localSharedMountPath)
+	if err != nil {
+		return errors.Wrap(err, "error starting builder container")
+	}
+	defer func() {
+		_, err := opts.runContainer(context.Background(), "rm", "--force", containerID)
+		if err != nil {
+			console.Warn(fmt.Sprintf("error removing builder container %v: %v", containerID, err))
+		}
+	}()
+	console.Verbose(2, "started builder container %v", containerID)
+
+	hostAddr, err := opts.runContainer(ctx, "port", containerID, localBuilderPort)
+	if err != nil {
+		return errors.Wrap(err, "error getting builder container port")
+	}
+	// the runtime may report one address per line, e.g. for IPv4 and IPv6
+	builderURL := fmt.Sprintf("http://%v", strings.Split(hostAddr, "\n")[0])
+
+	err = waitForLocalBuilder(ctx, builderURL)
+	if err != nil {
+		return err
+	}
+
+	pkgBuildReq := &builder.PackageBuildRequest{
+		SrcPkgFilename: srcPkgFilename,
+		BuildCommand:   opts.buildCmd,
+	}
+	buildResp, err := builderClient.MakeClient(zap.NewNop(), builderURL).Build(ctx, pkgBuildReq)
+	if err != nil {
+		// keep the log format the same as the build logs buildermgr records in package status
+		var buildLogs string
+		if buildResp != nil {
+			buildLogs = buildResp.BuildLogs
+		}
+		buildLogs += fmt.Sprintf("Error building deployment package: %v\n", err)
+		fmt.Print(buildLogs)
+		return errors.New("build failed")
+	}
+	fmt.Print(buildResp.BuildLogs)
+
+	deployPkgPath := filepath.Join(sharedDir, buildResp.ArtifactFilename)
+	err = fetcher.Archive(deployPkgPath, opts.output)
+	if err != nil {
+		return errors.Wrap(err, "error archiving deployment package")
+	}
+	checksum, err := utils.GetFileChecksum(opts.output)
+	if err != nil {
+		return errors.Wrap(err, "error calculating checksum of deployment package")
+	}
+
+	fmt.Printf("Deployment archive: %v\n", opts.output)
+	fmt.Printf("Checksum (%v): %v\n", checksum.Type, checksum.Sum)
+	return nil
+}
+
+// runContainer runs the container runtime CLI with args and returns its trimmed stdout.
+func (opts *BuildLocalSubCommand) runContainer(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, opts.runtime, args...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return "", errors.Wrapf(err, "%v %v: %v", opts.runtime, args[0], msg)
+		}
+		return "", errors.Wrapf(err, "%v %v", opts.runtime, args[0])
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// waitForLocalBuilder waits for the builder health check to pass,
+// like the readiness probe of builder pods.
+func waitForLocalBuilder(ctx context.Context, builderURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, localBuilderTimeout)
+	defer cancel()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, builderURL+"/healthz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "builder container not ready")
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package _package
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	builderMgrClient "github.com/fission/fission/pkg/buildermgr/client"
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
+	"github.com/fission/fission/pkg/fission-cli/cmd"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/fission-cli/util"
+)
+
+type CancelSubCommand struct {
+	cmd.CommandActioner
+	name      string
+	namespace string
+}
+
+func Cancel(input cli.Input) error {
+	return (&CancelSubCommand{}).do(input)
+}
+
+func (opts *CancelSubCommand) do(input cli.Input) error {
+	err := opts.complete(input)
+	if err != nil {
+		return err
+	}
+	return opts.run(input)
+}
+
+func (opts *CancelSubCommand) complete(input cli.Input) (err error) {
+	opts.name = input.String(flagkey.PkgName)
+	_, opts.namespace, err = opts.GetResourceNamespace(input, flagkey.NamespacePackage)
+	if err != nil {
+		return fv1.AggregateValidationErrors("Package", err)
+	}
+	return nil
+}
+
+func (opts *CancelSubCommand) run(input cli.Input) error {
+	serverURL, err := util.GetBuilderMgrURL(input.Context(), opts.Client())
+	if err != nil {
+		return errors.Wrap(err, "error getting builder manager URL")
+	}
+	err = builderMgrClient.MakeClient(serverURL).CancelBuild(input.Context(), opts.namespace, opts.name)
+	if err != nil {
+		return errors.Wrap(err, "error canceling package build")
+	}
+
+	fmt.Printf("Canceling build of pkg %v. Use \"fission pkg info --name %v\" to view status.\n", opts.name, opts.name)
+	return nil
+}
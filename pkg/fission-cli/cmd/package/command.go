@@ -19,8 +19,11 @@ package _package
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	wrapper "github.com/fission/fission/pkg/fission-cli/cliwrapper/driver/cobra"
+	"github.com/fission/fission/pkg/fission-cli/console"
 	"github.com/fission/fission/pkg/fission-cli/flag"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
 )
 
 func Commands() *cobra.Command {
@@ -74,7 +77,8 @@ func Commands() *cobra.Command {
 		RunE:  wrapper.Wrapper(Delete),
 	}
 	wrapper.SetFlags(deleteCmd, flag.FlagSet{
-		Optional: []flag.Flag{flag.PkgName, flag.PkgForce, flag.PkgOrphan, flag.NamespacePackage, flag.IgnoreNotFound},
+		Optional: []flag.Flag{flag.PkgName, flag.PkgForce, flag.PkgOrphan, flag.NamespacePackage, flag.IgnoreNotFound,
+			flag.BulkDryRun, flag.BulkYes},
 	})
 
 	listCmd := &cobra.Command{
@@ -94,12 +98,12 @@ func Commands() *cobra.Command {
 	}
 	wrapper.SetFlags(infoCmd, flag.FlagSet{
 		Required: []flag.Flag{flag.PkgName},
-		Optional: []flag.Flag{flag.NamespacePackage},
+		Optional: []flag.Flag{flag.NamespacePackage, flag.PkgImpact, flag.PkgFullLog},
 	})
 
 	rebuildCmd := &cobra.Command{
 		Use:   "rebuild",
-		Short: "Rebuild a failed package",
+		Short: "Rebuild a failed or canceled package",
 		RunE:  wrapper.Wrapper(Rebuild),
 	}
 	wrapper.SetFlags(rebuildCmd, flag.FlagSet{
@@ -107,13 +111,50 @@ func Commands() *cobra.Command {
 		Optional: []flag.Flag{flag.NamespacePackage},
 	})
 
+	cancelCmd := &cobra.Command{
+		Use:   "cancel",
+		Short: "Cancel the running or queued build of a package",
+		RunE:  wrapper.Wrapper(Cancel),
+	}
+	wrapper.SetFlags(cancelCmd, flag.FlagSet{
+		Required: []flag.Flag{flag.PkgName},
+		Optional: []flag.Flag{flag.NamespacePackage},
+	})
+
+	buildLocalCmd := &cobra.Command{
+		Use:   "build-local",
+		Short: "Build a source package locally with an environment builder image",
+		Long:  "Build a source package with the environment builder image on the local container runtime, without a cluster. The deployment archive is written to the output file.",
+		RunE:  wrapper.Wrapper(BuildLocal),
+		// local builds don't talk to the cluster, skip the client setup of the root command
+		PersistentPreRunE: wrapper.Wrapper(func(input cli.Input) error {
+			console.Verbosity = input.Int(flagkey.Verbosity)
+			return nil
+		}),
+	}
+	wrapper.SetFlags(buildLocalCmd, flag.FlagSet{
+		Required: []flag.Flag{flag.PkgSrcArchive, flag.PkgEnvBuilderImage},
+		Optional: []flag.Flag{flag.PkgBuildCmd, flag.PkgOutput, flag.PkgContainerRuntime},
+	})
+
+	migrateLiteralsCmd := &cobra.Command{
+		Use:   "migrate-literals",
+		Short: "Move large literal archives of packages to the storage service",
+		Long:  "Upload the literal archives above the threshold size to the storage service and reference them by URL in the package specs. Packages that fail to migrate are left untouched, running the command again resumes the migration.",
+		RunE:  wrapper.Wrapper(MigrateLiterals),
+	}
+	wrapper.SetFlags(migrateLiteralsCmd, flag.FlagSet{
+		Optional: []flag.Flag{flag.PkgMigrateThreshold, flag.PkgMigrateQPS,
+			flag.NamespacePackage, flag.AllNamespaces, flag.BulkDryRun, flag.BulkYes},
+	})
+
 	command := &cobra.Command{
 		Use:     "package",
 		Aliases: []string{"pkg"},
 		Short:   "Create, update and manage packages",
 	}
 
-	command.AddCommand(createCmd, getSrcCmd, getDeployCmd, updateCmd, deleteCmd, listCmd, infoCmd, rebuildCmd)
+	command.AddCommand(createCmd, getSrcCmd, getDeployCmd, updateCmd, deleteCmd, listCmd, infoCmd, rebuildCmd, cancelCmd, buildLocalCmd, migrateLiteralsCmd)
 
 	return command
 }
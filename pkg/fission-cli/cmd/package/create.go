@@ -148,6 +148,7 @@ func CreatePackage(input cli.Input, client cmd.Client, pkgName string, pkgNamesp
 	}
 
 	var pkgStatus fv1.BuildStatus = fv1.BuildStatusSucceeded
+	var pkgTrigger fv1.BuildTrigger
 
 	if len(deployArchiveFiles) > 0 {
 		if len(specFile) > 0 { // we should do this in all cases, i think
@@ -169,6 +170,7 @@ func CreatePackage(input cli.Input, client cmd.Client, pkgName string, pkgNamesp
 		}
 		pkgSpec.Source = *source
 		pkgStatus = fv1.BuildStatusPending // set package build status to pending
+		pkgTrigger = fv1.BuildTriggerPackageCreated
 		if len(pkgName) == 0 {
 			pkgName = util.KubifyName(fmt.Sprintf("%v-%v", path.Base(srcArchiveFiles[0]), uniuri.NewLen(4)))
 		}
@@ -194,6 +196,7 @@ func CreatePackage(input cli.Input, client cmd.Client, pkgName string, pkgNamesp
 		Spec: pkgSpec,
 		Status: fv1.PackageStatus{
 			BuildStatus:         pkgStatus,
+			BuildTrigger:        pkgTrigger,
 			LastUpdateTimestamp: metav1.Time{Time: time.Now().UTC()},
 		},
 	}
@@ -28,6 +28,7 @@ import (
 	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
 	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/fission-cli/util"
 )
 
 type DeleteSubCommand struct {
@@ -63,6 +64,9 @@ func (opts *DeleteSubCommand) complete(input cli.Input) (err error) {
 	if len(opts.name) == 0 && !opts.deleteOrphans {
 		return errors.Errorf("need --%v or --%v flag", flagkey.PkgName, flagkey.PkgOrphan)
 	}
+	if !opts.deleteOrphans && (input.Bool(flagkey.BulkDryRun) || input.Bool(flagkey.BulkYes)) {
+		return errors.Errorf("--%v and --%v only apply with --%v", flagkey.BulkDryRun, flagkey.BulkYes, flagkey.PkgOrphan)
+	}
 
 	return nil
 }
@@ -94,36 +98,40 @@ func (opts *DeleteSubCommand) run(input cli.Input) error {
 
 	// TODO improve list speed when --orphan
 	if opts.deleteOrphans {
-		err := deleteOrphanPkgs(input.Context(), opts.Client(), opts.namespace)
-		if err != nil {
-			return errors.Wrap(err, "deleting orphan packages")
-		}
-		fmt.Println("Orphan packages deleted")
+		op := util.NewBulkOperation(input, "delete orphan packages")
+		return deleteOrphanPkgs(input.Context(), opts.Client(), opts.namespace, op)
 	}
 
 	return nil
 }
 
-func deleteOrphanPkgs(ctx context.Context, client cmd.Client, pkgNamespace string) error {
+// deleteOrphanPkgs deletes the packages of the namespace that no function
+// references.
+func deleteOrphanPkgs(ctx context.Context, client cmd.Client, pkgNamespace string, op *util.BulkOperation) error {
 	pkgList, err := client.FissionClientSet.CoreV1().Packages(pkgNamespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return err
+		return errors.Wrap(err, "list packages")
 	}
 
 	// range through all packages and find out the ones not referenced by any function
+	var orphans []util.BulkItem
 	for _, pkg := range pkgList.Items {
 		fnList, err := GetFunctionsByPackage(ctx, client, pkg.ObjectMeta.Name, pkgNamespace)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("get functions sharing package %s", pkg.ObjectMeta.Name))
 		}
 		if len(fnList) == 0 {
-			err = deletePackage(ctx, client, pkg.ObjectMeta.Name, pkgNamespace)
-			if err != nil {
-				return err
-			}
+			orphans = append(orphans, util.BulkItem{
+				Kind:      "package",
+				Name:      pkg.ObjectMeta.Name,
+				Namespace: pkg.ObjectMeta.Namespace,
+				Action:    "delete",
+			})
 		}
 	}
-	return nil
+	return op.Run(ctx, orphans, func(ctx context.Context, item util.BulkItem) error {
+		return deletePackage(ctx, client, item.Name, item.Namespace)
+	})
 }
 
 func deletePackage(ctx context.Context, client cmd.Client, pkgName string, pkgNamespace string) error {
@@ -17,22 +17,29 @@ limitations under the License.
 package _package
 
 import (
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/buildermgr"
+	builderMgrClient "github.com/fission/fission/pkg/buildermgr/client"
 	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
 	pkgutil "github.com/fission/fission/pkg/fission-cli/cmd/package/util"
 	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/fission-cli/util"
 )
 
 type InfoSubCommand struct {
 	cmd.CommandActioner
 	name      string
 	namespace string
+	impact    bool
+	fullLog   bool
 }
 
 func Info(input cli.Input) error {
@@ -49,6 +56,8 @@ func (opts *InfoSubCommand) do(input cli.Input) error {
 
 func (opts *InfoSubCommand) complete(input cli.Input) (err error) {
 	opts.name = input.String(flagkey.PkgName)
+	opts.impact = input.Bool(flagkey.PkgImpact)
+	opts.fullLog = input.Bool(flagkey.PkgFullLog)
 
 	_, opts.namespace, err = opts.GetResourceNamespace(input, flagkey.NamespacePackage)
 	if err != nil {
@@ -66,6 +75,54 @@ func (opts *InfoSubCommand) run(input cli.Input) error {
 	if err != nil {
 		return errors.Wrapf(err, "error finding package %s", opts.name)
 	}
+	if opts.fullLog && len(pkg.Status.BuildLogURL) > 0 {
+		// the package status only keeps the tail of persisted build logs
+		reader, err := pkgutil.DownloadStrorageURL(input.Context(), opts.Client(), pkg.Status.BuildLogURL)
+		if err != nil {
+			return errors.Wrap(err, "error downloading build log")
+		}
+		buildLog, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return errors.Wrap(err, "error reading build log")
+		}
+		pkg.Status.BuildLog = string(buildLog)
+	}
 	pkgutil.PrintPackageSummary(os.Stdout, pkg)
+
+	if opts.impact {
+		serverURL, err := util.GetBuilderMgrURL(input.Context(), opts.Client())
+		if err != nil {
+			return errors.Wrap(err, "error getting builder manager URL")
+		}
+		impact, err := builderMgrClient.MakeClient(serverURL).PackageImpact(input.Context(), opts.namespace, opts.name)
+		if err != nil {
+			return errors.Wrap(err, "error getting package impact")
+		}
+		fmt.Println()
+		printPackageImpact(os.Stdout, impact)
+	}
 	return nil
 }
+
+// printPackageImpact renders the functions a rebuild of the package affects
+// and their triggers as a tree.
+func printPackageImpact(w io.Writer, impact *buildermgr.PackageImpact) {
+	fmt.Fprintf(w, "Rebuild Impact: %d functions\n", len(impact.Functions))
+	fmt.Fprintf(w, "%s/%s (resource version %s)\n", impact.Namespace, impact.Name, impact.ResourceVersion)
+	for i, fn := range impact.Functions {
+		branch, indent := "├── ", "│   "
+		if i == len(impact.Functions)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintf(w, "%sfunction %s (executor %s, update %s, package version %s)\n",
+			branch, fn.Name, fn.ExecutorType, fn.UpdatePolicy, fn.PackageResourceVersion)
+		for j, trigger := range fn.Triggers {
+			tBranch := "├── "
+			if j == len(fn.Triggers)-1 {
+				tBranch = "└── "
+			}
+			fmt.Fprintf(w, "%s%s%s %s\n", indent, tBranch, trigger.Kind, trigger.Name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package _package
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/buildermgr"
+	builderMgrClient "github.com/fission/fission/pkg/buildermgr/client"
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
+	"github.com/fission/fission/pkg/fission-cli/cmd"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+	"github.com/fission/fission/pkg/fission-cli/util"
+)
+
+type MigrateLiteralsSubCommand struct {
+	cmd.CommandActioner
+	request buildermgr.LiteralMigrationRequest
+}
+
+func MigrateLiterals(input cli.Input) error {
+	return (&MigrateLiteralsSubCommand{}).do(input)
+}
+
+func (opts *MigrateLiteralsSubCommand) do(input cli.Input) error {
+	err := opts.complete(input)
+	if err != nil {
+		return err
+	}
+	return opts.run(input)
+}
+
+func (opts *MigrateLiteralsSubCommand) complete(input cli.Input) (err error) {
+	threshold, err := humanize.ParseBytes(input.String(flagkey.PkgMigrateThreshold))
+	if err != nil || threshold == 0 {
+		return errors.Errorf("invalid threshold %q, expected a size like 100KB", input.String(flagkey.PkgMigrateThreshold))
+	}
+	opts.request.Threshold = int64(threshold)
+	opts.request.QPS = float32(input.Int(flagkey.PkgMigrateQPS))
+
+	_, opts.request.Namespace, err = opts.GetResourceNamespace(input, flagkey.NamespacePackage)
+	if err != nil {
+		return fv1.AggregateValidationErrors("Package", err)
+	}
+	if input.Bool(flagkey.AllNamespaces) {
+		opts.request.Namespace = metav1.NamespaceAll
+	}
+	return nil
+}
+
+func (opts *MigrateLiteralsSubCommand) run(input cli.Input) error {
+	serverURL, err := util.GetBuilderMgrURL(input.Context(), opts.Client())
+	if err != nil {
+		return errors.Wrap(err, "error getting builder manager URL")
+	}
+	client := builderMgrClient.MakeClient(serverURL)
+	op := util.NewBulkOperation(input, "migrate package literals")
+
+	// a dry run finds the packages to migrate
+	plan := opts.request
+	plan.DryRun = true
+	resp, err := client.MigrateLiterals(input.Context(), &plan)
+	if err != nil {
+		return errors.Wrap(err, "error finding package literals to migrate")
+	}
+	var items []util.BulkItem
+	for _, result := range resp.Results {
+		if result.Status == buildermgr.LiteralMigrationMigrated {
+			items = append(items, literalMigrationItem(result))
+		}
+	}
+	// only the confirmed packages are migrated, one request each
+	err = op.Run(input.Context(), items, func(ctx context.Context, item util.BulkItem) error {
+		req := opts.request
+		req.Namespace, req.Name = item.Namespace, item.Name
+		resp, err := client.MigrateLiterals(ctx, &req)
+		if err != nil {
+			return err
+		}
+		for _, result := range resp.Results {
+			if result.Status == buildermgr.LiteralMigrationFailed {
+				return errors.New(result.Message)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, util.ErrBulkAborted) {
+		return errors.Errorf("%v, run the command again to retry", err)
+	}
+	return err
+}
+
+// literalMigrationItem returns the bulk item of the package migration.
+func literalMigrationItem(result buildermgr.LiteralMigrationResult) util.BulkItem {
+	return util.BulkItem{
+		Kind:      "package",
+		Name:      result.Name,
+		Namespace: result.Namespace,
+		Action:    fmt.Sprintf("migrate %s literal (%s)", strings.Join(result.Archives, ","), humanize.Bytes(uint64(result.Size))),
+	}
+}
@@ -31,6 +31,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/buildermgr"
 	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
 	pkgutil "github.com/fission/fission/pkg/fission-cli/cmd/package/util"
@@ -253,16 +254,12 @@ func archiveName(givenNameHint string, includedFiles []string) string {
 	return fmt.Sprintf("%v-%v", util.KubifyName(includedFiles[0]), uniuri.NewLen(4))
 }
 
+// GetFunctionsByPackage returns the functions using the package, it backs the
+// checks that keep packages in use from being deleted.
 func GetFunctionsByPackage(ctx context.Context, client cmd.Client, pkgName, pkgNamespace string) ([]fv1.Function, error) {
 	fnList, err := client.FissionClientSet.CoreV1().Functions(pkgNamespace).List(ctx, v1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
-	fns := []fv1.Function{}
-	for _, fn := range fnList.Items {
-		if fn.Spec.Package.PackageRef.Name == pkgName {
-			fns = append(fns, fn)
-		}
-	}
-	return fns, nil
+	return buildermgr.FunctionsReferencingPackage(fnList.Items, pkgName, pkgNamespace), nil
 }
@@ -25,6 +25,7 @@ import (
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
+	pkgutil "github.com/fission/fission/pkg/fission-cli/cmd/package/util"
 	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
 )
 
@@ -64,12 +65,12 @@ func (opts *RebuildSubCommand) run(input cli.Input) error {
 		return errors.Wrap(err, "find package")
 	}
 
-	if pkg.Status.BuildStatus != fv1.BuildStatusFailed {
-		return errors.New(fmt.Sprintf("Package %v is not in %v state.",
-			pkg.ObjectMeta.Name, fv1.BuildStatusFailed))
+	if pkg.Status.BuildStatus != fv1.BuildStatusFailed && pkg.Status.BuildStatus != fv1.BuildStatusCanceled {
+		return errors.New(fmt.Sprintf("Package %v is not in %v or %v state.",
+			pkg.ObjectMeta.Name, fv1.BuildStatusFailed, fv1.BuildStatusCanceled))
 	}
 
-	_, err = updatePackageStatus(input.Context(), opts.Client(), pkg, fv1.BuildStatusPending)
+	_, err = pkgutil.SetPackagePending(input.Context(), opts.Client(), pkg, fv1.BuildTriggerManualRebuild)
 	if err != nil {
 		return errors.Wrap(err, "update package status")
 	}
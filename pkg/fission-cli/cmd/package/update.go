@@ -19,7 +19,6 @@ package _package
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -28,6 +27,7 @@ import (
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
+	pkgutil "github.com/fission/fission/pkg/fission-cli/cmd/package/util"
 	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
 )
 
@@ -165,23 +165,23 @@ func UpdatePackage(input cli.Input, client cmd.Client, pkg *fv1.Package) (*metav
 		return &pkg.ObjectMeta, nil
 	}
 
+	newPkg, err := client.FissionClientSet.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(input.Context(), pkg, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "update package")
+	}
+
 	// Set package as pending status when needToBuild is true
 	if needToRebuild {
 		// change into pending state to trigger package build
-		pkg.Status = fv1.PackageStatus{
-			BuildStatus:         fv1.BuildStatusPending,
-			LastUpdateTimestamp: metav1.Time{Time: time.Now().UTC()},
+		newPkg, err = pkgutil.SetPackagePending(input.Context(), client, newPkg, fv1.BuildTriggerSpecChanged)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	newPkgMeta, err := client.FissionClientSet.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(input.Context(), pkg, metav1.UpdateOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "update package")
-	}
-
-	fmt.Printf("Package '%v' updated\n", newPkgMeta.GetName())
+	fmt.Printf("Package '%v' updated\n", newPkg.GetName())
 
-	return &newPkgMeta.ObjectMeta, err
+	return &newPkg.ObjectMeta, nil
 }
 
 func UpdateFunctionPackageResourceVersion(ctx context.Context, client cmd.Client, pkgMeta *metav1.ObjectMeta, fnList ...fv1.Function) error {
@@ -198,16 +198,3 @@ func UpdateFunctionPackageResourceVersion(ctx context.Context, client cmd.Client
 
 	return errs.ErrorOrNil()
 }
-
-func updatePackageStatus(ctx context.Context, client cmd.Client, pkg *fv1.Package, status fv1.BuildStatus) (*metav1.ObjectMeta, error) {
-	switch status {
-	case fv1.BuildStatusNone, fv1.BuildStatusPending, fv1.BuildStatusRunning, fv1.BuildStatusSucceeded, fv1.CanaryConfigStatusAborted:
-		pkg.Status = fv1.PackageStatus{
-			BuildStatus:         status,
-			LastUpdateTimestamp: metav1.Time{Time: time.Now().UTC()},
-		}
-		pkg, err := client.FissionClientSet.CoreV1().Packages(pkg.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
-		return &pkg.ObjectMeta, err
-	}
-	return nil, errors.New("unknown package status")
-}
@@ -25,14 +25,21 @@ import (
 	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/controller/client"
+	"github.com/fission/fission/pkg/crd"
 	"github.com/fission/fission/pkg/fission-cli/cmd"
 	"github.com/fission/fission/pkg/fission-cli/util"
+	"github.com/fission/fission/pkg/storagesvc"
 	storageSvcClient "github.com/fission/fission/pkg/storagesvc/client"
 	"github.com/fission/fission/pkg/utils"
 )
@@ -182,13 +189,14 @@ func DownloadStrorageURL(ctx context.Context, client cmd.Client, fileUrl string)
 			return nil, err
 		}
 		id := url.Query().Get("id")
+		target := url.Query().Get(storagesvc.QueryParamTarget)
 		storageAccessURL, err := util.GetStorageURL(ctx, client)
 		if err != nil {
 			return nil, err
 		}
 
 		client := storageSvcClient.MakeClient(storageAccessURL.String())
-		resp, err = client.GetFile(ctx, id)
+		resp, err = client.GetTargetFile(ctx, id, target)
 		if err != nil {
 			return nil, err
 		}
@@ -262,7 +270,82 @@ func PrintPackageSummary(writer io.Writer, pkg *fv1.Package) {
 	w := tabwriter.NewWriter(writer, 0, 0, 1, ' ', 0)
 	fmt.Fprintf(w, "%v\t%v\n", "Name:", pkg.ObjectMeta.Name)
 	fmt.Fprintf(w, "%v\t%v\n", "Environment:", pkg.Spec.Environment.Name)
-	fmt.Fprintf(w, "%v\t%v\n", "Status:", pkg.Status.BuildStatus)
+	status := string(pkg.Status.BuildStatus)
+	if pkg.Status.BuildStatus == fv1.BuildStatusCanceled {
+		// tell who stopped the build
+		if cond := meta.FindStatusCondition(pkg.Status.Conditions, fv1.PackageConditionBuildSucceeded); cond != nil {
+			status = fmt.Sprintf("%s (%s)", status, cond.Message)
+		}
+	}
+	fmt.Fprintf(w, "%v\t%v\n", "Status:", status)
+	if wait := pkg.Status.BuilderWait; wait != nil {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Phase:", builderWaitSummary(wait, time.Now()))
+	} else if len(pkg.Status.BuildPhase) > 0 {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Phase:", pkg.Status.BuildPhase)
+	}
+	if len(pkg.Status.BuildTrigger) > 0 {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Trigger:", pkg.Status.BuildTrigger)
+	}
+	if len(pkg.Status.BuildLogURL) > 0 {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Log URL:", pkg.Status.BuildLogURL)
+	}
+	if start := pkg.Status.BuildStartTime; start != nil {
+		fmt.Fprintf(w, "%v\t%v (%d attempts)\n", "Build Started:", start.Time, pkg.Status.BuildAttempts)
+	}
+	if completion := pkg.Status.BuildCompletionTime; completion != nil {
+		fmt.Fprintf(w, "%v\t%v, took %v\n", "Build Completed:", completion.Time,
+			time.Duration(pkg.Status.BuildDurationSeconds)*time.Second)
+	}
+	if usage := pkg.Status.BuildResourceUsage; usage != nil {
+		fmt.Fprintf(w, "%v\tcpu %v, peak memory %v\n", "Build Resources:",
+			time.Duration(usage.CPUMilliseconds)*time.Millisecond, humanize.IBytes(uint64(usage.PeakMemoryBytes)))
+	}
 	fmt.Fprintf(w, "%v\n%v", "Build Logs:", buildlog)
 	w.Flush()
 }
+
+// builderWaitSummary describes the builder health check backoff of a
+// build waiting for its environment builder.
+func builderWaitSummary(wait *fv1.BuilderWait, now time.Time) string {
+	retry := "next retry due"
+	if next := wait.NextRetryTime; next != nil && next.Time.After(now) {
+		retry = fmt.Sprintf("next retry in %v", next.Time.Sub(now).Round(time.Second))
+	}
+	return fmt.Sprintf("waiting for builder (attempt %d/%d, %s)", wait.Attempt, wait.MaxAttempts, retry)
+}
+
+// SetPackagePending marks the package for a rebuild, the trigger tells why.
+// The builder manager marks packages whose source changed pending too, a
+// conflicting update that already did so is not an error.
+func SetPackagePending(ctx context.Context, client cmd.Client, pkg *fv1.Package, trigger fv1.BuildTrigger) (*fv1.Package, error) {
+	pkg.Status = fv1.PackageStatus{
+		BuildStatus:          fv1.BuildStatusPending,
+		BuildTrigger:         trigger,
+		BuildLogURL:          pkg.Status.BuildLogURL,
+		BuildStartTime:       pkg.Status.BuildStartTime,
+		BuildCompletionTime:  pkg.Status.BuildCompletionTime,
+		BuildDurationSeconds: pkg.Status.BuildDurationSeconds,
+		BuildAttempts:        pkg.Status.BuildAttempts,
+		BuiltSource:          pkg.Status.BuiltSource,
+		Conditions:           pkg.Status.Conditions,
+		LastUpdateTimestamp:  metav1.Time{Time: time.Now().UTC()},
+	}
+	meta.SetStatusCondition(&pkg.Status.Conditions, metav1.Condition{
+		Type:               fv1.PackageConditionBuildSucceeded,
+		Status:             metav1.ConditionUnknown,
+		Reason:             fv1.PackageReasonBuildPending,
+		Message:            "package is waiting to be built",
+		ObservedGeneration: pkg.ObjectMeta.Generation,
+	})
+	updated, err := crd.UpdatePackageStatus(ctx, client.FissionClientSet, pkg)
+	if k8serrors.IsConflict(err) {
+		latest, gerr := client.FissionClientSet.CoreV1().Packages(pkg.ObjectMeta.Namespace).Get(ctx, pkg.ObjectMeta.Name, metav1.GetOptions{})
+		if gerr == nil && latest.Status.BuildStatus == fv1.BuildStatusPending {
+			return latest, nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error setting package pending state")
+	}
+	return updated, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -32,3 +33,46 @@ func TestPrintPackageSummary(t *testing.T) {
 		t.Errorf("PrintPackageBuildLog() = %v, want %v", gotWriter, expected)
 	}
 }
+
+func TestPrintPackageSummaryResourceUsage(t *testing.T) {
+	pkg := &fv1.Package{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foobar",
+			Namespace: "dummy",
+		},
+		Status: fv1.PackageStatus{
+			BuildStatus: "succeeded",
+			BuildResourceUsage: &fv1.BuildResourceUsage{
+				CPUMilliseconds: 1500,
+				PeakMemoryBytes: 128 << 20,
+			},
+		},
+	}
+
+	writer := &bytes.Buffer{}
+	PrintPackageSummary(writer, pkg)
+
+	expected := "Build Resources: cpu 1.5s, peak memory 128 MiB\n"
+	if !strings.Contains(writer.String(), expected) {
+		t.Errorf("PrintPackageSummary() = %q, want it to contain %q", writer.String(), expected)
+	}
+}
+
+func TestBuilderWaitSummary(t *testing.T) {
+	now := time.Now()
+	wait := &fv1.BuilderWait{
+		Attempt:       4,
+		MaxAttempts:   10,
+		NextRetryTime: &metav1.Time{Time: now.Add(32 * time.Second)},
+	}
+	expected := "waiting for builder (attempt 4/10, next retry in 32s)"
+	if got := builderWaitSummary(wait, now); got != expected {
+		t.Errorf("builderWaitSummary() = %q, want %q", got, expected)
+	}
+
+	wait.NextRetryTime = &metav1.Time{Time: now.Add(-time.Second)}
+	expected = "waiting for builder (attempt 4/10, next retry due)"
+	if got := builderWaitSummary(wait, now); got != expected {
+		t.Errorf("builderWaitSummary() = %q, want %q", got, expected)
+	}
+}
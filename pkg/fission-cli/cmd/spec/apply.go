@@ -717,16 +717,19 @@ func applyPackages(ctx context.Context, fclient cmd.Client, fr *FissionResources
 					pkg = &o
 				}
 
-				// update status in order to rebuild the package again
-				if pkg.Status.BuildStatus == fv1.BuildStatusFailed {
-					pkg.Status.BuildStatus = fv1.BuildStatusPending
-				}
-
 				newmeta, err := fclient.FissionClientSet.CoreV1().Packages(pkg.ObjectMeta.Namespace).Update(ctx, pkg, metav1.UpdateOptions{})
 				if err != nil {
 					return nil, nil, err
 					// TODO check for resourceVersion conflict errors and retry
 				}
+
+				// update status in order to rebuild the package again
+				if pkg.Status.BuildStatus == fv1.BuildStatusFailed || pkg.Status.BuildStatus == fv1.BuildStatusCanceled {
+					newmeta, err = pkgutil.SetPackagePending(ctx, fclient, newmeta, fv1.BuildTriggerManualRebuild)
+					if err != nil {
+						return nil, nil, err
+					}
+				}
 				ras.Updated = append(ras.Updated, &newmeta.ObjectMeta)
 				// keep track of metadata in case we need to create a reference to it
 				metadataMap[mapKey(&o.ObjectMeta)] = newmeta.ObjectMeta
@@ -98,13 +98,14 @@ func (w *packageBuildWatcher) watch(ctx context.Context) {
 				continue
 			}
 			if pkg.Status.BuildStatus == fv1.BuildStatusFailed ||
+				pkg.Status.BuildStatus == fv1.BuildStatusCanceled ||
 				pkg.Status.BuildStatus == fv1.BuildStatusSucceeded {
 				w.finished[k] = true
 				fmt.Printf("------\n")
 				util.PrintPackageSummary(os.Stdout, &pkg)
 				fmt.Printf("------\n")
 			}
-			if pkg.Status.BuildStatus == fv1.BuildStatusFailed {
+			if pkg.Status.BuildStatus == fv1.BuildStatusFailed || pkg.Status.BuildStatus == fv1.BuildStatusCanceled {
 				os.Exit(1)
 			}
 		}
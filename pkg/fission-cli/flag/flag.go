@@ -78,6 +78,9 @@ var (
 
 	IgnoreNotFound = Flag{Type: Bool, Name: flagkey.IgnoreNotFound, Usage: "Treat \"resource not found\" as a successful delete.", DefaultValue: false}
 
+	BulkDryRun = Flag{Type: Bool, Name: flagkey.BulkDryRun, Usage: "Print the objects the command would change and how, without changing them"}
+	BulkYes    = Flag{Type: Bool, Name: flagkey.BulkYes, Usage: "Change the objects without asking for confirmation"}
+
 	Labels     = Flag{Type: String, Name: flagkey.Labels, Usage: "Comma separated labels to apply to the function. E.g. --labels=\"environment=dev,application=analytics\""}
 	Annotation = Flag{Type: StringSlice, Name: flagkey.Annotation, Usage: "Annotation to apply to the function. To mention multiple annotations --annotation=\"abc.com/team=dev\" --annotation=\"foo=bar\""}
 
@@ -200,8 +203,10 @@ var (
 	PkgEnvironment    = Flag{Type: String, Name: flagkey.PkgEnvironment, Usage: "Environment name"}
 	PkgBuildCmd       = Flag{Type: String, Name: flagkey.PkgBuildCmd, Usage: "Build command for builder to run with"}
 	PkgOutput         = Flag{Type: String, Name: flagkey.PkgOutput, Short: "o", Usage: "Output filename to save archive content"}
-	PkgStatus         = Flag{Type: String, Name: flagkey.PkgStatus, Usage: `Filter packages by status`}
+	PkgStatus         = Flag{Type: String, Name: flagkey.PkgStatus, Usage: `Filter packages by status: pending, running, succeeded, failed, canceled or none`}
 	PkgOrphan         = Flag{Type: Bool, Name: flagkey.PkgOrphan, Usage: "Orphan packages that are not referenced by any function"}
+	PkgImpact         = Flag{Type: Bool, Name: flagkey.PkgImpact, Usage: "Show the functions and triggers a rebuild of the package affects"}
+	PkgFullLog        = Flag{Type: Bool, Name: flagkey.PkgFullLog, Usage: "Download and show the complete build log from the storage service"}
 	PkgCode           = Flag{Type: String, Name: flagkey.PkgCode, Usage: "URL or local path for single file source code"}
 	PkgDeployArchive  = Flag{Type: StringSlice, Name: flagkey.PkgDeployArchive, Aliases: []string{"deploy"}, Usage: "URL or local paths for binary archive"}
 	PkgDeployChecksum = Flag{Type: String, Name: flagkey.PkgDeployChecksum, Usage: "SHA256 checksum of deploy archive when providing URL"}
@@ -209,6 +214,12 @@ var (
 	PkgSrcChecksum    = Flag{Type: String, Name: flagkey.PkgSrcChecksum, Usage: "SHA256 checksum of source archive when providing URL"}
 	PkgInsecure       = Flag{Type: Bool, Name: flagkey.PkgInsecure, Usage: "Skip generating SHA256 checksum for file integrity validation"}
 
+	PkgEnvBuilderImage  = Flag{Type: String, Name: flagkey.PkgEnvBuilderImage, Usage: "Environment builder image used to build the package"}
+	PkgContainerRuntime = Flag{Type: String, Name: flagkey.PkgContainerRuntime, Usage: "Local container runtime CLI used to run the builder image", DefaultValue: "docker"}
+
+	PkgMigrateThreshold = Flag{Type: String, Name: flagkey.PkgMigrateThreshold, Usage: "Size from which literal archives are migrated, e.g. 100KB", DefaultValue: "100KB"}
+	PkgMigrateQPS       = Flag{Type: Int, Name: flagkey.PkgMigrateQPS, Usage: "Maximum requests per second to the Kubernetes API server during the migration", DefaultValue: 5}
+
 	SpecSave             = Flag{Type: Bool, Name: flagkey.SpecSave, Usage: "Save to the spec directory instead of creating on cluster"}
 	SpecDir              = Flag{Type: String, Name: flagkey.SpecDir, Usage: "Directory to store specs, defaults to ./specs"}
 	SpecName             = Flag{Type: String, Name: flagkey.SpecName, Usage: "Name for the app, applied to resources as a Kubernetes annotation"}
@@ -33,6 +33,9 @@ const (
 
 	IgnoreNotFound = "ignorenotfound"
 
+	BulkDryRun = "dry-run"
+	BulkYes    = "yes"
+
 	NamespaceFunction    = "fnNamespace"
 	NamespaceEnvironment = "envNamespace"
 	NamespacePackage     = "pkgNamespace"
@@ -160,6 +163,14 @@ const (
 	PkgOutput         = Output
 	PkgStatus         = "status"
 	PkgOrphan         = "orphan"
+	PkgImpact         = "impact"
+	PkgFullLog        = "full-log"
+
+	PkgEnvBuilderImage  = "env-builder-image"
+	PkgContainerRuntime = "container-runtime"
+
+	PkgMigrateThreshold = "threshold"
+	PkgMigrateQPS       = "qps"
 
 	SpecSave             = "spec"
 	SpecDir              = "specdir"
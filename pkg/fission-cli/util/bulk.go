@@ -0,0 +1,185 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	"github.com/fission/fission/pkg/fission-cli/cliwrapper/cli"
+	flagkey "github.com/fission/fission/pkg/fission-cli/flag/key"
+)
+
+const (
+	// BulkConfirmThreshold is the number of objects from which a bulk
+	// operation asks for confirmation before changing them.
+	BulkConfirmThreshold = 10
+	// BulkConcurrency is the number of objects a bulk operation changes
+	// at once.
+	BulkConcurrency = 5
+)
+
+// ErrBulkAborted is returned when the user doesn't confirm a bulk operation.
+var ErrBulkAborted = errors.New("operation aborted, pass --yes to confirm it without prompt")
+
+type (
+	// BulkItem is an object changed by a bulk operation.
+	BulkItem struct {
+		Kind      string
+		Name      string
+		Namespace string
+		// Action tells what the operation does to the object.
+		Action string
+	}
+
+	// BulkResult is the outcome of a bulk operation on an object, Err
+	// is nil if the object was changed.
+	BulkResult struct {
+		Item BulkItem
+		Err  error
+	}
+
+	// BulkOperation gives the commands changing many objects at once the
+	// same safety model: a dry run prints the objects and actions without
+	// changing anything, changes to more than ConfirmThreshold objects
+	// need a confirmation unless Yes is set, and failures of single
+	// objects are reported without stopping the others.
+	BulkOperation struct {
+		// Description names the operation in the prompt and reports,
+		// e.g. "delete orphan packages".
+		Description      string
+		DryRun           bool
+		Yes              bool
+		ConfirmThreshold int
+		Concurrency      int
+		// In is read for the confirmation, Out receives the reports.
+		In  io.Reader
+		Out io.Writer
+	}
+)
+
+// NewBulkOperation returns the bulk operation with the --dry-run and
+// --yes flags of the command.
+func NewBulkOperation(input cli.Input, description string) *BulkOperation {
+	return &BulkOperation{
+		Description:      description,
+		DryRun:           input.Bool(flagkey.BulkDryRun),
+		Yes:              input.Bool(flagkey.BulkYes),
+		ConfirmThreshold: BulkConfirmThreshold,
+		Concurrency:      BulkConcurrency,
+		In:               os.Stdin,
+		Out:              os.Stdout,
+	}
+}
+
+// Confirm tells whether the items are to be changed. A dry run prints
+// them and returns false, so does an operation without items. More
+// items than the threshold are printed and need a confirmation, unless
+// Yes is set. An unconfirmed operation returns ErrBulkAborted.
+func (op *BulkOperation) Confirm(items []BulkItem) (bool, error) {
+	if len(items) == 0 {
+		fmt.Fprintf(op.Out, "Nothing to %s.\n", op.Description)
+		return false, nil
+	}
+	if op.DryRun {
+		op.printItems(items)
+		fmt.Fprintf(op.Out, "\nDry run: %s would change %d objects.\n", op.Description, len(items))
+		return false, nil
+	}
+	if op.Yes || len(items) <= op.ConfirmThreshold {
+		return true, nil
+	}
+	op.printItems(items)
+	fmt.Fprintf(op.Out, "\nAbout to %s, %d objects are changed. Continue? [y/N]: ", op.Description, len(items))
+	answer, err := bufio.NewReader(op.In).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, errors.Wrap(err, "error reading confirmation")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, ErrBulkAborted
+}
+
+// Run applies the operation to the confirmed items, at most Concurrency
+// at once, and reports the results. It returns an error if any item
+// failed.
+func (op *BulkOperation) Run(ctx context.Context, items []BulkItem, apply func(ctx context.Context, item BulkItem) error) error {
+	ok, err := op.Confirm(items)
+	if err != nil || !ok {
+		return err
+	}
+	concurrency := op.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]BulkResult, len(items))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, item BulkItem) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			results[i] = BulkResult{Item: item, Err: apply(ctx, item)}
+		}(i, item)
+	}
+	wg.Wait()
+	return op.Report(results)
+}
+
+// Report prints the results and returns an error if any item failed.
+func (op *BulkOperation) Report(results []BulkResult) error {
+	failed := 0
+	w := tabwriter.NewWriter(op.Out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", "KIND", "NAME", "NAMESPACE", "ACTION", "RESULT", "ERROR")
+	for _, r := range results {
+		result, msg := "succeeded", ""
+		if r.Err != nil {
+			failed++
+			result, msg = "failed", r.Err.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", r.Item.Kind, r.Item.Name, r.Item.Namespace, r.Item.Action, result, msg)
+	}
+	w.Flush()
+	fmt.Fprintf(op.Out, "\n%d succeeded, %d failed.\n", len(results)-failed, failed)
+	if failed > 0 {
+		return errors.Errorf("%s failed for %d of %d objects", op.Description, failed, len(results))
+	}
+	return nil
+}
+
+func (op *BulkOperation) printItems(items []BulkItem) {
+	w := tabwriter.NewWriter(op.Out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "KIND", "NAME", "NAMESPACE", "ACTION")
+	for _, item := range items {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", item.Kind, item.Name, item.Namespace, item.Action)
+	}
+	w.Flush()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func testBulkItems(n int) []BulkItem {
+	items := make([]BulkItem, n)
+	for i := range items {
+		items[i] = BulkItem{Kind: "package", Name: fmt.Sprintf("pkg-%d", i), Namespace: "default", Action: "delete"}
+	}
+	return items
+}
+
+func testBulkOperation(answer string) (*BulkOperation, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &BulkOperation{
+		Description:      "delete packages",
+		ConfirmThreshold: 2,
+		Concurrency:      2,
+		In:               strings.NewReader(answer),
+		Out:              out,
+	}, out
+}
+
+func TestBulkOperationConfirmation(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		items   int
+		answer  string
+		yes     bool
+		applied int
+		prompt  bool
+		err     error
+	}{
+		{name: "below threshold", items: 2, applied: 2},
+		{name: "confirmed", items: 3, answer: "y\n", applied: 3, prompt: true},
+		{name: "declined", items: 3, answer: "n\n", prompt: true, err: ErrBulkAborted},
+		{name: "no answer", items: 3, prompt: true, err: ErrBulkAborted},
+		{name: "yes flag", items: 3, yes: true, applied: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			op, out := testBulkOperation(test.answer)
+			op.Yes = test.yes
+			var applied int32
+			err := op.Run(context.Background(), testBulkItems(test.items), func(ctx context.Context, item BulkItem) error {
+				atomic.AddInt32(&applied, 1)
+				return nil
+			})
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected error %v, got %v", test.err, err)
+			}
+			if int(applied) != test.applied {
+				t.Errorf("Expected %d changed objects, got %d", test.applied, applied)
+			}
+			if prompt := strings.Contains(out.String(), "Continue? [y/N]"); prompt != test.prompt {
+				t.Errorf("Expected prompt %v, got output %q", test.prompt, out.String())
+			}
+		})
+	}
+}
+
+func TestBulkOperationDryRun(t *testing.T) {
+	op, out := testBulkOperation("")
+	op.DryRun = true
+	op.Yes = true
+	err := op.Run(context.Background(), testBulkItems(3), func(ctx context.Context, item BulkItem) error {
+		t.Errorf("Expected dry run not to change %s", item.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error running dry run: %v", err)
+	}
+	for _, expected := range []string{"pkg-0", "pkg-1", "pkg-2", "delete", "Dry run: delete packages would change 3 objects."} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected dry run output to contain %q, got %q", expected, out.String())
+		}
+	}
+}
+
+func TestBulkOperationCollectsFailures(t *testing.T) {
+	op, out := testBulkOperation("")
+	op.Yes = true
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	err := op.Run(context.Background(), testBulkItems(6), func(ctx context.Context, item BulkItem) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		if item.Name == "pkg-1" || item.Name == "pkg-4" {
+			return errors.New("conflict")
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed for 2 of 6 objects") {
+		t.Errorf("Expected the failures to be reported, got %v", err)
+	}
+	if maxRunning > op.Concurrency {
+		t.Errorf("Expected at most %d objects changed at once, got %d", op.Concurrency, maxRunning)
+	}
+	if !strings.Contains(out.String(), "4 succeeded, 2 failed.") {
+		t.Errorf("Expected the results summary, got %q", out.String())
+	}
+}
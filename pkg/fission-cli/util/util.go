@@ -476,6 +476,15 @@ func GetStorageURL(ctx context.Context, client cmd.Client) (*url.URL, error) {
 	return serverURL, nil
 }
 
+// GetBuilderMgrURL returns the URL of the builder manager API through a port forward.
+func GetBuilderMgrURL(ctx context.Context, client cmd.Client) (string, error) {
+	localPort, err := SetupPortForward(ctx, client, GetFissionNamespace(), "application=fission-buildermgr")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s", localhostURL, localPort), nil
+}
+
 // CheckHTTPTriggerDuplicates checks whether the tuple (Method, Host, URL) is duplicate or not.
 func CheckHTTPTriggerDuplicates(ctx context.Context, client cmd.Client, t *fv1.HTTPTrigger) error {
 	triggers, err := client.FissionClientSet.CoreV1().HTTPTriggers(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
@@ -17,6 +17,8 @@ limitations under the License.
 package util
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -72,3 +74,24 @@ func TestGetEnvVarFromStringSlice(t *testing.T) {
 // 	}
 // 	t.Log("Current NS: ", response)
 // }
+
+func FuzzSpecIgnoreParser(f *testing.F) {
+	f.Add("# comment\nfunction.yaml\n", "specs/function.yaml")
+	f.Add("*.yaml\n!env.yaml\n", "env.yaml")
+	f.Add("/**/\n**/tmp/**\nfoo/*.bar\n", "a/tmp/b")
+	f.Add("!\n!#\n\\*\n[\n(\n", "[")
+	f.Add("\r\n  \n", "")
+
+	f.Fuzz(func(t *testing.T, specIgnore string, path string) {
+		specDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(specDir, SPEC_IGNORE_FILE), []byte(specIgnore), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser, err := GetSpecIgnoreParser(specDir, SPEC_IGNORE_FILE)
+		if err != nil {
+			t.Fatalf("Error reading spec ignore file %q: %v", specIgnore, err)
+		}
+		parser.MatchesPath(path)
+	})
+}
@@ -102,6 +102,18 @@ func (c *FakeEnvironments) Update(ctx context.Context, _environment *corev1.Envi
 	return obj.(*corev1.Environment), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeEnvironments) UpdateStatus(ctx context.Context, _environment *corev1.Environment, opts v1.UpdateOptions) (*corev1.Environment, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(environmentsResource, "status", c.ns, _environment), &corev1.Environment{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*corev1.Environment), err
+}
+
 // Delete takes name of the _environment and deletes it. Returns an error if one occurs.
 func (c *FakeEnvironments) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -17,8 +17,10 @@ limitations under the License.
 package client
 
 import (
-	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -35,6 +37,9 @@ import (
 	"github.com/fission/fission/pkg/storagesvc"
 )
 
+// ErrArchiveNotFound is returned for archives the storage service doesn't have.
+var ErrArchiveNotFound = errors.New("archive not found")
+
 type (
 	Client struct {
 		url        string
@@ -51,64 +56,206 @@ func MakeClient(url string) *Client {
 	}
 }
 
+type (
+	// UploadOptions describe how a file is stored.
+	UploadOptions struct {
+		// ContentType of the uploaded file, e.g. application/zip.
+		ContentType string
+		// Compression is one of storagesvc.CompressionAuto, CompressionAlways
+		// or CompressionNever. Empty means CompressionNever.
+		Compression string
+		// CompressionLevel is a gzip compression level, 0 means the default level.
+		CompressionLevel int
+		// Target is the name of the storage target receiving the file,
+		// empty means the default storage.
+		Target string
+	}
+
+	// UploadResult describes a stored file.
+	UploadResult struct {
+		ID           string
+		OriginalSize int64
+		StoredSize   int64
+		Compressed   bool
+		// StoredSHA256 is the hex encoded sha256 checksum of the
+		// compressed file, set for compressed files only.
+		StoredSHA256 string
+	}
+
+	// UploadError is the error of an upload the storage service answered
+	// with an error status.
+	UploadError struct {
+		StatusCode int
+		Message    string
+	}
+)
+
+func (e *UploadError) Error() string {
+	return e.Message
+}
+
+// IsUploadUnavailable reports whether the upload failed because the
+// storage service couldn't be reached or failed with a server error, the
+// upload may succeed once retried. Uploads the storage service rejected
+// with a client error fail the same way again.
+func IsUploadUnavailable(err error) bool {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.StatusCode >= http.StatusInternalServerError
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
 // Upload sends the local file pointed to by filePath to the storage
 // service, along with the metadata.  It returns a file ID that can be
 // used to retrieve the file.
 func (c *Client) Upload(ctx context.Context, filePath string, metadata *map[string]string) (string, error) {
-	fi, err := os.Stat(filePath)
+	result, err := c.UploadWithOptions(ctx, filePath, UploadOptions{})
 	if err != nil {
 		return "", err
 	}
-	fileSize := fi.Size()
+	return result.ID, nil
+}
 
-	buf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(buf)
-	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", filePath)
+// UploadWithOptions sends the local file pointed to by filePath to the
+// storage service, gzip compressing it first if requested. Compressed files
+// are transparently decompressed when downloaded.
+func (c *Client) UploadWithOptions(ctx context.Context, filePath string, opts UploadOptions) (*UploadResult, error) {
+	fi, err := os.Stat(filePath)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	result := &UploadResult{
+		OriginalSize: fi.Size(),
+		StoredSize:   fi.Size(),
 	}
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		return "", err
+	level := opts.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	compress := false
+	switch opts.Compression {
+	case "", storagesvc.CompressionNever:
+	case storagesvc.CompressionAlways:
+		compress = true
+	case storagesvc.CompressionAuto:
+		compress, err = storagesvc.IsCompressible(filePath, level)
+		if err != nil {
+			return nil, errors.Wrap(err, "error sampling file compressibility")
+		}
+	default:
+		return nil, errors.Errorf("unsupported compression %q", opts.Compression)
 	}
 
-	_, err = io.Copy(fileWriter, f)
-	if err != nil {
-		return "", err
+	uploadPath := filePath
+	if compress {
+		uploadPath = filePath + ".gz"
+		err = storagesvc.CompressFile(filePath, uploadPath, level)
+		if err != nil {
+			os.Remove(uploadPath)
+			return nil, err
+		}
+		defer os.Remove(uploadPath)
+
+		zfi, err := os.Stat(uploadPath)
+		if err != nil {
+			return nil, err
+		}
+		result.StoredSize = zfi.Size()
+		result.Compressed = true
+		result.StoredSHA256, err = fileSHA256(uploadPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "error calculating checksum of compressed file")
+		}
 	}
 
-	contentType := bodyWriter.FormDataContentType()
-	bodyWriter.Close()
+	f, err := os.Open(uploadPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	req, err := http.NewRequest(http.MethodPost, c.url+"/archive", buf)
+	// the multipart body is streamed from the file in chunks, large
+	// archives aren't held in memory. The transport closes the body once
+	// the request is done, which stops the writer on failed requests.
+	body, bodyWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(bodyWriter)
+	contentType := multipartWriter.FormDataContentType()
+	go func() {
+		bodyWriter.CloseWithError(writeMultipartFile(multipartWriter, filePath, f))
+	}()
+
+	uploadUrl := c.url + "/archive"
+	if len(opts.Target) > 0 {
+		uploadUrl += "?" + storagesvc.QueryParamTarget + "=" + url.QueryEscape(opts.Target)
+	}
+	req, err := http.NewRequest(http.MethodPost, uploadUrl, body)
 	if err != nil {
-		return "", err
+		body.Close()
+		return nil, err
 	}
-	req.Header["X-File-Size"] = []string{fmt.Sprintf("%v", fileSize)}
+	req.Header["X-File-Size"] = []string{fmt.Sprintf("%v", result.StoredSize)}
 	req.Header["Content-Type"] = []string{contentType}
+	if len(opts.ContentType) > 0 {
+		req.Header.Set(storagesvc.HeaderFileContentType, opts.ContentType)
+	}
+	if result.Compressed {
+		req.Header.Set(storagesvc.HeaderFileEncoding, storagesvc.EncodingGzip)
+	}
 
 	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		msg := fmt.Sprintf("Upload error %v", resp.Status)
-		return "", errors.New(msg)
+		msg := fmt.Sprintf("Upload error %v: %v", resp.Status, strings.TrimSpace(string(respBody)))
+		return nil, &UploadError{StatusCode: resp.StatusCode, Message: msg}
 	}
 
 	var ur storagesvc.UploadResponse
-	err = json.Unmarshal(body, &ur)
+	err = json.Unmarshal(respBody, &ur)
+	if err != nil {
+		return nil, err
+	}
+	result.ID = ur.ID
+
+	return result, nil
+}
+
+// fileSHA256 returns the hex encoded sha256 checksum of the file.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
 	if err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	return ur.ID, nil
+// writeMultipartFile writes the file as the upload file part of the
+// multipart body, and ends the body.
+func writeMultipartFile(w *multipart.Writer, filePath string, f io.Reader) error {
+	part, err := w.CreateFormFile("uploadfile", filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	if err != nil {
+		return err
+	}
+	return w.Close()
 }
 
 // GetUrl returns an HTTP URL that can be used to download the file pointed to by ID
@@ -116,6 +263,51 @@ func (c *Client) GetUrl(id string) string {
 	return fmt.Sprintf("%v/archive?id=%v", c.url, url.PathEscape(id))
 }
 
+// GetTargetUrl returns an HTTP URL that can be used to download the file
+// pointed to by ID from the given storage target.
+func (c *Client) GetTargetUrl(id string, target string) string {
+	if len(target) == 0 {
+		return c.GetUrl(id)
+	}
+	return fmt.Sprintf("%v&%v=%v", c.GetUrl(id), storagesvc.QueryParamTarget, url.QueryEscape(target))
+}
+
+// ArchiveID returns the ID and storage target of the archive the URL points
+// to, ok is false if the URL isn't an archive URL of this storage service.
+func (c *Client) ArchiveID(archiveURL string) (id string, target string, ok bool) {
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return "", "", false
+	}
+	base, err := url.Parse(c.url + "/archive")
+	if err != nil || u.Scheme != base.Scheme || u.Host != base.Host || u.Path != base.Path {
+		return "", "", false
+	}
+	id = u.Query().Get("id")
+	return id, u.Query().Get(storagesvc.QueryParamTarget), len(id) > 0
+}
+
+// Stat looks up the metadata of the archive identified by ID in the given
+// storage target, it returns ErrArchiveNotFound if the archive is missing.
+func (c *Client) Stat(ctx context.Context, id string, target string) error {
+	req, err := http.NewRequest(http.MethodHead, c.GetTargetUrl(id, target), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errors.Wrap(ErrArchiveNotFound, id)
+	}
+	return errors.Errorf("HTTP error %v", resp.StatusCode)
+}
+
 func (c *Client) List(ctx context.Context) ([]string, error) {
 	req, err := http.NewRequest(http.MethodGet, c.url+"/archive", nil)
 	if err != nil {
@@ -188,8 +380,13 @@ func (c *Client) Download(ctx context.Context, id string, filePath string) error
 // Download fetches the file identified by ID to the local file path.
 // filePath must not exist.
 func (c *Client) GetFile(ctx context.Context, id string) (resp *http.Response, err error) {
+	return c.GetTargetFile(ctx, id, "")
+}
+
+// GetTargetFile fetches the file identified by ID from the given storage target.
+func (c *Client) GetTargetFile(ctx context.Context, id string, target string) (resp *http.Response, err error) {
 	// url for id
-	url := c.GetUrl(id)
+	url := c.GetTargetUrl(id, target)
 
 	// make request
 	resp, err = ctxhttp.Get(ctx, c.httpClient, url)
@@ -201,7 +398,12 @@ func (c *Client) GetFile(ctx context.Context, id string) (resp *http.Response, e
 }
 
 func (c *Client) Delete(ctx context.Context, id string) error {
-	url := c.GetUrl(id)
+	return c.DeleteTarget(ctx, id, "")
+}
+
+// DeleteTarget deletes the file identified by ID from the given storage target.
+func (c *Client) DeleteTarget(ctx context.Context, id string, target string) error {
+	url := c.GetTargetUrl(id, target)
 
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
@@ -19,6 +19,7 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -258,10 +259,24 @@ func TestLocalStorageService(t *testing.T) {
 		log.Panic("Contents don't match")
 	}
 
+	// look it up by its URL
+	id, _, ok := client.ArchiveID(client.GetUrl(fileID))
+	if !ok || id != fileID {
+		t.Fatalf("Expected archive ID %v of its URL, got %v", fileID, id)
+	}
+	err = client.Stat(ctx, fileID, "")
+	failTest(t, err)
+
 	// delete uploaded file
 	err = client.Delete(ctx, fileID)
 	failTest(t, err)
 
+	// make sure it's reported missing
+	err = client.Stat(ctx, fileID, "")
+	if !errors.Is(err, ErrArchiveNotFound) {
+		t.Fatalf("Expected deleted archive to be missing, got %v", err)
+	}
+
 	// make sure download fails
 	err = client.Download(ctx, fileID, "xxx")
 	if err == nil {
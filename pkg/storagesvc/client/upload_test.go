@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fission/fission/pkg/storagesvc"
+)
+
+func TestUploadStreamsLargeArchive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads a large archive")
+	}
+	const size = 300 << 20
+	path := filepath.Join(t.TempDir(), "deploy.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sparse file, the archive doesn't take any disk space
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received int64
+	var chunked bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunked = r.ContentLength < 0
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil || part.FormName() != "uploadfile" {
+			http.Error(w, "missing upload file", http.StatusBadRequest)
+			return
+		}
+		received, err = io.Copy(io.Discard, part)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = json.NewEncoder(w).Encode(storagesvc.UploadResponse{ID: "deploy"})
+		if err != nil {
+			t.Errorf("Error encoding upload response: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result, err := MakeClient(srv.URL).UploadWithOptions(context.Background(), path,
+		UploadOptions{Compression: storagesvc.CompressionNever})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("Error uploading archive: %v", err)
+	}
+	if result.ID != "deploy" || result.OriginalSize != size || received != size {
+		t.Errorf("Expected the %d bytes archive uploaded, got %+v with %d bytes received", size, result, received)
+	}
+	if !chunked {
+		t.Errorf("Expected a chunked upload")
+	}
+	// client and server copy the archive through small buffers, it's
+	// never held in memory
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 32<<20 {
+		t.Errorf("Expected the upload to allocate well below the archive size, allocated %d bytes", allocated)
+	}
+}
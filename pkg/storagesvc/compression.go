@@ -0,0 +1,204 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Archives can be stored gzip compressed. The encoding and the content type
+// are recorded as suffix of the archive name, so that they are known when
+// the archive is downloaded without the need for backend metadata support,
+// e.g. "<uuid>.zip.gz". The archive checksum is always computed on the
+// uncompressed archive, downloads are decompressed before verification.
+const (
+	// Compression modes for uploads
+	CompressionAuto   = "auto"
+	CompressionAlways = "always"
+	CompressionNever  = "never"
+
+	EncodingGzip = "gzip"
+
+	// headers sent with an upload to describe the file
+	HeaderFileEncoding    = "X-File-Encoding"
+	HeaderFileContentType = "X-File-Content-Type"
+
+	// HeaderChecksumRequest asks archive info requests for the checksum
+	// of the uncompressed archive, which is returned in HeaderChecksumSHA256
+	// along with its size in bytes in HeaderSize.
+	HeaderChecksumRequest = "X-Fission-Checksum"
+	HeaderChecksumSHA256  = "X-Fission-Checksum-Sha256"
+	HeaderSize            = "X-Fission-Size"
+	ChecksumSHA256        = "sha256"
+	// HeaderStoredSize is the size in bytes of the archive as stored,
+	// compressed or not, returned by all archive info requests.
+	HeaderStoredSize = "X-Fission-Stored-Size"
+
+	gzipSuffix = ".gz"
+
+	// compressionSampleSize is the amount of data, spread over the
+	// file, compressed to decide whether compressing the file is worth it.
+	compressionSampleSize   = 256 * 1024
+	compressionSampleChunks = 4
+	// minCompressionSaving is the minimum size reduction of the sample
+	// for a file to be compressed in auto mode.
+	minCompressionSaving = 0.1
+)
+
+// archiveContentTypes maps the name suffix of stored archives to their content type.
+var archiveContentTypes = map[string]string{
+	".zip": "application/zip",
+	".jar": "application/java-archive",
+	".tar": "application/x-tar",
+	".bin": "application/octet-stream",
+}
+
+// ContentTypeSuffix returns the archive name suffix for the content type.
+func ContentTypeSuffix(contentType string) (string, bool) {
+	for suffix, ct := range archiveContentTypes {
+		if ct == contentType {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+// ArchiveEncoding returns the content type and encoding of a stored archive from its ID.
+// Archives stored before encodings were recorded have neither.
+func ArchiveEncoding(id string) (contentType string, encoding string) {
+	if strings.HasSuffix(id, gzipSuffix) {
+		encoding = EncodingGzip
+		id = strings.TrimSuffix(id, gzipSuffix)
+	}
+	for suffix, ct := range archiveContentTypes {
+		if strings.HasSuffix(id, suffix) {
+			contentType = ct
+			break
+		}
+	}
+	return contentType, encoding
+}
+
+// archiveNameSuffix returns the suffix of the stored archive name for the
+// content type and encoding of an upload.
+func archiveNameSuffix(contentType string, encoding string) (string, error) {
+	var suffix string
+	if len(contentType) > 0 {
+		s, ok := ContentTypeSuffix(contentType)
+		if !ok {
+			return "", errors.Errorf("unsupported content type %q", contentType)
+		}
+		suffix = s
+	}
+	switch encoding {
+	case "":
+	case EncodingGzip:
+		suffix += gzipSuffix
+	default:
+		return "", errors.Errorf("unsupported encoding %q", encoding)
+	}
+	return suffix, nil
+}
+
+// IsCompressible compresses samples spread over the file and reports
+// whether they shrink enough to be worth compressing the whole file.
+// Already compressed files, e.g. zip archives of deflated files, don't.
+func IsCompressible(path string, level int) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() == 0 {
+		return false, nil
+	}
+
+	chunkSize := int64(compressionSampleSize / compressionSampleChunks)
+	counter := &countingWriter{}
+	zw, err := gzip.NewWriterLevel(counter, level)
+	if err != nil {
+		return false, err
+	}
+	var sampled, end int64
+	for i := int64(0); i < compressionSampleChunks; i++ {
+		offset := fi.Size() * i / compressionSampleChunks
+		if offset < end {
+			// small files are sampled entirely by the first chunks
+			continue
+		}
+		n, err := io.Copy(zw, io.NewSectionReader(f, offset, chunkSize))
+		if err != nil {
+			return false, err
+		}
+		end = offset + n
+		sampled += n
+	}
+	err = zw.Close()
+	if err != nil {
+		return false, err
+	}
+	return float64(counter.n) <= float64(sampled)*(1-minCompressionSaving), nil
+}
+
+// CompressFile writes the gzip compressed content of src to dst.
+func CompressFile(src string, dst string, level int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zw, in)
+	if err != nil {
+		return errors.Wrap(err, "error compressing file")
+	}
+	err = zw.Close()
+	if err != nil {
+		return errors.Wrap(err, "error compressing file")
+	}
+	return out.Sync()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+package storagesvc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveEncoding(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		encoding    string
+		id          string
+	}{
+		{"", "", "archive"},
+		{"application/zip", "", "archive.zip"},
+		{"application/zip", EncodingGzip, "archive.zip.gz"},
+		{"", EncodingGzip, "archive.gz"},
+	} {
+		suffix, err := archiveNameSuffix(test.contentType, test.encoding)
+		if err != nil {
+			t.Fatalf("error getting suffix of %q %q: %v", test.contentType, test.encoding, err)
+		}
+		id := "archive" + suffix
+		if id != test.id {
+			t.Errorf("Incorrect archive name. Got: %s, Want %s", id, test.id)
+		}
+		contentType, encoding := ArchiveEncoding(id)
+		if contentType != test.contentType || encoding != test.encoding {
+			t.Errorf("Incorrect archive encoding of %s. Got: %q %q, Want %q %q",
+				id, contentType, encoding, test.contentType, test.encoding)
+		}
+	}
+
+	if _, err := archiveNameSuffix("text/plain", ""); err == nil {
+		t.Error("expected error for unsupported content type")
+	}
+	if _, err := archiveNameSuffix("", "br"); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
+
+func FuzzArchiveEncoding(f *testing.F) {
+	for _, id := range []string{"", "archive", "archive.zip", "archive.zip.gz", "archive.gz", "archive.tar.gz", ".gz.zip", "archive.zip.gz.gz"} {
+		f.Add(id)
+	}
+	f.Fuzz(func(t *testing.T, id string) {
+		contentType, encoding := ArchiveEncoding(id)
+		// the archive of another upload with the same content type
+		// and encoding must get them back from its name
+		suffix, err := archiveNameSuffix(contentType, encoding)
+		if err != nil {
+			t.Fatalf("error getting suffix of %q %q from %q: %v", contentType, encoding, id, err)
+		}
+		if !strings.HasSuffix(id, suffix) {
+			t.Errorf("Expected %q to end with %q", id, suffix)
+		}
+		gotContentType, gotEncoding := ArchiveEncoding("archive" + suffix)
+		if gotContentType != contentType || gotEncoding != encoding {
+			t.Errorf("Incorrect archive encoding of %q. Got: %q %q, Want %q %q",
+				"archive"+suffix, gotContentType, gotEncoding, contentType, encoding)
+		}
+	})
+}
+
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+
+	text := filepath.Join(dir, "text")
+	content := bytes.Repeat([]byte("fission function source "), 100000)
+	err := os.WriteFile(text, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := filepath.Join(dir, "random")
+	data := make([]byte, 1024*1024)
+	_, err = rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(random, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressible, err := IsCompressible(text, gzip.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compressible {
+		t.Error("expected repetitive text to be compressible")
+	}
+	compressible, err = IsCompressible(random, gzip.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressible {
+		t.Error("expected random data not to be compressible")
+	}
+
+	dst := text + gzipSuffix
+	err = CompressFile(text, dst, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("decompressed file doesn't match the original")
+	}
+}
@@ -41,6 +41,25 @@ func NewS3Storage(args ...string) Storage {
 	}
 }
 
+// newS3StorageFromConfig returns the s3 storage of a storage target,
+// missing settings are taken from the default s3 storage.
+func newS3StorageFromConfig(cfg StorageTargetConfig) Storage {
+	storage := NewS3Storage().(s3Storage)
+	storage.bucketName = cfg.BucketName
+	storage.subDir = cfg.SubDir
+	if len(cfg.EndPoint) > 0 {
+		storage.endpoint = cfg.EndPoint
+	}
+	if len(cfg.Region) > 0 {
+		storage.region = cfg.Region
+	}
+	if len(cfg.AccessKeyID) > 0 {
+		storage.accessKeyID = cfg.AccessKeyID
+		storage.secretAccessKey = cfg.SecretAccessKey
+	}
+	return storage
+}
+
 func (ss s3Storage) getStorageType() StorageType {
 	return ss.storageType
 }
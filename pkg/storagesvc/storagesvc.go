@@ -18,11 +18,15 @@ package storagesvc
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -49,7 +53,9 @@ type (
 	StorageService struct {
 		logger        *zap.Logger
 		storageClient *StowClient
-		port          int
+		// targets are the storage clients of the named storage targets
+		targets map[string]*StowClient
+		port    int
 	}
 
 	UploadResponse struct {
@@ -68,10 +74,14 @@ func getStorageLocation(config *storageConfig) (stow.Location, error) {
 
 func (ss *StorageService) listItems(w http.ResponseWriter, r *http.Request) {
 	logger := otelUtils.LoggerWithTraceID(r.Context(), ss.logger)
+	storageClient, ok := ss.targetClient(w, r)
+	if !ok {
+		return
+	}
 	// get all archives on storage
 	// out of them, there may be some just created but not referenced by packages yet.
 	// need to filter them out.
-	archivesInStorage, err := ss.storageClient.getItemIDsWithFilter(ss.storageClient.filterAllItems, false)
+	archivesInStorage, err := storageClient.getItemIDsWithFilter(storageClient.filterAllItems, false)
 	if err != nil {
 		logger.Error("error getting items from storage", zap.Error(err))
 		return
@@ -97,10 +107,17 @@ func (ss *StorageService) listItems(w http.ResponseWriter, r *http.Request) {
 func (ss *StorageService) uploadHandler(w http.ResponseWriter, r *http.Request) {
 	logger := otelUtils.LoggerWithTraceID(r.Context(), ss.logger)
 
-	// handle upload
+	storageClient, ok := ss.targetClient(w, r)
+	if !ok {
+		return
+	}
+
+	// handle upload, the uploaded file is spilled to a temporary file
+	// rather than held in memory
 	err := r.ParseMultipartForm(0)
 	if err != nil {
 		http.Error(w, "failed to parse request", http.StatusBadRequest)
+		return
 	}
 	file, handler, err := r.FormFile("uploadfile")
 	if err != nil {
@@ -132,11 +149,20 @@ func (ss *StorageService) uploadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	suffix, err := archiveNameSuffix(r.Header.Get(HeaderFileContentType), r.Header.Get(HeaderFileEncoding))
+	if err != nil {
+		logger.Error("error parsing file metadata headers",
+			zap.Error(err),
+			zap.String("filename", handler.Filename))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// TODO: allow headers to add more metadata (e.g. environment and function metadata)
 	logger.Debug("handling upload",
 		zap.String("filename", handler.Filename))
 
-	id, err := ss.storageClient.putFile(file, int64(fileSize))
+	id, err := storageClient.putFile(file, int64(fileSize), suffix)
 	if err != nil {
 		logger.Error("error saving uploaded file",
 			zap.Error(err),
@@ -188,12 +214,17 @@ func (ss *StorageService) deleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	filesize, err := ss.storageClient.getFileSize(fileId)
+	storageClient, ok := ss.targetClient(w, r)
+	if !ok {
+		return
+	}
+
+	filesize, err := storageClient.getFileSize(fileId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
 
-	err = ss.storageClient.removeFileByID(fileId)
+	err = storageClient.removeFileByID(fileId)
 	if err != nil {
 		msg := fmt.Sprintf("Error deleting item: %v", err)
 		http.Error(w, msg, http.StatusInternalServerError)
@@ -215,9 +246,29 @@ func (ss *StorageService) downloadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	storageClient, ok := ss.targetClient(w, r)
+	if !ok {
+		return
+	}
+
+	// Compressed archives are sent as is to clients accepting gzip,
+	// which decompress them transparently, and decompressed otherwise.
+	contentType, encoding := ArchiveEncoding(fileId)
+	if len(contentType) > 0 {
+		w.Header().Set("Content-Type", contentType)
+	}
+	decompress := false
+	if encoding == EncodingGzip {
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", EncodingGzip)
+		} else {
+			decompress = true
+		}
+	}
+
 	// Get the file (called "item" in stow's jargon), open it,
 	// stream it to response
-	err = ss.storageClient.copyFileToStream(fileId, w)
+	err = storageClient.copyFileToStream(fileId, w, decompress)
 	if err != nil {
 		logger.Error("error getting file from storage client", zap.Error(err), zap.String("file_id", fileId))
 		if err == ErrNotFound {
@@ -233,6 +284,19 @@ func (ss *StorageService) downloadHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// acceptsGzip reports whether the client accepts gzip content encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+			if enc == EncodingGzip || enc == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (ss *StorageService) infoHandler(w http.ResponseWriter, r *http.Request) {
 
 	fileID, err := ss.getIdFromRequest(r)
@@ -241,27 +305,61 @@ func (ss *StorageService) infoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = ss.storageClient.container.Item(fileID)
+	storageClient, ok := ss.targetClient(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := storageClient.container.Item(fileID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if size, err := item.Size(); err == nil {
+		w.Header().Set(HeaderStoredSize, strconv.FormatInt(size, 10))
+	}
 
-	storageType := ss.storageClient.config.storage.getStorageType()
+	storageType := storageClient.config.storage.getStorageType()
 	if storageType == StorageTypeS3 {
-		w.Header().Add("X-FISSION-BUCKET", ss.storageClient.config.storage.getContainerName())
+		w.Header().Add("X-FISSION-BUCKET", storageClient.config.storage.getContainerName())
 	}
 	w.Header().Add("X-FISSION-STORAGETYPE", string(storageType))
+
+	// reading the whole archive proves it can be downloaded
+	if r.Header.Get(HeaderChecksumRequest) == ChecksumSHA256 {
+		h := sha256.New()
+		size := &countingWriter{}
+		_, encoding := ArchiveEncoding(fileID)
+		err = storageClient.copyFileToStream(fileID, io.MultiWriter(h, size), encoding == EncodingGzip)
+		if err != nil {
+			ss.logger.Error("error reading archive for checksum", zap.String("archive_id", fileID), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set(HeaderChecksumSHA256, hex.EncodeToString(h.Sum(nil)))
+		w.Header().Set(HeaderSize, strconv.FormatInt(size.n, 10))
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
 }
 
 func (ss *StorageService) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func MakeStorageService(logger *zap.Logger, storageClient *StowClient, port int) *StorageService {
+func MakeStorageService(logger *zap.Logger, storageClient *StowClient, targets map[string]*StowClient, port int) *StorageService {
 	return &StorageService{
 		logger:        logger.Named("storage_service"),
 		storageClient: storageClient,
+		targets:       targets,
 		port:          port,
 	}
 }
@@ -293,8 +391,24 @@ func Start(ctx context.Context, logger *zap.Logger, storage Storage, port int) e
 		return errors.Wrap(err, "Error creating stowClient")
 	}
 
+	// create storage clients of the storage targets
+	targetStorages, err := LoadStorageTargets(StorageTargetsPath, localTargetsPath)
+	if err != nil {
+		return errors.Wrap(err, "Error loading storage targets")
+	}
+	targets := make(map[string]*StowClient, len(targetStorages))
+	for name, targetStorage := range targetStorages {
+		targets[name], err = MakeStowClient(logger.With(zap.String("storage_target", name)), targetStorage)
+		if err != nil {
+			return errors.Wrapf(err, "Error creating stowClient for storage target %q", name)
+		}
+		logger.Info("storage target configured",
+			zap.String("storage_target", name),
+			zap.String("storage_type", string(targetStorage.getStorageType())))
+	}
+
 	// create http handlers
-	storageService := MakeStorageService(logger, storageClient, port)
+	storageService := MakeStorageService(logger, storageClient, targets, port)
 	go metrics.ServeMetrics(ctx, logger)
 	go storageService.Start(ctx, port)
 
@@ -305,11 +419,15 @@ func Start(ctx context.Context, logger *zap.Logger, storage Storage, port int) e
 		if err != nil {
 			pruneInterval = defaultPruneInterval
 		}
-		pruner, err := MakeArchivePruner(logger, storageClient, time.Duration(pruneInterval))
-		if err != nil {
-			return errors.Wrap(err, "Error creating archivePruner")
+		// archive IDs are unique across targets, the archives referenced
+		// by packages of other targets don't get in the way of pruning.
+		for _, client := range append([]*StowClient{storageClient}, storageService.targetClients()...) {
+			pruner, err := MakeArchivePruner(logger, client, time.Duration(pruneInterval))
+			if err != nil {
+				return errors.Wrap(err, "Error creating archivePruner")
+			}
+			go pruner.Start(ctx)
 		}
-		go pruner.Start(ctx)
 	}
 
 	logger.Info("storage service started")
@@ -17,6 +17,7 @@ limitations under the License.
 package storagesvc
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -131,12 +132,14 @@ func MakeStowClient(logger *zap.Logger, storage Storage) (*StowClient, error) {
 	return stowClient, nil
 }
 
-// putFile writes the file on the storage
-func (client *StowClient) putFile(file multipart.File, fileSize int64) (string, error) {
+// putFile writes the file on the storage, suffix is appended to the
+// name of the stored file.
+func (client *StowClient) putFile(file multipart.File, fileSize int64, suffix string) (string, error) {
 	uploadName, err := client.config.storage.getUploadFileName()
 	if err != nil {
 		return "", err
 	}
+	uploadName += suffix
 
 	// save the file to the storage backend
 	item, err := client.container.Put(uploadName, file, fileSize, nil)
@@ -151,8 +154,9 @@ func (client *StowClient) putFile(file multipart.File, fileSize int64) (string,
 	return item.ID(), nil
 }
 
-// copyFileToStream gets the file contents into a stream
-func (client *StowClient) copyFileToStream(fileId string, w io.Writer) error {
+// copyFileToStream gets the file contents into a stream, decompressing
+// gzip encoded files if decompress is set.
+func (client *StowClient) copyFileToStream(fileId string, w io.Writer, decompress bool) error {
 	item, err := client.container.Item(fileId)
 	if err != nil {
 		if err == stow.ErrNotFound {
@@ -168,7 +172,17 @@ func (client *StowClient) copyFileToStream(fileId string, w io.Writer) error {
 	}
 	defer f.Close()
 
-	_, err = io.Copy(w, f)
+	var r io.Reader = f
+	if decompress {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return ErrOpeningItem
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	_, err = io.Copy(w, r)
 	if err != nil {
 		return ErrWritingFileIntoResponse
 	}
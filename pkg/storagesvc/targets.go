@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// Archives can be stored in named storage targets in addition to the default
+// storage, e.g. to keep the artifacts of different teams in different buckets.
+// The target is selected with the "target" query parameter of the archive API
+// and is part of the archive URL. Archive URLs without target, including those
+// of archives uploaded before targets existed, refer to the default storage.
+const (
+	// StorageTargetsPath is the file listing the storage targets.
+	StorageTargetsPath = "/etc/fission/storage-targets.yaml"
+
+	// QueryParamTarget is the archive API query parameter selecting the storage target.
+	QueryParamTarget = "target"
+
+	// ReasonStorageTargetUnknown is reported for requests to a target that isn't configured.
+	ReasonStorageTargetUnknown = "StorageTargetUnknown"
+
+	// localTargetsPath is the root directory of local storage targets.
+	localTargetsPath = "/fission"
+)
+
+type (
+	// StorageTargetConfig describes a named storage target. Credentials of s3
+	// targets default to the ones of the default s3 storage.
+	StorageTargetConfig struct {
+		Name            string      `json:"name"`
+		Type            StorageType `json:"type"`
+		BucketName      string      `json:"bucketName,omitempty"`
+		SubDir          string      `json:"subDir,omitempty"`
+		Region          string      `json:"region,omitempty"`
+		EndPoint        string      `json:"endPoint,omitempty"`
+		AccessKeyID     string      `json:"accessKeyId,omitempty"`
+		SecretAccessKey string      `json:"secretAccessKey,omitempty"`
+	}
+
+	storageTargetsFile struct {
+		Targets []StorageTargetConfig `json:"targets"`
+	}
+
+	// ErrStorageTargetUnknown is returned for a target that isn't configured.
+	ErrStorageTargetUnknown struct {
+		Target string
+	}
+)
+
+func (e ErrStorageTargetUnknown) Error() string {
+	return fmt.Sprintf("%s: storage target %q is not configured", ReasonStorageTargetUnknown, e.Target)
+}
+
+// LoadStorageTargets reads the storage targets from the file at path, a
+// missing file means no targets. localPath is the root of local targets.
+func LoadStorageTargets(path string, localPath string) (map[string]Storage, error) {
+	targets := make(map[string]Storage)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return targets, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error reading storage targets from %s", path)
+	}
+
+	var file storageTargetsFile
+	err = yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing storage targets from %s", path)
+	}
+
+	for _, cfg := range file.Targets {
+		if len(cfg.Name) == 0 {
+			return nil, errors.New("storage target without name")
+		}
+		if _, ok := targets[cfg.Name]; ok {
+			return nil, errors.Errorf("duplicate storage target %q", cfg.Name)
+		}
+		switch cfg.Type {
+		case StorageTypeS3:
+			if len(cfg.BucketName) == 0 {
+				return nil, errors.Errorf("storage target %q has no bucket name", cfg.Name)
+			}
+			targets[cfg.Name] = newS3StorageFromConfig(cfg)
+		case StorageTypeLocal:
+			containerName := cfg.SubDir
+			if len(containerName) == 0 {
+				containerName = cfg.Name
+			}
+			targets[cfg.Name] = localStorage{
+				storageType:   StorageTypeLocal,
+				containerName: containerName,
+				localPath:     localPath,
+			}
+		default:
+			return nil, errors.Errorf("storage target %q has unsupported type %q", cfg.Name, cfg.Type)
+		}
+	}
+	return targets, nil
+}
+
+// clientForRequest returns the storage client of the target selected by the
+// request, the default storage if there is none.
+func (ss *StorageService) clientForRequest(r *http.Request) (*StowClient, error) {
+	target := r.URL.Query().Get(QueryParamTarget)
+	if len(target) == 0 {
+		return ss.storageClient, nil
+	}
+	client, ok := ss.targets[target]
+	if !ok {
+		return nil, ErrStorageTargetUnknown{Target: target}
+	}
+	return client, nil
+}
+
+// targetClient is clientForRequest for handlers, it responds with an error
+// for unknown targets.
+func (ss *StorageService) targetClient(w http.ResponseWriter, r *http.Request) (*StowClient, bool) {
+	client, err := ss.clientForRequest(r)
+	if err != nil {
+		ss.logger.Error("request for unknown storage target", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return client, true
+}
+
+func (ss *StorageService) targetClients() []*StowClient {
+	clients := make([]*StowClient, 0, len(ss.targets))
+	for _, client := range ss.targets {
+		clients = append(clients, client)
+	}
+	return clients
+}
@@ -0,0 +1,100 @@
+package storagesvc
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestLoadStorageTargets(t *testing.T) {
+	dir := t.TempDir()
+
+	targets, err := LoadStorageTargets(filepath.Join(dir, "missing.yaml"), dir)
+	if err != nil {
+		t.Fatalf("error loading missing storage targets: %v", err)
+	}
+	if len(targets) != 0 {
+		t.Errorf("expected no storage targets, got %v", len(targets))
+	}
+
+	t.Setenv("STORAGE_S3_ACCESS_KEY_ID", "default-key")
+	path := filepath.Join(dir, "targets.yaml")
+	err = os.WriteFile(path, []byte(`targets:
+- name: team-a
+  type: s3
+  bucketName: team-a-bucket
+  subDir: archives
+- name: scratch
+  type: local
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err = LoadStorageTargets(path, dir)
+	if err != nil {
+		t.Fatalf("error loading storage targets: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 storage targets, got %v", len(targets))
+	}
+	s3, ok := targets["team-a"].(s3Storage)
+	if !ok {
+		t.Fatalf("expected s3 storage for team-a, got %T", targets["team-a"])
+	}
+	if s3.getContainerName() != "team-a-bucket" || s3.getSubDir() != "archives" {
+		t.Errorf("unexpected s3 target %s/%s", s3.getContainerName(), s3.getSubDir())
+	}
+	if s3.accessKeyID != "default-key" {
+		t.Errorf("expected default s3 credentials, got %q", s3.accessKeyID)
+	}
+	if name := targets["scratch"].getContainerName(); name != "scratch" {
+		t.Errorf("expected local target container scratch, got %q", name)
+	}
+
+	for _, invalid := range []string{
+		"targets:\n- type: local\n",
+		"targets:\n- name: a\n  type: local\n- name: a\n  type: local\n",
+		"targets:\n- name: a\n  type: s3\n",
+		"targets:\n- name: a\n  type: gcs\n",
+	} {
+		err = os.WriteFile(path, []byte(invalid), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadStorageTargets(path, dir)
+		if err == nil {
+			t.Errorf("expected error for storage targets %q", invalid)
+		}
+	}
+}
+
+func TestClientForRequest(t *testing.T) {
+	defaultClient := &StowClient{}
+	targetClient := &StowClient{}
+	ss := MakeStorageService(loggerfactory.GetLogger(), defaultClient,
+		map[string]*StowClient{"team-a": targetClient}, 8000)
+
+	for _, test := range []struct {
+		url    string
+		client *StowClient
+	}{
+		{"/v1/archive?id=foo", defaultClient},
+		{"/v1/archive?id=foo&target=team-a", targetClient},
+	} {
+		client, err := ss.clientForRequest(httptest.NewRequest("GET", test.url, nil))
+		if err != nil {
+			t.Fatalf("error getting client for %s: %v", test.url, err)
+		}
+		if client != test.client {
+			t.Errorf("wrong storage client for %s", test.url)
+		}
+	}
+
+	_, err := ss.clientForRequest(httptest.NewRequest("GET", "/v1/archive?id=foo&target=team-b", nil))
+	if _, ok := err.(ErrStorageTargetUnknown); !ok {
+		t.Errorf("expected ErrStorageTargetUnknown, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package authtoken authenticates the requests between the builder manager
+// and the builder pods with a shared bearer token.
+package authtoken
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// EnvBuilderAuthToken is the environment variable holding the token of the
+// builder and fetcher containers of the builder pods.
+const EnvBuilderAuthToken = "FISSION_BUILDER_AUTH_TOKEN"
+
+const bearerPrefix = "Bearer "
+
+// Transport adds the bearer token to the requests sent with its base round
+// tripper, http.DefaultTransport by default.
+type Transport struct {
+	Token string
+	Base  http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if len(t.Token) == 0 {
+		return base.RoundTrip(req)
+	}
+	// round trippers must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", bearerPrefix+t.Token)
+	return base.RoundTrip(req)
+}
+
+// Handler rejects the requests without the bearer token with 401, except
+// the requests of the open paths, e.g. the health checks. An empty token
+// accepts all requests.
+func Handler(token string, next http.Handler, openPaths ...string) http.Handler {
+	if len(token) == 0 {
+		return next
+	}
+	open := make(map[string]bool, len(openPaths))
+	for _, path := range openPaths {
+		open[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !open[r.URL.Path] && !valid(token, r.Header.Get("Authorization")) {
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func valid(token, authorization string) bool {
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return false
+	}
+	got := strings.TrimPrefix(authorization, bearerPrefix)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// Generate returns a random token.
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,50 @@
+package authtoken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), "/healthz")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "valid token", path: "/", token: "secret", status: http.StatusOK},
+		{name: "no token", path: "/", status: http.StatusUnauthorized},
+		{name: "wrong token", path: "/", token: "guess", status: http.StatusUnauthorized},
+		{name: "open path", path: "/healthz", status: http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: &Transport{Token: test.token}}
+			resp, err := client.Get(server.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandlerWithoutToken(t *testing.T) {
+	handler := Handler("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected requests accepted without token, got %d", w.Code)
+	}
+}
@@ -37,7 +37,8 @@ func NewBackOff(initialInterval time.Duration, maxInterval time.Duration, multip
 		MaxInterval:     maxInterval,
 		Multiplier:      multiplier,
 		InitialInterval: initialInterval,
-		currentbackoff:  DefaultInitialInterval,
+		MaxCount:        maxCount,
+		currentbackoff:  initialInterval,
 		currentCount:    0,
 	}, nil
 }
@@ -118,3 +119,17 @@ func (backoff *backoff) NextExists() bool {
 	}
 	return true
 }
+
+// RemainingCount returns the number of retries left before NextExists
+// returns false, it doesn't change the current backoff
+func (backoff *backoff) RemainingCount() int {
+	next := *backoff
+	count := 0
+	for {
+		next.GetNext()
+		if !next.NextExists() {
+			return count
+		}
+		count++
+	}
+}
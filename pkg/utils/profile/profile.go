@@ -17,35 +17,75 @@ limitations under the License.
 // To use profile in a go program over http
 // import this package and call ProfileIfEnabled()
 // in your main function.
-// Please set the environment variable PPROF_ENABLE=true to enable/disable it runtime.
-// To customize host and port you can set PPROF_HOST and PPROF_PORT environment variables.
-//	$ PPROF_ENABLE=true PPROF_HOST=localhost PPROF_PORT=6060 go run myprogram.go
+// Please set the environment variable PPROF_ENABLED=true to enable/disable it runtime.
+// The profiles are served on localhost only by default, to customize host and port
+// you can set PPROF_HOST and PPROF_PORT environment variables.
+//	$ PPROF_ENABLED=true PPROF_HOST=localhost PPROF_PORT=6060 go run myprogram.go
+// The mutex profile samples one in PPROF_MUTEX_PROFILE_FRACTION mutex contention
+// events, 5 by default, 0 turns it off.
 
 package profile
 
 import (
 	"context"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"os"
+	"runtime"
+	"strconv"
 
 	"go.uber.org/zap"
 
 	"github.com/fission/fission/pkg/utils/httpserver"
 )
 
+const (
+	defaultHost                 = "localhost"
+	defaultPort                 = "6060"
+	defaultMutexProfileFraction = 5
+)
+
+// Handler returns the handler serving the pprof index, with the goroutine,
+// heap and mutex profiles among others, and the CPU profile and trace. The
+// handlers are on a mux of their own, only the pprof server serves them.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// ProfileIfEnabled serves the pprof handlers on their own port if
+// PPROF_ENABLED is true.
 func ProfileIfEnabled(ctx context.Context, logger *zap.Logger) {
 	enablePprof := os.Getenv("PPROF_ENABLED")
 	if enablePprof != "true" {
 		return
 	}
+	pprofHost := os.Getenv("PPROF_HOST")
+	if pprofHost == "" {
+		pprofHost = defaultHost
+	}
 	pprofPort := os.Getenv("PPROF_PORT")
 	if pprofPort == "" {
-		pprofPort = "6060"
+		pprofPort = defaultPort
 	}
 
-	pprofMux := http.DefaultServeMux
-	http.DefaultServeMux = http.NewServeMux()
+	fraction := defaultMutexProfileFraction
+	if s := os.Getenv("PPROF_MUTEX_PROFILE_FRACTION"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			logger.Warn("invalid mutex profile fraction, using default",
+				zap.String("fraction", s), zap.Int("default", defaultMutexProfileFraction))
+		} else {
+			fraction = n
+		}
+	}
+	runtime.SetMutexProfileFraction(fraction)
 
-	go httpserver.StartServer(ctx, logger, "pprof", pprofPort, pprofMux)
+	go httpserver.StartServer(ctx, logger, "pprof", net.JoinHostPort(pprofHost, pprofPort), Handler())
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package utils
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -181,14 +182,35 @@ func DownloadUrl(ctx context.Context, httpClient *http.Client, url string, local
 		return err
 	}
 	defer resp.Body.Close()
+	return WriteResponseBody(resp, localPath)
+}
+
+// WriteResponseBody writes the body of the response to localPath, gzip
+// encoded bodies are decompressed.
+func WriteResponseBody(resp *http.Response, localPath string) error {
+	// the transport decompresses gzip encoded bodies unless the
+	// client asked for an encoding explicitly
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" && !resp.Uncompressed {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		body = zr
+	}
+	return WriteFile(body, localPath)
+}
 
+// WriteFile writes the content of r to localPath, readable by its owner only.
+func WriteFile(r io.Reader, localPath string) error {
 	w, err := os.Create(localPath)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
 
-	_, err = io.Copy(w, resp.Body)
+	_, err = io.Copy(w, r)
 	if err != nil {
 		return err
 	}